@@ -1,52 +1,215 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
 
+	"instance-manager/internal/daemon"
+	"instance-manager/internal/diag"
+	"instance-manager/internal/leader"
 	"instance-manager/internal/scheduler"
+	"instance-manager/internal/session"
 	"instance-manager/internal/utils"
+	"instance-manager/pkg/apikey"
 	"instance-manager/pkg/aws"
+	"instance-manager/pkg/azure"
 	"instance-manager/pkg/cloud"
 	"instance-manager/pkg/config"
+	"instance-manager/pkg/digitalocean"
+	"instance-manager/pkg/docker"
+	"instance-manager/pkg/gcp"
+	"instance-manager/pkg/hetzner"
+	"instance-manager/pkg/hooks"
+	"instance-manager/pkg/libvirt"
 	"instance-manager/pkg/models"
+	"instance-manager/pkg/notify"
+	"instance-manager/pkg/oci"
+	"instance-manager/pkg/sso"
 	"instance-manager/pkg/storage"
+	"instance-manager/pkg/tracing"
+	"instance-manager/pkg/users"
+	"instance-manager/pkg/vultr"
 	"instance-manager/pkg/webserver"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+// providerChoices lists the --provider values for flag help
+const providerChoices = "aws, gcp, azure, digitalocean, hetzner, vultr, oci, docker, libvirt"
+
+// defaultPruneDays is how long the prune command keeps terminated records
+// when neither the flag nor the config file sets it
+const defaultPruneDays = 30
+
+// daemonStartTimeout is how long service --daemon waits for the detached
+// service to become ready
+const daemonStartTimeout = time.Minute
+
+// webShutdownTimeout is how long the web server waits for the requests in
+// flight to finish when it is stopped
+const webShutdownTimeout = time.Minute
+
+// version is the tool version, set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
 var (
 	instanceType     string
 	duration         string
 	publicKeyPath    string
+	keyName          string
+	passwordStdin    bool
 	availabilityZone string
 	instanceID       string
 	provider         string // Add provider flag
 	verbose          bool
 	logLevel         string
+	storageFile      string
+	storageBackend   string
+	memoryStore      *storage.MemoryStorage // Shared by every openStorage call of the process
+	otelEndpoint     string
+	shutdownTracing  func(context.Context) error
+	dryRun           bool
+	useAWSTime       bool
+	maxClockSkew     time.Duration
+	checkInterval    time.Duration
+	reloadInterval   time.Duration
+	concurrency      int
+	retryAttempts    int
+	instanceTimeout  time.Duration
+	warnLeadTimes    []time.Duration
+	notifyWebhook    string
+	notifyWebURL     string
+	gracePeriod      time.Duration
+	stoppedDays      int
+	warnBefore       time.Duration
+	openPorts        []int64
+	securityGroupID  string
+	sshCIDRs         []string
+	attachGroupIDs   []string
+	dailyBudget      float64
+	restartPolicy    string
+	diagOutput       string
+	sessionID        string
+	assumeYes        bool
+	maxRestarts      int
+	configPath       string
+	regions          []string
+	autoRenewUntil   string
+	autoRenewStep    time.Duration
+	autoRenewMaxAge  time.Duration
+	snapshotVolume   bool
+	snapshotTimeout  time.Duration
+	forceOverwrite   bool
+	callTimeout      time.Duration
+	account          string
+	spot             bool
+	spotMaxPrice     string
+	osName           string
+	extraVolumes     []string
+	vpcID            string
+	subnetID         string
+	elasticIP        bool
+	tagSpecs         []string
+	instanceName     string
+	snapshotOnExpiry bool
+	imageOnExpiry    bool
+	keepWhenIdle     bool
+	officeHours      string
+	clearHours       bool
+	clearCron        bool
+	stopCron         string
+	startCron        string
+	idleWindow       time.Duration
+	idleCPUPercent   float64
+	idleNetworkBytes float64
+	fromImage        string
+	expiryAction     string
+	metadataTokens   string
+	metadataHopLimit int32
+	metadataEndpoint string
+	imageName        string
+	leaderBackend    string
+	leaderLockFile   string
+	orphanInterval   time.Duration
+	adoptOrphans     bool
+	terminateOrphans bool
+	pruneDays        int
+	archivePruned    bool
+	pauseReason      string
+	daemonize        bool
+	pidFile          string
+	logFile          string
+	stopWait         time.Duration
+	showHistory      bool
+	listArchived     bool
+	backupOut        string
+	backupDir        string
+	backupInterval   time.Duration
+	backupKeep       int
+	listStates       []string
+	listOwner        string
+	expiresWithin    time.Duration
+	exportFormat     string
+	exportOut        string
 )
 
 func main() {
 	var rootCmd = &cobra.Command{
-		Use:   "instance-manager",
-		Short: "AWS EC2 instance management tool",
-		Long:  "A tool for creating and managing AWS EC2 instances with automatic lifecycle management",
-		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		Use:     "instance-manager",
+		Version: version,
+		Short:   "AWS EC2 instance management tool",
+		Long:    "A tool for creating and managing AWS EC2 instances with automatic lifecycle management",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			if verbose {
 				log.SetOutput(os.Stdout)
 			}
+
+			shutdown, err := tracing.Setup(cmd.Context(), otelEndpoint)
+			if err != nil {
+				return fmt.Errorf("failed to set up tracing: %w", err)
+			}
+			shutdownTracing = shutdown
+
+			// Every --instance-id flag also accepts an instance name
+			if flag := cmd.Flags().Lookup("instance-id"); flag != nil && flag.Value.String() != "" {
+				store, err := openStorage()
+				if err != nil {
+					return err
+				}
+				id, err := resolveInstanceID(store, flag.Value.String())
+				if err != nil {
+					return err
+				}
+				if err := flag.Value.Set(id); err != nil {
+					return err
+				}
+			}
+			return nil
 		},
 	}
 
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose logging")
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Log level (debug, info, warn, error)")
+	rootCmd.PersistentFlags().StringVar(&otelEndpoint, "otel-endpoint", "", "OTLP/HTTP endpoint for exporting traces (e.g., localhost:4318 or https://otel.example.com:4318); tracing is disabled when empty")
+	rootCmd.PersistentFlags().StringVar(&storageFile, "storage-file", "", "Path to the instance storage file (use a .gz extension for compression)")
+	rootCmd.PersistentFlags().StringVar(&storageBackend, "storage", "", "Storage backend: file, dynamodb, postgres, redis, etcd, s3 or memory (overrides storage.backend; memory keeps records only until the process exits)")
+	rootCmd.PersistentFlags().StringVar(&account, "account", "", "Named AWS account from the config file to act in (default: the default account)")
+	rootCmd.PersistentFlags().DurationVar(&callTimeout, "timeout", 2*time.Minute, "Timeout for each cloud provider call (0 disables it)")
+	rootCmd.PersistentFlags().IntVar(&retryAttempts, "retry-attempts", 4, "How often a throttled or temporarily failing cloud provider call is tried in total (overrides retry.max_attempts; 1 disables retries)")
 
 	// Create command
 	var createCmd = &cobra.Command{
@@ -58,12 +221,40 @@ func main() {
 
 	createCmd.Flags().StringVarP(&instanceType, "instance-type", "t", "t2.nano", "EC2 instance type")
 	createCmd.Flags().StringVarP(&duration, "duration", "d", "1h", "Instance runtime duration (e.g., 1h, 30m, 2h30m)")
-	createCmd.Flags().StringVarP(&publicKeyPath, "public-key", "k", "", "Path to SSH public key file (required)")
+	createCmd.Flags().StringVarP(&publicKeyPath, "public-key", "k", "", "Path to SSH public key file (required unless --key-name is set)")
+	createCmd.Flags().StringVar(&keyName, "key-name", "", "Name of an existing key pair to use instead of importing --public-key")
 	createCmd.Flags().StringVarP(&availabilityZone, "availability-zone", "z", "us-east-1a", "AWS availability zone")
-	createCmd.Flags().StringVarP(&provider, "provider", "P", "aws", "Cloud provider (aws, gcp)")
-	if err := createCmd.MarkFlagRequired("public-key"); err != nil {
-		log.Fatal(err)
-	}
+	createCmd.Flags().StringVarP(&provider, "provider", "P", "aws", "Cloud provider ("+providerChoices+")")
+	createCmd.Flags().Int64SliceVar(&openPorts, "open-port", nil, "Inbound TCP port to open to the internet (repeatable, default 22)")
+	createCmd.Flags().StringVar(&securityGroupID, "security-group-id", "", "Existing security group to use instead of the managed one")
+	createCmd.Flags().StringSliceVar(&sshCIDRs, "ssh-cidr", nil, "CIDR block allowed to reach SSH (repeatable, default 0.0.0.0/0, AWS only)")
+	createCmd.Flags().StringSliceVar(&attachGroupIDs, "attach-security-group-id", nil, "Existing security group to attach alongside the managed one (repeatable, AWS only)")
+	createCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the security group rules that would be applied without creating anything")
+	createCmd.Flags().StringVar(&expiryAction, "expiry-action", models.ExpiryActionStop, "What the service does to the instance at expiry: stop, hibernate to keep its memory (AWS only), or terminate (defaults to defaults.expiry_action)")
+	createCmd.Flags().StringVar(&restartPolicy, "restart-policy", models.RestartPolicyOnExtend, "Whether the service restarts the instance when found stopped before expiry (always, never, on-extend)")
+	createCmd.Flags().StringSliceVar(&regions, "regions", nil, "Launch one instance in each of these regions (e.g. us-east-1,eu-west-1)")
+	createCmd.Flags().StringVarP(&instanceName, "name", "n", "", "Name for the instance; other commands accept it in place of the ID")
+	createCmd.Flags().StringVar(&sessionID, "session", "", "Session identifier to group related instances")
+	createCmd.Flags().StringVar(&osName, "os", "", "Operating system to launch ("+strings.Join(aws.OSNames(), ", ")+"; default "+aws.DefaultOS+", AWS only)")
+	createCmd.Flags().StringVar(&fromImage, "from-image", "", "AMI to launch instead of the latest image of --os, e.g. one made by 'image' (AWS only)")
+	createCmd.Flags().StringVar(&vpcID, "vpc-id", "", "VPC to launch into instead of the default VPC (AWS only)")
+	createCmd.Flags().StringVar(&subnetID, "subnet-id", "", "Subnet to launch into; its AZ overrides --availability-zone (AWS only)")
+	createCmd.Flags().StringArrayVar(&extraVolumes, "extra-volume", nil, "Extra EBS volume to attach, e.g. size=100,type=gp3,device=/dev/xvdf (repeatable, AWS only)")
+	createCmd.Flags().StringArrayVar(&tagSpecs, "tag", nil, "Tag to add to the instance as key=value (repeatable, AWS only)")
+	createCmd.Flags().StringVar(&metadataTokens, "metadata-http-tokens", "", "IMDS token mode: required (IMDSv2 only) or optional (default from config, else required; AWS only)")
+	createCmd.Flags().Int32Var(&metadataHopLimit, "metadata-hop-limit", 0, "IMDS PUT response hop limit, 1-64; 2 lets containers reach IMDSv2 (AWS only)")
+	createCmd.Flags().StringVar(&metadataEndpoint, "metadata-endpoint", "", "Whether the IMDS endpoint is enabled or disabled (default from config, else enabled; AWS only)")
+	createCmd.Flags().BoolVar(&elasticIP, "elastic-ip", false, "Allocate an Elastic IP that survives stop/start cycles; released on terminate (AWS only)")
+	createCmd.Flags().BoolVar(&snapshotOnExpiry, "snapshot-on-expiry", false, "Snapshot the instance's EBS volumes before the service stops it at expiry (AWS only)")
+	createCmd.Flags().BoolVar(&imageOnExpiry, "image-on-expiry", false, "Create an AMI of the instance before the service stops it at expiry (AWS only)")
+	createCmd.Flags().BoolVar(&keepWhenIdle, "keep-when-idle", false, "Exempt the instance from the service's idle policy")
+	createCmd.Flags().StringVar(&stopCron, "stop-cron", "", "Cron expression at which the service stops the instance, e.g. \"0 20 * * *\" (UTC unless prefixed with CRON_TZ=<zone>)")
+	createCmd.Flags().StringVar(&startCron, "start-cron", "", "Cron expression at which the service starts the instance, e.g. \"0 8 * * 1-5\"")
+	createCmd.Flags().StringVar(&officeHours, "office-hours", "", "Only run the instance in this weekly window, e.g. \"08:00-19:00 Mon-Fri Europe/Berlin\" (the service stops and starts it)")
+	createCmd.Flags().BoolVar(&spot, "spot", false, "Launch a spot instance (AWS only)")
+	createCmd.Flags().StringVar(&spotMaxPrice, "spot-max-price", "", "Maximum hourly spot price in USD (default: the on-demand price)")
+	createCmd.MarkFlagsMutuallyExclusive("open-port", "security-group-id")
+	createCmd.MarkFlagsMutuallyExclusive("ssh-cidr", "security-group-id")
 
 	// Status command
 	var statusCmd = &cobra.Command{
@@ -73,7 +264,8 @@ func main() {
 		RunE:  runStatus,
 	}
 
-	statusCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID to check (required)")
+	statusCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID or name to check (required)")
+	statusCmd.Flags().StringVarP(&provider, "provider", "P", "aws", "Cloud provider of an instance missing from storage ("+providerChoices+")")
 	if err := statusCmd.MarkFlagRequired("instance-id"); err != nil {
 		log.Fatal(err)
 	}
@@ -86,6 +278,15 @@ func main() {
 		RunE:  runList,
 	}
 
+	listCmd.Flags().StringVar(&sessionID, "session", "", "Only list instances in this session")
+	listCmd.Flags().StringArrayVar(&tagSpecs, "tag", nil, "Only list instances with this key=value tag (repeatable; all must match)")
+	listCmd.Flags().StringSliceVar(&listStates, "state", nil, "Only list instances in these states, e.g. running,stopped")
+	listCmd.Flags().StringVar(&listOwner, "owner", "", "Only list instances created by this user")
+	listCmd.Flags().DurationVar(&expiresWithin, "expires-within", 0, "Only list instances expiring within this duration, e.g. 2h")
+	listCmd.Flags().StringSliceVar(&regions, "regions", nil, "Regions to list (default: the configured region and every region with stored instances)")
+	listCmd.Flags().StringVarP(&provider, "provider", "P", "aws", "Cloud provider whose instances are listed ("+providerChoices+")")
+	listCmd.Flags().BoolVar(&listArchived, "archived", false, "List the archived records of terminated instances from storage instead")
+
 	// Stop command
 	var stopCmd = &cobra.Command{
 		Use:   "stop",
@@ -94,7 +295,8 @@ func main() {
 		RunE:  runStop,
 	}
 
-	stopCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID to stop (required)")
+	stopCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID or name to stop (required)")
+	stopCmd.Flags().StringVarP(&provider, "provider", "P", "aws", "Cloud provider of an instance missing from storage ("+providerChoices+")")
 	if err := stopCmd.MarkFlagRequired("instance-id"); err != nil {
 		log.Fatal(err)
 	}
@@ -107,17 +309,18 @@ func main() {
 		RunE:  runShow,
 	}
 
-	showCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID to show (optional, shows all if not provided)")
+	showCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID or name to show (optional, shows all if not provided)")
+	showCmd.Flags().BoolVar(&showHistory, "history", false, "Also show the lifecycle history of each instance")
 
 	// Sync command
 	var syncCmd = &cobra.Command{
 		Use:   "sync",
-		Short: "Sync stored data with AWS",
-		Long:  "Sync stored instance data with current AWS state (updates IPs, states, etc.)",
+		Short: "Sync stored data with the cloud providers",
+		Long:  "Sync stored instance data with the current state reported by each instance's provider (updates IPs, states, etc.)",
 		RunE:  runSync,
 	}
 
-	syncCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID to sync (optional, syncs all if not provided)")
+	syncCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID or name to sync (optional, syncs all if not provided)")
 
 	// Extend command
 	var extendCmd = &cobra.Command{
@@ -127,7 +330,7 @@ func main() {
 		RunE:  runExtend,
 	}
 
-	extendCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID to extend (required)")
+	extendCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID or name to extend (required)")
 	extendCmd.Flags().StringVarP(&duration, "duration", "d", "", "Additional duration to extend (e.g., 1h, 30m, 2h30m) (required)")
 	if err := extendCmd.MarkFlagRequired("instance-id"); err != nil {
 		log.Fatal(err)
@@ -136,6 +339,54 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Office hours command
+	var officeHoursCmd = &cobra.Command{
+		Use:   "office-hours [window]",
+		Short: "Set or clear the office hours of an instance",
+		Long: "Attach a weekly window such as \"08:00-19:00 Mon-Fri Europe/Berlin\" to an instance. The service stops the instance outside the window " +
+			"and starts it when the window opens, within the instance's TTL. Without a window the current office hours are shown.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: runOfficeHours,
+	}
+
+	officeHoursCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID or name (required)")
+	officeHoursCmd.Flags().BoolVar(&clearHours, "clear", false, "Remove the office hours so the instance runs around the clock")
+	if err := officeHoursCmd.MarkFlagRequired("instance-id"); err != nil {
+		log.Fatal(err)
+	}
+
+	// Cron command
+	var cronCmd = &cobra.Command{
+		Use:   "cron",
+		Short: "Set or clear the stop and start schedules of an instance",
+		Long: "Store cron expressions at which the service stops and starts an instance within its TTL, e.g. --stop \"0 20 * * *\" --start \"0 8 * * 1-5\". " +
+			"Expressions are evaluated in UTC unless prefixed with CRON_TZ=<zone>. Without flags the schedules and their next fire times are shown.",
+		RunE: runCron,
+	}
+
+	cronCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID or name (required)")
+	cronCmd.Flags().StringVar(&stopCron, "stop", "", "Cron expression at which the instance is stopped (empty removes it)")
+	cronCmd.Flags().StringVar(&startCron, "start", "", "Cron expression at which the instance is started (empty removes it)")
+	cronCmd.Flags().BoolVar(&clearCron, "clear", false, "Remove both schedules")
+	if err := cronCmd.MarkFlagRequired("instance-id"); err != nil {
+		log.Fatal(err)
+	}
+
+	// Expiry action command
+	var expiryActionCmd = &cobra.Command{
+		Use:   "expiry-action [stop|hibernate|terminate]",
+		Short: "Set what the service does to an instance at expiry",
+		Long: "Change what the service does to an instance when it expires: stop it, so it restarts when extended; hibernate it (only for AWS instances " +
+			"launched with --expiry-action hibernate); or terminate it and archive its record. Without an action the current one is shown.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: runExpiryAction,
+	}
+
+	expiryActionCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID or name (required)")
+	if err := expiryActionCmd.MarkFlagRequired("instance-id"); err != nil {
+		log.Fatal(err)
+	}
+
 	// Service command (enhanced scheduler)
 	var serviceCmd = &cobra.Command{
 		Use:   "service",
@@ -144,6 +395,77 @@ func main() {
 		RunE:  runService,
 	}
 
+	serviceCmd.Flags().StringVarP(&provider, "provider", "P", "aws", "Cloud provider to validate at startup; each stored instance is managed by its own provider ("+providerChoices+")")
+	serviceCmd.Flags().BoolVar(&useAWSTime, "aws-time", false, "Use the AWS server time instead of the local clock for expiry decisions")
+	serviceCmd.Flags().StringVar(&autoRenewUntil, "auto-renew-until", "", "Extend expiring instances instead of stopping them until this local time of day (HH:MM)")
+	serviceCmd.Flags().DurationVar(&autoRenewStep, "auto-renew-increment", time.Hour, "How far --auto-renew-until extends the TTL each time")
+	serviceCmd.Flags().DurationVar(&autoRenewMaxAge, "auto-renew-max-age", 12*time.Hour, "Never auto-renew instances older than this (0 means no limit)")
+	serviceCmd.Flags().IntVar(&maxRestarts, "max-restarts", 0, "Stop restarting an instance after this many restarts and mark it unhealthy (0 means no limit)")
+	serviceCmd.Flags().Float64Var(&dailyBudget, "daily-budget", 0, "Stop the soonest-expiring instances when projected daily spend (USD) exceeds this (0 disables)")
+	serviceCmd.Flags().DurationVar(&maxClockSkew, "max-clock-skew", 30*time.Second, "Log a warning when the local clock differs from AWS time by more than this")
+	serviceCmd.Flags().DurationVar(&checkInterval, "interval", 30*time.Second, "How often instances are checked for expiry (overrides scheduler.interval in the config file)")
+	serviceCmd.Flags().DurationVar(&reloadInterval, "reload-interval", 10*time.Second, "Longest the service works from cached storage before reading it again (overrides scheduler.reload_interval)")
+	serviceCmd.Flags().IntVar(&concurrency, "concurrency", 10, "How many instances are checked at once (overrides scheduler.concurrency)")
+	serviceCmd.Flags().DurationVar(&instanceTimeout, "instance-timeout", 2*time.Minute, "Longest a check of a single instance may take before it is abandoned until the next pass (overrides scheduler.instance_timeout)")
+	serviceCmd.Flags().DurationSliceVar(&warnLeadTimes, "warn-before", []time.Duration{30 * time.Minute, 10 * time.Minute, time.Minute}, "Lead times before expiry at which running instances are warned about (overrides scheduler.warn_before; 0 disables)")
+	serviceCmd.Flags().StringVar(&notifyWebhook, "notify-webhook", "", "URL that receives notifications such as expiry warnings as JSON (overrides NOTIFY_WEBHOOK_URL)")
+	serviceCmd.Flags().StringVar(&notifyWebURL, "web-url", "", "Base URL of the web UI, linked from expiry warnings (overrides NOTIFY_WEB_URL)")
+	serviceCmd.Flags().DurationVar(&idleWindow, "idle-window", 0, "Stop running instances that stay idle this long, even before they expire (overrides scheduler.idle.window; 0 disables; AWS only)")
+	serviceCmd.Flags().Float64Var(&idleCPUPercent, "idle-cpu-percent", 5, "CPU utilization below which an instance is idle (overrides scheduler.idle.cpu_percent)")
+	serviceCmd.Flags().Float64Var(&idleNetworkBytes, "idle-network-bytes", 10*1024, "Network bytes per second, in and out, below which an instance is idle (overrides scheduler.idle.network_bytes_per_second; 0 ignores the network)")
+	serviceCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Log the stops, starts and other actions the service would take without taking them")
+	serviceCmd.Flags().StringVar(&leaderBackend, "leader", "", "Elect one of several service replicas to manage instances: file, consul or etcd (overrides leader.backend; empty runs without an election)")
+	serviceCmd.Flags().StringVar(&leaderLockFile, "lock-file", "", "File locked by the file leader backend (overrides leader.lock_file; defaults to the storage file with a .lock suffix)")
+	serviceCmd.Flags().DurationVar(&orphanInterval, "orphan-interval", 15*time.Minute, "How often the provider is listed for managed instances missing from storage (overrides scheduler.orphans.interval; 0 disables)")
+	serviceCmd.Flags().BoolVar(&adoptOrphans, "adopt-orphans", false, "Save managed instances missing from storage to it instead of only reporting them (overrides scheduler.orphans.adopt)")
+	serviceCmd.Flags().IntVar(&stoppedDays, "terminate-stopped-after-days", 0, "Terminate instances stopped for more than this many days and archive their records (overrides scheduler.terminate_stopped_after_days; 0 disables)")
+	serviceCmd.Flags().IntVar(&pruneDays, "prune-terminated-after-days", 0, "Remove the records of instances terminated more than this many days ago (overrides scheduler.prune.terminated_after_days; 0 keeps them)")
+	serviceCmd.Flags().BoolVar(&archivePruned, "archive-pruned", false, "Move pruned records to the archive instead of deleting them (overrides scheduler.prune.archive)")
+	serviceCmd.Flags().DurationVar(&backupInterval, "backup-interval", 0, "How often a backup of storage is written (overrides scheduler.backup.interval; 0 disables)")
+	serviceCmd.Flags().StringVar(&backupDir, "backup-dir", "", "Directory the backups are written to (overrides scheduler.backup.dir; defaults to the backups directory next to the storage file)")
+	serviceCmd.Flags().IntVar(&backupKeep, "backup-keep", 7, "How many backups are kept; older ones are removed (overrides scheduler.backup.keep; 0 keeps all)")
+	serviceCmd.Flags().BoolVar(&daemonize, "daemon", false, "Run the service in the background, detached from the terminal, once it is ready")
+	serviceCmd.Flags().StringVar(&logFile, "log-file", "", "Append the service's logs to this file (with --daemon, defaults to the storage file with a .log suffix)")
+	serviceCmd.PersistentFlags().StringVar(&pidFile, "pid-file", "", "File holding the PID of the running service (with --daemon, service stop and service status, defaults to the storage file with a .pid suffix)")
+
+	var serviceStatusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show the report of the service's last pass",
+		Long:  "Show when the service last checked instances, how long it took, the actions it took and the errors it hit, to tell whether it is working",
+		RunE:  runServiceStatus,
+	}
+	serviceCmd.AddCommand(serviceStatusCmd)
+
+	var serviceStopCmd = &cobra.Command{
+		Use:   "stop",
+		Short: "Stop the service running in the background",
+		Long:  "Stop the service started with service --daemon, or any service writing a PID file, and wait for its pass in progress to finish",
+		Args:  cobra.NoArgs,
+		RunE:  runServiceStop,
+	}
+	serviceStopCmd.Flags().DurationVar(&stopWait, "wait", 5*time.Minute, "Longest to wait for the service to exit")
+	serviceCmd.AddCommand(serviceStopCmd)
+
+	var servicePauseCmd = &cobra.Command{
+		Use:   "pause",
+		Short: "Pause the service's lifecycle actions",
+		Long:  "Stop the running service from stopping, starting or terminating instances, for example during an incident or maintenance, without stopping the service",
+		Args:  cobra.NoArgs,
+		RunE:  runServicePause,
+	}
+	servicePauseCmd.Flags().StringVar(&pauseReason, "reason", "", "Why the service is paused, shown by service status")
+	servicePauseCmd.Flags().StringVarP(&duration, "duration", "d", "", "Resume by itself after this long (e.g., 30m, 2h); empty pauses until service resume")
+	serviceCmd.AddCommand(servicePauseCmd)
+
+	var serviceResumeCmd = &cobra.Command{
+		Use:   "resume",
+		Short: "Resume the service's lifecycle actions",
+		Long:  "Let the service manage instances again after service pause",
+		Args:  cobra.NoArgs,
+		RunE:  runServiceResume,
+	}
+	serviceCmd.AddCommand(serviceResumeCmd)
+
 	// Web command
 	var webPort int
 	var webCmd = &cobra.Command{
@@ -153,592 +475,3750 @@ func main() {
 		RunE:  runWeb,
 	}
 
+	webCmd.Flags().StringVarP(&provider, "provider", "P", "aws", "Cloud provider the web UI creates instances with ("+providerChoices+")")
 	webCmd.Flags().IntVarP(&webPort, "port", "p", 8080, "Port to run the web server on")
+	webCmd.Flags().String("static-dir", "", "Directory of web UI files served in place of the built-in ones at the same paths, such as css/style.css")
 
 	// Terminate command
 	var terminateCmd = &cobra.Command{
 		Use:   "terminate",
-		Short: "Terminate an instance (permanently deletes it)",
-		Long:  "Terminate a specific instance. This action cannot be undone.",
+		Short: "Terminate an instance and archive its record",
+		Long:  "Terminate a specific instance. This action cannot be undone; the instance's record moves to the archive, where list --archived and show find it.",
 		RunE:  runTerminate,
 	}
 	var terminateInstanceID string
-	terminateCmd.Flags().StringVarP(&terminateInstanceID, "instance-id", "i", "", "Instance ID to terminate (required)")
+	terminateCmd.Flags().StringVarP(&terminateInstanceID, "instance-id", "i", "", "Instance ID or name to terminate (required)")
+	terminateCmd.Flags().StringVarP(&provider, "provider", "P", "aws", "Cloud provider of an instance missing from storage ("+providerChoices+")")
+	terminateCmd.Flags().BoolVar(&snapshotVolume, "snapshot-volume", false, "Snapshot the root EBS volume and wait for it to complete before terminating")
+	terminateCmd.Flags().DurationVar(&snapshotTimeout, "snapshot-timeout", 10*time.Minute, "How long to wait for the root volume snapshot to complete")
 	if err := terminateCmd.MarkFlagRequired("instance-id"); err != nil {
 		log.Fatal(err)
 	}
 
-	rootCmd.AddCommand(createCmd)
-	rootCmd.AddCommand(statusCmd)
-	rootCmd.AddCommand(listCmd)
-	rootCmd.AddCommand(stopCmd)
-	rootCmd.AddCommand(showCmd)
-	rootCmd.AddCommand(syncCmd)
-	rootCmd.AddCommand(extendCmd)
-	rootCmd.AddCommand(serviceCmd)
-	rootCmd.AddCommand(webCmd)
-	rootCmd.AddCommand(terminateCmd)
-
-	if err := rootCmd.Execute(); err != nil {
-		log.Fatal(err)
+	// Terminate session command
+	var terminateSessionCmd = &cobra.Command{
+		Use:   "terminate-session <session>",
+		Short: "Terminate every instance in a session",
+		Long:  "Terminate all managed instances tagged with the given session after confirmation and archive their records. This action cannot be undone.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runTerminateSession,
 	}
-}
 
-func runCreate(cmd *cobra.Command, args []string) error {
-	// Load configuration
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+	terminateSessionCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Skip the confirmation prompt")
+	terminateSessionCmd.Flags().StringVarP(&provider, "provider", "P", "aws", "Cloud provider whose session instances are terminated ("+providerChoices+")")
+
+	// Retag command
+	var retagCmd = &cobra.Command{
+		Use:   "retag",
+		Short: "Backfill metadata tags on managed instances",
+		Long:  "Apply any missing metadata tags to managed instances based on their stored data",
+		RunE:  runRetag,
 	}
 
-	// Validate inputs
-	if err := config.ValidatePublicKeyPath(publicKeyPath); err != nil {
-		return fmt.Errorf("invalid public key: %w", err)
+	retagCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID or name to retag (optional, retags all if not provided)")
+	retagCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the tags that would be applied without changing anything")
+
+	// Schedule preview command
+	var schedulePreviewCmd = &cobra.Command{
+		Use:   "schedule-preview",
+		Short: "Preview upcoming scheduler actions",
+		Long:  "Show the next lifecycle action the background service will take for each instance and when",
+		RunE:  runSchedulePreview,
 	}
 
-	if err := utils.ValidateInstanceType(instanceType); err != nil {
-		return fmt.Errorf("invalid instance type: %w", err)
+	schedulePreviewCmd.Flags().DurationVar(&gracePeriod, "grace-period", 0, "Grace period after expiry before instances are stopped (defaults to scheduler.grace_period)")
+	schedulePreviewCmd.Flags().DurationVar(&warnBefore, "warn-before", 0, "Lead time before expiry at which a warning is issued (0 disables)")
+
+	// Security group preview command
+	var sgPreviewCmd = &cobra.Command{
+		Use:   "sg-preview",
+		Short: "Preview the security group rules for a new instance",
+		Long:  "Resolve and print the ingress rules a create with the same flags would apply, without creating anything",
+		RunE:  runSGPreview,
 	}
 
-	if err := utils.ValidateAvailabilityZone(availabilityZone); err != nil {
-		return fmt.Errorf("invalid availability zone: %w", err)
+	sgPreviewCmd.Flags().Int64SliceVar(&openPorts, "open-port", nil, "Inbound TCP port to open to the internet (repeatable, default 22)")
+	sgPreviewCmd.Flags().StringVar(&securityGroupID, "security-group-id", "", "Existing security group to use instead of the managed one")
+	sgPreviewCmd.Flags().StringSliceVar(&sshCIDRs, "ssh-cidr", nil, "CIDR block allowed to reach SSH (repeatable, default 0.0.0.0/0, AWS only)")
+	sgPreviewCmd.Flags().StringSliceVar(&attachGroupIDs, "attach-security-group-id", nil, "Existing security group to attach alongside the managed one (repeatable, AWS only)")
+	sgPreviewCmd.MarkFlagsMutuallyExclusive("open-port", "security-group-id")
+	sgPreviewCmd.MarkFlagsMutuallyExclusive("ssh-cidr", "security-group-id")
+
+	// Image command
+	var imageCmd = &cobra.Command{
+		Use:   "image",
+		Short: "Create an AMI backup of an instance",
+		Long:  "Create an AMI of the instance and its EBS volumes without rebooting it, so the environment can be recreated with 'create --from-image'",
+		RunE:  runImage,
 	}
 
-	parsedDuration, err := utils.ParseDuration(duration)
-	if err != nil {
-		return fmt.Errorf("invalid duration: %w", err)
+	imageCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID or name to create an image of (required)")
+	imageCmd.Flags().StringVar(&imageName, "image-name", "", "Name of the AMI (default instance-manager-<instance-id>-<timestamp>)")
+	imageCmd.Flags().StringVarP(&provider, "provider", "P", "aws", "Cloud provider of an instance missing from storage ("+providerChoices+")")
+	if err := imageCmd.MarkFlagRequired("instance-id"); err != nil {
+		log.Fatal(err)
 	}
 
-	// Create provider based on flag
-	var cloudProvider cloud.CloudProvider
-	switch provider {
-	case "aws":
-		cloudProvider, err = aws.NewProvider(cfg.AWS.Region, cfg.AWS.AccessKey, cfg.AWS.SecretKey)
-		if err != nil {
-			return fmt.Errorf("failed to create AWS provider: %w", err)
-		}
-	// case "gcp":
-	// 	cloudProvider, err = gcp.NewProvider(...)
-	// 	if err != nil {
-	// 		return fmt.Errorf("failed to create GCP provider: %w", err)
-	// 	}
-	default:
-		return fmt.Errorf("unsupported provider: %s", provider)
+	// Orphans command
+	var orphansCmd = &cobra.Command{
+		Use:   "orphans",
+		Short: "Find managed instances missing from storage",
+		Long:  "List the instances tagged ManagedBy=instance-manager at the provider that have no record in storage, e.g. after the storage file was lost, and adopt them into storage or terminate them",
+		RunE:  runOrphans,
 	}
 
-	// Validate credentials
-	if err := cloudProvider.ValidateCredentials(); err != nil {
-		return fmt.Errorf("failed to validate AWS credentials: %w", err)
+	orphansCmd.Flags().StringVarP(&provider, "provider", "P", "aws", "Cloud provider whose instances are checked ("+providerChoices+")")
+	orphansCmd.Flags().StringSliceVar(&regions, "regions", nil, "Regions to check (default: the configured region and every region with stored instances)")
+	orphansCmd.Flags().BoolVar(&adoptOrphans, "adopt", false, "Save the orphans to storage, so they are managed like instances created here")
+	orphansCmd.Flags().BoolVar(&terminateOrphans, "terminate", false, "Terminate the orphans after confirmation")
+	orphansCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Skip the confirmation prompt of --terminate")
+	orphansCmd.MarkFlagsMutuallyExclusive("adopt", "terminate")
+
+	// Snapshots commands
+	var snapshotsCmd = &cobra.Command{
+		Use:   "snapshots",
+		Short: "Manage the EBS snapshots taken of instances",
 	}
 
-	// Create instance configuration
-	instanceConfig := models.InstanceConfig{
-		InstanceType:     instanceType,
-		Duration:         parsedDuration,
-		PublicKeyPath:    publicKeyPath,
-		AvailabilityZone: availabilityZone,
-		Region:           cfg.AWS.Region,
+	var snapshotsListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List recorded snapshots",
+		Long:  "List the snapshots taken before termination or at expiry, including those of instances that no longer exist",
+		RunE:  runSnapshotsList,
 	}
 
-	fmt.Printf("Creating instance with configuration:\n")
-	fmt.Printf("  Instance Type: %s\n", instanceConfig.InstanceType)
-	fmt.Printf("  Duration: %s\n", utils.FormatDuration(instanceConfig.Duration))
-	fmt.Printf("  Public Key: %s\n", instanceConfig.PublicKeyPath)
-	fmt.Printf("  Availability Zone: %s\n", instanceConfig.AvailabilityZone)
-	fmt.Printf("\nCreating instance...\n")
+	snapshotsListCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID or name whose snapshots to list (optional, lists all if not provided)")
 
-	// Create instance
-	instance, err := cloudProvider.CreateInstance(instanceConfig)
-	if err != nil {
-		return fmt.Errorf("failed to create instance: %w", err)
+	var snapshotsDeleteCmd = &cobra.Command{
+		Use:   "delete <snapshot-id>...",
+		Short: "Delete recorded snapshots",
+		Long:  "Delete the snapshots from AWS and remove their records. This action cannot be undone.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  runSnapshotsDelete,
 	}
+	snapshotsCmd.AddCommand(snapshotsListCmd)
+	snapshotsCmd.AddCommand(snapshotsDeleteCmd)
 
-	// Save instance to storage
-	storage := storage.NewFileStorage("")
-	if err := storage.SaveInstance(instance); err != nil {
-		log.Printf("Warning: failed to save instance to storage: %v", err)
+	// Archived command
+	var archivedCmd = &cobra.Command{
+		Use:   "archived",
+		Short: "List archived instance records",
+		Long:  "List the records of terminated instances: those terminated with terminate or terminate-session, those the service terminated, such as instances stopped longer than scheduler.terminate_stopped_after_days, and the pruned records of terminated instances",
+		RunE:  runArchived,
 	}
 
-	fmt.Printf("\nInstance created successfully!\n")
-	fmt.Printf("  Instance ID: %s\n", instance.ID)
-	fmt.Printf("  State: %s\n", instance.State)
-	fmt.Printf("  Expires at: %s\n", instance.ExpiresAt.Format(time.RFC3339))
-	fmt.Printf("\nUse 'instance-manager status --instance-id %s' to check status\n", instance.ID)
+	archivedCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "Instance ID or name whose archived record to show (optional, lists all if not provided)")
 
-	return nil
-}
+	// Prune command
+	var pruneCmd = &cobra.Command{
+		Use:   "prune",
+		Short: "Remove the records of terminated instances",
+		Long:  "Remove the records of instances terminated more than the given number of days ago from storage, or move them to the archive",
+		RunE:  runPrune,
+	}
 
-func runStatus(cmd *cobra.Command, args []string) error {
-	// Load configuration
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+	pruneCmd.Flags().IntVar(&pruneDays, "older-than-days", defaultPruneDays, "Remove records of instances terminated more than this many days ago (defaults to scheduler.prune.terminated_after_days when set)")
+	pruneCmd.Flags().BoolVar(&archivePruned, "archive", false, "Move the records to the archive instead of deleting them (defaults to scheduler.prune.archive)")
+	pruneCmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the records that would be removed without removing them")
+
+	// Backup command
+	var backupCmd = &cobra.Command{
+		Use:   "backup",
+		Short: "Write a backup of storage to a file",
+		Long:  "Write the instance records, archive, snapshot and image records of storage to a gzip-compressed tar archive, encrypted with the storage encryption key when one is set",
+		RunE:  runBackup,
 	}
 
-	// Create AWS provider
-	provider, err := aws.NewProvider(cfg.AWS.Region, cfg.AWS.AccessKey, cfg.AWS.SecretKey)
-	if err != nil {
-		return fmt.Errorf("failed to create AWS provider: %w", err)
+	backupCmd.Flags().StringVarP(&backupOut, "out", "o", "", "File to write the backup to (default: instance-manager-backup-<time>.tar.gz)")
+	backupCmd.Flags().BoolVar(&forceOverwrite, "force", false, "Overwrite an existing file")
+
+	// Restore command
+	var restoreCmd = &cobra.Command{
+		Use:   "restore <file>",
+		Short: "Restore storage from a backup",
+		Long:  "Write the records of a backup written by backup or by the service to storage, replacing the records with the same IDs. The scheduler's pause and last report are not restored.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runRestore,
 	}
 
-	// Get instance status
-	status, err := provider.GetInstanceStatus(instanceID)
-	if err != nil {
-		return fmt.Errorf("failed to get instance status: %w", err)
+	restoreCmd.Flags().BoolVar(&forceOverwrite, "force", false, "Restore into storage that already holds instances")
+
+	// Export command
+	var exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Export instance records as JSON or CSV",
+		Long:  "Write the stored instance records to a file or stdout, as JSON holding every field or as CSV with one row per instance for spreadsheets and reporting tools",
+		RunE:  runExport,
 	}
 
-	fmt.Printf("Instance Status:\n")
-	fmt.Printf("  ID: %s\n", status.ID)
-	fmt.Printf("  State: %s\n", status.State)
-	fmt.Printf("  Ready: %t\n", status.Ready)
+	exportCmd.Flags().StringVarP(&exportOut, "out", "o", "", "File to write the records to (default: stdout)")
+	exportCmd.Flags().StringVar(&exportFormat, "format", "", "Format of the records, json or csv (default: csv for a .csv file, otherwise json)")
+	exportCmd.Flags().BoolVar(&forceOverwrite, "force", false, "Overwrite an existing file")
+	exportCmd.Flags().StringSliceVar(&listStates, "state", nil, "Only export instances in these states, e.g. running,stopped")
+	exportCmd.Flags().StringVar(&listOwner, "owner", "", "Only export instances created by this user")
+	exportCmd.Flags().StringArrayVar(&tagSpecs, "tag", nil, "Only export instances with this key=value tag (repeatable; all must match)")
 
-	if status.PublicIP != "" {
-		fmt.Printf("  Public IP: %s\n", status.PublicIP)
-		fmt.Printf("  SSH Command: ssh %s@%s\n", status.Username, status.PublicIP)
+	// Import command
+	var importCmd = &cobra.Command{
+		Use:   "import <file>",
+		Short: "Import instance records from JSON or CSV",
+		Long:  "Add the instance records of a file written by export, or a CSV file with an id column, to storage. Records whose IDs are already stored are skipped unless --force is given.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runImport,
 	}
 
-	if status.PrivateIP != "" {
-		fmt.Printf("  Private IP: %s\n", status.PrivateIP)
+	importCmd.Flags().StringVar(&exportFormat, "format", "", "Format of the file, json or csv (default: csv for a .csv file, otherwise json)")
+	importCmd.Flags().BoolVar(&forceOverwrite, "force", false, "Replace stored records with the same IDs")
+
+	// Config commands
+	var configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Manage the configuration file",
 	}
 
-	return nil
-}
+	var configInitCmd = &cobra.Command{
+		Use:   "init",
+		Short: "Write a starter configuration file",
+		Long:  "Write a commented configuration file listing every supported key with its default value",
+		RunE:  runConfigInit,
+	}
 
-func runList(cmd *cobra.Command, args []string) error {
-	// Load configuration
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+	configInitCmd.Flags().StringVar(&configPath, "path", "", "Where to write the config file (default $INSTANCE_MANAGER_CONFIG or ~/.instance-manager/config.yaml)")
+	configInitCmd.Flags().BoolVar(&forceOverwrite, "force", false, "Overwrite an existing config file")
+	configCmd.AddCommand(configInitCmd)
+
+	// API key commands
+	var keysCmd = &cobra.Command{
+		Use:   "keys",
+		Short: "Manage the API keys of the web server",
+		Long:  "Manage the API keys that requests to the web server's API must carry. The key file keeps only hashes of the keys, so a key is shown once, when it is created.",
 	}
 
-	// Create AWS provider
-	provider, err := aws.NewProvider(cfg.AWS.Region, cfg.AWS.AccessKey, cfg.AWS.SecretKey)
-	if err != nil {
-		return fmt.Errorf("failed to create AWS provider: %w", err)
+	var keysCreateCmd = &cobra.Command{
+		Use:   "create <name>",
+		Short: "Generate a new API key",
+		Long:  "Generate a key named after the user or script that will use it, and print it. Changes made with the key are recorded under its name.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runKeysCreate,
 	}
 
-	// List instances
-	instances, err := provider.ListInstances()
-	if err != nil {
-		return fmt.Errorf("failed to list instances: %w", err)
+	var keysListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the API keys",
+		Args:  cobra.NoArgs,
+		RunE:  runKeysList,
 	}
 
-	if len(instances) == 0 {
-		fmt.Println("No managed instances found.")
-		return nil
+	var keysRevokeCmd = &cobra.Command{
+		Use:   "revoke <name>",
+		Short: "Revoke an API key",
+		Long:  "Remove a key from the key file. Running web servers refuse it from their next request.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runKeysRevoke,
 	}
+	keysCmd.AddCommand(keysCreateCmd)
+	keysCmd.AddCommand(keysListCmd)
+	keysCmd.AddCommand(keysRevokeCmd)
 
-	fmt.Printf("Managed Instances:\n\n")
-	for _, instance := range instances {
-		fmt.Printf("Instance ID: %s\n", instance.ID)
-		fmt.Printf("  Type: %s\n", instance.InstanceType)
-		fmt.Printf("  State: %s\n", instance.State)
-		fmt.Printf("  Launch Time: %s\n", instance.LaunchTime.Format(time.RFC3339))
-		fmt.Printf("  Duration: %s\n", utils.FormatDuration(instance.Duration))
-		fmt.Printf("  Expires At: %s\n", instance.ExpiresAt.Format(time.RFC3339))
-		fmt.Printf("  Availability Zone: %s\n", instance.AvailabilityZone)
+	// User account commands
+	var usersCmd = &cobra.Command{
+		Use:   "users",
+		Short: "Manage the users who sign in to the web server",
+		Long:  "Manage the users who sign in on the web server's login page. Once the user file exists, the web UI asks for a user name and password and records changes under the user's name.",
+	}
 
-		if instance.PublicIP != "" {
-			fmt.Printf("  Public IP: %s\n", instance.PublicIP)
-			fmt.Printf("  SSH Command: ssh %s@%s\n", instance.Username, instance.PublicIP)
-		}
+	var usersAddCmd = &cobra.Command{
+		Use:   "add <name>",
+		Short: "Add a user",
+		Long:  "Add a user with a generated password, printed once, or with the password read from standard input with --password-stdin.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runUsersAdd,
+	}
 
-		if instance.IsExpired() {
-			fmt.Printf("  Status: EXPIRED\n")
-		} else {
-			timeLeft := time.Until(instance.ExpiresAt)
-			fmt.Printf("  Time Remaining: %s\n", utils.FormatDuration(timeLeft))
-		}
+	var usersPasswdCmd = &cobra.Command{
+		Use:   "passwd <name>",
+		Short: "Change the password of a user",
+		Long:  "Replace the password of a user with a generated one, or with one read from standard input with --password-stdin. The user's sessions end.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runUsersPasswd,
+	}
 
-		fmt.Println()
+	var usersListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the users",
+		Args:  cobra.NoArgs,
+		RunE:  runUsersList,
 	}
 
-	return nil
-}
+	var usersRemoveCmd = &cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove a user",
+		Long:  "Remove a user from the user file. Running web servers end the user's sessions from their next request.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runUsersRemove,
+	}
+	usersAddCmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "Read the password from standard input")
+	usersPasswdCmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "Read the password from standard input")
+	usersCmd.AddCommand(usersAddCmd)
+	usersCmd.AddCommand(usersPasswdCmd)
+	usersCmd.AddCommand(usersListCmd)
+	usersCmd.AddCommand(usersRemoveCmd)
 
-func runStop(cmd *cobra.Command, args []string) error {
-	// Load configuration
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+	// Diagnostics command
+	var diagCmd = &cobra.Command{
+		Use:   "diag",
+		Short: "Write a diagnostics bundle for bug reports",
+		Long:  "Collect the effective configuration, stored instances, recent events, version and a credentials check into a JSON file with secrets redacted",
+		RunE:  runDiag,
 	}
 
-	// Create AWS provider
-	provider, err := aws.NewProvider(cfg.AWS.Region, cfg.AWS.AccessKey, cfg.AWS.SecretKey)
+	diagCmd.Flags().StringVarP(&diagOutput, "output", "o", "", "Path of the bundle to write (default instance-manager-diag-<timestamp>.json)")
+
+	rootCmd.AddCommand(createCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(stopCmd)
+	rootCmd.AddCommand(showCmd)
+	rootCmd.AddCommand(syncCmd)
+	rootCmd.AddCommand(extendCmd)
+	rootCmd.AddCommand(officeHoursCmd)
+	rootCmd.AddCommand(expiryActionCmd)
+	rootCmd.AddCommand(cronCmd)
+	rootCmd.AddCommand(serviceCmd)
+	rootCmd.AddCommand(webCmd)
+	rootCmd.AddCommand(terminateCmd)
+	rootCmd.AddCommand(terminateSessionCmd)
+	rootCmd.AddCommand(retagCmd)
+	rootCmd.AddCommand(schedulePreviewCmd)
+	rootCmd.AddCommand(sgPreviewCmd)
+	rootCmd.AddCommand(diagCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(keysCmd)
+	rootCmd.AddCommand(usersCmd)
+	rootCmd.AddCommand(snapshotsCmd)
+	rootCmd.AddCommand(archivedCmd)
+	rootCmd.AddCommand(pruneCmd)
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(imageCmd)
+	rootCmd.AddCommand(orphansCmd)
+
+	// Provider calls are cancelled on Ctrl+C instead of running to completion
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	// Flushed here rather than in a post-run hook, which cobra skips when a
+	// command fails, so the spans of failed runs are exported too
+	flushTracing()
 	if err != nil {
-		return fmt.Errorf("failed to create AWS provider: %w", err)
+		log.Fatal(err)
 	}
+}
 
-	fmt.Printf("Stopping instance %s...\n", instanceID)
+// flushTracing exports the spans still buffered when tracing is set up
+func flushTracing() {
+	if shutdownTracing == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Warning: failed to flush traces: %v", err)
+	}
+}
 
-	// Terminate instance
-	if err := provider.TerminateInstance(instanceID); err != nil {
-		return fmt.Errorf("failed to terminate instance: %w", err)
+// callContext returns the context for a single cloud provider call, cancelled
+// on interrupt or when --timeout elapses
+func callContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	if callTimeout <= 0 {
+		return context.WithCancel(cmd.Context())
 	}
+	return context.WithTimeout(cmd.Context(), callTimeout)
+}
 
-	// Update storage
-	storage := storage.NewFileStorage("")
-	instance, err := storage.GetInstance(instanceID)
-	if err == nil {
-		instance.State = "terminated"
-		if err := storage.UpdateInstance(instance); err != nil {
-			log.Printf("Warning: failed to update instance state in storage: %v", err)
+// cliRetry is the retry policy of provider calls, loaded on first use so
+// commands that make none don't need a valid config file
+var cliRetry *cloud.RetryPolicy
+
+// retryPolicy returns the retry policy from the config file and
+// --retry-attempts
+func retryPolicy(cmd *cobra.Command) (cloud.RetryPolicy, error) {
+	if cliRetry != nil {
+		return *cliRetry, nil
+	}
+	retryConfig, err := config.LoadRetryConfig()
+	if err != nil {
+		return cloud.RetryPolicy{}, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if cmd.Flags().Changed("retry-attempts") {
+		if retryAttempts <= 0 {
+			return cloud.RetryPolicy{}, fmt.Errorf("invalid --retry-attempts: %d", retryAttempts)
 		}
+		retryConfig.MaxAttempts = retryAttempts
+	}
+	cliRetry = &cloud.RetryPolicy{
+		MaxAttempts: retryConfig.MaxAttempts,
+		BaseDelay:   retryConfig.BaseDelay,
+		MaxDelay:    retryConfig.MaxDelay,
 	}
+	return *cliRetry, nil
+}
 
-	fmt.Printf("Instance %s has been stopped.\n", instanceID)
-	return nil
+// retryCall makes an idempotent provider call, retrying throttling and other
+// transient failures with each attempt limited by --timeout
+func retryCall[T any](cmd *cobra.Command, op func(ctx context.Context) (T, error)) (T, error) {
+	policy, err := retryPolicy(cmd)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return cloud.Retry(cmd.Context(), policy, func(context.Context) (T, error) {
+		ctx, cancel := callContext(cmd)
+		defer cancel()
+		return op(ctx)
+	})
 }
 
-func runShow(cmd *cobra.Command, args []string) error {
-	// Create storage
-	storage := storage.NewFileStorage("")
+// retryDo is retryCall for calls that only return an error
+func retryDo(cmd *cobra.Command, op func(ctx context.Context) error) error {
+	_, err := retryCall(cmd, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, op(ctx)
+	})
+	return err
+}
 
-	if instanceID == "" {
-		// Show all instances
-		instances, err := storage.ListInstances()
-		if err != nil {
-			return fmt.Errorf("failed to load instances: %w", err)
-		}
+func runCreate(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := config.LoadConfigForProvider(provider)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
 
-		if len(instances) == 0 {
-			fmt.Println("No instances found in storage.")
-			fmt.Println("Create an instance first using: instance-manager create --public-key ~/.ssh/id_rsa.pub")
-			return nil
+	// The instance type and zone defaults are EC2 names
+	switch provider {
+	case "gcp":
+		if !cmd.Flags().Changed("instance-type") {
+			instanceType = gcp.DefaultMachineType
 		}
-
-		fmt.Printf("=== All Stored Instances (%d total) ===\n\n", len(instances))
-		for i, instance := range instances {
-			fmt.Printf("Instance %d:\n", i+1)
-			printDetailedInstanceInfo(instance)
-			fmt.Println()
+		if !cmd.Flags().Changed("availability-zone") {
+			availabilityZone = cfg.GCP.Zone
 		}
-	} else {
-		// Show specific instance
-		instance, err := storage.GetInstance(instanceID)
-		if err != nil {
-			return fmt.Errorf("instance %s not found: %w", instanceID, err)
+	case "azure":
+		if !cmd.Flags().Changed("instance-type") {
+			instanceType = azure.DefaultVMSize
+		}
+		availabilityZone = cfg.Azure.Location
+	case "digitalocean":
+		if !cmd.Flags().Changed("instance-type") {
+			instanceType = digitalocean.DefaultSize
+		}
+		availabilityZone = cfg.DigitalOcean.Region
+	case "hetzner":
+		if !cmd.Flags().Changed("instance-type") {
+			instanceType = hetzner.DefaultServerType
+		}
+		availabilityZone = cfg.Hetzner.Location
+	case "vultr":
+		if !cmd.Flags().Changed("instance-type") {
+			instanceType = vultr.DefaultPlan
+		}
+		availabilityZone = cfg.Vultr.Region
+	case "oci":
+		if !cmd.Flags().Changed("instance-type") {
+			instanceType = oci.DefaultShape
+		}
+		// An empty domain lets the provider pick the region's first one
+		if !cmd.Flags().Changed("availability-zone") {
+			availabilityZone = cfg.OCI.AvailabilityDomain
+		}
+	case "docker":
+		if !cmd.Flags().Changed("instance-type") {
+			instanceType = docker.DefaultInstanceType
 		}
+		availabilityZone = "local"
+	case "libvirt":
+		if !cmd.Flags().Changed("instance-type") {
+			instanceType = libvirt.DefaultInstanceType
+		}
+		availabilityZone = cfg.Libvirt.Pool
+	}
 
-		fmt.Printf("=== Instance Communication Details ===\n\n")
-		printDetailedInstanceInfo(instance)
+	// Validate inputs
+	if keyName == "" {
+		if publicKeyPath == "" {
+			return fmt.Errorf("either --public-key or --key-name is required")
+		}
+		if err := config.ValidatePublicKeyPath(publicKeyPath); err != nil {
+			return fmt.Errorf("invalid public key: %w", err)
+		}
 	}
-	return nil
-}
 
-func printDetailedInstanceInfo(instance *models.Instance) {
-	fmt.Printf("🆔 Instance ID: %s\n", instance.ID)
-	fmt.Printf("💻 Instance Type: %s\n", instance.InstanceType)
-	fmt.Printf("📍 Availability Zone: %s\n", instance.AvailabilityZone)
-	fmt.Printf("🔑 Key Name: %s\n", instance.KeyName)
-	fmt.Printf("👤 Username: %s\n", instance.Username)
+	if provider == "aws" {
+		if err := utils.ValidateInstanceType(instanceType); err != nil {
+			return fmt.Errorf("invalid instance type: %w", err)
+		}
 
-	fmt.Printf("\n🌐 Network & Communication Details:\n")
-	if instance.PublicIP != "" {
-		fmt.Printf("   📡 Public IP: %s\n", instance.PublicIP)
-		fmt.Printf("   🔗 SSH Command: ssh -i ~/.ssh/id_rsa %s@%s\n", instance.Username, instance.PublicIP)
-		fmt.Printf("   🌍 Web Access: http://%s (if web server running)\n", instance.PublicIP)
-	} else {
-		fmt.Printf("   📡 Public IP: Not assigned yet (instance may be starting)\n")
-		fmt.Printf("   💡 Tip: Check status again in a few minutes\n")
+		if err := utils.ValidateAvailabilityZone(availabilityZone); err != nil {
+			return fmt.Errorf("invalid availability zone: %w", err)
+		}
 	}
 
-	if instance.PrivateIP != "" {
-		fmt.Printf("   🏠 Private IP: %s\n", instance.PrivateIP)
+	if err := utils.ValidateInstanceFamily(instanceType, cfg.AllowedInstanceFamilies); err != nil {
+		return fmt.Errorf("invalid instance type: %w", err)
+	}
+
+	parsedDuration, err := utils.ParseDuration(duration)
+	if err != nil {
+		return fmt.Errorf("invalid duration: %w", err)
+	}
+	if cfg.MaxLifetime > 0 && parsedDuration > cfg.MaxLifetime {
+		return fmt.Errorf("invalid duration: %s exceeds the maximum lifetime of %s", utils.FormatDuration(parsedDuration), utils.FormatDuration(cfg.MaxLifetime))
+	}
+
+	if err := models.ValidateRestartPolicy(restartPolicy); err != nil {
+		return err
+	}
+	if !cmd.Flags().Changed("expiry-action") && cfg.DefaultValues.ExpiryAction != "" {
+		expiryAction = cfg.DefaultValues.ExpiryAction
+	}
+	if err := models.ValidateExpiryAction(expiryAction); err != nil {
+		return err
+	}
+	if expiryAction == models.ExpiryActionHibernate && provider != "aws" {
+		return fmt.Errorf("--expiry-action hibernate is not supported for provider %s (defaults.expiry_action applies when the flag is not given)", provider)
+	}
+
+	if sessionID != "" {
+		if err := utils.ValidateSession(sessionID); err != nil {
+			return fmt.Errorf("invalid session: %w", err)
+		}
+	}
+
+	if officeHours != "" {
+		if _, err := models.ParseOfficeHours(officeHours); err != nil {
+			return err
+		}
+	}
+	for _, expr := range []string{stopCron, startCron} {
+		if expr == "" {
+			continue
+		}
+		if _, err := models.ParseCron(expr); err != nil {
+			return err
+		}
+	}
+
+	if instanceName != "" {
+		if err := utils.ValidateInstanceName(instanceName); err != nil {
+			return fmt.Errorf("invalid name: %w", err)
+		}
+		store, err := openStorage()
+		if err != nil {
+			return err
+		}
+		existing, err := store.FindByName(instanceName)
+		if err != nil {
+			return fmt.Errorf("failed to check for an instance named %s: %w", instanceName, err)
+		}
+		if len(existing) > 0 {
+			return fmt.Errorf("an instance named %s already exists (%s)", instanceName, existing[0].ID)
+		}
+	}
+
+	if osName != "" {
+		if provider != "aws" {
+			return fmt.Errorf("--os is not supported for provider %s", provider)
+		}
+		if _, err := aws.LookupOS(osName); err != nil {
+			return err
+		}
+	}
+	if gpus, ok := aws.InstanceGPUs(instanceType); ok && provider == "aws" {
+		if image, err := aws.LookupOS(osName); err == nil && !image.GPU {
+			fmt.Printf("Note: %s has %d x %s but the selected OS ships without the NVIDIA driver; use --os dlami-ubuntu-22.04 or dlami-amazon-linux-2023 for a driver-ready image\n",
+				instanceType, gpus.Count, gpus.Model)
+		}
+	}
+
+	if len(extraVolumes) > 0 && provider != "aws" {
+		return fmt.Errorf("--extra-volume is not supported for provider %s", provider)
+	}
+	volumes, err := models.ParseVolumeSpecs(extraVolumes)
+	if err != nil {
+		return fmt.Errorf("invalid extra volume: %w", err)
+	}
+
+	if vpcID != "" && provider != "aws" {
+		return fmt.Errorf("--vpc-id is not supported for provider %s", provider)
+	}
+	if subnetID != "" && provider != "aws" {
+		return fmt.Errorf("--subnet-id is not supported for provider %s", provider)
+	}
+
+	if len(sshCIDRs) > 0 && provider != "aws" {
+		return fmt.Errorf("--ssh-cidr is not supported for provider %s", provider)
+	}
+	if len(attachGroupIDs) > 0 && provider != "aws" {
+		return fmt.Errorf("--attach-security-group-id is not supported for provider %s", provider)
+	}
+
+	if len(tagSpecs) > 0 && provider != "aws" {
+		return fmt.Errorf("--tag is not supported for provider %s", provider)
+	}
+	tags, err := models.ParseTags(tagSpecs)
+	if err != nil {
+		return fmt.Errorf("invalid tag: %w", err)
+	}
+
+	if snapshotOnExpiry && provider != "aws" {
+		return fmt.Errorf("--snapshot-on-expiry is not supported for provider %s", provider)
+	}
+	// Flags override the configured metadata options field by field
+	metadata := cfg.AWS.Metadata
+	for _, flag := range []string{"metadata-http-tokens", "metadata-hop-limit", "metadata-endpoint"} {
+		if cmd.Flags().Changed(flag) && provider != "aws" {
+			return fmt.Errorf("--%s is not supported for provider %s", flag, provider)
+		}
+	}
+	if cmd.Flags().Changed("metadata-http-tokens") {
+		metadata.HTTPTokens = metadataTokens
+	}
+	if cmd.Flags().Changed("metadata-hop-limit") {
+		if metadataHopLimit < 1 {
+			return fmt.Errorf("invalid --metadata-hop-limit %d (must be 1-%d)", metadataHopLimit, models.MaxMetadataHopLimit)
+		}
+		metadata.HopLimit = metadataHopLimit
+	}
+	if cmd.Flags().Changed("metadata-endpoint") {
+		metadata.Endpoint = metadataEndpoint
+	}
+	if err := metadata.Validate(); err != nil {
+		return err
+	}
+
+	if imageOnExpiry && provider != "aws" {
+		return fmt.Errorf("--image-on-expiry is not supported for provider %s", provider)
+	}
+	if fromImage != "" {
+		if provider != "aws" {
+			return fmt.Errorf("--from-image is not supported for provider %s", provider)
+		}
+		if err := utils.ValidateImageID(fromImage); err != nil {
+			return err
+		}
+	}
+
+	if elasticIP && provider != "aws" {
+		return fmt.Errorf("--elastic-ip is not supported for provider %s", provider)
+	}
+	if spot && provider != "aws" {
+		return fmt.Errorf("--spot is not supported for provider %s", provider)
+	}
+	if spotMaxPrice != "" && !spot {
+		return fmt.Errorf("--spot-max-price requires --spot")
+	}
+	if err := utils.ValidateSpotPrice(spotMaxPrice); err != nil {
+		return err
+	}
+
+	// Create instance configuration
+	instanceConfig := models.InstanceConfig{
+		InstanceType:          instanceType,
+		Duration:              parsedDuration,
+		PublicKeyPath:         publicKeyPath,
+		KeyName:               keyName,
+		AvailabilityZone:      availabilityZone,
+		Region:                cfg.AWS.Region,
+		OpenPorts:             openPorts,
+		SecurityGroupID:       securityGroupID,
+		SSHCIDRs:              sshCIDRs,
+		RestartPolicy:         restartPolicy,
+		Session:               sessionID,
+		Spot:                  spot,
+		SpotMaxPrice:          spotMaxPrice,
+		OS:                    osName,
+		ExtraVolumes:          volumes,
+		VPCID:                 vpcID,
+		SubnetID:              subnetID,
+		ElasticIP:             elasticIP,
+		Tags:                  tags,
+		Name:                  instanceName,
+		SnapshotOnExpiry:      snapshotOnExpiry,
+		ImageOnExpiry:         imageOnExpiry,
+		ImageID:               fromImage,
+		ExpiryAction:          expiryAction,
+		Metadata:              metadata,
+		ExtraSecurityGroupIDs: attachGroupIDs,
+	}
+
+	if len(regions) > 0 {
+		if provider != "aws" {
+			return fmt.Errorf("--regions is not supported for provider %s", provider)
+		}
+		if dryRun {
+			return fmt.Errorf("--dry-run cannot be combined with --regions")
+		}
+		if vpcID != "" || subnetID != "" {
+			return fmt.Errorf("--vpc-id and --subnet-id cannot be combined with --regions")
+		}
+		if fromImage != "" {
+			return fmt.Errorf("--from-image cannot be combined with --regions; AMIs belong to a single region")
+		}
+		if instanceName != "" {
+			return fmt.Errorf("--name cannot be combined with --regions")
+		}
+		return createInRegions(cmd, cfg, instanceConfig)
+	}
+
+	// Create provider based on flag
+	cloudProvider, err := newRegistry().GetAccount(provider, account)
+	if err != nil {
+		return err
+	}
+
+	// Validate credentials
+	if err := retryDo(cmd, cloudProvider.ValidateCredentials); err != nil {
+		return fmt.Errorf("failed to validate %s credentials: %w", strings.ToUpper(provider), err)
+	}
+
+	if dryRun {
+		awsProvider, ok := cloudProvider.(*aws.Provider)
+		if !ok {
+			return fmt.Errorf("--dry-run is not supported for provider %s", provider)
+		}
+		plan, err := retryCall(cmd, func(ctx context.Context) (*aws.SecurityGroupPlan, error) {
+			return awsProvider.PreviewSecurityGroup(ctx, instanceConfig)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to preview security group: %w", err)
+		}
+		fmt.Printf("Dry run: no instance will be created.\n\n")
+		printSecurityGroupPlan(plan)
+		return nil
+	}
+
+	fmt.Printf("Creating instance with configuration:\n")
+	if instanceConfig.Name != "" {
+		fmt.Printf("  Name: %s\n", instanceConfig.Name)
+	}
+	fmt.Printf("  Instance Type: %s\n", instanceConfig.InstanceType)
+	if gpus, ok := aws.InstanceGPUs(instanceConfig.InstanceType); ok {
+		fmt.Printf("  GPUs: %d x %s\n", gpus.Count, gpus.Model)
+	}
+	if instanceConfig.OS != "" {
+		fmt.Printf("  OS: %s\n", instanceConfig.OS)
+	}
+	if instanceConfig.ImageID != "" {
+		fmt.Printf("  Image: %s\n", instanceConfig.ImageID)
+	}
+	fmt.Printf("  Duration: %s\n", utils.FormatDuration(instanceConfig.Duration))
+	if instanceConfig.KeyName != "" {
+		fmt.Printf("  Key Pair: %s\n", instanceConfig.KeyName)
+	} else {
+		fmt.Printf("  Public Key: %s\n", instanceConfig.PublicKeyPath)
+	}
+	if instanceConfig.SubnetID != "" {
+		fmt.Printf("  Subnet: %s\n", instanceConfig.SubnetID)
+	} else {
+		fmt.Printf("  Availability Zone: %s\n", instanceConfig.AvailabilityZone)
+	}
+	if instanceConfig.VPCID != "" {
+		fmt.Printf("  VPC: %s\n", instanceConfig.VPCID)
+	}
+	for _, volume := range instanceConfig.ExtraVolumes {
+		fmt.Printf("  Extra Volume: %d GiB %s at %s\n", volume.SizeGiB, volume.Type, volume.Device)
+	}
+	fmt.Printf("  Restart Policy: %s\n", instanceConfig.RestartPolicy)
+	fmt.Printf("  Expiry Action: %s\n", instanceConfig.ExpiryAction)
+	if instanceConfig.Session != "" {
+		fmt.Printf("  Session: %s\n", instanceConfig.Session)
+	}
+	if len(instanceConfig.Tags) > 0 {
+		fmt.Printf("  Tags: %s\n", formatTags(instanceConfig.Tags))
+	}
+	if instanceConfig.Spot {
+		maxPrice := "on-demand price"
+		if instanceConfig.SpotMaxPrice != "" {
+			maxPrice = "$" + instanceConfig.SpotMaxPrice + "/h"
+		}
+		fmt.Printf("  Spot: yes (max %s)\n", maxPrice)
+	}
+	if instanceConfig.ElasticIP {
+		fmt.Printf("  Elastic IP: yes\n")
+	}
+	if instanceConfig.SnapshotOnExpiry {
+		fmt.Printf("  Snapshot on Expiry: yes\n")
+	}
+	if instanceConfig.ImageOnExpiry {
+		fmt.Printf("  Image on Expiry: yes\n")
+	}
+	if keepWhenIdle {
+		fmt.Printf("  Keep When Idle: yes\n")
+	}
+	if officeHours != "" {
+		fmt.Printf("  Office Hours: %s\n", officeHours)
+	}
+	if stopCron != "" {
+		fmt.Printf("  Stop Schedule: %s\n", stopCron)
+	}
+	if startCron != "" {
+		fmt.Printf("  Start Schedule: %s\n", startCron)
+	}
+	fmt.Printf("\nCreating instance...\n")
+
+	// Create instance
+	ctx, cancel := callContext(cmd)
+	defer cancel()
+	instance, err := cloudProvider.CreateInstance(ctx, instanceConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create instance: %w", err)
+	}
+	if instance.Provider == "" {
+		instance.Provider = provider
+	}
+	instance.Account = account
+	instance.KeepWhenIdle = keepWhenIdle
+	instance.ExpiryAction = instanceConfig.ExpiryAction
+	instance.OfficeHours = officeHours
+	instance.StopCron = stopCron
+	instance.StartCron = startCron
+	if instance.Name == "" {
+		instance.Name = instanceConfig.Name
+	}
+	instance.Owner = cliActor()
+	instance.RecordEvent(time.Now(), models.EventCreated, instance.Owner, "")
+
+	// Save instance to storage
+	store, err := openStorage()
+	if err == nil {
+		err = store.SaveInstance(instance)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to save instance to storage: %v", err)
+	}
+
+	fmt.Printf("\nInstance created successfully!\n")
+	fmt.Printf("  Instance ID: %s\n", instance.ID)
+	fmt.Printf("  State: %s\n", instance.State)
+	if instance.SubnetID != "" {
+		fmt.Printf("  Network: %s / %s (%s)\n", instance.VPCID, instance.SubnetID, instance.AvailabilityZone)
+	}
+	if instance.SpotRequestID != "" {
+		fmt.Printf("  Spot Request: %s\n", instance.SpotRequestID)
+	}
+	if instance.ElasticIPAllocationID != "" {
+		fmt.Printf("  Elastic IP: %s (%s)\n", instance.PublicIP, instance.ElasticIPAllocationID)
+	}
+	fmt.Printf("  Expires at: %s\n", instance.ExpiresAt.Format(time.RFC3339))
+	fmt.Printf("\nUse 'instance-manager status --instance-id %s' to check status\n", instance.ID)
+
+	return nil
+}
+
+// providerConstructors build each supported provider from its configuration
+var providerConstructors = map[string]func(cfg *config.Config) (cloud.CloudProvider, error){
+	"aws": func(cfg *config.Config) (cloud.CloudProvider, error) {
+		return newAWSProvider(cfg.AWS)
+	},
+	"gcp": func(cfg *config.Config) (cloud.CloudProvider, error) {
+		return gcp.NewProvider(cfg.GCP.Project, cfg.GCP.Zone, cfg.GCP.CredentialsFile)
+	},
+	"azure": func(cfg *config.Config) (cloud.CloudProvider, error) {
+		return azure.NewProvider(cfg.Azure.SubscriptionID, cfg.Azure.ResourceGroup, cfg.Azure.Location)
+	},
+	"digitalocean": func(cfg *config.Config) (cloud.CloudProvider, error) {
+		return digitalocean.NewProvider(cfg.DigitalOcean.Token, cfg.DigitalOcean.Region)
+	},
+	"hetzner": func(cfg *config.Config) (cloud.CloudProvider, error) {
+		return hetzner.NewProvider(cfg.Hetzner.Token, cfg.Hetzner.Location)
+	},
+	"vultr": func(cfg *config.Config) (cloud.CloudProvider, error) {
+		return vultr.NewProvider(cfg.Vultr.APIKey, cfg.Vultr.Region)
+	},
+	"oci": func(cfg *config.Config) (cloud.CloudProvider, error) {
+		return oci.NewProvider(cfg.OCI.CompartmentID, cfg.OCI.Region, cfg.OCI.AvailabilityDomain, cfg.OCI.ConfigFile, cfg.OCI.Profile)
+	},
+	"docker": func(cfg *config.Config) (cloud.CloudProvider, error) {
+		return docker.NewProvider(cfg.Docker.Host, cfg.Docker.Image)
+	},
+	"libvirt": func(cfg *config.Config) (cloud.CloudProvider, error) {
+		return libvirt.NewProvider(cfg.Libvirt.URI, libvirt.Settings{
+			Pool:      cfg.Libvirt.Pool,
+			BaseImage: cfg.Libvirt.BaseImage,
+			Network:   cfg.Libvirt.Network,
+			Username:  cfg.Libvirt.Username,
+		})
+	},
+}
+
+// newRegistry returns a registry of every supported provider. Each provider
+// loads the configuration it requires when first used. Instances stored
+// without a provider name predate multi-provider support and belong to AWS.
+func newRegistry() *cloud.Registry {
+	registry := cloud.NewRegistry("aws")
+	for name, construct := range providerConstructors {
+		name, construct := name, construct
+		registry.Register(name, func() (cloud.CloudProvider, error) {
+			cfg, err := config.LoadConfigForProvider(name)
+			if err != nil {
+				return nil, err
+			}
+			return construct(cfg)
+		})
+	}
+	registry.RegisterAccounts("aws", func(account string) (cloud.CloudProvider, error) {
+		cfg, err := config.LoadConfigForProvider("aws")
+		if err != nil {
+			return nil, err
+		}
+		accountCfg, err := cfg.AWS.ForAccount(account)
+		if err != nil {
+			return nil, err
+		}
+		return newAWSProvider(accountCfg)
+	})
+	return registry
+}
+
+// newAWSProvider creates an AWS provider from an account's configuration
+func newAWSProvider(cfg config.AWSConfig) (cloud.CloudProvider, error) {
+	return aws.NewProviderWithOptions(aws.Options{
+		Region:          cfg.Region,
+		AccessKey:       cfg.AccessKey,
+		SecretKey:       cfg.SecretKey,
+		Profile:         cfg.Profile,
+		RoleARN:         cfg.RoleARN,
+		ExternalID:      cfg.ExternalID,
+		RoleSessionName: cfg.RoleSessionName,
+		Endpoint:        cfg.Endpoint,
+	})
+}
+
+// openStorage opens the storage backend selected by storage.backend in the
+// config file: --storage-file by default, a DynamoDB table, a PostgreSQL
+// database, a Redis server or an S3 object
+func openStorage() (storage.Storage, error) {
+	storageConfig, err := config.LoadStorageConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if storageBackend != "" {
+		storageConfig.Backend = storageBackend
+	}
+
+	switch storageConfig.Backend {
+	case "", "file":
+		key, err := storageEncryptionKey(storageConfig)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return storage.NewFileStorage(storageFile), nil
+		}
+		return storage.NewEncryptedFileStorage(storageFile, key)
+	case "dynamodb":
+		dynamo := storageConfig.DynamoDB
+		store, err := storage.NewDynamoDBStorage(context.Background(), storage.DynamoDBOptions{
+			Table:       dynamo.Table,
+			Region:      dynamo.Region,
+			Endpoint:    dynamo.Endpoint,
+			Profile:     dynamo.Profile,
+			CreateTable: dynamo.CreateTable,
+			Retention:   dynamo.Retention,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the DynamoDB storage: %w", err)
+		}
+		return store, nil
+	case "postgres":
+		if storageConfig.PostgresURL == "" {
+			return nil, errors.New("storage.postgres.url (POSTGRES_URL) is required for the postgres storage backend")
+		}
+		store, err := storage.NewPostgresStorage(context.Background(), storageConfig.PostgresURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the PostgreSQL storage: %w", err)
+		}
+		return store, nil
+	case "redis":
+		if storageConfig.Redis.URL == "" {
+			return nil, errors.New("storage.redis.url (REDIS_URL) is required for the redis storage backend")
+		}
+		store, err := storage.NewRedisStorage(context.Background(), storageConfig.Redis.URL, storageConfig.Redis.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the Redis storage: %w", err)
+		}
+		return store, nil
+	case "etcd":
+		etcd := storageConfig.Etcd
+		store, err := storage.NewEtcdStorage(context.Background(), storage.EtcdOptions{
+			Endpoints: etcd.Endpoints,
+			Prefix:    etcd.Prefix,
+			Username:  etcd.Username,
+			Password:  etcd.Password,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the etcd storage: %w", err)
+		}
+		return store, nil
+	case "s3":
+		s3 := storageConfig.S3
+		store, err := storage.NewS3Storage(context.Background(), storage.S3Options{
+			Bucket:   s3.Bucket,
+			Key:      s3.Key,
+			Region:   s3.Region,
+			Endpoint: s3.Endpoint,
+			Profile:  s3.Profile,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the S3 storage: %w", err)
+		}
+		return store, nil
+	case "memory":
+		if memoryStore == nil {
+			memoryStore = storage.NewMemoryStorage()
+		}
+		return memoryStore, nil
+	default:
+		return nil, fmt.Errorf("invalid storage backend: %s (must be file, dynamodb, postgres, redis, etcd, s3 or memory)", storageConfig.Backend)
+	}
+}
+
+// storageEncryptionKey returns the key to encrypt the storage file with,
+// from STORAGE_ENCRYPTION_KEY or the key file, or nil when none is set
+func storageEncryptionKey(storageConfig config.StorageConfig) ([]byte, error) {
+	encoded := storageConfig.EncryptionKey
+	if encoded == "" && storageConfig.EncryptionKeyFile != "" {
+		data, err := os.ReadFile(storageConfig.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the storage encryption key: %w", err)
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+	return storage.ParseEncryptionKey(encoded)
+}
+
+// backupEncryptionKey returns the key backups are encrypted with: the
+// storage encryption key, whichever backend holds the records
+func backupEncryptionKey() ([]byte, error) {
+	storageConfig, err := config.LoadStorageConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	return storageEncryptionKey(storageConfig)
+}
+
+// cliActor names the user running the command in instance histories and as
+// the owner of the instances it creates
+func cliActor() string {
+	if current, err := user.Current(); err == nil && current.Username != "" {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+// localStoragePath returns the path of the storage file. The service keeps
+// its lock, PID and log files next to it whichever backend holds the records.
+func localStoragePath() string {
+	return storage.NewFileStorage(storageFile).Path()
+}
+
+// resolveInstanceID returns the ID of the stored instance named ref, or ref
+// itself when it is an instance ID or no stored instance has that name
+func resolveInstanceID(store storage.Storage, ref string) (string, error) {
+	if _, err := store.GetInstance(ref); err == nil {
+		return ref, nil
+	}
+
+	matches, err := store.FindByName(ref)
+	if err != nil {
+		return "", err
+	}
+	switch len(matches) {
+	case 0:
+		return ref, nil
+	case 1:
+		return matches[0].ID, nil
+	}
+	ids := make([]string, len(matches))
+	for i, instance := range matches {
+		ids[i] = instance.ID
+	}
+	sort.Strings(ids)
+	return "", fmt.Errorf("name %q matches %d instances (%s); use the instance ID", ref, len(matches), strings.Join(ids, ", "))
+}
+
+// instanceProvider returns the provider managing instanceID: the one recorded
+// in storage, or the --provider one for instances missing from storage
+func instanceProvider(registry *cloud.Registry, store storage.Storage, instanceID string) (cloud.CloudProvider, error) {
+	if instance, err := store.GetInstance(instanceID); err == nil {
+		return registry.ForInstance(instance)
+	}
+	return registry.GetAccount(provider, account)
+}
+
+// createInRegions launches one instance per --regions entry and prints a summary
+func createInRegions(cmd *cobra.Command, cfg *config.Config, instanceConfig models.InstanceConfig) error {
+	// Every region shares the credentials of one provider; each region's
+	// client is created when it is first used
+	accountCfg, err := cfg.AWS.ForAccount(account)
+	if err != nil {
+		return err
+	}
+	baseProvider, err := newAWSProvider(accountCfg)
+	if err != nil {
+		return err
+	}
+	factory := func(region string) (cloud.CloudProvider, error) {
+		regionProvider, err := cloud.InRegion(baseProvider, region)
+		if err != nil {
+			return nil, err
+		}
+		if err := retryDo(cmd, regionProvider.ValidateCredentials); err != nil {
+			return nil, err
+		}
+		return regionProvider, nil
+	}
+
+	storage, err := openStorage()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Creating %s instances in %d regions: %s\n", instanceConfig.InstanceType, len(regions), strings.Join(regions, ", "))
+
+	results := cloud.CreateInRegions(cmd.Context(), factory, regions, instanceConfig)
+
+	failed := 0
+	fmt.Printf("\nSummary:\n")
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			fmt.Printf("  %-16s FAILED: %v\n", result.Region, result.Err)
+			continue
+		}
+		result.Instance.Account = account
+		result.Instance.KeepWhenIdle = keepWhenIdle
+		result.Instance.ExpiryAction = instanceConfig.ExpiryAction
+		result.Instance.OfficeHours = officeHours
+		result.Instance.StopCron = stopCron
+		result.Instance.StartCron = startCron
+		result.Instance.Owner = cliActor()
+		result.Instance.RecordEvent(time.Now(), models.EventCreated, result.Instance.Owner, "")
+		if err := storage.SaveInstance(result.Instance); err != nil {
+			log.Printf("Warning: failed to save instance %s to storage: %v", result.Instance.ID, err)
+		}
+		fmt.Printf("  %-16s %s (%s)\n", result.Region, result.Instance.ID, result.Instance.AvailabilityZone)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to create instances in %d of %d regions", failed, len(results))
+	}
+	return nil
+}
+
+func runStatus(cmd *cobra.Command, args []string) error {
+	// Resolve the provider managing the instance
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	provider, err := instanceProvider(newRegistry(), store, instanceID)
+	if err != nil {
+		return err
+	}
+
+	// Get instance status
+	status, err := retryCall(cmd, func(ctx context.Context) (*models.InstanceStatus, error) {
+		return provider.GetInstanceStatus(ctx, instanceID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get instance status: %w", err)
+	}
+
+	fmt.Printf("Instance Status:\n")
+	fmt.Printf("  ID: %s\n", status.ID)
+	fmt.Printf("  State: %s\n", status.State)
+	fmt.Printf("  Ready: %t\n", status.Ready)
+
+	if status.PublicIP != "" {
+		fmt.Printf("  Public IP: %s\n", status.PublicIP)
+		if status.SSHPort != 0 {
+			fmt.Printf("  SSH Command: ssh -p %d %s@%s\n", status.SSHPort, status.Username, status.PublicIP)
+		} else {
+			fmt.Printf("  SSH Command: ssh %s@%s\n", status.Username, status.PublicIP)
+		}
+	}
+
+	if status.PrivateIP != "" {
+		fmt.Printf("  Private IP: %s\n", status.PrivateIP)
+	}
+	if status.GPUCount > 0 {
+		fmt.Printf("  GPUs: %d x %s\n", status.GPUCount, status.GPUModel)
+	}
+
+	// Expiry is only known for instances in storage
+	if instance, err := store.GetInstance(instanceID); err == nil {
+		schedulerConfig, err := config.LoadSchedulerConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load configuration: %w", err)
+		}
+		printExpiry(instance, time.Now(), schedulerConfig.GracePeriod)
+	}
+
+	return nil
+}
+
+// printExpiry prints when the instance expires and, with a grace period, when
+// the scheduler stops it
+func printExpiry(instance *models.Instance, now time.Time, gracePeriod time.Duration) {
+	if instance.IsExpiredAt(now) {
+		fmt.Printf("  Expires At: %s (expired %s ago)\n", instance.ExpiresAt.Format(time.RFC3339), utils.FormatDuration(now.Sub(instance.ExpiresAt)))
+	} else {
+		fmt.Printf("  Expires At: %s (in %s)\n", instance.ExpiresAt.Format(time.RFC3339), utils.FormatDuration(instance.ExpiresAt.Sub(now)))
+	}
+	if gracePeriod <= 0 {
+		return
+	}
+	stopsAt := instance.StopsAt(gracePeriod)
+	if instance.InGracePeriod(now, gracePeriod) {
+		fmt.Printf("  Grace Period: %s, stopping at %s (in %s) unless extended\n", utils.FormatDuration(gracePeriod), stopsAt.Format(time.RFC3339), utils.FormatDuration(stopsAt.Sub(now)))
+	} else {
+		fmt.Printf("  Grace Period: %s, stops at %s\n", utils.FormatDuration(gracePeriod), stopsAt.Format(time.RFC3339))
+	}
+}
+
+func runList(cmd *cobra.Command, args []string) error {
+	if listArchived {
+		return runArchived(cmd, args)
+	}
+
+	// Load configuration
+	cfg, err := config.LoadConfigForProvider(provider)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Create provider based on flag
+	cloudProvider, err := newRegistry().GetAccount(provider, account)
+	if err != nil {
+		return err
+	}
+
+	// List instances in every region of interest
+	listRegions := regions
+	if len(listRegions) == 0 {
+		accountCfg, err := cfg.AWS.ForAccount(account)
+		if err != nil {
+			return err
+		}
+		store, err := openStorage()
+		if err != nil {
+			return err
+		}
+		listRegions = storedRegions(store, provider, account, accountCfg.Region)
+	}
+	instances, err := retryCall(cmd, func(ctx context.Context) ([]*models.Instance, error) {
+		return cloud.ListInRegions(ctx, cloudProvider, listRegions)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list instances: %w", err)
+	}
+
+	if sessionID != "" {
+		instances = models.FilterBySession(instances, sessionID)
+	}
+	filter := models.InstanceFilter{States: listStates, Owner: listOwner}
+	if len(tagSpecs) > 0 {
+		tags, err := models.ParseTags(tagSpecs)
+		if err != nil {
+			return fmt.Errorf("invalid tag: %w", err)
+		}
+		filter.Tags = tags
+	}
+	if expiresWithin > 0 {
+		now := time.Now()
+		filter.ExpiresAfter = now
+		filter.ExpiresBefore = now.Add(expiresWithin)
+	}
+	copyStoredOwners(instances)
+	instances = filter.Filter(instances)
+
+	if len(instances) == 0 {
+		fmt.Println("No managed instances found.")
+		return nil
+	}
+
+	fmt.Printf("Managed Instances:\n\n")
+	for _, instance := range instances {
+		fmt.Printf("Instance ID: %s\n", instance.ID)
+		if instance.Name != "" {
+			fmt.Printf("  Name: %s\n", instance.Name)
+		}
+		fmt.Printf("  Type: %s\n", instance.InstanceType)
+		if instance.GPUCount > 0 {
+			fmt.Printf("  GPUs: %d x %s\n", instance.GPUCount, instance.GPUModel)
+		}
+		if instance.OS != "" {
+			fmt.Printf("  OS: %s\n", instance.OS)
+		}
+		fmt.Printf("  State: %s\n", instance.State)
+		fmt.Printf("  Launch Time: %s\n", instance.LaunchTime.Format(time.RFC3339))
+		fmt.Printf("  Duration: %s\n", utils.FormatDuration(instance.Duration))
+		fmt.Printf("  Expires At: %s\n", instance.ExpiresAt.Format(time.RFC3339))
+		if instance.Region != "" {
+			fmt.Printf("  Region: %s\n", instance.Region)
+		}
+		fmt.Printf("  Availability Zone: %s\n", instance.AvailabilityZone)
+		if instance.SubnetID != "" {
+			fmt.Printf("  Network: %s / %s\n", instance.VPCID, instance.SubnetID)
+		}
+		if instance.Session != "" {
+			fmt.Printf("  Session: %s\n", instance.Session)
+		}
+		if instance.Owner != "" {
+			fmt.Printf("  Owner: %s\n", instance.Owner)
+		}
+		if len(instance.Tags) > 0 {
+			fmt.Printf("  Tags: %s\n", formatTags(instance.Tags))
+		}
+		if instance.SpotRequestID != "" {
+			fmt.Printf("  Spot Request: %s\n", instance.SpotRequestID)
+		}
+		if len(instance.VolumeIDs) > 0 {
+			fmt.Printf("  Volumes: %s\n", strings.Join(instance.VolumeIDs, ", "))
+		}
+
+		if instance.PublicIP != "" {
+			fmt.Printf("  Public IP: %s\n", instance.PublicIP)
+			if command, err := instance.RenderConnection(cfg.ConnectionTemplate); err != nil {
+				fmt.Printf("  SSH Command: %v\n", err)
+			} else if command != "" {
+				fmt.Printf("  SSH Command: %s\n", command)
+			}
+		}
+
+		if instance.IsExpired() {
+			fmt.Printf("  Status: EXPIRED\n")
+		} else {
+			timeLeft := time.Until(instance.ExpiresAt)
+			fmt.Printf("  Time Remaining: %s\n", utils.FormatDuration(timeLeft))
+		}
+
+		fmt.Println()
+	}
+
+	return nil
+}
+
+// copyStoredOwners sets the owners recorded in storage on listed instances,
+// which the providers do not know about
+func copyStoredOwners(instances []*models.Instance) {
+	store, err := openStorage()
+	if err != nil {
+		log.Printf("Warning: failed to read stored instance owners: %v", err)
+		return
+	}
+	stored, err := store.ListInstances()
+	if err != nil {
+		log.Printf("Warning: failed to read stored instance owners: %v", err)
+		return
+	}
+	owners := make(map[string]string, len(stored))
+	for _, instance := range stored {
+		owners[instance.ID] = instance.Owner
+	}
+	for _, instance := range instances {
+		if instance.Owner == "" {
+			instance.Owner = owners[instance.ID]
+		}
+	}
+}
+
+// storedRegions returns the configured region followed by the other regions
+// of the stored instances of the provider and account
+func storedRegions(store storage.Storage, providerName, accountName, configured string) []string {
+	regions := []string{configured}
+	seen := map[string]bool{configured: true}
+
+	instances, err := store.ListInstances()
+	if err != nil {
+		log.Printf("Warning: failed to read stored instance regions: %v", err)
+		return regions
+	}
+	for _, instance := range instances {
+		name := instance.Provider
+		if name == "" {
+			name = "aws"
+		}
+		if name != providerName || instance.Account != accountName || instance.Region == "" || seen[instance.Region] {
+			continue
+		}
+		seen[instance.Region] = true
+		regions = append(regions, instance.Region)
+	}
+	return regions
+}
+
+func runStop(cmd *cobra.Command, args []string) error {
+	storage, err := openStorage()
+	if err != nil {
+		return err
+	}
+
+	// Resolve the provider managing the instance
+	provider, err := instanceProvider(newRegistry(), storage, instanceID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Stopping instance %s...\n", instanceID)
+
+	// Terminate instance
+	if err := retryDo(cmd, func(ctx context.Context) error { return provider.TerminateInstance(ctx, instanceID) }); err != nil {
+		return fmt.Errorf("failed to terminate instance: %w", err)
+	}
+
+	// Update storage
+	instance, err := storage.GetInstance(instanceID)
+	if err == nil {
+		instance.State = "terminated"
+		instance.TerminatedAt = time.Now()
+		instance.RecordEvent(instance.TerminatedAt, models.EventTerminated, cliActor(), "stop command")
+		if err := storage.UpdateInstance(instance); err != nil {
+			log.Printf("Warning: failed to update instance state in storage: %v", err)
+		}
+	}
+
+	fmt.Printf("Instance %s has been stopped.\n", instanceID)
+	return nil
+}
+
+func runShow(cmd *cobra.Command, args []string) error {
+	connTemplate, err := config.LoadConnectionTemplate()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Create storage
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+
+	if instanceID == "" {
+		// Show all instances
+		instances, err := store.ListInstances()
+		if err != nil {
+			return fmt.Errorf("failed to load instances: %w", err)
+		}
+
+		if len(instances) == 0 {
+			fmt.Println("No instances found in storage.")
+			fmt.Println("Create an instance first using: instance-manager create --public-key ~/.ssh/id_rsa.pub")
+			return nil
+		}
+
+		fmt.Printf("=== All Stored Instances (%d total) ===\n\n", len(instances))
+		for i, instance := range instances {
+			fmt.Printf("Instance %d:\n", i+1)
+			printDetailedInstanceInfo(instance, connTemplate)
+			if showHistory {
+				printHistory(instance)
+			}
+			fmt.Println()
+		}
+	} else {
+		// Show specific instance, falling back to its archived record
+		instance, err := store.GetInstance(instanceID)
+		if err != nil {
+			record, archiveErr := storage.FindArchived(store, instanceID)
+			if archiveErr != nil || record == nil {
+				return fmt.Errorf("instance %s not found: %w", instanceID, err)
+			}
+			fmt.Printf("=== Archived Instance (%s at %s) ===\n\n", record.Reason, record.ArchivedAt.Format(time.RFC3339))
+			printDetailedInstanceInfo(record.Instance, connTemplate)
+			if showHistory {
+				printHistory(record.Instance)
+			}
+			return nil
+		}
+
+		fmt.Printf("=== Instance Communication Details ===\n\n")
+		printDetailedInstanceInfo(instance, connTemplate)
+		if showHistory {
+			printHistory(instance)
+		}
+	}
+	return nil
+}
+
+// printHistory prints the lifecycle events of an instance, oldest first
+func printHistory(instance *models.Instance) {
+	fmt.Printf("\n📜 History:\n")
+	if len(instance.History) == 0 {
+		fmt.Printf("   No events recorded\n")
+		return
+	}
+	for _, event := range instance.History {
+		line := fmt.Sprintf("   %s  %-10s", event.Time.Local().Format("2006-01-02 15:04:05"), event.Event)
+		if event.Actor != "" {
+			line += " by " + event.Actor
+		}
+		if event.Detail != "" {
+			line += " (" + event.Detail + ")"
+		}
+		fmt.Println(line)
+	}
+}
+
+// formatTags renders tags as "key=value" pairs sorted by key
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+func printDetailedInstanceInfo(instance *models.Instance, connTemplate string) {
+	fmt.Printf("🆔 Instance ID: %s\n", instance.ID)
+	if instance.Name != "" {
+		fmt.Printf("🏷️  Name: %s\n", instance.Name)
+	}
+	fmt.Printf("💻 Instance Type: %s\n", instance.InstanceType)
+	if instance.GPUCount > 0 {
+		fmt.Printf("🎮 GPUs: %d x %s\n", instance.GPUCount, instance.GPUModel)
+	}
+	if instance.OS != "" {
+		fmt.Printf("🐧 OS: %s\n", instance.OS)
+	}
+	fmt.Printf("📍 Availability Zone: %s\n", instance.AvailabilityZone)
+	fmt.Printf("🔑 Key Name: %s\n", instance.KeyName)
+	if instance.Region != "" {
+		fmt.Printf("🗺️  Region: %s\n", instance.Region)
+	}
+	fmt.Printf("👤 Username: %s\n", instance.Username)
+
+	fmt.Printf("\n🌐 Network & Communication Details:\n")
+	if instance.PublicIP != "" {
+		fmt.Printf("   📡 Public IP: %s\n", instance.PublicIP)
+		if command, err := instance.RenderConnection(connTemplate); err != nil {
+			fmt.Printf("   🔗 SSH Command: %v\n", err)
+		} else if command != "" {
+			fmt.Printf("   🔗 SSH Command: %s\n", command)
+		}
+		fmt.Printf("   🌍 Web Access: http://%s (if web server running)\n", instance.PublicIP)
+	} else {
+		fmt.Printf("   📡 Public IP: Not assigned yet (instance may be starting)\n")
+		fmt.Printf("   💡 Tip: Check status again in a few minutes\n")
+	}
+
+	if instance.PrivateIP != "" {
+		fmt.Printf("   🏠 Private IP: %s\n", instance.PrivateIP)
+	}
+	if instance.SubnetID != "" {
+		fmt.Printf("   🧭 VPC / Subnet: %s / %s\n", instance.VPCID, instance.SubnetID)
+	}
+
+	fmt.Printf("\n📊 Instance Status:\n")
+	fmt.Printf("   State: %s\n", instance.State)
+	fmt.Printf("   Launch Time: %s\n", instance.LaunchTime.Format("2006-01-02 15:04:05"))
+	if !instance.ReadyAt.IsZero() {
+		fmt.Printf("   Ready At: %s\n", instance.ReadyAt.Format("2006-01-02 15:04:05"))
+		fmt.Printf("   Time to Ready: %s\n", utils.FormatDuration(instance.TimeToReady()))
+	}
+	fmt.Printf("   Duration: %s\n", utils.FormatDuration(instance.Duration))
+	fmt.Printf("   Expires At: %s\n", instance.ExpiresAt.Format("2006-01-02 15:04:05"))
+	fmt.Printf("   Restart Policy: %s\n", instance.GetRestartPolicy())
+	fmt.Printf("   Expiry Action: %s\n", instance.GetExpiryAction())
+	if instance.Session != "" {
+		fmt.Printf("   Session: %s\n", instance.Session)
+	}
+	if instance.Owner != "" {
+		fmt.Printf("   Owner: %s\n", instance.Owner)
+	}
+	if len(instance.Tags) > 0 {
+		fmt.Printf("   Tags: %s\n", formatTags(instance.Tags))
+	}
+	if instance.SpotRequestID != "" {
+		fmt.Printf("   Spot Request: %s\n", instance.SpotRequestID)
+	}
+	if instance.ElasticIPAllocationID != "" {
+		fmt.Printf("   Elastic IP Allocation: %s\n", instance.ElasticIPAllocationID)
+	}
+	if instance.SnapshotOnExpiry {
+		fmt.Printf("   Snapshot on Expiry: yes\n")
+	}
+	if instance.ImageOnExpiry {
+		fmt.Printf("   Image on Expiry: yes\n")
+	}
+	if instance.KeepWhenIdle {
+		fmt.Printf("   Keep When Idle: yes\n")
+	}
+	if instance.OfficeHours != "" {
+		fmt.Printf("   Office Hours: %s\n", instance.OfficeHours)
+	}
+	printCronSchedules(instance, time.Now(), "   ")
+	if len(instance.VolumeIDs) > 0 {
+		fmt.Printf("   Volumes: %s\n", strings.Join(instance.VolumeIDs, ", "))
+	}
+	if instance.RestartCount > 0 {
+		fmt.Printf("   Restarts: %d\n", instance.RestartCount)
+	}
+	if instance.Unhealthy {
+		fmt.Printf("   ⚠️  Health: UNHEALTHY (too many restarts; extend the TTL to reset)\n")
+	}
+	if instance.StopReason != "" {
+		fmt.Printf("   Stop Reason: %s\n", instance.StopReason)
+	}
+
+	if instance.IsExpired() {
+		fmt.Printf("   ⚠️  Status: EXPIRED\n")
+		fmt.Printf("   💡 Tip: This instance should be terminated automatically\n")
+	} else {
+		timeLeft := time.Until(instance.ExpiresAt)
+		fmt.Printf("   ⏳ Time Remaining: %s\n", utils.FormatDuration(timeLeft))
+
+		if timeLeft < 10*time.Minute {
+			fmt.Printf("   ⚠️  Warning: Instance will expire soon!\n")
+			fmt.Printf("   💡 Extend with: instance-manager extend --instance-id %s --duration 1h\n", instance.ID)
+		}
+	}
+
+	// Show full connection string if available
+	connectionString := instance.GetConnectionString()
+	if connectionString != "" {
+		fmt.Printf("\n🚀 Quick Connect: %s\n", connectionString)
+	}
+
+	fmt.Printf("\n📝 Storage Information:\n")
+	fmt.Printf("   📁 This data is persisted locally for future access\n")
+	fmt.Printf("   🔄 Run 'instance-manager service' for automatic lifecycle management\n")
+}
+
+func runExtend(cmd *cobra.Command, args []string) error {
+	// Parse duration
+	parsedDuration, err := utils.ParseDuration(duration)
+	if err != nil {
+		return fmt.Errorf("invalid duration: %w", err)
+	}
+
+	// Create storage
+	storage, err := openStorage()
+	if err != nil {
+		return err
+	}
+
+	// Get instance
+	instance, err := storage.GetInstance(instanceID)
+	if err != nil {
+		return fmt.Errorf("failed to get instance: %w", err)
+	}
+
+	maxLifetime, err := config.LoadMaxLifetime()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if err := utils.ValidateLifetime(instance.LaunchTime, instance.ExpiresAt.Add(parsedDuration), maxLifetime); err != nil {
+		return fmt.Errorf("cannot extend by %s: %w", utils.FormatDuration(parsedDuration), err)
+	}
+
+	// Extend TTL
+	oldExpiresAt := instance.ExpiresAt
+	instance.ExpiresAt = instance.ExpiresAt.Add(parsedDuration)
+	instance.Duration = instance.Duration + parsedDuration
+	// Allow the scheduler to restart a budget-stopped or unhealthy instance
+	instance.StopReason = ""
+	instance.RestartCount = 0
+	instance.Unhealthy = false
+	instance.RecordEvent(time.Now(), models.EventExtended, cliActor(), fmt.Sprintf("by %s until %s", utils.FormatDuration(parsedDuration), instance.ExpiresAt.Format(time.RFC3339)))
+
+	// Update storage
+	if err := storage.UpdateInstance(instance); err != nil {
+		return fmt.Errorf("failed to update instance: %w", err)
+	}
+
+	fmt.Printf("Instance TTL extended successfully!\n")
+	fmt.Printf("  Instance ID: %s\n", instance.ID)
+	fmt.Printf("  Previous expiry: %s\n", oldExpiresAt.Format(time.RFC3339))
+	fmt.Printf("  New expiry: %s\n", instance.ExpiresAt.Format(time.RFC3339))
+	fmt.Printf("  Extended by: %s\n", utils.FormatDuration(parsedDuration))
+
+	// If the instance is currently stopped and the new TTL is in the future,
+	// let the user know that the service will restart it
+	if instance.State == "stopped" && instance.ExpiresAt.After(time.Now()) {
+		fmt.Printf("\nNote: Instance is currently stopped. The background service will automatically start it.\n")
+		fmt.Printf("To manually start the service: %s service --log-level info\n", os.Args[0])
+	}
+
+	return nil
+}
+
+func runExpiryAction(cmd *cobra.Command, args []string) error {
+	storage, err := openStorage()
+	if err != nil {
+		return err
+	}
+	instance, err := storage.GetInstance(instanceID)
+	if err != nil {
+		return fmt.Errorf("failed to get instance: %w", err)
+	}
+
+	if len(args) == 0 {
+		if instance.ExpiryAction != "" {
+			fmt.Printf("Expiry action of %s: %s\n", instance.ID, instance.ExpiryAction)
+			return nil
+		}
+		defaults, err := config.LoadDefaultValues()
+		if err != nil {
+			return fmt.Errorf("failed to load configuration: %w", err)
+		}
+		fmt.Printf("Expiry action of %s: %s (the default)\n", instance.ID, instance.ExpiryActionOr(defaults.ExpiryAction))
+		return nil
+	}
+
+	action := args[0]
+	if err := models.ValidateExpiryAction(action); err != nil {
+		return err
+	}
+	if action == models.ExpiryActionHibernate && instance.Provider != "" && instance.Provider != "aws" {
+		return fmt.Errorf("hibernation is not supported for provider %s", instance.Provider)
+	}
+	previous := instance.ExpiryAction
+	instance.ExpiryAction = action
+	if err := storage.UpdateInstance(instance); err != nil {
+		return fmt.Errorf("failed to update instance: %w", err)
+	}
+
+	fmt.Printf("Expiry action of %s set to %s\n", instance.ID, action)
+	if action == models.ExpiryActionHibernate && previous != models.ExpiryActionHibernate {
+		fmt.Println("Note: an instance not launched with hibernation enabled is stopped instead.")
+	}
+	if action == models.ExpiryActionTerminate {
+		fmt.Println("Note: the instance is terminated at expiry, even when stopped, and cannot be restarted by extending it.")
+	}
+	return nil
+}
+
+func runOfficeHours(cmd *cobra.Command, args []string) error {
+	if clearHours && len(args) > 0 {
+		return fmt.Errorf("--clear cannot be combined with a window")
+	}
+
+	storage, err := openStorage()
+	if err != nil {
+		return err
+	}
+	instance, err := storage.GetInstance(instanceID)
+	if err != nil {
+		return fmt.Errorf("failed to get instance: %w", err)
+	}
+
+	if !clearHours && len(args) == 0 {
+		if instance.OfficeHours == "" {
+			fmt.Printf("Instance %s has no office hours and runs around the clock.\n", instance.ID)
+			return nil
+		}
+		hours, err := instance.GetOfficeHours()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		fmt.Printf("Office hours of %s: %s\n", instance.ID, instance.OfficeHours)
+		if hours.Contains(now) {
+			fmt.Printf("  Open now, closes at %s\n", hours.NextClose(now).Format(time.RFC3339))
+		} else {
+			fmt.Printf("  Closed now, opens at %s\n", hours.NextOpen(now).Format(time.RFC3339))
+		}
+		return nil
+	}
+
+	if clearHours {
+		instance.OfficeHours = ""
+	} else {
+		if _, err := models.ParseOfficeHours(args[0]); err != nil {
+			return err
+		}
+		instance.OfficeHours = args[0]
+	}
+	if err := storage.UpdateInstance(instance); err != nil {
+		return fmt.Errorf("failed to update instance: %w", err)
+	}
+
+	if instance.OfficeHours == "" {
+		fmt.Printf("Office hours of %s cleared; the instance runs around the clock within its TTL.\n", instance.ID)
+	} else {
+		fmt.Printf("Office hours of %s set to %s\n", instance.ID, instance.OfficeHours)
+		fmt.Printf("The background service stops the instance outside this window and starts it when the window opens.\n")
+	}
+	return nil
+}
+
+// printCronSchedules prints the stop and start schedules of the instance with
+// their next fire times
+func printCronSchedules(instance *models.Instance, now time.Time, indent string) {
+	if instance.StopCron != "" {
+		fmt.Printf("%sStop Schedule: %s (next %s)\n", indent, instance.StopCron, formatNextFire(instance.NextCronStop(now)))
+	}
+	if instance.StartCron != "" {
+		fmt.Printf("%sStart Schedule: %s (next %s)\n", indent, instance.StartCron, formatNextFire(instance.NextCronStart(now)))
+	}
+}
+
+func formatNextFire(next time.Time) string {
+	if next.IsZero() {
+		return "never"
+	}
+	return next.Local().Format("2006-01-02 15:04:05")
+}
+
+func runCron(cmd *cobra.Command, args []string) error {
+	changeStop, changeStart := cmd.Flags().Changed("stop"), cmd.Flags().Changed("start")
+	if clearCron && (changeStop || changeStart) {
+		return fmt.Errorf("--clear cannot be combined with --stop or --start")
+	}
+	for _, expr := range []string{stopCron, startCron} {
+		if expr == "" {
+			continue
+		}
+		if _, err := models.ParseCron(expr); err != nil {
+			return err
+		}
+	}
+
+	storage, err := openStorage()
+	if err != nil {
+		return err
+	}
+	instance, err := storage.GetInstance(instanceID)
+	if err != nil {
+		return fmt.Errorf("failed to get instance: %w", err)
+	}
+
+	if !clearCron && !changeStop && !changeStart {
+		if instance.StopCron == "" && instance.StartCron == "" {
+			fmt.Printf("Instance %s has no stop or start schedule.\n", instance.ID)
+			return nil
+		}
+		fmt.Printf("Schedules of %s:\n", instance.ID)
+		printCronSchedules(instance, time.Now(), "  ")
+		return nil
+	}
+
+	if clearCron {
+		instance.StopCron, instance.StartCron = "", ""
+	}
+	if changeStop {
+		instance.StopCron = stopCron
+	}
+	if changeStart {
+		instance.StartCron = startCron
+	}
+	if err := storage.UpdateInstance(instance); err != nil {
+		return fmt.Errorf("failed to update instance: %w", err)
+	}
+
+	if instance.StopCron == "" && instance.StartCron == "" {
+		fmt.Printf("Schedules of %s cleared.\n", instance.ID)
+		return nil
+	}
+	fmt.Printf("Schedules of %s updated:\n", instance.ID)
+	printCronSchedules(instance, time.Now(), "  ")
+	fmt.Printf("The background service stops and starts the instance at these times while its TTL lasts.\n")
+	return nil
+}
+
+func runSync(cmd *cobra.Command, args []string) error {
+	// Get the instance ID from the flag
+	syncInstanceID, _ := cmd.Flags().GetString("instance-id")
+
+	// Each stored instance is synced with the provider recorded on it
+	registry := newRegistry()
+
+	// Create storage
+	storage, err := openStorage()
+	if err != nil {
+		return err
+	}
+
+	// Sync all instances if no specific ID is provided
+	if syncInstanceID == "" {
+		instances, err := storage.ListInstances()
+		if err != nil {
+			return fmt.Errorf("failed to list instances: %w", err)
+		}
+
+		for _, instance := range instances {
+			provider, err := registry.ForInstance(instance)
+			if err != nil {
+				log.Printf("Warning: failed to sync instance %s: %v", instance.ID, err)
+				continue
+			}
+			if err := syncInstanceData(cmd, provider, storage, instance.ID); err != nil {
+				log.Printf("Warning: failed to sync instance %s: %v", instance.ID, err)
+			}
+		}
+
+		fmt.Println("Sync completed for all instances.")
+	} else {
+		// Sync specific instance
+		instance, err := storage.GetInstance(syncInstanceID)
+		if err != nil {
+			return fmt.Errorf("failed to sync instance %s: %w", syncInstanceID, err)
+		}
+		provider, err := registry.ForInstance(instance)
+		if err != nil {
+			return fmt.Errorf("failed to sync instance %s: %w", syncInstanceID, err)
+		}
+		if err := syncInstanceData(cmd, provider, storage, syncInstanceID); err != nil {
+			return fmt.Errorf("failed to sync instance %s: %w", syncInstanceID, err)
+		}
+
+		fmt.Printf("Sync completed for instance %s.\n", syncInstanceID)
+	}
+
+	return nil
+}
+
+func syncInstanceData(cmd *cobra.Command, provider cloud.CloudProvider, storage storage.Storage, instanceID string) error {
+	// Get current instance data from the provider
+	currentData, err := retryCall(cmd, func(ctx context.Context) (*models.InstanceStatus, error) {
+		return provider.GetInstanceStatus(ctx, instanceID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get instance status from provider: %w", err)
+	}
+
+	// Get stored instance data
+	storedInstance, err := storage.GetInstance(instanceID)
+	if err != nil {
+		return fmt.Errorf("failed to get instance from storage: %w", err)
+	}
+
+	// Update stored instance data with current data
+	storedInstance.PublicIP = currentData.PublicIP
+	storedInstance.PrivateIP = currentData.PrivateIP
+	storedInstance.SSHPort = currentData.SSHPort
+	storedInstance.State = currentData.State
+	if len(currentData.VolumeIDs) > 0 {
+		storedInstance.VolumeIDs = currentData.VolumeIDs
+	}
+	// Note: Ready status is determined by PublicIP presence and state
+	storedInstance.MarkReady(time.Now())
+
+	// Update storage
+	if err := storage.UpdateInstance(storedInstance); err != nil {
+		return fmt.Errorf("failed to update instance in storage: %w", err)
+	}
+
+	fmt.Printf("Instance %s synced: PublicIP=%s, State=%s\n", instanceID, storedInstance.PublicIP, storedInstance.State)
+	return nil
+}
+
+// getLogLevel parses log level string to logrus level
+func getLogLevel(level string) logrus.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return logrus.DebugLevel
+	case "info":
+		return logrus.InfoLevel
+	case "warn", "warning":
+		return logrus.WarnLevel
+	case "error":
+		return logrus.ErrorLevel
+	default:
+		return logrus.InfoLevel
+	}
+}
+
+// leaderElector returns the elector of the configured leader backend, or nil
+// when the service runs without an election
+func leaderElector(cmd *cobra.Command, interval time.Duration) (leader.Elector, config.LeaderConfig, error) {
+	leaderConfig, err := config.LoadLeaderConfig()
+	if err != nil {
+		return nil, leaderConfig, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if cmd.Flags().Changed("leader") {
+		leaderConfig.Backend = leaderBackend
+	}
+	if cmd.Flags().Changed("lock-file") {
+		leaderConfig.LockFile = leaderLockFile
+	}
+
+	switch leaderConfig.Backend {
+	case "":
+		return nil, leaderConfig, nil
+	case "file":
+		if leaderConfig.LockFile == "" {
+			leaderConfig.LockFile = localStoragePath() + ".lock"
+		}
+		return leader.NewFileElector(leaderConfig.LockFile), leaderConfig, nil
+	case "consul":
+		if leaderConfig.TTL <= interval {
+			return nil, leaderConfig, fmt.Errorf("leader.ttl (%s) must be longer than the scheduler interval (%s)", leaderConfig.TTL, interval)
+		}
+		return leader.NewConsulElector(leaderConfig.ConsulAddress, leaderConfig.ConsulToken, leaderConfig.Key, leaderConfig.TTL), leaderConfig, nil
+	case "etcd":
+		if leaderConfig.TTL <= interval {
+			return nil, leaderConfig, fmt.Errorf("leader.ttl (%s) must be longer than the scheduler interval (%s)", leaderConfig.TTL, interval)
+		}
+		storageConfig, err := config.LoadStorageConfig()
+		if err != nil {
+			return nil, leaderConfig, fmt.Errorf("failed to load configuration: %w", err)
+		}
+		etcd := storageConfig.Etcd
+		elector, err := leader.NewEtcdElector(etcd.Endpoints, etcd.Username, etcd.Password, leaderConfig.Key, leaderConfig.TTL)
+		if err != nil {
+			return nil, leaderConfig, err
+		}
+		return elector, leaderConfig, nil
+	default:
+		return nil, leaderConfig, fmt.Errorf("invalid --leader: %s (must be file, consul or etcd)", leaderConfig.Backend)
+	}
+}
+
+func runService(cmd *cobra.Command, args []string) error {
+	if daemonize && !daemon.IsChild() {
+		return startDaemon()
+	}
+
+	// Create provider based on flag
+	registry := newRegistry()
+	cloudProvider, err := registry.Get(provider)
+	if err != nil {
+		return err
+	}
+	retry, err := retryPolicy(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Validate credentials
+	if err := retryDo(cmd, cloudProvider.ValidateCredentials); err != nil {
+		return fmt.Errorf("failed to validate %s credentials: %w", strings.ToUpper(provider), err)
+	}
+
+	// Create storage
+	storage, err := openStorage()
+	if err != nil {
+		return err
+	}
+
+	// Use AWS server time for expiry decisions if requested
+	var timeSource scheduler.TimeSource
+	if useAWSTime {
+		awsProvider, ok := cloudProvider.(*aws.Provider)
+		if !ok {
+			return fmt.Errorf("--aws-time is not supported for provider %s", provider)
+		}
+		timeSource = scheduler.TimeSourceFunc(awsProvider.ServerTime)
+	}
+
+	// Renew expiring instances until the daily cutoff if requested
+	var autoRenew *scheduler.AutoRenewOptions
+	if autoRenewUntil != "" {
+		cutoff, err := utils.ParseTimeOfDay(autoRenewUntil)
+		if err != nil {
+			return fmt.Errorf("invalid --auto-renew-until: %w", err)
+		}
+		if autoRenewStep <= 0 {
+			return fmt.Errorf("invalid --auto-renew-increment: %s", autoRenewStep)
+		}
+		autoRenew = &scheduler.AutoRenewOptions{
+			Until:     cutoff,
+			Increment: autoRenewStep,
+			MaxAge:    autoRenewMaxAge,
+		}
+	}
+
+	// Polling intervals come from the config file unless given as flags
+	schedulerConfig, err := config.LoadSchedulerConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if cmd.Flags().Changed("interval") {
+		if checkInterval <= 0 {
+			return fmt.Errorf("invalid --interval: %s", checkInterval)
+		}
+		schedulerConfig.Interval = checkInterval
+	}
+	if cmd.Flags().Changed("reload-interval") {
+		if reloadInterval <= 0 {
+			return fmt.Errorf("invalid --reload-interval: %s", reloadInterval)
+		}
+		schedulerConfig.ReloadInterval = reloadInterval
+	}
+	if cmd.Flags().Changed("concurrency") {
+		if concurrency <= 0 {
+			return fmt.Errorf("invalid --concurrency: %d", concurrency)
+		}
+		schedulerConfig.Concurrency = concurrency
+	}
+	if cmd.Flags().Changed("instance-timeout") {
+		if instanceTimeout <= 0 {
+			return fmt.Errorf("invalid --instance-timeout: %s", instanceTimeout)
+		}
+		schedulerConfig.InstanceTimeout = instanceTimeout
+	}
+	if cmd.Flags().Changed("warn-before") {
+		for _, leadTime := range warnLeadTimes {
+			if leadTime < 0 {
+				return fmt.Errorf("invalid --warn-before: %s", leadTime)
+			}
+		}
+		schedulerConfig.WarnBefore = warnLeadTimes
+	}
+	if cmd.Flags().Changed("terminate-stopped-after-days") {
+		if stoppedDays < 0 {
+			return fmt.Errorf("invalid --terminate-stopped-after-days: %d", stoppedDays)
+		}
+		schedulerConfig.TerminateStoppedAfterDays = stoppedDays
+	}
+	if cmd.Flags().Changed("prune-terminated-after-days") {
+		if pruneDays < 0 {
+			return fmt.Errorf("invalid --prune-terminated-after-days: %d", pruneDays)
+		}
+		schedulerConfig.Prune.TerminatedAfterDays = pruneDays
+	}
+	if cmd.Flags().Changed("archive-pruned") {
+		schedulerConfig.Prune.Archive = archivePruned
+	}
+	if cmd.Flags().Changed("backup-interval") {
+		if backupInterval < 0 {
+			return fmt.Errorf("invalid --backup-interval: %s", backupInterval)
+		}
+		schedulerConfig.Backup.Interval = backupInterval
+	}
+	if cmd.Flags().Changed("backup-dir") {
+		schedulerConfig.Backup.Dir = backupDir
+	}
+	if cmd.Flags().Changed("backup-keep") {
+		if backupKeep < 0 {
+			return fmt.Errorf("invalid --backup-keep: %d", backupKeep)
+		}
+		schedulerConfig.Backup.Keep = backupKeep
+	}
+	if cmd.Flags().Changed("idle-window") {
+		if idleWindow < 0 {
+			return fmt.Errorf("invalid --idle-window: %s", idleWindow)
+		}
+		schedulerConfig.Idle.Window = idleWindow
+	}
+	if cmd.Flags().Changed("idle-cpu-percent") {
+		if idleCPUPercent <= 0 || idleCPUPercent > 100 {
+			return fmt.Errorf("invalid --idle-cpu-percent: %g", idleCPUPercent)
+		}
+		schedulerConfig.Idle.CPUPercent = idleCPUPercent
+	}
+	if cmd.Flags().Changed("idle-network-bytes") {
+		if idleNetworkBytes < 0 {
+			return fmt.Errorf("invalid --idle-network-bytes: %g", idleNetworkBytes)
+		}
+		schedulerConfig.Idle.NetworkBytesPerSecond = idleNetworkBytes
+	}
+	notificationsConfig, err := config.LoadNotificationsConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if cmd.Flags().Changed("notify-webhook") {
+		notificationsConfig.WebhookURL = notifyWebhook
+	}
+	if cmd.Flags().Changed("web-url") {
+		notificationsConfig.WebURL = notifyWebURL
+	}
+	warnings := scheduler.ExpiryWarningOptions{
+		LeadTimes: schedulerConfig.WarnBefore,
+		WebURL:    notificationsConfig.WebURL,
+	}
+	idle := scheduler.IdlePolicy{
+		CPUPercent:            schedulerConfig.Idle.CPUPercent,
+		NetworkBytesPerSecond: schedulerConfig.Idle.NetworkBytesPerSecond,
+		Window:                schedulerConfig.Idle.Window,
+	}
+	elector, leaderConfig, err := leaderElector(cmd, schedulerConfig.Interval)
+	if err != nil {
+		return err
+	}
+	lifecycleHooks, err := config.LoadHooks()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	maxLifetime, err := config.LoadMaxLifetime()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	defaults, err := config.LoadDefaultValues()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if cmd.Flags().Changed("orphan-interval") {
+		if orphanInterval < 0 {
+			return fmt.Errorf("invalid --orphan-interval: %s", orphanInterval)
+		}
+		schedulerConfig.Orphans.Interval = orphanInterval
+	}
+	if cmd.Flags().Changed("adopt-orphans") {
+		schedulerConfig.Orphans.Adopt = adoptOrphans
+	}
+	var orphanCheck *scheduler.OrphanCheckOptions
+	if schedulerConfig.Orphans.Interval > 0 {
+		cfg, err := config.LoadConfigForProvider(provider)
+		if err != nil {
+			return fmt.Errorf("failed to load configuration: %w", err)
+		}
+		orphanCheck = &scheduler.OrphanCheckOptions{
+			Provider:     cloudProvider,
+			ProviderName: provider,
+			Regions:      []string{cfg.AWS.Region},
+			Interval:     schedulerConfig.Orphans.Interval,
+			Adopt:        schedulerConfig.Orphans.Adopt,
+		}
+	}
+	var prune *scheduler.PruneOptions
+	if schedulerConfig.Prune.TerminatedAfterDays > 0 {
+		prune = &scheduler.PruneOptions{
+			After:   time.Duration(schedulerConfig.Prune.TerminatedAfterDays) * 24 * time.Hour,
+			Archive: schedulerConfig.Prune.Archive,
+		}
+	}
+	var backups *scheduler.BackupOptions
+	if schedulerConfig.Backup.Interval > 0 {
+		key, err := backupEncryptionKey()
+		if err != nil {
+			return err
+		}
+		backups = &scheduler.BackupOptions{
+			Dir:      schedulerConfig.Backup.Dir,
+			Interval: schedulerConfig.Backup.Interval,
+			Keep:     schedulerConfig.Backup.Keep,
+			Key:      key,
+		}
+		if backups.Dir == "" {
+			backups.Dir = filepath.Join(filepath.Dir(localStoragePath()), "backups")
+		}
+	}
+
+	// Create and configure scheduler
+	scheduler := scheduler.NewScheduler(cloudProvider, storage,
+		scheduler.WithInterval(schedulerConfig.Interval),
+		scheduler.WithReloadInterval(schedulerConfig.ReloadInterval),
+		scheduler.WithConcurrency(schedulerConfig.Concurrency),
+		scheduler.WithInstanceTimeout(schedulerConfig.InstanceTimeout),
+	)
+	scheduler.SetRegistry(registry)
+	if cmd.Flags().Changed("timeout") {
+		scheduler.SetCallTimeout(callTimeout)
+	}
+	scheduler.SetRetryPolicy(retry)
+
+	// Set log level
+	logLevelParsed := getLogLevel(logLevel)
+	if verbose {
+		logLevelParsed = logrus.DebugLevel
+	}
+	scheduler.SetLogLevel(logLevelParsed)
+
+	if timeSource != nil {
+		scheduler.SetTimeSource(timeSource, maxClockSkew)
+	}
+
+	if dailyBudget < 0 {
+		return fmt.Errorf("invalid daily budget: %.2f", dailyBudget)
+	}
+	scheduler.SetDailyBudget(dailyBudget)
+
+	if maxRestarts < 0 {
+		return fmt.Errorf("invalid max restarts: %d", maxRestarts)
+	}
+	scheduler.SetMaxRestarts(maxRestarts)
+
+	if autoRenew != nil {
+		scheduler.SetAutoRenew(*autoRenew)
+	}
+
+	scheduler.SetExpiryWarnings(warnings)
+	scheduler.SetGracePeriod(schedulerConfig.GracePeriod)
+	scheduler.SetTerminateStoppedAfter(time.Duration(schedulerConfig.TerminateStoppedAfterDays) * 24 * time.Hour)
+	scheduler.SetMaxLifetime(maxLifetime)
+	scheduler.SetDefaultExpiryAction(defaults.ExpiryAction)
+	if idle.Window > 0 {
+		scheduler.SetIdlePolicy(idle)
+	}
+	if notificationsConfig.WebhookURL != "" {
+		scheduler.SetNotifier(notify.NewWebhook(notificationsConfig.WebhookURL))
+	}
+	scheduler.SetDryRun(dryRun)
+	if elector != nil {
+		scheduler.SetLeaderElector(elector)
+	}
+	if len(lifecycleHooks) > 0 {
+		scheduler.SetHooks(hooks.NewRunner(lifecycleHooks))
+	}
+	if orphanCheck != nil {
+		scheduler.SetOrphanCheck(*orphanCheck)
+	}
+	if prune != nil {
+		scheduler.SetPruneTerminated(*prune)
+	}
+	if backups != nil {
+		scheduler.SetBackups(*backups)
+	}
+
+	// Record the PID for service stop, refusing to start twice
+	pidPath := pidFile
+	if pidPath == "" && daemonize {
+		pidPath = servicePIDFile()
+	}
+	if pidPath != "" {
+		if err := daemon.WritePIDFile(pidPath); err != nil {
+			return err
+		}
+		defer daemon.RemovePIDFile(pidPath)
+	}
+
+	// The detached service already writes everything to the log file
+	if logFile != "" && !daemon.IsChild() {
+		file, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		defer file.Close()
+		scheduler.SetLogOutput(file)
+	}
+
+	// Start scheduler
+	scheduler.Start()
+	if _, err := daemon.Notify("READY=1"); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+
+	fmt.Printf("Instance Manager service started (log level: %s)\n", logLevel)
+	if dryRun {
+		fmt.Println("DRY RUN: logging the actions the service would take without taking them")
+	}
+	fmt.Println("Monitoring instance lifecycle, TTL changes, and state management...")
+	fmt.Printf("Checking instances every %s, reloading storage at least every %s\n", schedulerConfig.Interval, schedulerConfig.ReloadInterval)
+	fmt.Printf("Checking up to %d instances at once, giving up on an instance after %s\n", schedulerConfig.Concurrency, schedulerConfig.InstanceTimeout)
+	if autoRenewUntil != "" {
+		fmt.Printf("Auto-renewing expiring instances by %s until %s\n", autoRenewStep, autoRenewUntil)
+	}
+	if dailyBudget > 0 {
+		fmt.Printf("Enforcing a daily budget of $%.2f\n", dailyBudget)
+	}
+	if schedulerConfig.GracePeriod > 0 {
+		fmt.Printf("Keeping expired instances running for a grace period of %s\n", schedulerConfig.GracePeriod)
+	}
+	if idle.Window > 0 {
+		fmt.Printf("Stopping instances idle for %s (CPU below %g%%", idle.Window, idle.CPUPercent)
+		if idle.NetworkBytesPerSecond > 0 {
+			fmt.Printf(", network below %g bytes/s", idle.NetworkBytesPerSecond)
+		}
+		fmt.Println(")")
+	}
+	if maxLifetime > 0 {
+		fmt.Printf("Stopping instances %s after launch, however often they were extended\n", utils.FormatDuration(maxLifetime))
+	}
+	if defaults.ExpiryAction != "" && defaults.ExpiryAction != models.ExpiryActionStop {
+		fmt.Printf("Applying the %s expiry action to instances without one\n", defaults.ExpiryAction)
+	}
+	if schedulerConfig.TerminateStoppedAfterDays > 0 {
+		fmt.Printf("Terminating instances stopped for more than %d days\n", schedulerConfig.TerminateStoppedAfterDays)
+	}
+	if schedulerConfig.Prune.TerminatedAfterDays > 0 && schedulerConfig.Prune.Archive {
+		fmt.Printf("Archiving the records of instances terminated more than %d days ago\n", schedulerConfig.Prune.TerminatedAfterDays)
+	} else if schedulerConfig.Prune.TerminatedAfterDays > 0 {
+		fmt.Printf("Removing the records of instances terminated more than %d days ago\n", schedulerConfig.Prune.TerminatedAfterDays)
+	}
+	if backups != nil {
+		fmt.Printf("Backing up storage to %s every %s\n", backups.Dir, backups.Interval)
+	}
+	if notificationsConfig.WebhookURL != "" {
+		fmt.Println("Sending notifications to the configured webhook")
+	}
+	if orphanCheck != nil && orphanCheck.Adopt {
+		fmt.Printf("Adopting managed instances missing from storage, checking every %s\n", orphanCheck.Interval)
+	} else if orphanCheck != nil {
+		fmt.Printf("Reporting managed instances missing from storage, checking every %s\n", orphanCheck.Interval)
+	}
+	if len(lifecycleHooks) > 0 {
+		fmt.Printf("Running %d lifecycle hooks before and after stopping or terminating instances\n", len(lifecycleHooks))
+	}
+	switch leaderConfig.Backend {
+	case "file":
+		fmt.Printf("Managing instances only while holding the lock on %s\n", leaderConfig.LockFile)
+	case "consul":
+		fmt.Printf("Managing instances only while holding the Consul key %s at %s\n", leaderConfig.Key, leaderConfig.ConsulAddress)
+	case "etcd":
+		fmt.Printf("Managing instances only while leading the etcd election %s\n", leaderConfig.Key)
+	}
+	if pidPath != "" {
+		fmt.Printf("Running as PID %d, recorded in %s\n", os.Getpid(), pidPath)
+	}
+	if daemon.IsChild() {
+		fmt.Println("Stop the service with 'instance-manager service stop'.")
+	} else {
+		fmt.Println("Press Ctrl+C to stop the service.")
+	}
+
+	// Wait for interrupt signal
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+
+	if _, err := daemon.Notify("STOPPING=1"); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	scheduler.Stop()
+	fmt.Println("Service stopped.")
+	return nil
+}
+
+// startDaemon starts the service in the background and returns once it is
+// ready
+func startDaemon() error {
+	pidPath := servicePIDFile()
+	if pid, err := daemon.ReadPIDFile(pidPath); err == nil && daemon.Running(pid) {
+		return fmt.Errorf("the service is already running as PID %d (%s)", pid, pidPath)
+	}
+	logPath := logFile
+	if logPath == "" {
+		logPath = localStoragePath() + ".log"
+	}
+
+	pid, err := daemon.Start(os.Args[1:], logPath, pidPath, daemonStartTimeout)
+	if err != nil {
+		if pid != 0 {
+			return fmt.Errorf("%w; it keeps starting as PID %d", err, pid)
+		}
+		return err
+	}
+	fmt.Printf("Service started in the background as PID %d\n", pid)
+	fmt.Printf("Logging to %s\n", logPath)
+	fmt.Println("Check it with 'instance-manager service status' and stop it with 'instance-manager service stop'.")
+	return nil
+}
+
+// servicePIDFile returns the PID file of the service
+func servicePIDFile() string {
+	if pidFile != "" {
+		return pidFile
+	}
+	return localStoragePath() + ".pid"
+}
+
+func runServiceStop(cmd *cobra.Command, args []string) error {
+	pidPath := servicePIDFile()
+	pid, err := daemon.ReadPIDFile(pidPath)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("The service is not running (no PID file at %s).\n", pidPath)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !daemon.Running(pid) {
+		// Left behind by a service that crashed
+		if err := os.Remove(pidPath); err != nil {
+			return fmt.Errorf("failed to remove stale PID file: %w", err)
+		}
+		fmt.Printf("The service is not running; removed the stale PID file %s.\n", pidPath)
+		return nil
+	}
+
+	fmt.Printf("Stopping the service (PID %d)...\n", pid)
+	if err := daemon.Stop(pid, stopWait); err != nil {
+		return err
+	}
+	fmt.Println("Service stopped.")
+	return nil
+}
+
+func runServicePause(cmd *cobra.Command, args []string) error {
+	now := time.Now()
+	pause := &models.SchedulerPause{PausedAt: now, PausedBy: cliActor(), Reason: pauseReason}
+	if duration != "" {
+		d, err := utils.ParseDuration(duration)
+		if err != nil {
+			return fmt.Errorf("invalid duration: %w", err)
+		}
+		pause.Until = now.Add(d)
+	}
+
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	if err := store.PauseScheduler(pause); err != nil {
+		return fmt.Errorf("failed to pause the service: %w", err)
+	}
+
+	if pause.Until.IsZero() {
+		fmt.Println("Paused the service until service resume.")
+	} else {
+		fmt.Printf("Paused the service until %s.\n", pause.Until.Format(time.RFC3339))
+	}
+	fmt.Println("The service skips its checks from its next pass; actions already under way finish.")
+	return nil
+}
+
+func runServiceResume(cmd *cobra.Command, args []string) error {
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	resumed, err := store.ResumeScheduler()
+	if err != nil {
+		return fmt.Errorf("failed to resume the service: %w", err)
+	}
+	if !resumed {
+		fmt.Println("The service was not paused.")
+		return nil
+	}
+	fmt.Println("Resumed the service; it manages instances again from its next pass.")
+	return nil
+}
+
+func runServiceStatus(cmd *cobra.Command, args []string) error {
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	pidPath := servicePIDFile()
+	if pid, err := daemon.ReadPIDFile(pidPath); err == nil && daemon.Running(pid) {
+		fmt.Printf("Running as PID %d\n", pid)
+	} else if err == nil {
+		fmt.Printf("Not running (stale PID file %s)\n", pidPath)
+	}
+
+	pause, err := store.SchedulerPause()
+	if err != nil {
+		return fmt.Errorf("failed to read the service status: %w", err)
+	}
+	if pause != nil {
+		fmt.Printf("Paused since %s", pause.PausedAt.Format(time.RFC3339))
+		if pause.PausedBy != "" {
+			fmt.Printf(" by %s", pause.PausedBy)
+		}
+		if !pause.Until.IsZero() {
+			fmt.Printf(" until %s", pause.Until.Format(time.RFC3339))
+		}
+		if pause.Reason != "" {
+			fmt.Printf(": %s", pause.Reason)
+		}
+		fmt.Println()
+	}
+
+	run, err := store.LastSchedulerRun()
+	if err != nil {
+		return fmt.Errorf("failed to read the service status: %w", err)
+	}
+	if run == nil {
+		fmt.Println("The service has not checked instances yet.")
+		return nil
+	}
+
+	since := time.Since(run.StartedAt)
+	fmt.Printf("Last pass: %s (%s ago)\n", run.StartedAt.Format(time.RFC3339), utils.FormatDuration(since))
+	fmt.Printf("Duration: %s\n", run.Duration.Round(time.Millisecond))
+	fmt.Printf("Instances checked: %d\n", run.Instances)
+	if run.DryRun {
+		fmt.Println("Dry run: actions were only logged")
+	}
+	if len(run.Actions) == 0 {
+		fmt.Println("Actions: none")
+	} else {
+		actions := make([]string, 0, len(run.Actions))
+		for action := range run.Actions {
+			actions = append(actions, action)
+		}
+		sort.Strings(actions)
+		fmt.Println("Actions:")
+		for _, action := range actions {
+			fmt.Printf("  %s: %d\n", action, run.Actions[action])
+		}
+	}
+	fmt.Printf("Errors: %d\n", run.ErrorCount)
+	for _, msg := range run.Errors {
+		fmt.Printf("  %s\n", msg)
+	}
+	if len(run.Errors) < run.ErrorCount {
+		fmt.Printf("  ... and %d more\n", run.ErrorCount-len(run.Errors))
+	}
+
+	// Passes run every interval, so missing several means the service is
+	// down or standing by for another replica
+	schedulerConfig, err := config.LoadSchedulerConfig()
+	if err == nil && pause == nil && since > 3*schedulerConfig.Interval+run.Duration {
+		fmt.Printf("\nWarning: no pass for %s although the interval is %s; the service may not be running.\n",
+			utils.FormatDuration(since), schedulerConfig.Interval)
+	}
+	return nil
+}
+
+func runWeb(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := config.LoadConfigForProvider(provider)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Create provider based on flag
+	registry := newRegistry()
+	cloudProvider, err := registry.GetAccount(provider, account)
+	if err != nil {
+		return err
+	}
+	retry, err := retryPolicy(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Validate credentials
+	if err := retryDo(cmd, cloudProvider.ValidateCredentials); err != nil {
+		return fmt.Errorf("failed to validate %s credentials: %w", strings.ToUpper(provider), err)
+	}
+
+	// Create storage
+	storage, err := openStorage()
+	if err != nil {
+		return err
+	}
+
+	// Create logger
+	logger := logrus.New()
+	logger.SetLevel(getLogLevel(logLevel))
+	if verbose {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+	logger.SetOutput(os.Stdout)
+
+	// Create and start web server
+	webPort, _ := cmd.Flags().GetInt("port")
+	server := webserver.NewServer(cloudProvider, storage, logger, webPort)
+	server.SetRegistry(registry)
+	server.SetAccount(account)
+	switch provider {
+	case "gcp":
+		server.SetProvider(provider, []string{gcp.DefaultMachineType})
+	case "azure":
+		server.SetProvider(provider, []string{azure.DefaultVMSize})
+	case "digitalocean":
+		server.SetProvider(provider, digitalocean.Sizes)
+	case "hetzner":
+		server.SetProvider(provider, hetzner.ServerTypes)
+	case "vultr":
+		server.SetProvider(provider, vultr.Plans)
+	case "oci":
+		server.SetProvider(provider, oci.Shapes)
+	case "docker":
+		server.SetProvider(provider, docker.InstanceTypes)
+	case "libvirt":
+		server.SetProvider(provider, libvirt.InstanceTypes)
+	}
+	server.SetAllowedInstanceFamilies(cfg.AllowedInstanceFamilies)
+	server.SetMaxLifetime(cfg.MaxLifetime)
+	server.SetConnectionTemplate(cfg.ConnectionTemplate)
+	server.SetMetadataOptions(cfg.AWS.Metadata)
+	server.SetGracePeriod(cfg.Scheduler.GracePeriod)
+	server.SetSchedulerInterval(cfg.Scheduler.Interval)
+	server.SetDefaultExpiryAction(cfg.DefaultValues.ExpiryAction)
+	server.SetUserHeader(cfg.Web.UserHeader)
+	if err := server.SetTrustedProxies(cfg.Web.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid web.trusted_proxies: %w", err)
+	}
+	server.SetAdmins(cfg.Web.Admins)
+	server.SetRoles(cfg.Web.Roles, cfg.Web.DefaultRole)
+	server.SetRateLimits(webserver.RateLimits{
+		PerIP:          cfg.Web.RateLimit.PerIP,
+		PerToken:       cfg.Web.RateLimit.PerToken,
+		Changes:        cfg.Web.RateLimit.Changes,
+		ClientIPHeader: cfg.Web.RateLimit.ClientIPHeader,
+	})
+	keyring := apikey.NewKeyring(cfg.Web.APIKeys, apiKeysFile(cfg.Web))
+	server.SetAPIKeys(keyring)
+	accounts := userAccountsFile(cfg.Web)
+	sessions, err := userSessions(cfg.Web, accounts)
+	if err != nil {
+		return err
+	}
+	server.SetUserAccounts(accounts, sessions)
+	var identityProvider *sso.Provider
+	if ssoConfig := cfg.Web.SSO; ssoConfig.Provider != "" {
+		identityProvider, err = sso.New(sso.Config{
+			Kind:           ssoConfig.Provider,
+			Name:           ssoConfig.Name,
+			Issuer:         ssoConfig.Issuer,
+			ClientID:       ssoConfig.ClientID,
+			ClientSecret:   ssoConfig.ClientSecret,
+			RedirectURL:    ssoConfig.RedirectURL,
+			Scopes:         ssoConfig.Scopes,
+			UsernameClaim:  ssoConfig.UsernameClaim,
+			GroupsClaim:    ssoConfig.GroupsClaim,
+			AllowedGroups:  ssoConfig.AllowedGroups,
+			AllowedDomains: ssoConfig.AllowedDomains,
+			Roles:          ssoConfig.Roles,
+		})
+		if err != nil {
+			return fmt.Errorf("invalid web.sso: %w", err)
+		}
+		server.SetSSO(identityProvider)
+	}
+	if cmd.Flags().Changed("timeout") {
+		server.SetCallTimeout(callTimeout)
+	}
+	server.SetRetryPolicy(retry)
+	staticDir, _ := cmd.Flags().GetString("static-dir")
+	if err := server.SetStaticDir(staticDir); err != nil {
+		return err
+	}
+
+	fmt.Printf("AWS Instance Manager Web Server starting on http://localhost:%d\n", webPort)
+	fmt.Println("Open your browser and navigate to the address above.")
+	if keyring.Enabled() {
+		fmt.Println("API requests require an API key; see 'instance-manager keys'.")
+	}
+	if accounts.Exists() {
+		fmt.Println("Users sign in on the login page; see 'instance-manager users'.")
+	}
+	if identityProvider != nil {
+		fmt.Printf("Users sign in with %s on the login page.\n", identityProvider.Name())
+	}
+	fmt.Println("Press Ctrl+C to stop the server.")
+
+	served := make(chan error, 1)
+	go func() { served <- server.Start() }()
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Requests in flight, such as instances being created, finish first
+	fmt.Println("Stopping the web server...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), webShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to stop the web server gracefully: %w", err)
+	}
+	if err := <-served; err != nil {
+		return err
+	}
+	fmt.Println("Web server stopped.")
+	return nil
+}
+
+// apiKeysFile returns the file of the API keys managed with the keys command
+func apiKeysFile(webConfig config.WebConfig) *apikey.File {
+	if webConfig.APIKeysFile != "" {
+		return apikey.NewFile(webConfig.APIKeysFile)
+	}
+	return apikey.NewFile(filepath.Join(filepath.Dir(localStoragePath()), "api_keys.json"))
+}
+
+func runKeysCreate(cmd *cobra.Command, args []string) error {
+	webConfig, err := config.LoadWebConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if _, configured := webConfig.APIKeys[args[0]]; configured {
+		return fmt.Errorf("an API key named %s is already configured in web.api_keys", args[0])
+	}
+	file := apiKeysFile(webConfig)
+	key, err := file.Create(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created API key %s:\n\n  %s\n\n", args[0], key)
+	fmt.Println("Store it now; it cannot be shown again. Send it in API requests as:")
+	fmt.Printf("  Authorization: Bearer %s\n", key)
+	fmt.Printf("The web server requires a key on API requests from now on (keys kept in %s).\n", file.Path())
+	return nil
+}
+
+func runKeysList(cmd *cobra.Command, args []string) error {
+	webConfig, err := config.LoadWebConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	keys, err := apiKeysFile(webConfig).List()
+	if err != nil {
+		return err
+	}
+	configured := make([]string, 0, len(webConfig.APIKeys))
+	for name := range webConfig.APIKeys {
+		configured = append(configured, name)
+	}
+	sort.Strings(configured)
+
+	if len(keys) == 0 && len(configured) == 0 {
+		fmt.Println("No API keys found.")
+		return nil
+	}
+	fmt.Printf("API Keys:\n\n")
+	for _, key := range keys {
+		fmt.Printf("  %-24s created %s\n", key.Name, key.CreatedAt.Local().Format(time.RFC3339))
+	}
+	for _, name := range configured {
+		fmt.Printf("  %-24s configured in web.api_keys\n", name)
+	}
+	return nil
+}
+
+func runKeysRevoke(cmd *cobra.Command, args []string) error {
+	webConfig, err := config.LoadWebConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if err := apiKeysFile(webConfig).Revoke(args[0]); err != nil {
+		if _, configured := webConfig.APIKeys[args[0]]; configured {
+			return fmt.Errorf("API key %s is configured in web.api_keys; remove it there", args[0])
+		}
+		return err
+	}
+	fmt.Printf("API key %s has been revoked.\n", args[0])
+	return nil
+}
+
+// userAccountsFile returns the file of the users managed with the users
+// command
+func userAccountsFile(webConfig config.WebConfig) *users.File {
+	if webConfig.UsersFile != "" {
+		return users.NewFile(webConfig.UsersFile)
+	}
+	return users.NewFile(filepath.Join(filepath.Dir(localStoragePath()), "users.json"))
+}
+
+// userSessions returns the sessions of the users of file, signed with the
+// configured secret or else with the one kept next to the file
+func userSessions(webConfig config.WebConfig, file *users.File) (*users.Sessions, error) {
+	secret := []byte(webConfig.SessionSecret)
+	if len(secret) == 0 {
+		var err error
+		if secret, err = users.LoadSecret(filepath.Join(filepath.Dir(file.Path()), "session.key")); err != nil {
+			return nil, err
+		}
+	}
+	return users.NewSessions(file, secret, webConfig.SessionTTL), nil
+}
+
+// newPassword returns the password read from standard input with
+// --password-stdin, or else a generated one, reporting whether it was
+// generated
+func newPassword() (string, bool, error) {
+	if !passwordStdin {
+		password, err := users.GeneratePassword()
+		return password, true, err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false, fmt.Errorf("failed to read the password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), false, nil
+}
+
+func runUsersAdd(cmd *cobra.Command, args []string) error {
+	webConfig, err := config.LoadWebConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	password, generated, err := newPassword()
+	if err != nil {
+		return err
+	}
+	file := userAccountsFile(webConfig)
+	if err := file.Add(args[0], password); err != nil {
+		return err
+	}
+	fmt.Printf("Added user %s.\n", args[0])
+	if generated {
+		fmt.Printf("Their password is:\n\n  %s\n\n", password)
+		fmt.Println("Pass it on now; it cannot be shown again.")
+	}
+	fmt.Printf("The web server asks users to sign in from now on (users kept in %s).\n", file.Path())
+	return nil
+}
+
+func runUsersPasswd(cmd *cobra.Command, args []string) error {
+	webConfig, err := config.LoadWebConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	password, generated, err := newPassword()
+	if err != nil {
+		return err
 	}
+	if err := userAccountsFile(webConfig).SetPassword(args[0], password); err != nil {
+		return err
+	}
+	fmt.Printf("Changed the password of %s; their sessions have ended.\n", args[0])
+	if generated {
+		fmt.Printf("Their new password is:\n\n  %s\n\n", password)
+	}
+	return nil
+}
 
-	fmt.Printf("\n📊 Instance Status:\n")
-	fmt.Printf("   State: %s\n", instance.State)
-	fmt.Printf("   Launch Time: %s\n", instance.LaunchTime.Format("2006-01-02 15:04:05"))
-	fmt.Printf("   Duration: %s\n", utils.FormatDuration(instance.Duration))
-	fmt.Printf("   Expires At: %s\n", instance.ExpiresAt.Format("2006-01-02 15:04:05"))
+func runUsersList(cmd *cobra.Command, args []string) error {
+	webConfig, err := config.LoadWebConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	list, err := userAccountsFile(webConfig).List()
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		fmt.Println("No users found.")
+		return nil
+	}
+	fmt.Printf("Users:\n\n")
+	for _, account := range list {
+		fmt.Printf("  %-24s created %s, password changed %s\n", account.Name,
+			account.CreatedAt.Local().Format(time.RFC3339), account.UpdatedAt.Local().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func runUsersRemove(cmd *cobra.Command, args []string) error {
+	webConfig, err := config.LoadWebConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if err := userAccountsFile(webConfig).Remove(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("User %s has been removed.\n", args[0])
+	return nil
+}
+
+func runTerminate(cmd *cobra.Command, args []string) error {
+	instanceID, err := cmd.Flags().GetString("instance-id")
+	if err != nil {
+		return err
+	}
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	provider, err := instanceProvider(newRegistry(), store, instanceID)
+	if err != nil {
+		return err
+	}
+	if err := retryDo(cmd, provider.ValidateCredentials); err != nil {
+		return fmt.Errorf("failed to validate credentials: %w", err)
+	}
+	if snapshotVolume {
+		awsProvider, ok := provider.(*aws.Provider)
+		if !ok {
+			return fmt.Errorf("--snapshot-volume is only supported for AWS instances")
+		}
+		fmt.Printf("Snapshotting root volume of %s (waiting up to %s)...\n", instanceID, snapshotTimeout)
+		awsProvider.SetSnapshotWait(10*time.Second, snapshotTimeout)
+		// The snapshot wait is bounded by --snapshot-timeout rather than --timeout
+		snapshotID, err := awsProvider.SnapshotRootVolume(cmd.Context(), instanceID)
+		if snapshotID != "" {
+			record := &models.SnapshotRecord{
+				SnapshotID: snapshotID,
+				InstanceID: instanceID,
+				Region:     awsProvider.Region(),
+				CreatedAt:  time.Now(),
+			}
+			if err := store.RecordSnapshot(record); err != nil {
+				log.Printf("Warning: failed to record snapshot %s: %v", snapshotID, err)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to snapshot root volume, instance not terminated: %w", err)
+		}
+		fmt.Printf("Snapshot %s completed.\n", snapshotID)
+	}
+	fmt.Printf("Terminating instance %s...\n", instanceID)
+	err = retryDo(cmd, func(ctx context.Context) error { return provider.TerminateInstance(ctx, instanceID) })
+	if err != nil {
+		return fmt.Errorf("Failed to terminate instance: %w", err)
+	}
+	// Move the record to the archive
+	_ = storage.ArchiveTerminated(store, instanceID, cliActor(), time.Now())
+	fmt.Printf("Instance %s has been terminated and its record archived.\n", instanceID)
+	return nil
+}
+
+func runImage(cmd *cobra.Command, args []string) error {
+	storage, err := openStorage()
+	if err != nil {
+		return err
+	}
+	provider, err := instanceProvider(newRegistry(), storage, instanceID)
+	if err != nil {
+		return err
+	}
+	awsProvider, ok := provider.(*aws.Provider)
+	if !ok {
+		return fmt.Errorf("images are only supported for AWS instances")
+	}
+
+	ctx, cancel := callContext(cmd)
+	defer cancel()
+	record, err := awsProvider.CreateImage(ctx, instanceID, imageName)
+	if err != nil {
+		return err
+	}
+	if err := storage.RecordImage(record); err != nil {
+		log.Printf("Warning: failed to record image %s: %v", record.ImageID, err)
+	}
+
+	fmt.Printf("Image %s (%s) of %s is being created.\n", record.ImageID, record.Name, instanceID)
+	fmt.Printf("\nOnce it is available, recreate the instance with 'instance-manager create --from-image %s'\n", record.ImageID)
+	return nil
+}
+
+func runSnapshotsList(cmd *cobra.Command, args []string) error {
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	snapshots, err := store.ListSnapshots()
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	if instanceID != "" {
+		snapshots = slices.DeleteFunc(snapshots, func(snapshot *models.SnapshotRecord) bool {
+			return snapshot.InstanceID != instanceID
+		})
+	}
+
+	if len(snapshots) == 0 {
+		fmt.Println("No recorded snapshots found.")
+		return nil
+	}
+
+	fmt.Printf("Recorded Snapshots:\n\n")
+	for _, snapshot := range snapshots {
+		fmt.Printf("Snapshot ID: %s\n", snapshot.SnapshotID)
+		fmt.Printf("  Instance ID: %s\n", snapshot.InstanceID)
+		if snapshot.VolumeID != "" {
+			fmt.Printf("  Volume ID: %s\n", snapshot.VolumeID)
+		}
+		if snapshot.Region != "" {
+			fmt.Printf("  Region: %s\n", snapshot.Region)
+		}
+		fmt.Printf("  Created At: %s\n", snapshot.CreatedAt.Format(time.RFC3339))
+		fmt.Println()
+	}
+	return nil
+}
+
+func runArchived(cmd *cobra.Command, args []string) error {
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	archived, err := store.ListArchived()
+	if err != nil {
+		return fmt.Errorf("failed to list archived instances: %w", err)
+	}
+	if instanceID != "" {
+		archived = slices.DeleteFunc(archived, func(record *models.ArchivedInstance) bool {
+			return record.Instance.ID != instanceID && record.Instance.Name != instanceID
+		})
+	}
+
+	if len(archived) == 0 {
+		fmt.Println("No archived instances found.")
+		return nil
+	}
+
+	fmt.Printf("Archived Instances:\n\n")
+	for _, record := range archived {
+		fmt.Printf("Instance ID: %s\n", record.Instance.ID)
+		if record.Instance.Name != "" {
+			fmt.Printf("  Name: %s\n", record.Instance.Name)
+		}
+		fmt.Printf("  Instance Type: %s\n", record.Instance.InstanceType)
+		if record.Instance.Region != "" {
+			fmt.Printf("  Region: %s\n", record.Instance.Region)
+		}
+		fmt.Printf("  Launched At: %s\n", record.Instance.LaunchTime.Format(time.RFC3339))
+		if !record.Instance.StoppedAt.IsZero() {
+			fmt.Printf("  Stopped At: %s\n", record.Instance.StoppedAt.Format(time.RFC3339))
+		}
+		if !record.Instance.TerminatedAt.IsZero() {
+			fmt.Printf("  Terminated At: %s\n", record.Instance.TerminatedAt.Format(time.RFC3339))
+		}
+		fmt.Printf("  Reason: %s\n", record.Reason)
+		fmt.Printf("  Archived At: %s\n", record.ArchivedAt.Format(time.RFC3339))
+		fmt.Println()
+	}
+	return nil
+}
+
+func runPrune(cmd *cobra.Command, args []string) error {
+	schedulerConfig, err := config.LoadSchedulerConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	days := pruneDays
+	if !cmd.Flags().Changed("older-than-days") && schedulerConfig.Prune.TerminatedAfterDays > 0 {
+		days = schedulerConfig.Prune.TerminatedAfterDays
+	}
+	if days < 0 {
+		return fmt.Errorf("invalid --older-than-days: %d", days)
+	}
+	archive := archivePruned
+	if !cmd.Flags().Changed("archive") {
+		archive = schedulerConfig.Prune.Archive
+	}
+
+	storage, err := openStorage()
+	if err != nil {
+		return err
+	}
+	terminated, err := storage.GetTerminatedBefore(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return fmt.Errorf("failed to list terminated instances: %w", err)
+	}
+	if len(terminated) == 0 {
+		fmt.Printf("No instances terminated more than %d days ago.\n", days)
+		return nil
+	}
+
+	action := "Removed"
+	if archive {
+		action = "Archived"
+	}
+	if dryRun {
+		action = "Would remove"
+		if archive {
+			action = "Would archive"
+		}
+	}
+	for _, instance := range terminated {
+		if !dryRun {
+			if archive {
+				err = storage.ArchiveInstance(instance.ID, models.ArchiveReasonPruned)
+			} else {
+				err = storage.DeleteInstance(instance.ID)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to prune instance %s: %w", instance.ID, err)
+			}
+		}
+		name := instance.ID
+		if instance.Name != "" {
+			name += " (" + instance.Name + ")"
+		}
+		fmt.Printf("%s the record of %s\n", action, name)
+	}
+	return nil
+}
+
+func runBackup(cmd *cobra.Command, args []string) error {
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	key, err := backupEncryptionKey()
+	if err != nil {
+		return err
+	}
+	record, err := store.Snapshot()
+	if err != nil {
+		return fmt.Errorf("failed to read storage: %w", err)
+	}
+
+	out := backupOut
+	if out == "" {
+		out = "instance-manager-backup-" + time.Now().UTC().Format("20060102T150405Z") + ".tar.gz"
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if forceOverwrite {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(out, flags, 0600)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists (use --force to overwrite it)", out)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+	err = storage.WriteBackup(file, record, key)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out)
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	fmt.Printf("Backed up %d instances, %d archived records, %d snapshots and %d images from %s to %s\n",
+		len(record.Instances), len(record.Archived), len(record.Snapshots), len(record.Images), store.Location(), out)
+	if key != nil {
+		fmt.Println("The backup is encrypted with the storage encryption key.")
+	}
+	return nil
+}
+
+func runRestore(cmd *cobra.Command, args []string) error {
+	key, err := backupEncryptionKey()
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer file.Close()
+	backup, err := storage.ReadBackup(file, key)
+	if err != nil {
+		return fmt.Errorf("failed to read backup %s: %w", args[0], err)
+	}
+
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	if !forceOverwrite {
+		existing, err := store.ListInstances()
+		if err != nil {
+			return fmt.Errorf("failed to read storage: %w", err)
+		}
+		if len(existing) > 0 {
+			return fmt.Errorf("%s already holds %d instances (use --force to restore over them, replacing the records with the same IDs)", store.Location(), len(existing))
+		}
+	}
+	if err := storage.Restore(store, backup); err != nil {
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+
+	fmt.Printf("Restored %d instances, %d archived records, %d snapshots and %d images to %s\n",
+		len(backup.Instances), len(backup.Archived), len(backup.Snapshots), len(backup.Images), store.Location())
+	fmt.Println("Run 'instance-manager sync' to refresh their state from the cloud providers.")
+	return nil
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	format, err := storage.ExportFormatFor(exportFormat, exportOut)
+	if err != nil {
+		return err
+	}
+	filter := models.InstanceFilter{States: listStates, Owner: listOwner}
+	if filter.Tags, err = models.ParseTags(tagSpecs); err != nil {
+		return fmt.Errorf("invalid tag: %w", err)
+	}
+
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	instances, err := store.ListInstancesFiltered(filter)
+	if err != nil {
+		return fmt.Errorf("failed to read storage: %w", err)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+
+	if exportOut == "" {
+		return storage.ExportInstances(os.Stdout, instances, format)
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if forceOverwrite {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(exportOut, flags, 0600)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists (use --force to overwrite it)", exportOut)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create export: %w", err)
+	}
+	err = storage.ExportInstances(file, instances, format)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(exportOut)
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	fmt.Printf("Exported %d instances from %s to %s as %s\n", len(instances), store.Location(), exportOut, strings.ToUpper(format))
+	return nil
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	format, err := storage.ExportFormatFor(exportFormat, args[0])
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", args[0], err)
+	}
+	defer file.Close()
+	instances, err := storage.ImportInstances(file, format)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", args[0], err)
+	}
+
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	stored, err := store.ListInstances()
+	if err != nil {
+		return fmt.Errorf("failed to read storage: %w", err)
+	}
+	exists := make(map[string]bool, len(stored))
+	for _, instance := range stored {
+		exists[instance.ID] = true
+	}
+
+	imported, skipped := 0, 0
+	for _, instance := range instances {
+		if exists[instance.ID] && !forceOverwrite {
+			skipped++
+			continue
+		}
+		instance.Revision = 0
+		if err := store.SaveInstance(instance); err != nil {
+			return fmt.Errorf("failed to import instance %s: %w", instance.ID, err)
+		}
+		imported++
+	}
+
+	fmt.Printf("Imported %d instances to %s\n", imported, store.Location())
+	if skipped > 0 {
+		fmt.Printf("Skipped %d instances already in storage (use --force to replace them)\n", skipped)
+	}
+	if imported > 0 {
+		fmt.Println("Run 'instance-manager sync' to refresh their state from the cloud providers.")
+	}
+	return nil
+}
+
+func runSnapshotsDelete(cmd *cobra.Command, args []string) error {
+	awsProvider, storage, err := getProviderAndStorage(cmd)
+	if err != nil {
+		return err
+	}
+	snapshots, err := storage.ListSnapshots()
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	regions := make(map[string]string, len(snapshots))
+	for _, snapshot := range snapshots {
+		regions[snapshot.SnapshotID] = snapshot.Region
+	}
+
+	for _, snapshotID := range args {
+		region, ok := regions[snapshotID]
+		if !ok {
+			return fmt.Errorf("snapshot %s is not recorded; see 'instance-manager snapshots list'", snapshotID)
+		}
+		regional, err := awsProvider.ForRegion(region)
+		if err != nil {
+			return err
+		}
+		err = retryDo(cmd, func(ctx context.Context) error { return regional.DeleteSnapshot(ctx, snapshotID) })
+		if err != nil {
+			return err
+		}
+		if err := storage.DeleteSnapshot(snapshotID); err != nil {
+			log.Printf("Warning: failed to remove snapshot %s from storage: %v", snapshotID, err)
+		}
+		fmt.Printf("Snapshot %s has been deleted.\n", snapshotID)
+	}
+	return nil
+}
+
+func runTerminateSession(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	if err := utils.ValidateSession(name); err != nil {
+		return fmt.Errorf("invalid session: %w", err)
+	}
+
+	cloudProvider, err := newRegistry().GetAccount(provider, account)
+	if err != nil {
+		return err
+	}
+	if err := retryDo(cmd, cloudProvider.ValidateCredentials); err != nil {
+		return fmt.Errorf("failed to validate %s credentials: %w", strings.ToUpper(provider), err)
+	}
+	storage, err := openStorage()
+	if err != nil {
+		return err
+	}
+
+	instances, err := retryCall(cmd, func(ctx context.Context) ([]*models.Instance, error) {
+		return session.Instances(ctx, cloudProvider, name)
+	})
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		fmt.Printf("No managed instances found in session %s.\n", name)
+		return nil
+	}
 
-	if instance.IsExpired() {
-		fmt.Printf("   ⚠️  Status: EXPIRED\n")
-		fmt.Printf("   💡 Tip: This instance should be terminated automatically\n")
-	} else {
-		timeLeft := time.Until(instance.ExpiresAt)
-		fmt.Printf("   ⏳ Time Remaining: %s\n", utils.FormatDuration(timeLeft))
+	fmt.Printf("Instances in session %s:\n", name)
+	for _, instance := range instances {
+		fmt.Printf("  %s (%s, %s)\n", instance.ID, instance.InstanceType, instance.State)
+	}
 
-		if timeLeft < 10*time.Minute {
-			fmt.Printf("   ⚠️  Warning: Instance will expire soon!\n")
-			fmt.Printf("   💡 Extend with: instance-manager extend --instance-id %s --duration 1h\n", instance.ID)
+	if !assumeYes {
+		fmt.Printf("\nTerminate %d instance(s)? This cannot be undone. [y/N]: ", len(instances))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer != "y" && answer != "yes" {
+			fmt.Println("Aborted.")
+			return nil
 		}
 	}
 
-	// Show full connection string if available
-	connectionString := instance.GetConnectionString()
-	if connectionString != "" {
-		fmt.Printf("\n🚀 Quick Connect: %s\n", connectionString)
+	terminated, err := session.Terminate(cmd.Context(), cloudProvider, storage, instances, cliActor())
+	for _, id := range terminated {
+		fmt.Printf("Instance %s has been terminated and its record archived.\n", id)
 	}
-
-	fmt.Printf("\n📝 Storage Information:\n")
-	fmt.Printf("   📁 This data is persisted locally for future access\n")
-	fmt.Printf("   🔄 Run 'instance-manager service' for automatic lifecycle management\n")
+	return err
 }
 
-func runExtend(cmd *cobra.Command, args []string) error {
-	// Parse duration
-	parsedDuration, err := utils.ParseDuration(duration)
+func runOrphans(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadConfigForProvider(provider)
 	if err != nil {
-		return fmt.Errorf("invalid duration: %w", err)
+		return fmt.Errorf("failed to load configuration: %w", err)
 	}
-
-	// Create storage
-	storage := storage.NewFileStorage("")
-
-	// Get instance
-	instance, err := storage.GetInstance(instanceID)
+	cloudProvider, err := newRegistry().GetAccount(provider, account)
 	if err != nil {
-		return fmt.Errorf("failed to get instance: %w", err)
+		return err
+	}
+	storage, err := openStorage()
+	if err != nil {
+		return err
 	}
 
-	// Extend TTL
-	oldExpiresAt := instance.ExpiresAt
-	instance.ExpiresAt = instance.ExpiresAt.Add(parsedDuration)
-	instance.Duration = instance.Duration + parsedDuration
-
-	// Update storage
-	if err := storage.UpdateInstance(instance); err != nil {
-		return fmt.Errorf("failed to update instance: %w", err)
+	// Check every region of interest
+	checkRegions := regions
+	if len(checkRegions) == 0 {
+		accountCfg, err := cfg.AWS.ForAccount(account)
+		if err != nil {
+			return err
+		}
+		checkRegions = storedRegions(storage, provider, account, accountCfg.Region)
+	}
+	listed, err := retryCall(cmd, func(ctx context.Context) ([]*models.Instance, error) {
+		return cloud.ListInRegions(ctx, cloudProvider, checkRegions)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list instances: %w", err)
+	}
+	stored, err := storage.ListInstances()
+	if err != nil {
+		return fmt.Errorf("failed to list stored instances: %w", err)
 	}
 
-	fmt.Printf("Instance TTL extended successfully!\n")
-	fmt.Printf("  Instance ID: %s\n", instance.ID)
-	fmt.Printf("  Previous expiry: %s\n", oldExpiresAt.Format(time.RFC3339))
-	fmt.Printf("  New expiry: %s\n", instance.ExpiresAt.Format(time.RFC3339))
-	fmt.Printf("  Extended by: %s\n", utils.FormatDuration(parsedDuration))
+	orphans := models.FindOrphans(listed, stored)
+	if len(orphans) == 0 {
+		fmt.Println("No managed instances are missing from storage.")
+		return nil
+	}
 
-	// If the instance is currently stopped and the new TTL is in the future,
-	// let the user know that the service will restart it
-	if instance.State == "stopped" && instance.ExpiresAt.After(time.Now()) {
-		fmt.Printf("\nNote: Instance is currently stopped. The background service will automatically start it.\n")
-		fmt.Printf("To manually start the service: %s service --log-level info\n", os.Args[0])
+	fmt.Printf("Managed instances missing from storage:\n")
+	for _, orphan := range orphans {
+		expiry := "no recorded duration"
+		if !orphan.ExpiresAt.IsZero() {
+			expiry = "expires " + orphan.ExpiresAt.Format(time.RFC3339)
+		}
+		fmt.Printf("  %s (%s, %s, %s, launched %s, %s)\n",
+			orphan.ID, orphan.InstanceType, orphan.State, orphan.Region,
+			orphan.LaunchTime.Format(time.RFC3339), expiry)
 	}
 
-	return nil
+	switch {
+	case adoptOrphans:
+		now := time.Now()
+		for _, orphan := range orphans {
+			orphan.Adopt(provider, account, now)
+			orphan.RecordEvent(now, models.EventAdopted, cliActor(), "")
+			if err := storage.SaveInstance(orphan); err != nil {
+				return fmt.Errorf("failed to adopt instance %s: %w", orphan.ID, err)
+			}
+			fmt.Printf("Instance %s has been adopted and expires at %s.\n", orphan.ID, orphan.ExpiresAt.Format(time.RFC3339))
+		}
+		return nil
+	case terminateOrphans:
+		if !assumeYes {
+			fmt.Printf("\nTerminate %d instance(s)? This cannot be undone. [y/N]: ", len(orphans))
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			if answer != "y" && answer != "yes" {
+				fmt.Println("Aborted.")
+				return nil
+			}
+		}
+		var errs []error
+		for _, orphan := range orphans {
+			regionProvider, err := cloud.InRegion(cloudProvider, orphan.Region)
+			if err == nil {
+				err = retryDo(cmd, func(ctx context.Context) error { return regionProvider.TerminateInstance(ctx, orphan.ID) })
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to terminate instance %s: %w", orphan.ID, err))
+				continue
+			}
+			fmt.Printf("Instance %s has been terminated.\n", orphan.ID)
+		}
+		return errors.Join(errs...)
+	default:
+		fmt.Println("\nRun with --adopt to manage them or --terminate to terminate them.")
+		return nil
+	}
 }
 
-func runSync(cmd *cobra.Command, args []string) error {
-	// Get the instance ID from the flag
-	syncInstanceID, _ := cmd.Flags().GetString("instance-id")
-
-	// Load configuration
+func getProviderAndStorage(cmd *cobra.Command) (*aws.Provider, storage.Storage, error) {
 	cfg, err := config.LoadConfig()
 	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+		return nil, nil, fmt.Errorf("failed to load configuration: %w", err)
 	}
-
-	// Create AWS provider
-	provider, err := aws.NewProvider(cfg.AWS.Region, cfg.AWS.AccessKey, cfg.AWS.SecretKey)
+	accountCfg, err := cfg.AWS.ForAccount(account)
+	if err != nil {
+		return nil, nil, err
+	}
+	providerIface, err := newAWSProvider(accountCfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create AWS provider: %w", err)
+	}
+	provider, ok := providerIface.(*aws.Provider)
+	if !ok {
+		return nil, nil, fmt.Errorf("provider type assertion failed")
+	}
+	if err := retryDo(cmd, provider.ValidateCredentials); err != nil {
+		return nil, nil, fmt.Errorf("failed to validate AWS credentials: %w", err)
+	}
+	storage, err := openStorage()
 	if err != nil {
-		return fmt.Errorf("failed to create AWS provider: %w", err)
+		return nil, nil, err
 	}
+	return provider, storage, nil
+}
 
-	// Create storage
-	storage := storage.NewFileStorage("")
+func runRetag(cmd *cobra.Command, args []string) error {
+	// Each instance is retagged with the credentials of its account
+	registry := newRegistry()
+	storage, err := openStorage()
+	if err != nil {
+		return err
+	}
 
-	// Sync all instances if no specific ID is provided
-	if syncInstanceID == "" {
-		instances, err := storage.ListInstances()
+	var instances []*models.Instance
+	if instanceID != "" {
+		instance, err := storage.GetInstance(instanceID)
+		if err != nil {
+			return fmt.Errorf("failed to get instance: %w", err)
+		}
+		instances = append(instances, instance)
+	} else {
+		instances, err = storage.ListInstances()
 		if err != nil {
 			return fmt.Errorf("failed to list instances: %w", err)
 		}
+	}
 
-		for _, instance := range instances {
-			awsProvider, ok := provider.(*aws.Provider)
-			if !ok {
-				return fmt.Errorf("invalid provider type for sync operation")
-			}
-			if err := syncInstanceData(awsProvider, storage, instance.ID); err != nil {
-				log.Printf("Warning: failed to sync instance %s: %v", instance.ID, err)
-			}
+	for _, instance := range instances {
+		if instance.State == "terminated" {
+			continue
 		}
 
-		fmt.Println("Sync completed for all instances.")
-	} else {
-		// Sync specific instance
-		awsProvider, ok := provider.(*aws.Provider)
+		resolved, err := registry.ForInstance(instance)
+		if err != nil {
+			log.Printf("Warning: failed to retag instance %s: %v", instance.ID, err)
+			continue
+		}
+		awsProvider, ok := resolved.(*aws.Provider)
 		if !ok {
-			return fmt.Errorf("invalid provider type for sync operation")
+			fmt.Printf("Instance %s: skipped, retagging is only supported for AWS instances\n", instance.ID)
+			continue
 		}
-		if err := syncInstanceData(awsProvider, storage, syncInstanceID); err != nil {
-			return fmt.Errorf("failed to sync instance %s: %w", syncInstanceID, err)
+		added, err := retryCall(cmd, func(ctx context.Context) (map[string]string, error) {
+			return awsProvider.RetagInstance(ctx, instance, dryRun)
+		})
+		if err != nil {
+			log.Printf("Warning: failed to retag instance %s: %v", instance.ID, err)
+			continue
 		}
 
-		fmt.Printf("Sync completed for instance %s.\n", syncInstanceID)
+		if len(added) == 0 {
+			fmt.Printf("Instance %s: all tags present\n", instance.ID)
+			continue
+		}
+
+		action := "tagged"
+		if dryRun {
+			action = "would tag"
+		}
+		keys := make([]string, 0, len(added))
+		for key := range added {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("Instance %s: %s %s=%s\n", instance.ID, action, key, added[key])
+		}
 	}
 
 	return nil
 }
 
-func syncInstanceData(provider *aws.Provider, storage *storage.FileStorage, instanceID string) error {
-	// Get current instance data from AWS
-	currentData, err := provider.GetInstanceStatus(instanceID)
-	if err != nil {
-		return fmt.Errorf("failed to get instance status from AWS: %w", err)
-	}
-
-	// Get stored instance data
-	storedInstance, err := storage.GetInstance(instanceID)
-	if err != nil {
-		return fmt.Errorf("failed to get instance from storage: %w", err)
+func runConfigInit(cmd *cobra.Command, args []string) error {
+	path := configPath
+	if path == "" {
+		path = config.DefaultConfigPath()
 	}
 
-	// Update stored instance data with current data
-	storedInstance.PublicIP = currentData.PublicIP
-	storedInstance.PrivateIP = currentData.PrivateIP
-	storedInstance.State = currentData.State
-	// Note: Ready status is determined by PublicIP presence and state
-
-	// Update storage
-	if err := storage.UpdateInstance(storedInstance); err != nil {
-		return fmt.Errorf("failed to update instance in storage: %w", err)
+	if err := config.WriteStarterConfig(path, forceOverwrite); err != nil {
+		return err
 	}
 
-	fmt.Printf("Instance %s synced: PublicIP=%s, State=%s\n", instanceID, storedInstance.PublicIP, storedInstance.State)
+	fmt.Printf("Config file written to %s\n", path)
+	fmt.Println("Environment variables take precedence over values in the file.")
 	return nil
 }
 
-// getLogLevel parses log level string to logrus level
-func getLogLevel(level string) logrus.Level {
-	switch strings.ToLower(level) {
-	case "debug":
-		return logrus.DebugLevel
-	case "info":
-		return logrus.InfoLevel
-	case "warn", "warning":
-		return logrus.WarnLevel
-	case "error":
-		return logrus.ErrorLevel
-	default:
-		return logrus.InfoLevel
+func runDiag(cmd *cobra.Command, args []string) error {
+	opts := diag.Options{
+		Version: version,
 	}
-}
-
-func runService(cmd *cobra.Command, args []string) error {
-	// Load configuration
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+	if store, err := openStorage(); err != nil {
+		opts.ConfigError = err
+	} else {
+		opts.Storage = store
 	}
 
-	// Create AWS provider
-	provider, err := aws.NewProvider(cfg.AWS.Region, cfg.AWS.AccessKey, cfg.AWS.SecretKey)
+	cfg, err := config.LoadConfig()
 	if err != nil {
-		return fmt.Errorf("failed to create AWS provider: %w", err)
+		opts.ConfigError = err
+	} else {
+		opts.Config = cfg
+		provider, err := aws.NewProvider(cfg.AWS.Region, cfg.AWS.AccessKey, cfg.AWS.SecretKey)
+		if err != nil {
+			opts.ConfigError = err
+		} else {
+			opts.Validator = provider
+		}
 	}
 
-	// Validate credentials
-	if err := provider.ValidateCredentials(); err != nil {
-		return fmt.Errorf("failed to validate AWS credentials: %w", err)
+	output := diagOutput
+	if output == "" {
+		output = fmt.Sprintf("instance-manager-diag-%s.json", time.Now().Format("20060102-150405"))
 	}
 
-	// Create storage
-	storage := storage.NewFileStorage("")
-
-	// Create and configure scheduler
-	scheduler := scheduler.NewScheduler(provider, storage)
-
-	// Set log level
-	logLevelParsed := getLogLevel(logLevel)
-	if verbose {
-		logLevelParsed = logrus.DebugLevel
+	ctx, cancel := callContext(cmd)
+	defer cancel()
+	bundle := diag.Collect(ctx, opts)
+	if err := bundle.Write(output); err != nil {
+		return err
 	}
-	scheduler.SetLogLevel(logLevelParsed)
-
-	// Start scheduler
-	scheduler.Start()
-
-	fmt.Printf("Instance Manager service started (log level: %s)\n", logLevel)
-	fmt.Println("Monitoring instance lifecycle, TTL changes, and state management...")
-	fmt.Println("Press Ctrl+C to stop the service.")
 
-	// Wait for interrupt signal
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
-
-	scheduler.Stop()
-	fmt.Println("Service stopped.")
+	fmt.Printf("Diagnostics bundle written to %s\n", output)
+	fmt.Println("Secrets are redacted, but review the file before sharing it.")
 	return nil
 }
-func runWeb(cmd *cobra.Command, args []string) error {
-	// Load configuration
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
-	}
 
-	// Create AWS provider
-	provider, err := aws.NewProvider(cfg.AWS.Region, cfg.AWS.AccessKey, cfg.AWS.SecretKey)
+func runSGPreview(cmd *cobra.Command, args []string) error {
+	provider, _, err := getProviderAndStorage(cmd)
 	if err != nil {
-		return fmt.Errorf("failed to create AWS provider: %w", err)
+		return err
 	}
 
-	// Validate credentials
-	if err := provider.ValidateCredentials(); err != nil {
-		return fmt.Errorf("failed to validate AWS credentials: %w", err)
+	plan, err := retryCall(cmd, func(ctx context.Context) (*aws.SecurityGroupPlan, error) {
+		return provider.PreviewSecurityGroup(ctx, models.InstanceConfig{
+			OpenPorts:             openPorts,
+			SecurityGroupID:       securityGroupID,
+			SSHCIDRs:              sshCIDRs,
+			ExtraSecurityGroupIDs: attachGroupIDs,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to preview security group: %w", err)
 	}
 
-	// Create storage
-	storage := storage.NewFileStorage("")
+	printSecurityGroupPlan(plan)
+	return nil
+}
 
-	// Create logger
-	logger := logrus.New()
-	logger.SetLevel(getLogLevel(logLevel))
-	if verbose {
-		logger.SetLevel(logrus.DebugLevel)
+// printSecurityGroupPlan prints the security group and ingress rules a create would use
+func printSecurityGroupPlan(plan *aws.SecurityGroupPlan) {
+	switch {
+	case plan.Reused:
+		fmt.Printf("Security Group: %s (%s, existing group)\n", plan.GroupName, plan.GroupID)
+	case plan.GroupID != "":
+		fmt.Printf("Security Group: %s (%s, managed)\n", plan.GroupName, plan.GroupID)
+	default:
+		fmt.Printf("Security Group: %s (will be created)\n", plan.GroupName)
 	}
-	logger.SetOutput(os.Stdout)
-
-	// Create and start web server
-	webPort, _ := cmd.Flags().GetInt("port")
-	server := webserver.NewServer(provider, storage, logger, webPort)
 
-	fmt.Printf("AWS Instance Manager Web Server starting on http://localhost:%d\n", webPort)
-	fmt.Println("Open your browser and navigate to the address above.")
-	fmt.Println("Press Ctrl+C to stop the server.")
+	fmt.Printf("Ingress Rules:\n")
+	if len(plan.Rules) == 0 {
+		fmt.Printf("  (none)\n")
+	}
+	for _, rule := range plan.Rules {
+		fmt.Printf("  %s\n", rule)
+	}
 
-	return server.Start()
+	if len(plan.AttachedGroupIDs) > 0 {
+		fmt.Printf("Also Attached: %s\n", strings.Join(plan.AttachedGroupIDs, ", "))
+	}
 }
 
-func runTerminate(cmd *cobra.Command, args []string) error {
-	instanceID, err := cmd.Flags().GetString("instance-id")
-	if err != nil {
-		return err
-	}
-	provider, storage, err := getProviderAndStorage()
+func runSchedulePreview(cmd *cobra.Command, args []string) error {
+	storage, err := openStorage()
 	if err != nil {
 		return err
 	}
-	fmt.Printf("Terminating instance %s...\n", instanceID)
-	err = provider.TerminateInstance(instanceID)
+
+	instances, err := storage.ListInstances()
 	if err != nil {
-		return fmt.Errorf("Failed to terminate instance: %w", err)
+		return fmt.Errorf("failed to list instances: %w", err)
 	}
-	// Remove from storage
-	_ = storage.DeleteInstance(instanceID)
-	fmt.Printf("Instance %s has been terminated and removed from storage.\n", instanceID)
-	return nil
-}
 
-func getProviderAndStorage() (*aws.Provider, *storage.FileStorage, error) {
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to load configuration: %w", err)
+	// The grace period comes from the config file unless given as a flag
+	previewGrace := gracePeriod
+	if !cmd.Flags().Changed("grace-period") {
+		schedulerConfig, err := config.LoadSchedulerConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load configuration: %w", err)
+		}
+		previewGrace = schedulerConfig.GracePeriod
 	}
-	providerIface, err := aws.NewProvider(cfg.AWS.Region, cfg.AWS.AccessKey, cfg.AWS.SecretKey)
+
+	defaults, err := config.LoadDefaultValues()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create AWS provider: %w", err)
+		return fmt.Errorf("failed to load configuration: %w", err)
 	}
-	provider, ok := providerIface.(*aws.Provider)
-	if !ok {
-		return nil, nil, fmt.Errorf("provider type assertion failed")
+	actions := scheduler.PreviewSchedule(instances, time.Now(), scheduler.PreviewOptions{
+		GracePeriod:         previewGrace,
+		WarnBefore:          warnBefore,
+		DefaultExpiryAction: defaults.ExpiryAction,
+	})
+
+	if len(actions) == 0 {
+		fmt.Println("No upcoming scheduler actions.")
+		return nil
 	}
-	if err := provider.ValidateCredentials(); err != nil {
-		return nil, nil, fmt.Errorf("failed to validate AWS credentials: %w", err)
+
+	fmt.Printf("Upcoming Scheduler Actions:\n\n")
+	for _, action := range actions {
+		fmt.Printf("  %-20s %-8s at %s (in %s)\n",
+			action.InstanceID,
+			action.Action,
+			action.At.Format(time.RFC3339),
+			utils.FormatDuration(action.TimeUntil))
 	}
-	storage := storage.NewFileStorage("")
-	return provider, storage, nil
+
+	return nil
 }
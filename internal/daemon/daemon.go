@@ -0,0 +1,172 @@
+// Package daemon runs the service in the background: it detaches the
+// process from the terminal, manages its PID file and tells systemd when the
+// service is ready or stopping.
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChildEnv is set in the environment of the detached process, so it runs
+// the service in the foreground instead of detaching again
+const ChildEnv = "INSTANCE_MANAGER_DAEMON"
+
+// pollInterval is how often Start and Stop check on the detached process
+const pollInterval = 100 * time.Millisecond
+
+// IsChild reports whether this process was detached by Start
+func IsChild() bool {
+	return os.Getenv(ChildEnv) != ""
+}
+
+// Start runs the executable again with args in a new session, detached from
+// the terminal, with its output appended to logFile. It waits until the
+// detached process writes its PID to pidFile, which it does once it is
+// ready, and returns the PID. When the process is not ready within timeout
+// it keeps running and its PID is returned with an error.
+func Start(args []string, logFile, pidFile string, timeout time.Duration) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to find the executable: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(logFile), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create log file directory: %w", err)
+	}
+	log, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer log.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), ChildEnv+"=1")
+	cmd.Stdout = log
+	cmd.Stderr = log
+	if err := detach(cmd); err != nil {
+		return 0, err
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start the service: %w", err)
+	}
+	pid := cmd.Process.Pid
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case err := <-exited:
+			return 0, fmt.Errorf("the service exited during startup (%v); see %s", err, logFile)
+		case <-deadline:
+			return pid, fmt.Errorf("the service did not become ready within %s; see %s", timeout, logFile)
+		case <-ticker.C:
+			if written, err := ReadPIDFile(pidFile); err == nil && written == pid {
+				return pid, nil
+			}
+		}
+	}
+}
+
+// Stop asks the process with pid to shut down and waits up to wait for it
+// to exit
+func Stop(pid int, wait time.Duration) error {
+	if err := terminate(pid); err != nil {
+		return fmt.Errorf("failed to signal PID %d: %w", pid, err)
+	}
+
+	deadline := time.Now().Add(wait)
+	for Running(pid) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("PID %d is still running after %s", pid, wait)
+		}
+		time.Sleep(pollInterval)
+	}
+	return nil
+}
+
+// Running reports whether a process with pid exists
+func Running(pid int) bool {
+	return pid > 0 && processRunning(pid)
+}
+
+// WritePIDFile records the PID of this process in path. It fails when the
+// file names another process that is still running, so two services cannot
+// share a PID file.
+func WritePIDFile(path string) error {
+	if pid, err := ReadPIDFile(path); err == nil && pid != os.Getpid() && Running(pid) {
+		return fmt.Errorf("the service is already running as PID %d (%s)", pid, path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create PID file directory: %w", err)
+	}
+	// Write through a temporary file so readers never see a partial PID
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write PID file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write PID file: %w", err)
+	}
+	return nil
+}
+
+// ReadPIDFile returns the PID recorded in path
+func ReadPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid PID file %s", path)
+	}
+	return pid, nil
+}
+
+// RemovePIDFile removes path if it still records the PID of this process
+func RemovePIDFile(path string) error {
+	pid, err := ReadPIDFile(path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && pid != os.Getpid()) {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// Notify sends state, such as "READY=1" or "STOPPING=1", to the service
+// manager when it runs the service with Type=notify. It reports false
+// without error when the service was not started by one.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to the notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("failed to notify the service manager: %w", err)
+	}
+	return true, nil
+}
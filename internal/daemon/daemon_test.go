@@ -0,0 +1,97 @@
+package daemon_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"instance-manager/internal/daemon"
+)
+
+func TestPIDFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("processes cannot be signalled on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "run", "service.pid")
+
+	if err := daemon.WritePIDFile(path); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+	pid, err := daemon.ReadPIDFile(path)
+	if err != nil || pid != os.Getpid() {
+		t.Fatalf("Expected PID %d, got %d, %v", os.Getpid(), pid, err)
+	}
+	if !daemon.Running(pid) {
+		t.Error("Expected this process to be running")
+	}
+
+	// Another running process holds the file
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getppid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.WritePIDFile(path); err == nil {
+		t.Error("Expected writing a PID file held by a running process to fail")
+	}
+	if err := daemon.RemovePIDFile(path); err != nil {
+		t.Fatalf("Failed to remove PID file: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("Expected the PID file of another process to be kept")
+	}
+
+	// A stale file left behind by a crash is replaced
+	if err := os.WriteFile(path, []byte("999999999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.WritePIDFile(path); err != nil {
+		t.Fatalf("Expected a stale PID file to be replaced, got %v", err)
+	}
+	if err := daemon.RemovePIDFile(path); err != nil {
+		t.Fatalf("Failed to remove PID file: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the PID file to be removed, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("not a pid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := daemon.ReadPIDFile(path); err == nil {
+		t.Error("Expected an invalid PID file to be rejected")
+	}
+}
+
+func TestNotify(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unixgram sockets are not supported on Windows")
+	}
+
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := daemon.Notify("READY=1"); sent || err != nil {
+		t.Errorf("Expected no notification without NOTIFY_SOCKET, got %v, %v", sent, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	sent, err := daemon.Notify("READY=1")
+	if !sent || err != nil {
+		t.Fatalf("Expected the notification to be sent, got %v, %v", sent, err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read notification: %v", err)
+	}
+	if got := string(buf[:n]); got != "READY=1" {
+		t.Errorf("Expected READY=1, got %q", got)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package daemon
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// errDaemonUnsupported is returned where processes cannot be detached or
+// signalled
+var errDaemonUnsupported = errors.New("daemon mode is not supported on this platform; run the service under a service manager instead")
+
+// detach runs cmd in a new session, so it outlives the terminal that
+// started it
+func detach(cmd *exec.Cmd) error {
+	return errDaemonUnsupported
+}
+
+// processRunning reports whether a process with pid exists
+func processRunning(pid int) bool {
+	return false
+}
+
+// terminate asks the process with pid to shut down
+func terminate(pid int) error {
+	return errDaemonUnsupported
+}
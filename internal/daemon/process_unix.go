@@ -0,0 +1,27 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package daemon
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// detach runs cmd in a new session, so it outlives the terminal that
+// started it
+func detach(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	return nil
+}
+
+// processRunning reports whether a process with pid exists
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// terminate asks the process with pid to shut down
+func terminate(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
@@ -0,0 +1,325 @@
+package diag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/config"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/storage"
+)
+
+// redacted replaces secret values in the bundle
+const redacted = "[REDACTED]"
+
+// secretName matches the names of config fields and environment variables
+// holding credentials, such as API tokens, secret keys, passwords, OTLP
+// headers and webhook URLs, which often embed a token
+var secretName = regexp.MustCompile(`(?i)token|secret|key|password|passphrase|credential|headers|webhook`)
+
+// minSecretLength keeps short values, such as a flag set to "1", from being
+// scrubbed throughout the bundle
+const minSecretLength = 6
+
+// defaultMaxEvents is the number of recent events included when not set
+const defaultMaxEvents = 50
+
+// CredentialValidator checks cloud credentials
+type CredentialValidator interface {
+	ValidateCredentials(ctx context.Context) error
+}
+
+// Options controls what is collected into a diagnostics bundle
+type Options struct {
+	Version     string
+	Config      *config.Config
+	ConfigError error // Set when the configuration could not be loaded
+	Storage     storage.Storage
+	Validator   CredentialValidator // Optional; credentials are not checked when nil
+	MaxEvents   int
+}
+
+// Bundle is a secret-redacted snapshot of the tool's state for bug reports
+type Bundle struct {
+	GeneratedAt time.Time         `json:"generated_at"`
+	Version     VersionInfo       `json:"version"`
+	Config      ConfigSection     `json:"config"`
+	Storage     StorageSection    `json:"storage"`
+	Events      []Event           `json:"events"`
+	Credentials CredentialsResult `json:"credentials"`
+}
+
+// VersionInfo describes the tool build and runtime
+type VersionInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// ConfigSection holds the effective configuration with secrets redacted
+type ConfigSection struct {
+	AWSRegion               string   `json:"aws_region,omitempty"`
+	AWSAccessKey            string   `json:"aws_access_key,omitempty"`
+	AWSSecretKey            string   `json:"aws_secret_key,omitempty"`
+	GCPProject              string   `json:"gcp_project,omitempty"`
+	GCPZone                 string   `json:"gcp_zone,omitempty"`
+	AzureSubscriptionID     string   `json:"azure_subscription_id,omitempty"`
+	AzureResourceGroup      string   `json:"azure_resource_group,omitempty"`
+	DigitalOceanToken       string   `json:"digitalocean_token,omitempty"`
+	DigitalOceanRegion      string   `json:"digitalocean_region,omitempty"`
+	HetznerToken            string   `json:"hetzner_token,omitempty"`
+	HetznerLocation         string   `json:"hetzner_location,omitempty"`
+	VultrAPIKey             string   `json:"vultr_api_key,omitempty"`
+	VultrRegion             string   `json:"vultr_region,omitempty"`
+	OCICompartmentID        string   `json:"oci_compartment_id,omitempty"`
+	OCIRegion               string   `json:"oci_region,omitempty"`
+	DockerHost              string   `json:"docker_host,omitempty"`
+	WebhookURL              string   `json:"webhook_url,omitempty"`
+	ConsulToken             string   `json:"consul_token,omitempty"`
+	LibvirtURI              string   `json:"libvirt_uri,omitempty"`
+	DefaultInstanceType     string   `json:"default_instance_type,omitempty"`
+	DefaultDuration         string   `json:"default_duration,omitempty"`
+	DefaultAvailabilityZone string   `json:"default_availability_zone,omitempty"`
+	AllowedInstanceFamilies []string `json:"allowed_instance_families,omitempty"`
+	ConnectionTemplate      string   `json:"connection_template,omitempty"`
+	Error                   string   `json:"error,omitempty"`
+}
+
+// StorageSection holds the storage file contents
+type StorageSection struct {
+	Path     string                 `json:"path"`
+	Contents *storage.StorageRecord `json:"contents,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+}
+
+// Event is a recent change to a stored instance
+type Event struct {
+	Time       time.Time `json:"time"`
+	InstanceID string    `json:"instance_id"`
+	Event      string    `json:"event"`
+	State      string    `json:"current_state"` // State of the instance now, not at the time of the event
+}
+
+// CredentialsResult is the sanitized outcome of validating credentials
+type CredentialsResult struct {
+	Checked bool   `json:"checked"`
+	Valid   bool   `json:"valid"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Collect gathers a diagnostics bundle. Failures to collect a section are
+// recorded in the bundle rather than returned.
+func Collect(ctx context.Context, opts Options) *Bundle {
+	bundle := &Bundle{
+		GeneratedAt: time.Now().UTC(),
+		Version: VersionInfo{
+			Version:   opts.Version,
+			GoVersion: runtime.Version(),
+			Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		},
+		Config: redactConfig(opts.Config),
+	}
+
+	secrets := append(configSecrets(opts.Config), envSecrets(os.Environ())...)
+	if opts.ConfigError != nil {
+		bundle.Config.Error = scrub(opts.ConfigError.Error(), secrets)
+	}
+
+	if opts.Storage != nil {
+		bundle.Storage.Path = opts.Storage.Location()
+		contents, err := opts.Storage.Snapshot()
+		if err != nil {
+			bundle.Storage.Error = err.Error()
+		} else {
+			bundle.Storage.Contents = contents
+			bundle.Events = recentEvents(contents.Instances, opts.MaxEvents)
+		}
+	}
+
+	if opts.Validator != nil {
+		bundle.Credentials.Checked = true
+		if err := opts.Validator.ValidateCredentials(ctx); err != nil {
+			bundle.Credentials.Error = scrub(err.Error(), secrets)
+		} else {
+			bundle.Credentials.Valid = true
+		}
+	}
+
+	return bundle
+}
+
+// Write saves the bundle as JSON to path. The file is only readable by the owner.
+func (b *Bundle) Write(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal diagnostics bundle: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write diagnostics bundle: %w", err)
+	}
+	return nil
+}
+
+// redactConfig copies the configuration, masking credentials
+func redactConfig(cfg *config.Config) ConfigSection {
+	if cfg == nil {
+		return ConfigSection{}
+	}
+	return ConfigSection{
+		AWSRegion:               cfg.AWS.Region,
+		AWSAccessKey:            maskAccessKey(cfg.AWS.AccessKey),
+		AWSSecretKey:            maskSecret(cfg.AWS.SecretKey),
+		GCPProject:              cfg.GCP.Project,
+		GCPZone:                 cfg.GCP.Zone,
+		AzureSubscriptionID:     cfg.Azure.SubscriptionID,
+		AzureResourceGroup:      cfg.Azure.ResourceGroup,
+		DigitalOceanToken:       maskSecret(cfg.DigitalOcean.Token),
+		DigitalOceanRegion:      cfg.DigitalOcean.Region,
+		HetznerToken:            maskSecret(cfg.Hetzner.Token),
+		HetznerLocation:         cfg.Hetzner.Location,
+		VultrAPIKey:             maskSecret(cfg.Vultr.APIKey),
+		VultrRegion:             cfg.Vultr.Region,
+		OCICompartmentID:        cfg.OCI.CompartmentID,
+		OCIRegion:               cfg.OCI.Region,
+		DockerHost:              cfg.Docker.Host,
+		WebhookURL:              maskSecret(cfg.Notifications.WebhookURL),
+		ConsulToken:             maskSecret(cfg.Leader.ConsulToken),
+		LibvirtURI:              cfg.Libvirt.URI,
+		DefaultInstanceType:     cfg.DefaultValues.InstanceType,
+		DefaultDuration:         cfg.DefaultValues.Duration.String(),
+		DefaultAvailabilityZone: cfg.DefaultValues.AvailabilityZone,
+		AllowedInstanceFamilies: cfg.AllowedInstanceFamilies,
+		ConnectionTemplate:      cfg.ConnectionTemplate,
+	}
+}
+
+// maskAccessKey keeps the last four characters of an access key ID so that
+// the key in use can be identified without disclosing it
+func maskAccessKey(key string) string {
+	if len(key) <= 8 {
+		return maskSecret(key)
+	}
+	return redacted + key[len(key)-4:]
+}
+
+// maskSecret hides a secret entirely, recording only whether it was set
+func maskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// configSecrets returns the values of every string field of cfg, at any
+// depth, whose name marks it as a credential
+func configSecrets(cfg *config.Config) []string {
+	if cfg == nil {
+		return nil
+	}
+	var secrets []string
+	var walk func(name string, v reflect.Value)
+	walk = func(name string, v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				if v.Type().Field(i).IsExported() {
+					walk(v.Type().Field(i).Name, v.Field(i))
+				}
+			}
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < v.Len(); i++ {
+				walk(name, v.Index(i))
+			}
+		case reflect.Map:
+			for _, key := range v.MapKeys() {
+				walk(name, v.MapIndex(key))
+			}
+		case reflect.Pointer, reflect.Interface:
+			if !v.IsNil() {
+				walk(name, v.Elem())
+			}
+		case reflect.String:
+			if secretName.MatchString(name) {
+				secrets = append(secrets, v.String())
+			}
+		}
+	}
+	walk("", reflect.ValueOf(cfg).Elem())
+	return secrets
+}
+
+// envSecrets returns the values of the environment variables whose name
+// marks them as credentials, such as AZURE_CLIENT_SECRET or
+// OTEL_EXPORTER_OTLP_HEADERS. Header lists are also split into their values,
+// so a single header is scrubbed wherever it appears.
+func envSecrets(environ []string) []string {
+	var secrets []string
+	for _, entry := range environ {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || !secretName.MatchString(name) {
+			continue
+		}
+		secrets = append(secrets, value)
+		if strings.Contains(strings.ToLower(name), "headers") {
+			for _, header := range strings.Split(value, ",") {
+				if _, headerValue, ok := strings.Cut(header, "="); ok {
+					secrets = append(secrets, strings.TrimSpace(headerValue))
+				}
+			}
+		}
+	}
+	return secrets
+}
+
+// scrub removes any occurrence of the secrets from text
+func scrub(text string, secrets []string) string {
+	// Longer secrets go first, so one containing another is removed whole
+	sorted := append([]string(nil), secrets...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	for _, secret := range sorted {
+		if len(secret) >= minSecretLength {
+			text = strings.ReplaceAll(text, secret, redacted)
+		}
+	}
+	return text
+}
+
+// recentEvents derives creation and update events from the stored records,
+// newest first
+func recentEvents(records map[string]*models.InstanceRecord, limit int) []Event {
+	if limit <= 0 {
+		limit = defaultMaxEvents
+	}
+
+	var events []Event
+	for id, record := range records {
+		state := ""
+		if record.Instance != nil {
+			state = record.Instance.State
+		}
+		events = append(events, Event{Time: record.CreatedAt, InstanceID: id, Event: "created", State: state})
+		if record.UpdatedAt.After(record.CreatedAt) {
+			events = append(events, Event{Time: record.UpdatedAt, InstanceID: id, Event: "updated", State: state})
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Time.Equal(events[j].Time) {
+			return events[i].Time.After(events[j].Time)
+		}
+		return events[i].InstanceID < events[j].InstanceID
+	})
+
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events
+}
@@ -0,0 +1,35 @@
+// Package etcd connects to etcd clusters through the official v3 client,
+// configured alike for the storage backend and the leader election
+package etcd
+
+import (
+	"errors"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// NewClient returns a client of the cluster at endpoints, such as
+// http://127.0.0.1:2379, authenticating as username with password when it
+// is set. The client connects in the background and sends requests to the
+// endpoints it reaches, so one that is down is skipped. Endpoints with the
+// https scheme are reached over TLS.
+func NewClient(endpoints []string, username, password string) (*clientv3.Client, error) {
+	var cleaned []string
+	for _, endpoint := range endpoints {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			cleaned = append(cleaned, strings.TrimSuffix(endpoint, "/"))
+		}
+	}
+	if len(cleaned) == 0 {
+		return nil, errors.New("etcd: no endpoints")
+	}
+	return clientv3.New(clientv3.Config{
+		Endpoints: cleaned,
+		Username:  username,
+		Password:  password,
+		// Failures are returned to the caller, which logs them
+		Logger: zap.NewNop(),
+	})
+}
@@ -0,0 +1,552 @@
+// Package etcdtest runs an in-process etcd server for tests. It serves the
+// parts of the etcd v3 gRPC API that the storage backend and the leader
+// election use: ranges, puts, deletes, transactions, watches, leases and
+// password authentication, on a single member keeping its keys in memory.
+package etcdtest
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Server is an in-process etcd server. It is safe for concurrent use.
+type Server struct {
+	// URL is the client endpoint of the server, such as
+	// http://127.0.0.1:41234
+	URL string
+
+	mu         sync.Mutex
+	revision   int64
+	kvs        map[string]*mvccpb.KeyValue
+	events     []*mvccpb.Event // Every change, in order of revision
+	changed    chan struct{}   // Closed and replaced on every change
+	leases     map[int64]int64 // TTL of each lease by ID
+	nextLease  int64
+	keepAlives int                          // Renewals of leases that existed
+	password   string                       // Required for user root when set
+	beforeTxn  func()                       // Run before each transaction
+	watchers   map[int64]context.CancelFunc // Watches by ID
+	nextWatch  int64
+}
+
+// New starts a server that stops when the test ends
+func New(t testing.TB) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &Server{
+		URL: "http://" + listener.Addr().String(),
+		// etcd starts at revision 1, so writes start at 2
+		revision: 1,
+		kvs:      make(map[string]*mvccpb.KeyValue),
+		changed:  make(chan struct{}),
+		leases:   make(map[int64]int64),
+		watchers: make(map[int64]context.CancelFunc),
+	}
+	services := &services{s: s}
+	server := grpc.NewServer()
+	pb.RegisterKVServer(server, services)
+	pb.RegisterWatchServer(server, services)
+	pb.RegisterLeaseServer(server, services)
+	pb.RegisterAuthServer(server, services)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return s
+}
+
+// SetPassword requires clients to authenticate as root with password, or
+// lets any client in when it is empty
+func (s *Server) SetPassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+// SetBeforeTxn runs fn before each transaction, so tests can change keys
+// as another process would between a read and the transaction depending on
+// it
+func (s *Server) SetBeforeTxn(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.beforeTxn = fn
+}
+
+// Get returns a key, or nil when it does not exist
+func (s *Server) Get(key string) *mvccpb.KeyValue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kvs[key]
+}
+
+// Put sets the value of a key
+func (s *Server) Put(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.write(func(revision int64) bool {
+		s.put(revision, []byte(key), []byte(value), 0)
+		return true
+	})
+}
+
+// Expire ends all leases, deleting the keys attached to them, as etcd does
+// with leases that are not kept alive within their TTL
+func (s *Server) Expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range s.leases {
+		s.revoke(id)
+	}
+}
+
+// KeepAlives returns how often a lease was kept alive
+func (s *Server) KeepAlives() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keepAlives
+}
+
+// header returns the response header at the current revision. The caller
+// holds the lock.
+func (s *Server) header() *pb.ResponseHeader {
+	return &pb.ResponseHeader{ClusterId: 1, MemberId: 1, Revision: s.revision, RaftTerm: 1}
+}
+
+// authenticate checks the token of a request when a password is required
+func (s *Server) authenticate(ctx context.Context) error {
+	s.mu.Lock()
+	password := s.password
+	s.mu.Unlock()
+	if password == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get(rpctypes.TokenFieldNameGRPC)
+	switch {
+	case len(tokens) == 0:
+		return rpctypes.ErrGRPCUserEmpty
+	case tokens[0] != "token-"+password:
+		return rpctypes.ErrGRPCInvalidAuthToken
+	}
+	return nil
+}
+
+// write runs fn, whose changes all carry the next revision, and moves to
+// that revision when fn reports a change. The caller holds the lock.
+func (s *Server) write(fn func(revision int64) bool) {
+	if fn(s.revision + 1) {
+		s.revision++
+		close(s.changed)
+		s.changed = make(chan struct{})
+	}
+}
+
+// put sets a key at revision. The caller holds the lock.
+func (s *Server) put(revision int64, key, value []byte, lease int64) {
+	kv := &mvccpb.KeyValue{Key: key, Value: value, CreateRevision: revision, ModRevision: revision, Version: 1, Lease: lease}
+	if existing := s.kvs[string(key)]; existing != nil {
+		kv.CreateRevision = existing.CreateRevision
+		kv.Version = existing.Version + 1
+	}
+	s.kvs[string(key)] = kv
+	s.events = append(s.events, &mvccpb.Event{Type: mvccpb.PUT, Kv: kv})
+}
+
+// deleteRange deletes the keys of a range at revision, returning how many
+// there were. The caller holds the lock.
+func (s *Server) deleteRange(revision int64, key, end []byte) int64 {
+	var deleted int64
+	for _, kv := range s.rangeKeys(key, end) {
+		delete(s.kvs, string(kv.Key))
+		s.events = append(s.events, &mvccpb.Event{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: kv.Key, ModRevision: revision}})
+		deleted++
+	}
+	return deleted
+}
+
+// revoke ends a lease, deleting the keys attached to it. The caller holds
+// the lock.
+func (s *Server) revoke(id int64) {
+	delete(s.leases, id)
+	s.write(func(revision int64) bool {
+		var deleted int64
+		for _, kv := range s.rangeKeys([]byte{0}, []byte{0}) {
+			if kv.Lease == id {
+				deleted += s.deleteRange(revision, kv.Key, nil)
+			}
+		}
+		return deleted > 0
+	})
+}
+
+// rangeKeys returns the key, or the keys from key up to end when end is
+// set, sorted by key. An end of "\x00" means all keys from key on. The
+// caller holds the lock.
+func (s *Server) rangeKeys(key, end []byte) []*mvccpb.KeyValue {
+	var kvs []*mvccpb.KeyValue
+	for name, kv := range s.kvs {
+		if inRange([]byte(name), key, end) {
+			kvs = append(kvs, kv)
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool { return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0 })
+	return kvs
+}
+
+// inRange reports whether name is the key, or between key and end as
+// rangeKeys takes them
+func inRange(name, key, end []byte) bool {
+	switch {
+	case len(end) == 0:
+		return bytes.Equal(name, key)
+	case bytes.Equal(end, []byte{0}):
+		return bytes.Compare(name, key) >= 0
+	default:
+		return bytes.Compare(name, key) >= 0 && bytes.Compare(name, end) < 0
+	}
+}
+
+// rangeRequest answers a range request. The caller holds the lock.
+func (s *Server) rangeRequest(r *pb.RangeRequest) *pb.RangeResponse {
+	var kvs []*mvccpb.KeyValue
+	for _, kv := range s.rangeKeys(r.Key, r.RangeEnd) {
+		if (r.MaxCreateRevision == 0 || kv.CreateRevision <= r.MaxCreateRevision) &&
+			(r.MinCreateRevision == 0 || kv.CreateRevision >= r.MinCreateRevision) {
+			kvs = append(kvs, kv)
+		}
+	}
+	switch r.SortTarget {
+	case pb.RangeRequest_CREATE:
+		sort.SliceStable(kvs, func(i, j int) bool { return kvs[i].CreateRevision < kvs[j].CreateRevision })
+	case pb.RangeRequest_MOD:
+		sort.SliceStable(kvs, func(i, j int) bool { return kvs[i].ModRevision < kvs[j].ModRevision })
+	}
+	if r.SortOrder == pb.RangeRequest_DESCEND {
+		for i, j := 0, len(kvs)-1; i < j; i, j = i+1, j-1 {
+			kvs[i], kvs[j] = kvs[j], kvs[i]
+		}
+	}
+	count := int64(len(kvs))
+	more := false
+	if r.Limit > 0 && int64(len(kvs)) > r.Limit {
+		kvs, more = kvs[:r.Limit], true
+	}
+	return &pb.RangeResponse{Header: s.header(), Kvs: kvs, Count: count, More: more}
+}
+
+// compare evaluates a comparison of a transaction. The caller holds the
+// lock.
+func (s *Server) compare(c *pb.Compare) bool {
+	kv := s.kvs[string(c.Key)]
+	if kv == nil {
+		kv = &mvccpb.KeyValue{}
+	}
+	var result int
+	switch c.Target {
+	case pb.Compare_CREATE:
+		result = compareInt(kv.CreateRevision, c.GetCreateRevision())
+	case pb.Compare_MOD:
+		result = compareInt(kv.ModRevision, c.GetModRevision())
+	case pb.Compare_VERSION:
+		result = compareInt(kv.Version, c.GetVersion())
+	case pb.Compare_LEASE:
+		result = compareInt(kv.Lease, c.GetLease())
+	case pb.Compare_VALUE:
+		result = bytes.Compare(kv.Value, c.GetValue())
+	}
+	switch c.Result {
+	case pb.Compare_EQUAL:
+		return result == 0
+	case pb.Compare_NOT_EQUAL:
+		return result != 0
+	case pb.Compare_GREATER:
+		return result > 0
+	default:
+		return result < 0
+	}
+}
+
+// compareInt returns -1, 0 or 1 as a is less than, equal to or greater than b
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// services implements the gRPC services of a Server. Of the auth
+// service, only Authenticate is served.
+type services struct {
+	pb.UnimplementedAuthServer
+	s *Server
+}
+
+func (v *services) Range(ctx context.Context, r *pb.RangeRequest) (*pb.RangeResponse, error) {
+	if err := v.s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	v.s.mu.Lock()
+	defer v.s.mu.Unlock()
+	return v.s.rangeRequest(r), nil
+}
+
+func (v *services) Put(ctx context.Context, r *pb.PutRequest) (*pb.PutResponse, error) {
+	if err := v.s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	v.s.mu.Lock()
+	defer v.s.mu.Unlock()
+	if _, exists := v.s.leases[r.Lease]; r.Lease != 0 && !exists {
+		return nil, rpctypes.ErrGRPCLeaseNotFound
+	}
+	v.s.write(func(revision int64) bool {
+		v.s.put(revision, r.Key, r.Value, r.Lease)
+		return true
+	})
+	return &pb.PutResponse{Header: v.s.header()}, nil
+}
+
+func (v *services) DeleteRange(ctx context.Context, r *pb.DeleteRangeRequest) (*pb.DeleteRangeResponse, error) {
+	if err := v.s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	v.s.mu.Lock()
+	defer v.s.mu.Unlock()
+	var deleted int64
+	v.s.write(func(revision int64) bool {
+		deleted = v.s.deleteRange(revision, r.Key, r.RangeEnd)
+		return deleted > 0
+	})
+	return &pb.DeleteRangeResponse{Header: v.s.header(), Deleted: deleted}, nil
+}
+
+func (v *services) Txn(ctx context.Context, r *pb.TxnRequest) (*pb.TxnResponse, error) {
+	if err := v.s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	v.s.mu.Lock()
+	hook := v.s.beforeTxn
+	v.s.mu.Unlock()
+	if hook != nil {
+		hook()
+	}
+
+	v.s.mu.Lock()
+	defer v.s.mu.Unlock()
+	succeeded := true
+	for _, compare := range r.Compare {
+		succeeded = succeeded && v.s.compare(compare)
+	}
+	ops := r.Failure
+	if succeeded {
+		ops = r.Success
+	}
+	var responses []*pb.ResponseOp
+	v.s.write(func(revision int64) bool {
+		changed := false
+		for _, op := range ops {
+			switch request := op.Request.(type) {
+			case *pb.RequestOp_RequestRange:
+				responses = append(responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseRange{
+					ResponseRange: v.s.rangeRequest(request.RequestRange),
+				}})
+			case *pb.RequestOp_RequestPut:
+				put := request.RequestPut
+				v.s.put(revision, put.Key, put.Value, put.Lease)
+				changed = true
+				responses = append(responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponsePut{
+					ResponsePut: &pb.PutResponse{Header: v.s.header()},
+				}})
+			case *pb.RequestOp_RequestDeleteRange:
+				deleteRange := request.RequestDeleteRange
+				deleted := v.s.deleteRange(revision, deleteRange.Key, deleteRange.RangeEnd)
+				changed = changed || deleted > 0
+				responses = append(responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseDeleteRange{
+					ResponseDeleteRange: &pb.DeleteRangeResponse{Header: v.s.header(), Deleted: deleted},
+				}})
+			}
+		}
+		return changed
+	})
+	return &pb.TxnResponse{Header: v.s.header(), Succeeded: succeeded, Responses: responses}, nil
+}
+
+func (v *services) Compact(ctx context.Context, r *pb.CompactionRequest) (*pb.CompactionResponse, error) {
+	return nil, rpctypes.ErrGRPCNotCapable
+}
+
+// Watch streams the changes of the watched keys from their start revision
+// on, each watch in a goroutine of its own
+func (v *services) Watch(stream pb.Watch_WatchServer) error {
+	if err := v.s.authenticate(stream.Context()); err != nil {
+		return err
+	}
+	var sendMu sync.Mutex
+	send := func(resp *pb.WatchResponse) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return stream.Send(resp)
+	}
+	for {
+		request, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		switch r := request.RequestUnion.(type) {
+		case *pb.WatchRequest_CreateRequest:
+			ctx, cancel := context.WithCancel(stream.Context())
+			v.s.mu.Lock()
+			v.s.nextWatch++
+			id := v.s.nextWatch
+			v.s.watchers[id] = cancel
+			header := v.s.header()
+			v.s.mu.Unlock()
+			if err := send(&pb.WatchResponse{Header: header, WatchId: id, Created: true}); err != nil {
+				cancel()
+				return nil
+			}
+			go v.s.watch(ctx, id, r.CreateRequest, send)
+		case *pb.WatchRequest_CancelRequest:
+			v.s.mu.Lock()
+			cancel := v.s.watchers[r.CancelRequest.WatchId]
+			delete(v.s.watchers, r.CancelRequest.WatchId)
+			header := v.s.header()
+			v.s.mu.Unlock()
+			if cancel != nil {
+				cancel()
+				send(&pb.WatchResponse{Header: header, WatchId: r.CancelRequest.WatchId, Canceled: true})
+			}
+		}
+	}
+}
+
+// watch sends the events of a watch until ctx is done
+func (s *Server) watch(ctx context.Context, id int64, r *pb.WatchCreateRequest, send func(*pb.WatchResponse) error) {
+	s.mu.Lock()
+	next := r.StartRevision
+	if next == 0 {
+		next = s.revision + 1
+	}
+	s.mu.Unlock()
+	for {
+		s.mu.Lock()
+		var events []*mvccpb.Event
+		for _, event := range s.events {
+			if event.Kv.ModRevision >= next && inRange(event.Kv.Key, r.Key, r.RangeEnd) {
+				events = append(events, event)
+			}
+		}
+		changed := s.changed
+		header := s.header()
+		s.mu.Unlock()
+
+		if len(events) > 0 {
+			if err := send(&pb.WatchResponse{Header: header, WatchId: id, Events: events}); err != nil {
+				return
+			}
+			next = header.Revision + 1
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+func (v *services) LeaseGrant(ctx context.Context, r *pb.LeaseGrantRequest) (*pb.LeaseGrantResponse, error) {
+	if err := v.s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	v.s.mu.Lock()
+	defer v.s.mu.Unlock()
+	v.s.nextLease++
+	v.s.leases[v.s.nextLease] = r.TTL
+	return &pb.LeaseGrantResponse{Header: v.s.header(), ID: v.s.nextLease, TTL: r.TTL}, nil
+}
+
+func (v *services) LeaseRevoke(ctx context.Context, r *pb.LeaseRevokeRequest) (*pb.LeaseRevokeResponse, error) {
+	if err := v.s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	v.s.mu.Lock()
+	defer v.s.mu.Unlock()
+	if _, exists := v.s.leases[r.ID]; !exists {
+		return nil, rpctypes.ErrGRPCLeaseNotFound
+	}
+	v.s.revoke(r.ID)
+	return &pb.LeaseRevokeResponse{Header: v.s.header()}, nil
+}
+
+// LeaseKeepAlive answers each renewal with the TTL of the lease, or zero
+// when it no longer exists
+func (v *services) LeaseKeepAlive(stream pb.Lease_LeaseKeepAliveServer) error {
+	if err := v.s.authenticate(stream.Context()); err != nil {
+		return err
+	}
+	for {
+		request, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		v.s.mu.Lock()
+		ttl, exists := v.s.leases[request.ID]
+		if exists {
+			v.s.keepAlives++
+		}
+		header := v.s.header()
+		v.s.mu.Unlock()
+		if err := stream.Send(&pb.LeaseKeepAliveResponse{Header: header, ID: request.ID, TTL: ttl}); err != nil {
+			return nil
+		}
+	}
+}
+
+func (v *services) LeaseTimeToLive(ctx context.Context, r *pb.LeaseTimeToLiveRequest) (*pb.LeaseTimeToLiveResponse, error) {
+	if err := v.s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	v.s.mu.Lock()
+	defer v.s.mu.Unlock()
+	ttl, exists := v.s.leases[r.ID]
+	if !exists {
+		ttl = -1
+	}
+	return &pb.LeaseTimeToLiveResponse{Header: v.s.header(), ID: r.ID, TTL: ttl, GrantedTTL: ttl}, nil
+}
+
+func (v *services) LeaseLeases(ctx context.Context, r *pb.LeaseLeasesRequest) (*pb.LeaseLeasesResponse, error) {
+	if err := v.s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	v.s.mu.Lock()
+	defer v.s.mu.Unlock()
+	resp := &pb.LeaseLeasesResponse{Header: v.s.header()}
+	for id := range v.s.leases {
+		resp.Leases = append(resp.Leases, &pb.LeaseStatus{ID: id})
+	}
+	return resp, nil
+}
+
+// Authenticate hands out a token to root with the password
+func (v *services) Authenticate(ctx context.Context, r *pb.AuthenticateRequest) (*pb.AuthenticateResponse, error) {
+	v.s.mu.Lock()
+	defer v.s.mu.Unlock()
+	if r.Name != "root" || r.Password != v.s.password {
+		return nil, rpctypes.ErrGRPCAuthFailed
+	}
+	return &pb.AuthenticateResponse{Header: v.s.header(), Token: "token-" + v.s.password}, nil
+}
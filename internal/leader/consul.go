@@ -0,0 +1,190 @@
+package leader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errSessionGone is returned when Consul no longer knows the session,
+// typically because it was not renewed within its TTL
+var errSessionGone = errors.New("consul session expired")
+
+// ConsulElector elects the replica that holds a Consul KV key through a
+// session. Consul releases the key when the session is not renewed within
+// its TTL, so a crashed leader is replaced within about twice the TTL.
+type ConsulElector struct {
+	address    string
+	token      string
+	key        string
+	ttl        time.Duration
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	session string // ID of this replica's Consul session, empty before the first campaign
+}
+
+// NewConsulElector creates an elector that competes for key through the
+// Consul agent at address (e.g. "http://127.0.0.1:8500"). token is the ACL
+// token sent with each request and may be empty. ttl must be between 10s
+// and 24h, as required by Consul, and longer than the scheduler interval.
+func NewConsulElector(address, token, key string, ttl time.Duration) *ConsulElector {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return &ConsulElector{
+		address:    strings.TrimSuffix(address, "/"),
+		token:      token,
+		key:        strings.Trim(key, "/"),
+		ttl:        ttl,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Campaign renews this replica's session, creating a new one when it
+// expired, and tries to acquire the key with it. Acquiring a key already
+// held by the session succeeds, so the leader keeps it.
+func (e *ConsulElector) Campaign(ctx context.Context) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.session != "" {
+		if err := e.renew(ctx); err != nil && !errors.Is(err, errSessionGone) {
+			return false, err
+		}
+	}
+	if e.session == "" {
+		request := map[string]string{
+			"Name":     "instance-manager",
+			"TTL":      e.ttl.String(),
+			"Behavior": "release",
+		}
+		var created struct {
+			ID string `json:"ID"`
+		}
+		if err := e.do(ctx, http.MethodPut, "/v1/session/create", request, &created); err != nil {
+			return false, fmt.Errorf("failed to create consul session: %w", err)
+		}
+		e.session = created.ID
+	}
+
+	var acquired bool
+	path := "/v1/kv/" + e.key + "?acquire=" + url.QueryEscape(e.session)
+	if err := e.do(ctx, http.MethodPut, path, holder(), &acquired); err != nil {
+		return false, fmt.Errorf("failed to acquire consul key %s: %w", e.key, err)
+	}
+	return acquired, nil
+}
+
+// Hold renews the session every third of its TTL until ctx is done, so the
+// key is kept through scheduler passes that outlast the TTL. It returns the
+// error of the first renewal that fails.
+func (e *ConsulElector) Hold(ctx context.Context) error {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		e.mu.Lock()
+		err := e.renew(ctx)
+		e.mu.Unlock()
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+	}
+}
+
+// renew renews this replica's session, forgetting it when Consul no longer
+// knows it. The caller holds e.mu.
+func (e *ConsulElector) renew(ctx context.Context) error {
+	if e.session == "" {
+		return errSessionGone
+	}
+	err := e.do(ctx, http.MethodPut, "/v1/session/renew/"+e.session, nil, nil)
+	if errors.Is(err, errSessionGone) {
+		e.session = ""
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to renew consul session: %w", err)
+	}
+	return nil
+}
+
+// Resign releases the key and destroys the session, so another replica can
+// acquire the key without waiting for the TTL
+func (e *ConsulElector) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.session == "" {
+		return nil
+	}
+	session := e.session
+	e.session = ""
+
+	path := "/v1/kv/" + e.key + "?release=" + url.QueryEscape(session)
+	if err := e.do(ctx, http.MethodPut, path, holder(), nil); err != nil {
+		return fmt.Errorf("failed to release consul key %s: %w", e.key, err)
+	}
+	if err := e.do(ctx, http.MethodPut, "/v1/session/destroy/"+session, nil, nil); err != nil {
+		return fmt.Errorf("failed to destroy consul session: %w", err)
+	}
+	return nil
+}
+
+// do sends a request to the Consul HTTP API and decodes the JSON response
+// into result when it is not nil. Strings are sent as raw bodies, other
+// bodies as JSON.
+func (e *ConsulElector) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(body)
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, e.address+path, reader)
+	if err != nil {
+		return err
+	}
+	if e.token != "" {
+		req.Header.Set("X-Consul-Token", e.token)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && strings.HasPrefix(path, "/v1/session/renew/") {
+		return errSessionGone
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("consul returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,213 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"instance-manager/internal/etcd"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// EtcdElector elects a replica through an etcd election under a key
+// prefix. Each replica campaigns with a key attached to the lease of its
+// session, and the one whose key was created first leads. The session keeps
+// the lease alive in the background; etcd deletes the key when it is not
+// kept alive within its TTL, so a crashed leader is replaced within about
+// the TTL.
+type EtcdElector struct {
+	client *clientv3.Client
+	key    string
+	ttl    time.Duration
+
+	mu       sync.Mutex
+	session  *concurrency.Session // nil before the first campaign
+	election *concurrency.Election
+	cancel   context.CancelFunc // Ends the campaign
+	result   chan error         // Receives the outcome of the campaign, then closes
+	elected  bool               // Whether the campaign ended with this replica elected
+}
+
+// NewEtcdElector creates an elector that competes for key through the etcd
+// cluster at endpoints, authenticating as username when it is set. ttl must
+// be longer than the scheduler interval.
+func NewEtcdElector(endpoints []string, username, password, key string, ttl time.Duration) (*EtcdElector, error) {
+	client, err := etcd.NewClient(endpoints, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return &EtcdElector{client: client, key: key, ttl: ttl}, nil
+}
+
+// Campaign starts a session and campaigns with it unless it already did,
+// and reports whether this replica's key is the first of the election. A
+// replica whose session ended, or whose key was deleted with its lease,
+// campaigns again with a new session.
+func (e *EtcdElector) Campaign(ctx context.Context) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.session != nil {
+		select {
+		case <-e.session.Done():
+			e.stop()
+		default:
+		}
+	}
+	if e.session == nil {
+		if err := e.start(ctx); err != nil {
+			return false, err
+		}
+	}
+
+	leading, err := e.leads(ctx)
+	if err != nil || leading || !e.elected {
+		return leading, err
+	}
+	// Elected, but the key is gone with the lease: campaign anew
+	e.stop()
+	if err := e.start(ctx); err != nil {
+		return false, err
+	}
+	return e.leads(ctx)
+}
+
+// start opens a session and campaigns with it in the background. The
+// lease is granted within ctx, as the session keeps it alive beyond. The
+// caller holds e.mu.
+func (e *EtcdElector) start(ctx context.Context) error {
+	ttl := int64(math.Ceil(e.ttl.Seconds()))
+	lease, err := e.client.Grant(ctx, ttl)
+	if err != nil {
+		return fmt.Errorf("failed to grant etcd lease: %w", err)
+	}
+	session, err := concurrency.NewSession(e.client, concurrency.WithTTL(int(ttl)), concurrency.WithLease(lease.ID))
+	if err != nil {
+		return fmt.Errorf("failed to open etcd session: %w", err)
+	}
+	campaignCtx, cancel := context.WithCancel(context.Background())
+	e.session = session
+	e.election = concurrency.NewElection(session, e.key)
+	e.cancel = cancel
+	e.result = make(chan error, 1)
+	e.elected = false
+
+	// The result is closed once sent, so stop need not know whether it was
+	// received
+	election, result := e.election, e.result
+	go func() {
+		result <- election.Campaign(campaignCtx, holder())
+		close(result)
+	}()
+	return nil
+}
+
+// leads reports whether this replica leads. Until its campaign ends, it
+// waits for the first key of the election, which ends the campaign at once
+// when it is this replica's. The caller holds e.mu.
+func (e *EtcdElector) leads(ctx context.Context) (bool, error) {
+	lease := int64(e.session.Lease())
+	if !e.elected {
+		observeCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		select {
+		case err := <-e.result:
+			if err := e.campaigned(err); err != nil {
+				return false, err
+			}
+		case leader, ok := <-e.election.Observe(observeCtx):
+			if !ok {
+				if err := ctx.Err(); err != nil {
+					return false, err
+				}
+				return false, fmt.Errorf("failed to observe etcd election %s", e.key)
+			}
+			if leader.Kvs[0].Lease != lease {
+				return false, nil
+			}
+			select {
+			case err := <-e.result:
+				if err := e.campaigned(err); err != nil {
+					return false, err
+				}
+			case <-ctx.Done():
+				return false, ctx.Err()
+			}
+		}
+	}
+
+	resp, err := e.election.Leader(ctx)
+	if errors.Is(err, concurrency.ErrElectionNoLeader) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read etcd election %s: %w", e.key, err)
+	}
+	return resp.Kvs[0].Lease == lease, nil
+}
+
+// campaigned records the outcome of the campaign, ending the session when
+// it failed. The caller holds e.mu.
+func (e *EtcdElector) campaigned(err error) error {
+	if err != nil {
+		e.stop()
+		return fmt.Errorf("failed to campaign for etcd election %s: %w", e.key, err)
+	}
+	e.elected = true
+	return nil
+}
+
+// stop ends the campaign and closes the session, which revokes its lease
+// and so deletes this replica's key. The caller holds e.mu.
+func (e *EtcdElector) stop() error {
+	e.cancel()
+	<-e.result
+	err := e.session.Close()
+	e.session, e.election = nil, nil
+	if err != nil && !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		return fmt.Errorf("failed to revoke etcd lease: %w", err)
+	}
+	return nil
+}
+
+// Hold waits until ctx is done while the session keeps the lease alive. It
+// returns an error once the session ends, as etcd then deletes the key.
+func (e *EtcdElector) Hold(ctx context.Context) error {
+	e.mu.Lock()
+	session := e.session
+	e.mu.Unlock()
+	if session == nil {
+		return errors.New("no etcd session")
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-session.Done():
+		return fmt.Errorf("etcd session of lease %x ended", session.Lease())
+	}
+}
+
+// Resign deletes the key of the leader, or ends the campaign of another
+// replica, and revokes the lease, so another replica can take over without
+// waiting for the TTL
+func (e *EtcdElector) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.session == nil {
+		return nil
+	}
+	if e.elected {
+		if err := e.election.Resign(ctx); err != nil {
+			return fmt.Errorf("failed to resign from etcd election %s: %w", e.key, err)
+		}
+	}
+	return e.stop()
+}
@@ -0,0 +1,78 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileElector elects the replica that holds an exclusive lock on a file.
+// The operating system releases the lock when the process exits, so a
+// crashed leader is replaced on the next pass of another replica. It suits
+// replicas on one host or sharing a file system that supports locks.
+type FileElector struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File // Open while this replica holds the lock
+}
+
+// NewFileElector creates an elector that locks the file at path, creating
+// it when missing
+func NewFileElector(path string) *FileElector {
+	return &FileElector{path: path}
+}
+
+// Campaign takes the lock if no other replica holds it
+func (e *FileElector) Campaign(ctx context.Context) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.file != nil {
+		return true, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(e.path), 0755); err != nil {
+		return false, fmt.Errorf("failed to create lock file directory: %w", err)
+	}
+	file, err := os.OpenFile(e.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	locked, err := tryLock(file)
+	if err != nil || !locked {
+		file.Close()
+		if err != nil {
+			return false, fmt.Errorf("failed to lock %s: %w", e.path, err)
+		}
+		return false, nil
+	}
+
+	// Record the holder for operators; the lock itself is what counts
+	if err := file.Truncate(0); err == nil {
+		_, _ = file.WriteAt([]byte(holder()+"\n"), 0)
+	}
+	e.file = file
+	return true, nil
+}
+
+// Resign releases the lock if this replica holds it
+func (e *FileElector) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.file == nil {
+		return nil
+	}
+	err := unlock(e.file)
+	if closeErr := e.file.Close(); err == nil {
+		err = closeErr
+	}
+	e.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to unlock %s: %w", e.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Elector decides which of several service replicas manages the instances
+type Elector interface {
+	// Campaign acquires or renews leadership and reports whether this
+	// replica is the leader. It is called before every scheduler pass.
+	Campaign(ctx context.Context) (bool, error)
+	// Resign gives up leadership so another replica can take over at once
+	Resign(ctx context.Context) error
+}
+
+// Holder is implemented by electors whose leadership lapses unless it is
+// kept alive while the leader works, such as Consul sessions with a TTL
+type Holder interface {
+	// Hold keeps leadership alive until ctx is done. It returns early with
+	// an error once leadership can no longer be kept, as another replica
+	// may then take over.
+	Hold(ctx context.Context) error
+}
+
+// holder identifies this replica in the lock it holds, for operators
+// wondering which host is the leader
+func holder() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s (pid %d)", host, os.Getpid())
+}
@@ -0,0 +1,248 @@
+package leader_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"instance-manager/internal/etcd/etcdtest"
+	"instance-manager/internal/leader"
+)
+
+func TestFileElector(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "service.lock")
+	first := leader.NewFileElector(path)
+	second := leader.NewFileElector(path)
+
+	if leading, err := first.Campaign(ctx); err != nil || !leading {
+		t.Fatalf("Expected the first replica to lead, got %v, %v", leading, err)
+	}
+	if leading, err := first.Campaign(ctx); err != nil || !leading {
+		t.Fatalf("Expected the leader to keep the lock, got %v, %v", leading, err)
+	}
+	if leading, err := second.Campaign(ctx); err != nil || leading {
+		t.Fatalf("Expected the second replica to stand by, got %v, %v", leading, err)
+	}
+
+	if err := first.Resign(ctx); err != nil {
+		t.Fatalf("Resign failed: %v", err)
+	}
+	if leading, err := second.Campaign(ctx); err != nil || !leading {
+		t.Errorf("Expected the second replica to take over, got %v, %v", leading, err)
+	}
+}
+
+// fakeConsul implements the session and KV lock endpoints of the Consul API
+type fakeConsul struct {
+	mu       sync.Mutex
+	sessions map[string]bool
+	holder   string // Session holding the key
+	nextID   int
+	renewals int
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/v1/session/create":
+		c.nextID++
+		id := fmt.Sprintf("session-%d", c.nextID)
+		c.sessions[id] = true
+		json.NewEncoder(w).Encode(map[string]string{"ID": id})
+	case strings.HasPrefix(r.URL.Path, "/v1/session/renew/"):
+		if !c.sessions[strings.TrimPrefix(r.URL.Path, "/v1/session/renew/")] {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		c.renewals++
+		w.Write([]byte("[]"))
+	case strings.HasPrefix(r.URL.Path, "/v1/session/destroy/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/session/destroy/")
+		delete(c.sessions, id)
+		if c.holder == id {
+			c.holder = ""
+		}
+		w.Write([]byte("true"))
+	case r.URL.Path == "/v1/kv/instance-manager/leader":
+		if session := r.URL.Query().Get("acquire"); session != "" {
+			acquired := c.sessions[session] && (c.holder == "" || c.holder == session)
+			if acquired {
+				c.holder = session
+			}
+			json.NewEncoder(w).Encode(acquired)
+			return
+		}
+		if c.holder == r.URL.Query().Get("release") {
+			c.holder = ""
+		}
+		w.Write([]byte("true"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// renewed returns how often a session was renewed
+func (c *fakeConsul) renewed() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.renewals
+}
+
+// expire invalidates all sessions, as Consul does when they are not renewed
+func (c *fakeConsul) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessions = make(map[string]bool)
+	c.holder = ""
+}
+
+func TestConsulElector(t *testing.T) {
+	consul := &fakeConsul{sessions: make(map[string]bool)}
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	ctx := context.Background()
+	first := leader.NewConsulElector(server.URL, "", "instance-manager/leader", 15*time.Second)
+	second := leader.NewConsulElector(server.URL, "", "instance-manager/leader", 15*time.Second)
+
+	if leading, err := first.Campaign(ctx); err != nil || !leading {
+		t.Fatalf("Expected the first replica to lead, got %v, %v", leading, err)
+	}
+	if leading, err := second.Campaign(ctx); err != nil || leading {
+		t.Fatalf("Expected the second replica to stand by, got %v, %v", leading, err)
+	}
+	if leading, err := first.Campaign(ctx); err != nil || !leading {
+		t.Fatalf("Expected the leader to renew its session and keep the key, got %v, %v", leading, err)
+	}
+
+	if err := first.Resign(ctx); err != nil {
+		t.Fatalf("Resign failed: %v", err)
+	}
+	if leading, err := second.Campaign(ctx); err != nil || !leading {
+		t.Fatalf("Expected the second replica to take over, got %v, %v", leading, err)
+	}
+
+	// A replica whose session expired creates a new one and campaigns again
+	consul.expire()
+	if leading, err := first.Campaign(ctx); err != nil || !leading {
+		t.Errorf("Expected a new session to win the released key, got %v, %v", leading, err)
+	}
+}
+
+func TestConsulElector_Hold(t *testing.T) {
+	consul := &fakeConsul{sessions: make(map[string]bool)}
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	elector := leader.NewConsulElector(server.URL, "", "instance-manager/leader", 30*time.Millisecond)
+	if leading, err := elector.Campaign(context.Background()); err != nil || !leading {
+		t.Fatalf("Expected the replica to lead, got %v, %v", leading, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	held := make(chan error, 1)
+	go func() { held <- elector.Hold(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for consul.renewed() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if consul.renewed() < 2 {
+		t.Fatalf("Expected the session to be renewed while held, got %d renewals", consul.renewed())
+	}
+
+	consul.expire()
+	select {
+	case err := <-held:
+		if err == nil {
+			t.Error("Expected Hold to fail once the session expired")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Hold to return once the session expired")
+	}
+	cancel()
+
+	// Holding ends without an error when the pass is over
+	if leading, err := elector.Campaign(context.Background()); err != nil || !leading {
+		t.Fatalf("Expected the replica to lead again, got %v, %v", leading, err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { held <- elector.Hold(ctx) }()
+	cancel()
+	if err := <-held; err != nil {
+		t.Errorf("Expected Hold to end without an error when cancelled, got %v", err)
+	}
+}
+
+func TestEtcdElector(t *testing.T) {
+	etcd := etcdtest.New(t)
+
+	ctx := context.Background()
+	first, err := leader.NewEtcdElector([]string{etcd.URL}, "", "", "instance-manager/leader", 15*time.Second)
+	if err != nil {
+		t.Fatalf("NewEtcdElector failed: %v", err)
+	}
+	second, _ := leader.NewEtcdElector([]string{etcd.URL}, "", "", "instance-manager/leader", 15*time.Second)
+
+	if leading, err := first.Campaign(ctx); err != nil || !leading {
+		t.Fatalf("Expected the first replica to lead, got %v, %v", leading, err)
+	}
+	if leading, err := second.Campaign(ctx); err != nil || leading {
+		t.Fatalf("Expected the second replica to stand by, got %v, %v", leading, err)
+	}
+	if leading, err := first.Campaign(ctx); err != nil || !leading {
+		t.Fatalf("Expected the leader to keep its key, got %v, %v", leading, err)
+	}
+	// The sessions keep their leases alive in the background
+	for deadline := time.Now().Add(5 * time.Second); etcd.KeepAlives() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the leases to be kept alive")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := first.Resign(ctx); err != nil {
+		t.Fatalf("Resign failed: %v", err)
+	}
+	if leading, err := second.Campaign(ctx); err != nil || !leading {
+		t.Fatalf("Expected the second replica to take over, got %v, %v", leading, err)
+	}
+
+	// A replica whose lease expired campaigns again with a new session
+	etcd.Expire()
+	if leading, err := first.Campaign(ctx); err != nil || !leading {
+		t.Errorf("Expected a new session to win the election, got %v, %v", leading, err)
+	}
+	if leading, err := second.Campaign(ctx); err != nil || leading {
+		t.Errorf("Expected the replica with the expired lease to stand by, got %v, %v", leading, err)
+	}
+
+	// Holding ends without an error when the pass is over
+	held := make(chan error, 1)
+	holdCtx, cancel := context.WithCancel(ctx)
+	go func() { held <- first.Hold(holdCtx) }()
+	cancel()
+	if err := <-held; err != nil {
+		t.Errorf("Expected Hold to end without an error when cancelled, got %v", err)
+	}
+	if err := first.Resign(ctx); err != nil {
+		t.Errorf("Resign failed: %v", err)
+	}
+	if err := second.Resign(ctx); err != nil {
+		t.Errorf("Resign of the replica standing by failed: %v", err)
+	}
+
+	if _, err := leader.NewEtcdElector(nil, "", "", "instance-manager/leader", time.Minute); err == nil {
+		t.Error("Expected an error without endpoints")
+	}
+}
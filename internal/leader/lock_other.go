@@ -0,0 +1,22 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package leader
+
+import (
+	"errors"
+	"os"
+)
+
+// errLocksUnsupported is returned where file locks are not available
+var errLocksUnsupported = errors.New("file locks are not supported on this platform; use the consul leader backend")
+
+// tryLock takes an exclusive lock on file without waiting and reports
+// whether it was free
+func tryLock(file *os.File) (bool, error) {
+	return false, errLocksUnsupported
+}
+
+// unlock releases a lock taken by tryLock
+func unlock(file *os.File) error {
+	return errLocksUnsupported
+}
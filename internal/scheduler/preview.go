@@ -0,0 +1,127 @@
+package scheduler
+
+import (
+	"sort"
+	"time"
+
+	"instance-manager/pkg/models"
+)
+
+// Scheduled action names
+const (
+	ActionWarn      = "warn"
+	ActionStop      = "stop"
+	ActionHibernate = "hibernate"
+	ActionTerminate = "terminate"
+	ActionRestart   = "restart"
+)
+
+// ScheduledAction describes the next lifecycle action the scheduler will take for an instance
+type ScheduledAction struct {
+	InstanceID string        `json:"instance_id"`
+	Action     string        `json:"action"`
+	At         time.Time     `json:"at"`
+	TimeUntil  time.Duration `json:"time_until"`
+}
+
+// PreviewOptions configures the timing used when previewing scheduled actions
+type PreviewOptions struct {
+	// GracePeriod delays the stop action past ExpiresAt
+	GracePeriod time.Duration
+	// WarnBefore schedules a warning this long before ExpiresAt (zero disables warnings)
+	WarnBefore time.Duration
+	// DefaultExpiryAction is the expiry action of instances without one;
+	// empty means stop
+	DefaultExpiryAction string
+}
+
+// PreviewSchedule computes the next scheduled action for each instance, sorted by
+// when it will happen. Instances with nothing left to do are omitted.
+func PreviewSchedule(instances []*models.Instance, now time.Time, opts PreviewOptions) []ScheduledAction {
+	actions := make([]ScheduledAction, 0, len(instances))
+	for _, instance := range instances {
+		action, ok := nextAction(instance, now, opts)
+		if !ok {
+			continue
+		}
+		if action.At.Before(now) {
+			action.At = now
+		}
+		action.TimeUntil = action.At.Sub(now)
+		actions = append(actions, action)
+	}
+
+	sort.SliceStable(actions, func(i, j int) bool {
+		if actions[i].At.Equal(actions[j].At) {
+			return actions[i].InstanceID < actions[j].InstanceID
+		}
+		return actions[i].At.Before(actions[j].At)
+	})
+
+	return actions
+}
+
+// nextAction determines the next action for a single instance
+func nextAction(instance *models.Instance, now time.Time, opts PreviewOptions) (ScheduledAction, bool) {
+	action := ScheduledAction{InstanceID: instance.ID}
+	hours, _ := instance.GetOfficeHours()
+
+	switch instance.State {
+	case "stopped", "stopping":
+		// Stopped instances are restarted on the next pass if their TTL was
+		// extended and their restart policy allows it
+		if !instance.ExpiresAt.After(now) {
+			// Expired instances that terminate at expiry are terminated
+			// even when stopped
+			if instance.State == "stopped" && instance.ExpiryActionOr(opts.DefaultExpiryAction) == models.ExpiryActionTerminate {
+				action.Action = ActionTerminate
+				action.At = now
+				return action, true
+			}
+			return action, false
+		}
+		if ok, _ := shouldRestart(instance); !ok {
+			return action, false
+		}
+		action.Action = ActionRestart
+		action.At = now
+		// Office hours delay the restart until they open
+		if hours != nil && !hours.Contains(now) {
+			action.At = hours.NextOpen(now)
+			if !action.At.Before(instance.ExpiresAt) {
+				return action, false
+			}
+		}
+	case "running", "pending":
+		warnAt := instance.ExpiresAt.Add(-opts.WarnBefore)
+		if opts.WarnBefore > 0 && now.Before(warnAt) {
+			action.Action = ActionWarn
+			action.At = warnAt
+		} else {
+			switch instance.ExpiryActionOr(opts.DefaultExpiryAction) {
+			case models.ExpiryActionHibernate:
+				action.Action = ActionHibernate
+			case models.ExpiryActionTerminate:
+				action.Action = ActionTerminate
+			default:
+				action.Action = ActionStop
+			}
+			action.At = instance.ExpiresAt.Add(opts.GracePeriod)
+		}
+		// Office hours stop the instance when they close, unless it expires first
+		if hours != nil {
+			closeAt := now
+			if hours.Contains(now) {
+				closeAt = hours.NextClose(now)
+			}
+			if closeAt.Before(action.At) {
+				action.Action = ActionStop
+				action.At = closeAt
+			}
+		}
+	default:
+		return action, false
+	}
+
+	return action, true
+}
@@ -0,0 +1,119 @@
+package scheduler_test
+
+import (
+	"testing"
+	"time"
+
+	"instance-manager/internal/scheduler"
+	"instance-manager/pkg/models"
+)
+
+func TestPreviewSchedule(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	instances := []*models.Instance{
+		{ID: "i-later", State: "running", ExpiresAt: now.Add(2 * time.Hour)},
+		{ID: "i-soon", State: "running", ExpiresAt: now.Add(5 * time.Minute)},
+		{ID: "i-overdue", State: "running", ExpiresAt: now.Add(-30 * time.Minute)},
+		{ID: "i-hibernate", State: "running", ExpiresAt: now.Add(-time.Hour), ExpiryAction: models.ExpiryActionHibernate},
+		{ID: "i-extended", State: "stopped", ExpiresAt: now.Add(1 * time.Hour)},
+		{ID: "i-expired-stopped", State: "stopped", ExpiresAt: now.Add(-1 * time.Hour)},
+		{ID: "i-terminated", State: "terminated", ExpiresAt: now.Add(1 * time.Hour)},
+	}
+
+	actions := scheduler.PreviewSchedule(instances, now, scheduler.PreviewOptions{
+		GracePeriod: 5 * time.Minute,
+		WarnBefore:  10 * time.Minute,
+	})
+
+	expected := []struct {
+		id     string
+		action string
+		until  time.Duration
+	}{
+		{"i-extended", scheduler.ActionRestart, 0},
+		{"i-hibernate", scheduler.ActionHibernate, 0},
+		{"i-overdue", scheduler.ActionStop, 0},
+		{"i-soon", scheduler.ActionStop, 10 * time.Minute},
+		{"i-later", scheduler.ActionWarn, 110 * time.Minute},
+	}
+
+	if len(actions) != len(expected) {
+		t.Fatalf("Expected %d actions, got %d: %+v", len(expected), len(actions), actions)
+	}
+
+	for i, want := range expected {
+		got := actions[i]
+		if got.InstanceID != want.id {
+			t.Errorf("Action %d: instance got %s, want %s", i, got.InstanceID, want.id)
+		}
+		if got.Action != want.action {
+			t.Errorf("Action %d (%s): action got %s, want %s", i, got.InstanceID, got.Action, want.action)
+		}
+		if got.TimeUntil != want.until {
+			t.Errorf("Action %d (%s): time until got %s, want %s", i, got.InstanceID, got.TimeUntil, want.until)
+		}
+	}
+}
+
+func TestPreviewScheduleWithoutWarnings(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	instances := []*models.Instance{
+		{ID: "i-running", State: "running", ExpiresAt: now.Add(1 * time.Hour)},
+	}
+
+	actions := scheduler.PreviewSchedule(instances, now, scheduler.PreviewOptions{})
+	if len(actions) != 1 {
+		t.Fatalf("Expected 1 action, got %d", len(actions))
+	}
+	if actions[0].Action != scheduler.ActionStop || !actions[0].At.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected stop at expiry, got %s at %s", actions[0].Action, actions[0].At)
+	}
+}
+
+func TestPreviewScheduleOfficeHours(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC) // Wednesday
+
+	instances := []*models.Instance{
+		{ID: "i-office", State: "running", ExpiresAt: now.Add(72 * time.Hour), OfficeHours: "08:00-19:00 Mon-Fri"},
+		{ID: "i-night", State: "stopped", ExpiresAt: now.Add(72 * time.Hour), OfficeHours: "20:00-06:00", StopReason: models.StopReasonOfficeHours},
+		{ID: "i-expiring", State: "stopped", ExpiresAt: now.Add(4 * time.Hour), OfficeHours: "20:00-06:00", StopReason: models.StopReasonOfficeHours},
+	}
+
+	actions := scheduler.PreviewSchedule(instances, now, scheduler.PreviewOptions{})
+	if len(actions) != 2 {
+		t.Fatalf("Expected 2 actions, got %+v", actions)
+	}
+	if actions[0].InstanceID != "i-office" || actions[0].Action != scheduler.ActionStop || actions[0].TimeUntil != 7*time.Hour {
+		t.Errorf("Expected i-office to stop at closing in 7h, got %+v", actions[0])
+	}
+	if actions[1].InstanceID != "i-night" || actions[1].Action != scheduler.ActionRestart || actions[1].TimeUntil != 8*time.Hour {
+		t.Errorf("Expected i-night to restart at opening in 8h, got %+v", actions[1])
+	}
+}
+
+func TestPreviewScheduleTerminate(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	instances := []*models.Instance{
+		{ID: "i-default", State: "running", ExpiresAt: now.Add(-time.Minute)},
+		{ID: "i-stop", State: "running", ExpiresAt: now.Add(-time.Minute), ExpiryAction: models.ExpiryActionStop},
+		{ID: "i-stopped", State: "stopped", ExpiresAt: now.Add(-time.Hour)},
+	}
+	actions := scheduler.PreviewSchedule(instances, now, scheduler.PreviewOptions{DefaultExpiryAction: models.ExpiryActionTerminate})
+
+	got := make(map[string]string)
+	for _, action := range actions {
+		got[action.InstanceID] = action.Action
+	}
+	want := map[string]string{
+		"i-default": scheduler.ActionTerminate,
+		"i-stop":    scheduler.ActionStop,
+		"i-stopped": scheduler.ActionTerminate,
+	}
+	for id, action := range want {
+		if got[id] != action {
+			t.Errorf("%s: action got %q, want %q", id, got[id], action)
+		}
+	}
+}
@@ -2,29 +2,230 @@ package scheduler
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
 	"time"
 
+	"instance-manager/internal/leader"
+	"instance-manager/internal/utils"
 	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/hooks"
 	"instance-manager/pkg/models"
+	"instance-manager/pkg/notify"
 	"instance-manager/pkg/storage"
 
 	"github.com/sirupsen/logrus"
 )
 
+// TimeSource provides the reference time used for expiry decisions
+type TimeSource interface {
+	Now() (time.Time, error)
+}
+
+// TimeSourceFunc adapts a function to the TimeSource interface
+type TimeSourceFunc func() (time.Time, error)
+
+// Now returns the time reported by the function
+func (f TimeSourceFunc) Now() (time.Time, error) {
+	return f()
+}
+
+// AutoRenewOptions keep instances alive during the working day by extending
+// their TTL at expiry instead of stopping them
+type AutoRenewOptions struct {
+	Until     time.Duration  // Daily cutoff as an offset from midnight; renewals stop after it
+	Increment time.Duration  // How far the TTL is extended on each renewal
+	MaxAge    time.Duration  // Instances older than this are never renewed (0 means no limit)
+	Location  *time.Location // Time zone of the cutoff (defaults to local time)
+}
+
+// ExpiryWarningOptions configure the warnings issued before instances expire
+type ExpiryWarningOptions struct {
+	LeadTimes []time.Duration // Warn this long before expiry, once per lead time
+	WebURL    string          // Base URL of the web UI linked from warnings; empty omits the link
+}
+
+// OrphanCheckOptions configure the search for orphans: instances tagged
+// ManagedBy=instance-manager at a provider that have no record in storage,
+// typically because the storage file was lost
+type OrphanCheckOptions struct {
+	Provider     cloud.CloudProvider // Provider whose managed instances are listed
+	ProviderName string              // Provider recorded on adopted instances
+	Regions      []string            // Regions listed besides those of stored instances
+	Interval     time.Duration       // How often the provider is listed
+	Adopt        bool                // Save orphans to storage so they are managed; otherwise they are only reported
+}
+
+// PruneOptions configures how long the records of terminated instances are
+// kept in storage
+type PruneOptions struct {
+	After   time.Duration // How long after termination a record is kept
+	Archive bool          // Move pruned records to the archive instead of deleting them
+}
+
+// BackupOptions configures the rotating backups of storage taken by the
+// scheduler
+type BackupOptions struct {
+	Dir      string        // Directory the backups are written to
+	Interval time.Duration // How often a backup is taken
+	Keep     int           // How many backups are kept; older ones are removed, 0 keeps all
+	Key      []byte        // Encrypts the backups when set
+}
+
+// pruneInterval is how often terminated records are pruned; retention is
+// counted in days, so pruning every pass would only rewrite storage
+const pruneInterval = time.Hour
+
+// orphanMinAge keeps instances that are still being created, which are
+// stored only once their launch returns, from being taken for orphans
+const orphanMinAge = 10 * time.Minute
+
+// stopReasonExpired is the reason passed to hooks when an instance is
+// stopped at expiry
+const stopReasonExpired = "expired"
+
+// warningExtension is the extension suggested by expiry warnings
+const warningExtension = "1h"
+
+// IdlePolicy stops running instances whose usage stayed below the thresholds
+// for a whole window, before their TTL expires
+type IdlePolicy struct {
+	CPUPercent            float64       // Instances at or above this CPU utilization are busy
+	NetworkBytesPerSecond float64       // Instances at or above this network traffic are busy (0 ignores network)
+	Window                time.Duration // How long usage must stay below the thresholds
+}
+
+// Idle reports whether the usage covers the whole window and stayed below the
+// thresholds
+func (p IdlePolicy) Idle(usage *models.Utilization) bool {
+	if usage.Covered < p.Window || usage.CPUPercent >= p.CPUPercent {
+		return false
+	}
+	return p.NetworkBytesPerSecond <= 0 || usage.NetworkBytesPerSecond < p.NetworkBytesPerSecond
+}
+
+// idleCheckInterval is how often the utilization of each instance is checked;
+// metrics are published every few minutes, so checking every pass would only
+// add cost
+const idleCheckInterval = 5 * time.Minute
+
 // Scheduler manages background tasks for instance lifecycle
 type Scheduler struct {
-	provider       cloud.CloudProvider
-	storage        *storage.FileStorage
+	providers      cloud.Resolver
+	storage        storage.Storage
 	interval       time.Duration
 	ctx            context.Context
 	cancel         context.CancelFunc
+	done           chan struct{} // Closed when the loop started by Start returns
 	logger         *logrus.Logger
 	lastReload     time.Time
 	reloadInterval time.Duration
+	timeSource     TimeSource
+	maxClockSkew   time.Duration
+	dailyBudget    float64
+	maxRestarts    int
+	autoRenew      *AutoRenewOptions
+	callTimeout    time.Duration
+	retry          cloud.RetryPolicy
+	warnings       *ExpiryWarningOptions
+	notifier       notify.Notifier
+	hooks          *hooks.Runner
+	gracePeriod    time.Duration
+	// terminateStoppedAfter is how long an instance may stay stopped before
+	// it is terminated and archived; zero keeps stopped instances
+	terminateStoppedAfter time.Duration
+	idle                  *IdlePolicy
+	maxLifetime           time.Duration // Longest an instance may run after launch; zero means no limit
+	defaultExpiryAction   string        // Expiry action of instances without one; empty means stop
+	dryRun                bool
+	concurrency           int           // Most instances processed at once
+	instanceTimeout       time.Duration // Limit on processing a single instance
+	elector               leader.Elector
+	campaigned            bool // Whether an election has been held yet
+	leading               bool // Whether this replica won the last election
+	orphans               *OrphanCheckOptions
+	orphansChecked        time.Time       // Last time the provider was listed for orphans
+	orphansReported       map[string]bool // Orphans already reported, so they are not repeated every check
+	prune                 *PruneOptions
+	pruned                time.Time // Last time terminated records were pruned
+	backups               *BackupOptions
+	backedUp              time.Time // Last time storage was backed up
+	paused                bool      // The previous pass was skipped because the scheduler is paused
+
+	// mu guards the maps below, which instances processed concurrently update
+	mu             sync.Mutex
+	idleChecked    map[string]time.Time // Last utilization check of each instance
+	cronChecked    map[string]time.Time // Last time the cron schedules of each instance were run
+	dryRunActions  map[string]bool      // Actions logged in dry-run mode in this pass, keyed by instance and message
+	dryRunPrevious map[string]bool      // Actions logged in the previous pass
+	report         *models.SchedulerRun // Report of the pass in progress
+}
+
+// maxReportedErrors bounds the errors kept in the report of a pass; the
+// rest are only counted
+const maxReportedErrors = 20
+
+// defaultCallTimeout bounds each cloud provider call made by the scheduler
+const defaultCallTimeout = 30 * time.Second
+
+// defaultConcurrency is how many instances are processed at once by default
+const defaultConcurrency = 10
+
+// defaultInstanceTimeout bounds all the provider calls made while processing
+// a single instance
+const defaultInstanceTimeout = 2 * time.Minute
+
+// Option configures a Scheduler created by NewScheduler
+type Option func(*Scheduler)
+
+// WithInterval sets how often instances are checked. Non-positive values
+// keep the default.
+func WithInterval(interval time.Duration) Option {
+	return func(s *Scheduler) {
+		if interval > 0 {
+			s.interval = interval
+		}
+	}
+}
+
+// WithReloadInterval sets the longest the scheduler works from cached storage
+// before reading it again. Non-positive values keep the default.
+func WithReloadInterval(interval time.Duration) Option {
+	return func(s *Scheduler) {
+		if interval > 0 {
+			s.reloadInterval = interval
+		}
+	}
+}
+
+// WithConcurrency sets how many instances are processed at once. Non-positive
+// values keep the default.
+func WithConcurrency(concurrency int) Option {
+	return func(s *Scheduler) {
+		if concurrency > 0 {
+			s.concurrency = concurrency
+		}
+	}
+}
+
+// WithInstanceTimeout limits how long processing a single instance may take,
+// so one unresponsive instance cannot hold up the pass. Non-positive values
+// keep the default.
+func WithInstanceTimeout(timeout time.Duration) Option {
+	return func(s *Scheduler) {
+		if timeout > 0 {
+			s.instanceTimeout = timeout
+		}
+	}
 }
 
-// NewScheduler creates a new scheduler instance
-func NewScheduler(provider cloud.CloudProvider, storage *storage.FileStorage) *Scheduler {
+// NewScheduler creates a new scheduler instance that manages every stored
+// instance through provider
+func NewScheduler(provider cloud.CloudProvider, storage storage.Storage, opts ...Option) *Scheduler {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	logger := logrus.New()
@@ -34,16 +235,46 @@ func NewScheduler(provider cloud.CloudProvider, storage *storage.FileStorage) *S
 	})
 	logger.SetLevel(logrus.InfoLevel)
 
-	return &Scheduler{
-		provider:       provider,
-		storage:        storage,
-		interval:       30 * time.Second, // Check every 30 seconds for better responsiveness
-		reloadInterval: 10 * time.Second, // Reload data every 10 seconds max
-		ctx:            ctx,
-		cancel:         cancel,
-		logger:         logger,
-		lastReload:     time.Time{}, // Force initial reload
+	s := &Scheduler{
+		providers:       cloud.Static(provider),
+		storage:         storage,
+		interval:        30 * time.Second, // Check every 30 seconds for better responsiveness
+		reloadInterval:  10 * time.Second, // Reload data every 10 seconds max
+		ctx:             ctx,
+		cancel:          cancel,
+		logger:          logger,
+		lastReload:      time.Time{}, // Force initial reload
+		callTimeout:     defaultCallTimeout,
+		retry:           cloud.DefaultRetryPolicy,
+		concurrency:     defaultConcurrency,
+		instanceTimeout: defaultInstanceTimeout,
+		cronChecked:     make(map[string]time.Time),
+		dryRunActions:   make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	logger.AddHook(reportHook{s})
+	return s
+}
+
+// SetRegistry makes the scheduler manage each instance through the provider
+// recorded on it, so storage may hold instances from several providers
+func (s *Scheduler) SetRegistry(registry *cloud.Registry) {
+	s.providers = registry.ForInstance
+}
+
+// SetCallTimeout limits how long a single cloud provider call may take before
+// it is cancelled. Zero or less removes the limit; calls are still cancelled
+// when the scheduler stops.
+func (s *Scheduler) SetCallTimeout(timeout time.Duration) {
+	s.callTimeout = timeout
+}
+
+// SetRetryPolicy sets how provider calls that fail with throttling or other
+// transient errors are retried. Calls that create resources are not retried.
+func (s *Scheduler) SetRetryPolicy(policy cloud.RetryPolicy) {
+	s.retry = policy
 }
 
 // SetLogLevel sets the logging level
@@ -51,23 +282,183 @@ func (s *Scheduler) SetLogLevel(level logrus.Level) {
 	s.logger.SetLevel(level)
 }
 
+// SetLogOutput sets the destination of the scheduler logs
+func (s *Scheduler) SetLogOutput(out io.Writer) {
+	s.logger.SetOutput(out)
+}
+
+// SetTimeSource makes expiry decisions use the given time source instead of the
+// local clock. A warning is logged when the local clock differs from it by more
+// than maxSkew.
+func (s *Scheduler) SetTimeSource(source TimeSource, maxSkew time.Duration) {
+	s.timeSource = source
+	s.maxClockSkew = maxSkew
+}
+
+// SetDailyBudget caps the projected daily spend (USD) of running instances.
+// When it is exceeded the soonest-expiring instances are stopped until the
+// projection fits. Zero disables the budget.
+func (s *Scheduler) SetDailyBudget(budget float64) {
+	s.dailyBudget = budget
+}
+
+// SetMaxRestarts limits how many times the scheduler restarts an instance.
+// Once reached the instance is left stopped and marked unhealthy. Zero means
+// no limit.
+func (s *Scheduler) SetMaxRestarts(maxRestarts int) {
+	s.maxRestarts = maxRestarts
+}
+
+// SetAutoRenew makes the scheduler extend expiring instances by
+// opts.Increment until the daily cutoff instead of stopping them
+func (s *Scheduler) SetAutoRenew(opts AutoRenewOptions) {
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	s.autoRenew = &opts
+}
+
+// SetExpiryWarnings makes the scheduler warn about running instances as they
+// come within each lead time of expiry. Warnings are logged and sent to the
+// notifier, if one is set.
+func (s *Scheduler) SetExpiryWarnings(opts ExpiryWarningOptions) {
+	leadTimes := make([]time.Duration, 0, len(opts.LeadTimes))
+	for _, leadTime := range opts.LeadTimes {
+		if leadTime > 0 {
+			leadTimes = append(leadTimes, leadTime)
+		}
+	}
+	sort.Slice(leadTimes, func(i, j int) bool { return leadTimes[i] > leadTimes[j] })
+	opts.LeadTimes = leadTimes
+	s.warnings = &opts
+}
+
+// SetGracePeriod keeps expired instances running for gracePeriod past their
+// expiry, giving users a last chance to extend them. The start of the grace
+// period is logged and notified. Zero stops instances as soon as they expire.
+func (s *Scheduler) SetGracePeriod(gracePeriod time.Duration) {
+	s.gracePeriod = gracePeriod
+}
+
+// SetTerminateStoppedAfter makes the scheduler terminate instances that have
+// been stopped for longer than after, so they stop incurring storage costs,
+// and move their records to the archive. Instances whose TTL was extended are
+// restarted instead. Zero keeps stopped instances.
+func (s *Scheduler) SetTerminateStoppedAfter(after time.Duration) {
+	s.terminateStoppedAfter = after
+}
+
+// SetIdlePolicy makes the scheduler stop running instances that stayed idle
+// for the policy's window, using the metrics of providers that report
+// utilization. Instances marked KeepWhenIdle or with the always restart
+// policy are exempt; idle-stopped instances restart once their TTL is
+// extended.
+func (s *Scheduler) SetIdlePolicy(policy IdlePolicy) {
+	s.idle = &policy
+	s.idleChecked = make(map[string]time.Time)
+}
+
+// SetMaxLifetime holds instances to maxLifetime after launch: an expiry
+// beyond it is moved back, so the instance is stopped when it is reached, and
+// auto-renewal stops there. Zero removes the limit.
+func (s *Scheduler) SetMaxLifetime(maxLifetime time.Duration) {
+	s.maxLifetime = maxLifetime
+}
+
+// SetDefaultExpiryAction sets what the scheduler does at expiry to instances
+// that were not given an expiry action, such as those created from the web
+// UI or adopted. Empty stops them.
+func (s *Scheduler) SetDefaultExpiryAction(action string) {
+	s.defaultExpiryAction = action
+}
+
+// SetDryRun makes the scheduler log the stops, starts, renewals and other
+// actions it would take instead of taking them. Instance status is still read
+// from the providers and recorded in storage.
+func (s *Scheduler) SetDryRun(dryRun bool) {
+	s.dryRun = dryRun
+}
+
+// SetLeaderElector makes the scheduler process instances only while elector
+// elects this replica, so several service replicas can run against the same
+// instances with one of them active at a time
+func (s *Scheduler) SetLeaderElector(elector leader.Elector) {
+	s.elector = elector
+}
+
+// SetHooks runs the lifecycle hooks of runner before and after the scheduler
+// stops an instance and before it terminates one
+func (s *Scheduler) SetHooks(runner *hooks.Runner) {
+	s.hooks = runner
+}
+
+// SetOrphanCheck makes the scheduler list the managed instances of
+// opts.Provider every opts.Interval and report those missing from storage,
+// or adopt them when opts.Adopt is set. Adopted instances are managed like
+// any other: they stop at the expiry recorded in their tags, or at once when
+// they have none.
+func (s *Scheduler) SetOrphanCheck(opts OrphanCheckOptions) {
+	s.orphans = &opts
+	s.orphansReported = make(map[string]bool)
+}
+
+// SetPruneTerminated makes the scheduler remove the records of instances
+// terminated more than opts.After ago, or move them to the archive when
+// opts.Archive is set. Records are pruned at most once an hour.
+func (s *Scheduler) SetPruneTerminated(opts PruneOptions) {
+	s.prune = &opts
+	s.pruned = time.Time{}
+}
+
+// SetBackups makes the scheduler write a backup of storage into opts.Dir
+// every opts.Interval, keeping the newest opts.Keep. Backups are taken while
+// the scheduler is paused too.
+func (s *Scheduler) SetBackups(opts BackupOptions) {
+	s.backups = &opts
+	s.backedUp = time.Time{}
+}
+
+// SetNotifier sends scheduler notifications, such as expiry warnings, to notifier
+func (s *Scheduler) SetNotifier(notifier notify.Notifier) {
+	s.notifier = notifier
+}
+
 // Start begins the background scheduler
 func (s *Scheduler) Start() {
 	s.logger.WithFields(logrus.Fields{
 		"interval":        s.interval,
 		"reload_interval": s.reloadInterval,
 	}).Info("Starting instance scheduler")
-	go s.run()
+	s.done = make(chan struct{})
+	changes, err := s.storage.Watch(s.ctx)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to watch storage, extensions take effect on the next pass")
+	}
+	go s.run(changes)
 }
 
-// Stop stops the background scheduler
+// Stop stops the background scheduler, waits for the pass in progress to
+// wind down and gives up leadership, so a standby replica can take over
 func (s *Scheduler) Stop() {
 	s.logger.Info("Stopping instance scheduler")
 	s.cancel()
+	if s.done != nil {
+		<-s.done
+	}
+
+	if s.elector != nil {
+		ctx, cancel := s.callContext(context.Background())
+		defer cancel()
+		if err := s.elector.Resign(ctx); err != nil {
+			s.logger.WithError(err).Warn("Failed to give up leadership")
+		}
+	}
 }
 
-// run is the main scheduler loop
-func (s *Scheduler) run() {
+// run is the main scheduler loop. Changes to the stored instances, when they
+// can be watched, arrive on changes.
+func (s *Scheduler) run(changes <-chan storage.ChangeEvent) {
+	defer close(s.done)
 	ticker := time.NewTicker(s.interval)
 	defer ticker.Stop()
 
@@ -78,14 +469,69 @@ func (s *Scheduler) run() {
 			return
 		case <-ticker.C:
 			s.processInstances()
+		case change, ok := <-changes:
+			if !ok {
+				changes = nil
+				continue
+			}
+			s.processExtension(change)
 		}
 	}
 }
 
+// processExtension processes an instance as soon as someone else extends its
+// TTL, so a stopped instance waiting for the extension restarts without
+// waiting for the next pass
+func (s *Scheduler) processExtension(change storage.ChangeEvent) {
+	if change.Type != storage.ChangeUpdated || !change.Instance.ExpiresAt.After(change.Previous.ExpiresAt) {
+		return
+	}
+	if history := change.Instance.History; len(history) > 0 && history[len(history)-1].Actor == models.ActorScheduler {
+		return // Renewed by the scheduler itself
+	}
+	if !s.lead() || s.isPaused() {
+		return
+	}
+
+	// The event's record is shared with other watchers
+	instance, err := s.storage.GetInstance(change.InstanceID)
+	if err != nil {
+		s.logger.WithError(err).WithField("instance_id", change.InstanceID).Warn("Failed to read extended instance")
+		return
+	}
+	s.logger.WithFields(logrus.Fields{
+		"instance_id": instance.ID,
+		"expires_at":  instance.ExpiresAt,
+	}).Debug("Instance extended, processing it now")
+
+	ctx, cancel := s.holdLeadership()
+	defer cancel()
+	ctx, cancelInstance := s.instanceContext(ctx)
+	defer cancelInstance()
+	if err := s.processInstance(ctx, instance, s.now()); err != nil {
+		s.logger.WithError(err).Warn("Failed to process extended instance")
+	}
+}
+
 // processInstances checks all instances and takes appropriate actions
 func (s *Scheduler) processInstances() {
+	if !s.lead() {
+		return
+	}
+	if s.backups != nil {
+		if now := time.Now(); now.Sub(s.backedUp) >= s.backups.Interval {
+			s.backedUp = now
+			s.backupStorage(now)
+		}
+	}
+	if s.isPaused() {
+		return
+	}
 	s.logger.Debug("Processing instances...")
 
+	s.beginReport()
+	defer s.finishReport()
+
 	// Get all instances from storage (this will reload if needed)
 	instances, err := s.getInstancesWithReload()
 	if err != nil {
@@ -94,130 +540,1572 @@ func (s *Scheduler) processInstances() {
 	}
 
 	s.logger.WithField("instance_count", len(instances)).Debug("Loaded instances from storage")
+	s.mu.Lock()
+	s.report.Instances = len(instances)
+	s.mu.Unlock()
 
-	for _, instance := range instances {
-		s.processInstance(instance)
+	s.dryRunPrevious, s.dryRunActions = s.dryRunActions, make(map[string]bool)
+
+	ctx, cancel := s.holdLeadership()
+	defer cancel()
+
+	now := s.now()
+	if err := s.processConcurrently(ctx, instances, now); err != nil {
+		s.logger.WithError(err).Warn("Failed to process some instances")
+	}
+
+	if s.dailyBudget > 0 {
+		s.enforceBudget(ctx, instances)
+	}
+
+	if s.orphans != nil && now.Sub(s.orphansChecked) >= s.orphans.Interval {
+		s.orphansChecked = now
+		s.checkOrphans(ctx, now)
+	}
+
+	if s.prune != nil && now.Sub(s.pruned) >= pruneInterval {
+		s.pruned = now
+		s.pruneTerminated(now)
 	}
 }
 
-// getInstancesWithReload gets instances and ensures data is fresh (max 10 seconds old)
-func (s *Scheduler) getInstancesWithReload() ([]*models.Instance, error) {
-	// Force reload if data is older than reloadInterval
-	if time.Since(s.lastReload) > s.reloadInterval {
-		s.logger.Debug("Reloading instance data from storage")
-		s.lastReload = time.Now()
+// isPaused reports whether the scheduler is paused, logging when a pause
+// starts and ends. A pause that cannot be read does not hold up the pass.
+func (s *Scheduler) isPaused() bool {
+	pause, err := s.storage.SchedulerPause()
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to read whether the scheduler is paused")
+		return false
 	}
 
-	return s.storage.ListInstances()
+	paused := pause != nil
+	if paused && !s.paused {
+		logger := s.logger.WithField("paused_at", pause.PausedAt.Format(time.RFC3339))
+		if pause.Reason != "" {
+			logger = logger.WithField("reason", pause.Reason)
+		}
+		if !pause.Until.IsZero() {
+			logger = logger.WithField("until", pause.Until.Format(time.RFC3339))
+		}
+		logger.Warn("Scheduler paused, skipping lifecycle actions until it is resumed")
+	} else if !paused && s.paused {
+		s.logger.Info("Scheduler resumed")
+	}
+	s.paused = paused
+	return paused
 }
 
-// processInstance handles the lifecycle of a single instance
-func (s *Scheduler) processInstance(instance *models.Instance) {
-	logger := s.logger.WithFields(logrus.Fields{
-		"instance_id": instance.ID,
-		"state":       instance.State,
-		"expires_at":  instance.ExpiresAt,
-	})
+// beginReport starts the report of a pass
+func (s *Scheduler) beginReport() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.report = &models.SchedulerRun{
+		StartedAt: time.Now(),
+		Actions:   make(map[string]int),
+		DryRun:    s.dryRun,
+	}
+}
 
-	logger.Debug("Processing instance")
+// finishReport records the report of the pass in storage
+func (s *Scheduler) finishReport() {
+	s.mu.Lock()
+	report := s.report
+	s.report = nil
+	s.mu.Unlock()
 
-	// Skip if instance is already terminated
-	if instance.State == "terminated" || instance.State == "terminating" {
-		logger.Debug("Instance already terminated, skipping")
-		return
+	report.Duration = time.Since(report.StartedAt)
+	if err := s.storage.RecordSchedulerRun(report); err != nil {
+		s.logger.WithError(err).Warn("Failed to record the report of the pass in storage")
 	}
+}
 
-	// Get current instance status from cloud provider
-	status, err := s.provider.GetInstanceStatus(instance.ID)
-	if err != nil {
-		logger.WithError(err).Warn("Failed to get instance status from cloud provider")
+// recordAction counts an action taken, or logged in dry-run mode, in the
+// pass in progress
+func (s *Scheduler) recordAction(action string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.report != nil {
+		s.report.Actions[action]++
+	}
+}
+
+// recordError adds an error to the report of the pass in progress
+func (s *Scheduler) recordError(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.report == nil {
 		return
 	}
+	s.report.ErrorCount++
+	if len(s.report.Errors) < maxReportedErrors {
+		s.report.Errors = append(s.report.Errors, msg)
+	}
+}
 
-	// Update local state if it differs from cloud state
-	if status.State != instance.State {
-		logger.WithFields(logrus.Fields{
-			"old_state": instance.State,
-			"new_state": status.State,
-		}).Info("Instance state changed, updating local storage")
+// reportHook adds the errors the scheduler logs to the report of the pass in
+// progress
+type reportHook struct {
+	s *Scheduler
+}
 
-		instance.State = status.State
-		instance.PublicIP = status.PublicIP
-		instance.PrivateIP = status.PrivateIP
+func (h reportHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.ErrorLevel}
+}
 
-		if err := s.storage.UpdateInstance(instance); err != nil {
-			logger.WithError(err).Error("Failed to update instance in storage")
-		}
+func (h reportHook) Fire(entry *logrus.Entry) error {
+	msg := entry.Message
+	if id, ok := entry.Data["instance_id"]; ok {
+		msg = fmt.Sprintf("instance %v: %s", id, msg)
+	}
+	if err, ok := entry.Data[logrus.ErrorKey]; ok {
+		msg = fmt.Sprintf("%s: %v", msg, err)
 	}
+	h.s.recordError(msg)
+	return nil
+}
 
-	// Check if instance has expired and should be stopped
-	if instance.IsExpired() {
-		// Only stop if instance is currently running or pending
-		if status.State == "running" || status.State == "pending" {
-			s.handleExpiredInstance(instance, logger)
-		} else {
-			logger.Debug("Instance expired but already stopped/terminated")
+// holdLeadership returns the context of a pass. When the elector's
+// leadership lapses unless it is kept alive, it is held for the whole pass
+// and the context is cancelled as soon as it is lost, so this replica stops
+// acting before another one takes over.
+func (s *Scheduler) holdLeadership() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	holder, ok := s.elector.(leader.Holder)
+	if !ok {
+		return ctx, cancel
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := holder.Hold(ctx); err != nil {
+			s.logger.WithError(err).Error("Lost leadership during the pass, abandoning it")
+			cancel()
 		}
-		return
+	}()
+	return ctx, func() {
+		cancel()
+		<-done
 	}
+}
 
-	// Check if instance should be started (if TTL was extended and instance is stopped)
-	if instance.ExpiresAt.After(time.Now()) && (status.State == "stopped" || status.State == "stopping") {
-		s.handleStoppedInstance(instance, logger)
+// lead campaigns for leadership when an elector is set and reports whether
+// this replica should process instances. Replicas that cannot reach the
+// election stand by rather than risk acting alongside the leader.
+func (s *Scheduler) lead() bool {
+	if s.elector == nil {
+		return true
+	}
+
+	ctx, cancel := s.callContext(s.ctx)
+	defer cancel()
+	leading, err := s.elector.Campaign(ctx)
+	if err != nil {
+		s.logger.WithError(err).Warn("Leader election failed, standing by")
+		leading = false
+	}
+
+	changed := !s.campaigned || leading != s.leading
+	s.campaigned, s.leading = true, leading
+	switch {
+	case changed && leading:
+		s.logger.Info("Elected leader, managing instances")
+	case changed:
+		s.logger.Info("Another replica is the leader, standing by")
+	case !leading:
+		s.logger.Debug("Standing by for the leader")
 	}
+	return leading
 }
 
-// handleExpiredInstance stops an expired instance (instead of terminating)
-func (s *Scheduler) handleExpiredInstance(instance *models.Instance, logger *logrus.Entry) {
-	timeOverdue := time.Since(instance.ExpiresAt)
+// processConcurrently processes the instances with at most s.concurrency
+// running at once, each limited to s.instanceTimeout, and returns the errors
+// of the instances that could not be processed, which are also added to the
+// report of the pass
+func (s *Scheduler) processConcurrently(ctx context.Context, instances []*models.Instance, now time.Time) error {
+	errs := make([]error, len(instances))
+	slots := make(chan struct{}, s.concurrency)
+	var wg sync.WaitGroup
+	for i, instance := range instances {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, instance *models.Instance) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
 
-	logger.WithField("overdue_duration", timeOverdue).Warn("Instance has EXPIRED - stopping instance (can be restarted if TTL extended)")
+			ctx, cancel := s.instanceContext(ctx)
+			defer cancel()
+			errs[i] = s.processInstance(ctx, instance, now)
+			if errs[i] == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				errs[i] = fmt.Errorf("instance %s: processing took longer than %s", instance.ID, s.instanceTimeout)
+			}
+			if errs[i] != nil {
+				s.recordError(errs[i].Error())
+			}
+		}(i, instance)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
 
-	// Stop the instance (not terminate)
-	if err := s.provider.StopInstance(instance.ID); err != nil {
-		logger.WithError(err).Error("Failed to stop expired instance")
-		return
+// enforceBudget stops running instances, soonest-expiring first, until the
+// projected daily spend of those left running fits within the daily budget.
+// Instances with the always restart policy are never stopped.
+func (s *Scheduler) enforceBudget(ctx context.Context, instances []*models.Instance) {
+	var running []*models.Instance
+	hourlyCosts := make(map[string]float64)
+	projected := 0.0
+	for _, instance := range instances {
+		if instance.State != "running" && instance.State != "pending" {
+			continue
+		}
+		cost, err := utils.EstimateHourlyCost(instance.InstanceType)
+		if err != nil {
+			s.logger.WithField("instance_id", instance.ID).WithError(err).Warn("Cannot estimate instance cost, excluding it from the budget")
+			continue
+		}
+		projected += cost * 24
+
+		// Instances that always restart count towards the spend but are never
+		// stopped, since they would only be restarted on the next pass
+		if instance.GetRestartPolicy() != models.RestartPolicyAlways {
+			running = append(running, instance)
+			hourlyCosts[instance.ID] = cost
+		}
 	}
 
-	// Update instance state in storage
-	instance.State = "stopping"
-	if err := s.storage.UpdateInstance(instance); err != nil {
-		logger.WithError(err).Error("Failed to update instance state in storage")
+	if projected <= s.dailyBudget {
+		s.logger.WithFields(logrus.Fields{
+			"projected_daily_spend": projected,
+			"daily_budget":          s.dailyBudget,
+		}).Debug("Projected spend is within the daily budget")
+		return
 	}
 
-	logger.WithFields(logrus.Fields{
-		"overdue_duration": timeOverdue,
-		"action":           "stopped",
-	}).Info("✅ Successfully stopped expired instance (can be restarted)")
-}
+	s.logger.WithFields(logrus.Fields{
+		"projected_daily_spend": projected,
+		"daily_budget":          s.dailyBudget,
+	}).Warn("Projected daily spend exceeds budget - stopping instances")
 
-// handleStoppedInstance starts a stopped instance if its TTL was extended
-func (s *Scheduler) handleStoppedInstance(instance *models.Instance, logger *logrus.Entry) {
-	timeRemaining := time.Until(instance.ExpiresAt)
+	sort.SliceStable(running, func(i, j int) bool {
+		if !running[i].ExpiresAt.Equal(running[j].ExpiresAt) {
+			return running[i].ExpiresAt.Before(running[j].ExpiresAt)
+		}
+		return running[i].ID < running[j].ID
+	})
 
-	logger.WithField("time_remaining", timeRemaining).Info("Instance TTL was EXTENDED - restarting stopped instance")
+	for _, instance := range running {
+		if projected <= s.dailyBudget {
+			break
+		}
 
-	// Start the instance
-	if err := s.startInstance(instance.ID); err != nil {
-		logger.WithError(err).Error("Failed to start stopped instance")
+		dailyCost := hourlyCosts[instance.ID] * 24
+		logger := s.logger.WithFields(logrus.Fields{
+			"instance_id":   instance.ID,
+			"instance_type": instance.InstanceType,
+			"daily_cost":    dailyCost,
+		})
+
+		if s.skipInDryRun(instance, "stop", logger, "Would stop instance to stay under the daily budget") {
+			projected -= dailyCost
+			continue
+		}
+		if err := s.stopInstance(ctx, instance, models.StopReasonBudget, logger); err != nil {
+			logger.WithError(err).Error("Failed to stop instance to meet budget")
+			continue
+		}
+
+		instance.State = "stopping"
+		instance.StopReason = models.StopReasonBudget
+		instance.RecordEvent(s.now(), models.EventStopped, models.ActorScheduler, "daily budget")
+		if err := s.storage.UpdateInstance(instance); err != nil {
+			logger.WithError(err).Error("Failed to update instance state in storage")
+		}
+
+		projected -= dailyCost
+		s.recordAction("stopped")
+		logger.WithFields(logrus.Fields{
+			"projected_daily_spend": projected,
+			"action":                "stopped",
+		}).Warn("Stopped instance to stay under the daily budget")
+	}
+}
+
+// checkOrphans lists the managed instances of the orphan check's provider
+// and reports or adopts those missing from storage. Storage is read afresh,
+// so instances created since the last reload are not taken for orphans.
+func (s *Scheduler) checkOrphans(ctx context.Context, now time.Time) {
+	stored, err := s.storage.ListInstances()
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to read storage for the orphan check")
 		return
 	}
 
-	// Update instance state in storage
-	instance.State = "pending"
-	if err := s.storage.UpdateInstance(instance); err != nil {
-		logger.WithError(err).Error("Failed to update instance state in storage")
+	var listed []*models.Instance
+	regions := s.orphanRegions(stored)
+	err = s.call(ctx, func(ctx context.Context) (err error) {
+		listed, err = cloud.ListInRegions(ctx, s.orphans.Provider, regions)
+		return err
+	})
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to list instances for the orphan check")
+		return
 	}
 
-	logger.WithFields(logrus.Fields{
-		"time_remaining": timeRemaining,
-		"action":         "restarted",
-	}).Info("🚀 Successfully restarted instance due to TTL extension")
+	reported := make(map[string]bool)
+	for _, orphan := range models.FindOrphans(listed, stored) {
+		if now.Sub(orphan.LaunchTime) < orphanMinAge {
+			continue
+		}
+		logger := s.logger.WithFields(logrus.Fields{
+			"instance_id": orphan.ID,
+			"region":      orphan.Region,
+			"state":       orphan.State,
+		})
+
+		if !s.orphans.Adopt {
+			reported[orphan.ID] = true
+			if s.orphansReported[orphan.ID] {
+				logger.Debug("Instance is still missing from storage")
+				continue
+			}
+			logger.Warn("Found a managed instance missing from storage; adopt or terminate it with the orphans command")
+			s.notify(ctx, notify.Notification{
+				Event:        notify.EventOrphanFound,
+				InstanceID:   orphan.ID,
+				InstanceName: orphan.Name,
+				ExpiresAt:    orphan.ExpiresAt,
+				Message:      fmt.Sprintf("Instance %s exists at the provider but is missing from storage, so it will not be stopped. Adopt or terminate it with: instance-manager orphans", orphan.ID),
+			}, logger)
+			continue
+		}
+
+		if s.skipInDryRun(orphan, "adopt", logger, "Would adopt instance missing from storage") {
+			continue
+		}
+		orphan.Adopt(s.orphans.ProviderName, "", now)
+		orphan.RecordEvent(now, models.EventAdopted, models.ActorScheduler, "missing from storage")
+		if err := s.storage.SaveInstance(orphan); err != nil {
+			logger.WithError(err).Error("Failed to adopt instance")
+			continue
+		}
+		s.recordAction("adopted")
+		logger.WithField("expires_at", orphan.ExpiresAt).Warn("Adopted instance missing from storage")
+	}
+	s.orphansReported = reported
+}
+
+// pruneTerminated removes, or archives, the records of instances terminated
+// before the retention period
+func (s *Scheduler) pruneTerminated(now time.Time) {
+	terminated, err := s.storage.GetTerminatedBefore(now.Add(-s.prune.After))
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to read storage to prune terminated instances")
+		return
+	}
+
+	for _, instance := range terminated {
+		logger := s.logger.WithFields(logrus.Fields{
+			"instance_id":   instance.ID,
+			"terminated_at": instance.TerminatedAt,
+		})
+		if s.prune.Archive {
+			if s.skipInDryRun(instance, "archive", logger, "Would archive the record of terminated instance") {
+				continue
+			}
+			if err := s.storage.ArchiveInstance(instance.ID, models.ArchiveReasonPruned); err != nil {
+				logger.WithError(err).Error("Failed to archive the record of terminated instance")
+				continue
+			}
+			s.recordAction("archived")
+			logger.Info("Archived the record of terminated instance")
+			continue
+		}
+
+		if s.skipInDryRun(instance, "delete", logger, "Would delete the record of terminated instance") {
+			continue
+		}
+		if err := s.storage.DeleteInstance(instance.ID); err != nil {
+			logger.WithError(err).Error("Failed to delete the record of terminated instance")
+			continue
+		}
+		s.recordAction("deleted")
+		logger.Info("Deleted the record of terminated instance")
+	}
 }
 
-// startInstance starts a stopped EC2 instance
-func (s *Scheduler) startInstance(instanceID string) error {
-	return s.provider.StartInstance(instanceID)
+// backupStorage writes a backup of storage and removes the oldest backups
+func (s *Scheduler) backupStorage(now time.Time) {
+	path, err := storage.BackupToDir(s.storage, s.backups.Dir, s.backups.Keep, s.backups.Key, now)
+	if err != nil && path == "" {
+		s.logger.WithError(err).Error("Failed to back up storage")
+		return
+	}
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to remove old storage backups")
+	}
+	s.logger.WithField("path", path).Info("Backed up storage")
+}
+
+// orphanRegions returns the regions listed for orphans: those of the orphan
+// check and those of the stored instances of its provider
+func (s *Scheduler) orphanRegions(stored []*models.Instance) []string {
+	regions := append([]string(nil), s.orphans.Regions...)
+	seen := make(map[string]bool)
+	for _, region := range regions {
+		seen[region] = true
+	}
+	for _, instance := range stored {
+		name := instance.Provider
+		if name == "" {
+			name = "aws" // Recorded before instances carried their provider
+		}
+		if name != s.orphans.ProviderName || instance.Account != "" || instance.Region == "" || seen[instance.Region] {
+			continue
+		}
+		seen[instance.Region] = true
+		regions = append(regions, instance.Region)
+	}
+	return regions
+}
+
+// now returns the reference time for expiry decisions, falling back to the
+// local clock when no time source is configured or it cannot be reached
+func (s *Scheduler) now() time.Time {
+	local := time.Now()
+	if s.timeSource == nil {
+		return local
+	}
+
+	reference, err := s.timeSource.Now()
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to get reference time, using local clock")
+		return local
+	}
+
+	skew := local.Sub(reference)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > s.maxClockSkew {
+		s.logger.WithFields(logrus.Fields{
+			"local_time":     local,
+			"reference_time": reference,
+			"skew":           skew,
+		}).Warn("Detected clock skew between local host and reference time source")
+	}
+
+	return reference
+}
+
+// getInstancesWithReload gets the instances that are not terminated and
+// ensures data is fresh (max 10 seconds old). Terminated records are left to
+// the prune.
+func (s *Scheduler) getInstancesWithReload() ([]*models.Instance, error) {
+	// Force reload if data is older than reloadInterval
+	if time.Since(s.lastReload) > s.reloadInterval {
+		s.logger.Debug("Reloading instance data from storage")
+		s.lastReload = time.Now()
+	}
+
+	return s.storage.ListInstancesFiltered(models.InstanceFilter{ExcludeStates: []string{"terminated"}})
+}
+
+// processInstance handles the lifecycle of a single instance. It returns an
+// error when the instance's status cannot be read; failed actions are logged.
+func (s *Scheduler) processInstance(ctx context.Context, instance *models.Instance, now time.Time) error {
+	logger := s.logger.WithFields(logrus.Fields{
+		"instance_id": instance.ID,
+		"state":       instance.State,
+		"expires_at":  instance.ExpiresAt,
+	})
+
+	logger.Debug("Processing instance")
+
+	// Skip if instance is already terminated
+	if instance.State == "terminated" || instance.State == "terminating" {
+		logger.Debug("Instance already terminated, skipping")
+		return nil
+	}
+
+	// Get current instance status from cloud provider
+	provider, err := s.providers(instance)
+	if err != nil {
+		return fmt.Errorf("instance %s: failed to resolve its cloud provider: %w", instance.ID, err)
+	}
+	var status *models.InstanceStatus
+	err = s.call(ctx, func(ctx context.Context) (err error) {
+		status, err = provider.GetInstanceStatus(ctx, instance.ID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("instance %s: failed to get status from cloud provider: %w", instance.ID, err)
+	}
+
+	// Update local state if it differs from cloud state
+	changed := false
+	if status.State != instance.State {
+		logger.WithFields(logrus.Fields{
+			"old_state": instance.State,
+			"new_state": status.State,
+		}).Info("Instance state changed, updating local storage")
+		changed = true
+	}
+	if status.PublicIP != instance.PublicIP || status.PrivateIP != instance.PrivateIP {
+		changed = true
+	}
+	instance.State = status.State
+	instance.PublicIP = status.PublicIP
+	instance.PrivateIP = status.PrivateIP
+
+	// Data volumes are only reported once attached, so an empty list keeps the
+	// recorded IDs
+	if len(status.VolumeIDs) > 0 && !slices.Equal(status.VolumeIDs, instance.VolumeIDs) {
+		instance.VolumeIDs = status.VolumeIDs
+		changed = true
+	}
+
+	// A stop by the scheduler no longer applies once the instance runs again
+	if status.State == "running" && instance.StopReason != "" {
+		instance.StopReason = ""
+		changed = true
+	}
+
+	// Record that the provider reclaimed a spot instance, so it is not
+	// restarted while the spot request waits for capacity
+	if status.Interrupted && instance.StopReason != models.StopReasonSpotInterruption {
+		logger.WithField("new_state", status.State).Warn("Spot instance was interrupted by the cloud provider")
+		instance.StopReason = models.StopReasonSpotInterruption
+		changed = true
+	}
+
+	// Track since when the instance has been stopped
+	if status.State == "stopped" && instance.StoppedAt.IsZero() {
+		instance.StoppedAt = now
+		changed = true
+	} else if (status.State == "running" || status.State == "pending") && !instance.StoppedAt.IsZero() {
+		instance.StoppedAt = time.Time{}
+		changed = true
+	}
+
+	// Record when the instance was terminated, so its record is pruned once
+	// the retention period ends
+	if status.State == "terminated" && instance.TerminatedAt.IsZero() {
+		instance.TerminatedAt = now
+		instance.RecordEvent(now, models.EventTerminated, models.ActorScheduler, "found terminated at the provider")
+		changed = true
+	}
+
+	// Record the first time the instance is observed ready
+	if instance.MarkReady(now) {
+		logger.WithField("time_to_ready", instance.TimeToReady()).Info("Instance is ready")
+		changed = true
+	}
+
+	if !s.dryRun && s.capLifetime(instance, logger) {
+		changed = true
+	}
+
+	if changed {
+		if err := s.storage.UpdateInstance(instance); err != nil {
+			logger.WithError(err).Error("Failed to update instance in storage")
+		}
+	}
+
+	if s.dryRun {
+		instance = s.dryRunCapLifetime(instance, logger)
+	}
+
+	if s.stoppedTooLong(instance, now) {
+		s.terminateStoppedInstance(ctx, instance, now, logger)
+		return nil
+	}
+
+	// Check if instance has expired and should be stopped
+	if instance.IsExpiredAt(now) {
+		// Only stop if instance is currently running or pending
+		if status.State == "running" || status.State == "pending" {
+			if s.canAutoRenew(instance, now) {
+				s.renewInstance(instance, now, logger)
+				return nil
+			}
+			if instance.InGracePeriod(now, s.gracePeriod) {
+				s.handleGracePeriod(ctx, instance, now, logger)
+				return nil
+			}
+			s.handleExpiredInstance(ctx, instance, now, logger)
+		} else if status.State == "stopped" && s.expiryAction(instance) == models.ExpiryActionTerminate {
+			// Stopped instances still incur volume costs, which terminating
+			// them at expiry is meant to avoid
+			s.handleExpiredInstance(ctx, instance, now, logger)
+		} else {
+			logger.Debug("Instance expired but already stopped/terminated")
+		}
+		return nil
+	}
+
+	// Office hours keep the instance stopped outside their window
+	if hours := s.officeHours(instance, logger); hours != nil && !hours.Contains(now) {
+		if status.State == "running" {
+			s.stopOutsideOfficeHours(ctx, instance, hours, now, logger)
+		}
+		return nil
+	}
+
+	if s.runCronSchedules(ctx, instance, status.State, now, logger) {
+		return nil
+	}
+
+	if status.State == "running" || status.State == "pending" {
+		s.warnBeforeExpiry(ctx, instance, now, logger)
+	}
+
+	if status.State == "running" && s.isIdle(ctx, instance, now, logger) {
+		s.stopIdleInstance(ctx, instance, now, logger)
+		return nil
+	}
+
+	// Check if instance should be started (if TTL was extended and instance is stopped)
+	if instance.ExpiresAt.After(now) && (status.State == "stopped" || status.State == "stopping") {
+		s.handleStoppedInstance(ctx, instance, now, logger)
+	}
+	return nil
+}
+
+// warnBeforeExpiry warns once for the most urgent lead time the instance has
+// come within, so users get a chance to extend it before it is stopped
+func (s *Scheduler) warnBeforeExpiry(ctx context.Context, instance *models.Instance, now time.Time, logger *logrus.Entry) {
+	if s.warnings == nil {
+		return
+	}
+	leadTime, ok := dueWarning(instance, now, s.warnings.LeadTimes)
+	if !ok {
+		return
+	}
+
+	timeLeft := instance.ExpiresAt.Sub(now)
+	notification := s.newNotification(instance, notify.EventExpiryWarning, timeLeft,
+		fmt.Sprintf("Instance %s expires in %s, it will then be %s", instance.ID, utils.FormatDuration(timeLeft), s.expiryOutcome(instance)))
+	s.sendWarning(ctx, instance, notification, leadTime, logger, "Instance is about to EXPIRE - extend it to keep it running")
+}
+
+// handleGracePeriod leaves an expired instance running until its grace period
+// ends, warning once when the grace period starts
+func (s *Scheduler) handleGracePeriod(ctx context.Context, instance *models.Instance, now time.Time, logger *logrus.Entry) {
+	timeLeft := instance.StopsAt(s.gracePeriod).Sub(now)
+	if instance.WarnedExpiresAt.Equal(instance.ExpiresAt) && instance.WarnedLeadTime == 0 {
+		logger.WithField("time_left", timeLeft).Debug("Instance expired but is within its grace period")
+		return
+	}
+
+	notification := s.newNotification(instance, notify.EventGracePeriod, timeLeft,
+		fmt.Sprintf("Instance %s has expired and will be %s in %s unless it is extended", instance.ID, s.expiryOutcome(instance), utils.FormatDuration(timeLeft)))
+	s.sendWarning(ctx, instance, notification, 0, logger, "Instance has EXPIRED - it will be stopped when the grace period ends unless extended")
+}
+
+// newNotification builds a notification about instance with a ready-made
+// extend command and, when the web UI URL is known, an extend link
+func (s *Scheduler) newNotification(instance *models.Instance, event string, timeLeft time.Duration, message string) notify.Notification {
+	notification := notify.Notification{
+		Event:         event,
+		InstanceID:    instance.ID,
+		InstanceName:  instance.Name,
+		ExpiresAt:     instance.ExpiresAt,
+		TimeLeft:      timeLeft,
+		Message:       message,
+		ExtendCommand: notify.ExtendCommand(instance.ID, warningExtension),
+	}
+	if s.warnings != nil {
+		notification.ExtendURL = notify.ExtendURL(s.warnings.WebURL, instance.ID)
+	}
+	return notification
+}
+
+// capLifetime moves the expiry of an instance that would outlive the maximum
+// lifetime back to the end of it and reports whether it did
+func (s *Scheduler) capLifetime(instance *models.Instance, logger *logrus.Entry) bool {
+	if utils.ValidateLifetime(instance.LaunchTime, instance.ExpiresAt, s.maxLifetime) == nil {
+		return false
+	}
+	end := instance.LaunchTime.Add(s.maxLifetime)
+	logger.WithFields(logrus.Fields{
+		"expires_at":     instance.ExpiresAt,
+		"max_lifetime":   s.maxLifetime,
+		"new_expires_at": end,
+	}).Warn("Instance TTL exceeded the maximum lifetime - moved its expiry back")
+
+	instance.Duration -= instance.ExpiresAt.Sub(end)
+	instance.ExpiresAt = end
+	return true
+}
+
+// dryRunCapLifetime logs the expiry capLifetime would move back and returns
+// a copy of the instance with the capped expiry, so the rest of the pass
+// reports the stop it leads to. Storage keeps the original expiry.
+func (s *Scheduler) dryRunCapLifetime(instance *models.Instance, logger *logrus.Entry) *models.Instance {
+	if utils.ValidateLifetime(instance.LaunchTime, instance.ExpiresAt, s.maxLifetime) == nil {
+		return instance
+	}
+	end := instance.LaunchTime.Add(s.maxLifetime)
+	s.skipInDryRun(instance, "cap-lifetime", logger.WithFields(logrus.Fields{
+		"expires_at":     instance.ExpiresAt,
+		"max_lifetime":   s.maxLifetime,
+		"new_expires_at": end,
+	}), "Would move the expiry back to the end of the maximum lifetime")
+
+	capped := *instance
+	capped.Duration -= capped.ExpiresAt.Sub(end)
+	capped.ExpiresAt = end
+	return &capped
+}
+
+// lifetimeExceeded reports whether the instance expires because it reached
+// the maximum lifetime rather than the end of its TTL
+func (s *Scheduler) lifetimeExceeded(instance *models.Instance) bool {
+	return s.maxLifetime > 0 && !instance.LaunchTime.IsZero() && instance.ExpiresAt.Equal(instance.LaunchTime.Add(s.maxLifetime))
+}
+
+// stoppedTooLong reports whether the instance has been stopped for longer than
+// the scheduler allows and will not be restarted
+func (s *Scheduler) stoppedTooLong(instance *models.Instance, now time.Time) bool {
+	if s.terminateStoppedAfter <= 0 || instance.State != "stopped" || instance.StoppedAt.IsZero() {
+		return false
+	}
+	if now.Sub(instance.StoppedAt) <= s.terminateStoppedAfter {
+		return false
+	}
+	restart, _ := shouldRestart(instance)
+	return instance.IsExpiredAt(now) || !restart
+}
+
+// terminateStoppedInstance terminates an instance that stayed stopped too
+// long and moves its record to the archive
+func (s *Scheduler) terminateStoppedInstance(ctx context.Context, instance *models.Instance, now time.Time, logger *logrus.Entry) {
+	stoppedFor := now.Sub(instance.StoppedAt)
+	logger = logger.WithField("stopped_for", stoppedFor)
+	if s.skipInDryRun(instance, "terminate", logger, "Would terminate instance that stayed stopped too long and archive its record") {
+		return
+	}
+
+	provider, err := s.providers(instance)
+	if err != nil {
+		logger.WithError(err).Error("Failed to resolve the instance's cloud provider")
+		return
+	}
+	if err := s.runHooks(ctx, hooks.PreTerminate, models.ArchiveReasonStoppedTooLong, instance, logger); err != nil {
+		logger.WithError(err).Error("Failed to terminate instance that stayed stopped too long")
+		return
+	}
+	err = s.call(ctx, func(ctx context.Context) error {
+		return provider.TerminateInstance(ctx, instance.ID)
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to terminate instance that stayed stopped too long")
+		return
+	}
+
+	instance.State = "terminating"
+	instance.RecordEvent(now, models.EventTerminated, models.ActorScheduler, "stopped too long")
+	if err := s.storage.UpdateInstance(instance); err != nil {
+		logger.WithError(err).Error("Failed to update instance state in storage")
+	}
+	if err := s.storage.ArchiveInstance(instance.ID, models.ArchiveReasonStoppedTooLong); err != nil {
+		logger.WithError(err).Error("Failed to archive terminated instance")
+	}
+
+	s.recordAction("terminated")
+	logger.WithField("action", "terminated").Warn("Instance stayed stopped too long - terminated it and archived its record")
+	s.notify(ctx, notify.Notification{
+		Event:        notify.EventTerminated,
+		InstanceID:   instance.ID,
+		InstanceName: instance.Name,
+		ExpiresAt:    instance.ExpiresAt,
+		Message:      fmt.Sprintf("Instance %s was terminated after being stopped for %s", instance.ID, utils.FormatDuration(stoppedFor)),
+	}, logger)
+}
+
+// officeHours returns the instance's office hours, or nil when it has none or
+// they cannot be parsed
+func (s *Scheduler) officeHours(instance *models.Instance, logger *logrus.Entry) *models.OfficeHours {
+	hours, err := instance.GetOfficeHours()
+	if err != nil {
+		logger.WithError(err).Warn("Ignoring invalid office hours")
+		return nil
+	}
+	return hours
+}
+
+// stopOutsideOfficeHours stops a running instance whose office hours have
+// closed. It is restarted when they open again.
+func (s *Scheduler) stopOutsideOfficeHours(ctx context.Context, instance *models.Instance, hours *models.OfficeHours, now time.Time, logger *logrus.Entry) {
+	if s.skipInDryRun(instance, "stop", logger, "Would stop instance outside its office hours") {
+		return
+	}
+	if err := s.stopInstance(ctx, instance, models.StopReasonOfficeHours, logger); err != nil {
+		logger.WithError(err).Error("Failed to stop instance outside its office hours")
+		return
+	}
+
+	instance.State = "stopping"
+	instance.StopReason = models.StopReasonOfficeHours
+	instance.RecordEvent(now, models.EventStopped, models.ActorScheduler, "outside office hours")
+	if err := s.storage.UpdateInstance(instance); err != nil {
+		logger.WithError(err).Error("Failed to update instance state in storage")
+	}
+
+	s.recordAction("stopped")
+	logger.WithFields(logrus.Fields{
+		"office_hours": instance.OfficeHours,
+		"next_open":    hours.NextOpen(now),
+		"action":       "stopped",
+	}).Info("Instance is outside its office hours - stopped it")
+}
+
+// runCronSchedules stops or starts the instance when its stop or start
+// schedule fired since the previous pass; the later firing wins. It reports
+// whether a schedule fired.
+func (s *Scheduler) runCronSchedules(ctx context.Context, instance *models.Instance, state string, now time.Time, logger *logrus.Entry) bool {
+	if instance.StopCron == "" && instance.StartCron == "" {
+		return false
+	}
+	s.mu.Lock()
+	since, ok := s.cronChecked[instance.ID]
+	if !ok {
+		since = now.Add(-s.interval)
+	}
+	s.cronChecked[instance.ID] = now
+	s.mu.Unlock()
+
+	stopAt, err := lastCronFire(instance.StopCron, since, now)
+	if err != nil {
+		logger.WithError(err).Warn("Ignoring invalid stop schedule")
+	}
+	startAt, err := lastCronFire(instance.StartCron, since, now)
+	if err != nil {
+		logger.WithError(err).Warn("Ignoring invalid start schedule")
+	}
+
+	switch {
+	case !stopAt.IsZero() && !stopAt.Before(startAt):
+		if state == "running" {
+			s.stopOnSchedule(ctx, instance, logger)
+		}
+		return true
+	case !startAt.IsZero():
+		if state == "stopped" {
+			s.startOnSchedule(ctx, instance, now, logger)
+		}
+		return true
+	}
+	return false
+}
+
+// lastCronFire returns the last time in (since, now] at which the cron
+// expression fired, or the zero time if it did not fire
+func lastCronFire(expr string, since, now time.Time) (time.Time, error) {
+	if expr == "" {
+		return time.Time{}, nil
+	}
+	schedule, err := models.ParseCron(expr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var last time.Time
+	for next := schedule.Next(since); !next.IsZero() && !next.After(now); next = schedule.Next(next) {
+		last = next
+	}
+	return last, nil
+}
+
+// stopOnSchedule stops a running instance whose stop schedule fired. It is
+// not restarted until its start schedule fires or its TTL is extended.
+func (s *Scheduler) stopOnSchedule(ctx context.Context, instance *models.Instance, logger *logrus.Entry) {
+	if s.skipInDryRun(instance, "stop", logger, "Would stop instance on its stop schedule") {
+		return
+	}
+	if err := s.stopInstance(ctx, instance, models.StopReasonCron, logger); err != nil {
+		logger.WithError(err).Error("Failed to stop instance on its stop schedule")
+		return
+	}
+
+	instance.State = "stopping"
+	instance.StopReason = models.StopReasonCron
+	instance.RecordEvent(s.now(), models.EventStopped, models.ActorScheduler, "stop schedule")
+	if err := s.storage.UpdateInstance(instance); err != nil {
+		logger.WithError(err).Error("Failed to update instance state in storage")
+	}
+
+	s.recordAction("stopped")
+	logger.WithFields(logrus.Fields{
+		"stop_cron": instance.StopCron,
+		"action":    "stopped",
+	}).Info("Stopped instance on its stop schedule")
+}
+
+// startOnSchedule starts a stopped instance whose start schedule fired
+func (s *Scheduler) startOnSchedule(ctx context.Context, instance *models.Instance, now time.Time, logger *logrus.Entry) {
+	if instance.Unhealthy || instance.StopReason == models.StopReasonSpotInterruption {
+		logger.Debug("Not starting an unhealthy or interrupted instance on its start schedule")
+		return
+	}
+	if s.skipInDryRun(instance, "start", logger, "Would start instance on its start schedule") {
+		return
+	}
+	if err := s.startInstance(ctx, instance); err != nil {
+		logger.WithError(err).Error("Failed to start instance on its start schedule")
+		return
+	}
+
+	instance.State = "pending"
+	instance.StopReason = ""
+	instance.RecordEvent(now, models.EventStarted, models.ActorScheduler, "start schedule")
+	if err := s.storage.UpdateInstance(instance); err != nil {
+		logger.WithError(err).Error("Failed to update instance state in storage")
+	}
+
+	s.recordAction("started")
+	logger.WithFields(logrus.Fields{
+		"start_cron":     instance.StartCron,
+		"time_remaining": instance.ExpiresAt.Sub(now),
+		"action":         "started",
+	}).Info("Started instance on its start schedule")
+}
+
+// isIdle checks the utilization of a running instance against the idle
+// policy, at most once per idleCheckInterval
+func (s *Scheduler) isIdle(ctx context.Context, instance *models.Instance, now time.Time, logger *logrus.Entry) bool {
+	if s.idle == nil || instance.KeepWhenIdle || instance.GetRestartPolicy() == models.RestartPolicyAlways {
+		return false
+	}
+	s.mu.Lock()
+	last, checked := s.idleChecked[instance.ID]
+	s.mu.Unlock()
+	if checked && now.Sub(last) < idleCheckInterval {
+		return false
+	}
+
+	provider, err := s.providers(instance)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to resolve the instance's cloud provider")
+		return false
+	}
+	reporter, ok := provider.(cloud.UtilizationReporter)
+	if !ok {
+		return false
+	}
+	s.mu.Lock()
+	s.idleChecked[instance.ID] = now
+	s.mu.Unlock()
+
+	var usage *models.Utilization
+	err = s.call(ctx, func(ctx context.Context) (err error) {
+		usage, err = reporter.Utilization(ctx, instance.ID, s.idle.Window)
+		return err
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Failed to get instance utilization")
+		return false
+	}
+
+	logger.WithFields(logrus.Fields{
+		"cpu_percent":              usage.CPUPercent,
+		"network_bytes_per_second": usage.NetworkBytesPerSecond,
+		"covered":                  usage.Covered,
+	}).Debug("Checked instance utilization")
+	return s.idle.Idle(usage)
+}
+
+// stopIdleInstance stops an instance that stayed idle for the idle window.
+// It is not restarted until its TTL is extended.
+func (s *Scheduler) stopIdleInstance(ctx context.Context, instance *models.Instance, now time.Time, logger *logrus.Entry) {
+	if s.skipInDryRun(instance, "stop", logger, "Would stop idle instance") {
+		return
+	}
+	if err := s.stopInstance(ctx, instance, models.StopReasonIdle, logger); err != nil {
+		logger.WithError(err).Error("Failed to stop idle instance")
+		return
+	}
+
+	s.mu.Lock()
+	delete(s.idleChecked, instance.ID)
+	s.mu.Unlock()
+	instance.State = "stopping"
+	instance.StopReason = models.StopReasonIdle
+	instance.RecordEvent(now, models.EventStopped, models.ActorScheduler, "idle")
+	if err := s.storage.UpdateInstance(instance); err != nil {
+		logger.WithError(err).Error("Failed to update instance state in storage")
+	}
+
+	s.recordAction("stopped")
+	logger.WithFields(logrus.Fields{
+		"idle_window": s.idle.Window,
+		"action":      "stopped",
+	}).Warn("Instance was idle - stopped it before expiry")
+	s.notify(ctx, s.newNotification(instance, notify.EventIdleStopped, instance.ExpiresAt.Sub(now),
+		fmt.Sprintf("Instance %s was idle for %s and has been stopped. Extend it to start it again.", instance.ID, utils.FormatDuration(s.idle.Window))), logger)
+}
+
+// notify sends the notification to the notifier, if one is set
+func (s *Scheduler) notify(ctx context.Context, notification notify.Notification, logger *logrus.Entry) {
+	if s.notifier == nil {
+		return
+	}
+	callCtx, cancel := s.callContext(ctx)
+	err := s.notifier.Notify(callCtx, notification)
+	cancel()
+	if err != nil {
+		logger.WithError(err).Error("Failed to send notification")
+	}
+}
+
+// sendWarning logs the notification, sends it to the notifier and records
+// the warning on the instance so it is not repeated. A leadTime of zero marks
+// the grace period warning.
+func (s *Scheduler) sendWarning(ctx context.Context, instance *models.Instance, notification notify.Notification, leadTime time.Duration, logger *logrus.Entry, msg string) {
+	logger = logger.WithFields(logrus.Fields{
+		"time_left":      notification.TimeLeft,
+		"extend_command": notification.ExtendCommand,
+	})
+	if notification.ExtendURL != "" {
+		logger = logger.WithField("extend_url", notification.ExtendURL)
+	}
+	if s.skipInDryRun(instance, "warn", logger, "Would warn: "+msg) {
+		return
+	}
+	logger.Warn(msg)
+	s.notify(ctx, notification, logger)
+
+	instance.WarnedExpiresAt = instance.ExpiresAt
+	instance.WarnedLeadTime = leadTime
+	if err := s.storage.UpdateInstance(instance); err != nil {
+		logger.WithError(err).Error("Failed to record warning in storage")
+	}
+}
+
+// dueWarning returns the most urgent of the lead times, sorted longest first,
+// that the instance has come within and not yet been warned about for its
+// current expiry time
+func dueWarning(instance *models.Instance, now time.Time, leadTimes []time.Duration) (time.Duration, bool) {
+	timeLeft := instance.ExpiresAt.Sub(now)
+	var due time.Duration
+	for _, leadTime := range leadTimes {
+		if timeLeft <= leadTime {
+			due = leadTime
+		}
+	}
+	if due == 0 {
+		return 0, false
+	}
+	if instance.WarnedExpiresAt.Equal(instance.ExpiresAt) && instance.WarnedLeadTime <= due {
+		return 0, false
+	}
+	return due, true
+}
+
+// expiryAction returns the instance's expiry action, or the default expiry
+// action when it has none
+func (s *Scheduler) expiryAction(instance *models.Instance) string {
+	return instance.ExpiryActionOr(s.defaultExpiryAction)
+}
+
+// expiryOutcome describes what happens to the instance at expiry
+func (s *Scheduler) expiryOutcome(instance *models.Instance) string {
+	switch s.expiryAction(instance) {
+	case models.ExpiryActionHibernate:
+		return "hibernated"
+	case models.ExpiryActionTerminate:
+		return "terminated"
+	default:
+		return "stopped"
+	}
+}
+
+// handleExpiredInstance stops, or hibernates, an expired instance so it can
+// be restarted once its TTL is extended, unless its expiry action is to
+// terminate it
+func (s *Scheduler) handleExpiredInstance(ctx context.Context, instance *models.Instance, now time.Time, logger *logrus.Entry) {
+	timeOverdue := now.Sub(instance.ExpiresAt)
+	reason := ""
+	if s.lifetimeExceeded(instance) {
+		reason = " (max lifetime exceeded)"
+	}
+	if s.skipInDryRun(instance, "expire", logger.WithField("overdue_duration", timeOverdue), "Instance has EXPIRED - it would be "+s.expiryOutcome(instance)+reason) {
+		return
+	}
+
+	if s.expiryAction(instance) == models.ExpiryActionTerminate {
+		s.terminateExpiredInstance(ctx, instance, now, logger.WithField("overdue_duration", timeOverdue), reason)
+		return
+	}
+
+	logger.WithField("overdue_duration", timeOverdue).Warn("Instance has EXPIRED" + reason + " - stopping instance (can be restarted if TTL extended)")
+
+	// Stop the instance (not terminate)
+	action, err := s.stopExpiredInstance(ctx, instance, logger)
+	if err != nil {
+		logger.WithError(err).Error("Failed to stop expired instance")
+		return
+	}
+
+	// Update instance state in storage
+	instance.State = "stopping"
+	detail := "expired"
+	if action == "hibernated" {
+		detail = "hibernated at expiry"
+	}
+	instance.RecordEvent(now, models.EventStopped, models.ActorScheduler, detail+reason)
+	if err := s.storage.UpdateInstance(instance); err != nil {
+		logger.WithError(err).Error("Failed to update instance state in storage")
+	}
+
+	s.recordAction(action)
+	logger.WithFields(logrus.Fields{
+		"overdue_duration": timeOverdue,
+		"action":           action,
+	}).Info("✅ Successfully stopped expired instance (can be restarted)")
+}
+
+// stopExpiredInstance takes the snapshots and image the instance asks for and
+// stops it according to its expiry action, returning the action taken. The
+// backups are part of the hooked stop, so a pre-stop hook that aborts skips
+// them too. Hibernation falls back to a plain stop when the provider cannot
+// hibernate or the hibernation fails, for example because the instance has
+// not finished preparing for it after launch.
+func (s *Scheduler) stopExpiredInstance(ctx context.Context, instance *models.Instance, logger *logrus.Entry) (action string, err error) {
+	err = s.withStopHooks(ctx, instance, stopReasonExpired, logger, func() error {
+		if instance.SnapshotOnExpiry && !instance.SnapshottedExpiresAt.Equal(instance.ExpiresAt) {
+			s.snapshotVolumes(ctx, instance, logger)
+		}
+		if instance.ImageOnExpiry && !instance.ImagedExpiresAt.Equal(instance.ExpiresAt) {
+			s.createImage(ctx, instance, logger)
+		}
+
+		if s.expiryAction(instance) == models.ExpiryActionHibernate {
+			provider, err := s.providers(instance)
+			if err != nil {
+				return err
+			}
+			if hibernator, ok := provider.(cloud.Hibernator); ok {
+				err := s.call(ctx, func(ctx context.Context) error {
+					return hibernator.HibernateInstance(ctx, instance.ID)
+				})
+				if err == nil {
+					action = "hibernated"
+					return nil
+				}
+				logger.WithError(err).Warn("Failed to hibernate expired instance, stopping it instead")
+			} else {
+				logger.Warn("Cloud provider does not support hibernation, stopping the instance instead")
+			}
+		}
+		action = "stopped"
+		return s.stopOnProvider(ctx, instance)
+	})
+	return action, err
+}
+
+// terminateExpiredInstance terminates an expired instance whose expiry action
+// is terminate, taking the snapshots and image it asks for first, and moves
+// its record to the archive. A pre-terminate hook that aborts skips the
+// backups too.
+func (s *Scheduler) terminateExpiredInstance(ctx context.Context, instance *models.Instance, now time.Time, logger *logrus.Entry, reason string) {
+	provider, err := s.providers(instance)
+	if err != nil {
+		logger.WithError(err).Error("Failed to resolve the instance's cloud provider")
+		return
+	}
+	if err := s.runHooks(ctx, hooks.PreTerminate, stopReasonExpired, instance, logger); err != nil {
+		logger.WithError(err).Error("Failed to terminate expired instance")
+		return
+	}
+
+	logger.Warn("Instance has EXPIRED" + reason + " - terminating instance")
+	if instance.SnapshotOnExpiry && !instance.SnapshottedExpiresAt.Equal(instance.ExpiresAt) {
+		s.snapshotVolumes(ctx, instance, logger)
+	}
+	if instance.ImageOnExpiry && !instance.ImagedExpiresAt.Equal(instance.ExpiresAt) {
+		s.createImage(ctx, instance, logger)
+	}
+	err = s.call(ctx, func(ctx context.Context) error {
+		return provider.TerminateInstance(ctx, instance.ID)
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to terminate expired instance")
+		return
+	}
+
+	instance.State = "terminating"
+	instance.TerminatedAt = now
+	instance.RecordEvent(now, models.EventTerminated, models.ActorScheduler, "expired"+reason)
+	if err := s.storage.UpdateInstance(instance); err != nil {
+		logger.WithError(err).Error("Failed to update instance state in storage")
+	}
+	if err := s.storage.ArchiveInstance(instance.ID, models.ArchiveReasonExpired); err != nil {
+		logger.WithError(err).Error("Failed to archive terminated instance")
+	}
+
+	s.recordAction("terminated")
+	logger.WithField("action", "terminated").Info("Terminated expired instance and archived its record")
+	s.notify(ctx, notify.Notification{
+		Event:        notify.EventTerminated,
+		InstanceID:   instance.ID,
+		InstanceName: instance.Name,
+		ExpiresAt:    instance.ExpiresAt,
+		Message:      fmt.Sprintf("Instance %s was terminated at expiry", instance.ID),
+	}, logger)
+}
+
+// snapshotVolumes snapshots the volumes of an expiring instance and records
+// the snapshots in storage. A failed snapshot is logged but does not keep the
+// instance running; stopping it leaves the volumes intact. Once any snapshot
+// is taken the instance is marked as snapshotted for its current expiry.
+func (s *Scheduler) snapshotVolumes(ctx context.Context, instance *models.Instance, logger *logrus.Entry) {
+	provider, err := s.providers(instance)
+	if err != nil {
+		logger.WithError(err).Error("Failed to resolve the instance's cloud provider for snapshots")
+		return
+	}
+	snapshotter, ok := provider.(cloud.VolumeSnapshotter)
+	if !ok {
+		logger.Warn("Cloud provider does not support volume snapshots, stopping without one")
+		return
+	}
+
+	callCtx, cancel := s.callContext(ctx)
+	records, err := snapshotter.SnapshotVolumes(callCtx, instance.ID)
+	cancel()
+	for _, record := range records {
+		if err := s.storage.RecordSnapshot(record); err != nil {
+			logger.WithField("snapshot_id", record.SnapshotID).WithError(err).Error("Failed to record snapshot in storage")
+		}
+		logger.WithFields(logrus.Fields{
+			"snapshot_id": record.SnapshotID,
+			"volume_id":   record.VolumeID,
+		}).Info("Snapshotted volume of expired instance")
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to snapshot volumes of expired instance")
+	}
+	if err == nil || len(records) > 0 {
+		instance.SnapshottedExpiresAt = instance.ExpiresAt
+		if err := s.storage.UpdateInstance(instance); err != nil {
+			logger.WithError(err).Error("Failed to record snapshots on the instance in storage")
+		}
+	}
+}
+
+// createImage creates a machine image of an expiring instance and records it
+// in storage. Like snapshots, a failure is logged and the instance is stopped
+// anyway, and a created image marks the instance as imaged for its current
+// expiry.
+func (s *Scheduler) createImage(ctx context.Context, instance *models.Instance, logger *logrus.Entry) {
+	provider, err := s.providers(instance)
+	if err != nil {
+		logger.WithError(err).Error("Failed to resolve the instance's cloud provider for the image")
+		return
+	}
+	creator, ok := provider.(cloud.ImageCreator)
+	if !ok {
+		logger.Warn("Cloud provider does not support machine images, stopping without one")
+		return
+	}
+
+	callCtx, cancel := s.callContext(ctx)
+	record, err := creator.CreateImage(callCtx, instance.ID, "")
+	cancel()
+	if err != nil {
+		logger.WithError(err).Error("Failed to create image of expired instance")
+		return
+	}
+	if err := s.storage.RecordImage(record); err != nil {
+		logger.WithField("image_id", record.ImageID).WithError(err).Error("Failed to record image in storage")
+	}
+	instance.ImagedExpiresAt = instance.ExpiresAt
+	if err := s.storage.UpdateInstance(instance); err != nil {
+		logger.WithError(err).Error("Failed to record the image on the instance in storage")
+	}
+	logger.WithField("image_id", record.ImageID).Info("Created image of expired instance")
+}
+
+// canAutoRenew reports whether an expired instance should be renewed: auto-renew
+// is enabled, the daily cutoff has not passed and the instance is not too old
+func (s *Scheduler) canAutoRenew(instance *models.Instance, now time.Time) bool {
+	if s.autoRenew == nil || s.autoRenew.Increment <= 0 {
+		return false
+	}
+	if utils.ValidateLifetime(instance.LaunchTime, now, s.maxLifetime) != nil {
+		return false
+	}
+
+	local := now.In(s.autoRenew.Location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, s.autoRenew.Location)
+	if local.Sub(midnight) >= s.autoRenew.Until {
+		return false
+	}
+
+	if s.autoRenew.MaxAge > 0 && now.Sub(instance.LaunchTime) >= s.autoRenew.MaxAge {
+		return false
+	}
+	return true
+}
+
+// renewInstance extends the TTL of an expired instance by the auto-renew increment
+func (s *Scheduler) renewInstance(instance *models.Instance, now time.Time, logger *logrus.Entry) {
+	if s.skipInDryRun(instance, "renew", logger, "Would extend the TTL of expired instance by "+s.autoRenew.Increment.String()) {
+		return
+	}
+	oldExpiresAt := instance.ExpiresAt
+	for !instance.ExpiresAt.After(now) {
+		instance.ExpiresAt = instance.ExpiresAt.Add(s.autoRenew.Increment)
+		instance.Duration += s.autoRenew.Increment
+	}
+	s.capLifetime(instance, logger)
+	instance.RecordEvent(now, models.EventExtended, models.ActorScheduler, "auto-renewed until "+instance.ExpiresAt.Format(time.RFC3339))
+
+	if err := s.storage.UpdateInstance(instance); err != nil {
+		logger.WithError(err).Error("Failed to save renewed instance")
+		return
+	}
+
+	s.recordAction("renewed")
+	logger.WithFields(logrus.Fields{
+		"old_expires_at": oldExpiresAt,
+		"new_expires_at": instance.ExpiresAt,
+		"action":         "renewed",
+	}).Info("Instance expired before the auto-renew cutoff - extended TTL")
+}
+
+// handleStoppedInstance starts a stopped instance if its TTL was extended and
+// its restart policy allows it
+func (s *Scheduler) handleStoppedInstance(ctx context.Context, instance *models.Instance, now time.Time, logger *logrus.Entry) {
+	logger = logger.WithField("restart_policy", instance.GetRestartPolicy())
+	if ok, reason := shouldRestart(instance); !ok {
+		logger.Debug("Not restarting stopped instance: " + reason)
+		return
+	}
+
+	// Opening office hours is a planned start, not a recovery
+	if instance.StopReason == models.StopReasonOfficeHours {
+		s.startInOfficeHours(ctx, instance, now, logger)
+		return
+	}
+
+	if s.maxRestarts > 0 && instance.RestartCount >= s.maxRestarts {
+		if s.skipInDryRun(instance, "mark-unhealthy", logger, "Would mark instance unhealthy after too many restarts") {
+			return
+		}
+		// Likely a crash loop: stop restarting and flag the instance once
+		instance.Unhealthy = true
+		instance.RecordEvent(now, models.EventUnhealthy, models.ActorScheduler, fmt.Sprintf("%d restarts", instance.RestartCount))
+		if err := s.storage.UpdateInstance(instance); err != nil {
+			logger.WithError(err).Error("Failed to update instance in storage")
+		}
+		logger.WithFields(logrus.Fields{
+			"restart_count": instance.RestartCount,
+			"max_restarts":  s.maxRestarts,
+		}).Error("Instance reached the maximum number of restarts - marking unhealthy and leaving it stopped")
+		return
+	}
+
+	timeRemaining := instance.ExpiresAt.Sub(now)
+	if s.skipInDryRun(instance, "restart", logger.WithField("time_remaining", timeRemaining), "Would restart stopped instance") {
+		return
+	}
+
+	logger.WithField("time_remaining", timeRemaining).Info("Instance TTL was EXTENDED - restarting stopped instance")
+
+	// Start the instance
+	if err := s.startInstance(ctx, instance); err != nil {
+		logger.WithError(err).Error("Failed to start stopped instance")
+		return
+	}
+
+	// Update instance state in storage
+	instance.State = "pending"
+	instance.RestartCount++
+	instance.RecordEvent(now, models.EventRestarted, models.ActorScheduler, "TTL extended")
+	if err := s.storage.UpdateInstance(instance); err != nil {
+		logger.WithError(err).Error("Failed to update instance state in storage")
+	}
+
+	s.recordAction("restarted")
+	logger.WithFields(logrus.Fields{
+		"time_remaining": timeRemaining,
+		"restart_count":  instance.RestartCount,
+		"action":         "restarted",
+	}).Info("🚀 Successfully restarted instance due to TTL extension")
+}
+
+// startInOfficeHours starts an instance that was stopped outside its office
+// hours once they open
+func (s *Scheduler) startInOfficeHours(ctx context.Context, instance *models.Instance, now time.Time, logger *logrus.Entry) {
+	if s.skipInDryRun(instance, "start", logger, "Would start instance in its office hours") {
+		return
+	}
+	if err := s.startInstance(ctx, instance); err != nil {
+		logger.WithError(err).Error("Failed to start instance in its office hours")
+		return
+	}
+
+	instance.State = "pending"
+	instance.RecordEvent(now, models.EventStarted, models.ActorScheduler, "office hours")
+	if err := s.storage.UpdateInstance(instance); err != nil {
+		logger.WithError(err).Error("Failed to update instance state in storage")
+	}
+
+	s.recordAction("started")
+	logger.WithFields(logrus.Fields{
+		"office_hours":   instance.OfficeHours,
+		"time_remaining": instance.ExpiresAt.Sub(now),
+		"action":         "started",
+	}).Info("Office hours opened - started instance")
+}
+
+// shouldRestart reports whether the restart policy allows restarting a stopped
+// instance with a future TTL, and the reason when it does not
+func shouldRestart(instance *models.Instance) (bool, string) {
+	if instance.Unhealthy {
+		return false, "instance is marked unhealthy after too many restarts"
+	}
+	if instance.StopReason == models.StopReasonSpotInterruption {
+		return false, "spot instance was interrupted, its spot request restarts it when capacity returns"
+	}
+	if instance.StopReason == models.StopReasonOfficeHours {
+		return true, ""
+	}
+	if instance.StopReason == models.StopReasonCron {
+		return false, "stopped by its stop schedule, waiting for its start schedule or a TTL extension"
+	}
+
+	switch instance.GetRestartPolicy() {
+	case models.RestartPolicyNever:
+		return false, "restart policy is never"
+	case models.RestartPolicyAlways:
+		return true, ""
+	default:
+		switch instance.StopReason {
+		case models.StopReasonBudget:
+			return false, "stopped to meet the daily budget, waiting for TTL extension"
+		case models.StopReasonIdle:
+			return false, "stopped while idle, waiting for TTL extension"
+		}
+		return true, ""
+	}
+}
+
+// skipInDryRun reports whether the scheduler runs in dry-run mode, logging the
+// action it would have taken on the instance instead. An action already
+// logged in the previous pass is only logged at debug level, so a pending
+// action does not repeat on every pass.
+func (s *Scheduler) skipInDryRun(instance *models.Instance, action string, logger *logrus.Entry, msg string) bool {
+	if !s.dryRun {
+		return false
+	}
+	logger = logger.WithFields(logrus.Fields{
+		"action":  action,
+		"dry_run": true,
+	})
+	s.recordAction(action)
+	key := instance.ID + ": " + msg
+	s.mu.Lock()
+	s.dryRunActions[key] = true
+	repeated := s.dryRunPrevious[key]
+	s.mu.Unlock()
+	if repeated {
+		logger.Debug(msg)
+		return true
+	}
+	logger.Warn(msg)
+	return true
+}
+
+// startInstance starts a stopped instance through its provider
+func (s *Scheduler) startInstance(ctx context.Context, instance *models.Instance) error {
+	provider, err := s.providers(instance)
+	if err != nil {
+		return err
+	}
+	return s.call(ctx, func(ctx context.Context) error {
+		return provider.StartInstance(ctx, instance.ID)
+	})
+}
+
+// stopInstance stops an instance through its provider for reason, running
+// the stop hooks around it
+func (s *Scheduler) stopInstance(ctx context.Context, instance *models.Instance, reason string, logger *logrus.Entry) error {
+	return s.withStopHooks(ctx, instance, reason, logger, func() error {
+		return s.stopOnProvider(ctx, instance)
+	})
+}
+
+// withStopHooks runs the pre-stop hooks, stop and then the post-stop hooks.
+// A pre-stop hook that aborts skips the stop and is returned as its error.
+func (s *Scheduler) withStopHooks(ctx context.Context, instance *models.Instance, reason string, logger *logrus.Entry, stop func() error) error {
+	if err := s.runHooks(ctx, hooks.PreStop, reason, instance, logger); err != nil {
+		return err
+	}
+	if err := stop(); err != nil {
+		return err
+	}
+	return s.runHooks(ctx, hooks.PostStop, reason, instance, logger)
+}
+
+// runHooks runs the lifecycle hooks of event for the instance. It returns an
+// error only when a hook aborts the action; other failures are logged.
+func (s *Scheduler) runHooks(ctx context.Context, event, reason string, instance *models.Instance, logger *logrus.Entry) error {
+	if s.hooks == nil {
+		return nil
+	}
+	err := s.hooks.Run(ctx, hooks.NewPayload(event, reason, instance))
+	if errors.Is(err, hooks.ErrAborted) {
+		return err
+	}
+	if err != nil {
+		logger.WithError(err).Warn("Lifecycle hook failed, proceeding")
+	}
+	return nil
+}
+
+// stopOnProvider stops the instance through its cloud provider
+func (s *Scheduler) stopOnProvider(ctx context.Context, instance *models.Instance) error {
+	provider, err := s.providers(instance)
+	if err != nil {
+		return err
+	}
+	return s.call(ctx, func(ctx context.Context) error {
+		return provider.StopInstance(ctx, instance.ID)
+	})
+}
+
+// call makes an idempotent provider call, retrying transient failures under
+// the retry policy with each attempt limited by the call timeout
+func (s *Scheduler) call(ctx context.Context, op func(ctx context.Context) error) error {
+	return s.retry.Do(ctx, func(ctx context.Context) error {
+		callCtx, cancel := s.callContext(ctx)
+		defer cancel()
+		return op(callCtx)
+	})
+}
+
+// instanceContext returns the context for processing a single instance in
+// the pass of ctx. It is cancelled with ctx or when the instance timeout
+// elapses.
+func (s *Scheduler) instanceContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.instanceTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.instanceTimeout)
+}
+
+// callContext returns the context for a single provider call made on behalf
+// of ctx. It is cancelled with ctx or when the call timeout elapses.
+func (s *Scheduler) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.callTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.callTimeout)
 }
 
 // RunOnce executes the scheduler logic once (useful for testing and manual runs)
@@ -1,12 +1,23 @@
 package scheduler_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"instance-manager/internal/scheduler"
+	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/hooks"
 	"instance-manager/pkg/models"
+	"instance-manager/pkg/notify"
 	"instance-manager/pkg/storage"
 
 	"github.com/sirupsen/logrus"
@@ -29,12 +40,12 @@ func NewMockProvider() *MockProvider {
 	}
 }
 
-func (m *MockProvider) CreateInstance(config models.InstanceConfig) (*models.Instance, error) {
+func (m *MockProvider) CreateInstance(ctx context.Context, config models.InstanceConfig) (*models.Instance, error) {
 	// Not used in scheduler tests
 	return nil, nil
 }
 
-func (m *MockProvider) GetInstanceStatus(instanceID string) (*models.InstanceStatus, error) {
+func (m *MockProvider) GetInstanceStatus(ctx context.Context, instanceID string) (*models.InstanceStatus, error) {
 	if status, exists := m.instances[instanceID]; exists {
 		return status, nil
 	}
@@ -49,7 +60,7 @@ func (m *MockProvider) GetInstanceStatus(instanceID string) (*models.InstanceSta
 	}, nil
 }
 
-func (m *MockProvider) StartInstance(instanceID string) error {
+func (m *MockProvider) StartInstance(ctx context.Context, instanceID string) error {
 	m.startCalls = append(m.startCalls, instanceID)
 	if status, exists := m.instances[instanceID]; exists {
 		status.State = "pending"
@@ -57,7 +68,7 @@ func (m *MockProvider) StartInstance(instanceID string) error {
 	return nil
 }
 
-func (m *MockProvider) StopInstance(instanceID string) error {
+func (m *MockProvider) StopInstance(ctx context.Context, instanceID string) error {
 	m.stopCalls = append(m.stopCalls, instanceID)
 	if status, exists := m.instances[instanceID]; exists {
 		status.State = "stopping"
@@ -65,7 +76,7 @@ func (m *MockProvider) StopInstance(instanceID string) error {
 	return nil
 }
 
-func (m *MockProvider) TerminateInstance(instanceID string) error {
+func (m *MockProvider) TerminateInstance(ctx context.Context, instanceID string) error {
 	m.terminateCalls = append(m.terminateCalls, instanceID)
 	if status, exists := m.instances[instanceID]; exists {
 		status.State = "terminating"
@@ -73,12 +84,12 @@ func (m *MockProvider) TerminateInstance(instanceID string) error {
 	return nil
 }
 
-func (m *MockProvider) ListInstances() ([]*models.Instance, error) {
+func (m *MockProvider) ListInstances(ctx context.Context) ([]*models.Instance, error) {
 	// Not used in scheduler tests
 	return []*models.Instance{}, nil
 }
 
-func (m *MockProvider) ValidateCredentials() error {
+func (m *MockProvider) ValidateCredentials(ctx context.Context) error {
 	return nil
 }
 
@@ -95,7 +106,7 @@ func (m *MockProvider) SetInstanceStatus(instanceID, state string) {
 func TestSchedulerExpiredInstance(t *testing.T) {
 	// Create mock provider and storage
 	provider := NewMockProvider()
-	storage := storage.NewFileStorage(t.TempDir() + "/test.json")
+	storage := storage.NewMemoryStorage()
 
 	// Create an expired instance
 	expiredInstance := &models.Instance{
@@ -134,7 +145,7 @@ func TestSchedulerExpiredInstance(t *testing.T) {
 func TestSchedulerStoppedInstanceWithExtendedTTL(t *testing.T) {
 	// Create mock provider and storage
 	provider := NewMockProvider()
-	storage := storage.NewFileStorage(t.TempDir() + "/test.json")
+	storage := storage.NewMemoryStorage()
 
 	// Create a stopped instance with extended TTL
 	stoppedInstance := &models.Instance{
@@ -170,10 +181,118 @@ func TestSchedulerStoppedInstanceWithExtendedTTL(t *testing.T) {
 	}
 }
 
+func TestSchedulerRecordsHistory(t *testing.T) {
+	provider := NewMockProvider()
+	store := storage.NewMemoryStorage()
+	if err := store.SaveInstance(&models.Instance{
+		ID:        "i-history",
+		State:     "running",
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.SetInstanceStatus("i-history", "running")
+
+	sched := scheduler.NewScheduler(provider, store)
+	sched.RunOnce()
+
+	// Extend the TTL so the next pass restarts the instance
+	instance, err := store.GetInstance("i-history")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	instance.ExpiresAt = time.Now().Add(time.Hour)
+	if err := store.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.SetInstanceStatus("i-history", "stopped")
+	sched.RunOnce()
+
+	instance, err = store.GetInstance("i-history")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	var events []string
+	for _, event := range instance.History {
+		if event.Actor != models.ActorScheduler {
+			t.Errorf("Expected the scheduler to be the actor, got %+v", event)
+		}
+		events = append(events, event.Event)
+	}
+	if len(events) != 2 || events[0] != models.EventStopped || events[1] != models.EventRestarted {
+		t.Errorf("Expected the instance to be stopped then restarted, got %v", events)
+	}
+}
+
+func TestSchedulerSpotInterruption(t *testing.T) {
+	provider := NewMockProvider()
+	store := storage.NewMemoryStorage()
+
+	instance := &models.Instance{
+		ID:            "i-spot",
+		State:         "running",
+		LaunchTime:    time.Now().Add(-30 * time.Minute),
+		Duration:      2 * time.Hour,
+		ExpiresAt:     time.Now().Add(90 * time.Minute),
+		RestartPolicy: models.RestartPolicyAlways,
+		SpotRequestID: "sir-123",
+	}
+	if err := store.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.instances["i-spot"] = &models.InstanceStatus{ID: "i-spot", State: "stopped", Interrupted: true}
+
+	sched := scheduler.NewScheduler(provider, store)
+	sched.SetLogLevel(logrus.DebugLevel)
+	sched.RunOnce()
+
+	if len(provider.startCalls) != 0 {
+		t.Errorf("Expected the interrupted spot instance not to be restarted, got %v", provider.startCalls)
+	}
+	stored, err := store.GetInstance("i-spot")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if stored.State != "stopped" || stored.StopReason != models.StopReasonSpotInterruption {
+		t.Errorf("Expected a stopped instance marked as interrupted, got %s (%q)", stored.State, stored.StopReason)
+	}
+
+	// Once EC2 restarts it the interruption no longer applies
+	provider.instances["i-spot"] = &models.InstanceStatus{ID: "i-spot", State: "running"}
+	sched.RunOnce()
+
+	stored, _ = store.GetInstance("i-spot")
+	if stored.StopReason != "" {
+		t.Errorf("Expected the stop reason to be cleared once running, got %q", stored.StopReason)
+	}
+}
+
+func TestSchedulerRecordsVolumeIDs(t *testing.T) {
+	provider := NewMockProvider()
+	store := storage.NewMemoryStorage()
+
+	instance := &models.Instance{ID: "i-volumes", State: "pending", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.instances["i-volumes"] = &models.InstanceStatus{ID: "i-volumes", State: "running", VolumeIDs: []string{"vol-1", "vol-2"}}
+
+	sched := scheduler.NewScheduler(provider, store)
+	sched.RunOnce()
+
+	stored, err := store.GetInstance("i-volumes")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if len(stored.VolumeIDs) != 2 || stored.VolumeIDs[0] != "vol-1" || stored.VolumeIDs[1] != "vol-2" {
+		t.Errorf("Expected the volume IDs to be recorded, got %v", stored.VolumeIDs)
+	}
+}
+
 func TestSchedulerStateSync(t *testing.T) {
 	// Create mock provider and storage
 	provider := NewMockProvider()
-	storage := storage.NewFileStorage(t.TempDir() + "/test.json")
+	storage := storage.NewMemoryStorage()
 
 	// Create an instance
 	instance := &models.Instance{
@@ -214,10 +333,13 @@ func TestSchedulerStateSync(t *testing.T) {
 func TestSchedulerReloadInterval(t *testing.T) {
 	// Create mock provider and storage
 	provider := NewMockProvider()
-	storage := storage.NewFileStorage(t.TempDir() + "/test.json")
+	storage := storage.NewMemoryStorage()
 
 	// Create scheduler with very short intervals for testing
-	sched := scheduler.NewScheduler(provider, storage)
+	sched := scheduler.NewScheduler(provider, storage,
+		scheduler.WithInterval(10*time.Millisecond),
+		scheduler.WithReloadInterval(10*time.Millisecond),
+	)
 	sched.SetLogLevel(logrus.DebugLevel)
 
 	// Start scheduler
@@ -233,3 +355,1856 @@ func TestSchedulerReloadInterval(t *testing.T) {
 
 	// Test passes if no errors occur during the brief run
 }
+
+func TestSchedulerWithInterval(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	instance := &models.Instance{
+		ID:         "i-expired123",
+		State:      "running",
+		LaunchTime: time.Now().Add(-2 * time.Hour),
+		Duration:   1 * time.Hour,
+		ExpiresAt:  time.Now().Add(-1 * time.Hour),
+	}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.SetInstanceStatus("i-expired123", "running")
+
+	// The default 30s interval would never tick within the deadline
+	sched := scheduler.NewScheduler(provider, storage, scheduler.WithInterval(5*time.Millisecond))
+	sched.SetLogOutput(io.Discard)
+	sched.Start()
+	defer sched.Stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		stored, err := storage.GetInstance("i-expired123")
+		if err != nil {
+			t.Fatalf("Failed to get instance: %v", err)
+		}
+		if stored.State != "running" {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("Expected the expired instance to be stopped on the configured interval")
+}
+
+func TestSchedulerTimeSourceExpiry(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	// Not expired according to the local clock
+	instance := &models.Instance{
+		ID:         "i-skewed123",
+		State:      "running",
+		LaunchTime: time.Now(),
+		Duration:   1 * time.Hour,
+		ExpiresAt:  time.Now().Add(1 * time.Hour),
+	}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.SetInstanceStatus("i-skewed123", "running")
+
+	var logs bytes.Buffer
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(&logs)
+
+	// The reference clock is two hours ahead of the local host
+	sched.SetTimeSource(scheduler.TimeSourceFunc(func() (time.Time, error) {
+		return time.Now().Add(2 * time.Hour), nil
+	}), time.Minute)
+
+	sched.RunOnce()
+
+	if len(provider.stopCalls) != 1 || provider.stopCalls[0] != "i-skewed123" {
+		t.Errorf("Expected stop call for i-skewed123 based on reference time, got %v", provider.stopCalls)
+	}
+	if !strings.Contains(logs.String(), "clock skew") {
+		t.Errorf("Expected clock skew warning in logs, got: %s", logs.String())
+	}
+}
+
+func TestSchedulerTimeSourceWithinSkew(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	instance := &models.Instance{
+		ID:        "i-inskew123",
+		State:     "running",
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.SetInstanceStatus("i-inskew123", "running")
+
+	var logs bytes.Buffer
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(&logs)
+	sched.SetTimeSource(scheduler.TimeSourceFunc(func() (time.Time, error) {
+		return time.Now().Add(5 * time.Second), nil
+	}), time.Minute)
+
+	sched.RunOnce()
+
+	if len(provider.stopCalls) != 0 {
+		t.Errorf("Expected no stop calls, got %v", provider.stopCalls)
+	}
+	if strings.Contains(logs.String(), "clock skew") {
+		t.Errorf("Expected no clock skew warning, got: %s", logs.String())
+	}
+}
+
+func TestSchedulerRecordsReadyAt(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	instance := &models.Instance{
+		ID:         "i-ready123",
+		State:      "pending",
+		LaunchTime: time.Now().Add(-2 * time.Minute),
+		ExpiresAt:  time.Now().Add(1 * time.Hour),
+	}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	// Not ready yet: pending without a public IP
+	provider.SetInstanceStatus("i-ready123", "pending")
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogLevel(logrus.DebugLevel)
+	sched.RunOnce()
+
+	stored, err := storage.GetInstance("i-ready123")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if !stored.ReadyAt.IsZero() {
+		t.Fatalf("Expected ReadyAt to be unset before instance is ready, got %s", stored.ReadyAt)
+	}
+
+	// First running-with-IP observation sets ReadyAt
+	provider.SetInstanceStatus("i-ready123", "running")
+	provider.instances["i-ready123"].PublicIP = "1.2.3.4"
+	sched.RunOnce()
+
+	stored, err = storage.GetInstance("i-ready123")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if stored.ReadyAt.IsZero() {
+		t.Fatal("Expected ReadyAt to be set once instance is running with an IP")
+	}
+	firstReadyAt := stored.ReadyAt
+
+	// Subsequent observations don't overwrite it
+	time.Sleep(10 * time.Millisecond)
+	sched.RunOnce()
+
+	stored, err = storage.GetInstance("i-ready123")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if !stored.ReadyAt.Equal(firstReadyAt) {
+		t.Errorf("Expected ReadyAt to remain %s, got %s", firstReadyAt, stored.ReadyAt)
+	}
+}
+
+func TestSchedulerDailyBudget(t *testing.T) {
+	// Daily costs: m5.large $2.304, c5.large $2.04, t3.large $1.9968 (total $6.3408)
+	seed := []struct {
+		id           string
+		instanceType string
+		expiresIn    time.Duration
+	}{
+		{"i-m5", "m5.large", 1 * time.Hour},
+		{"i-t3", "t3.large", 3 * time.Hour},
+		{"i-c5", "c5.large", 2 * time.Hour},
+	}
+
+	tests := []struct {
+		name        string
+		budget      float64
+		wantStopped []string
+	}{
+		{name: "within budget", budget: 10, wantStopped: nil},
+		{name: "stop soonest expiring", budget: 4.5, wantStopped: []string{"i-m5"}},
+		{name: "stop until under budget", budget: 2.5, wantStopped: []string{"i-m5", "i-c5"}},
+		{name: "stop everything", budget: 1, wantStopped: []string{"i-m5", "i-c5", "i-t3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewMockProvider()
+			storage := storage.NewMemoryStorage()
+
+			for _, s := range seed {
+				instance := &models.Instance{
+					ID:           s.id,
+					InstanceType: s.instanceType,
+					State:        "running",
+					LaunchTime:   time.Now().Add(-time.Hour),
+					ExpiresAt:    time.Now().Add(s.expiresIn),
+				}
+				if err := storage.SaveInstance(instance); err != nil {
+					t.Fatalf("Failed to save instance: %v", err)
+				}
+				provider.SetInstanceStatus(s.id, "running")
+			}
+
+			sched := scheduler.NewScheduler(provider, storage)
+			sched.SetLogOutput(&bytes.Buffer{})
+			sched.SetDailyBudget(tt.budget)
+			sched.RunOnce()
+
+			if strings.Join(provider.stopCalls, ",") != strings.Join(tt.wantStopped, ",") {
+				t.Errorf("Expected stop calls %v, got %v", tt.wantStopped, provider.stopCalls)
+			}
+
+			for _, id := range tt.wantStopped {
+				instance, err := storage.GetInstance(id)
+				if err != nil {
+					t.Fatalf("Failed to get instance: %v", err)
+				}
+				if instance.StopReason != models.StopReasonBudget {
+					t.Errorf("Expected %s to be marked as budget-stopped, got %q", id, instance.StopReason)
+				}
+			}
+
+			// Budget-stopped instances must not be restarted while their TTL is unchanged
+			sched.RunOnce()
+			if len(provider.startCalls) != 0 {
+				t.Errorf("Expected no start calls, got %v", provider.startCalls)
+			}
+		})
+	}
+}
+
+func TestSchedulerRestartPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		stopReason  string
+		wantRestart bool
+	}{
+		{name: "default", policy: "", wantRestart: true},
+		{name: "on-extend", policy: models.RestartPolicyOnExtend, wantRestart: true},
+		{name: "on-extend after budget stop", policy: models.RestartPolicyOnExtend, stopReason: models.StopReasonBudget, wantRestart: false},
+		{name: "always", policy: models.RestartPolicyAlways, wantRestart: true},
+		{name: "always after budget stop", policy: models.RestartPolicyAlways, stopReason: models.StopReasonBudget, wantRestart: true},
+		{name: "never", policy: models.RestartPolicyNever, wantRestart: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewMockProvider()
+			storage := storage.NewMemoryStorage()
+
+			instance := &models.Instance{
+				ID:            "i-stopped123",
+				State:         "stopped",
+				LaunchTime:    time.Now().Add(-30 * time.Minute),
+				ExpiresAt:     time.Now().Add(1 * time.Hour),
+				RestartPolicy: tt.policy,
+				StopReason:    tt.stopReason,
+			}
+			if err := storage.SaveInstance(instance); err != nil {
+				t.Fatalf("Failed to save instance: %v", err)
+			}
+			provider.SetInstanceStatus("i-stopped123", "stopped")
+
+			sched := scheduler.NewScheduler(provider, storage)
+			sched.SetLogOutput(&bytes.Buffer{})
+			sched.RunOnce()
+
+			restarted := len(provider.startCalls) == 1
+			if restarted != tt.wantRestart {
+				t.Errorf("Expected restart %v, got start calls %v", tt.wantRestart, provider.startCalls)
+			}
+		})
+	}
+}
+
+func TestSchedulerRestartsOnExtensionAtOnce(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	instance := &models.Instance{
+		ID:         "i-budget",
+		State:      "stopped",
+		LaunchTime: time.Now().Add(-30 * time.Minute),
+		ExpiresAt:  time.Now().Add(time.Hour),
+		StopReason: models.StopReasonBudget,
+	}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.SetInstanceStatus("i-budget", "stopped")
+
+	// The pass would not run again within the deadline
+	sched := scheduler.NewScheduler(provider, storage, scheduler.WithInterval(time.Hour))
+	sched.SetLogOutput(io.Discard)
+	sched.Start()
+	defer sched.Stop()
+
+	extended, err := storage.GetInstance("i-budget")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	extended.ExpiresAt = extended.ExpiresAt.Add(time.Hour)
+	extended.StopReason = ""
+	extended.RecordEvent(time.Now(), models.EventExtended, "alice", "")
+	if err := storage.UpdateInstance(extended); err != nil {
+		t.Fatalf("Failed to extend instance: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		stored, err := storage.GetInstance("i-budget")
+		if err != nil {
+			t.Fatalf("Failed to get instance: %v", err)
+		}
+		if stored.State != "stopped" {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("Expected the extended instance to be restarted without waiting for the next pass")
+}
+
+func TestSchedulerDailyBudgetSkipsAlwaysRestart(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	for _, instance := range []*models.Instance{
+		{ID: "i-pinned", InstanceType: "m5.large", RestartPolicy: models.RestartPolicyAlways, ExpiresAt: time.Now().Add(time.Hour)},
+		{ID: "i-other", InstanceType: "c5.large", ExpiresAt: time.Now().Add(2 * time.Hour)},
+	} {
+		instance.State = "running"
+		if err := storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+		provider.SetInstanceStatus(instance.ID, "running")
+	}
+
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(&bytes.Buffer{})
+	sched.SetDailyBudget(3)
+	sched.RunOnce()
+
+	if len(provider.stopCalls) != 1 || provider.stopCalls[0] != "i-other" {
+		t.Errorf("Expected only i-other to be stopped, got %v", provider.stopCalls)
+	}
+}
+
+func TestSchedulerMaxRestarts(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	instance := &models.Instance{
+		ID:         "i-crashloop",
+		State:      "stopped",
+		LaunchTime: time.Now().Add(-30 * time.Minute),
+		ExpiresAt:  time.Now().Add(1 * time.Hour),
+	}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	var logs bytes.Buffer
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(&logs)
+	sched.SetMaxRestarts(2)
+
+	// The instance crashes straight after every restart
+	for i := 0; i < 4; i++ {
+		provider.SetInstanceStatus("i-crashloop", "stopped")
+		sched.RunOnce()
+	}
+
+	if len(provider.startCalls) != 2 {
+		t.Errorf("Expected 2 start calls, got %d", len(provider.startCalls))
+	}
+
+	stored, err := storage.GetInstance("i-crashloop")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if stored.RestartCount != 2 {
+		t.Errorf("Expected restart count 2, got %d", stored.RestartCount)
+	}
+	if !stored.Unhealthy {
+		t.Error("Expected instance to be marked unhealthy")
+	}
+	if !strings.Contains(logs.String(), "maximum number of restarts") {
+		t.Error("Expected the max restarts to be logged")
+	}
+}
+
+func TestSchedulerAutoRenew(t *testing.T) {
+	day := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		now         time.Time
+		launchedAgo time.Duration
+		wantRenewed bool
+	}{
+		{name: "before cutoff", now: day.Add(10 * time.Hour), launchedAgo: 2 * time.Hour, wantRenewed: true},
+		{name: "after cutoff", now: day.Add(19 * time.Hour), launchedAgo: 2 * time.Hour, wantRenewed: false},
+		{name: "at cutoff", now: day.Add(18 * time.Hour), launchedAgo: 2 * time.Hour, wantRenewed: false},
+		{name: "max age exceeded", now: day.Add(10 * time.Hour), launchedAgo: 13 * time.Hour, wantRenewed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewMockProvider()
+			storage := storage.NewMemoryStorage()
+
+			expiresAt := tt.now.Add(-5 * time.Minute)
+			instance := &models.Instance{
+				ID:         "i-devbox",
+				State:      "running",
+				LaunchTime: tt.now.Add(-tt.launchedAgo),
+				Duration:   time.Hour,
+				ExpiresAt:  expiresAt,
+			}
+			if err := storage.SaveInstance(instance); err != nil {
+				t.Fatalf("Failed to save instance: %v", err)
+			}
+			provider.SetInstanceStatus("i-devbox", "running")
+
+			sched := scheduler.NewScheduler(provider, storage)
+			sched.SetLogOutput(&bytes.Buffer{})
+			sched.SetTimeSource(scheduler.TimeSourceFunc(func() (time.Time, error) {
+				return tt.now, nil
+			}), 100*365*24*time.Hour)
+			sched.SetAutoRenew(scheduler.AutoRenewOptions{
+				Until:     18 * time.Hour,
+				Increment: 30 * time.Minute,
+				MaxAge:    12 * time.Hour,
+				Location:  time.UTC,
+			})
+			sched.RunOnce()
+
+			stored, err := storage.GetInstance("i-devbox")
+			if err != nil {
+				t.Fatalf("Failed to get instance: %v", err)
+			}
+
+			if tt.wantRenewed {
+				if len(provider.stopCalls) != 0 {
+					t.Errorf("Expected no stop calls, got %v", provider.stopCalls)
+				}
+				if want := expiresAt.Add(30 * time.Minute); !stored.ExpiresAt.Equal(want) {
+					t.Errorf("Expected expiry extended to %v, got %v", want, stored.ExpiresAt)
+				}
+			} else {
+				if len(provider.stopCalls) != 1 {
+					t.Errorf("Expected 1 stop call, got %v", provider.stopCalls)
+				}
+				if !stored.ExpiresAt.Equal(expiresAt) {
+					t.Errorf("Expected expiry unchanged at %v, got %v", expiresAt, stored.ExpiresAt)
+				}
+			}
+		})
+	}
+}
+
+func TestSchedulerRegistryMixedProviders(t *testing.T) {
+	awsProvider := NewMockProvider()
+	dockerProvider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	registry := cloud.NewRegistry("aws")
+	registry.Register("aws", func() (cloud.CloudProvider, error) { return awsProvider, nil })
+	registry.Register("docker", func() (cloud.CloudProvider, error) { return dockerProvider, nil })
+
+	expiredAt := time.Now().Add(-time.Hour)
+	instances := []*models.Instance{
+		{ID: "i-legacy", State: "running", ExpiresAt: expiredAt},
+		{ID: "i-aws", Provider: "aws", State: "running", ExpiresAt: expiredAt},
+		{ID: "c-docker", Provider: "docker", State: "running", ExpiresAt: expiredAt},
+		{ID: "i-unknown", Provider: "vultr", State: "running", ExpiresAt: expiredAt},
+	}
+	for _, instance := range instances {
+		if err := storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+		awsProvider.SetInstanceStatus(instance.ID, "running")
+		dockerProvider.SetInstanceStatus(instance.ID, "running")
+	}
+
+	sched := scheduler.NewScheduler(awsProvider, storage)
+	sched.SetRegistry(registry)
+	sched.SetLogOutput(&bytes.Buffer{})
+	sched.RunOnce()
+
+	sort.Strings(awsProvider.stopCalls)
+	if strings.Join(awsProvider.stopCalls, ",") != "i-aws,i-legacy" {
+		t.Errorf("Expected AWS to stop i-legacy and i-aws, got %v", awsProvider.stopCalls)
+	}
+	if len(dockerProvider.stopCalls) != 1 || dockerProvider.stopCalls[0] != "c-docker" {
+		t.Errorf("Expected Docker to stop c-docker, got %v", dockerProvider.stopCalls)
+	}
+
+	unknown, err := storage.GetInstance("i-unknown")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if unknown.State != "running" {
+		t.Errorf("Expected an instance of an unregistered provider to be left alone, got state %s", unknown.State)
+	}
+}
+
+// slowProvider blocks status calls until their context is done
+type slowProvider struct {
+	*MockProvider
+}
+
+func (p *slowProvider) GetInstanceStatus(ctx context.Context, instanceID string) (*models.InstanceStatus, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestSchedulerCallTimeout(t *testing.T) {
+	provider := &slowProvider{MockProvider: NewMockProvider()}
+	storage := storage.NewMemoryStorage()
+
+	instance := &models.Instance{
+		ID:        "i-slow",
+		State:     "running",
+		ExpiresAt: time.Now().Add(-time.Hour),
+	}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetCallTimeout(20 * time.Millisecond)
+	var logs bytes.Buffer
+	sched.SetLogOutput(&logs)
+
+	done := make(chan struct{})
+	go func() {
+		sched.RunOnce()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the status call to be cancelled by the call timeout")
+	}
+
+	if len(provider.stopCalls) != 0 {
+		t.Errorf("Expected no stop calls when the status is unknown, got %v", provider.stopCalls)
+	}
+	if !strings.Contains(logs.String(), "deadline exceeded") {
+		t.Errorf("Expected the timeout to be logged, got %q", logs.String())
+	}
+}
+
+// snapshotProvider records the instances whose volumes were snapshotted or
+// imaged
+type snapshotProvider struct {
+	*MockProvider
+	snapshotCalls []string
+	imageCalls    []string
+	stopErr       error
+}
+
+func (p *snapshotProvider) StopInstance(ctx context.Context, instanceID string) error {
+	if p.stopErr != nil {
+		return p.stopErr
+	}
+	return p.MockProvider.StopInstance(ctx, instanceID)
+}
+
+func (p *snapshotProvider) SnapshotVolumes(ctx context.Context, instanceID string) ([]*models.SnapshotRecord, error) {
+	p.snapshotCalls = append(p.snapshotCalls, instanceID)
+	return []*models.SnapshotRecord{
+		{SnapshotID: "snap-" + instanceID, InstanceID: instanceID, VolumeID: "vol-root", CreatedAt: time.Now()},
+	}, nil
+}
+
+func (p *snapshotProvider) CreateImage(ctx context.Context, instanceID, name string) (*models.ImageRecord, error) {
+	p.imageCalls = append(p.imageCalls, instanceID)
+	return &models.ImageRecord{ImageID: "ami-" + instanceID, InstanceID: instanceID, CreatedAt: time.Now()}, nil
+}
+
+func TestSchedulerSnapshotOnExpiry(t *testing.T) {
+	provider := &snapshotProvider{MockProvider: NewMockProvider()}
+	storage := storage.NewMemoryStorage()
+
+	for _, instance := range []*models.Instance{
+		{ID: "i-snapshot", State: "running", ExpiresAt: time.Now().Add(-time.Hour), SnapshotOnExpiry: true},
+		{ID: "i-plain", State: "running", ExpiresAt: time.Now().Add(-time.Hour)},
+	} {
+		if err := storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.RunOnce()
+
+	if len(provider.snapshotCalls) != 1 || provider.snapshotCalls[0] != "i-snapshot" {
+		t.Errorf("Expected only i-snapshot to be snapshotted, got %v", provider.snapshotCalls)
+	}
+	if len(provider.stopCalls) != 2 {
+		t.Errorf("Expected both expired instances to be stopped, got %v", provider.stopCalls)
+	}
+
+	snapshots, err := storage.ListSnapshots()
+	if err != nil {
+		t.Fatalf("ListSnapshots failed: %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].SnapshotID != "snap-i-snapshot" || snapshots[0].VolumeID != "vol-root" {
+		t.Errorf("Expected the snapshot to be recorded, got %+v", snapshots)
+	}
+}
+
+func TestSchedulerSnapshotOnExpiryOncePerExpiry(t *testing.T) {
+	provider := &snapshotProvider{MockProvider: NewMockProvider(), stopErr: errors.New("stop failed")}
+	storage := storage.NewMemoryStorage()
+	instance := &models.Instance{ID: "i-snapshot", State: "running", ExpiresAt: time.Now().Add(-time.Hour), SnapshotOnExpiry: true}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.RunOnce()
+	sched.RunOnce()
+	if len(provider.snapshotCalls) != 1 {
+		t.Errorf("Expected a failed stop not to snapshot the volumes again, got %v", provider.snapshotCalls)
+	}
+
+	stored, err := storage.GetInstance("i-snapshot")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	stored.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := storage.UpdateInstance(stored); err != nil {
+		t.Fatalf("Failed to update instance: %v", err)
+	}
+	provider.stopErr = nil
+	sched.RunOnce()
+	if len(provider.snapshotCalls) != 2 {
+		t.Errorf("Expected a new expiry to snapshot the volumes again, got %v", provider.snapshotCalls)
+	}
+}
+
+func TestSchedulerImageOnExpiry(t *testing.T) {
+	provider := &snapshotProvider{MockProvider: NewMockProvider()}
+	storage := storage.NewMemoryStorage()
+
+	instance := &models.Instance{ID: "i-image", State: "running", ExpiresAt: time.Now().Add(-time.Hour), ImageOnExpiry: true}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.RunOnce()
+
+	if len(provider.imageCalls) != 1 || len(provider.snapshotCalls) != 0 {
+		t.Errorf("Expected only an image of i-image, got images %v and snapshots %v", provider.imageCalls, provider.snapshotCalls)
+	}
+	if len(provider.stopCalls) != 1 {
+		t.Errorf("Expected the instance to be stopped, got %v", provider.stopCalls)
+	}
+
+	images, err := storage.ListImages()
+	if err != nil {
+		t.Fatalf("ListImages failed: %v", err)
+	}
+	if len(images) != 1 || images[0].ImageID != "ami-i-image" {
+		t.Errorf("Expected the image to be recorded, got %+v", images)
+	}
+}
+
+func TestSchedulerImageOnExpiryOncePerExpiry(t *testing.T) {
+	provider := &snapshotProvider{MockProvider: NewMockProvider(), stopErr: errors.New("stop failed")}
+	storage := storage.NewMemoryStorage()
+	instance := &models.Instance{ID: "i-image", State: "running", ExpiresAt: time.Now().Add(-time.Hour), ImageOnExpiry: true}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.RunOnce()
+	sched.RunOnce()
+	if len(provider.imageCalls) != 1 {
+		t.Errorf("Expected a failed stop not to create another image, got %v", provider.imageCalls)
+	}
+}
+
+// hibernateProvider records hibernations, failing them when err is set
+type hibernateProvider struct {
+	*MockProvider
+	hibernateCalls []string
+	err            error
+}
+
+func (p *hibernateProvider) HibernateInstance(ctx context.Context, instanceID string) error {
+	p.hibernateCalls = append(p.hibernateCalls, instanceID)
+	return p.err
+}
+
+func TestSchedulerHibernateOnExpiry(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantStopCalls int
+	}{
+		{"hibernates", nil, 0},
+		{"falls back to stop", errors.New("instance is not ready to hibernate"), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &hibernateProvider{MockProvider: NewMockProvider(), err: tt.err}
+			storage := storage.NewMemoryStorage()
+
+			instance := &models.Instance{
+				ID:           "i-hibernate",
+				State:        "running",
+				ExpiresAt:    time.Now().Add(-time.Hour),
+				ExpiryAction: models.ExpiryActionHibernate,
+			}
+			if err := storage.SaveInstance(instance); err != nil {
+				t.Fatalf("Failed to save instance: %v", err)
+			}
+
+			sched := scheduler.NewScheduler(provider, storage)
+			sched.RunOnce()
+
+			if len(provider.hibernateCalls) != 1 {
+				t.Errorf("Expected 1 hibernate call, got %v", provider.hibernateCalls)
+			}
+			if len(provider.stopCalls) != tt.wantStopCalls {
+				t.Errorf("Expected %d stop calls, got %v", tt.wantStopCalls, provider.stopCalls)
+			}
+			stored, err := storage.GetInstance("i-hibernate")
+			if err != nil {
+				t.Fatalf("Failed to get instance: %v", err)
+			}
+			if stored.State != "stopping" {
+				t.Errorf("Expected state stopping, got %s", stored.State)
+			}
+		})
+	}
+}
+
+func TestSchedulerTerminateOnExpiry(t *testing.T) {
+	provider := &snapshotProvider{MockProvider: NewMockProvider()}
+	storage := storage.NewMemoryStorage()
+
+	now := time.Now()
+	instances := []*models.Instance{
+		{ID: "i-terminate", State: "running", ExpiresAt: now.Add(-time.Hour), ExpiryAction: models.ExpiryActionTerminate, SnapshotOnExpiry: true},
+		{ID: "i-default", State: "stopped", ExpiresAt: now.Add(-time.Hour)},
+		{ID: "i-stop", State: "running", ExpiresAt: now.Add(-time.Hour), ExpiryAction: models.ExpiryActionStop},
+	}
+	for _, instance := range instances {
+		if err := storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+	provider.SetInstanceStatus("i-terminate", "running")
+	provider.SetInstanceStatus("i-default", "stopped")
+	provider.SetInstanceStatus("i-stop", "running")
+
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetDefaultExpiryAction(models.ExpiryActionTerminate)
+	sched.RunOnce()
+
+	sort.Strings(provider.terminateCalls)
+	if len(provider.terminateCalls) != 2 || provider.terminateCalls[0] != "i-default" || provider.terminateCalls[1] != "i-terminate" {
+		t.Errorf("Expected i-default and i-terminate to be terminated, got %v", provider.terminateCalls)
+	}
+	if len(provider.stopCalls) != 1 || provider.stopCalls[0] != "i-stop" {
+		t.Errorf("Expected only i-stop to be stopped, got %v", provider.stopCalls)
+	}
+	if len(provider.snapshotCalls) != 1 || provider.snapshotCalls[0] != "i-terminate" {
+		t.Errorf("Expected i-terminate to be snapshotted before terminating, got %v", provider.snapshotCalls)
+	}
+
+	archived, err := storage.ListArchived()
+	if err != nil {
+		t.Fatalf("Failed to list archived instances: %v", err)
+	}
+	if len(archived) != 2 {
+		t.Fatalf("Expected 2 archived instances, got %+v", archived)
+	}
+	for _, record := range archived {
+		if record.Reason != models.ArchiveReasonExpired || record.Instance.TerminatedAt.IsZero() {
+			t.Errorf("Unexpected archived record: %+v", record)
+		}
+	}
+}
+
+func TestSchedulerExpiryWarnings(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	expiresAt := time.Now().Add(time.Hour)
+	instance := &models.Instance{
+		ID:        "i-warn123",
+		State:     "running",
+		ExpiresAt: expiresAt,
+	}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	var sent []notify.Notification
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetExpiryWarnings(scheduler.ExpiryWarningOptions{
+		LeadTimes: []time.Duration{time.Minute, 30 * time.Minute, 10 * time.Minute},
+		WebURL:    "http://localhost:8080",
+	})
+	sched.SetNotifier(notify.NotifierFunc(func(ctx context.Context, n notify.Notification) error {
+		sent = append(sent, n)
+		return nil
+	}))
+
+	now := expiresAt.Add(-45 * time.Minute)
+	sched.SetTimeSource(scheduler.TimeSourceFunc(func() (time.Time, error) {
+		return now, nil
+	}), 24*time.Hour)
+
+	// Outside every lead time, then within 10m (skipping the 30m warning),
+	// again within 10m, and finally within 1m
+	steps := []struct {
+		before    time.Duration
+		wantTotal int
+	}{
+		{45 * time.Minute, 0},
+		{5 * time.Minute, 1},
+		{4 * time.Minute, 1},
+		{30 * time.Second, 2},
+	}
+	for _, step := range steps {
+		now = expiresAt.Add(-step.before)
+		sched.RunOnce()
+		if len(sent) != step.wantTotal {
+			t.Fatalf("%s before expiry: expected %d warnings, got %d", step.before, step.wantTotal, len(sent))
+		}
+	}
+
+	warning := sent[0]
+	if warning.Event != notify.EventExpiryWarning || warning.InstanceID != "i-warn123" {
+		t.Errorf("Unexpected warning: %+v", warning)
+	}
+	if warning.ExtendCommand != "instance-manager extend --instance-id i-warn123 --duration 1h" {
+		t.Errorf("Unexpected extend command: %q", warning.ExtendCommand)
+	}
+	if warning.ExtendURL != "http://localhost:8080/?extend=i-warn123" {
+		t.Errorf("Unexpected extend URL: %q", warning.ExtendURL)
+	}
+	if len(provider.stopCalls) != 0 {
+		t.Errorf("Expected no stop calls before expiry, got %v", provider.stopCalls)
+	}
+
+	// Extending the instance re-arms the warnings for the new expiry time
+	stored, err := storage.GetInstance("i-warn123")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	expiresAt = expiresAt.Add(time.Hour)
+	stored.ExpiresAt = expiresAt
+	if err := storage.UpdateInstance(stored); err != nil {
+		t.Fatalf("Failed to update instance: %v", err)
+	}
+	now = expiresAt.Add(-20 * time.Minute)
+	sched.RunOnce()
+	if len(sent) != 3 {
+		t.Errorf("Expected a new warning after the extension, got %d warnings", len(sent))
+	}
+}
+
+func TestSchedulerGracePeriod(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	expiresAt := time.Now()
+	instance := &models.Instance{
+		ID:        "i-grace123",
+		State:     "running",
+		ExpiresAt: expiresAt,
+	}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.SetInstanceStatus("i-grace123", "running")
+
+	var sent []notify.Notification
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetGracePeriod(5 * time.Minute)
+	sched.SetNotifier(notify.NotifierFunc(func(ctx context.Context, n notify.Notification) error {
+		sent = append(sent, n)
+		return nil
+	}))
+
+	now := expiresAt.Add(2 * time.Minute)
+	sched.SetTimeSource(scheduler.TimeSourceFunc(func() (time.Time, error) {
+		return now, nil
+	}), 24*time.Hour)
+
+	// Within the grace period the instance keeps running and is warned once
+	sched.RunOnce()
+	now = expiresAt.Add(4 * time.Minute)
+	sched.RunOnce()
+	if len(provider.stopCalls) != 0 {
+		t.Errorf("Expected no stop calls during the grace period, got %v", provider.stopCalls)
+	}
+	if len(sent) != 1 || sent[0].Event != notify.EventGracePeriod {
+		t.Fatalf("Expected one grace period notification, got %+v", sent)
+	}
+	if sent[0].TimeLeft != 3*time.Minute {
+		t.Errorf("Expected 3m left until the stop, got %s", sent[0].TimeLeft)
+	}
+
+	// Once the grace period ends the instance is stopped
+	now = expiresAt.Add(6 * time.Minute)
+	sched.RunOnce()
+	if len(provider.stopCalls) != 1 || provider.stopCalls[0] != "i-grace123" {
+		t.Errorf("Expected stop call for i-grace123 after the grace period, got %v", provider.stopCalls)
+	}
+}
+
+func TestSchedulerTerminateStoppedTooLong(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	now := time.Now()
+	instances := []*models.Instance{
+		{ID: "i-old123", State: "stopped", ExpiresAt: now.Add(-10 * 24 * time.Hour), StoppedAt: now.Add(-8 * 24 * time.Hour)},
+		{ID: "i-recent123", State: "stopped", ExpiresAt: now.Add(-2 * 24 * time.Hour), StoppedAt: now.Add(-1 * 24 * time.Hour)},
+		{ID: "i-new123", State: "stopped", ExpiresAt: now.Add(-10 * 24 * time.Hour)},
+	}
+	for _, instance := range instances {
+		if err := storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+		provider.SetInstanceStatus(instance.ID, "stopped")
+	}
+
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetTerminateStoppedAfter(7 * 24 * time.Hour)
+	sched.RunOnce()
+
+	if len(provider.terminateCalls) != 1 || provider.terminateCalls[0] != "i-old123" {
+		t.Fatalf("Expected only i-old123 to be terminated, got %v", provider.terminateCalls)
+	}
+	if _, err := storage.GetInstance("i-old123"); err == nil {
+		t.Error("Expected i-old123 to be removed from the active instances")
+	}
+	archived, err := storage.ListArchived()
+	if err != nil {
+		t.Fatalf("Failed to list archived instances: %v", err)
+	}
+	if len(archived) != 1 || archived[0].Instance.ID != "i-old123" || archived[0].Reason != models.ArchiveReasonStoppedTooLong {
+		t.Errorf("Unexpected archive: %+v", archived)
+	}
+
+	// An instance seen stopped for the first time starts its clock now
+	updated, err := storage.GetInstance("i-new123")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if updated.StoppedAt.IsZero() {
+		t.Error("Expected StoppedAt to be recorded for i-new123")
+	}
+}
+
+// utilizationProvider reports fixed utilization for each instance
+type utilizationProvider struct {
+	*MockProvider
+	usage  map[string]*models.Utilization
+	checks int
+}
+
+func (p *utilizationProvider) Utilization(ctx context.Context, instanceID string, window time.Duration) (*models.Utilization, error) {
+	p.checks++
+	return p.usage[instanceID], nil
+}
+
+func TestSchedulerIdlePolicy(t *testing.T) {
+	provider := &utilizationProvider{MockProvider: NewMockProvider(), usage: map[string]*models.Utilization{
+		"i-idle123":    {CPUPercent: 1, NetworkBytesPerSecond: 50, Covered: time.Hour},
+		"i-busy123":    {CPUPercent: 40, NetworkBytesPerSecond: 50, Covered: time.Hour},
+		"i-new123":     {CPUPercent: 1, Covered: 20 * time.Minute},
+		"i-optout123":  {CPUPercent: 1, Covered: time.Hour},
+		"i-traffic123": {CPUPercent: 1, NetworkBytesPerSecond: 5000, Covered: time.Hour},
+	}}
+	storage := storage.NewMemoryStorage()
+
+	expiresAt := time.Now().Add(4 * time.Hour)
+	for id := range provider.usage {
+		instance := &models.Instance{ID: id, State: "running", ExpiresAt: expiresAt, KeepWhenIdle: id == "i-optout123"}
+		if err := storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+		provider.SetInstanceStatus(id, "running")
+	}
+
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetIdlePolicy(scheduler.IdlePolicy{CPUPercent: 5, NetworkBytesPerSecond: 1024, Window: time.Hour})
+	sched.RunOnce()
+
+	if len(provider.stopCalls) != 1 || provider.stopCalls[0] != "i-idle123" {
+		t.Fatalf("Expected only i-idle123 to be stopped, got %v", provider.stopCalls)
+	}
+	stopped, err := storage.GetInstance("i-idle123")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if stopped.StopReason != models.StopReasonIdle {
+		t.Errorf("Expected stop reason %q, got %q", models.StopReasonIdle, stopped.StopReason)
+	}
+
+	// Idle-stopped instances are not restarted before their TTL is extended,
+	// and utilization is not checked again right away
+	provider.SetInstanceStatus("i-idle123", "stopped")
+	checks := provider.checks
+	sched.RunOnce()
+	if len(provider.startCalls) != 0 {
+		t.Errorf("Expected no restart of the idle-stopped instance, got %v", provider.startCalls)
+	}
+	if provider.checks != checks {
+		t.Errorf("Expected no utilization checks within the check interval, got %d more", provider.checks-checks)
+	}
+}
+
+func TestSchedulerOfficeHours(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	friday := time.Date(2024, 3, 8, 18, 0, 0, 0, time.UTC)
+	instance := &models.Instance{
+		ID:          "i-office123",
+		State:       "running",
+		ExpiresAt:   friday.Add(30 * 24 * time.Hour),
+		OfficeHours: "08:00-19:00 Mon-Fri",
+	}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.SetInstanceStatus("i-office123", "running")
+
+	now := friday
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetMaxRestarts(1)
+	sched.SetTimeSource(scheduler.TimeSourceFunc(func() (time.Time, error) {
+		return now, nil
+	}), 24*time.Hour)
+
+	// Within office hours the instance keeps running
+	sched.RunOnce()
+	if len(provider.stopCalls) != 0 {
+		t.Fatalf("Expected no stop calls within office hours, got %v", provider.stopCalls)
+	}
+
+	// After closing it is stopped and stays stopped over the weekend
+	now = friday.Add(2 * time.Hour)
+	sched.RunOnce()
+	if len(provider.stopCalls) != 1 {
+		t.Fatalf("Expected a stop call after closing, got %v", provider.stopCalls)
+	}
+	provider.SetInstanceStatus("i-office123", "stopped")
+	now = friday.Add(24 * time.Hour)
+	sched.RunOnce()
+	if len(provider.startCalls) != 0 {
+		t.Fatalf("Expected no start calls on the weekend, got %v", provider.startCalls)
+	}
+
+	// Monday morning it is started without counting as a restart
+	for _, opening := range []time.Time{friday.Add(62 * time.Hour), friday.Add(86 * time.Hour)} {
+		now = opening
+		sched.RunOnce()
+		provider.SetInstanceStatus("i-office123", "running")
+		now = opening.Add(12 * time.Hour)
+		sched.RunOnce()
+		provider.SetInstanceStatus("i-office123", "stopped")
+	}
+	if len(provider.startCalls) != 2 {
+		t.Errorf("Expected a start call each morning, got %v", provider.startCalls)
+	}
+	updated, err := storage.GetInstance("i-office123")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if updated.RestartCount != 0 || updated.Unhealthy {
+		t.Errorf("Expected office hours starts not to count as restarts, got %d restarts (unhealthy %v)", updated.RestartCount, updated.Unhealthy)
+	}
+}
+
+func TestSchedulerCronSchedules(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	evening := time.Date(2024, 3, 4, 19, 59, 50, 0, time.UTC) // Monday
+	instance := &models.Instance{
+		ID:        "i-cron123",
+		State:     "running",
+		ExpiresAt: evening.Add(30 * 24 * time.Hour),
+		StopCron:  "0 20 * * *",
+		StartCron: "0 8 * * 1-5",
+	}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.SetInstanceStatus("i-cron123", "running")
+
+	now := evening
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetTimeSource(scheduler.TimeSourceFunc(func() (time.Time, error) {
+		return now, nil
+	}), 24*time.Hour)
+
+	sched.RunOnce()
+	if len(provider.stopCalls) != 0 {
+		t.Fatalf("Expected no stop calls before the stop schedule, got %v", provider.stopCalls)
+	}
+
+	// The stop schedule fires at 20:00
+	now = evening.Add(30 * time.Second)
+	sched.RunOnce()
+	if len(provider.stopCalls) != 1 {
+		t.Fatalf("Expected a stop call at 20:00, got %v", provider.stopCalls)
+	}
+
+	// Stopped by its schedule, the instance is not restarted overnight
+	provider.SetInstanceStatus("i-cron123", "stopped")
+	now = evening.Add(4 * time.Hour)
+	sched.RunOnce()
+	if len(provider.startCalls) != 0 {
+		t.Fatalf("Expected no start calls overnight, got %v", provider.startCalls)
+	}
+
+	// The start schedule fires at 08:00 on Tuesday
+	now = time.Date(2024, 3, 5, 8, 0, 20, 0, time.UTC)
+	sched.RunOnce()
+	if len(provider.startCalls) != 1 {
+		t.Fatalf("Expected a start call at 08:00, got %v", provider.startCalls)
+	}
+	updated, err := storage.GetInstance("i-cron123")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if updated.StopReason != "" || updated.RestartCount != 0 {
+		t.Errorf("Expected a scheduled start to clear the stop reason without counting a restart, got %q and %d restarts", updated.StopReason, updated.RestartCount)
+	}
+}
+
+func TestSchedulerDryRun(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	now := time.Now()
+	for _, instance := range []*models.Instance{
+		{ID: "i-expired", State: "running", InstanceType: "m5.large", ExpiresAt: now.Add(-time.Hour)},
+		{ID: "i-extended", State: "stopped", ExpiresAt: now.Add(time.Hour)},
+		{ID: "i-old", State: "stopped", ExpiresAt: now.Add(-time.Hour), StoppedAt: now.Add(-72 * time.Hour)},
+		{ID: "i-budget", State: "running", InstanceType: "m5.large", ExpiresAt: now.Add(time.Hour)},
+	} {
+		if err := storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+		provider.SetInstanceStatus(instance.ID, instance.State)
+	}
+
+	var logs bytes.Buffer
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(&logs)
+	sched.SetDryRun(true)
+	sched.SetDailyBudget(1)
+	sched.SetTerminateStoppedAfter(24 * time.Hour)
+	sched.RunOnce()
+
+	if len(provider.stopCalls)+len(provider.startCalls)+len(provider.terminateCalls) != 0 {
+		t.Errorf("Expected no provider actions, got stops %v, starts %v, terminations %v", provider.stopCalls, provider.startCalls, provider.terminateCalls)
+	}
+	for _, msg := range []string{
+		"Instance has EXPIRED - it would be stopped",
+		"Would restart stopped instance",
+		"Would terminate instance that stayed stopped too long",
+		"Would stop instance to stay under the daily budget",
+	} {
+		if !strings.Contains(logs.String(), msg) {
+			t.Errorf("Expected the log to contain %q, got:\n%s", msg, logs.String())
+		}
+	}
+
+	instances, err := storage.ListInstances()
+	if err != nil {
+		t.Fatalf("Failed to list instances: %v", err)
+	}
+	if len(instances) != 4 {
+		t.Fatalf("Expected all 4 instances to stay in storage, got %d", len(instances))
+	}
+	for _, instance := range instances {
+		if instance.StopReason != "" || instance.RestartCount != 0 {
+			t.Errorf("Expected %s to be unchanged, got stop reason %q and %d restarts", instance.ID, instance.StopReason, instance.RestartCount)
+		}
+	}
+
+	// Pending actions are not logged again on the next pass
+	logs.Reset()
+	sched.RunOnce()
+	if strings.Contains(logs.String(), "dry_run") {
+		t.Errorf("Expected repeated actions not to be logged again, got:\n%s", logs.String())
+	}
+}
+
+// blockingProvider counts the status calls in flight and blocks status calls
+// for the hung instance until their context ends
+type blockingProvider struct {
+	*MockProvider
+	hung     string
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+}
+
+func (p *blockingProvider) GetInstanceStatus(ctx context.Context, instanceID string) (*models.InstanceStatus, error) {
+	p.mu.Lock()
+	p.inFlight++
+	p.peak = max(p.peak, p.inFlight)
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.inFlight--
+		p.mu.Unlock()
+	}()
+
+	if instanceID == p.hung {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	time.Sleep(20 * time.Millisecond)
+	return p.MockProvider.GetInstanceStatus(ctx, instanceID)
+}
+
+func TestSchedulerConcurrency(t *testing.T) {
+	provider := &blockingProvider{MockProvider: NewMockProvider(), hung: "i-hung"}
+	storage := storage.NewMemoryStorage()
+	for _, id := range []string{"i-1", "i-2", "i-3", "i-4", "i-5", "i-6", "i-7", "i-hung"} {
+		if err := storage.SaveInstance(&models.Instance{ID: id, State: "running", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+
+	var logs bytes.Buffer
+	sched := scheduler.NewScheduler(provider, storage,
+		scheduler.WithConcurrency(3),
+		scheduler.WithInstanceTimeout(100*time.Millisecond),
+	)
+	sched.SetLogOutput(&logs)
+
+	start := time.Now()
+	sched.RunOnce()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the hung instance to be abandoned after its timeout, the pass took %s", elapsed)
+	}
+
+	if provider.peak != 3 {
+		t.Errorf("Expected at most 3 instances in flight and the pool to fill up, got a peak of %d", provider.peak)
+	}
+	if !strings.Contains(logs.String(), "Failed to process some instances") || !strings.Contains(logs.String(), "instance i-hung") {
+		t.Errorf("Expected the hung instance to be reported, got:\n%s", logs.String())
+	}
+	if strings.Contains(logs.String(), "instance i-1:") {
+		t.Errorf("Expected only the hung instance to be reported, got:\n%s", logs.String())
+	}
+}
+
+// throttlingError is the error an API returns when requests are rate limited
+type throttlingError struct{}
+
+func (throttlingError) Error() string {
+	return "api error RequestLimitExceeded: Request limit exceeded."
+}
+func (throttlingError) ErrorCode() string { return "RequestLimitExceeded" }
+
+// throttledProvider rejects the first stop calls with a throttling error
+type throttledProvider struct {
+	*MockProvider
+	throttledStops int
+	stopAttempts   int
+}
+
+func (p *throttledProvider) StopInstance(ctx context.Context, instanceID string) error {
+	p.stopAttempts++
+	if p.stopAttempts <= p.throttledStops {
+		return throttlingError{}
+	}
+	return p.MockProvider.StopInstance(ctx, instanceID)
+}
+
+func TestSchedulerRetriesThrottledCalls(t *testing.T) {
+	provider := &throttledProvider{MockProvider: NewMockProvider(), throttledStops: 2}
+	storage := storage.NewMemoryStorage()
+	if err := storage.SaveInstance(&models.Instance{ID: "i-throttled", State: "running", ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.SetInstanceStatus("i-throttled", "running")
+
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetRetryPolicy(cloud.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	sched.RunOnce()
+
+	if provider.stopAttempts != 3 || len(provider.stopCalls) != 1 {
+		t.Fatalf("Expected the stop to succeed on the third attempt, got %d attempts and %d stops", provider.stopAttempts, len(provider.stopCalls))
+	}
+	instance, err := storage.GetInstance("i-throttled")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if instance.State != "stopping" {
+		t.Errorf("Expected the instance to be stopping, got %s", instance.State)
+	}
+
+	// Without retries the throttled call fails and the instance keeps running
+	provider = &throttledProvider{MockProvider: NewMockProvider(), throttledStops: 1}
+	provider.SetInstanceStatus("i-throttled", "running")
+	if err := storage.UpdateInstance(&models.Instance{ID: "i-throttled", State: "running", ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("Failed to update instance: %v", err)
+	}
+	sched = scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetRetryPolicy(cloud.RetryPolicy{MaxAttempts: 1})
+	sched.RunOnce()
+	if provider.stopAttempts != 1 || len(provider.stopCalls) != 0 {
+		t.Errorf("Expected a single failed attempt, got %d attempts and %d stops", provider.stopAttempts, len(provider.stopCalls))
+	}
+}
+
+// fakeElector elects this replica while leading is set
+type fakeElector struct {
+	leading  bool
+	err      error
+	resigned bool
+}
+
+func (e *fakeElector) Campaign(ctx context.Context) (bool, error) {
+	return e.leading, e.err
+}
+
+func (e *fakeElector) Resign(ctx context.Context) error {
+	e.resigned = true
+	return nil
+}
+
+func TestSchedulerLeaderElection(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+	if err := storage.SaveInstance(&models.Instance{ID: "i-expired", State: "running", ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.SetInstanceStatus("i-expired", "running")
+
+	elector := &fakeElector{}
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetLeaderElector(elector)
+
+	sched.RunOnce()
+	if len(provider.stopCalls) != 0 {
+		t.Fatalf("Expected a standby replica to take no action, got stops %v", provider.stopCalls)
+	}
+
+	elector.err = errors.New("consul unreachable")
+	elector.leading = true
+	sched.RunOnce()
+	if len(provider.stopCalls) != 0 {
+		t.Fatalf("Expected a replica that cannot reach the election to take no action, got stops %v", provider.stopCalls)
+	}
+
+	elector.err = nil
+	sched.RunOnce()
+	if len(provider.stopCalls) != 1 {
+		t.Errorf("Expected the leader to stop the expired instance, got stops %v", provider.stopCalls)
+	}
+
+	sched.Stop()
+	if !elector.resigned {
+		t.Error("Expected the scheduler to give up leadership when stopped")
+	}
+}
+
+// lapsingElector leads until lost is closed, after which holding the
+// leadership fails
+type lapsingElector struct {
+	fakeElector
+	lost chan struct{}
+}
+
+func (e *lapsingElector) Hold(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-e.lost:
+		return errors.New("consul session expired")
+	}
+}
+
+// stallingProvider blocks status calls until their context ends, signalling
+// started when the first one begins
+type stallingProvider struct {
+	*MockProvider
+	started chan struct{}
+	once    sync.Once
+}
+
+func (p *stallingProvider) GetInstanceStatus(ctx context.Context, instanceID string) (*models.InstanceStatus, error) {
+	p.once.Do(func() { close(p.started) })
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(5 * time.Second):
+		return p.MockProvider.GetInstanceStatus(ctx, instanceID)
+	}
+}
+
+func TestSchedulerLeadershipLostDuringPass(t *testing.T) {
+	provider := &stallingProvider{MockProvider: NewMockProvider(), started: make(chan struct{})}
+	storage := storage.NewMemoryStorage()
+	if err := storage.SaveInstance(&models.Instance{ID: "i-expired", State: "running", ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	elector := &lapsingElector{fakeElector: fakeElector{leading: true}, lost: make(chan struct{})}
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetLeaderElector(elector)
+	go func() {
+		<-provider.started
+		close(elector.lost)
+	}()
+
+	start := time.Now()
+	sched.RunOnce()
+	if len(provider.stopCalls) != 0 {
+		t.Errorf("Expected a replica that lost leadership to abandon the pass, got stops %v", provider.stopCalls)
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("Expected the pass to be cancelled when leadership was lost, took %s", elapsed)
+	}
+}
+
+func TestSchedulerLifecycleHooks(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+	now := time.Now()
+	for _, instance := range []*models.Instance{
+		{ID: "i-expired", State: "running", ExpiresAt: now.Add(-time.Minute)},
+		{ID: "i-old", State: "stopped", ExpiresAt: now.Add(-time.Hour), StoppedAt: now.Add(-72 * time.Hour)},
+	} {
+		if err := storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+		provider.SetInstanceStatus(instance.ID, instance.State)
+	}
+
+	out := filepath.Join(t.TempDir(), "hooks.out")
+	record := `echo "$HOOK_EVENT $HOOK_REASON $INSTANCE_ID" >> ` + out
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetTerminateStoppedAfter(24 * time.Hour)
+	sched.SetHooks(hooks.NewRunner([]hooks.Hook{
+		{Event: hooks.PreStop, Command: record},
+		{Event: hooks.PostStop, Command: record},
+		{Event: hooks.PreTerminate, Command: record + "; exit 1", OnFailure: hooks.FailAbort},
+	}))
+	sched.RunOnce()
+
+	if len(provider.stopCalls) != 1 || len(provider.terminateCalls) != 0 {
+		t.Errorf("Expected the stop to proceed and the aborted termination to be skipped, got stops %v, terminations %v", provider.stopCalls, provider.terminateCalls)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Expected the hooks to run: %v", err)
+	}
+	for _, line := range []string{"pre-stop expired i-expired", "post-stop expired i-expired", "pre-terminate stopped-too-long i-old"} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("Expected hook output %q, got:\n%s", line, data)
+		}
+	}
+	instance, err := storage.GetInstance("i-old")
+	if err != nil || instance.State != "stopped" {
+		t.Errorf("Expected the instance whose termination was aborted to stay stopped, got %+v, %v", instance, err)
+	}
+}
+
+func TestSchedulerAbortedStopSkipsBackups(t *testing.T) {
+	provider := &snapshotProvider{MockProvider: NewMockProvider()}
+	storage := storage.NewMemoryStorage()
+	instance := &models.Instance{ID: "i-backup", State: "running", ExpiresAt: time.Now().Add(-time.Hour), SnapshotOnExpiry: true, ImageOnExpiry: true}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetHooks(hooks.NewRunner([]hooks.Hook{
+		{Event: hooks.PreStop, Command: "exit 1", OnFailure: hooks.FailAbort},
+	}))
+	sched.RunOnce()
+	sched.RunOnce()
+
+	if len(provider.snapshotCalls) != 0 || len(provider.imageCalls) != 0 || len(provider.stopCalls) != 0 {
+		t.Errorf("Expected an aborted stop to skip the backups, got snapshots %v, images %v, stops %v", provider.snapshotCalls, provider.imageCalls, provider.stopCalls)
+	}
+}
+
+func TestSchedulerMaxLifetime(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+	now := time.Now()
+	for _, instance := range []*models.Instance{
+		{ID: "i-overdue", State: "running", LaunchTime: now.Add(-80 * time.Hour), ExpiresAt: now.Add(time.Hour), Duration: 81 * time.Hour},
+		{ID: "i-capped", State: "running", LaunchTime: now.Add(-70 * time.Hour), ExpiresAt: now.Add(10 * time.Hour), Duration: 80 * time.Hour},
+		{ID: "i-young", State: "running", LaunchTime: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour), Duration: 2 * time.Hour},
+	} {
+		if err := storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+		provider.SetInstanceStatus(instance.ID, instance.State)
+	}
+
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetMaxLifetime(72 * time.Hour)
+	sched.RunOnce()
+
+	if len(provider.stopCalls) != 1 || provider.stopCalls[0] != "i-overdue" {
+		t.Errorf("Expected only the instance past its maximum lifetime to be stopped, got %v", provider.stopCalls)
+	}
+	capped, err := storage.GetInstance("i-capped")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if expected := capped.LaunchTime.Add(72 * time.Hour); !capped.ExpiresAt.Equal(expected) || capped.Duration != 72*time.Hour {
+		t.Errorf("Expected the expiry to move back to %s with a 72h duration, got %s and %s", expected, capped.ExpiresAt, capped.Duration)
+	}
+	young, err := storage.GetInstance("i-young")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if !young.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected the expiry within the maximum lifetime to be kept, got %s", young.ExpiresAt)
+	}
+}
+
+func TestSchedulerMaxLifetimeDryRun(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+	now := time.Now()
+	instance := &models.Instance{ID: "i-overdue", State: "running", LaunchTime: now.Add(-80 * time.Hour), ExpiresAt: now.Add(time.Hour), Duration: 81 * time.Hour}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.SetInstanceStatus(instance.ID, instance.State)
+
+	var logs bytes.Buffer
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(&logs)
+	sched.SetDryRun(true)
+	sched.SetMaxLifetime(72 * time.Hour)
+	sched.RunOnce()
+
+	if len(provider.stopCalls) != 0 {
+		t.Errorf("Expected no stop in dry-run mode, got %v", provider.stopCalls)
+	}
+	if !strings.Contains(logs.String(), "it would be stopped (max lifetime exceeded)") {
+		t.Errorf("Expected the dry run to report the stop, got:\n%s", logs.String())
+	}
+	stored, err := storage.GetInstance("i-overdue")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if !stored.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected the stored expiry to be kept in dry-run mode, got %s", stored.ExpiresAt)
+	}
+}
+
+// listingProvider lists a fixed set of managed instances
+type listingProvider struct {
+	*MockProvider
+	listed []*models.Instance
+}
+
+func (p *listingProvider) ListInstances(ctx context.Context) ([]*models.Instance, error) {
+	instances := make([]*models.Instance, 0, len(p.listed))
+	for _, instance := range p.listed {
+		copied := *instance
+		instances = append(instances, &copied)
+	}
+	return instances, nil
+}
+
+func TestSchedulerOrphanCheck(t *testing.T) {
+	now := time.Now()
+	provider := &listingProvider{
+		MockProvider: NewMockProvider(),
+		listed: []*models.Instance{
+			{ID: "i-stored", State: "running", LaunchTime: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+			{ID: "i-orphan", State: "running", LaunchTime: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour), Duration: 2 * time.Hour},
+			{ID: "i-launching", State: "pending", LaunchTime: now.Add(-time.Minute)},
+		},
+	}
+	storage := storage.NewMemoryStorage()
+	if err := storage.SaveInstance(&models.Instance{ID: "i-stored", Provider: "aws", State: "running", ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	var notifications []notify.Notification
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetNotifier(notify.NotifierFunc(func(ctx context.Context, notification notify.Notification) error {
+		notifications = append(notifications, notification)
+		return nil
+	}))
+	sched.SetOrphanCheck(scheduler.OrphanCheckOptions{Provider: provider, ProviderName: "aws"})
+
+	sched.RunOnce()
+	sched.RunOnce()
+	if len(notifications) != 1 || notifications[0].Event != notify.EventOrphanFound || notifications[0].InstanceID != "i-orphan" {
+		t.Fatalf("Expected the orphan to be reported once, got %+v", notifications)
+	}
+	if _, err := storage.GetInstance("i-orphan"); err == nil {
+		t.Fatal("Expected a reported orphan not to be adopted")
+	}
+
+	sched.SetOrphanCheck(scheduler.OrphanCheckOptions{Provider: provider, ProviderName: "aws", Adopt: true})
+	sched.SetDryRun(true)
+	sched.RunOnce()
+	if _, err := storage.GetInstance("i-orphan"); err == nil {
+		t.Fatal("Expected the orphan not to be adopted in dry-run mode")
+	}
+
+	sched.SetDryRun(false)
+	sched.RunOnce()
+	adopted, err := storage.GetInstance("i-orphan")
+	if err != nil {
+		t.Fatalf("Expected the orphan to be adopted: %v", err)
+	}
+	if adopted.Provider != "aws" || !adopted.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected the adopted orphan to keep its tagged expiry, got %+v", adopted)
+	}
+	if _, err := storage.GetInstance("i-launching"); err == nil {
+		t.Error("Expected an instance still being created not to be taken for an orphan")
+	}
+}
+
+func TestSchedulerPruneTerminated(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	now := time.Now()
+	instances := []*models.Instance{
+		{ID: "i-old123", State: "terminated", TerminatedAt: now.Add(-40 * 24 * time.Hour)},
+		{ID: "i-older123", State: "terminated", TerminatedAt: now.Add(-60 * 24 * time.Hour)},
+		{ID: "i-recent123", State: "terminated", TerminatedAt: now.Add(-24 * time.Hour)},
+		{ID: "i-gone123", State: "running", ExpiresAt: now.Add(time.Hour)},
+	}
+	for _, instance := range instances {
+		if err := storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+	provider.SetInstanceStatus("i-gone123", "terminated")
+
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.SetDryRun(true)
+	sched.SetPruneTerminated(scheduler.PruneOptions{After: 30 * 24 * time.Hour})
+	sched.RunOnce()
+	if _, err := storage.GetInstance("i-old123"); err != nil {
+		t.Fatal("Expected no record to be pruned in dry-run mode")
+	}
+
+	// An instance first seen terminated starts its retention period now
+	gone, err := storage.GetInstance("i-gone123")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if gone.TerminatedAt.IsZero() {
+		t.Error("Expected TerminatedAt to be recorded for i-gone123")
+	}
+
+	sched.SetDryRun(false)
+	sched.SetPruneTerminated(scheduler.PruneOptions{After: 30 * 24 * time.Hour})
+	sched.RunOnce()
+	for _, id := range []string{"i-old123", "i-older123"} {
+		if _, err := storage.GetInstance(id); err == nil {
+			t.Errorf("Expected %s to be pruned", id)
+		}
+	}
+	for _, id := range []string{"i-recent123", "i-gone123"} {
+		if _, err := storage.GetInstance(id); err != nil {
+			t.Errorf("Expected %s to be kept: %v", id, err)
+		}
+	}
+
+	sched.SetPruneTerminated(scheduler.PruneOptions{After: time.Hour, Archive: true})
+	sched.RunOnce()
+	archived, err := storage.ListArchived()
+	if err != nil {
+		t.Fatalf("Failed to list archived instances: %v", err)
+	}
+	if len(archived) != 1 || archived[0].Instance.ID != "i-recent123" || archived[0].Reason != models.ArchiveReasonPruned {
+		t.Errorf("Expected i-recent123 to be archived, got %+v", archived)
+	}
+}
+
+func TestSchedulerRecordsRunReport(t *testing.T) {
+	provider := &snapshotProvider{MockProvider: NewMockProvider()}
+	storage := storage.NewMemoryStorage()
+
+	now := time.Now()
+	instances := []*models.Instance{
+		{ID: "i-expired123", State: "running", LaunchTime: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+		{ID: "i-stopped123", State: "running", LaunchTime: now.Add(-30 * time.Minute), ExpiresAt: now.Add(time.Hour)},
+	}
+	for _, instance := range instances {
+		if err := storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+	provider.SetInstanceStatus("i-expired123", "running")
+	provider.SetInstanceStatus("i-stopped123", "stopped")
+
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.RunOnce()
+
+	run, err := storage.LastSchedulerRun()
+	if err != nil {
+		t.Fatalf("Failed to read the run report: %v", err)
+	}
+	if run == nil {
+		t.Fatal("Expected the pass to be recorded")
+	}
+	if run.Instances != 2 || run.Actions["stopped"] != 1 || run.Actions["restarted"] != 1 || run.ErrorCount != 0 {
+		t.Errorf("Unexpected run report: %+v", run)
+	}
+	if run.StartedAt.IsZero() || run.Duration <= 0 {
+		t.Errorf("Expected the start and duration of the pass, got %+v", run)
+	}
+
+	if err := storage.SaveInstance(&models.Instance{ID: "i-failing123", State: "running", ExpiresAt: now.Add(-time.Minute)}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.SetInstanceStatus("i-failing123", "running")
+	provider.stopErr = errors.New("UnauthorizedOperation")
+	sched.RunOnce()
+
+	run, err = storage.LastSchedulerRun()
+	if err != nil {
+		t.Fatalf("Failed to read the run report: %v", err)
+	}
+	if run.ErrorCount == 0 || !strings.Contains(strings.Join(run.Errors, "\n"), "instance i-failing123: Failed to stop expired instance: UnauthorizedOperation") {
+		t.Errorf("Expected the failed stop to be reported, got %+v", run)
+	}
+}
+
+func TestSchedulerPause(t *testing.T) {
+	provider := NewMockProvider()
+	storage := storage.NewMemoryStorage()
+
+	now := time.Now()
+	instance := &models.Instance{ID: "i-expired123", State: "running", LaunchTime: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}
+	if err := storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	provider.SetInstanceStatus("i-expired123", "running")
+
+	if err := storage.PauseScheduler(&models.SchedulerPause{PausedAt: now, Reason: "incident"}); err != nil {
+		t.Fatalf("Failed to pause the scheduler: %v", err)
+	}
+
+	sched := scheduler.NewScheduler(provider, storage)
+	sched.SetLogOutput(io.Discard)
+	sched.RunOnce()
+
+	status, _ := provider.GetInstanceStatus(context.Background(), "i-expired123")
+	if status.State != "running" {
+		t.Errorf("Expected a paused scheduler to leave the instance running, got %s", status.State)
+	}
+	if run, _ := storage.LastSchedulerRun(); run != nil {
+		t.Errorf("Expected no pass while paused, got %+v", run)
+	}
+
+	resumed, err := storage.ResumeScheduler()
+	if err != nil || !resumed {
+		t.Fatalf("Expected the scheduler to resume, got %v, %v", resumed, err)
+	}
+	sched.RunOnce()
+
+	status, _ = provider.GetInstanceStatus(context.Background(), "i-expired123")
+	if status.State == "running" {
+		t.Errorf("Expected the resumed scheduler to stop the expired instance, got %s", status.State)
+	}
+
+	// A pause that has run out no longer holds
+	if err := storage.PauseScheduler(&models.SchedulerPause{PausedAt: now.Add(-time.Hour), Until: now.Add(-time.Minute)}); err != nil {
+		t.Fatalf("Failed to pause the scheduler: %v", err)
+	}
+	if pause, err := storage.SchedulerPause(); err != nil || pause != nil {
+		t.Errorf("Expected the ended pause to be ignored, got %+v, %v", pause, err)
+	}
+	if resumed, _ := storage.ResumeScheduler(); resumed {
+		t.Error("Expected resuming after the pause ended to report it was not paused")
+	}
+}
+
+func TestSchedulerBackups(t *testing.T) {
+	provider := NewMockProvider()
+	dir := t.TempDir()
+	store := storage.NewMemoryStorage()
+	if err := store.SaveInstance(&models.Instance{ID: "i-1", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	if err := store.PauseScheduler(&models.SchedulerPause{PausedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to pause the scheduler: %v", err)
+	}
+
+	sched := scheduler.NewScheduler(provider, store)
+	backups := filepath.Join(dir, "backups")
+	sched.SetBackups(scheduler.BackupOptions{Dir: backups, Interval: time.Hour, Keep: 3})
+	sched.RunOnce()
+	// The next backup is only due after the interval
+	sched.RunOnce()
+
+	entries, err := os.ReadDir(backups)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected one backup while paused, got %v, %v", entries, err)
+	}
+	file, err := os.Open(filepath.Join(backups, entries[0].Name()))
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	defer file.Close()
+	backup, err := storage.ReadBackup(file, nil)
+	if err != nil || backup.Instances["i-1"] == nil {
+		t.Errorf("Expected the backup to hold i-1, got %v, %v", backup, err)
+	}
+}
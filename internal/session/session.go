@@ -0,0 +1,43 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/storage"
+)
+
+// Instances returns the provider's managed instances that belong to the session
+func Instances(ctx context.Context, provider cloud.CloudProvider, session string) ([]*models.Instance, error) {
+	instances, err := provider.ListInstances(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instances: %w", err)
+	}
+	return models.FilterBySession(instances, session), nil
+}
+
+// Terminate terminates the given instances and moves their records to the
+// archive, recording actor as who terminated them.
+// It continues past failures and returns the IDs that were terminated along
+// with an error describing any that were not.
+func Terminate(ctx context.Context, provider cloud.CloudProvider, store storage.Storage, instances []*models.Instance, actor string) ([]string, error) {
+	var terminated []string
+	var failures []string
+	for _, instance := range instances {
+		if err := provider.TerminateInstance(ctx, instance.ID); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", instance.ID, err))
+			continue
+		}
+		terminated = append(terminated, instance.ID)
+		_ = storage.ArchiveTerminated(store, instance.ID, actor, time.Now())
+	}
+
+	if len(failures) > 0 {
+		return terminated, fmt.Errorf("failed to terminate %d instance(s): %s", len(failures), strings.Join(failures, "; "))
+	}
+	return terminated, nil
+}
@@ -0,0 +1,156 @@
+package session_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"instance-manager/internal/session"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/storage"
+)
+
+// MockProvider implements the CloudProvider interface for testing
+type MockProvider struct {
+	instances      []*models.Instance
+	terminateCalls []string
+	failTerminate  map[string]bool
+}
+
+func (m *MockProvider) CreateInstance(ctx context.Context, config models.InstanceConfig) (*models.Instance, error) {
+	return nil, nil
+}
+
+func (m *MockProvider) GetInstanceStatus(ctx context.Context, instanceID string) (*models.InstanceStatus, error) {
+	return nil, nil
+}
+
+func (m *MockProvider) StartInstance(ctx context.Context, instanceID string) error {
+	return nil
+}
+
+func (m *MockProvider) StopInstance(ctx context.Context, instanceID string) error {
+	return nil
+}
+
+func (m *MockProvider) TerminateInstance(ctx context.Context, instanceID string) error {
+	if m.failTerminate[instanceID] {
+		return errors.New("access denied")
+	}
+	m.terminateCalls = append(m.terminateCalls, instanceID)
+	return nil
+}
+
+func (m *MockProvider) ListInstances(ctx context.Context) ([]*models.Instance, error) {
+	return m.instances, nil
+}
+
+func (m *MockProvider) ValidateCredentials(ctx context.Context) error {
+	return nil
+}
+
+func newFixture(t *testing.T) (*MockProvider, *storage.MemoryStorage) {
+	t.Helper()
+
+	provider := &MockProvider{
+		instances: []*models.Instance{
+			{ID: "i-a1", Session: "exp-a", State: "running"},
+			{ID: "i-a2", Session: "exp-a", State: "stopped"},
+			{ID: "i-b1", Session: "exp-b", State: "running"},
+			{ID: "i-none", State: "running"},
+		},
+	}
+
+	store := storage.NewMemoryStorage()
+	for _, instance := range provider.instances {
+		instance.ExpiresAt = time.Now().Add(time.Hour)
+		if err := store.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+	return provider, store
+}
+
+func TestInstances_FiltersBySession(t *testing.T) {
+	provider, _ := newFixture(t)
+
+	tests := []struct {
+		session string
+		want    []string
+	}{
+		{"exp-a", []string{"i-a1", "i-a2"}},
+		{"exp-b", []string{"i-b1"}},
+		{"exp-c", nil},
+	}
+
+	for _, tt := range tests {
+		instances, err := session.Instances(context.Background(), provider, tt.session)
+		if err != nil {
+			t.Fatalf("Instances failed: %v", err)
+		}
+		var ids []string
+		for _, instance := range instances {
+			ids = append(ids, instance.ID)
+		}
+		if strings.Join(ids, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("Session %s: expected %v, got %v", tt.session, tt.want, ids)
+		}
+	}
+}
+
+func TestTerminate_ScopedToSession(t *testing.T) {
+	provider, store := newFixture(t)
+
+	instances, err := session.Instances(context.Background(), provider, "exp-a")
+	if err != nil {
+		t.Fatalf("Instances failed: %v", err)
+	}
+
+	terminated, err := session.Terminate(context.Background(), provider, store, instances, "alice")
+	if err != nil {
+		t.Fatalf("Terminate failed: %v", err)
+	}
+
+	if strings.Join(terminated, ",") != "i-a1,i-a2" {
+		t.Errorf("Expected i-a1 and i-a2 to be terminated, got %v", terminated)
+	}
+	if strings.Join(provider.terminateCalls, ",") != "i-a1,i-a2" {
+		t.Errorf("Expected terminate calls only for session exp-a, got %v", provider.terminateCalls)
+	}
+
+	remaining, err := store.ListInstances()
+	if err != nil {
+		t.Fatalf("ListInstances failed: %v", err)
+	}
+	if len(remaining) != 2 {
+		t.Errorf("Expected 2 instances left in storage, got %d", len(remaining))
+	}
+	for _, instance := range remaining {
+		if instance.Session == "exp-a" {
+			t.Errorf("Instance %s from the terminated session is still stored", instance.ID)
+		}
+	}
+	archived, err := store.ListArchived()
+	if err != nil || len(archived) != 2 || archived[0].Reason != models.ArchiveReasonTerminated {
+		t.Errorf("Expected the terminated instances to be archived, got %v, %v", archived, err)
+	}
+}
+
+func TestTerminate_ReportsFailures(t *testing.T) {
+	provider, store := newFixture(t)
+	provider.failTerminate = map[string]bool{"i-a1": true}
+
+	instances, _ := session.Instances(context.Background(), provider, "exp-a")
+	terminated, err := session.Terminate(context.Background(), provider, store, instances, "alice")
+	if err == nil || !strings.Contains(err.Error(), "i-a1") {
+		t.Errorf("Expected an error mentioning i-a1, got %v", err)
+	}
+	if strings.Join(terminated, ",") != "i-a2" {
+		t.Errorf("Expected i-a2 to still be terminated, got %v", terminated)
+	}
+	if _, err := store.GetInstance("i-a1"); err != nil {
+		t.Error("Expected the instance that failed to terminate to stay in storage")
+	}
+}
@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+)
+
+// hourlyPrices holds approximate on-demand Linux prices (USD per hour, us-east-1)
+// for the supported instance types
+var hourlyPrices = map[string]float64{
+	"t2.nano":     0.0058,
+	"t2.micro":    0.0116,
+	"t2.small":    0.023,
+	"t2.medium":   0.0464,
+	"t2.large":    0.0928,
+	"t2.xlarge":   0.1856,
+	"t2.2xlarge":  0.3712,
+	"t3.nano":     0.0052,
+	"t3.micro":    0.0104,
+	"t3.small":    0.0208,
+	"t3.medium":   0.0416,
+	"t3.large":    0.0832,
+	"t3.xlarge":   0.1664,
+	"t3.2xlarge":  0.3328,
+	"m5.large":    0.096,
+	"m5.xlarge":   0.192,
+	"m5.2xlarge":  0.384,
+	"m5.4xlarge":  0.768,
+	"m5.8xlarge":  1.536,
+	"m5.12xlarge": 2.304,
+	"m5.16xlarge": 3.072,
+	"m5.24xlarge": 4.608,
+	"c5.large":    0.085,
+	"c5.xlarge":   0.17,
+	"c5.2xlarge":  0.34,
+	"c5.4xlarge":  0.68,
+	"c5.9xlarge":  1.53,
+	"c5.12xlarge": 2.04,
+	"c5.18xlarge": 3.06,
+	"c5.24xlarge": 4.08,
+	// Graviton (arm64) types
+	"t4g.nano":     0.0042,
+	"t4g.micro":    0.0084,
+	"t4g.small":    0.0168,
+	"t4g.medium":   0.0336,
+	"t4g.large":    0.0672,
+	"t4g.xlarge":   0.1344,
+	"t4g.2xlarge":  0.2688,
+	"m6g.medium":   0.0385,
+	"m6g.large":    0.077,
+	"m6g.xlarge":   0.154,
+	"m6g.2xlarge":  0.308,
+	"m6g.4xlarge":  0.616,
+	"m6g.8xlarge":  1.232,
+	"m6g.12xlarge": 1.848,
+	"m6g.16xlarge": 2.464,
+	"m7g.medium":   0.0408,
+	"m7g.large":    0.0816,
+	"m7g.xlarge":   0.1632,
+	"m7g.2xlarge":  0.3264,
+	"m7g.4xlarge":  0.6528,
+	"m7g.8xlarge":  1.3056,
+	"m7g.12xlarge": 1.9584,
+	"m7g.16xlarge": 2.6112,
+	"c6g.medium":   0.034,
+	"c6g.large":    0.068,
+	"c6g.xlarge":   0.136,
+	"c6g.2xlarge":  0.272,
+	"c6g.4xlarge":  0.544,
+	"c6g.8xlarge":  1.088,
+	"c6g.12xlarge": 1.632,
+	"c6g.16xlarge": 2.176,
+	"c7g.medium":   0.0363,
+	"c7g.large":    0.0725,
+	"c7g.xlarge":   0.145,
+	"c7g.2xlarge":  0.29,
+	"c7g.4xlarge":  0.58,
+	"c7g.8xlarge":  1.16,
+	"c7g.12xlarge": 1.74,
+	"c7g.16xlarge": 2.32,
+	// GPU types
+	"g4dn.xlarge":   0.526,
+	"g4dn.2xlarge":  0.752,
+	"g4dn.4xlarge":  1.204,
+	"g4dn.8xlarge":  2.176,
+	"g4dn.12xlarge": 3.912,
+	"g4dn.16xlarge": 4.352,
+	"g5.xlarge":     1.006,
+	"g5.2xlarge":    1.212,
+	"g5.4xlarge":    1.624,
+	"g5.8xlarge":    2.448,
+	"g5.12xlarge":   5.672,
+	"g5.16xlarge":   4.096,
+	"g5.24xlarge":   8.144,
+	"g5.48xlarge":   16.288,
+	"p3.2xlarge":    3.06,
+	"p3.8xlarge":    12.24,
+	"p3.16xlarge":   24.48,
+}
+
+// EstimateHourlyCost returns the approximate on-demand hourly cost of an instance type
+func EstimateHourlyCost(instanceType string) (float64, error) {
+	price, ok := hourlyPrices[instanceType]
+	if !ok {
+		return 0, fmt.Errorf("no price known for instance type: %s", instanceType)
+	}
+	return price, nil
+}
+
+// EstimateCost returns the approximate cost of running an instance type for the given duration
+func EstimateCost(instanceType string, d time.Duration) (float64, error) {
+	price, err := EstimateHourlyCost(instanceType)
+	if err != nil {
+		return 0, err
+	}
+	return price * d.Hours(), nil
+}
@@ -0,0 +1,70 @@
+package utils_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"instance-manager/internal/utils"
+)
+
+func TestEstimateCost(t *testing.T) {
+	tests := []struct {
+		name         string
+		instanceType string
+		duration     time.Duration
+		expected     float64
+		hasError     bool
+	}{
+		{
+			name:         "one hour",
+			instanceType: "t3.large",
+			duration:     time.Hour,
+			expected:     0.0832,
+		},
+		{
+			name:         "full day",
+			instanceType: "m5.large",
+			duration:     24 * time.Hour,
+			expected:     2.304,
+		},
+		{
+			name:         "half hour",
+			instanceType: "c5.xlarge",
+			duration:     30 * time.Minute,
+			expected:     0.085,
+		},
+		{
+			name:         "unknown type",
+			instanceType: "x1.32xlarge",
+			duration:     time.Hour,
+			hasError:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := utils.EstimateCost(tt.instanceType, tt.duration)
+			if tt.hasError {
+				if err == nil {
+					t.Errorf("Expected error for %s, got nil", tt.instanceType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if math.Abs(result-tt.expected) > 1e-9 {
+				t.Errorf("Expected cost %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestEveryValidInstanceTypeHasPrice(t *testing.T) {
+	for _, instanceType := range utils.AllowedInstanceTypes(nil) {
+		if _, err := utils.EstimateHourlyCost(instanceType); err != nil {
+			t.Errorf("Missing price: %v", err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -73,48 +74,230 @@ func FormatDuration(d time.Duration) string {
 	return fmt.Sprintf("%dd%dh", days, hours)
 }
 
+// validInstanceTypes lists the instance types accepted by the tool
+var validInstanceTypes = map[string]bool{
+	"t2.nano":     true,
+	"t2.micro":    true,
+	"t2.small":    true,
+	"t2.medium":   true,
+	"t2.large":    true,
+	"t2.xlarge":   true,
+	"t2.2xlarge":  true,
+	"t3.nano":     true,
+	"t3.micro":    true,
+	"t3.small":    true,
+	"t3.medium":   true,
+	"t3.large":    true,
+	"t3.xlarge":   true,
+	"t3.2xlarge":  true,
+	"m5.large":    true,
+	"m5.xlarge":   true,
+	"m5.2xlarge":  true,
+	"m5.4xlarge":  true,
+	"m5.8xlarge":  true,
+	"m5.12xlarge": true,
+	"m5.16xlarge": true,
+	"m5.24xlarge": true,
+	"c5.large":    true,
+	"c5.xlarge":   true,
+	"c5.2xlarge":  true,
+	"c5.4xlarge":  true,
+	"c5.9xlarge":  true,
+	"c5.12xlarge": true,
+	"c5.18xlarge": true,
+	"c5.24xlarge": true,
+	// Graviton (arm64) types
+	"t4g.nano":     true,
+	"t4g.micro":    true,
+	"t4g.small":    true,
+	"t4g.medium":   true,
+	"t4g.large":    true,
+	"t4g.xlarge":   true,
+	"t4g.2xlarge":  true,
+	"m6g.medium":   true,
+	"m6g.large":    true,
+	"m6g.xlarge":   true,
+	"m6g.2xlarge":  true,
+	"m6g.4xlarge":  true,
+	"m6g.8xlarge":  true,
+	"m6g.12xlarge": true,
+	"m6g.16xlarge": true,
+	"m7g.medium":   true,
+	"m7g.large":    true,
+	"m7g.xlarge":   true,
+	"m7g.2xlarge":  true,
+	"m7g.4xlarge":  true,
+	"m7g.8xlarge":  true,
+	"m7g.12xlarge": true,
+	"m7g.16xlarge": true,
+	"c6g.medium":   true,
+	"c6g.large":    true,
+	"c6g.xlarge":   true,
+	"c6g.2xlarge":  true,
+	"c6g.4xlarge":  true,
+	"c6g.8xlarge":  true,
+	"c6g.12xlarge": true,
+	"c6g.16xlarge": true,
+	"c7g.medium":   true,
+	"c7g.large":    true,
+	"c7g.xlarge":   true,
+	"c7g.2xlarge":  true,
+	"c7g.4xlarge":  true,
+	"c7g.8xlarge":  true,
+	"c7g.12xlarge": true,
+	"c7g.16xlarge": true,
+	// GPU types
+	"g4dn.xlarge":   true,
+	"g4dn.2xlarge":  true,
+	"g4dn.4xlarge":  true,
+	"g4dn.8xlarge":  true,
+	"g4dn.12xlarge": true,
+	"g4dn.16xlarge": true,
+	"g5.xlarge":     true,
+	"g5.2xlarge":    true,
+	"g5.4xlarge":    true,
+	"g5.8xlarge":    true,
+	"g5.12xlarge":   true,
+	"g5.16xlarge":   true,
+	"g5.24xlarge":   true,
+	"g5.48xlarge":   true,
+	"p3.2xlarge":    true,
+	"p3.8xlarge":    true,
+	"p3.16xlarge":   true,
+}
+
 // ValidateInstanceType checks if the instance type is valid
 func ValidateInstanceType(instanceType string) error {
-	validTypes := map[string]bool{
-		"t2.nano":     true,
-		"t2.micro":    true,
-		"t2.small":    true,
-		"t2.medium":   true,
-		"t2.large":    true,
-		"t2.xlarge":   true,
-		"t2.2xlarge":  true,
-		"t3.nano":     true,
-		"t3.micro":    true,
-		"t3.small":    true,
-		"t3.medium":   true,
-		"t3.large":    true,
-		"t3.xlarge":   true,
-		"t3.2xlarge":  true,
-		"m5.large":    true,
-		"m5.xlarge":   true,
-		"m5.2xlarge":  true,
-		"m5.4xlarge":  true,
-		"m5.8xlarge":  true,
-		"m5.12xlarge": true,
-		"m5.16xlarge": true,
-		"m5.24xlarge": true,
-		"c5.large":    true,
-		"c5.xlarge":   true,
-		"c5.2xlarge":  true,
-		"c5.4xlarge":  true,
-		"c5.9xlarge":  true,
-		"c5.12xlarge": true,
-		"c5.18xlarge": true,
-		"c5.24xlarge": true,
-	}
-
-	if !validTypes[instanceType] {
+	if !validInstanceTypes[instanceType] {
 		return fmt.Errorf("invalid instance type: %s", instanceType)
 	}
 
 	return nil
 }
 
+// ValidateInstanceFamily checks if the instance type matches one of the allowed
+// type prefixes. An empty allow-list permits every instance type.
+func ValidateInstanceFamily(instanceType string, allowedPrefixes []string) error {
+	if !isAllowedInstanceType(instanceType, allowedPrefixes) {
+		return fmt.Errorf("instance type %s is not permitted (allowed: %s)", instanceType, strings.Join(allowedPrefixes, ", "))
+	}
+
+	return nil
+}
+
+// ValidateLifetime checks that an instance launched at launch and expiring
+// at expiresAt stays within maxLifetime. Zero maxLifetime, or an unknown
+// launch time, allows any expiry.
+func ValidateLifetime(launch, expiresAt time.Time, maxLifetime time.Duration) error {
+	if maxLifetime <= 0 || launch.IsZero() {
+		return nil
+	}
+	if end := launch.Add(maxLifetime); expiresAt.After(end) {
+		return fmt.Errorf("instances may run at most %s after launch, so this one must expire by %s", FormatDuration(maxLifetime), end.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// AllowedInstanceTypes returns the sorted list of valid instance types permitted
+// by the given type prefixes
+func AllowedInstanceTypes(allowedPrefixes []string) []string {
+	var types []string
+	for instanceType := range validInstanceTypes {
+		if isAllowedInstanceType(instanceType, allowedPrefixes) {
+			types = append(types, instanceType)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// isAllowedInstanceType reports whether the instance type matches an allowed prefix
+func isAllowedInstanceType(instanceType string, allowedPrefixes []string) bool {
+	if len(allowedPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range allowedPrefixes {
+		if strings.HasPrefix(instanceType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTimeOfDay parses a 24-hour "HH:MM" time of day into the offset from midnight
+func ParseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (expected HH:MM)", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ValidateSession checks that a session identifier is 1-64 letters, digits, '.', '_' or '-'
+func ValidateSession(session string) error {
+	if session == "" {
+		return fmt.Errorf("session cannot be empty")
+	}
+	if len(session) > 64 {
+		return fmt.Errorf("session must be at most 64 characters: %s", session)
+	}
+	for _, r := range session {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return fmt.Errorf("invalid character %q in session: %s", r, session)
+		}
+	}
+
+	return nil
+}
+
+// ValidateInstanceName checks that an instance name is 1-64 letters, digits,
+// '.', '_' or '-' and cannot be mistaken for an EC2 instance ID
+func ValidateInstanceName(name string) error {
+	if name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+	if len(name) > 64 {
+		return fmt.Errorf("name must be at most 64 characters: %s", name)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return fmt.Errorf("invalid character %q in name: %s", r, name)
+		}
+	}
+	if strings.HasPrefix(name, "i-") {
+		return fmt.Errorf("name must not start with \"i-\", which is reserved for instance IDs: %s", name)
+	}
+	return nil
+}
+
+// ValidateImageID checks that an image ID has the EC2 AMI form ami- followed
+// by 8 or 17 hexadecimal digits
+func ValidateImageID(imageID string) error {
+	digits, ok := strings.CutPrefix(imageID, "ami-")
+	if !ok || (len(digits) != 8 && len(digits) != 17) {
+		return fmt.Errorf("invalid image ID (expected ami- followed by 8 or 17 hex digits): %s", imageID)
+	}
+	for _, r := range digits {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return fmt.Errorf("invalid image ID (expected ami- followed by 8 or 17 hex digits): %s", imageID)
+		}
+	}
+	return nil
+}
+
+// ValidateSpotPrice checks that a spot max price is a positive hourly price in
+// USD, such as "0.0035". An empty price is valid and means the on-demand price.
+func ValidateSpotPrice(price string) error {
+	if price == "" {
+		return nil
+	}
+	value, err := strconv.ParseFloat(price, 64)
+	if err != nil || value <= 0 {
+		return fmt.Errorf("spot max price must be a positive hourly price in USD: %s", price)
+	}
+	return nil
+}
+
 // ValidateAvailabilityZone checks if the availability zone format is valid
 func ValidateAvailabilityZone(az string) error {
 	if az == "" {
@@ -1,6 +1,7 @@
 package utils_test
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -164,6 +165,21 @@ func TestValidateInstanceType(t *testing.T) {
 			instanceType: "m5.large",
 			hasError:     false,
 		},
+		{
+			name:         "valid Graviton t4g.micro",
+			instanceType: "t4g.micro",
+			hasError:     false,
+		},
+		{
+			name:         "valid Graviton m7g.large",
+			instanceType: "m7g.large",
+			hasError:     false,
+		},
+		{
+			name:         "valid GPU g4dn.xlarge",
+			instanceType: "g4dn.xlarge",
+			hasError:     false,
+		},
 		{
 			name:         "invalid type",
 			instanceType: "invalid.type",
@@ -245,3 +261,187 @@ func TestValidateAvailabilityZone(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateInstanceFamily(t *testing.T) {
+	tests := []struct {
+		name         string
+		instanceType string
+		allowed      []string
+		hasError     bool
+	}{
+		{
+			name:         "empty allow-list permits all",
+			instanceType: "m5.24xlarge",
+			allowed:      nil,
+			hasError:     false,
+		},
+		{
+			name:         "allowed family",
+			instanceType: "t3.micro",
+			allowed:      []string{"t2.", "t3."},
+			hasError:     false,
+		},
+		{
+			name:         "disallowed family",
+			instanceType: "c5.24xlarge",
+			allowed:      []string{"t2.", "t3."},
+			hasError:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := utils.ValidateInstanceFamily(tt.instanceType, tt.allowed)
+			if tt.hasError && err == nil {
+				t.Errorf("ValidateInstanceFamily(%q) expected error, got nil", tt.instanceType)
+			}
+			if !tt.hasError && err != nil {
+				t.Errorf("ValidateInstanceFamily(%q) unexpected error: %v", tt.instanceType, err)
+			}
+		})
+	}
+}
+
+func TestAllowedInstanceTypes(t *testing.T) {
+	types := utils.AllowedInstanceTypes([]string{"t2."})
+	if len(types) != 7 {
+		t.Errorf("Expected 7 t2 types, got %d: %v", len(types), types)
+	}
+	for _, instanceType := range types {
+		if instanceType[:3] != "t2." {
+			t.Errorf("Unexpected instance type %s in t2 allow-list", instanceType)
+		}
+	}
+
+	if all := utils.AllowedInstanceTypes(nil); len(all) != 86 {
+		t.Errorf("Expected all 86 instance types with empty allow-list, got %d", len(all))
+	}
+}
+
+func TestValidateSession(t *testing.T) {
+	tests := []struct {
+		session  string
+		hasError bool
+	}{
+		{"exp-42", false},
+		{"batch_2024.01", false},
+		{"", true},
+		{"has space", true},
+		{"semi;colon", true},
+		{strings.Repeat("a", 65), true},
+	}
+
+	for _, tt := range tests {
+		err := utils.ValidateSession(tt.session)
+		if (err != nil) != tt.hasError {
+			t.Errorf("ValidateSession(%q) error = %v, want error %v", tt.session, err, tt.hasError)
+		}
+	}
+}
+
+func TestValidateInstanceName(t *testing.T) {
+	tests := []struct {
+		name     string
+		hasError bool
+	}{
+		{"web-1", false},
+		{"build.box_2", false},
+		{"", true},
+		{"has space", true},
+		{"i-0123456789abcdef0", true},
+		{strings.Repeat("a", 65), true},
+	}
+
+	for _, tt := range tests {
+		err := utils.ValidateInstanceName(tt.name)
+		if (err != nil) != tt.hasError {
+			t.Errorf("ValidateInstanceName(%q) error = %v, want error %v", tt.name, err, tt.hasError)
+		}
+	}
+}
+
+func TestValidateImageID(t *testing.T) {
+	tests := []struct {
+		imageID  string
+		hasError bool
+	}{
+		{"ami-0123456789abcdef0", false},
+		{"ami-12345678", false},
+		{"", true},
+		{"ami-", true},
+		{"ami-0123456789ABCDEF0", true},
+		{"snap-0123456789abcdef0", true},
+		{"ami-123456789", true},
+	}
+
+	for _, tt := range tests {
+		err := utils.ValidateImageID(tt.imageID)
+		if (err != nil) != tt.hasError {
+			t.Errorf("ValidateImageID(%q) error = %v, want error %v", tt.imageID, err, tt.hasError)
+		}
+	}
+}
+
+func TestValidateSpotPrice(t *testing.T) {
+	tests := []struct {
+		price    string
+		hasError bool
+	}{
+		{"", false},
+		{"0.0035", false},
+		{"1", false},
+		{"0", true},
+		{"-0.5", true},
+		{"$0.01", true},
+	}
+
+	for _, tt := range tests {
+		err := utils.ValidateSpotPrice(tt.price)
+		if (err != nil) != tt.hasError {
+			t.Errorf("ValidateSpotPrice(%q) error = %v, want error %v", tt.price, err, tt.hasError)
+		}
+	}
+}
+
+func TestParseTimeOfDay(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		hasError bool
+	}{
+		{"18:00", 18 * time.Hour, false},
+		{"09:30", 9*time.Hour + 30*time.Minute, false},
+		{" 00:05 ", 5 * time.Minute, false},
+		{"24:00", 0, true},
+		{"6pm", 0, true},
+	}
+
+	for _, tt := range tests {
+		result, err := utils.ParseTimeOfDay(tt.input)
+		if (err != nil) != tt.hasError {
+			t.Errorf("ParseTimeOfDay(%q) error = %v, want error %v", tt.input, err, tt.hasError)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("ParseTimeOfDay(%q) = %v, want %v", tt.input, result, tt.expected)
+		}
+	}
+}
+
+func TestValidateLifetime(t *testing.T) {
+	launch := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	if err := utils.ValidateLifetime(launch, launch.Add(72*time.Hour), 72*time.Hour); err != nil {
+		t.Errorf("Expected an expiry at the maximum lifetime to be allowed, got %v", err)
+	}
+	err := utils.ValidateLifetime(launch, launch.Add(73*time.Hour), 72*time.Hour)
+	if err == nil || !strings.Contains(err.Error(), "2024-03-07T12:00:00Z") {
+		t.Errorf("Expected an error naming the latest expiry, got %v", err)
+	}
+	if err := utils.ValidateLifetime(launch, launch.Add(1000*time.Hour), 0); err != nil {
+		t.Errorf("Expected no limit without a maximum lifetime, got %v", err)
+	}
+	if err := utils.ValidateLifetime(time.Time{}, launch, time.Hour); err != nil {
+		t.Errorf("Expected no limit without a launch time, got %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+// Package apikey manages the API keys that authenticate requests to the web
+// server. Keys come from the configuration or from a key file managed with
+// the keys command, which keeps only their SHA-256 hashes.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prefix starts every generated key, so leaked keys are easy to recognize
+const prefix = "imk_"
+
+// namePattern is the form of key names
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]*$`)
+
+// Key is a named API key as kept in a key file
+type Key struct {
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"` // Hex SHA-256 of the key
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Generate returns a new random key
+func Generate() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate an API key: %w", err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// Hash returns the hex SHA-256 of a key
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateName reports whether name can name a key
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid key name %q: use letters, digits, '.', '_', '@' and '-'", name)
+	}
+	return nil
+}
+
+// File is a JSON file holding the hashes of named keys
+type File struct {
+	path string
+}
+
+// NewFile returns the key file at path
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Path returns the path of the file
+func (f *File) Path() string {
+	return f.path
+}
+
+// List returns the keys in the file, sorted by name. A missing file holds
+// no keys.
+func (f *File) List() ([]Key, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys: %w", err)
+	}
+	var keys []Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys in %s: %w", f.path, err)
+	}
+	slices.SortFunc(keys, func(a, b Key) int { return strings.Compare(a.Name, b.Name) })
+	return keys, nil
+}
+
+// Create generates a key named name, stores its hash and returns the key,
+// which cannot be recovered from the file afterwards
+func (f *File) Create(name string) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	keys, err := f.List()
+	if err != nil {
+		return "", err
+	}
+	if slices.ContainsFunc(keys, func(key Key) bool { return key.Name == name }) {
+		return "", fmt.Errorf("an API key named %s already exists", name)
+	}
+	secret, err := Generate()
+	if err != nil {
+		return "", err
+	}
+	keys = append(keys, Key{Name: name, Hash: Hash(secret), CreatedAt: time.Now().UTC()})
+	if err := f.write(keys); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// Revoke removes the key named name
+func (f *File) Revoke(name string) error {
+	keys, err := f.List()
+	if err != nil {
+		return err
+	}
+	kept := slices.DeleteFunc(keys, func(key Key) bool { return key.Name == name })
+	if len(kept) == len(keys) {
+		return fmt.Errorf("no API key named %s", name)
+	}
+	return f.write(kept)
+}
+
+// write replaces the file with keys, readable only by its owner
+func (f *File) write(keys []Key) error {
+	if keys == nil {
+		keys = []Key{}
+	}
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+		return fmt.Errorf("failed to create the API key directory: %w", err)
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write API keys: %w", err)
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write API keys: %w", err)
+	}
+	return nil
+}
+
+// Keyring checks keys against those of the configuration and of a key
+// file. The file is read again whenever it changes, so keys created or
+// revoked with the keys command take effect without a restart. It is safe
+// for concurrent use.
+type Keyring struct {
+	static map[string]string // Hash of each configured key by name
+	file   *File
+
+	mu       sync.Mutex
+	modTime  time.Time
+	size     int64
+	fileKeys []Key
+}
+
+// NewKeyring returns a keyring of the configured keys, by name, and of the
+// keys in file, which may be nil
+func NewKeyring(keys map[string]string, file *File) *Keyring {
+	k := &Keyring{static: make(map[string]string, len(keys)), file: file}
+	for name, key := range keys {
+		k.static[name] = Hash(key)
+	}
+	return k
+}
+
+// Enabled reports whether requests must carry a key: when keys are
+// configured or the key file exists, even once its last key is revoked
+func (k *Keyring) Enabled() bool {
+	if len(k.static) > 0 {
+		return true
+	}
+	if k.file == nil {
+		return false
+	}
+	_, err := os.Stat(k.file.Path())
+	return err == nil
+}
+
+// Match returns the name of the key, or false when it is not a known key
+func (k *Keyring) Match(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	hash := []byte(Hash(key))
+	name, found := "", false
+	// Every key is compared, so the time taken reveals nothing
+	for keyName, keyHash := range k.static {
+		if subtle.ConstantTimeCompare(hash, []byte(keyHash)) == 1 {
+			name, found = keyName, true
+		}
+	}
+	for _, fileKey := range k.loadFile() {
+		if subtle.ConstantTimeCompare(hash, []byte(fileKey.Hash)) == 1 {
+			name, found = fileKey.Name, true
+		}
+	}
+	return name, found
+}
+
+// loadFile returns the keys of the key file, reading it when it changed.
+// A file that cannot be read holds no keys.
+func (k *Keyring) loadFile() []Key {
+	if k.file == nil {
+		return nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	info, err := os.Stat(k.file.Path())
+	if err != nil {
+		k.fileKeys, k.modTime, k.size = nil, time.Time{}, 0
+		return nil
+	}
+	if info.ModTime().Equal(k.modTime) && info.Size() == k.size {
+		return k.fileKeys
+	}
+	keys, err := k.file.List()
+	if err != nil {
+		return nil
+	}
+	k.fileKeys, k.modTime, k.size = keys, info.ModTime(), info.Size()
+	return keys
+}
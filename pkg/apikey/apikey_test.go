@@ -0,0 +1,93 @@
+package apikey_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"instance-manager/pkg/apikey"
+)
+
+func TestFile(t *testing.T) {
+	file := apikey.NewFile(filepath.Join(t.TempDir(), "keys", "api_keys.json"))
+	if keys, err := file.List(); err != nil || len(keys) != 0 {
+		t.Fatalf("Expected a missing file to hold no keys, got %v, %v", keys, err)
+	}
+
+	secret, err := file.Create("ci")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !strings.HasPrefix(secret, "imk_") {
+		t.Errorf("Expected a prefixed key, got %q", secret)
+	}
+	if _, err := file.Create("ci"); err == nil {
+		t.Error("Expected a second key named ci to be refused")
+	}
+	if _, err := file.Create("bad name"); err == nil {
+		t.Error("Expected an invalid name to be refused")
+	}
+	if _, err := file.Create("alice@example.com"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	keys, err := file.List()
+	if err != nil || len(keys) != 2 || keys[0].Name != "alice@example.com" || keys[1].Name != "ci" {
+		t.Fatalf("Expected two keys sorted by name, got %v, %v", keys, err)
+	}
+	data, _ := os.ReadFile(file.Path())
+	if strings.Contains(string(data), secret) || keys[1].Hash != apikey.Hash(secret) {
+		t.Error("Expected the file to hold only the hash of the key")
+	}
+	if info, err := os.Stat(file.Path()); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the file to be readable only by its owner, got %v, %v", info.Mode(), err)
+	}
+
+	if err := file.Revoke("ci"); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if err := file.Revoke("ci"); err == nil {
+		t.Error("Expected revoking a missing key to fail")
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	file := apikey.NewFile(filepath.Join(t.TempDir(), "api_keys.json"))
+	if apikey.NewKeyring(nil, file).Enabled() {
+		t.Error("Expected a keyring without keys or a key file to be disabled")
+	}
+
+	keyring := apikey.NewKeyring(map[string]string{"deploy": "configured-secret"}, file)
+	if !keyring.Enabled() {
+		t.Error("Expected a keyring with configured keys to be enabled")
+	}
+	if name, ok := keyring.Match("configured-secret"); !ok || name != "deploy" {
+		t.Errorf("Expected the configured key to match, got %q, %v", name, ok)
+	}
+	for _, key := range []string{"", "configured", "imk_unknown"} {
+		if _, ok := keyring.Match(key); ok {
+			t.Errorf("Expected %q not to match", key)
+		}
+	}
+
+	// Keys created and revoked in the file take effect at once
+	secret, err := file.Create("ci")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if name, ok := keyring.Match(secret); !ok || name != "ci" {
+		t.Errorf("Expected the created key to match, got %q, %v", name, ok)
+	}
+	if err := file.Revoke("ci"); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, ok := keyring.Match(secret); ok {
+		t.Error("Expected the revoked key not to match")
+	}
+
+	// An emptied key file keeps authentication on
+	if !apikey.NewKeyring(nil, file).Enabled() {
+		t.Error("Expected an existing key file to enable the keyring")
+	}
+}
@@ -0,0 +1,97 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// elasticIPWaitTimeout bounds how long CreateInstance waits for a new
+// instance to be running before associating its Elastic IP
+const elasticIPWaitTimeout = 5 * time.Minute
+
+// allocateElasticIP allocates a VPC Elastic IP tagged as managed, returning
+// its allocation ID and address
+func (p *Provider) allocateElasticIP(ctx context.Context) (allocationID, publicIP string, err error) {
+	result, err := p.ec2Client.AllocateAddress(ctx, &ec2.AllocateAddressInput{
+		Domain: types.DomainTypeVpc,
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeElasticIp,
+				Tags:         toEC2Tags(map[string]string{"Name": defaultName, "ManagedBy": "instance-manager"}),
+			},
+		},
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to allocate Elastic IP: %w", err)
+	}
+	return aws.ToString(result.AllocationId), aws.ToString(result.PublicIp), nil
+}
+
+// associateElasticIP waits for the instance to be running, which EC2
+// requires, and associates the allocation with it
+func (p *Provider) associateElasticIP(ctx context.Context, instanceID, allocationID string) error {
+	waiter := ec2.NewInstanceRunningWaiter(p.ec2Client)
+	if err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instanceID}}, elasticIPWaitTimeout); err != nil {
+		return fmt.Errorf("failed waiting for instance %s to run: %w", instanceID, err)
+	}
+
+	_, err := p.ec2Client.AssociateAddress(ctx, &ec2.AssociateAddressInput{
+		AllocationId: aws.String(allocationID),
+		InstanceId:   aws.String(instanceID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to associate Elastic IP %s: %w", allocationID, err)
+	}
+	return nil
+}
+
+// releaseElasticIPs disassociates and releases the managed Elastic IPs
+// associated with the instance. Addresses attached by hand are left alone.
+func (p *Provider) releaseElasticIPs(ctx context.Context, instanceID string) error {
+	result, err := p.ec2Client.DescribeAddresses(ctx, &ec2.DescribeAddressesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("instance-id"),
+				Values: []string{instanceID},
+			},
+			{
+				Name:   aws.String("tag:ManagedBy"),
+				Values: []string{"instance-manager"},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe Elastic IPs: %w", err)
+	}
+
+	for _, address := range result.Addresses {
+		if address.AssociationId != nil {
+			_, err := p.ec2Client.DisassociateAddress(ctx, &ec2.DisassociateAddressInput{
+				AssociationId: address.AssociationId,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to disassociate Elastic IP %s: %w", aws.ToString(address.PublicIp), err)
+			}
+		}
+		if err := p.releaseElasticIP(ctx, aws.ToString(address.AllocationId)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseElasticIP releases an allocation that is no longer associated
+func (p *Provider) releaseElasticIP(ctx context.Context, allocationID string) error {
+	_, err := p.ec2Client.ReleaseAddress(ctx, &ec2.ReleaseAddressInput{
+		AllocationId: aws.String(allocationID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release Elastic IP %s: %w", allocationID, err)
+	}
+	return nil
+}
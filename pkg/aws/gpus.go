@@ -0,0 +1,48 @@
+package aws
+
+import "strings"
+
+// GPUInfo describes the GPUs attached to an instance type
+type GPUInfo struct {
+	Count int
+	Model string
+}
+
+// gpuModels maps the GPU instance families to the NVIDIA GPU they carry
+var gpuModels = map[string]string{
+	"g4dn": "NVIDIA T4",
+	"g5":   "NVIDIA A10G",
+	"p3":   "NVIDIA V100",
+}
+
+// gpuCounts holds the number of GPUs of every supported GPU instance type
+var gpuCounts = map[string]int{
+	"g4dn.xlarge":   1,
+	"g4dn.2xlarge":  1,
+	"g4dn.4xlarge":  1,
+	"g4dn.8xlarge":  1,
+	"g4dn.12xlarge": 4,
+	"g4dn.16xlarge": 1,
+	"g5.xlarge":     1,
+	"g5.2xlarge":    1,
+	"g5.4xlarge":    1,
+	"g5.8xlarge":    1,
+	"g5.12xlarge":   4,
+	"g5.16xlarge":   1,
+	"g5.24xlarge":   4,
+	"g5.48xlarge":   8,
+	"p3.2xlarge":    1,
+	"p3.8xlarge":    4,
+	"p3.16xlarge":   8,
+}
+
+// InstanceGPUs returns the GPUs of an instance type, and false for types
+// without GPUs
+func InstanceGPUs(instanceType string) (GPUInfo, bool) {
+	count, ok := gpuCounts[instanceType]
+	if !ok {
+		return GPUInfo{}, false
+	}
+	family, _, _ := strings.Cut(instanceType, ".")
+	return GPUInfo{Count: count, Model: gpuModels[family]}, true
+}
@@ -0,0 +1,83 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"instance-manager/pkg/tracing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// hibernationMemoryGiB checks that the instance type supports hibernation and
+// returns its memory in GiB, rounded up, which the root volume must have room
+// for
+func (p *Provider) hibernationMemoryGiB(ctx context.Context, instanceType string) (int32, error) {
+	result, err := p.ec2Client.DescribeInstanceTypes(ctx, &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []types.InstanceType{types.InstanceType(instanceType)},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to describe instance type %s: %w", instanceType, err)
+	}
+	if len(result.InstanceTypes) == 0 {
+		return 0, fmt.Errorf("instance type %s not found in %s", instanceType, p.region)
+	}
+
+	info := result.InstanceTypes[0]
+	if !aws.ToBool(info.HibernationSupported) {
+		return 0, fmt.Errorf("instance type %s does not support hibernation", instanceType)
+	}
+	if info.MemoryInfo == nil {
+		return 0, fmt.Errorf("memory of instance type %s is unknown", instanceType)
+	}
+	memoryMiB := aws.ToInt64(info.MemoryInfo.SizeInMiB)
+	return int32((memoryMiB + 1023) / 1024), nil
+}
+
+// hibernationRootMapping returns the root device mapping of a hibernation
+// enabled launch of the AMI: EC2 only hibernates instances whose root volume
+// is encrypted and has room for the instance's memory besides the image
+func (p *Provider) hibernationRootMapping(ctx context.Context, amiID string, memoryGiB int32) (types.BlockDeviceMapping, error) {
+	image, err := p.describeImage(ctx, amiID)
+	if err != nil {
+		return types.BlockDeviceMapping{}, err
+	}
+
+	rootDevice := aws.ToString(image.RootDeviceName)
+	var imageGiB int32
+	for _, mapping := range image.BlockDeviceMappings {
+		if aws.ToString(mapping.DeviceName) == rootDevice && mapping.Ebs != nil {
+			imageGiB = aws.ToInt32(mapping.Ebs.VolumeSize)
+		}
+	}
+	if rootDevice == "" || imageGiB == 0 {
+		return types.BlockDeviceMapping{}, fmt.Errorf("image %s has no EBS root volume, which hibernation requires", amiID)
+	}
+
+	return types.BlockDeviceMapping{
+		DeviceName: aws.String(rootDevice),
+		Ebs: &types.EbsBlockDevice{
+			VolumeSize:          aws.Int32(imageGiB + memoryGiB),
+			Encrypted:           aws.Bool(true),
+			DeleteOnTermination: aws.Bool(true),
+		},
+	}, nil
+}
+
+// HibernateInstance saves the memory of a hibernation enabled instance to its
+// root volume and stops it. Starting the instance resumes it.
+func (p *Provider) HibernateInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "HibernateInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	_, err = p.ec2Client.StopInstances(ctx, &ec2.StopInstancesInput{
+		InstanceIds: []string{instanceID},
+		Hibernate:   aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to hibernate instance: %w", err)
+	}
+	return nil
+}
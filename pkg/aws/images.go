@@ -0,0 +1,220 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/tracing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// DefaultOS is the operating system launched when none is given
+const DefaultOS = "amazon-linux-2"
+
+// CPU architectures, named as in EC2 image metadata
+const (
+	ArchX86_64 = "x86_64"
+	ArchARM64  = "arm64"
+)
+
+// OSImage describes where the official AMIs of an operating system are
+// published and the user they log in as
+type OSImage struct {
+	Owner     string // Account ID or alias publishing the AMIs
+	Name      string // x86_64 AMI name pattern; the newest match is launched
+	ARM64Name string // arm64 AMI name pattern; empty if there is no arm64 build
+	Username  string
+	GPU       bool // Ships with the NVIDIA driver and CUDA for GPU instance types
+}
+
+// osImages is the catalog of operating systems that can be launched by name
+var osImages = map[string]OSImage{
+	"amazon-linux-2":    {Owner: "amazon", Name: "amzn2-ami-hvm-*-x86_64-gp2", ARM64Name: "amzn2-ami-hvm-*-arm64-gp2", Username: "ec2-user"},
+	"amazon-linux-2023": {Owner: "amazon", Name: "al2023-ami-2023.*-x86_64", ARM64Name: "al2023-ami-2023.*-arm64", Username: "ec2-user"},
+	"ubuntu-22.04":      {Owner: "099720109477", Name: "ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-*", ARM64Name: "ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-arm64-server-*", Username: "ubuntu"},
+	"ubuntu-24.04":      {Owner: "099720109477", Name: "ubuntu/images/hvm-ssd-gp3/ubuntu-noble-24.04-amd64-server-*", ARM64Name: "ubuntu/images/hvm-ssd-gp3/ubuntu-noble-24.04-arm64-server-*", Username: "ubuntu"},
+	"debian-11":         {Owner: "136693071363", Name: "debian-11-amd64-*", ARM64Name: "debian-11-arm64-*", Username: "admin"},
+	"debian-12":         {Owner: "136693071363", Name: "debian-12-amd64-*", ARM64Name: "debian-12-arm64-*", Username: "admin"},
+	"rhel-9":            {Owner: "309956199498", Name: "RHEL-9.*_HVM-*-x86_64-*", ARM64Name: "RHEL-9.*_HVM-*-arm64-*", Username: "ec2-user"},
+
+	// AWS Deep Learning Base AMIs, with the GPU driver preinstalled
+	"dlami-amazon-linux-2023": {Owner: "amazon", Name: "Deep Learning Base OSS Nvidia Driver GPU AMI (Amazon Linux 2023) *", Username: "ec2-user", GPU: true},
+	"dlami-ubuntu-22.04":      {Owner: "amazon", Name: "Deep Learning Base OSS Nvidia Driver GPU AMI (Ubuntu 22.04) *", ARM64Name: "Deep Learning ARM64 Base OSS Nvidia Driver GPU AMI (Ubuntu 22.04) *", Username: "ubuntu", GPU: true},
+}
+
+// InstanceArchitecture returns the CPU architecture of an EC2 instance type.
+// Graviton families carry a "g" right after the generation number (t4g,
+// m7g, c6gn); a1 is the first-generation Graviton.
+func InstanceArchitecture(instanceType string) string {
+	family, _, _ := strings.Cut(instanceType, ".")
+	if family == "a1" {
+		return ArchARM64
+	}
+	i := strings.IndexAny(family, "0123456789")
+	if i < 0 {
+		return ArchX86_64
+	}
+	rest := strings.TrimLeft(family[i:], "0123456789")
+	if strings.HasPrefix(rest, "g") {
+		return ArchARM64
+	}
+	return ArchX86_64
+}
+
+// OSNames returns the names of the operating systems in the catalog, sorted
+func OSNames() []string {
+	names := make([]string, 0, len(osImages))
+	for name := range osImages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupOS returns the catalog entry of the named operating system. An empty
+// name returns DefaultOS.
+func LookupOS(name string) (OSImage, error) {
+	if name == "" {
+		name = DefaultOS
+	}
+	image, ok := osImages[name]
+	if !ok {
+		return OSImage{}, fmt.Errorf("unknown OS %q (supported: %s)", name, strings.Join(OSNames(), ", "))
+	}
+	return image, nil
+}
+
+// usernameForOS returns the login user of the named operating system,
+// ec2-user for instances launched before the OS was recorded
+func usernameForOS(name string) string {
+	if image, ok := osImages[name]; ok {
+		return image.Username
+	}
+	return osImages[DefaultOS].Username
+}
+
+// latestAMI returns the newest available AMI of image for the architecture
+// in the provider's region
+func (p *Provider) latestAMI(ctx context.Context, image OSImage, arch string) (string, error) {
+	name := image.Name
+	if arch == ArchARM64 {
+		name = image.ARM64Name
+	}
+	if name == "" {
+		return "", fmt.Errorf("no %s images are published", arch)
+	}
+
+	result, err := p.ec2Client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		Owners: []string{image.Owner},
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("name"),
+				Values: []string{name},
+			},
+			{
+				Name:   aws.String("architecture"),
+				Values: []string{arch},
+			},
+			{
+				Name:   aws.String("state"),
+				Values: []string{"available"},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(result.Images) == 0 {
+		return "", errors.New("no matching AMI found")
+	}
+
+	// CreationDate is an ISO 8601 timestamp, so the latest sorts last
+	latest := result.Images[0]
+	for _, candidate := range result.Images[1:] {
+		if aws.ToString(candidate.CreationDate) > aws.ToString(latest.CreationDate) {
+			latest = candidate
+		}
+	}
+
+	return aws.ToString(latest.ImageId), nil
+}
+
+// describeImage returns the AMI with the given ID
+func (p *Provider) describeImage(ctx context.Context, imageID string) (types.Image, error) {
+	result, err := p.ec2Client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{imageID},
+	})
+	if err != nil {
+		return types.Image{}, fmt.Errorf("failed to describe image %s: %w", imageID, err)
+	}
+	if len(result.Images) == 0 {
+		return types.Image{}, fmt.Errorf("image %s not found in %s", imageID, p.region)
+	}
+	return result.Images[0], nil
+}
+
+// CreateImage starts creating an AMI of the instance and all of its EBS
+// volumes, without rebooting it, and returns once EC2 has accepted the
+// request. An empty name is generated from the instance ID and the time. The
+// AMI and its snapshots are tagged with the instance and its OS, so instances
+// launched from the image get the right login user.
+func (p *Provider) CreateImage(ctx context.Context, instanceID, name string) (record *models.ImageRecord, err error) {
+	ctx, span := p.startSpan(ctx, "CreateImage", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	result, err := p.ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe instance: %w", err)
+	}
+	if len(result.Reservations) == 0 || len(result.Reservations[0].Instances) == 0 {
+		return nil, errors.New("instance not found")
+	}
+	osName := tagValue(result.Reservations[0].Instances[0].Tags, "OS")
+
+	createdAt := time.Now()
+	if name == "" {
+		name = fmt.Sprintf("%s-%s-%s", defaultName, instanceID, createdAt.UTC().Format("20060102-150405"))
+	}
+	tags := map[string]string{
+		"Name":       name,
+		"ManagedBy":  "instance-manager",
+		"InstanceId": instanceID,
+	}
+	if osName != "" {
+		tags["OS"] = osName
+	}
+
+	output, err := p.ec2Client.CreateImage(ctx, &ec2.CreateImageInput{
+		InstanceId:  aws.String(instanceID),
+		Name:        aws.String(name),
+		Description: aws.String(fmt.Sprintf("Image of %s created by instance-manager", instanceID)),
+		NoReboot:    aws.Bool(true),
+		TagSpecifications: []types.TagSpecification{
+			{ResourceType: types.ResourceTypeImage, Tags: toEC2Tags(tags)},
+			{ResourceType: types.ResourceTypeSnapshot, Tags: toEC2Tags(tags)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create image of %s: %w", instanceID, err)
+	}
+
+	return &models.ImageRecord{
+		ImageID:    aws.ToString(output.ImageId),
+		Name:       name,
+		InstanceID: instanceID,
+		OS:         osName,
+		Region:     p.region,
+		CreatedAt:  createdAt,
+	}, nil
+}
@@ -0,0 +1,154 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/tracing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// metricsPeriod is the period of EC2 basic monitoring datapoints
+const metricsPeriod = 5 * time.Minute
+
+// metricsDelay is how far behind CloudWatch may publish datapoints; queries
+// look back this much further so a full window is still found
+const metricsDelay = 15 * time.Minute
+
+// CloudWatchAPI is the subset of the CloudWatch client used by the provider
+type CloudWatchAPI interface {
+	GetMetricData(ctx context.Context, input *cloudwatch.GetMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error)
+}
+
+// MetricsClientFactory creates a CloudWatch client for a region
+type MetricsClientFactory func(region string) CloudWatchAPI
+
+// SetMetricsClientFactory sets how the provider, and those it creates for
+// other regions, create their CloudWatch clients. Without a factory
+// Utilization returns an error.
+func (p *Provider) SetMetricsClientFactory(factory MetricsClientFactory) {
+	p.regions.mu.Lock()
+	defer p.regions.mu.Unlock()
+	p.regions.metricsFactory = factory
+}
+
+// metricsClient returns the provider's CloudWatch client, creating it on
+// first use
+func (p *Provider) metricsClient() (CloudWatchAPI, error) {
+	p.regions.mu.Lock()
+	defer p.regions.mu.Unlock()
+
+	if p.cloudwatchClient == nil {
+		if p.regions.metricsFactory == nil {
+			return nil, fmt.Errorf("CloudWatch metrics are not available in %s", p.region)
+		}
+		p.cloudwatchClient = p.regions.metricsFactory(p.region)
+	}
+	return p.cloudwatchClient, nil
+}
+
+// instanceMetrics are the CloudWatch metrics read for an instance's
+// utilization, keyed by query ID
+var instanceMetrics = []struct {
+	id   string
+	name string
+	stat string
+}{
+	{id: "cpu", name: "CPUUtilization", stat: "Average"},
+	{id: "network_in", name: "NetworkIn", stat: "Sum"},
+	{id: "network_out", name: "NetworkOut", stat: "Sum"},
+}
+
+// Utilization returns the peak CPU utilization and network traffic of the
+// instance over the most recent window of CloudWatch datapoints
+func (p *Provider) Utilization(ctx context.Context, instanceID string, window time.Duration) (usage *models.Utilization, err error) {
+	ctx, span := p.startSpan(ctx, "Utilization", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	client, err := p.metricsClient()
+	if err != nil {
+		return nil, err
+	}
+
+	queries := make([]cwtypes.MetricDataQuery, 0, len(instanceMetrics))
+	for _, metric := range instanceMetrics {
+		queries = append(queries, cwtypes.MetricDataQuery{
+			Id: aws.String(metric.id),
+			MetricStat: &cwtypes.MetricStat{
+				Metric: &cwtypes.Metric{
+					Namespace:  aws.String("AWS/EC2"),
+					MetricName: aws.String(metric.name),
+					Dimensions: []cwtypes.Dimension{{Name: aws.String("InstanceId"), Value: aws.String(instanceID)}},
+				},
+				Period: aws.Int32(int32(metricsPeriod / time.Second)),
+				Stat:   aws.String(metric.stat),
+			},
+		})
+	}
+
+	end := time.Now()
+	input := &cloudwatch.GetMetricDataInput{
+		MetricDataQueries: queries,
+		StartTime:         aws.Time(end.Add(-window - metricsDelay)),
+		EndTime:           aws.Time(end),
+	}
+	samples := make(map[string]map[time.Time]float64, len(instanceMetrics))
+	for {
+		result, err := client.GetMetricData(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get metrics of instance %s: %w", instanceID, err)
+		}
+		for _, series := range result.MetricDataResults {
+			id := aws.ToString(series.Id)
+			if samples[id] == nil {
+				samples[id] = make(map[time.Time]float64)
+			}
+			for i, timestamp := range series.Timestamps {
+				if i < len(series.Values) {
+					samples[id][timestamp] = series.Values[i]
+				}
+			}
+		}
+		if result.NextToken == nil {
+			break
+		}
+		input.NextToken = result.NextToken
+	}
+
+	return utilizationFromSamples(samples, window), nil
+}
+
+// utilizationFromSamples computes the peak usage over the window ending with
+// the latest CPU datapoint. Each datapoint covers the period after its
+// timestamp.
+func utilizationFromSamples(samples map[string]map[time.Time]float64, window time.Duration) *models.Utilization {
+	usage := &models.Utilization{}
+
+	var latest time.Time
+	for timestamp := range samples["cpu"] {
+		if timestamp.After(latest) {
+			latest = timestamp
+		}
+	}
+	if latest.IsZero() {
+		return usage
+	}
+
+	windowStart := latest.Add(metricsPeriod - window)
+	for timestamp, cpu := range samples["cpu"] {
+		if timestamp.Before(windowStart) {
+			continue
+		}
+		usage.Covered += metricsPeriod
+		usage.CPUPercent = max(usage.CPUPercent, cpu)
+
+		bytes := samples["network_in"][timestamp] + samples["network_out"][timestamp]
+		usage.NetworkBytesPerSecond = max(usage.NetworkBytesPerSecond, bytes/metricsPeriod.Seconds())
+	}
+	return usage
+}
@@ -1,152 +1,627 @@
 package aws
 
 import (
+	"context"
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"instance-manager/pkg/cloud"
 	"instance-manager/pkg/models"
+	"instance-manager/pkg/tracing"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// DefaultRoleSessionName identifies sessions of assumed roles when no session
+// name is configured
+const DefaultRoleSessionName = "instance-manager"
+
+// Default bounds for waiting on EBS snapshots
+const (
+	defaultSnapshotPollInterval = 10 * time.Second
+	defaultSnapshotTimeout      = 10 * time.Minute
+)
+
+// EC2API is the subset of the EC2 client used by the provider
+type EC2API interface {
+	DescribeRegions(ctx context.Context, input *ec2.DescribeRegionsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRegionsOutput, error)
+	RunInstances(ctx context.Context, input *ec2.RunInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error)
+	DescribeInstances(ctx context.Context, input *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+	StartInstances(ctx context.Context, input *ec2.StartInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StartInstancesOutput, error)
+	StopInstances(ctx context.Context, input *ec2.StopInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StopInstancesOutput, error)
+	TerminateInstances(ctx context.Context, input *ec2.TerminateInstancesInput, optFns ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error)
+	CreateSnapshot(ctx context.Context, input *ec2.CreateSnapshotInput, optFns ...func(*ec2.Options)) (*ec2.CreateSnapshotOutput, error)
+	DescribeSnapshots(ctx context.Context, input *ec2.DescribeSnapshotsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSnapshotsOutput, error)
+	DeleteSnapshot(ctx context.Context, input *ec2.DeleteSnapshotInput, optFns ...func(*ec2.Options)) (*ec2.DeleteSnapshotOutput, error)
+	DescribeTags(ctx context.Context, input *ec2.DescribeTagsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTagsOutput, error)
+	CreateTags(ctx context.Context, input *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error)
+	DescribeKeyPairs(ctx context.Context, input *ec2.DescribeKeyPairsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeKeyPairsOutput, error)
+	ImportKeyPair(ctx context.Context, input *ec2.ImportKeyPairInput, optFns ...func(*ec2.Options)) (*ec2.ImportKeyPairOutput, error)
+	DescribeSubnets(ctx context.Context, input *ec2.DescribeSubnetsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error)
+	DescribeVpcs(ctx context.Context, input *ec2.DescribeVpcsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVpcsOutput, error)
+	DescribeSecurityGroups(ctx context.Context, input *ec2.DescribeSecurityGroupsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error)
+	CreateSecurityGroup(ctx context.Context, input *ec2.CreateSecurityGroupInput, optFns ...func(*ec2.Options)) (*ec2.CreateSecurityGroupOutput, error)
+	AuthorizeSecurityGroupIngress(ctx context.Context, input *ec2.AuthorizeSecurityGroupIngressInput, optFns ...func(*ec2.Options)) (*ec2.AuthorizeSecurityGroupIngressOutput, error)
+	DescribeImages(ctx context.Context, input *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
+	CreateImage(ctx context.Context, input *ec2.CreateImageInput, optFns ...func(*ec2.Options)) (*ec2.CreateImageOutput, error)
+	DescribeInstanceTypes(ctx context.Context, input *ec2.DescribeInstanceTypesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error)
+	CancelSpotInstanceRequests(ctx context.Context, input *ec2.CancelSpotInstanceRequestsInput, optFns ...func(*ec2.Options)) (*ec2.CancelSpotInstanceRequestsOutput, error)
+	AllocateAddress(ctx context.Context, input *ec2.AllocateAddressInput, optFns ...func(*ec2.Options)) (*ec2.AllocateAddressOutput, error)
+	AssociateAddress(ctx context.Context, input *ec2.AssociateAddressInput, optFns ...func(*ec2.Options)) (*ec2.AssociateAddressOutput, error)
+	DescribeAddresses(ctx context.Context, input *ec2.DescribeAddressesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeAddressesOutput, error)
+	DisassociateAddress(ctx context.Context, input *ec2.DisassociateAddressInput, optFns ...func(*ec2.Options)) (*ec2.DisassociateAddressOutput, error)
+	ReleaseAddress(ctx context.Context, input *ec2.ReleaseAddressInput, optFns ...func(*ec2.Options)) (*ec2.ReleaseAddressOutput, error)
+}
+
+// ClientFactory creates an EC2 client for a region
+type ClientFactory func(region string) EC2API
+
 // Provider implements the CloudProvider interface for AWS
 type Provider struct {
-	ec2Client *ec2.EC2
-	region    string
+	ec2Client            EC2API
+	region               string
+	snapshotPollInterval time.Duration
+	snapshotTimeout      time.Duration
+	regions              *regionCache  // Shared by the providers of every region
+	cloudwatchClient     CloudWatchAPI // Created on first use by metricsClient
+}
+
+// regionCache holds the providers created for other regions, so each region's
+// client is built once and reused
+type regionCache struct {
+	mu             sync.Mutex
+	factory        ClientFactory
+	metricsFactory MetricsClientFactory
+	providers      map[string]*Provider
 }
 
-// NewProvider creates a new AWS provider instance
+// Options configure how NewProviderWithOptions authenticates
+type Options struct {
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Profile selects a shared config profile (~/.aws/config) when no access
+	// key pair is given
+	Profile string
+	// RoleARN is an IAM role assumed with the credentials above. The role's
+	// temporary credentials are refreshed before they expire.
+	RoleARN string
+	// ExternalID is passed when assuming RoleARN, if the role requires one
+	ExternalID string
+	// RoleSessionName names the assumed role session (DefaultRoleSessionName when empty)
+	RoleSessionName string
+	// Endpoint overrides the AWS API endpoint, e.g. http://localhost:4566 to
+	// run against LocalStack or moto instead of AWS
+	Endpoint string
+}
+
+// NewProvider creates a new AWS provider instance. The access key pair is
+// used when given; otherwise credentials come from the default chain
+// (environment, shared config and credentials files, SSO, instance role).
 func NewProvider(region, accessKey, secretKey string) (cloud.CloudProvider, error) {
-	if region == "" {
+	return NewProviderWithOptions(Options{Region: region, AccessKey: accessKey, SecretKey: secretKey})
+}
+
+// NewProviderWithOptions creates an AWS provider from opts. Credentials come
+// from the access key pair, then the profile, then the default chain, and are
+// used to assume opts.RoleARN when it is set.
+func NewProviderWithOptions(opts Options) (cloud.CloudProvider, error) {
+	if opts.Region == "" {
 		return nil, errors.New("region is required")
 	}
-	if accessKey == "" {
-		return nil, errors.New("AWS_ACCESS_KEY_ID environment variable is required")
+
+	loadOpts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(opts.Region)}
+	switch {
+	case opts.AccessKey != "" && opts.SecretKey != "":
+		loadOpts = append(loadOpts, awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(opts.AccessKey, opts.SecretKey, "")))
+	case opts.AccessKey != "":
+		return nil, errors.New("AWS_SECRET_ACCESS_KEY environment variable is required with AWS_ACCESS_KEY_ID")
+	case opts.SecretKey != "":
+		return nil, errors.New("AWS_ACCESS_KEY_ID environment variable is required with AWS_SECRET_ACCESS_KEY")
+	case opts.Profile != "":
+		loadOpts = append(loadOpts, awsconfig.WithSharedConfigProfile(opts.Profile))
 	}
-	if secretKey == "" {
-		return nil, errors.New("AWS_SECRET_ACCESS_KEY environment variable is required")
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	if opts.Endpoint != "" {
+		cfg.BaseEndpoint = aws.String(opts.Endpoint)
+	}
+	// Callers retry throttled and transient failures under their
+	// cloud.RetryPolicy; a second layer of SDK retries would multiply the
+	// attempts and stack two backoff schedules
+	cfg.Retryer = func() aws.Retryer { return aws.NopRetryer{} }
+	if cfg.Credentials != nil {
+		profile := opts.Profile
+		if profile == "" {
+			profile = os.Getenv("AWS_PROFILE")
+		}
+		cfg.Credentials = ssoLoginHint{CredentialsProvider: cfg.Credentials, profile: profile}
+	}
+
+	if opts.RoleARN != "" {
+		sessionName := opts.RoleSessionName
+		if sessionName == "" {
+			sessionName = DefaultRoleSessionName
+		}
+		assumeRole := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), opts.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = sessionName
+			if opts.ExternalID != "" {
+				o.ExternalID = aws.String(opts.ExternalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(assumeRole)
 	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(region),
-		Credentials: credentials.NewStaticCredentials(accessKey, secretKey, ""),
+	provider := NewProviderWithClientFactory(func(region string) EC2API {
+		return ec2.NewFromConfig(cfg, func(o *ec2.Options) {
+			o.Region = region
+		})
+	}, opts.Region)
+	provider.SetMetricsClientFactory(func(region string) CloudWatchAPI {
+		return cloudwatch.NewFromConfig(cfg, func(o *cloudwatch.Options) {
+			o.Region = region
+		})
 	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	return provider, nil
+}
+
+// ssoLoginHint tells the user how to sign in again when credentials cannot be
+// retrieved because the profile's SSO session has expired. While the session
+// is valid the SDK refreshes the role credentials and SSO token on its own.
+type ssoLoginHint struct {
+	aws.CredentialsProvider
+	profile string
+}
+
+func (h ssoLoginHint) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := h.CredentialsProvider.Retrieve(ctx)
+	if err != nil && isSSOSessionError(err) {
+		command := "aws sso login"
+		if h.profile != "" {
+			command += " --profile " + h.profile
+		}
+		return creds, fmt.Errorf("%w; run %q to sign in again", err, command)
 	}
+	return creds, err
+}
 
+// isSSOSessionError reports whether err comes from a missing or expired SSO
+// session. Profiles using an sso-session report token refresh failures as
+// plain errors, so those are matched by message.
+func isSSOSessionError(err error) bool {
+	var invalidToken *ssocreds.InvalidTokenError
+	return errors.As(err, &invalidToken) || strings.Contains(err.Error(), "SSO token")
+}
+
+// NewProviderWithClient creates an AWS provider backed by the given EC2 client.
+// The provider is bound to region and cannot switch to other regions.
+func NewProviderWithClient(client EC2API, region string) *Provider {
 	return &Provider{
-		ec2Client: ec2.New(sess),
-		region:    region,
-	}, nil
+		ec2Client:            client,
+		region:               region,
+		snapshotPollInterval: defaultSnapshotPollInterval,
+		snapshotTimeout:      defaultSnapshotTimeout,
+		regions:              &regionCache{providers: make(map[string]*Provider)},
+	}
+}
+
+// NewProviderWithClientFactory creates an AWS provider for region whose EC2
+// clients, including those for other regions, are created by factory
+func NewProviderWithClientFactory(factory ClientFactory, region string) *Provider {
+	p := NewProviderWithClient(factory(region), region)
+	p.regions.factory = factory
+	p.regions.providers[region] = p
+	return p
+}
+
+// Region returns the AWS region the provider operates in
+func (p *Provider) Region() string {
+	return p.region
+}
+
+// SetSnapshotWait sets how often SnapshotRootVolume polls a pending snapshot
+// and how long it waits for it to complete
+func (p *Provider) SetSnapshotWait(pollInterval, timeout time.Duration) {
+	p.snapshotPollInterval = pollInterval
+	p.snapshotTimeout = timeout
+}
+
+// InRegion returns a provider for region that shares this provider's
+// credentials. The region's EC2 client is created on first use.
+func (p *Provider) InRegion(region string) (cloud.CloudProvider, error) {
+	return p.ForRegion(region)
+}
+
+// ForRegion is InRegion returning the concrete provider, for callers that
+// need AWS-specific operations
+func (p *Provider) ForRegion(region string) (*Provider, error) {
+	if region == "" || region == p.region {
+		return p, nil
+	}
+
+	p.regions.mu.Lock()
+	defer p.regions.mu.Unlock()
+
+	if provider, ok := p.regions.providers[region]; ok {
+		return provider, nil
+	}
+	if p.regions.factory == nil {
+		return nil, fmt.Errorf("provider for %s cannot operate in region %s", p.region, region)
+	}
+
+	provider := NewProviderWithClient(p.regions.factory(region), region)
+	provider.snapshotPollInterval = p.snapshotPollInterval
+	provider.snapshotTimeout = p.snapshotTimeout
+	provider.regions = p.regions
+	p.regions.providers[region] = provider
+	return provider, nil
 }
 
 // ValidateCredentials checks if AWS credentials are valid
-func (p *Provider) ValidateCredentials() error {
-	_, err := p.ec2Client.DescribeRegions(&ec2.DescribeRegionsInput{})
+func (p *Provider) ValidateCredentials(ctx context.Context) error {
+	_, err := p.ec2Client.DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
 	if err != nil {
 		return fmt.Errorf("invalid AWS credentials: %w", err)
 	}
 	return nil
 }
 
+// ServerTime returns the current time as reported by the Date header of an AWS API response
+func (p *Provider) ServerTime() (time.Time, error) {
+	result, err := p.ec2Client.DescribeRegions(context.Background(), &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to query AWS time: %w", err)
+	}
+	response, ok := awsmiddleware.GetRawResponse(result.ResultMetadata).(*smithyhttp.Response)
+	if !ok || response == nil {
+		return time.Time{}, errors.New("no HTTP response received from AWS")
+	}
+
+	date := response.Header.Get("Date")
+	if date == "" {
+		return time.Time{}, errors.New("AWS response has no Date header")
+	}
+
+	serverTime, err := http.ParseTime(date)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse AWS Date header: %w", err)
+	}
+	return serverTime, nil
+}
+
 // CreateInstance creates a new EC2 instance
-func (p *Provider) CreateInstance(config models.InstanceConfig) (*models.Instance, error) {
-	// Read and import the public key
-	keyName, err := p.importKeyPair(config.PublicKeyPath)
+func (p *Provider) CreateInstance(ctx context.Context, config models.InstanceConfig) (instance *models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "CreateInstance", "")
+	defer func() {
+		if instance != nil {
+			span.SetAttributes(tracing.AttrInstanceID.String(instance.ID))
+		}
+		tracing.EndSpan(span, err)
+	}()
+
+	// A source image records the OS it was created from, which picks the
+	// login user unless an OS is given
+	arch := InstanceArchitecture(config.InstanceType)
+	osName := config.OS
+	if config.ImageID != "" {
+		source, err := p.describeImage(ctx, config.ImageID)
+		if err != nil {
+			return nil, err
+		}
+		if imageArch := string(source.Architecture); imageArch != "" && imageArch != arch {
+			return nil, fmt.Errorf("image %s is built for %s but %s is %s", config.ImageID, imageArch, config.InstanceType, arch)
+		}
+		if _, ok := osImages[tagValue(source.Tags, "OS")]; ok && osName == "" {
+			osName = tagValue(source.Tags, "OS")
+		}
+	}
+	if osName == "" {
+		osName = DefaultOS
+	}
+	image, err := LookupOS(osName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to import key pair: %w", err)
+		return nil, err
+	}
+	hibernate := config.ExpiryAction == models.ExpiryActionHibernate
+	var memoryGiB int32
+	if hibernate {
+		memoryGiB, err = p.hibernationMemoryGiB(ctx, config.InstanceType)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, volume := range config.ExtraVolumes {
+		if !isVolumeType(volume.Type) {
+			return nil, fmt.Errorf("unsupported volume type %q for %s", volume.Type, volume.Device)
+		}
+	}
+	if err := checkUserTags(config.Tags); err != nil {
+		return nil, err
 	}
 
-	// Get the default VPC and subnet
-	subnetID, err := p.getDefaultSubnet(config.AvailabilityZone)
+	// Use the named key pair if given, otherwise read and import the public key
+	keyName := config.KeyName
+	if keyName != "" {
+		if err := p.validateKeyPair(ctx, keyName); err != nil {
+			return nil, err
+		}
+	} else {
+		keyName, err = p.importKeyPair(ctx, config.PublicKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import key pair: %w", err)
+		}
+	}
+
+	// Use the requested VPC and subnet, or fall back to the default subnet
+	subnet, err := p.resolveSubnet(ctx, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get default subnet: %w", err)
+		return nil, fmt.Errorf("failed to get subnet: %w", err)
+	}
+	subnetID := aws.ToString(subnet.SubnetId)
+	if config.VPCID != "" || config.SubnetID != "" {
+		// The security group must live in the same VPC as the subnet
+		config.VPCID = aws.ToString(subnet.VpcId)
+	}
+	if subnet.AvailabilityZone != nil {
+		config.AvailabilityZone = aws.ToString(subnet.AvailabilityZone)
 	}
 
-	// Create security group if it doesn't exist
-	securityGroupID, err := p.createOrGetSecurityGroup()
+	// Resolve the security group, creating the managed one if it doesn't exist
+	plan, err := p.previewSecurityGroup(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve security group: %w", err)
+	}
+	securityGroupID, err := p.createOrGetSecurityGroup(ctx, plan)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create security group: %w", err)
 	}
 
-	// Get the latest Amazon Linux 2 AMI
-	amiID, err := p.getLatestAmazonLinuxAMI()
-	if err != nil {
-		// Fallback to a known working AMI ID based on region
-		amiID = p.getAMIID()
+	// Launch the requested image, or the latest AMI of the requested OS for
+	// the instance type's architecture
+	amiID := config.ImageID
+	if amiID == "" {
+		amiID, err = p.latestAMI(ctx, image, arch)
+		if err != nil {
+			if osName != DefaultOS || arch != ArchX86_64 {
+				return nil, fmt.Errorf("failed to find an %s AMI for %s: %w", arch, osName, err)
+			}
+			// Fallback to a known working AMI ID based on region
+			amiID = p.getAMIID()
+		}
 	}
 
+	launchTime := time.Now()
+	expiresAt := launchTime.Add(config.Duration)
+
 	// Launch the instance
-	runResult, err := p.ec2Client.RunInstances(&ec2.RunInstancesInput{
+	input := &ec2.RunInstancesInput{
 		ImageId:      aws.String(amiID),
-		InstanceType: aws.String(config.InstanceType),
-		MinCount:     aws.Int64(1),
-		MaxCount:     aws.Int64(1),
+		InstanceType: types.InstanceType(config.InstanceType),
+		MinCount:     aws.Int32(1),
+		MaxCount:     aws.Int32(1),
 		KeyName:      aws.String(keyName),
-		NetworkInterfaces: []*ec2.InstanceNetworkInterfaceSpecification{
+		NetworkInterfaces: []types.InstanceNetworkInterfaceSpecification{
 			{
-				DeviceIndex:              aws.Int64(0),
+				DeviceIndex:              aws.Int32(0),
 				SubnetId:                 aws.String(subnetID),
-				Groups:                   []*string{aws.String(securityGroupID)},
+				Groups:                   append([]string{securityGroupID}, plan.AttachedGroupIDs...),
 				AssociatePublicIpAddress: aws.Bool(true), // This ensures public IP assignment
 			},
 		},
-		TagSpecifications: []*ec2.TagSpecification{
+		TagSpecifications: []types.TagSpecification{
 			{
-				ResourceType: aws.String("instance"),
-				Tags: []*ec2.Tag{
-					{
-						Key:   aws.String("Name"),
-						Value: aws.String("instance-manager"),
-					},
-					{
-						Key:   aws.String("ManagedBy"),
-						Value: aws.String("instance-manager"),
-					},
-					{
-						Key:   aws.String("Duration"),
-						Value: aws.String(config.Duration.String()),
-					},
-				},
+				ResourceType: types.ResourceTypeInstance,
+				Tags:         toEC2Tags(instanceTags(managedTags(config.Name, config.Duration, expiresAt, config.Session, osName), config.Tags)),
 			},
 		},
-	})
+	}
+	if config.Spot {
+		input.InstanceMarketOptions = spotMarketOptions(config.SpotMaxPrice)
+	}
+	input.BlockDeviceMappings = volumeMappings(config.ExtraVolumes)
+	input.MetadataOptions = metadataOptions(config.Metadata)
+	if hibernate {
+		rootMapping, err := p.hibernationRootMapping(ctx, amiID, memoryGiB)
+		if err != nil {
+			return nil, err
+		}
+		input.BlockDeviceMappings = append([]types.BlockDeviceMapping{rootMapping}, input.BlockDeviceMappings...)
+		input.HibernationOptions = &types.HibernationOptionsRequest{Configured: aws.Bool(true)}
+	}
+
+	// Allocate the Elastic IP up front so a quota error fails before launching
+	var allocationID, elasticIP string
+	if config.ElasticIP {
+		allocationID, elasticIP, err = p.allocateElasticIP(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	runResult, err := p.ec2Client.RunInstances(ctx, input)
+	if err == nil && len(runResult.Instances) == 0 {
+		err = errors.New("no instance returned")
+	}
 	if err != nil {
+		if allocationID != "" {
+			if releaseErr := p.releaseElasticIP(ctx, allocationID); releaseErr != nil {
+				fmt.Printf("Warning: %v\n", releaseErr)
+			}
+		}
 		return nil, fmt.Errorf("failed to launch instance: %w", err)
 	}
+	instanceID := aws.ToString(runResult.Instances[0].InstanceId)
 
-	instanceID := *runResult.Instances[0].InstanceId
-	launchTime := time.Now()
-	expiresAt := launchTime.Add(config.Duration)
+	// The instance is up either way, so an association failure releases the
+	// address and leaves the instance on its ephemeral public IP
+	if allocationID != "" {
+		if err := p.associateElasticIP(ctx, instanceID, allocationID); err != nil {
+			fmt.Printf("Warning: %v; the instance keeps its ephemeral public IP\n", err)
+			if releaseErr := p.releaseElasticIP(ctx, allocationID); releaseErr != nil {
+				fmt.Printf("Warning: %v\n", releaseErr)
+			}
+			allocationID, elasticIP = "", ""
+		}
+	}
+
+	instance = &models.Instance{
+		ID:                    instanceID,
+		Name:                  config.Name,
+		InstanceType:          config.InstanceType,
+		State:                 "pending",
+		LaunchTime:            launchTime,
+		Duration:              config.Duration,
+		AvailabilityZone:      config.AvailabilityZone,
+		Region:                p.region,
+		VPCID:                 aws.ToString(subnet.VpcId),
+		SubnetID:              subnetID,
+		KeyName:               keyName,
+		OS:                    osName,
+		Username:              image.Username,
+		ExpiresAt:             expiresAt,
+		RestartPolicy:         config.RestartPolicy,
+		SnapshotOnExpiry:      config.SnapshotOnExpiry,
+		ImageOnExpiry:         config.ImageOnExpiry,
+		ExpiryAction:          config.ExpiryAction,
+		Session:               config.Session,
+		SpotRequestID:         aws.ToString(runResult.Instances[0].SpotInstanceRequestId),
+		VolumeIDs:             dataVolumeIDs(runResult.Instances[0]),
+		PublicIP:              elasticIP,
+		ElasticIPAllocationID: allocationID,
+		Tags:                  config.Tags,
+	}
 
-	instance := &models.Instance{
-		ID:               instanceID,
-		InstanceType:     config.InstanceType,
-		State:            "pending",
-		LaunchTime:       launchTime,
-		Duration:         config.Duration,
-		AvailabilityZone: config.AvailabilityZone,
-		KeyName:          keyName,
-		Username:         "ec2-user", // Default username for Amazon Linux
-		ExpiresAt:        expiresAt,
+	if gpus, ok := InstanceGPUs(config.InstanceType); ok {
+		instance.GPUCount, instance.GPUModel = gpus.Count, gpus.Model
 	}
 
 	return instance, nil
 }
 
+// metadataOptions returns the instance metadata settings of a launch. IMDSv2
+// is required unless optional tokens are asked for, since IMDSv1 lets any
+// request forgery on the instance read its role credentials.
+func metadataOptions(opts models.MetadataOptions) *types.InstanceMetadataOptionsRequest {
+	tokens := opts.HTTPTokens
+	if tokens == "" {
+		tokens = models.MetadataTokensRequired
+	}
+	request := &types.InstanceMetadataOptionsRequest{
+		HttpTokens: types.HttpTokensState(tokens),
+	}
+	if opts.HopLimit > 0 {
+		request.HttpPutResponseHopLimit = aws.Int32(opts.HopLimit)
+	}
+	if opts.Endpoint != "" {
+		request.HttpEndpoint = types.InstanceMetadataEndpointState(opts.Endpoint)
+	}
+	return request
+}
+
+// volumeMappings returns the block device mappings creating the extra
+// volumes. The volumes are deleted with the instance, however it is
+// terminated.
+func volumeMappings(volumes []models.VolumeSpec) []types.BlockDeviceMapping {
+	var mappings []types.BlockDeviceMapping
+	for _, volume := range volumes {
+		mappings = append(mappings, types.BlockDeviceMapping{
+			DeviceName: aws.String(volume.Device),
+			Ebs: &types.EbsBlockDevice{
+				VolumeSize:          aws.Int32(volume.SizeGiB),
+				VolumeType:          types.VolumeType(volume.Type),
+				DeleteOnTermination: aws.Bool(true),
+			},
+		})
+	}
+	return mappings
+}
+
+// isVolumeType reports whether name is an EBS volume type
+func isVolumeType(name string) bool {
+	for _, volumeType := range types.VolumeType("").Values() {
+		if string(volumeType) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// dataVolumeIDs returns the IDs of the EBS volumes attached to the instance
+// besides its root volume. EC2 may only report them once the instance runs.
+func dataVolumeIDs(instance types.Instance) []string {
+	var ids []string
+	for _, mapping := range instance.BlockDeviceMappings {
+		if mapping.Ebs == nil || aws.ToString(mapping.DeviceName) == aws.ToString(instance.RootDeviceName) {
+			continue
+		}
+		ids = append(ids, aws.ToString(mapping.Ebs.VolumeId))
+	}
+	return ids
+}
+
+// spotMarketOptions requests a persistent spot instance that EC2 stops rather
+// than terminates when it reclaims the capacity. Unlike one-time requests this
+// lets the scheduler stop the instance at expiry and start it again when the
+// TTL is extended. An empty maxPrice caps the price at the on-demand rate.
+func spotMarketOptions(maxPrice string) *types.InstanceMarketOptionsRequest {
+	options := &types.SpotMarketOptions{
+		SpotInstanceType:             types.SpotInstanceTypePersistent,
+		InstanceInterruptionBehavior: types.InstanceInterruptionBehaviorStop,
+	}
+	if maxPrice != "" {
+		options.MaxPrice = aws.String(maxPrice)
+	}
+	return &types.InstanceMarketOptionsRequest{
+		MarketType:  types.MarketTypeSpot,
+		SpotOptions: options,
+	}
+}
+
+// isSpotInterruption reports whether EC2 stopped or terminated the instance
+// to reclaim spot capacity
+func isSpotInterruption(instance types.Instance) bool {
+	if instance.StateReason == nil {
+		return false
+	}
+	switch aws.ToString(instance.StateReason.Code) {
+	case "Server.SpotInstanceShutdown", "Server.SpotInstanceTermination":
+		return true
+	}
+	return false
+}
+
 // GetInstanceStatus retrieves the status of an instance
-func (p *Provider) GetInstanceStatus(instanceID string) (*models.InstanceStatus, error) {
-	result, err := p.ec2Client.DescribeInstances(&ec2.DescribeInstancesInput{
-		InstanceIds: []*string{aws.String(instanceID)},
+func (p *Provider) GetInstanceStatus(ctx context.Context, instanceID string) (_ *models.InstanceStatus, err error) {
+	ctx, span := p.startSpan(ctx, "GetInstanceStatus", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	result, err := p.ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to describe instance: %w", err)
@@ -157,10 +632,13 @@ func (p *Provider) GetInstanceStatus(instanceID string) (*models.InstanceStatus,
 	}
 
 	instance := result.Reservations[0].Instances[0]
+	state := instanceState(instance)
 	status := &models.InstanceStatus{
-		ID:    instanceID,
-		State: *instance.State.Name,
-		Ready: *instance.State.Name == "running",
+		ID:          instanceID,
+		State:       state,
+		Ready:       state == "running",
+		Interrupted: isSpotInterruption(instance),
+		VolumeIDs:   dataVolumeIDs(instance),
 	}
 
 	if instance.PublicIpAddress != nil {
@@ -170,16 +648,21 @@ func (p *Provider) GetInstanceStatus(instanceID string) (*models.InstanceStatus,
 		status.PrivateIP = *instance.PrivateIpAddress
 	}
 
-	// Get username from AMI
-	status.Username = "ec2-user"
+	status.Username = usernameForOS(tagValue(instance.Tags, "OS"))
+	if gpus, ok := InstanceGPUs(string(instance.InstanceType)); ok {
+		status.GPUCount, status.GPUModel = gpus.Count, gpus.Model
+	}
 
 	return status, nil
 }
 
 // StartInstance starts a stopped EC2 instance
-func (p *Provider) StartInstance(instanceID string) error {
-	_, err := p.ec2Client.StartInstances(&ec2.StartInstancesInput{
-		InstanceIds: []*string{aws.String(instanceID)},
+func (p *Provider) StartInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StartInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	_, err = p.ec2Client.StartInstances(ctx, &ec2.StartInstancesInput{
+		InstanceIds: []string{instanceID},
 	})
 	if err != nil {
 		return fmt.Errorf("failed to start instance: %w", err)
@@ -188,9 +671,12 @@ func (p *Provider) StartInstance(instanceID string) error {
 }
 
 // StopInstance stops a running EC2 instance
-func (p *Provider) StopInstance(instanceID string) error {
-	_, err := p.ec2Client.StopInstances(&ec2.StopInstancesInput{
-		InstanceIds: []*string{aws.String(instanceID)},
+func (p *Provider) StopInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StopInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	_, err = p.ec2Client.StopInstances(ctx, &ec2.StopInstancesInput{
+		InstanceIds: []string{instanceID},
 	})
 	if err != nil {
 		return fmt.Errorf("failed to stop instance: %w", err)
@@ -198,10 +684,21 @@ func (p *Provider) StopInstance(instanceID string) error {
 	return nil
 }
 
-// TerminateInstance terminates an EC2 instance
-func (p *Provider) TerminateInstance(instanceID string) error {
-	_, err := p.ec2Client.TerminateInstances(&ec2.TerminateInstancesInput{
-		InstanceIds: []*string{aws.String(instanceID)},
+// TerminateInstance terminates an EC2 instance. The persistent request of a
+// spot instance is cancelled first so that EC2 does not launch a replacement.
+func (p *Provider) TerminateInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "TerminateInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.cancelSpotRequest(ctx, instanceID); err != nil {
+		return err
+	}
+	if err := p.releaseElasticIPs(ctx, instanceID); err != nil {
+		return err
+	}
+
+	_, err = p.ec2Client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: []string{instanceID},
 	})
 	if err != nil {
 		return fmt.Errorf("failed to terminate instance: %w", err)
@@ -209,73 +706,437 @@ func (p *Provider) TerminateInstance(instanceID string) error {
 	return nil
 }
 
+// cancelSpotRequest cancels the spot request that launched the instance, if any
+func (p *Provider) cancelSpotRequest(ctx context.Context, instanceID string) error {
+	result, err := p.ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe instance: %w", err)
+	}
+	if len(result.Reservations) == 0 || len(result.Reservations[0].Instances) == 0 {
+		return nil
+	}
+
+	requestID := aws.ToString(result.Reservations[0].Instances[0].SpotInstanceRequestId)
+	if requestID == "" {
+		return nil
+	}
+	_, err = p.ec2Client.CancelSpotInstanceRequests(ctx, &ec2.CancelSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: []string{requestID},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to cancel spot request %s: %w", requestID, err)
+	}
+	return nil
+}
+
 // ListInstances lists all instances managed by this tool
-func (p *Provider) ListInstances() ([]*models.Instance, error) {
-	result, err := p.ec2Client.DescribeInstances(&ec2.DescribeInstancesInput{
-		Filters: []*ec2.Filter{
+func (p *Provider) ListInstances(ctx context.Context) (_ []*models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "ListInstances", "")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	paginator := ec2.NewDescribeInstancesPaginator(p.ec2Client, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
 			{
 				Name:   aws.String("tag:ManagedBy"),
-				Values: []*string{aws.String("instance-manager")},
+				Values: []string{"instance-manager"},
 			},
 			{
-				Name: aws.String("instance-state-name"),
-				Values: []*string{
-					aws.String("pending"),
-					aws.String("running"),
-					aws.String("stopping"),
-					aws.String("stopped"),
-				},
+				Name:   aws.String("instance-state-name"),
+				Values: []string{"pending", "running", "stopping", "stopped"},
 			},
 		},
 	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to list instances: %w", err)
-	}
 
 	var instances []*models.Instance
-	for _, reservation := range result.Reservations {
-		for _, instance := range reservation.Instances {
-			inst := &models.Instance{
-				ID:           *instance.InstanceId,
-				InstanceType: *instance.InstanceType,
-				State:        *instance.State.Name,
-				LaunchTime:   *instance.LaunchTime,
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list instances: %w", err)
+		}
+		for _, reservation := range result.Reservations {
+			for _, instance := range reservation.Instances {
+				instances = append(instances, p.toInstance(instance))
 			}
+		}
+	}
 
-			if instance.PublicIpAddress != nil {
-				inst.PublicIP = *instance.PublicIpAddress
-			}
-			if instance.PrivateIpAddress != nil {
-				inst.PrivateIP = *instance.PrivateIpAddress
-			}
-			if instance.Placement != nil && instance.Placement.AvailabilityZone != nil {
-				inst.AvailabilityZone = *instance.Placement.AvailabilityZone
+	return instances, nil
+}
+
+// toInstance converts a described EC2 instance to the stored model, recovering
+// the duration and session from its tags
+func (p *Provider) toInstance(instance types.Instance) *models.Instance {
+	inst := &models.Instance{
+		ID:           aws.ToString(instance.InstanceId),
+		InstanceType: string(instance.InstanceType),
+		State:        instanceState(instance),
+		LaunchTime:   aws.ToTime(instance.LaunchTime),
+		Region:       p.region,
+	}
+
+	if instance.PublicIpAddress != nil {
+		inst.PublicIP = *instance.PublicIpAddress
+	}
+	if instance.PrivateIpAddress != nil {
+		inst.PrivateIP = *instance.PrivateIpAddress
+	}
+	if instance.Placement != nil && instance.Placement.AvailabilityZone != nil {
+		inst.AvailabilityZone = *instance.Placement.AvailabilityZone
+	}
+	if instance.KeyName != nil {
+		inst.KeyName = *instance.KeyName
+	}
+
+	// Get duration and session from tags
+	for _, tag := range instance.Tags {
+		switch aws.ToString(tag.Key) {
+		case "Duration":
+			duration, err := time.ParseDuration(aws.ToString(tag.Value))
+			if err == nil {
+				inst.Duration = duration
+				inst.ExpiresAt = inst.LaunchTime.Add(duration)
 			}
-			if instance.KeyName != nil {
-				inst.KeyName = *instance.KeyName
+		case "Session":
+			inst.Session = aws.ToString(tag.Value)
+		case "OS":
+			inst.OS = aws.ToString(tag.Value)
+		case "Name":
+			if name := aws.ToString(tag.Value); name != defaultName {
+				inst.Name = name
 			}
+		}
+	}
 
-			// Get duration from tags
-			for _, tag := range instance.Tags {
-				if *tag.Key == "Duration" {
-					duration, err := time.ParseDuration(*tag.Value)
-					if err == nil {
-						inst.Duration = duration
-						inst.ExpiresAt = inst.LaunchTime.Add(duration)
-					}
-				}
-			}
+	inst.Tags = userTags(instance.Tags)
+	if gpus, ok := InstanceGPUs(inst.InstanceType); ok {
+		inst.GPUCount, inst.GPUModel = gpus.Count, gpus.Model
+	}
+	inst.VPCID = aws.ToString(instance.VpcId)
+	inst.SubnetID = aws.ToString(instance.SubnetId)
+	inst.SpotRequestID = aws.ToString(instance.SpotInstanceRequestId)
+	inst.VolumeIDs = dataVolumeIDs(instance)
+	inst.Username = usernameForOS(inst.OS)
+	return inst
+}
+
+// instanceState returns the name of the instance's state
+func instanceState(instance types.Instance) string {
+	if instance.State == nil {
+		return ""
+	}
+	return string(instance.State.Name)
+}
 
-			inst.Username = "ec2-user"
-			instances = append(instances, inst)
+// SnapshotRootVolume creates an EBS snapshot of the instance's root volume and
+// waits until it has completed. It returns the snapshot ID, which is also
+// returned alongside the error if the wait fails.
+func (p *Provider) SnapshotRootVolume(ctx context.Context, instanceID string) (snapshotID string, err error) {
+	ctx, span := p.startSpan(ctx, "SnapshotRootVolume", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	volumeID, err := p.rootVolumeID(ctx, instanceID)
+	if err != nil {
+		return "", err
+	}
+
+	snapshotID, err = p.createSnapshot(ctx, instanceID, volumeID, fmt.Sprintf("Root volume of %s before termination", instanceID))
+	if err != nil {
+		return "", err
+	}
+	if err := p.waitForSnapshot(ctx, snapshotID); err != nil {
+		return snapshotID, err
+	}
+	return snapshotID, nil
+}
+
+// SnapshotVolumes starts a snapshot of every EBS volume attached to the
+// instance without waiting for them to complete. EBS snapshots capture the
+// volume as it was when they started, so the instance may be stopped or
+// terminated right away. Snapshots started before a failure are returned
+// alongside the error.
+func (p *Provider) SnapshotVolumes(ctx context.Context, instanceID string) (records []*models.SnapshotRecord, err error) {
+	ctx, span := p.startSpan(ctx, "SnapshotVolumes", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	result, err := p.ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe instance: %w", err)
+	}
+	if len(result.Reservations) == 0 || len(result.Reservations[0].Instances) == 0 {
+		return nil, errors.New("instance not found")
+	}
+
+	for _, mapping := range result.Reservations[0].Instances[0].BlockDeviceMappings {
+		if mapping.Ebs == nil {
+			continue
+		}
+		volumeID := aws.ToString(mapping.Ebs.VolumeId)
+		description := fmt.Sprintf("Volume %s of %s at expiry", aws.ToString(mapping.DeviceName), instanceID)
+		snapshotID, err := p.createSnapshot(ctx, instanceID, volumeID, description)
+		if err != nil {
+			return records, err
 		}
+		records = append(records, &models.SnapshotRecord{
+			SnapshotID: snapshotID,
+			InstanceID: instanceID,
+			VolumeID:   volumeID,
+			Region:     p.region,
+			CreatedAt:  time.Now(),
+		})
 	}
+	return records, nil
+}
 
-	return instances, nil
+// DeleteSnapshot deletes an EBS snapshot
+func (p *Provider) DeleteSnapshot(ctx context.Context, snapshotID string) (err error) {
+	ctx, span := p.startSpan(ctx, "DeleteSnapshot", snapshotID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	_, err = p.ec2Client.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{
+		SnapshotId: aws.String(snapshotID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete snapshot %s: %w", snapshotID, err)
+	}
+	return nil
+}
+
+// createSnapshot starts a snapshot of the volume tagged with the instance it
+// belongs to and returns its ID
+func (p *Provider) createSnapshot(ctx context.Context, instanceID, volumeID, description string) (string, error) {
+	result, err := p.ec2Client.CreateSnapshot(ctx, &ec2.CreateSnapshotInput{
+		VolumeId:    aws.String(volumeID),
+		Description: aws.String(description),
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeSnapshot,
+				Tags: toEC2Tags(map[string]string{
+					"ManagedBy":  "instance-manager",
+					"InstanceId": instanceID,
+				}),
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create snapshot of %s: %w", volumeID, err)
+	}
+	return aws.ToString(result.SnapshotId), nil
+}
+
+// rootVolumeID returns the ID of the EBS volume attached as the instance's root device
+func (p *Provider) rootVolumeID(ctx context.Context, instanceID string) (string, error) {
+	result, err := p.ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe instance: %w", err)
+	}
+	if len(result.Reservations) == 0 || len(result.Reservations[0].Instances) == 0 {
+		return "", errors.New("instance not found")
+	}
+
+	instance := result.Reservations[0].Instances[0]
+	rootDevice := aws.ToString(instance.RootDeviceName)
+	for _, mapping := range instance.BlockDeviceMappings {
+		if aws.ToString(mapping.DeviceName) == rootDevice && mapping.Ebs != nil {
+			return aws.ToString(mapping.Ebs.VolumeId), nil
+		}
+	}
+	return "", fmt.Errorf("no EBS root volume found for instance %s", instanceID)
+}
+
+// waitForSnapshot polls the snapshot until it is completed, fails, the
+// snapshot timeout passes or ctx is done
+func (p *Provider) waitForSnapshot(ctx context.Context, snapshotID string) error {
+	deadline := time.Now().Add(p.snapshotTimeout)
+	for {
+		result, err := p.ec2Client.DescribeSnapshots(ctx, &ec2.DescribeSnapshotsInput{
+			SnapshotIds: []string{snapshotID},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to describe snapshot %s: %w", snapshotID, err)
+		}
+		if len(result.Snapshots) == 0 {
+			return fmt.Errorf("snapshot %s not found", snapshotID)
+		}
+
+		switch state := result.Snapshots[0].State; state {
+		case types.SnapshotStateCompleted:
+			return nil
+		case types.SnapshotStateError, types.SnapshotStateRecoverable, types.SnapshotStateRecovering:
+			return fmt.Errorf("snapshot %s is in state %s", snapshotID, state)
+		}
+
+		if time.Now().Add(p.snapshotPollInterval).After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for snapshot %s to complete", p.snapshotTimeout, snapshotID)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.snapshotPollInterval):
+		}
+	}
+}
+
+// startSpan starts a tracing span for an AWS provider operation. The returned
+// context carries the span and is passed to the EC2 calls made for it.
+func (p *Provider) startSpan(ctx context.Context, operation, instanceID string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		tracing.AttrProvider.String("aws"),
+		attribute.String("aws.region", p.region),
+	}
+	if instanceID != "" {
+		attrs = append(attrs, tracing.AttrInstanceID.String(instanceID))
+	}
+	return tracing.StartSpan(ctx, "aws."+operation, attrs...)
+}
+
+// RetagInstance applies the managed metadata tags that are missing from an
+// existing instance. It returns the tags that were added, or would be added
+// when dryRun is set.
+func (p *Provider) RetagInstance(ctx context.Context, instance *models.Instance, dryRun bool) (map[string]string, error) {
+	result, err := p.ec2Client.DescribeTags(ctx, &ec2.DescribeTagsInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("resource-id"),
+				Values: []string{instance.ID},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe tags: %w", err)
+	}
+
+	existing := make(map[string]bool)
+	for _, tag := range result.Tags {
+		if tag.Key != nil {
+			existing[*tag.Key] = true
+		}
+	}
+
+	missing := make(map[string]string)
+	for key, value := range instanceTags(managedTags(instance.Name, instance.Duration, instance.ExpiresAt, instance.Session, instance.OS), instance.Tags) {
+		if !existing[key] {
+			missing[key] = value
+		}
+	}
+
+	if len(missing) == 0 || dryRun {
+		return missing, nil
+	}
+
+	_, err = p.ec2Client.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{instance.ID},
+		Tags:      toEC2Tags(missing),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tags: %w", err)
+	}
+
+	return missing, nil
+}
+
+// defaultName is the Name tag of instances created without a name
+const defaultName = "instance-manager"
+
+// managedTags returns the metadata tags every managed instance should carry,
+// plus the Session tag for instances created in a session. Unnamed instances
+// are named defaultName.
+func managedTags(name string, duration time.Duration, expiresAt time.Time, session, osName string) map[string]string {
+	if name == "" {
+		name = defaultName
+	}
+	tags := map[string]string{
+		"Name":      name,
+		"ManagedBy": "instance-manager",
+		"Duration":  duration.String(),
+		"ExpiresAt": expiresAt.UTC().Format(time.RFC3339),
+	}
+	if session != "" {
+		tags["Session"] = session
+	}
+	if osName != "" {
+		tags["OS"] = osName
+	}
+	return tags
+}
+
+// reservedTagKeys are the tags set by managedTags, which user-defined tags
+// may not override
+var reservedTagKeys = []string{"Name", "ManagedBy", "Duration", "ExpiresAt", "Session", "OS"}
+
+// checkUserTags fails if a user-defined tag is invalid or reserved
+func checkUserTags(tags map[string]string) error {
+	if err := models.ValidateTags(tags); err != nil {
+		return err
+	}
+	for key := range tags {
+		if slices.Contains(reservedTagKeys, key) {
+			return fmt.Errorf("tag %q is set by instance-manager and cannot be overridden", key)
+		}
+	}
+	return nil
+}
+
+// instanceTags merges the user-defined tags into the managed ones
+func instanceTags(managed, user map[string]string) map[string]string {
+	for key, value := range user {
+		managed[key] = value
+	}
+	return managed
+}
+
+// userTags returns the tags that are set neither by managedTags nor by AWS,
+// or nil
+func userTags(tags []types.Tag) map[string]string {
+	var result map[string]string
+	for _, tag := range tags {
+		key := aws.ToString(tag.Key)
+		if slices.Contains(reservedTagKeys, key) || strings.HasPrefix(key, "aws:") {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]string)
+		}
+		result[key] = aws.ToString(tag.Value)
+	}
+	return result
+}
+
+// tagValue returns the value of the tag with the given key, or ""
+func tagValue(tags []types.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value)
+		}
+	}
+	return ""
+}
+
+// toEC2Tags converts a tag map to EC2 tags sorted by key
+func toEC2Tags(tags map[string]string) []types.Tag {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ec2Tags := make([]types.Tag, 0, len(keys))
+	for _, key := range keys {
+		ec2Tags = append(ec2Tags, types.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(tags[key]),
+		})
+	}
+	return ec2Tags
 }
 
 // importKeyPair imports a public key to AWS
-func (p *Provider) importKeyPair(publicKeyPath string) (string, error) {
+func (p *Provider) importKeyPair(ctx context.Context, publicKeyPath string) (string, error) {
 	keyData, err := os.ReadFile(publicKeyPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to read public key file: %w", err)
@@ -287,8 +1148,8 @@ func (p *Provider) importKeyPair(publicKeyPath string) (string, error) {
 	keyName := fmt.Sprintf("instance-manager-%x", hasher.Sum(nil)[:8])
 
 	// Check if key already exists
-	_, err = p.ec2Client.DescribeKeyPairs(&ec2.DescribeKeyPairsInput{
-		KeyNames: []*string{aws.String(keyName)},
+	_, err = p.ec2Client.DescribeKeyPairs(ctx, &ec2.DescribeKeyPairsInput{
+		KeyNames: []string{keyName},
 	})
 	if err == nil {
 		// Key already exists
@@ -296,7 +1157,7 @@ func (p *Provider) importKeyPair(publicKeyPath string) (string, error) {
 	}
 
 	// Import the key
-	_, err = p.ec2Client.ImportKeyPair(&ec2.ImportKeyPairInput{
+	_, err = p.ec2Client.ImportKeyPair(ctx, &ec2.ImportKeyPairInput{
 		KeyName:           aws.String(keyName),
 		PublicKeyMaterial: keyData,
 	})
@@ -307,65 +1168,130 @@ func (p *Provider) importKeyPair(publicKeyPath string) (string, error) {
 	return keyName, nil
 }
 
+// validateKeyPair checks that a key pair with the given name exists
+func (p *Provider) validateKeyPair(ctx context.Context, keyName string) error {
+	result, err := p.ec2Client.DescribeKeyPairs(ctx, &ec2.DescribeKeyPairsInput{
+		KeyNames: []string{keyName},
+	})
+	if err != nil {
+		return fmt.Errorf("key pair %s not found: %w", keyName, err)
+	}
+	if len(result.KeyPairs) == 0 {
+		return fmt.Errorf("key pair %s not found", keyName)
+	}
+	return nil
+}
+
+// resolveSubnet returns the subnet to launch into. An explicit subnet must
+// belong to the requested VPC, if any; a VPC without a subnet uses an
+// available subnet of that VPC in the requested AZ. Neither falls back to the
+// default subnet chain.
+func (p *Provider) resolveSubnet(ctx context.Context, config models.InstanceConfig) (types.Subnet, error) {
+	if config.SubnetID != "" {
+		result, err := p.ec2Client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+			SubnetIds: []string{config.SubnetID},
+		})
+		if err != nil {
+			return types.Subnet{}, fmt.Errorf("failed to describe subnet %s: %w", config.SubnetID, err)
+		}
+		if len(result.Subnets) == 0 {
+			return types.Subnet{}, fmt.Errorf("subnet %s not found", config.SubnetID)
+		}
+		subnet := result.Subnets[0]
+		if config.VPCID != "" && aws.ToString(subnet.VpcId) != config.VPCID {
+			return types.Subnet{}, fmt.Errorf("subnet %s belongs to VPC %s, not %s", config.SubnetID, aws.ToString(subnet.VpcId), config.VPCID)
+		}
+		return subnet, nil
+	}
+
+	if config.VPCID != "" {
+		result, err := p.ec2Client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+			Filters: []types.Filter{
+				{
+					Name:   aws.String("vpc-id"),
+					Values: []string{config.VPCID},
+				},
+				{
+					Name:   aws.String("availability-zone"),
+					Values: []string{config.AvailabilityZone},
+				},
+				{
+					Name:   aws.String("state"),
+					Values: []string{"available"},
+				},
+			},
+		})
+		if err != nil {
+			return types.Subnet{}, fmt.Errorf("failed to describe subnets: %w", err)
+		}
+		if len(result.Subnets) == 0 {
+			return types.Subnet{}, fmt.Errorf("no available subnet in VPC %s in %s", config.VPCID, config.AvailabilityZone)
+		}
+		return result.Subnets[0], nil
+	}
+
+	return p.getDefaultSubnet(ctx, config.AvailabilityZone)
+}
+
 // getDefaultSubnet gets the default subnet for the specified AZ, or any available subnet
-func (p *Provider) getDefaultSubnet(availabilityZone string) (string, error) {
+func (p *Provider) getDefaultSubnet(ctx context.Context, availabilityZone string) (types.Subnet, error) {
 	// First try to find default subnet in the specified AZ
-	result, err := p.ec2Client.DescribeSubnets(&ec2.DescribeSubnetsInput{
-		Filters: []*ec2.Filter{
+	result, err := p.ec2Client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+		Filters: []types.Filter{
 			{
 				Name:   aws.String("availability-zone"),
-				Values: []*string{aws.String(availabilityZone)},
+				Values: []string{availabilityZone},
 			},
 			{
 				Name:   aws.String("default-for-az"),
-				Values: []*string{aws.String("true")},
+				Values: []string{"true"},
 			},
 		},
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to describe subnets: %w", err)
+		return types.Subnet{}, fmt.Errorf("failed to describe subnets: %w", err)
 	}
 
 	if len(result.Subnets) > 0 {
-		return *result.Subnets[0].SubnetId, nil
+		return result.Subnets[0], nil
 	}
 
 	// If no default subnet found, try to find any subnet in the specified AZ
-	result, err = p.ec2Client.DescribeSubnets(&ec2.DescribeSubnetsInput{
-		Filters: []*ec2.Filter{
+	result, err = p.ec2Client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+		Filters: []types.Filter{
 			{
 				Name:   aws.String("availability-zone"),
-				Values: []*string{aws.String(availabilityZone)},
+				Values: []string{availabilityZone},
 			},
 			{
 				Name:   aws.String("state"),
-				Values: []*string{aws.String("available")},
+				Values: []string{"available"},
 			},
 		},
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to describe subnets: %w", err)
+		return types.Subnet{}, fmt.Errorf("failed to describe subnets: %w", err)
 	}
 
 	if len(result.Subnets) > 0 {
-		return *result.Subnets[0].SubnetId, nil
+		return result.Subnets[0], nil
 	}
 
 	// If still no subnet found, try to find any subnet in any AZ in the region
-	result, err = p.ec2Client.DescribeSubnets(&ec2.DescribeSubnetsInput{
-		Filters: []*ec2.Filter{
+	result, err = p.ec2Client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+		Filters: []types.Filter{
 			{
 				Name:   aws.String("state"),
-				Values: []*string{aws.String("available")},
+				Values: []string{"available"},
 			},
 		},
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to describe subnets: %w", err)
+		return types.Subnet{}, fmt.Errorf("failed to describe subnets: %w", err)
 	}
 
 	if len(result.Subnets) == 0 {
-		return "", fmt.Errorf("no available subnets found in region %s. Please create a VPC and subnet first", p.region)
+		return types.Subnet{}, fmt.Errorf("no available subnets found in region %s. Please create a VPC and subnet first", p.region)
 	}
 
 	// Use the first available subnet and log a warning
@@ -374,59 +1300,236 @@ func (p *Provider) getDefaultSubnet(availabilityZone string) (string, error) {
 		*result.Subnets[0].SubnetId,
 		*result.Subnets[0].AvailabilityZone)
 
-	return *result.Subnets[0].SubnetId, nil
+	return result.Subnets[0], nil
 }
 
-// createOrGetSecurityGroup creates or gets the security group for SSH access
-func (p *Provider) createOrGetSecurityGroup() (string, error) {
-	groupName := "instance-manager-sg"
+// IngressRule describes a single inbound security group rule
+type IngressRule struct {
+	Protocol string `json:"protocol"`
+	FromPort int64  `json:"from_port"`
+	ToPort   int64  `json:"to_port"`
+	Source   string `json:"source"` // CIDR block or source security group ID
+}
 
-	// Check if security group exists
-	result, err := p.ec2Client.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
-		Filters: []*ec2.Filter{
-			{
-				Name:   aws.String("group-name"),
-				Values: []*string{aws.String(groupName)},
-			},
+// String returns a human-readable form of the rule, e.g. "tcp/22 from 0.0.0.0/0"
+func (r IngressRule) String() string {
+	if r.Protocol == "-1" {
+		return "all traffic from " + r.Source
+	}
+	ports := fmt.Sprintf("%d", r.FromPort)
+	if r.ToPort != r.FromPort {
+		ports = fmt.Sprintf("%d-%d", r.FromPort, r.ToPort)
+	}
+	return fmt.Sprintf("%s/%s from %s", r.Protocol, ports, r.Source)
+}
+
+// SecurityGroupPlan describes the security group an instance would be launched with
+type SecurityGroupPlan struct {
+	GroupID   string        `json:"group_id,omitempty"` // Empty when the group does not exist yet
+	GroupName string        `json:"group_name"`
+	VPCID     string        `json:"vpc_id,omitempty"` // Empty means the default VPC
+	Reused    bool          `json:"reused"`           // Set when an existing group was requested by ID
+	Rules     []IngressRule `json:"rules"`
+
+	// AttachedGroupIDs are existing groups attached alongside this one
+	AttachedGroupIDs []string `json:"attached_group_ids,omitempty"`
+}
+
+// PreviewSecurityGroup resolves the security group and ingress rules that
+// CreateInstance would apply for the given configuration without changing anything
+func (p *Provider) PreviewSecurityGroup(ctx context.Context, config models.InstanceConfig) (*SecurityGroupPlan, error) {
+	return p.previewSecurityGroup(ctx, config)
+}
+
+func (p *Provider) previewSecurityGroup(ctx context.Context, config models.InstanceConfig) (*SecurityGroupPlan, error) {
+	plan, err := p.primarySecurityGroup(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.ExtraSecurityGroupIDs) > 0 {
+		result, err := p.ec2Client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+			GroupIds: config.ExtraSecurityGroupIDs,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe security groups %s: %w", strings.Join(config.ExtraSecurityGroupIDs, ", "), err)
+		}
+		found := make(map[string]bool)
+		for _, group := range result.SecurityGroups {
+			if err := checkGroupVPC(group, config.VPCID); err != nil {
+				return nil, err
+			}
+			found[aws.ToString(group.GroupId)] = true
+		}
+		for _, id := range config.ExtraSecurityGroupIDs {
+			if !found[id] {
+				return nil, fmt.Errorf("security group %s not found", id)
+			}
+			if id != plan.GroupID && !slices.Contains(plan.AttachedGroupIDs, id) {
+				plan.AttachedGroupIDs = append(plan.AttachedGroupIDs, id)
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// primarySecurityGroup resolves the group requested by ID, or else the
+// managed group holding the requested rules
+func (p *Provider) primarySecurityGroup(ctx context.Context, config models.InstanceConfig) (*SecurityGroupPlan, error) {
+	if config.SecurityGroupID != "" {
+		result, err := p.ec2Client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+			GroupIds: []string{config.SecurityGroupID},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe security group %s: %w", config.SecurityGroupID, err)
+		}
+		if len(result.SecurityGroups) == 0 {
+			return nil, fmt.Errorf("security group %s not found", config.SecurityGroupID)
+		}
+
+		group := result.SecurityGroups[0]
+		if err := checkGroupVPC(group, config.VPCID); err != nil {
+			return nil, err
+		}
+		return &SecurityGroupPlan{
+			GroupID:   aws.ToString(group.GroupId),
+			GroupName: aws.ToString(group.GroupName),
+			VPCID:     aws.ToString(group.VpcId),
+			Reused:    true,
+			Rules:     ingressRules(group.IpPermissions),
+		}, nil
+	}
+
+	ports, err := cloud.NormalizePorts(config.OpenPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	sshSources, err := cloud.NormalizeCIDRs(config.SSHCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []IngressRule
+	for _, port := range ports {
+		sources := []string{cloud.Anywhere}
+		if port == 22 {
+			sources = sshSources
+		}
+		for _, source := range sources {
+			rules = append(rules, IngressRule{
+				Protocol: "tcp",
+				FromPort: port,
+				ToPort:   port,
+				Source:   source,
+			})
+		}
+	}
+	plan := &SecurityGroupPlan{GroupName: securityGroupName(ports, rules), VPCID: config.VPCID, Rules: rules}
+
+	// Reuse the managed group if it was created by an earlier launch
+	filters := []types.Filter{
+		{
+			Name:   aws.String("group-name"),
+			Values: []string{plan.GroupName},
 		},
+	}
+	if plan.VPCID != "" {
+		filters = append(filters, types.Filter{
+			Name:   aws.String("vpc-id"),
+			Values: []string{plan.VPCID},
+		})
+	}
+	result, err := p.ec2Client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: filters,
 	})
 	if err == nil && len(result.SecurityGroups) > 0 {
-		return *result.SecurityGroups[0].GroupId, nil
+		plan.GroupID = aws.ToString(result.SecurityGroups[0].GroupId)
 	}
 
-	// First try to get default VPC
-	vpcResult, err := p.ec2Client.DescribeVpcs(&ec2.DescribeVpcsInput{
-		Filters: []*ec2.Filter{
-			{
-				Name:   aws.String("is-default"),
-				Values: []*string{aws.String("true")},
-			},
-		},
-	})
+	return plan, nil
+}
 
-	var vpcID string
-	if err != nil || len(vpcResult.Vpcs) == 0 {
-		// No default VPC, find any VPC
-		vpcResult, err = p.ec2Client.DescribeVpcs(&ec2.DescribeVpcsInput{
-			Filters: []*ec2.Filter{
-				{
-					Name:   aws.String("state"),
-					Values: []*string{aws.String("available")},
-				},
-			},
-		})
-		if err != nil || len(vpcResult.Vpcs) == 0 {
-			return "", fmt.Errorf("no available VPCs found. Please create a VPC first")
+// checkGroupVPC fails if an existing group is outside the VPC the instance
+// launches into. An empty vpcID skips the check.
+func checkGroupVPC(group types.SecurityGroup, vpcID string) error {
+	if vpcID != "" && aws.ToString(group.VpcId) != vpcID {
+		return fmt.Errorf("security group %s belongs to VPC %s, not %s", aws.ToString(group.GroupId), aws.ToString(group.VpcId), vpcID)
+	}
+	return nil
+}
+
+// securityGroupName returns the managed group name for a set of rules.
+// Groups open to anywhere are named after their ports, and the SSH-only group
+// keeps its original name, so existing groups are reused. Groups with
+// narrower sources are named after a hash of their rules, so every distinct
+// rule set gets its own group.
+func securityGroupName(ports []int64, rules []IngressRule) string {
+	restricted := false
+	for _, rule := range rules {
+		if rule.Source != cloud.Anywhere {
+			restricted = true
 		}
-		vpcID = *vpcResult.Vpcs[0].VpcId
-		fmt.Printf("Warning: No default VPC found, using VPC %s\n", vpcID)
-	} else {
-		vpcID = *vpcResult.Vpcs[0].VpcId
+	}
+	if restricted {
+		h := sha256.New()
+		for _, rule := range rules {
+			fmt.Fprintln(h, rule)
+		}
+		return "instance-manager-sg-" + hex.EncodeToString(h.Sum(nil))[:12]
+	}
+
+	if len(ports) == 1 && ports[0] == 22 {
+		return "instance-manager-sg"
+	}
+	parts := make([]string, len(ports))
+	for i, port := range ports {
+		parts[i] = fmt.Sprintf("%d", port)
+	}
+	return "instance-manager-sg-" + strings.Join(parts, "-")
+}
+
+// ingressRules flattens EC2 IP permissions into one rule per source
+func ingressRules(permissions []types.IpPermission) []IngressRule {
+	var rules []IngressRule
+	for _, permission := range permissions {
+		rule := IngressRule{
+			Protocol: aws.ToString(permission.IpProtocol),
+			FromPort: int64(aws.ToInt32(permission.FromPort)),
+			ToPort:   int64(aws.ToInt32(permission.ToPort)),
+		}
+		for _, ipRange := range permission.IpRanges {
+			rule.Source = aws.ToString(ipRange.CidrIp)
+			rules = append(rules, rule)
+		}
+		for _, ipRange := range permission.Ipv6Ranges {
+			rule.Source = aws.ToString(ipRange.CidrIpv6)
+			rules = append(rules, rule)
+		}
+		for _, pair := range permission.UserIdGroupPairs {
+			rule.Source = aws.ToString(pair.GroupId)
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// createOrGetSecurityGroup returns the planned security group, creating it
+// with the planned ingress rules if it doesn't exist yet
+func (p *Provider) createOrGetSecurityGroup(ctx context.Context, plan *SecurityGroupPlan) (string, error) {
+	if plan.GroupID != "" {
+		return plan.GroupID, nil
+	}
+
+	vpcID, err := p.securityGroupVPC(ctx, plan)
+	if err != nil {
+		return "", err
 	}
 
 	// Create security group
-	createResult, err := p.ec2Client.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
-		GroupName:   aws.String(groupName),
+	createResult, err := p.ec2Client.CreateSecurityGroup(ctx, &ec2.CreateSecurityGroupInput{
+		GroupName:   aws.String(plan.GroupName),
 		Description: aws.String("Security group for instance-manager"),
 		VpcId:       aws.String(vpcID),
 	})
@@ -436,29 +1539,68 @@ func (p *Provider) createOrGetSecurityGroup() (string, error) {
 
 	securityGroupID := *createResult.GroupId
 
-	// Add SSH rule
-	_, err = p.ec2Client.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
-		GroupId: aws.String(securityGroupID),
-		IpPermissions: []*ec2.IpPermission{
-			{
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int64(22),
-				ToPort:     aws.Int64(22),
-				IpRanges: []*ec2.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
+	// Add the ingress rules
+	permissions := make([]types.IpPermission, 0, len(plan.Rules))
+	for _, rule := range plan.Rules {
+		permissions = append(permissions, types.IpPermission{
+			IpProtocol: aws.String(rule.Protocol),
+			FromPort:   aws.Int32(int32(rule.FromPort)),
+			ToPort:     aws.Int32(int32(rule.ToPort)),
+			IpRanges: []types.IpRange{
+				{
+					CidrIp: aws.String(rule.Source),
 				},
 			},
-		},
+		})
+	}
+	_, err = p.ec2Client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(securityGroupID),
+		IpPermissions: permissions,
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to add SSH rule to security group: %w", err)
+		return "", fmt.Errorf("failed to add ingress rules to security group: %w", err)
 	}
 
 	return securityGroupID, nil
 }
 
+// securityGroupVPC returns the VPC a new managed group is created in: the
+// planned one, else the default VPC, else any available VPC
+func (p *Provider) securityGroupVPC(ctx context.Context, plan *SecurityGroupPlan) (string, error) {
+	if plan.VPCID != "" {
+		return plan.VPCID, nil
+	}
+
+	// First try to get default VPC
+	vpcResult, err := p.ec2Client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("is-default"),
+				Values: []string{"true"},
+			},
+		},
+	})
+	if err == nil && len(vpcResult.Vpcs) > 0 {
+		return aws.ToString(vpcResult.Vpcs[0].VpcId), nil
+	}
+
+	// No default VPC, find any VPC
+	vpcResult, err = p.ec2Client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("state"),
+				Values: []string{"available"},
+			},
+		},
+	})
+	if err != nil || len(vpcResult.Vpcs) == 0 {
+		return "", fmt.Errorf("no available VPCs found. Please create a VPC first")
+	}
+	vpcID := aws.ToString(vpcResult.Vpcs[0].VpcId)
+	fmt.Printf("Warning: No default VPC found, using VPC %s\n", vpcID)
+	return vpcID, nil
+}
+
 // getAMIID returns a fallback AMI ID for Amazon Linux 2
 func (p *Provider) getAMIID() string {
 	// Updated AMI IDs for Amazon Linux 2 (as of late 2024)
@@ -480,39 +1622,3 @@ func (p *Provider) getAMIID() string {
 	// Fallback to us-east-1a AMI if region not found
 	return amiMap["us-east-1a"]
 }
-
-// getLatestAmazonLinuxAMI gets the latest Amazon Linux 2 AMI for the current region
-func (p *Provider) getLatestAmazonLinuxAMI() (string, error) {
-	result, err := p.ec2Client.DescribeImages(&ec2.DescribeImagesInput{
-		Owners: []*string{aws.String("amazon")},
-		Filters: []*ec2.Filter{
-			{
-				Name:   aws.String("name"),
-				Values: []*string{aws.String("amzn2-ami-hvm-*-x86_64-gp2")},
-			},
-			{
-				Name:   aws.String("state"),
-				Values: []*string{aws.String("available")},
-			},
-		},
-	})
-	if err != nil {
-		return "", err
-	}
-
-	if len(result.Images) == 0 {
-		return "", errors.New("no Amazon Linux 2 AMI found")
-	}
-
-	// Sort by creation date and return the latest
-	latest := result.Images[0]
-	for _, image := range result.Images[1:] {
-		if image.CreationDate != nil && latest.CreationDate != nil {
-			if strings.Compare(*image.CreationDate, *latest.CreationDate) > 0 {
-				latest = image
-			}
-		}
-	}
-
-	return *latest.ImageId, nil
-}
@@ -0,0 +1,1615 @@
+package aws_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	awsprovider "instance-manager/pkg/aws"
+	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/tracing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// MockEC2 implements the subset of the EC2 API used by the provider
+type MockEC2 struct {
+	awsprovider.EC2API
+	tags               map[string]map[string]string
+	keyPairs           map[string]bool
+	securityGroups     []types.SecurityGroup
+	subnets            []types.Subnet
+	snapshotStates     []types.SnapshotState
+	createSnapCalls    []*ec2.CreateSnapshotInput
+	describeSnapCalls  int
+	deleteSnapCalls    []string
+	createTagCalls     []*ec2.CreateTagsInput
+	importKeyCalls     []*ec2.ImportKeyPairInput
+	runInstancesCalls  []*ec2.RunInstancesInput
+	terminateCalls     []string
+	spotRequests       map[string]string // Spot request ID by instance ID
+	stateReasons       map[string]string // State reason code by instance ID
+	cancelSpotCalls    []string
+	describeImageCalls []*ec2.DescribeImagesInput
+	images             []types.Image // Images returned by ID
+	createImageCalls   []*ec2.CreateImageInput
+	instanceTypes      []types.InstanceTypeInfo
+	stopCalls          []*ec2.StopInstancesInput
+	addresses          []types.Address
+	associateCalls     []*ec2.AssociateAddressInput
+	releaseCalls       []string
+}
+
+func NewMockEC2() *MockEC2 {
+	return &MockEC2{
+		tags:         make(map[string]map[string]string),
+		keyPairs:     make(map[string]bool),
+		spotRequests: make(map[string]string),
+		stateReasons: make(map[string]string),
+		securityGroups: []types.SecurityGroup{
+			{GroupId: aws.String("sg-123"), GroupName: aws.String("instance-manager-sg")},
+		},
+		subnets: []types.Subnet{
+			{SubnetId: aws.String("subnet-123"), VpcId: aws.String("vpc-default"), AvailabilityZone: aws.String("us-east-1a")},
+		},
+	}
+}
+
+func (m *MockEC2) DescribeKeyPairs(ctx context.Context, input *ec2.DescribeKeyPairsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeKeyPairsOutput, error) {
+	output := &ec2.DescribeKeyPairsOutput{}
+	for _, name := range input.KeyNames {
+		if !m.keyPairs[name] {
+			return nil, &smithy.GenericAPIError{Code: "InvalidKeyPair.NotFound", Message: "The key pair does not exist"}
+		}
+		output.KeyPairs = append(output.KeyPairs, types.KeyPairInfo{KeyName: aws.String(name)})
+	}
+	return output, nil
+}
+
+func (m *MockEC2) ImportKeyPair(ctx context.Context, input *ec2.ImportKeyPairInput, optFns ...func(*ec2.Options)) (*ec2.ImportKeyPairOutput, error) {
+	m.importKeyCalls = append(m.importKeyCalls, input)
+	m.keyPairs[aws.ToString(input.KeyName)] = true
+	return &ec2.ImportKeyPairOutput{KeyName: input.KeyName}, nil
+}
+
+// DescribeSubnets matches subnets by ID and VPC; other filters are ignored
+func (m *MockEC2) DescribeSubnets(ctx context.Context, input *ec2.DescribeSubnetsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error) {
+	output := &ec2.DescribeSubnetsOutput{}
+	for _, subnet := range m.subnets {
+		matched := true
+		if len(input.SubnetIds) > 0 {
+			matched = slices.Contains(input.SubnetIds, aws.ToString(subnet.SubnetId))
+		}
+		for _, filter := range input.Filters {
+			if aws.ToString(filter.Name) == "vpc-id" {
+				matched = matched && slices.Contains(filter.Values, aws.ToString(subnet.VpcId))
+			}
+		}
+		if matched {
+			output.Subnets = append(output.Subnets, subnet)
+		}
+	}
+	if len(input.SubnetIds) > 0 && len(output.Subnets) == 0 {
+		return nil, &smithy.GenericAPIError{Code: "InvalidSubnetID.NotFound", Message: "The subnet ID does not exist"}
+	}
+	return output, nil
+}
+
+func (m *MockEC2) DescribeSecurityGroups(ctx context.Context, input *ec2.DescribeSecurityGroupsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error) {
+	output := &ec2.DescribeSecurityGroupsOutput{}
+	for _, group := range m.securityGroups {
+		matched := true
+		if len(input.GroupIds) > 0 {
+			matched = slices.Contains(input.GroupIds, aws.ToString(group.GroupId))
+		}
+		for _, filter := range input.Filters {
+			switch aws.ToString(filter.Name) {
+			case "group-name":
+				matched = matched && filter.Values[0] == aws.ToString(group.GroupName)
+			case "vpc-id":
+				matched = matched && filter.Values[0] == aws.ToString(group.VpcId)
+			}
+		}
+		if matched {
+			output.SecurityGroups = append(output.SecurityGroups, group)
+		}
+	}
+	if len(input.GroupIds) > 0 && len(output.SecurityGroups) == 0 {
+		return nil, &smithy.GenericAPIError{Code: "InvalidGroup.NotFound", Message: "The security group does not exist"}
+	}
+	return output, nil
+}
+
+func (m *MockEC2) DescribeImages(ctx context.Context, input *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	m.describeImageCalls = append(m.describeImageCalls, input)
+	if len(input.ImageIds) > 0 {
+		output := &ec2.DescribeImagesOutput{}
+		for _, image := range m.images {
+			if slices.Contains(input.ImageIds, aws.ToString(image.ImageId)) {
+				output.Images = append(output.Images, image)
+			}
+		}
+		return output, nil
+	}
+	return &ec2.DescribeImagesOutput{
+		Images: []types.Image{
+			{ImageId: aws.String("ami-123"), CreationDate: aws.String("2024-01-01T00:00:00.000Z")},
+		},
+	}, nil
+}
+
+func (m *MockEC2) CreateImage(ctx context.Context, input *ec2.CreateImageInput, optFns ...func(*ec2.Options)) (*ec2.CreateImageOutput, error) {
+	m.createImageCalls = append(m.createImageCalls, input)
+	return &ec2.CreateImageOutput{ImageId: aws.String("ami-0backup0000000000")}, nil
+}
+
+func (m *MockEC2) DescribeInstanceTypes(ctx context.Context, input *ec2.DescribeInstanceTypesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error) {
+	output := &ec2.DescribeInstanceTypesOutput{}
+	for _, info := range m.instanceTypes {
+		if slices.Contains(input.InstanceTypes, info.InstanceType) {
+			output.InstanceTypes = append(output.InstanceTypes, info)
+		}
+	}
+	return output, nil
+}
+
+func (m *MockEC2) StopInstances(ctx context.Context, input *ec2.StopInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StopInstancesOutput, error) {
+	m.stopCalls = append(m.stopCalls, input)
+	return &ec2.StopInstancesOutput{}, nil
+}
+
+func (m *MockEC2) RunInstances(ctx context.Context, input *ec2.RunInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error) {
+	m.runInstancesCalls = append(m.runInstancesCalls, input)
+	instance := types.Instance{InstanceId: aws.String("i-new123")}
+	if input.InstanceMarketOptions != nil {
+		instance.SpotInstanceRequestId = aws.String("sir-new123")
+	}
+	return &ec2.RunInstancesOutput{Instances: []types.Instance{instance}}, nil
+}
+
+func (m *MockEC2) DescribeTags(ctx context.Context, input *ec2.DescribeTagsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTagsOutput, error) {
+	output := &ec2.DescribeTagsOutput{}
+	for _, filter := range input.Filters {
+		if aws.ToString(filter.Name) != "resource-id" {
+			continue
+		}
+		for _, id := range filter.Values {
+			for key, value := range m.tags[id] {
+				output.Tags = append(output.Tags, types.TagDescription{
+					ResourceId: aws.String(id),
+					Key:        aws.String(key),
+					Value:      aws.String(value),
+				})
+			}
+		}
+	}
+	return output, nil
+}
+
+// DescribeInstances describes the requested instances, or every instance
+// with tags when none are requested
+func (m *MockEC2) DescribeInstances(ctx context.Context, input *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	ids := input.InstanceIds
+	if len(ids) == 0 {
+		for id := range m.tags {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+
+	var instances []types.Instance
+	for _, id := range ids {
+		instance := types.Instance{
+			InstanceId:     aws.String(id),
+			State:          &types.InstanceState{Name: types.InstanceStateNameRunning},
+			RootDeviceName: aws.String("/dev/xvda"),
+			BlockDeviceMappings: []types.InstanceBlockDeviceMapping{
+				{DeviceName: aws.String("/dev/sdf"), Ebs: &types.EbsInstanceBlockDevice{VolumeId: aws.String("vol-data")}},
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &types.EbsInstanceBlockDevice{VolumeId: aws.String("vol-root")}},
+			},
+		}
+		if requestID, ok := m.spotRequests[id]; ok {
+			instance.SpotInstanceRequestId = aws.String(requestID)
+		}
+		for key, value := range m.tags[id] {
+			instance.Tags = append(instance.Tags, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+		}
+		if code, ok := m.stateReasons[id]; ok {
+			instance.State = &types.InstanceState{Name: types.InstanceStateNameStopped}
+			instance.StateReason = &types.StateReason{Code: aws.String(code)}
+		}
+		instances = append(instances, instance)
+	}
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{{Instances: instances}},
+	}, nil
+}
+
+func (m *MockEC2) CancelSpotInstanceRequests(ctx context.Context, input *ec2.CancelSpotInstanceRequestsInput, optFns ...func(*ec2.Options)) (*ec2.CancelSpotInstanceRequestsOutput, error) {
+	m.cancelSpotCalls = append(m.cancelSpotCalls, input.SpotInstanceRequestIds...)
+	return &ec2.CancelSpotInstanceRequestsOutput{}, nil
+}
+
+func (m *MockEC2) AllocateAddress(ctx context.Context, input *ec2.AllocateAddressInput, optFns ...func(*ec2.Options)) (*ec2.AllocateAddressOutput, error) {
+	id := fmt.Sprintf("eipalloc-%d", len(m.addresses)+1)
+	m.addresses = append(m.addresses, types.Address{
+		AllocationId: aws.String(id),
+		PublicIp:     aws.String("198.51.100.7"),
+		Tags:         input.TagSpecifications[0].Tags,
+	})
+	return &ec2.AllocateAddressOutput{AllocationId: aws.String(id), PublicIp: aws.String("198.51.100.7")}, nil
+}
+
+func (m *MockEC2) AssociateAddress(ctx context.Context, input *ec2.AssociateAddressInput, optFns ...func(*ec2.Options)) (*ec2.AssociateAddressOutput, error) {
+	m.associateCalls = append(m.associateCalls, input)
+	for i := range m.addresses {
+		if aws.ToString(m.addresses[i].AllocationId) == aws.ToString(input.AllocationId) {
+			m.addresses[i].InstanceId = input.InstanceId
+			m.addresses[i].AssociationId = aws.String("eipassoc-1")
+		}
+	}
+	return &ec2.AssociateAddressOutput{AssociationId: aws.String("eipassoc-1")}, nil
+}
+
+// DescribeAddresses matches addresses by the instance-id filter only
+func (m *MockEC2) DescribeAddresses(ctx context.Context, input *ec2.DescribeAddressesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeAddressesOutput, error) {
+	output := &ec2.DescribeAddressesOutput{}
+	for _, address := range m.addresses {
+		for _, filter := range input.Filters {
+			if aws.ToString(filter.Name) == "instance-id" && filter.Values[0] == aws.ToString(address.InstanceId) {
+				output.Addresses = append(output.Addresses, address)
+			}
+		}
+	}
+	return output, nil
+}
+
+func (m *MockEC2) DisassociateAddress(ctx context.Context, input *ec2.DisassociateAddressInput, optFns ...func(*ec2.Options)) (*ec2.DisassociateAddressOutput, error) {
+	return &ec2.DisassociateAddressOutput{}, nil
+}
+
+func (m *MockEC2) ReleaseAddress(ctx context.Context, input *ec2.ReleaseAddressInput, optFns ...func(*ec2.Options)) (*ec2.ReleaseAddressOutput, error) {
+	m.releaseCalls = append(m.releaseCalls, aws.ToString(input.AllocationId))
+	return &ec2.ReleaseAddressOutput{}, nil
+}
+
+func (m *MockEC2) CreateSnapshot(ctx context.Context, input *ec2.CreateSnapshotInput, optFns ...func(*ec2.Options)) (*ec2.CreateSnapshotOutput, error) {
+	m.createSnapCalls = append(m.createSnapCalls, input)
+	return &ec2.CreateSnapshotOutput{SnapshotId: aws.String("snap-123"), VolumeId: input.VolumeId, State: types.SnapshotStatePending}, nil
+}
+
+// DescribeSnapshots reports the next state from snapshotStates, repeating the last one
+func (m *MockEC2) DescribeSnapshots(ctx context.Context, input *ec2.DescribeSnapshotsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSnapshotsOutput, error) {
+	state := m.snapshotStates[len(m.snapshotStates)-1]
+	if m.describeSnapCalls < len(m.snapshotStates) {
+		state = m.snapshotStates[m.describeSnapCalls]
+	}
+	m.describeSnapCalls++
+	return &ec2.DescribeSnapshotsOutput{
+		Snapshots: []types.Snapshot{{SnapshotId: aws.String(input.SnapshotIds[0]), State: state}},
+	}, nil
+}
+
+func (m *MockEC2) DeleteSnapshot(ctx context.Context, input *ec2.DeleteSnapshotInput, optFns ...func(*ec2.Options)) (*ec2.DeleteSnapshotOutput, error) {
+	m.deleteSnapCalls = append(m.deleteSnapCalls, aws.ToString(input.SnapshotId))
+	return &ec2.DeleteSnapshotOutput{}, nil
+}
+
+func (m *MockEC2) TerminateInstances(ctx context.Context, input *ec2.TerminateInstancesInput, optFns ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error) {
+	m.terminateCalls = append(m.terminateCalls, input.InstanceIds...)
+	return &ec2.TerminateInstancesOutput{}, nil
+}
+
+func (m *MockEC2) CreateTags(ctx context.Context, input *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	m.createTagCalls = append(m.createTagCalls, input)
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func TestRetagInstance_MissingTags(t *testing.T) {
+	mock := NewMockEC2()
+	mock.tags["i-untagged"] = map[string]string{
+		"Name":      "instance-manager",
+		"ManagedBy": "instance-manager",
+	}
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	expiresAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	instance := &models.Instance{
+		ID:        "i-untagged",
+		Duration:  2 * time.Hour,
+		ExpiresAt: expiresAt,
+	}
+
+	added, err := provider.RetagInstance(context.Background(), instance, false)
+	if err != nil {
+		t.Fatalf("RetagInstance failed: %v", err)
+	}
+
+	if len(mock.createTagCalls) != 1 {
+		t.Fatalf("Expected 1 CreateTags call, got %d", len(mock.createTagCalls))
+	}
+
+	call := mock.createTagCalls[0]
+	if len(call.Resources) != 1 || call.Resources[0] != "i-untagged" {
+		t.Errorf("Expected CreateTags for i-untagged, got %v", call.Resources)
+	}
+
+	applied := make(map[string]string)
+	for _, tag := range call.Tags {
+		applied[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	expected := map[string]string{
+		"Duration":  "2h0m0s",
+		"ExpiresAt": "2025-01-01T12:00:00Z",
+	}
+	if len(applied) != len(expected) {
+		t.Errorf("Expected %d tags, got %d: %v", len(expected), len(applied), applied)
+	}
+	for key, value := range expected {
+		if applied[key] != value {
+			t.Errorf("Tag %s: got %q, want %q", key, applied[key], value)
+		}
+		if added[key] != value {
+			t.Errorf("Returned tag %s: got %q, want %q", key, added[key], value)
+		}
+	}
+}
+
+func TestRetagInstance_FullyTagged(t *testing.T) {
+	mock := NewMockEC2()
+	mock.tags["i-tagged"] = map[string]string{
+		"Name":      "instance-manager",
+		"ManagedBy": "instance-manager",
+		"Duration":  "1h0m0s",
+		"ExpiresAt": "2025-01-01T12:00:00Z",
+	}
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	instance := &models.Instance{
+		ID:        "i-tagged",
+		Duration:  1 * time.Hour,
+		ExpiresAt: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	added, err := provider.RetagInstance(context.Background(), instance, false)
+	if err != nil {
+		t.Fatalf("RetagInstance failed: %v", err)
+	}
+
+	if len(added) != 0 {
+		t.Errorf("Expected no missing tags, got %v", added)
+	}
+	if len(mock.createTagCalls) != 0 {
+		t.Errorf("Expected no CreateTags calls, got %d", len(mock.createTagCalls))
+	}
+}
+
+func TestRetagInstance_DryRun(t *testing.T) {
+	mock := NewMockEC2()
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	instance := &models.Instance{
+		ID:        "i-dryrun",
+		Duration:  1 * time.Hour,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	added, err := provider.RetagInstance(context.Background(), instance, true)
+	if err != nil {
+		t.Fatalf("RetagInstance failed: %v", err)
+	}
+
+	if len(added) != 4 {
+		t.Errorf("Expected 4 missing tags, got %d: %v", len(added), added)
+	}
+	if len(mock.createTagCalls) != 0 {
+		t.Errorf("Expected no CreateTags calls in dry run, got %d", len(mock.createTagCalls))
+	}
+}
+
+func TestCreateInstance_WithKeyName(t *testing.T) {
+	mock := NewMockEC2()
+	mock.keyPairs["team-key"] = true
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:     "t2.nano",
+		Duration:         time.Hour,
+		KeyName:          "team-key",
+		AvailabilityZone: "us-east-1a",
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	if len(mock.importKeyCalls) != 0 {
+		t.Errorf("Expected no ImportKeyPair calls with --key-name, got %d", len(mock.importKeyCalls))
+	}
+	if instance.KeyName != "team-key" {
+		t.Errorf("KeyName mismatch: got %s, want team-key", instance.KeyName)
+	}
+	if len(mock.runInstancesCalls) != 1 || aws.ToString(mock.runInstancesCalls[0].KeyName) != "team-key" {
+		t.Errorf("Expected RunInstances with key team-key")
+	}
+}
+
+func TestCreateInstance_Spot(t *testing.T) {
+	mock := NewMockEC2()
+	mock.keyPairs["team-key"] = true
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:     "t3.micro",
+		Duration:         time.Hour,
+		KeyName:          "team-key",
+		AvailabilityZone: "us-east-1a",
+		Spot:             true,
+		SpotMaxPrice:     "0.005",
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	market := mock.runInstancesCalls[0].InstanceMarketOptions
+	if market == nil || market.MarketType != types.MarketTypeSpot || market.SpotOptions == nil {
+		t.Fatalf("Expected a spot market request, got %+v", market)
+	}
+	if aws.ToString(market.SpotOptions.MaxPrice) != "0.005" {
+		t.Errorf("Expected max price 0.005, got %q", aws.ToString(market.SpotOptions.MaxPrice))
+	}
+	if market.SpotOptions.InstanceInterruptionBehavior != types.InstanceInterruptionBehaviorStop {
+		t.Errorf("Expected interrupted instances to be stopped, got %q", market.SpotOptions.InstanceInterruptionBehavior)
+	}
+	if instance.SpotRequestID != "sir-new123" {
+		t.Errorf("Expected spot request sir-new123 to be recorded, got %q", instance.SpotRequestID)
+	}
+}
+
+func TestCreateInstance_OS(t *testing.T) {
+	mock := NewMockEC2()
+	mock.keyPairs["team-key"] = true
+	provider := awsprovider.NewProviderWithClient(mock, "eu-west-1")
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:     "t3.micro",
+		Duration:         time.Hour,
+		KeyName:          "team-key",
+		AvailabilityZone: "eu-west-1a",
+		OS:               "ubuntu-22.04",
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	if len(mock.describeImageCalls) != 1 || mock.describeImageCalls[0].Owners[0] != "099720109477" {
+		t.Errorf("Expected the AMI to be looked up among Canonical's images, got %+v", mock.describeImageCalls)
+	}
+	if instance.Username != "ubuntu" || instance.OS != "ubuntu-22.04" {
+		t.Errorf("Expected an ubuntu-22.04 instance with user ubuntu, got %s with user %s", instance.OS, instance.Username)
+	}
+
+	tagged := false
+	for _, tag := range mock.runInstancesCalls[0].TagSpecifications[0].Tags {
+		tagged = tagged || (aws.ToString(tag.Key) == "OS" && aws.ToString(tag.Value) == "ubuntu-22.04")
+	}
+	if !tagged {
+		t.Error("Expected the instance to be tagged with its OS")
+	}
+
+	mock.tags["i-ubuntu"] = map[string]string{"OS": "ubuntu-22.04"}
+	status, err := provider.GetInstanceStatus(context.Background(), "i-ubuntu")
+	if err != nil {
+		t.Fatalf("GetInstanceStatus failed: %v", err)
+	}
+	if status.Username != "ubuntu" {
+		t.Errorf("Expected status username ubuntu from the OS tag, got %s", status.Username)
+	}
+
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{KeyName: "team-key", OS: "windows-95"}); err == nil {
+		t.Error("Expected an error for an unknown OS")
+	}
+}
+
+func TestCreateInstance_VPCAndSubnet(t *testing.T) {
+	mock := NewMockEC2()
+	mock.keyPairs["team-key"] = true
+	mock.subnets = append(mock.subnets,
+		types.Subnet{SubnetId: aws.String("subnet-app"), VpcId: aws.String("vpc-app"), AvailabilityZone: aws.String("us-east-1c")},
+	)
+	mock.securityGroups = append(mock.securityGroups,
+		types.SecurityGroup{GroupId: aws.String("sg-app"), GroupName: aws.String("instance-manager-sg"), VpcId: aws.String("vpc-app")},
+	)
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	tests := []struct {
+		name     string
+		vpcID    string
+		subnetID string
+		wantErr  bool
+	}{
+		{name: "subnet only", subnetID: "subnet-app"},
+		{name: "VPC only", vpcID: "vpc-app"},
+		{name: "matching VPC and subnet", vpcID: "vpc-app", subnetID: "subnet-app"},
+		{name: "subnet outside VPC", vpcID: "vpc-app", subnetID: "subnet-123", wantErr: true},
+		{name: "unknown subnet", subnetID: "subnet-missing", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.runInstancesCalls = nil
+			instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+				InstanceType:     "t3.micro",
+				Duration:         time.Hour,
+				KeyName:          "team-key",
+				AvailabilityZone: "us-east-1a",
+				VPCID:            tt.vpcID,
+				SubnetID:         tt.subnetID,
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				if len(mock.runInstancesCalls) != 0 {
+					t.Error("Expected no instance to be launched")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateInstance failed: %v", err)
+			}
+
+			networkInterface := mock.runInstancesCalls[0].NetworkInterfaces[0]
+			if aws.ToString(networkInterface.SubnetId) != "subnet-app" {
+				t.Errorf("Expected launch into subnet-app, got %s", aws.ToString(networkInterface.SubnetId))
+			}
+			if !slices.Equal(networkInterface.Groups, []string{"sg-app"}) {
+				t.Errorf("Expected the managed group of vpc-app, got %v", networkInterface.Groups)
+			}
+			if instance.VPCID != "vpc-app" || instance.SubnetID != "subnet-app" || instance.AvailabilityZone != "us-east-1c" {
+				t.Errorf("Expected vpc-app/subnet-app in us-east-1c, got %s/%s in %s", instance.VPCID, instance.SubnetID, instance.AvailabilityZone)
+			}
+		})
+	}
+}
+
+func TestCreateInstance_ElasticIP(t *testing.T) {
+	mock := NewMockEC2()
+	mock.keyPairs["team-key"] = true
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:     "t3.micro",
+		Duration:         time.Hour,
+		KeyName:          "team-key",
+		AvailabilityZone: "us-east-1a",
+		ElasticIP:        true,
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	if instance.ElasticIPAllocationID != "eipalloc-1" || instance.PublicIP != "198.51.100.7" {
+		t.Errorf("Expected Elastic IP 198.51.100.7 (eipalloc-1), got %s (%s)", instance.PublicIP, instance.ElasticIPAllocationID)
+	}
+	if len(mock.associateCalls) != 1 || aws.ToString(mock.associateCalls[0].InstanceId) != instance.ID {
+		t.Fatalf("Expected the address to be associated with %s, got %+v", instance.ID, mock.associateCalls)
+	}
+
+	if err := provider.TerminateInstance(context.Background(), instance.ID); err != nil {
+		t.Fatalf("TerminateInstance failed: %v", err)
+	}
+	if !slices.Equal(mock.releaseCalls, []string{"eipalloc-1"}) {
+		t.Errorf("Expected eipalloc-1 to be released on terminate, got %v", mock.releaseCalls)
+	}
+}
+
+func TestCreateInstance_Tags(t *testing.T) {
+	mock := NewMockEC2()
+	mock.keyPairs["team-key"] = true
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	config := models.InstanceConfig{
+		InstanceType:     "t3.micro",
+		Duration:         time.Hour,
+		KeyName:          "team-key",
+		AvailabilityZone: "us-east-1a",
+		Tags:             map[string]string{"team": "data", "cost-center": "1234"},
+	}
+	instance, err := provider.CreateInstance(context.Background(), config)
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	if instance.Tags["team"] != "data" {
+		t.Errorf("Expected the tags to be recorded on the instance, got %v", instance.Tags)
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range mock.runInstancesCalls[0].TagSpecifications[0].Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	if tags["team"] != "data" || tags["cost-center"] != "1234" || tags["ManagedBy"] != "instance-manager" {
+		t.Errorf("Expected the user tags alongside the managed ones, got %v", tags)
+	}
+
+	mock.tags["i-tagged"] = map[string]string{"ManagedBy": "instance-manager", "team": "data", "aws:ec2launchtemplate:id": "lt-1"}
+	instances, err := provider.ListInstances(context.Background())
+	if err != nil {
+		t.Fatalf("ListInstances failed: %v", err)
+	}
+	if len(instances) != 1 || len(instances[0].Tags) != 1 || instances[0].Tags["team"] != "data" {
+		t.Errorf("Expected only the user tag team=data to be read back, got %+v", instances)
+	}
+
+	config.Tags = map[string]string{"ExpiresAt": "never"}
+	if _, err := provider.CreateInstance(context.Background(), config); err == nil {
+		t.Error("Expected an error for overriding a managed tag")
+	}
+}
+
+func TestCreateInstance_Name(t *testing.T) {
+	mock := NewMockEC2()
+	mock.keyPairs["team-key"] = true
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:     "t3.micro",
+		Duration:         time.Hour,
+		KeyName:          "team-key",
+		AvailabilityZone: "us-east-1a",
+		Name:             "build-box",
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	if instance.Name != "build-box" {
+		t.Errorf("Expected the instance to be named build-box, got %q", instance.Name)
+	}
+	for _, tag := range mock.runInstancesCalls[0].TagSpecifications[0].Tags {
+		if aws.ToString(tag.Key) == "Name" && aws.ToString(tag.Value) != "build-box" {
+			t.Errorf("Expected Name tag build-box, got %s", aws.ToString(tag.Value))
+		}
+	}
+
+	mock.tags["i-named"] = map[string]string{"Name": "build-box"}
+	mock.tags["i-unnamed"] = map[string]string{"Name": "instance-manager"}
+	instances, err := provider.ListInstances(context.Background())
+	if err != nil {
+		t.Fatalf("ListInstances failed: %v", err)
+	}
+	if len(instances) != 2 || instances[0].Name != "build-box" || instances[1].Name != "" {
+		t.Errorf("Expected names build-box and none from the Name tags, got %+v", instances)
+	}
+}
+
+func TestInstanceArchitecture(t *testing.T) {
+	tests := map[string]string{
+		"t2.nano":    awsprovider.ArchX86_64,
+		"m5.large":   awsprovider.ArchX86_64,
+		"g4dn.large": awsprovider.ArchX86_64,
+		"t4g.micro":  awsprovider.ArchARM64,
+		"m7g.large":  awsprovider.ArchARM64,
+		"c6gn.large": awsprovider.ArchARM64,
+		"a1.medium":  awsprovider.ArchARM64,
+	}
+	for instanceType, want := range tests {
+		if got := awsprovider.InstanceArchitecture(instanceType); got != want {
+			t.Errorf("InstanceArchitecture(%q) = %s, want %s", instanceType, got, want)
+		}
+	}
+}
+
+func TestCreateInstance_ARM64(t *testing.T) {
+	mock := NewMockEC2()
+	mock.keyPairs["team-key"] = true
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	_, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:     "t4g.micro",
+		Duration:         time.Hour,
+		KeyName:          "team-key",
+		AvailabilityZone: "us-east-1a",
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	filters := make(map[string]string)
+	for _, filter := range mock.describeImageCalls[0].Filters {
+		filters[aws.ToString(filter.Name)] = filter.Values[0]
+	}
+	if filters["name"] != "amzn2-ami-hvm-*-arm64-gp2" || filters["architecture"] != "arm64" {
+		t.Errorf("Expected an arm64 Amazon Linux 2 lookup, got %v", filters)
+	}
+}
+
+func TestCreateInstance_GPU(t *testing.T) {
+	mock := NewMockEC2()
+	mock.keyPairs["team-key"] = true
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:     "g5.12xlarge",
+		OS:               "dlami-ubuntu-22.04",
+		Duration:         time.Hour,
+		KeyName:          "team-key",
+		AvailabilityZone: "us-east-1a",
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	if instance.GPUCount != 4 || instance.GPUModel != "NVIDIA A10G" {
+		t.Errorf("Expected 4 x NVIDIA A10G, got %d x %q", instance.GPUCount, instance.GPUModel)
+	}
+	if instance.Username != "ubuntu" {
+		t.Errorf("Expected username ubuntu, got %s", instance.Username)
+	}
+
+	filters := make(map[string]string)
+	for _, filter := range mock.describeImageCalls[0].Filters {
+		filters[aws.ToString(filter.Name)] = filter.Values[0]
+	}
+	if filters["name"] != "Deep Learning Base OSS Nvidia Driver GPU AMI (Ubuntu 22.04) *" || filters["architecture"] != "x86_64" {
+		t.Errorf("Expected an x86_64 Deep Learning AMI lookup, got %v", filters)
+	}
+
+	if _, ok := awsprovider.InstanceGPUs("t3.micro"); ok {
+		t.Error("Expected t3.micro to have no GPUs")
+	}
+}
+
+func TestCreateImage(t *testing.T) {
+	mock := NewMockEC2()
+	mock.tags["i-abc"] = map[string]string{"ManagedBy": "instance-manager", "OS": "ubuntu-22.04"}
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	record, err := provider.CreateImage(context.Background(), "i-abc", "")
+	if err != nil {
+		t.Fatalf("CreateImage failed: %v", err)
+	}
+	if record.ImageID != "ami-0backup0000000000" || record.OS != "ubuntu-22.04" || record.Region != "us-east-1" {
+		t.Errorf("Unexpected image record: %+v", record)
+	}
+	if !strings.HasPrefix(record.Name, "instance-manager-i-abc-") {
+		t.Errorf("Expected a generated name for i-abc, got %s", record.Name)
+	}
+
+	input := mock.createImageCalls[0]
+	if !aws.ToBool(input.NoReboot) {
+		t.Error("Expected the image to be created without rebooting the instance")
+	}
+	if len(input.TagSpecifications) != 2 {
+		t.Fatalf("Expected image and snapshot tag specifications, got %v", input.TagSpecifications)
+	}
+	tags := make(map[string]string)
+	for _, tag := range input.TagSpecifications[0].Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	if tags["InstanceId"] != "i-abc" || tags["OS"] != "ubuntu-22.04" {
+		t.Errorf("Unexpected image tags: %v", tags)
+	}
+}
+
+func TestCreateInstance_FromImage(t *testing.T) {
+	mock := NewMockEC2()
+	mock.keyPairs["team-key"] = true
+	mock.images = []types.Image{
+		{
+			ImageId:      aws.String("ami-0backup0000000000"),
+			Architecture: types.ArchitectureValuesX8664,
+			Tags:         []types.Tag{{Key: aws.String("OS"), Value: aws.String("ubuntu-22.04")}},
+		},
+	}
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	config := models.InstanceConfig{
+		InstanceType:     "t3.micro",
+		Duration:         time.Hour,
+		KeyName:          "team-key",
+		AvailabilityZone: "us-east-1a",
+		ImageID:          "ami-0backup0000000000",
+	}
+	instance, err := provider.CreateInstance(context.Background(), config)
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	if got := aws.ToString(mock.runInstancesCalls[0].ImageId); got != "ami-0backup0000000000" {
+		t.Errorf("Expected the source image to be launched, got %s", got)
+	}
+	if instance.OS != "ubuntu-22.04" || instance.Username != "ubuntu" {
+		t.Errorf("Expected the image's OS and user, got %s/%s", instance.OS, instance.Username)
+	}
+
+	// The image must match the instance type's architecture
+	config.InstanceType = "t4g.micro"
+	if _, err := provider.CreateInstance(context.Background(), config); err == nil || !strings.Contains(err.Error(), "built for x86_64") {
+		t.Errorf("Expected an architecture mismatch error, got %v", err)
+	}
+
+	config.ImageID = "ami-0missing000000000"
+	if _, err := provider.CreateInstance(context.Background(), config); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected a missing image error, got %v", err)
+	}
+}
+
+func TestCreateInstance_Hibernate(t *testing.T) {
+	mock := NewMockEC2()
+	mock.keyPairs["team-key"] = true
+	mock.instanceTypes = []types.InstanceTypeInfo{
+		{InstanceType: "t3.large", HibernationSupported: aws.Bool(true), MemoryInfo: &types.MemoryInfo{SizeInMiB: aws.Int64(8192)}},
+		{InstanceType: "t3.nano", HibernationSupported: aws.Bool(false), MemoryInfo: &types.MemoryInfo{SizeInMiB: aws.Int64(512)}},
+	}
+	mock.images = []types.Image{
+		{
+			ImageId:        aws.String("ami-123"),
+			RootDeviceName: aws.String("/dev/xvda"),
+			BlockDeviceMappings: []types.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &types.EbsBlockDevice{VolumeSize: aws.Int32(8)}},
+			},
+		},
+	}
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	config := models.InstanceConfig{
+		InstanceType:     "t3.large",
+		Duration:         time.Hour,
+		KeyName:          "team-key",
+		AvailabilityZone: "us-east-1a",
+		ExpiryAction:     models.ExpiryActionHibernate,
+	}
+	instance, err := provider.CreateInstance(context.Background(), config)
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	if instance.ExpiryAction != models.ExpiryActionHibernate {
+		t.Errorf("Expected the hibernate expiry action to be recorded, got %q", instance.ExpiryAction)
+	}
+
+	input := mock.runInstancesCalls[0]
+	if input.HibernationOptions == nil || !aws.ToBool(input.HibernationOptions.Configured) {
+		t.Error("Expected a hibernation enabled launch")
+	}
+	if len(input.BlockDeviceMappings) != 1 {
+		t.Fatalf("Expected the root volume mapping, got %d mappings", len(input.BlockDeviceMappings))
+	}
+	root := input.BlockDeviceMappings[0]
+	if aws.ToString(root.DeviceName) != "/dev/xvda" || !aws.ToBool(root.Ebs.Encrypted) || aws.ToInt32(root.Ebs.VolumeSize) != 16 {
+		t.Errorf("Expected an encrypted 16 GiB root volume on /dev/xvda, got %s: %+v", aws.ToString(root.DeviceName), root.Ebs)
+	}
+
+	config.InstanceType = "t3.nano"
+	if _, err := provider.CreateInstance(context.Background(), config); err == nil || !strings.Contains(err.Error(), "does not support hibernation") {
+		t.Errorf("Expected an unsupported hibernation error, got %v", err)
+	}
+
+	if err := provider.HibernateInstance(context.Background(), "i-new123"); err != nil {
+		t.Fatalf("HibernateInstance failed: %v", err)
+	}
+	if len(mock.stopCalls) != 1 || !aws.ToBool(mock.stopCalls[0].Hibernate) {
+		t.Errorf("Expected a hibernating stop, got %+v", mock.stopCalls)
+	}
+}
+
+func TestCreateInstance_MetadataOptions(t *testing.T) {
+	mock := NewMockEC2()
+	mock.keyPairs["team-key"] = true
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	config := models.InstanceConfig{
+		InstanceType:     "t3.micro",
+		Duration:         time.Hour,
+		KeyName:          "team-key",
+		AvailabilityZone: "us-east-1a",
+	}
+	if _, err := provider.CreateInstance(context.Background(), config); err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	options := mock.runInstancesCalls[0].MetadataOptions
+	if options == nil || options.HttpTokens != types.HttpTokensStateRequired {
+		t.Fatalf("Expected IMDSv2 to be required by default, got %+v", options)
+	}
+	if options.HttpPutResponseHopLimit != nil || options.HttpEndpoint != "" {
+		t.Errorf("Expected the hop limit and endpoint to be left unset, got %+v", options)
+	}
+
+	config.Metadata = models.MetadataOptions{HTTPTokens: models.MetadataTokensOptional, HopLimit: 2, Endpoint: models.MetadataEndpointDisabled}
+	if _, err := provider.CreateInstance(context.Background(), config); err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	options = mock.runInstancesCalls[1].MetadataOptions
+	if options.HttpTokens != types.HttpTokensStateOptional || aws.ToInt32(options.HttpPutResponseHopLimit) != 2 || options.HttpEndpoint != types.InstanceMetadataEndpointStateDisabled {
+		t.Errorf("Unexpected metadata options %+v", options)
+	}
+}
+
+func TestCreateInstance_ExtraVolumes(t *testing.T) {
+	mock := NewMockEC2()
+	mock.keyPairs["team-key"] = true
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	_, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:     "t3.micro",
+		Duration:         time.Hour,
+		KeyName:          "team-key",
+		AvailabilityZone: "us-east-1a",
+		ExtraVolumes:     []models.VolumeSpec{{SizeGiB: 100, Type: "gp3", Device: "/dev/xvdf"}},
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	mappings := mock.runInstancesCalls[0].BlockDeviceMappings
+	if len(mappings) != 1 {
+		t.Fatalf("Expected 1 block device mapping, got %d", len(mappings))
+	}
+	ebs := mappings[0].Ebs
+	if aws.ToString(mappings[0].DeviceName) != "/dev/xvdf" || aws.ToInt32(ebs.VolumeSize) != 100 || ebs.VolumeType != types.VolumeTypeGp3 {
+		t.Errorf("Unexpected mapping %s: %+v", aws.ToString(mappings[0].DeviceName), ebs)
+	}
+	if !aws.ToBool(ebs.DeleteOnTermination) {
+		t.Error("Expected the volume to be deleted with the instance")
+	}
+
+	status, err := provider.GetInstanceStatus(context.Background(), "i-new123")
+	if err != nil {
+		t.Fatalf("GetInstanceStatus failed: %v", err)
+	}
+	if len(status.VolumeIDs) != 1 || status.VolumeIDs[0] != "vol-data" {
+		t.Errorf("Expected the data volume vol-data without the root volume, got %v", status.VolumeIDs)
+	}
+
+	_, err = provider.CreateInstance(context.Background(), models.InstanceConfig{
+		KeyName:      "team-key",
+		ExtraVolumes: []models.VolumeSpec{{SizeGiB: 10, Type: "ssd", Device: "/dev/sdf"}},
+	})
+	if err == nil {
+		t.Error("Expected an error for an unknown volume type")
+	}
+}
+
+func TestSpotInstance_InterruptionAndTermination(t *testing.T) {
+	mock := NewMockEC2()
+	mock.spotRequests["i-spot"] = "sir-123"
+	mock.stateReasons["i-spot"] = "Server.SpotInstanceShutdown"
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	status, err := provider.GetInstanceStatus(context.Background(), "i-spot")
+	if err != nil {
+		t.Fatalf("GetInstanceStatus failed: %v", err)
+	}
+	if !status.Interrupted {
+		t.Error("Expected the spot instance to be reported as interrupted")
+	}
+
+	if err := provider.TerminateInstance(context.Background(), "i-spot"); err != nil {
+		t.Fatalf("TerminateInstance failed: %v", err)
+	}
+	if len(mock.cancelSpotCalls) != 1 || mock.cancelSpotCalls[0] != "sir-123" {
+		t.Errorf("Expected spot request sir-123 to be cancelled, got %v", mock.cancelSpotCalls)
+	}
+	if len(mock.terminateCalls) != 1 {
+		t.Errorf("Expected the instance to be terminated, got %v", mock.terminateCalls)
+	}
+}
+
+func TestCreateInstance_WithMissingKeyName(t *testing.T) {
+	mock := NewMockEC2()
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	_, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:     "t2.nano",
+		Duration:         time.Hour,
+		KeyName:          "missing-key",
+		AvailabilityZone: "us-east-1a",
+	})
+	if err == nil {
+		t.Fatal("Expected error for nonexistent key name")
+	}
+
+	if len(mock.importKeyCalls) != 0 {
+		t.Errorf("Expected no ImportKeyPair calls, got %d", len(mock.importKeyCalls))
+	}
+	if len(mock.runInstancesCalls) != 0 {
+		t.Errorf("Expected no RunInstances calls, got %d", len(mock.runInstancesCalls))
+	}
+}
+
+func TestCreateInstance_ImportsPublicKey(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "id_rsa.pub")
+	if err := os.WriteFile(keyPath, []byte("ssh-rsa AAAA test@example.com"), 0644); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	mock := NewMockEC2()
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:     "t2.nano",
+		Duration:         time.Hour,
+		PublicKeyPath:    keyPath,
+		AvailabilityZone: "us-east-1a",
+	}); err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	if len(mock.importKeyCalls) != 1 {
+		t.Errorf("Expected 1 ImportKeyPair call, got %d", len(mock.importKeyCalls))
+	}
+}
+
+func TestCreateInstance_RecordsSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(tracerProvider)
+	defer func() {
+		_ = tracerProvider.Shutdown(context.Background())
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	}()
+
+	mock := NewMockEC2()
+	mock.keyPairs["team-key"] = true
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:     "t2.nano",
+		Duration:         time.Hour,
+		KeyName:          "team-key",
+		AvailabilityZone: "us-east-1a",
+	}); err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+
+	span := spans[0]
+	if span.Name != "aws.CreateInstance" {
+		t.Errorf("Span name: got %s, want aws.CreateInstance", span.Name)
+	}
+
+	attrs := make(map[attribute.Key]string)
+	for _, attr := range span.Attributes {
+		attrs[attr.Key] = attr.Value.Emit()
+	}
+	if attrs[tracing.AttrInstanceID] != "i-new123" {
+		t.Errorf("instance.id attribute: got %q, want i-new123", attrs[tracing.AttrInstanceID])
+	}
+	if attrs[tracing.AttrOperation] != "aws.CreateInstance" {
+		t.Errorf("operation attribute: got %q, want aws.CreateInstance", attrs[tracing.AttrOperation])
+	}
+}
+
+func TestPreviewSecurityGroup(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     models.InstanceConfig
+		wantID     string
+		wantName   string
+		wantReused bool
+		wantRules  []string
+		wantAttach []string
+		wantErr    bool
+	}{
+		{
+			name:      "default SSH rule",
+			config:    models.InstanceConfig{},
+			wantID:    "sg-123",
+			wantName:  "instance-manager-sg",
+			wantRules: []string{"tcp/22 from 0.0.0.0/0"},
+		},
+		{
+			name:      "custom open ports",
+			config:    models.InstanceConfig{OpenPorts: []int64{443, 22, 80, 443}},
+			wantName:  "instance-manager-sg-22-80-443",
+			wantRules: []string{"tcp/22 from 0.0.0.0/0", "tcp/80 from 0.0.0.0/0", "tcp/443 from 0.0.0.0/0"},
+		},
+		{
+			name:       "reuse existing group",
+			config:     models.InstanceConfig{SecurityGroupID: "sg-web", OpenPorts: []int64{8080}},
+			wantID:     "sg-web",
+			wantName:   "web-servers",
+			wantReused: true,
+			wantRules:  []string{"tcp/80-81 from 10.0.0.0/8", "tcp/80-81 from sg-lb", "all traffic from ::/0"},
+		},
+		{
+			name:      "restricted SSH source",
+			config:    models.InstanceConfig{OpenPorts: []int64{22, 443}, SSHCIDRs: []string{"203.0.113.7/24", "198.51.100.0/24"}},
+			wantName:  "instance-manager-sg-a39b906517e3",
+			wantRules: []string{"tcp/22 from 198.51.100.0/24", "tcp/22 from 203.0.113.0/24", "tcp/443 from 0.0.0.0/0"},
+		},
+		{
+			name:       "attach existing groups",
+			config:     models.InstanceConfig{ExtraSecurityGroupIDs: []string{"sg-web", "sg-web"}},
+			wantID:     "sg-123",
+			wantName:   "instance-manager-sg",
+			wantRules:  []string{"tcp/22 from 0.0.0.0/0"},
+			wantAttach: []string{"sg-web"},
+		},
+		{
+			name:    "unknown group",
+			config:  models.InstanceConfig{SecurityGroupID: "sg-missing"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown attached group",
+			config:  models.InstanceConfig{ExtraSecurityGroupIDs: []string{"sg-missing"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid SSH CIDR",
+			config:  models.InstanceConfig{SSHCIDRs: []string{"203.0.113.7"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid port",
+			config:  models.InstanceConfig{OpenPorts: []int64{70000}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := NewMockEC2()
+			mock.securityGroups = append(mock.securityGroups, types.SecurityGroup{
+				GroupId:   aws.String("sg-web"),
+				GroupName: aws.String("web-servers"),
+				IpPermissions: []types.IpPermission{
+					{
+						IpProtocol:       aws.String("tcp"),
+						FromPort:         aws.Int32(80),
+						ToPort:           aws.Int32(81),
+						IpRanges:         []types.IpRange{{CidrIp: aws.String("10.0.0.0/8")}},
+						UserIdGroupPairs: []types.UserIdGroupPair{{GroupId: aws.String("sg-lb")}},
+					},
+					{
+						IpProtocol: aws.String("-1"),
+						Ipv6Ranges: []types.Ipv6Range{{CidrIpv6: aws.String("::/0")}},
+					},
+				},
+			})
+			provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+			plan, err := provider.PreviewSecurityGroup(context.Background(), tt.config)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PreviewSecurityGroup failed: %v", err)
+			}
+
+			if plan.GroupID != tt.wantID {
+				t.Errorf("Expected group ID %q, got %q", tt.wantID, plan.GroupID)
+			}
+			if plan.GroupName != tt.wantName {
+				t.Errorf("Expected group name %q, got %q", tt.wantName, plan.GroupName)
+			}
+			if plan.Reused != tt.wantReused {
+				t.Errorf("Expected reused %v, got %v", tt.wantReused, plan.Reused)
+			}
+
+			var rules []string
+			for _, rule := range plan.Rules {
+				rules = append(rules, rule.String())
+			}
+			if strings.Join(rules, ", ") != strings.Join(tt.wantRules, ", ") {
+				t.Errorf("Expected rules %v, got %v", tt.wantRules, rules)
+			}
+			if !slices.Equal(plan.AttachedGroupIDs, tt.wantAttach) {
+				t.Errorf("Expected attached groups %v, got %v", tt.wantAttach, plan.AttachedGroupIDs)
+			}
+		})
+	}
+}
+
+func TestSnapshotRootVolume_CreateInput(t *testing.T) {
+	mock := NewMockEC2()
+	mock.snapshotStates = []types.SnapshotState{types.SnapshotStateCompleted}
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+	provider.SetSnapshotWait(time.Millisecond, time.Second)
+
+	snapshotID, err := provider.SnapshotRootVolume(context.Background(), "i-abc")
+	if err != nil {
+		t.Fatalf("SnapshotRootVolume failed: %v", err)
+	}
+	if snapshotID != "snap-123" {
+		t.Errorf("Expected snapshot snap-123, got %s", snapshotID)
+	}
+
+	if len(mock.createSnapCalls) != 1 {
+		t.Fatalf("Expected 1 CreateSnapshot call, got %d", len(mock.createSnapCalls))
+	}
+	input := mock.createSnapCalls[0]
+	if aws.ToString(input.VolumeId) != "vol-root" {
+		t.Errorf("Expected the root volume vol-root to be snapshotted, got %s", aws.ToString(input.VolumeId))
+	}
+	if len(input.TagSpecifications) != 1 || input.TagSpecifications[0].ResourceType != types.ResourceTypeSnapshot {
+		t.Fatalf("Expected snapshot tag specification, got %v", input.TagSpecifications)
+	}
+	tags := make(map[string]string)
+	for _, tag := range input.TagSpecifications[0].Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	if tags["InstanceId"] != "i-abc" || tags["ManagedBy"] != "instance-manager" {
+		t.Errorf("Unexpected snapshot tags: %v", tags)
+	}
+}
+
+func TestSnapshotRootVolume_WaitsForCompletion(t *testing.T) {
+	tests := []struct {
+		name      string
+		states    []types.SnapshotState
+		timeout   time.Duration
+		wantErr   bool
+		wantPolls int
+	}{
+		{name: "completes after pending", states: []types.SnapshotState{types.SnapshotStatePending, types.SnapshotStatePending, types.SnapshotStateCompleted}, timeout: time.Second, wantPolls: 3},
+		{name: "snapshot error", states: []types.SnapshotState{types.SnapshotStatePending, types.SnapshotStateError}, timeout: time.Second, wantErr: true, wantPolls: 2},
+		{name: "times out", states: []types.SnapshotState{types.SnapshotStatePending}, timeout: 20 * time.Millisecond, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := NewMockEC2()
+			mock.snapshotStates = tt.states
+			provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+			provider.SetSnapshotWait(5*time.Millisecond, tt.timeout)
+
+			snapshotID, err := provider.SnapshotRootVolume(context.Background(), "i-abc")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SnapshotRootVolume error = %v, want error %v", err, tt.wantErr)
+			}
+			if snapshotID != "snap-123" {
+				t.Errorf("Expected the snapshot ID to be returned, got %q", snapshotID)
+			}
+			if tt.wantPolls > 0 && mock.describeSnapCalls != tt.wantPolls {
+				t.Errorf("Expected %d polls, got %d", tt.wantPolls, mock.describeSnapCalls)
+			}
+		})
+	}
+}
+
+func TestSnapshotVolumes(t *testing.T) {
+	mock := NewMockEC2()
+	provider := awsprovider.NewProviderWithClient(mock, "us-east-1")
+
+	records, err := provider.SnapshotVolumes(context.Background(), "i-abc")
+	if err != nil {
+		t.Fatalf("SnapshotVolumes failed: %v", err)
+	}
+
+	var volumes []string
+	for _, input := range mock.createSnapCalls {
+		volumes = append(volumes, aws.ToString(input.VolumeId))
+	}
+	if !slices.Equal(volumes, []string{"vol-data", "vol-root"}) {
+		t.Errorf("Expected the data and root volumes to be snapshotted, got %v", volumes)
+	}
+	if mock.describeSnapCalls != 0 {
+		t.Error("Expected SnapshotVolumes not to wait for the snapshots")
+	}
+	if len(records) != 2 || records[1].VolumeID != "vol-root" || records[1].InstanceID != "i-abc" || records[1].Region != "us-east-1" {
+		t.Errorf("Unexpected snapshot records: %+v", records)
+	}
+
+	if err := provider.DeleteSnapshot(context.Background(), "snap-123"); err != nil {
+		t.Fatalf("DeleteSnapshot failed: %v", err)
+	}
+	if !slices.Equal(mock.deleteSnapCalls, []string{"snap-123"}) {
+		t.Errorf("Expected snap-123 to be deleted, got %v", mock.deleteSnapCalls)
+	}
+}
+
+func TestNewProvider_RequiresCompleteKeyPair(t *testing.T) {
+	if _, err := awsprovider.NewProvider("us-east-1", "AKIAEXAMPLE", ""); err == nil {
+		t.Error("Expected an error for an access key without a secret key")
+	}
+	if _, err := awsprovider.NewProvider("us-east-1", "", "secret"); err == nil {
+		t.Error("Expected an error for a secret key without an access key")
+	}
+	if _, err := awsprovider.NewProvider("", "AKIAEXAMPLE", "secret"); err == nil {
+		t.Error("Expected an error without a region")
+	}
+}
+
+func TestNewProviderWithOptions_Profile(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	if err := os.WriteFile(configFile, []byte("[profile prod]\nregion = eu-west-1\n"), 0600); err != nil {
+		t.Fatalf("Failed to write AWS config file: %v", err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+
+	if _, err := awsprovider.NewProviderWithOptions(awsprovider.Options{Region: "us-east-1", Profile: "prod"}); err != nil {
+		t.Errorf("Expected the prod profile to load, got %v", err)
+	}
+	if _, err := awsprovider.NewProviderWithOptions(awsprovider.Options{Region: "us-east-1", Profile: "staging"}); err == nil {
+		t.Error("Expected an error for a profile missing from the config file")
+	}
+}
+
+func TestInRegion_CreatesClientsLazily(t *testing.T) {
+	mocks := make(map[string]*MockEC2)
+	factory := func(region string) awsprovider.EC2API {
+		mock := NewMockEC2()
+		mocks[region] = mock
+		return mock
+	}
+	provider := awsprovider.NewProviderWithClientFactory(factory, "us-east-1")
+	if len(mocks) != 1 {
+		t.Fatalf("Expected only the home region's client to be created, got %d", len(mocks))
+	}
+
+	resolve := cloud.Static(provider)
+	for i := 0; i < 2; i++ {
+		regionProvider, err := resolve(&models.Instance{ID: "i-eu", Region: "eu-west-1"})
+		if err != nil {
+			t.Fatalf("Failed to resolve the eu-west-1 provider: %v", err)
+		}
+		if err := regionProvider.TerminateInstance(context.Background(), "i-eu"); err != nil {
+			t.Fatalf("TerminateInstance failed: %v", err)
+		}
+	}
+	legacy, err := resolve(&models.Instance{ID: "i-legacy"})
+	if err != nil {
+		t.Fatalf("Failed to resolve the provider of an instance without a region: %v", err)
+	}
+	if err := legacy.TerminateInstance(context.Background(), "i-legacy"); err != nil {
+		t.Fatalf("TerminateInstance failed: %v", err)
+	}
+
+	if len(mocks) != 2 {
+		t.Errorf("Expected one client per region, got %d", len(mocks))
+	}
+	if got := strings.Join(mocks["eu-west-1"].terminateCalls, ","); got != "i-eu,i-eu" {
+		t.Errorf("Expected eu-west-1 to terminate i-eu twice, got %q", got)
+	}
+	if got := strings.Join(mocks["us-east-1"].terminateCalls, ","); got != "i-legacy" {
+		t.Errorf("Expected the home region to terminate i-legacy, got %q", got)
+	}
+}
+
+func TestInRegion_FixedClient(t *testing.T) {
+	provider := awsprovider.NewProviderWithClient(NewMockEC2(), "us-east-1")
+
+	if same, err := provider.InRegion("us-east-1"); err != nil || same != provider {
+		t.Errorf("Expected the provider itself for its own region, got %v, %v", same, err)
+	}
+	if _, err := provider.InRegion("eu-west-1"); err == nil {
+		t.Error("Expected an error for another region without a client factory")
+	}
+}
+
+func TestNewProviderWithOptions_AssumeRole(t *testing.T) {
+	var assumeRequests []string
+	var ec2Auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("Failed to parse request: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		switch r.Form.Get("Action") {
+		case "AssumeRole":
+			assumeRequests = append(assumeRequests, r.Form.Get("RoleArn")+" "+r.Form.Get("ExternalId")+" "+r.Form.Get("RoleSessionName"))
+			w.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult>
+<Credentials><AccessKeyId>ASIAROLE</AccessKeyId><SecretAccessKey>role-secret</SecretAccessKey><SessionToken>role-token</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration></Credentials>
+</AssumeRoleResult></AssumeRoleResponse>`))
+		case "DescribeRegions":
+			ec2Auth = r.Header.Get("Authorization")
+			w.Write([]byte(`<DescribeRegionsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><regionInfo/></DescribeRegionsResponse>`))
+		default:
+			http.Error(w, "unexpected action", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_ENDPOINT_URL_STS", server.URL)
+	t.Setenv("AWS_ENDPOINT_URL_EC2", server.URL)
+
+	provider, err := awsprovider.NewProviderWithOptions(awsprovider.Options{
+		Region:     "us-east-1",
+		AccessKey:  "AKIASOURCE",
+		SecretKey:  "source-secret",
+		RoleARN:    "arn:aws:iam::123456789012:role/instance-manager",
+		ExternalID: "ext-42",
+	})
+	if err != nil {
+		t.Fatalf("NewProviderWithOptions failed: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := provider.ValidateCredentials(context.Background()); err != nil {
+			t.Fatalf("ValidateCredentials failed: %v", err)
+		}
+	}
+
+	want := "arn:aws:iam::123456789012:role/instance-manager ext-42 " + awsprovider.DefaultRoleSessionName
+	if len(assumeRequests) != 1 || assumeRequests[0] != want {
+		t.Errorf("Expected one AssumeRole call %q, got %v", want, assumeRequests)
+	}
+	if !strings.Contains(ec2Auth, "Credential=ASIAROLE/") {
+		t.Errorf("Expected EC2 calls to be signed with the role credentials, got %q", ec2Auth)
+	}
+}
+
+func TestNewProviderWithOptions_ExpiredSSOSession(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	content := `[profile dev]
+sso_start_url = https://example.awsapps.com/start
+sso_region = us-east-1
+sso_account_id = 123456789012
+sso_role_name = Developer
+`
+	if err := os.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write AWS config: %v", err)
+	}
+	// No cached SSO token exists under the temporary home directory
+	t.Setenv("HOME", dir)
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_ENDPOINT_URL_EC2", "http://127.0.0.1:1")
+
+	provider, err := awsprovider.NewProviderWithOptions(awsprovider.Options{Region: "us-east-1", Profile: "dev"})
+	if err != nil {
+		t.Fatalf("NewProviderWithOptions failed: %v", err)
+	}
+
+	err = provider.ValidateCredentials(context.Background())
+	if err == nil {
+		t.Fatal("Expected an error without an SSO session")
+	}
+	if !strings.Contains(err.Error(), `"aws sso login --profile dev"`) {
+		t.Errorf("Expected a hint to sign in with the dev profile, got %v", err)
+	}
+}
+
+func TestNewProviderWithOptions_Endpoint(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<DescribeRegionsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><regionInfo/></DescribeRegionsResponse>`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_ENDPOINT_URL", "")
+	t.Setenv("AWS_ENDPOINT_URL_EC2", "")
+
+	provider, err := awsprovider.NewProviderWithOptions(awsprovider.Options{
+		Region:    "us-east-1",
+		AccessKey: "test",
+		SecretKey: "test",
+		Endpoint:  server.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewProviderWithOptions failed: %v", err)
+	}
+	if err := provider.ValidateCredentials(context.Background()); err != nil {
+		t.Fatalf("ValidateCredentials failed: %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected the request to go to the custom endpoint, got %d requests", requests)
+	}
+}
+
+func TestNewProviderWithOptions_RetryPolicyOnly(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`<Response><Errors><Error><Code>RequestLimitExceeded</Code><Message>Request limit exceeded.</Message></Error></Errors><RequestID>1</RequestID></Response>`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_ENDPOINT_URL", "")
+	t.Setenv("AWS_ENDPOINT_URL_EC2", "")
+	t.Setenv("AWS_MAX_ATTEMPTS", "")
+
+	provider, err := awsprovider.NewProviderWithOptions(awsprovider.Options{
+		Region:    "us-east-1",
+		AccessKey: "test",
+		SecretKey: "test",
+		Endpoint:  server.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewProviderWithOptions failed: %v", err)
+	}
+
+	for _, attempts := range []int{1, 3} {
+		requests = 0
+		policy := cloud.RetryPolicy{MaxAttempts: attempts, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+		err := policy.Do(context.Background(), func(ctx context.Context) error {
+			return provider.ValidateCredentials(ctx)
+		})
+		if err == nil {
+			t.Fatal("Expected the throttled call to fail")
+		}
+		if requests != attempts {
+			t.Errorf("Expected %d HTTP requests with %d attempts, got %d", attempts, attempts, requests)
+		}
+	}
+}
+
+// mockCloudWatch returns fixed metric series
+type mockCloudWatch struct {
+	results []cwtypes.MetricDataResult
+	inputs  []*cloudwatch.GetMetricDataInput
+}
+
+func (m *mockCloudWatch) GetMetricData(ctx context.Context, input *cloudwatch.GetMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error) {
+	m.inputs = append(m.inputs, input)
+	return &cloudwatch.GetMetricDataOutput{MetricDataResults: m.results}, nil
+}
+
+func TestProvider_Utilization(t *testing.T) {
+	latest := time.Now().Truncate(5 * time.Minute).Add(-10 * time.Minute)
+	timestamps := []time.Time{latest, latest.Add(-5 * time.Minute), latest.Add(-10 * time.Minute), latest.Add(-15 * time.Minute)}
+	metrics := &mockCloudWatch{results: []cwtypes.MetricDataResult{
+		{Id: aws.String("cpu"), Timestamps: timestamps, Values: []float64{1.5, 2.5, 0.5, 90}},
+		{Id: aws.String("network_in"), Timestamps: timestamps, Values: []float64{3000, 600, 0, 0}},
+		{Id: aws.String("network_out"), Timestamps: timestamps, Values: []float64{0, 300, 0, 0}},
+	}}
+
+	provider := awsprovider.NewProviderWithClient(NewMockEC2(), "us-east-1")
+	if _, err := provider.Utilization(context.Background(), "i-idle123", 15*time.Minute); err == nil {
+		t.Error("Expected an error without a CloudWatch client")
+	}
+	provider.SetMetricsClientFactory(func(region string) awsprovider.CloudWatchAPI {
+		return metrics
+	})
+
+	usage, err := provider.Utilization(context.Background(), "i-idle123", 15*time.Minute)
+	if err != nil {
+		t.Fatalf("Utilization failed: %v", err)
+	}
+	// The busy datapoint falls before the window ending at the latest one
+	if usage.Covered != 15*time.Minute {
+		t.Errorf("Expected 15m of coverage, got %s", usage.Covered)
+	}
+	if usage.CPUPercent != 2.5 {
+		t.Errorf("Expected peak CPU 2.5%%, got %.1f%%", usage.CPUPercent)
+	}
+	if usage.NetworkBytesPerSecond != 10 {
+		t.Errorf("Expected peak network 10 B/s, got %.1f", usage.NetworkBytesPerSecond)
+	}
+
+	query := metrics.inputs[0].MetricDataQueries[0]
+	if aws.ToString(query.MetricStat.Metric.MetricName) != "CPUUtilization" || aws.ToString(query.MetricStat.Metric.Dimensions[0].Value) != "i-idle123" {
+		t.Errorf("Unexpected CPU query: %+v", query.MetricStat.Metric)
+	}
+}
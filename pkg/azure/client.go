@@ -0,0 +1,219 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v5"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+// AzureAPI is the subset of the Azure Resource Manager APIs used by the
+// provider. Network resources are created synchronously; VM operations
+// return once Azure has accepted them.
+type AzureAPI interface {
+	ResourceGroupExists(ctx context.Context, name string) (bool, error)
+	CreateResourceGroup(ctx context.Context, name, location string) error
+
+	GetSubnet(ctx context.Context, resourceGroup, vnet, subnet string) (*armnetwork.Subnet, error)
+	CreateVirtualNetwork(ctx context.Context, resourceGroup, name string, vnet armnetwork.VirtualNetwork) (*armnetwork.VirtualNetwork, error)
+	GetSecurityGroup(ctx context.Context, resourceGroup, name string) (*armnetwork.SecurityGroup, error)
+	CreateSecurityGroup(ctx context.Context, resourceGroup, name string, group armnetwork.SecurityGroup) (*armnetwork.SecurityGroup, error)
+	CreatePublicIP(ctx context.Context, resourceGroup, name string, ip armnetwork.PublicIPAddress) (*armnetwork.PublicIPAddress, error)
+	GetPublicIP(ctx context.Context, resourceGroup, name string) (*armnetwork.PublicIPAddress, error)
+	CreateNetworkInterface(ctx context.Context, resourceGroup, name string, nic armnetwork.Interface) (*armnetwork.Interface, error)
+	GetNetworkInterface(ctx context.Context, resourceGroup, name string) (*armnetwork.Interface, error)
+
+	CreateVM(ctx context.Context, resourceGroup, name string, vm armcompute.VirtualMachine) error
+	// GetVM returns the VM including its instance view
+	GetVM(ctx context.Context, resourceGroup, name string) (*armcompute.VirtualMachine, error)
+	StartVM(ctx context.Context, resourceGroup, name string) error
+	DeallocateVM(ctx context.Context, resourceGroup, name string) error
+	DeleteVM(ctx context.Context, resourceGroup, name string) error
+	ListVMs(ctx context.Context, resourceGroup string) ([]*armcompute.VirtualMachine, error)
+}
+
+// sdkClient implements AzureAPI on top of the Azure SDK clients
+type sdkClient struct {
+	groups     *armresources.ResourceGroupsClient
+	vms        *armcompute.VirtualMachinesClient
+	vnets      *armnetwork.VirtualNetworksClient
+	subnets    *armnetwork.SubnetsClient
+	nsgs       *armnetwork.SecurityGroupsClient
+	publicIPs  *armnetwork.PublicIPAddressesClient
+	interfaces *armnetwork.InterfacesClient
+}
+
+func newSDKClient(subscriptionID string, credential azcore.TokenCredential) (*sdkClient, error) {
+	groups, err := armresources.NewResourceGroupsClient(subscriptionID, credential, nil)
+	if err != nil {
+		return nil, err
+	}
+	compute, err := armcompute.NewClientFactory(subscriptionID, credential, nil)
+	if err != nil {
+		return nil, err
+	}
+	network, err := armnetwork.NewClientFactory(subscriptionID, credential, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sdkClient{
+		groups:     groups,
+		vms:        compute.NewVirtualMachinesClient(),
+		vnets:      network.NewVirtualNetworksClient(),
+		subnets:    network.NewSubnetsClient(),
+		nsgs:       network.NewSecurityGroupsClient(),
+		publicIPs:  network.NewPublicIPAddressesClient(),
+		interfaces: network.NewInterfacesClient(),
+	}, nil
+}
+
+func (c *sdkClient) ResourceGroupExists(ctx context.Context, name string) (bool, error) {
+	resp, err := c.groups.CheckExistence(ctx, name, nil)
+	if err != nil {
+		return false, err
+	}
+	return resp.Success, nil
+}
+
+func (c *sdkClient) CreateResourceGroup(ctx context.Context, name, location string) error {
+	_, err := c.groups.CreateOrUpdate(ctx, name, armresources.ResourceGroup{
+		Location: to.Ptr(location),
+		Tags:     map[string]*string{"ManagedBy": to.Ptr(managedBy)},
+	}, nil)
+	return err
+}
+
+func (c *sdkClient) GetSubnet(ctx context.Context, resourceGroup, vnet, subnet string) (*armnetwork.Subnet, error) {
+	resp, err := c.subnets.Get(ctx, resourceGroup, vnet, subnet, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Subnet, nil
+}
+
+func (c *sdkClient) CreateVirtualNetwork(ctx context.Context, resourceGroup, name string, vnet armnetwork.VirtualNetwork) (*armnetwork.VirtualNetwork, error) {
+	poller, err := c.vnets.BeginCreateOrUpdate(ctx, resourceGroup, name, vnet, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.VirtualNetwork, nil
+}
+
+func (c *sdkClient) GetSecurityGroup(ctx context.Context, resourceGroup, name string) (*armnetwork.SecurityGroup, error) {
+	resp, err := c.nsgs.Get(ctx, resourceGroup, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.SecurityGroup, nil
+}
+
+func (c *sdkClient) CreateSecurityGroup(ctx context.Context, resourceGroup, name string, group armnetwork.SecurityGroup) (*armnetwork.SecurityGroup, error) {
+	poller, err := c.nsgs.BeginCreateOrUpdate(ctx, resourceGroup, name, group, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.SecurityGroup, nil
+}
+
+func (c *sdkClient) CreatePublicIP(ctx context.Context, resourceGroup, name string, ip armnetwork.PublicIPAddress) (*armnetwork.PublicIPAddress, error) {
+	poller, err := c.publicIPs.BeginCreateOrUpdate(ctx, resourceGroup, name, ip, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.PublicIPAddress, nil
+}
+
+func (c *sdkClient) GetPublicIP(ctx context.Context, resourceGroup, name string) (*armnetwork.PublicIPAddress, error) {
+	resp, err := c.publicIPs.Get(ctx, resourceGroup, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.PublicIPAddress, nil
+}
+
+func (c *sdkClient) CreateNetworkInterface(ctx context.Context, resourceGroup, name string, nic armnetwork.Interface) (*armnetwork.Interface, error) {
+	poller, err := c.interfaces.BeginCreateOrUpdate(ctx, resourceGroup, name, nic, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Interface, nil
+}
+
+func (c *sdkClient) GetNetworkInterface(ctx context.Context, resourceGroup, name string) (*armnetwork.Interface, error) {
+	resp, err := c.interfaces.Get(ctx, resourceGroup, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Interface, nil
+}
+
+func (c *sdkClient) CreateVM(ctx context.Context, resourceGroup, name string, vm armcompute.VirtualMachine) error {
+	_, err := c.vms.BeginCreateOrUpdate(ctx, resourceGroup, name, vm, nil)
+	return err
+}
+
+func (c *sdkClient) GetVM(ctx context.Context, resourceGroup, name string) (*armcompute.VirtualMachine, error) {
+	resp, err := c.vms.Get(ctx, resourceGroup, name, &armcompute.VirtualMachinesClientGetOptions{
+		Expand: to.Ptr(armcompute.InstanceViewTypesInstanceView),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp.VirtualMachine, nil
+}
+
+func (c *sdkClient) StartVM(ctx context.Context, resourceGroup, name string) error {
+	_, err := c.vms.BeginStart(ctx, resourceGroup, name, nil)
+	return err
+}
+
+func (c *sdkClient) DeallocateVM(ctx context.Context, resourceGroup, name string) error {
+	_, err := c.vms.BeginDeallocate(ctx, resourceGroup, name, nil)
+	return err
+}
+
+func (c *sdkClient) DeleteVM(ctx context.Context, resourceGroup, name string) error {
+	_, err := c.vms.BeginDelete(ctx, resourceGroup, name, nil)
+	return err
+}
+
+func (c *sdkClient) ListVMs(ctx context.Context, resourceGroup string) ([]*armcompute.VirtualMachine, error) {
+	var vms []*armcompute.VirtualMachine
+	pager := c.vms.NewListPager(resourceGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		vms = append(vms, page.Value...)
+	}
+	return vms, nil
+}
+
+// isNotFound reports whether err is a 404 from Azure Resource Manager
+func isNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
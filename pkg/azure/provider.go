@@ -0,0 +1,567 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/tracing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultVMSize is used when no VM size is given
+	DefaultVMSize = "Standard_B1s"
+
+	// defaultUsername is the admin user whose SSH key is installed on VMs
+	defaultUsername = "azureuser"
+
+	managedBy   = "instance-manager"
+	vnetName    = "instance-manager-vnet"
+	subnetName  = "default"
+	vnetPrefix  = "10.42.0.0/16"
+	subnetRange = "10.42.0.0/24"
+)
+
+// Provider implements the CloudProvider interface for Azure virtual machines.
+// All VMs and their network resources live in a single resource group, and
+// instance IDs are VM names.
+type Provider struct {
+	client        AzureAPI
+	resourceGroup string
+	location      string
+}
+
+// NewProvider creates a new Azure provider. Credentials come from the Azure
+// default credential chain.
+func NewProvider(subscriptionID, resourceGroup, location string) (cloud.CloudProvider, error) {
+	if subscriptionID == "" {
+		return nil, errors.New("AZURE_SUBSCRIPTION_ID environment variable is required")
+	}
+	if resourceGroup == "" {
+		return nil, errors.New("resource group is required")
+	}
+	if location == "" {
+		return nil, errors.New("location is required")
+	}
+
+	credential, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Azure credentials: %w", err)
+	}
+	client, err := newSDKClient(subscriptionID, credential)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure clients: %w", err)
+	}
+
+	return NewProviderWithClient(client, resourceGroup, location), nil
+}
+
+// NewProviderWithClient creates an Azure provider backed by the given client
+func NewProviderWithClient(client AzureAPI, resourceGroup, location string) *Provider {
+	return &Provider{
+		client:        client,
+		resourceGroup: resourceGroup,
+		location:      location,
+	}
+}
+
+// ValidateCredentials checks that the credentials can access the subscription
+func (p *Provider) ValidateCredentials(ctx context.Context) error {
+	if _, err := p.client.ResourceGroupExists(ctx, p.resourceGroup); err != nil {
+		return fmt.Errorf("invalid Azure credentials: %w", err)
+	}
+	return nil
+}
+
+// CreateInstance creates a new VM together with its public IP and network
+// interface. The resource group, virtual network and network security group
+// are created on first use.
+func (p *Provider) CreateInstance(ctx context.Context, config models.InstanceConfig) (instance *models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "CreateInstance", "")
+	defer func() {
+		if instance != nil {
+			span.SetAttributes(tracing.AttrInstanceID.String(instance.ID))
+		}
+		tracing.EndSpan(span, err)
+	}()
+
+	if config.KeyName != "" {
+		return nil, errors.New("key pairs are not supported on Azure; use a public key instead")
+	}
+	if config.SecurityGroupID != "" {
+		return nil, errors.New("security group IDs are not supported on Azure")
+	}
+
+	publicKey, err := os.ReadFile(config.PublicKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key file: %w", err)
+	}
+
+	if err := p.ensureResourceGroup(ctx); err != nil {
+		return nil, err
+	}
+	subnetID, err := p.ensureSubnet(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subnet: %w", err)
+	}
+	securityGroupID, err := p.ensureSecurityGroup(ctx, config.OpenPorts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network security group: %w", err)
+	}
+
+	size := config.InstanceType
+	if size == "" {
+		size = DefaultVMSize
+	}
+
+	launchTime := time.Now()
+	expiresAt := launchTime.Add(config.Duration)
+	name := "im-" + strconv.FormatInt(launchTime.UnixNano(), 36)
+	tags := toAzureTags(managedTags(config.Duration, expiresAt, config.Session))
+
+	// The public IP and NIC are removed together with the VM
+	publicIP, err := p.client.CreatePublicIP(ctx, p.resourceGroup, name+"-ip", armnetwork.PublicIPAddress{
+		Location: to.Ptr(p.location),
+		Tags:     tags,
+		SKU:      &armnetwork.PublicIPAddressSKU{Name: to.Ptr(armnetwork.PublicIPAddressSKUNameStandard)},
+		Properties: &armnetwork.PublicIPAddressPropertiesFormat{
+			PublicIPAllocationMethod: to.Ptr(armnetwork.IPAllocationMethodStatic),
+			DeleteOption:             to.Ptr(armnetwork.DeleteOptionsDelete),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create public IP: %w", err)
+	}
+
+	nic, err := p.client.CreateNetworkInterface(ctx, p.resourceGroup, name+"-nic", armnetwork.Interface{
+		Location: to.Ptr(p.location),
+		Tags:     tags,
+		Properties: &armnetwork.InterfacePropertiesFormat{
+			NetworkSecurityGroup: &armnetwork.SecurityGroup{ID: to.Ptr(securityGroupID)},
+			IPConfigurations: []*armnetwork.InterfaceIPConfiguration{
+				{
+					Name: to.Ptr("ipconfig1"),
+					Properties: &armnetwork.InterfaceIPConfigurationPropertiesFormat{
+						Subnet:                    &armnetwork.Subnet{ID: to.Ptr(subnetID)},
+						PublicIPAddress:           &armnetwork.PublicIPAddress{ID: publicIP.ID},
+						PrivateIPAllocationMethod: to.Ptr(armnetwork.IPAllocationMethodDynamic),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network interface: %w", err)
+	}
+
+	err = p.client.CreateVM(ctx, p.resourceGroup, name, armcompute.VirtualMachine{
+		Location: to.Ptr(p.location),
+		Tags:     tags,
+		Properties: &armcompute.VirtualMachineProperties{
+			HardwareProfile: &armcompute.HardwareProfile{
+				VMSize: to.Ptr(armcompute.VirtualMachineSizeTypes(size)),
+			},
+			StorageProfile: &armcompute.StorageProfile{
+				ImageReference: &armcompute.ImageReference{
+					Publisher: to.Ptr("Canonical"),
+					Offer:     to.Ptr("0001-com-ubuntu-server-jammy"),
+					SKU:       to.Ptr("22_04-lts-gen2"),
+					Version:   to.Ptr("latest"),
+				},
+				OSDisk: &armcompute.OSDisk{
+					CreateOption: to.Ptr(armcompute.DiskCreateOptionTypesFromImage),
+					DeleteOption: to.Ptr(armcompute.DiskDeleteOptionTypesDelete),
+				},
+			},
+			OSProfile: &armcompute.OSProfile{
+				ComputerName:  to.Ptr(name),
+				AdminUsername: to.Ptr(defaultUsername),
+				LinuxConfiguration: &armcompute.LinuxConfiguration{
+					DisablePasswordAuthentication: to.Ptr(true),
+					SSH: &armcompute.SSHConfiguration{
+						PublicKeys: []*armcompute.SSHPublicKey{
+							{
+								Path:    to.Ptr("/home/" + defaultUsername + "/.ssh/authorized_keys"),
+								KeyData: to.Ptr(strings.TrimSpace(string(publicKey))),
+							},
+						},
+					},
+				},
+			},
+			NetworkProfile: &armcompute.NetworkProfile{
+				NetworkInterfaces: []*armcompute.NetworkInterfaceReference{
+					{
+						ID: nic.ID,
+						Properties: &armcompute.NetworkInterfaceReferenceProperties{
+							Primary:      to.Ptr(true),
+							DeleteOption: to.Ptr(armcompute.DeleteOptionsDelete),
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to launch instance: %w", err)
+	}
+
+	instance = &models.Instance{
+		ID:               name,
+		InstanceType:     size,
+		State:            "pending",
+		LaunchTime:       launchTime,
+		Duration:         config.Duration,
+		AvailabilityZone: p.location,
+		Region:           p.location,
+		Username:         defaultUsername,
+		ExpiresAt:        expiresAt,
+		RestartPolicy:    config.RestartPolicy,
+		Session:          config.Session,
+	}
+	if publicIP.Properties != nil && publicIP.Properties.IPAddress != nil {
+		instance.PublicIP = *publicIP.Properties.IPAddress
+	}
+
+	return instance, nil
+}
+
+// GetInstanceStatus retrieves the status of a VM
+func (p *Provider) GetInstanceStatus(ctx context.Context, instanceID string) (_ *models.InstanceStatus, err error) {
+	ctx, span := p.startSpan(ctx, "GetInstanceStatus", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	vm, err := p.client.GetVM(ctx, p.resourceGroup, instanceID)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, errors.New("instance not found")
+		}
+		return nil, fmt.Errorf("failed to get instance: %w", err)
+	}
+
+	state := vmState(vm)
+	publicIP, privateIP := p.vmIPs(ctx, vm)
+	return &models.InstanceStatus{
+		ID:        instanceID,
+		State:     state,
+		PublicIP:  publicIP,
+		PrivateIP: privateIP,
+		Username:  defaultUsername,
+		Ready:     state == "running",
+	}, nil
+}
+
+// StartInstance starts a deallocated VM
+func (p *Provider) StartInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StartInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.StartVM(ctx, p.resourceGroup, instanceID); err != nil {
+		return fmt.Errorf("failed to start instance: %w", err)
+	}
+	return nil
+}
+
+// StopInstance deallocates a VM so that it stops accruing compute charges
+func (p *Provider) StopInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StopInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.DeallocateVM(ctx, p.resourceGroup, instanceID); err != nil {
+		return fmt.Errorf("failed to stop instance: %w", err)
+	}
+	return nil
+}
+
+// TerminateInstance deletes a VM. Its OS disk, NIC and public IP are deleted with it.
+func (p *Provider) TerminateInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "TerminateInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.DeleteVM(ctx, p.resourceGroup, instanceID); err != nil {
+		return fmt.Errorf("failed to terminate instance: %w", err)
+	}
+	return nil
+}
+
+// ListInstances lists the VMs managed by this tool in the resource group
+func (p *Provider) ListInstances(ctx context.Context) (_ []*models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "ListInstances", "")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	vms, err := p.client.ListVMs(ctx, p.resourceGroup)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list instances: %w", err)
+	}
+
+	var instances []*models.Instance
+	for _, vm := range vms {
+		if tagValue(vm.Tags, "ManagedBy") != managedBy || vm.Name == nil {
+			continue
+		}
+
+		// The list response has no instance view, so fetch each VM for its state
+		if full, err := p.client.GetVM(ctx, p.resourceGroup, *vm.Name); err == nil {
+			vm = full
+		}
+
+		inst := &models.Instance{
+			ID:               *vm.Name,
+			State:            vmState(vm),
+			AvailabilityZone: p.location,
+			Region:           p.location,
+			Username:         defaultUsername,
+			Session:          tagValue(vm.Tags, "Session"),
+		}
+		if vm.Location != nil {
+			inst.AvailabilityZone = *vm.Location
+			inst.Region = *vm.Location
+		}
+		if props := vm.Properties; props != nil {
+			if props.HardwareProfile != nil && props.HardwareProfile.VMSize != nil {
+				inst.InstanceType = string(*props.HardwareProfile.VMSize)
+			}
+			if props.TimeCreated != nil {
+				inst.LaunchTime = *props.TimeCreated
+			}
+		}
+		if duration, err := time.ParseDuration(tagValue(vm.Tags, "Duration")); err == nil {
+			inst.Duration = duration
+			inst.ExpiresAt = inst.LaunchTime.Add(duration)
+		}
+		inst.PublicIP, inst.PrivateIP = p.vmIPs(ctx, vm)
+
+		instances = append(instances, inst)
+	}
+
+	return instances, nil
+}
+
+// ensureResourceGroup creates the resource group if it doesn't exist
+func (p *Provider) ensureResourceGroup(ctx context.Context) error {
+	exists, err := p.client.ResourceGroupExists(ctx, p.resourceGroup)
+	if err != nil {
+		return fmt.Errorf("failed to check resource group %s: %w", p.resourceGroup, err)
+	}
+	if exists {
+		return nil
+	}
+	if err := p.client.CreateResourceGroup(ctx, p.resourceGroup, p.location); err != nil {
+		return fmt.Errorf("failed to create resource group %s: %w", p.resourceGroup, err)
+	}
+	return nil
+}
+
+// ensureSubnet returns the ID of the managed subnet, creating the virtual
+// network if it doesn't exist yet
+func (p *Provider) ensureSubnet(ctx context.Context) (string, error) {
+	subnet, err := p.client.GetSubnet(ctx, p.resourceGroup, vnetName, subnetName)
+	if err == nil {
+		return stringValue(subnet.ID), nil
+	}
+	if !isNotFound(err) {
+		return "", err
+	}
+
+	vnet, err := p.client.CreateVirtualNetwork(ctx, p.resourceGroup, vnetName, armnetwork.VirtualNetwork{
+		Location: to.Ptr(p.location),
+		Properties: &armnetwork.VirtualNetworkPropertiesFormat{
+			AddressSpace: &armnetwork.AddressSpace{AddressPrefixes: []*string{to.Ptr(vnetPrefix)}},
+			Subnets: []*armnetwork.Subnet{
+				{
+					Name:       to.Ptr(subnetName),
+					Properties: &armnetwork.SubnetPropertiesFormat{AddressPrefix: to.Ptr(subnetRange)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create virtual network: %w", err)
+	}
+	if vnet.Properties == nil || len(vnet.Properties.Subnets) == 0 {
+		return "", errors.New("virtual network was created without a subnet")
+	}
+	return stringValue(vnet.Properties.Subnets[0].ID), nil
+}
+
+// ensureSecurityGroup returns the ID of the managed network security group
+// for the given ports, creating it with one inbound rule per port if needed
+func (p *Provider) ensureSecurityGroup(ctx context.Context, openPorts []int64) (string, error) {
+	ports, err := cloud.NormalizePorts(openPorts)
+	if err != nil {
+		return "", err
+	}
+
+	name := securityGroupName(ports)
+	group, err := p.client.GetSecurityGroup(ctx, p.resourceGroup, name)
+	if err == nil {
+		return stringValue(group.ID), nil
+	}
+	if !isNotFound(err) {
+		return "", err
+	}
+
+	rules := make([]*armnetwork.SecurityRule, 0, len(ports))
+	for i, port := range ports {
+		rules = append(rules, &armnetwork.SecurityRule{
+			Name: to.Ptr(fmt.Sprintf("allow-tcp-%d", port)),
+			Properties: &armnetwork.SecurityRulePropertiesFormat{
+				Priority:                 to.Ptr(int32(1000 + i)),
+				Direction:                to.Ptr(armnetwork.SecurityRuleDirectionInbound),
+				Access:                   to.Ptr(armnetwork.SecurityRuleAccessAllow),
+				Protocol:                 to.Ptr(armnetwork.SecurityRuleProtocolTCP),
+				SourceAddressPrefix:      to.Ptr("*"),
+				SourcePortRange:          to.Ptr("*"),
+				DestinationAddressPrefix: to.Ptr("*"),
+				DestinationPortRange:     to.Ptr(strconv.FormatInt(port, 10)),
+			},
+		})
+	}
+
+	group, err = p.client.CreateSecurityGroup(ctx, p.resourceGroup, name, armnetwork.SecurityGroup{
+		Location:   to.Ptr(p.location),
+		Properties: &armnetwork.SecurityGroupPropertiesFormat{SecurityRules: rules},
+	})
+	if err != nil {
+		return "", err
+	}
+	return stringValue(group.ID), nil
+}
+
+// vmIPs looks up the public and private IPs of the VM's primary NIC. Lookup
+// failures leave the IPs empty.
+func (p *Provider) vmIPs(ctx context.Context, vm *armcompute.VirtualMachine) (publicIP, privateIP string) {
+	if vm.Properties == nil || vm.Properties.NetworkProfile == nil || len(vm.Properties.NetworkProfile.NetworkInterfaces) == 0 {
+		return "", ""
+	}
+
+	nic, err := p.client.GetNetworkInterface(ctx, p.resourceGroup, lastSegment(stringValue(vm.Properties.NetworkProfile.NetworkInterfaces[0].ID)))
+	if err != nil || nic.Properties == nil || len(nic.Properties.IPConfigurations) == 0 {
+		return "", ""
+	}
+
+	ipConfig := nic.Properties.IPConfigurations[0].Properties
+	if ipConfig == nil {
+		return "", ""
+	}
+	privateIP = stringValue(ipConfig.PrivateIPAddress)
+	if ipConfig.PublicIPAddress != nil && ipConfig.PublicIPAddress.ID != nil {
+		ip, err := p.client.GetPublicIP(ctx, p.resourceGroup, lastSegment(*ipConfig.PublicIPAddress.ID))
+		if err == nil && ip.Properties != nil {
+			publicIP = stringValue(ip.Properties.IPAddress)
+		}
+	}
+	return publicIP, privateIP
+}
+
+// startSpan starts a tracing span for an Azure provider operation
+func (p *Provider) startSpan(ctx context.Context, operation, instanceID string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		tracing.AttrProvider.String("azure"),
+		attribute.String("azure.resource_group", p.resourceGroup),
+	}
+	if instanceID != "" {
+		attrs = append(attrs, tracing.AttrInstanceID.String(instanceID))
+	}
+	return tracing.StartSpan(ctx, "azure."+operation, attrs...)
+}
+
+// vmState maps the power state in a VM's instance view to the states used by
+// the scheduler, which follow EC2's naming. A VM without a power state is
+// still being provisioned.
+func vmState(vm *armcompute.VirtualMachine) string {
+	if vm.Properties == nil || vm.Properties.InstanceView == nil {
+		return "pending"
+	}
+	for _, status := range vm.Properties.InstanceView.Statuses {
+		code := stringValue(status.Code)
+		if !strings.HasPrefix(code, "PowerState/") {
+			continue
+		}
+		switch power := strings.TrimPrefix(code, "PowerState/"); power {
+		case "starting":
+			return "pending"
+		case "running":
+			return "running"
+		case "stopping", "deallocating":
+			return "stopping"
+		case "stopped", "deallocated":
+			return "stopped"
+		default:
+			return power
+		}
+	}
+	return "pending"
+}
+
+// managedTags returns the metadata tags every managed VM carries, plus the
+// Session tag for VMs created in a session
+func managedTags(duration time.Duration, expiresAt time.Time, session string) map[string]string {
+	tags := map[string]string{
+		"ManagedBy": managedBy,
+		"Duration":  duration.String(),
+		"ExpiresAt": expiresAt.UTC().Format(time.RFC3339),
+	}
+	if session != "" {
+		tags["Session"] = session
+	}
+	return tags
+}
+
+// toAzureTags converts a tag map to the pointer map used by the Azure SDK
+func toAzureTags(tags map[string]string) map[string]*string {
+	result := make(map[string]*string, len(tags))
+	for key, value := range tags {
+		result[key] = to.Ptr(value)
+	}
+	return result
+}
+
+// tagValue returns the value of a tag, or "" if it is not set
+func tagValue(tags map[string]*string, key string) string {
+	if value, ok := tags[key]; ok && value != nil {
+		return *value
+	}
+	return ""
+}
+
+// securityGroupName returns the managed network security group name for a
+// set of open ports
+func securityGroupName(ports []int64) string {
+	if len(ports) == 1 && ports[0] == 22 {
+		return "instance-manager-nsg"
+	}
+	parts := make([]string, len(ports))
+	for i, port := range ports {
+		parts[i] = strconv.FormatInt(port, 10)
+	}
+	return "instance-manager-nsg-" + strings.Join(parts, "-")
+}
+
+// lastSegment returns the final segment of a resource ID
+func lastSegment(id string) string {
+	return id[strings.LastIndex(id, "/")+1:]
+}
+
+// stringValue dereferences an optional string
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,338 @@
+package azure_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/azure"
+	"instance-manager/pkg/models"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v5"
+)
+
+const rgID = "/subscriptions/sub/resourceGroups/instance-manager/providers/Microsoft.Network"
+
+// MockAzure implements the subset of the Azure APIs used by the provider
+type MockAzure struct {
+	groupExists    bool
+	groupCalls     []string
+	subnetExists   bool
+	vnetCalls      []armnetwork.VirtualNetwork
+	securityGroups map[string]bool
+	nsgCalls       []armnetwork.SecurityGroup
+	publicIPs      map[string]*armnetwork.PublicIPAddress
+	interfaces     map[string]*armnetwork.Interface
+	vms            map[string]*armcompute.VirtualMachine
+	vmCalls        []armcompute.VirtualMachine
+	deallocated    []string
+}
+
+func NewMockAzure() *MockAzure {
+	return &MockAzure{
+		groupExists:    true,
+		subnetExists:   true,
+		securityGroups: map[string]bool{"instance-manager-nsg": true},
+		publicIPs:      make(map[string]*armnetwork.PublicIPAddress),
+		interfaces:     make(map[string]*armnetwork.Interface),
+		vms:            make(map[string]*armcompute.VirtualMachine),
+	}
+}
+
+func notFound() error {
+	return &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "NotFound"}
+}
+
+func (m *MockAzure) ResourceGroupExists(ctx context.Context, name string) (bool, error) {
+	return m.groupExists, nil
+}
+
+func (m *MockAzure) CreateResourceGroup(ctx context.Context, name, location string) error {
+	m.groupCalls = append(m.groupCalls, name)
+	m.groupExists = true
+	return nil
+}
+
+func (m *MockAzure) GetSubnet(ctx context.Context, resourceGroup, vnet, subnet string) (*armnetwork.Subnet, error) {
+	if !m.subnetExists {
+		return nil, notFound()
+	}
+	return &armnetwork.Subnet{ID: to.Ptr(rgID + "/virtualNetworks/" + vnet + "/subnets/" + subnet)}, nil
+}
+
+func (m *MockAzure) CreateVirtualNetwork(ctx context.Context, resourceGroup, name string, vnet armnetwork.VirtualNetwork) (*armnetwork.VirtualNetwork, error) {
+	m.vnetCalls = append(m.vnetCalls, vnet)
+	m.subnetExists = true
+	vnet.Properties.Subnets[0].ID = to.Ptr(rgID + "/virtualNetworks/" + name + "/subnets/default")
+	return &vnet, nil
+}
+
+func (m *MockAzure) GetSecurityGroup(ctx context.Context, resourceGroup, name string) (*armnetwork.SecurityGroup, error) {
+	if !m.securityGroups[name] {
+		return nil, notFound()
+	}
+	return &armnetwork.SecurityGroup{ID: to.Ptr(rgID + "/networkSecurityGroups/" + name)}, nil
+}
+
+func (m *MockAzure) CreateSecurityGroup(ctx context.Context, resourceGroup, name string, group armnetwork.SecurityGroup) (*armnetwork.SecurityGroup, error) {
+	m.nsgCalls = append(m.nsgCalls, group)
+	m.securityGroups[name] = true
+	group.ID = to.Ptr(rgID + "/networkSecurityGroups/" + name)
+	return &group, nil
+}
+
+func (m *MockAzure) CreatePublicIP(ctx context.Context, resourceGroup, name string, ip armnetwork.PublicIPAddress) (*armnetwork.PublicIPAddress, error) {
+	ip.ID = to.Ptr(rgID + "/publicIPAddresses/" + name)
+	ip.Properties.IPAddress = to.Ptr("20.1.2.3")
+	m.publicIPs[name] = &ip
+	return &ip, nil
+}
+
+func (m *MockAzure) GetPublicIP(ctx context.Context, resourceGroup, name string) (*armnetwork.PublicIPAddress, error) {
+	ip, ok := m.publicIPs[name]
+	if !ok {
+		return nil, notFound()
+	}
+	return ip, nil
+}
+
+func (m *MockAzure) CreateNetworkInterface(ctx context.Context, resourceGroup, name string, nic armnetwork.Interface) (*armnetwork.Interface, error) {
+	nic.ID = to.Ptr(rgID + "/networkInterfaces/" + name)
+	nic.Properties.IPConfigurations[0].Properties.PrivateIPAddress = to.Ptr("10.42.0.4")
+	m.interfaces[name] = &nic
+	return &nic, nil
+}
+
+func (m *MockAzure) GetNetworkInterface(ctx context.Context, resourceGroup, name string) (*armnetwork.Interface, error) {
+	nic, ok := m.interfaces[name]
+	if !ok {
+		return nil, notFound()
+	}
+	return nic, nil
+}
+
+func (m *MockAzure) CreateVM(ctx context.Context, resourceGroup, name string, vm armcompute.VirtualMachine) error {
+	m.vmCalls = append(m.vmCalls, vm)
+	vm.Name = to.Ptr(name)
+	m.vms[name] = &vm
+	return nil
+}
+
+func (m *MockAzure) GetVM(ctx context.Context, resourceGroup, name string) (*armcompute.VirtualMachine, error) {
+	vm, ok := m.vms[name]
+	if !ok {
+		return nil, notFound()
+	}
+	return vm, nil
+}
+
+func (m *MockAzure) StartVM(ctx context.Context, resourceGroup, name string) error {
+	return nil
+}
+
+func (m *MockAzure) DeallocateVM(ctx context.Context, resourceGroup, name string) error {
+	m.deallocated = append(m.deallocated, name)
+	return nil
+}
+
+func (m *MockAzure) DeleteVM(ctx context.Context, resourceGroup, name string) error {
+	delete(m.vms, name)
+	return nil
+}
+
+func (m *MockAzure) ListVMs(ctx context.Context, resourceGroup string) ([]*armcompute.VirtualMachine, error) {
+	var vms []*armcompute.VirtualMachine
+	for _, vm := range m.vms {
+		vms = append(vms, vm)
+	}
+	return vms, nil
+}
+
+func writePublicKey(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "id_rsa.pub")
+	if err := os.WriteFile(path, []byte("ssh-rsa AAAAB3NzaC1yc2E test@example\n"), 0600); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+	return path
+}
+
+func setPowerState(vm *armcompute.VirtualMachine, code string) {
+	vm.Properties.InstanceView = &armcompute.VirtualMachineInstanceView{
+		Statuses: []*armcompute.InstanceViewStatus{
+			{Code: to.Ptr("ProvisioningState/succeeded")},
+			{Code: to.Ptr(code)},
+		},
+	}
+}
+
+func TestCreateInstance(t *testing.T) {
+	mock := NewMockAzure()
+	provider := azure.NewProviderWithClient(mock, "instance-manager", "westeurope")
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:  "Standard_B2s",
+		Duration:      2 * time.Hour,
+		PublicKeyPath: writePublicKey(t),
+		Session:       "exp-42",
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	if len(mock.groupCalls) != 0 || len(mock.vnetCalls) != 0 || len(mock.nsgCalls) != 0 {
+		t.Errorf("Expected existing network resources to be reused")
+	}
+	if len(mock.vmCalls) != 1 {
+		t.Fatalf("Expected 1 VM create call, got %d", len(mock.vmCalls))
+	}
+
+	vm := mock.vmCalls[0]
+	if got := string(*vm.Properties.HardwareProfile.VMSize); got != "Standard_B2s" {
+		t.Errorf("Expected size Standard_B2s, got %s", got)
+	}
+	if got := *vm.Properties.OSProfile.LinuxConfiguration.SSH.PublicKeys[0].KeyData; got != "ssh-rsa AAAAB3NzaC1yc2E test@example" {
+		t.Errorf("Unexpected SSH key %q", got)
+	}
+	if *vm.Tags["ManagedBy"] != "instance-manager" || *vm.Tags["Duration"] != "2h0m0s" || *vm.Tags["Session"] != "exp-42" {
+		t.Errorf("Unexpected tags %v", vm.Tags)
+	}
+	nicRef := vm.Properties.NetworkProfile.NetworkInterfaces[0]
+	if *nicRef.ID != rgID+"/networkInterfaces/"+instance.ID+"-nic" {
+		t.Errorf("Expected VM to use the created NIC, got %s", *nicRef.ID)
+	}
+	if *nicRef.Properties.DeleteOption != armcompute.DeleteOptionsDelete {
+		t.Error("Expected the NIC to be deleted with the VM")
+	}
+
+	nic := mock.interfaces[instance.ID+"-nic"]
+	if *nic.Properties.NetworkSecurityGroup.ID != rgID+"/networkSecurityGroups/instance-manager-nsg" {
+		t.Errorf("Unexpected NSG %s", *nic.Properties.NetworkSecurityGroup.ID)
+	}
+	if *nic.Properties.IPConfigurations[0].Properties.PublicIPAddress.ID != rgID+"/publicIPAddresses/"+instance.ID+"-ip" {
+		t.Error("Expected the NIC to use the created public IP")
+	}
+
+	if instance.PublicIP != "20.1.2.3" || instance.Username != "azureuser" || instance.Region != "westeurope" {
+		t.Errorf("Unexpected instance %+v", instance)
+	}
+}
+
+func TestCreateInstance_CreatesNetworkResources(t *testing.T) {
+	mock := NewMockAzure()
+	mock.groupExists = false
+	mock.subnetExists = false
+	provider := azure.NewProviderWithClient(mock, "instance-manager", "westeurope")
+
+	_, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		Duration:      time.Hour,
+		PublicKeyPath: writePublicKey(t),
+		OpenPorts:     []int64{443, 22},
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	if len(mock.groupCalls) != 1 || len(mock.vnetCalls) != 1 {
+		t.Errorf("Expected resource group and virtual network to be created, got %d and %d", len(mock.groupCalls), len(mock.vnetCalls))
+	}
+	if len(mock.nsgCalls) != 1 {
+		t.Fatalf("Expected 1 NSG create call, got %d", len(mock.nsgCalls))
+	}
+	rules := mock.nsgCalls[0].Properties.SecurityRules
+	if len(rules) != 2 || *rules[0].Properties.DestinationPortRange != "22" || *rules[1].Properties.DestinationPortRange != "443" {
+		t.Errorf("Unexpected NSG rules")
+	}
+	if !mock.securityGroups["instance-manager-nsg-22-443"] {
+		t.Error("Expected NSG named after its ports")
+	}
+	if got := string(*mock.vmCalls[0].Properties.HardwareProfile.VMSize); got != azure.DefaultVMSize {
+		t.Errorf("Expected default size, got %s", got)
+	}
+}
+
+func TestGetInstanceStatus(t *testing.T) {
+	mock := NewMockAzure()
+	provider := azure.NewProviderWithClient(mock, "instance-manager", "westeurope")
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{Duration: time.Hour, PublicKeyPath: writePublicKey(t)})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	tests := []struct {
+		code  string
+		state string
+	}{
+		{"PowerState/starting", "pending"},
+		{"PowerState/running", "running"},
+		{"PowerState/deallocating", "stopping"},
+		{"PowerState/deallocated", "stopped"},
+	}
+	for _, tt := range tests {
+		setPowerState(mock.vms[instance.ID], tt.code)
+		status, err := provider.GetInstanceStatus(context.Background(), instance.ID)
+		if err != nil {
+			t.Fatalf("GetInstanceStatus failed: %v", err)
+		}
+		if status.State != tt.state {
+			t.Errorf("%s: expected state %s, got %s", tt.code, tt.state, status.State)
+		}
+		if status.PublicIP != "20.1.2.3" || status.PrivateIP != "10.42.0.4" {
+			t.Errorf("Unexpected IPs %s / %s", status.PublicIP, status.PrivateIP)
+		}
+	}
+
+	if _, err := provider.GetInstanceStatus(context.Background(), "missing"); err == nil {
+		t.Error("Expected an error for a missing instance")
+	}
+}
+
+func TestListInstances(t *testing.T) {
+	mock := NewMockAzure()
+	provider := azure.NewProviderWithClient(mock, "instance-manager", "westeurope")
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{Duration: 3 * time.Hour, PublicKeyPath: writePublicKey(t)})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	created := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	mock.vms[instance.ID].Properties.TimeCreated = &created
+	setPowerState(mock.vms[instance.ID], "PowerState/running")
+
+	// VMs without the ManagedBy tag are ignored
+	mock.vms["other"] = &armcompute.VirtualMachine{Name: to.Ptr("other"), Properties: &armcompute.VirtualMachineProperties{}}
+
+	instances, err := provider.ListInstances(context.Background())
+	if err != nil {
+		t.Fatalf("ListInstances failed: %v", err)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("Expected 1 instance, got %d", len(instances))
+	}
+	got := instances[0]
+	if got.ID != instance.ID || got.State != "running" || got.InstanceType != azure.DefaultVMSize {
+		t.Errorf("Unexpected instance %+v", got)
+	}
+	if !got.ExpiresAt.Equal(created.Add(3 * time.Hour)) {
+		t.Errorf("Expected expiry %s, got %s", created.Add(3*time.Hour), got.ExpiresAt)
+	}
+}
+
+func TestStopInstance_Deallocates(t *testing.T) {
+	mock := NewMockAzure()
+	provider := azure.NewProviderWithClient(mock, "instance-manager", "westeurope")
+
+	if err := provider.StopInstance(context.Background(), "im-1"); err != nil {
+		t.Fatalf("StopInstance failed: %v", err)
+	}
+	if len(mock.deallocated) != 1 || mock.deallocated[0] != "im-1" {
+		t.Errorf("Expected im-1 to be deallocated, got %v", mock.deallocated)
+	}
+}
@@ -1,31 +1,66 @@
 package cloud
 
 import (
+	"context"
+	"time"
+
 	"instance-manager/pkg/models"
 )
 
-// CloudProvider defines the interface for cloud providers
+// CloudProvider defines the interface for cloud providers. Every method takes
+// a context so callers can cancel or time out slow provider calls.
 type CloudProvider interface {
 	// CreateInstance creates a new instance with the given configuration
-	CreateInstance(config models.InstanceConfig) (*models.Instance, error)
+	CreateInstance(ctx context.Context, config models.InstanceConfig) (*models.Instance, error)
 
 	// GetInstanceStatus retrieves the current status of an instance
-	GetInstanceStatus(instanceID string) (*models.InstanceStatus, error)
+	GetInstanceStatus(ctx context.Context, instanceID string) (*models.InstanceStatus, error)
 
 	// StartInstance starts a stopped instance
-	StartInstance(instanceID string) error
+	StartInstance(ctx context.Context, instanceID string) error
 
 	// StopInstance stops a running instance (without terminating)
-	StopInstance(instanceID string) error
+	StopInstance(ctx context.Context, instanceID string) error
 
 	// TerminateInstance terminates the specified instance
-	TerminateInstance(instanceID string) error
+	TerminateInstance(ctx context.Context, instanceID string) error
 
 	// ListInstances returns a list of all instances managed by this provider
-	ListInstances() ([]*models.Instance, error)
+	ListInstances(ctx context.Context) ([]*models.Instance, error)
 
 	// ValidateCredentials checks if the provider credentials are valid
-	ValidateCredentials() error
+	ValidateCredentials(ctx context.Context) error
+}
+
+// VolumeSnapshotter is implemented by providers that can snapshot the volumes
+// of an instance before the scheduler stops it
+type VolumeSnapshotter interface {
+	// SnapshotVolumes starts a snapshot of every volume attached to the
+	// instance and returns the started snapshots
+	SnapshotVolumes(ctx context.Context, instanceID string) ([]*models.SnapshotRecord, error)
+}
+
+// ImageCreator is implemented by providers that can create a machine image of
+// an instance before the scheduler stops it
+type ImageCreator interface {
+	// CreateImage starts creating an image of the instance; an empty name is
+	// generated by the provider
+	CreateImage(ctx context.Context, instanceID, name string) (*models.ImageRecord, error)
+}
+
+// Hibernator is implemented by providers that can hibernate an instance,
+// saving its memory so it resumes where it left off when started
+type Hibernator interface {
+	// HibernateInstance hibernates an instance launched with hibernation enabled
+	HibernateInstance(ctx context.Context, instanceID string) error
+}
+
+// UtilizationReporter is implemented by providers that report the recent
+// resource usage of an instance, which the scheduler's idle policy reads
+type UtilizationReporter interface {
+	// Utilization returns the peak CPU and network usage of the instance over
+	// the most recent window for which metrics are available
+	Utilization(ctx context.Context, instanceID string, window time.Duration) (*models.Utilization, error)
 }
 
 // ProviderConfig represents configuration common to all cloud providers
@@ -0,0 +1,90 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"instance-manager/pkg/logging"
+	"instance-manager/pkg/models"
+)
+
+// RegionalProviderFactory builds a provider that operates in the given region
+type RegionalProviderFactory func(region string) (CloudProvider, error)
+
+// RegionResult is the outcome of launching an instance in one region
+type RegionResult struct {
+	Region   string
+	Instance *models.Instance
+	Err      error
+}
+
+// CreateInRegions launches one instance per region, each through a provider
+// built for that region. A failure in one region does not stop the others.
+// The availability zone is kept when it belongs to the region, otherwise the
+// region's "a" zone is used. Failures are logged with the logger ctx
+// carries.
+func CreateInRegions(ctx context.Context, factory RegionalProviderFactory, regions []string, config models.InstanceConfig) []RegionResult {
+	results := make([]RegionResult, 0, len(regions))
+	for _, region := range regions {
+		result := RegionResult{Region: region}
+		logger := logging.FromContext(ctx).WithField("region", region)
+
+		provider, err := factory(region)
+		if err != nil {
+			result.Err = fmt.Errorf("failed to create provider for %s: %w", region, err)
+			logger.WithError(err).Warn("Failed to create provider for region")
+			results = append(results, result)
+			continue
+		}
+
+		regionConfig := config
+		regionConfig.Region = region
+		if !strings.HasPrefix(regionConfig.AvailabilityZone, region) {
+			regionConfig.AvailabilityZone = region + "a"
+		}
+
+		instance, err := provider.CreateInstance(ctx, regionConfig)
+		if err != nil {
+			result.Err = fmt.Errorf("failed to create instance in %s: %w", region, err)
+			logger.WithError(err).Warn("Failed to create instance in region")
+			results = append(results, result)
+			continue
+		}
+		if instance.Region == "" {
+			instance.Region = region
+		}
+
+		result.Instance = instance
+		results = append(results, result)
+	}
+	return results
+}
+
+// ListInRegions lists the provider's instances in each region, skipping
+// duplicates. Providers that cannot switch regions are listed once.
+func ListInRegions(ctx context.Context, provider CloudProvider, regions []string) ([]*models.Instance, error) {
+	if _, ok := provider.(RegionalProvider); !ok || len(regions) == 0 {
+		return provider.ListInstances(ctx)
+	}
+
+	var instances []*models.Instance
+	seen := make(map[string]bool)
+	for _, region := range regions {
+		regionProvider, err := InRegion(provider, region)
+		if err != nil {
+			return nil, err
+		}
+		regionInstances, err := regionProvider.ListInstances(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list instances in %s: %w", region, err)
+		}
+		for _, instance := range regionInstances {
+			if !seen[instance.ID] {
+				seen[instance.ID] = true
+				instances = append(instances, instance)
+			}
+		}
+	}
+	return instances, nil
+}
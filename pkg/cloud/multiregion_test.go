@@ -0,0 +1,154 @@
+package cloud_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/models"
+)
+
+// regionalMock is a provider bound to a single region
+type regionalMock struct {
+	region  string
+	configs []models.InstanceConfig
+}
+
+func (m *regionalMock) CreateInstance(ctx context.Context, config models.InstanceConfig) (*models.Instance, error) {
+	m.configs = append(m.configs, config)
+	return &models.Instance{
+		ID:               "i-" + m.region,
+		AvailabilityZone: config.AvailabilityZone,
+		Region:           m.region,
+	}, nil
+}
+
+func (m *regionalMock) GetInstanceStatus(ctx context.Context, instanceID string) (*models.InstanceStatus, error) {
+	return nil, nil
+}
+
+func (m *regionalMock) StartInstance(ctx context.Context, instanceID string) error     { return nil }
+func (m *regionalMock) StopInstance(ctx context.Context, instanceID string) error      { return nil }
+func (m *regionalMock) TerminateInstance(ctx context.Context, instanceID string) error { return nil }
+func (m *regionalMock) ValidateCredentials(ctx context.Context) error                  { return nil }
+
+func (m *regionalMock) ListInstances(ctx context.Context) ([]*models.Instance, error) {
+	return []*models.Instance{{ID: "i-" + m.region, Region: m.region}}, nil
+}
+
+// switchingMock is a provider that can operate in any region
+type switchingMock struct {
+	regionalMock
+	regions map[string]*regionalMock
+}
+
+func newSwitchingMock(home string) *switchingMock {
+	m := &switchingMock{regionalMock: regionalMock{region: home}, regions: make(map[string]*regionalMock)}
+	m.regions[home] = &m.regionalMock
+	return m
+}
+
+func (m *switchingMock) InRegion(region string) (cloud.CloudProvider, error) {
+	if region == "mars-north-1" {
+		return nil, errors.New("unknown region")
+	}
+	if _, ok := m.regions[region]; !ok {
+		m.regions[region] = &regionalMock{region: region}
+	}
+	return m.regions[region], nil
+}
+
+func TestCreateInRegions(t *testing.T) {
+	providers := make(map[string]*regionalMock)
+	factory := func(region string) (cloud.CloudProvider, error) {
+		provider := &regionalMock{region: region}
+		providers[region] = provider
+		return provider, nil
+	}
+
+	regions := []string{"us-east-1", "eu-west-1", "ap-southeast-2"}
+	results := cloud.CreateInRegions(context.Background(), factory, regions, models.InstanceConfig{
+		InstanceType:     "t3.micro",
+		AvailabilityZone: "us-east-1b",
+	})
+
+	if len(results) != len(regions) {
+		t.Fatalf("Expected %d results, got %d", len(regions), len(results))
+	}
+
+	wantZones := map[string]string{
+		"us-east-1":      "us-east-1b", // Requested zone belongs to the region
+		"eu-west-1":      "eu-west-1a",
+		"ap-southeast-2": "ap-southeast-2a",
+	}
+	for i, result := range results {
+		region := regions[i]
+		if result.Err != nil {
+			t.Fatalf("Unexpected error for %s: %v", region, result.Err)
+		}
+		if result.Region != region || result.Instance.Region != region {
+			t.Errorf("Expected instance in %s, got result %s / instance %s", region, result.Region, result.Instance.Region)
+		}
+		if result.Instance.ID != "i-"+region {
+			t.Errorf("Expected instance from the %s provider, got %s", region, result.Instance.ID)
+		}
+
+		provider := providers[region]
+		if len(provider.configs) != 1 {
+			t.Fatalf("Expected 1 create call in %s, got %d", region, len(provider.configs))
+		}
+		if provider.configs[0].Region != region {
+			t.Errorf("Expected config region %s, got %s", region, provider.configs[0].Region)
+		}
+		if provider.configs[0].AvailabilityZone != wantZones[region] {
+			t.Errorf("Expected zone %s in %s, got %s", wantZones[region], region, provider.configs[0].AvailabilityZone)
+		}
+	}
+}
+
+func TestCreateInRegions_ContinuesAfterFailure(t *testing.T) {
+	factory := func(region string) (cloud.CloudProvider, error) {
+		if region == "mars-north-1" {
+			return nil, errors.New("unknown region")
+		}
+		return &regionalMock{region: region}, nil
+	}
+
+	results := cloud.CreateInRegions(context.Background(), factory, []string{"mars-north-1", "eu-west-1"}, models.InstanceConfig{})
+
+	if results[0].Err == nil {
+		t.Error("Expected an error for mars-north-1")
+	}
+	if results[1].Err != nil || results[1].Instance == nil {
+		t.Errorf("Expected eu-west-1 to succeed, got %+v", results[1])
+	}
+}
+
+func TestListInRegions(t *testing.T) {
+	provider := newSwitchingMock("us-east-1")
+
+	instances, err := cloud.ListInRegions(context.Background(), provider, []string{"us-east-1", "eu-west-1", "us-east-1"})
+	if err != nil {
+		t.Fatalf("ListInRegions failed: %v", err)
+	}
+	var ids []string
+	for _, instance := range instances {
+		ids = append(ids, instance.ID)
+	}
+	if strings.Join(ids, ",") != "i-us-east-1,i-eu-west-1" {
+		t.Errorf("Expected one instance per distinct region, got %v", ids)
+	}
+
+	if _, err := cloud.ListInRegions(context.Background(), provider, []string{"mars-north-1"}); err == nil {
+		t.Error("Expected an error for a region the provider cannot use")
+	}
+
+	// Providers that cannot switch regions are listed once
+	single := &regionalMock{region: "local"}
+	instances, err = cloud.ListInRegions(context.Background(), single, []string{"us-east-1", "eu-west-1"})
+	if err != nil || len(instances) != 1 {
+		t.Errorf("Expected a single listing, got %d instances, %v", len(instances), err)
+	}
+}
@@ -0,0 +1,54 @@
+package cloud
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+)
+
+// Anywhere is the source of firewall rules open to the whole internet
+const Anywhere = "0.0.0.0/0"
+
+// NormalizePorts validates, sorts and de-duplicates the ports to open,
+// defaulting to SSH only
+func NormalizePorts(ports []int64) ([]int64, error) {
+	if len(ports) == 0 {
+		return []int64{22}, nil
+	}
+
+	seen := make(map[int64]bool)
+	var result []int64
+	for _, port := range ports {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %d: must be between 1 and 65535", port)
+		}
+		if !seen[port] {
+			seen[port] = true
+			result = append(result, port)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result, nil
+}
+
+// NormalizeCIDRs validates, canonicalizes and de-duplicates the CIDR blocks
+// allowed to reach SSH, defaulting to Anywhere
+func NormalizeCIDRs(cidrs []string) ([]string, error) {
+	if len(cidrs) == 0 {
+		return []string{Anywhere}, nil
+	}
+
+	var result []string
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil || network.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid SSH CIDR %q: must be an IPv4 CIDR block such as 203.0.113.0/24", cidr)
+		}
+		if canonical := network.String(); !slices.Contains(result, canonical) {
+			result = append(result, canonical)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
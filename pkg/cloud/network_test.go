@@ -0,0 +1,44 @@
+package cloud_test
+
+import (
+	"slices"
+	"testing"
+
+	"instance-manager/pkg/cloud"
+)
+
+func TestNormalizePorts(t *testing.T) {
+	ports, err := cloud.NormalizePorts(nil)
+	if err != nil || !slices.Equal(ports, []int64{22}) {
+		t.Errorf("Expected SSH only by default, got %v (%v)", ports, err)
+	}
+
+	ports, err = cloud.NormalizePorts([]int64{443, 22, 80, 443})
+	if err != nil || !slices.Equal(ports, []int64{22, 80, 443}) {
+		t.Errorf("Expected sorted unique ports, got %v (%v)", ports, err)
+	}
+
+	for _, port := range []int64{0, 70000} {
+		if _, err := cloud.NormalizePorts([]int64{port}); err == nil {
+			t.Errorf("Expected an error for port %d", port)
+		}
+	}
+}
+
+func TestNormalizeCIDRs(t *testing.T) {
+	cidrs, err := cloud.NormalizeCIDRs(nil)
+	if err != nil || !slices.Equal(cidrs, []string{cloud.Anywhere}) {
+		t.Errorf("Expected anywhere by default, got %v (%v)", cidrs, err)
+	}
+
+	cidrs, err = cloud.NormalizeCIDRs([]string{"203.0.113.7/24", "198.51.100.0/24", "203.0.113.0/24"})
+	if err != nil || !slices.Equal(cidrs, []string{"198.51.100.0/24", "203.0.113.0/24"}) {
+		t.Errorf("Expected sorted canonical blocks, got %v (%v)", cidrs, err)
+	}
+
+	for _, cidr := range []string{"203.0.113.7", "2001:db8::/32"} {
+		if _, err := cloud.NormalizeCIDRs([]string{cidr}); err == nil {
+			t.Errorf("Expected an error for %q", cidr)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package cloud
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"instance-manager/pkg/models"
+)
+
+// Constructor builds a provider, typically from the loaded configuration
+type Constructor func() (CloudProvider, error)
+
+// AccountConstructor builds a provider acting in a named account
+type AccountConstructor func(account string) (CloudProvider, error)
+
+// Resolver returns the provider that manages an instance
+type Resolver func(instance *models.Instance) (CloudProvider, error)
+
+// RegionalProvider is implemented by providers that can act on instances in
+// regions other than the one they were created for
+type RegionalProvider interface {
+	// InRegion returns a provider bound to region
+	InRegion(region string) (CloudProvider, error)
+}
+
+// InRegion returns provider bound to region when the provider supports
+// switching regions and region is set, otherwise provider itself
+func InRegion(provider CloudProvider, region string) (CloudProvider, error) {
+	regional, ok := provider.(RegionalProvider)
+	if !ok || region == "" {
+		return provider, nil
+	}
+	return regional.InRegion(region)
+}
+
+// Static returns a Resolver that uses provider for every instance, bound to
+// the instance's region
+func Static(provider CloudProvider) Resolver {
+	return func(instance *models.Instance) (CloudProvider, error) {
+		return InRegion(provider, instance.Region)
+	}
+}
+
+// Registry maps provider names to constructors. Each provider is built on
+// first use and reused afterwards; failed constructions are retried.
+type Registry struct {
+	mu           sync.Mutex
+	constructors map[string]Constructor
+	accounts     map[string]AccountConstructor
+	providers    map[string]CloudProvider
+	fallback     string
+}
+
+// NewRegistry creates an empty registry. Instances stored without a provider
+// name are resolved to fallback.
+func NewRegistry(fallback string) *Registry {
+	return &Registry{
+		constructors: make(map[string]Constructor),
+		accounts:     make(map[string]AccountConstructor),
+		providers:    make(map[string]CloudProvider),
+		fallback:     fallback,
+	}
+}
+
+// Register adds a provider constructor under name, replacing any previous one
+func (r *Registry) Register(name string, constructor Constructor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.constructors[name] = constructor
+	delete(r.providers, name)
+}
+
+// RegisterAccounts adds a constructor for the named accounts of provider
+// name. Providers are built and cached per account, like Get does.
+func (r *Registry) RegisterAccounts(name string, constructor AccountConstructor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.accounts[name] = constructor
+	for key := range r.providers {
+		if strings.HasPrefix(key, name+"/") {
+			delete(r.providers, key)
+		}
+	}
+}
+
+// Names returns the registered provider names in sorted order
+func (r *Registry) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.constructors))
+	for name := range r.constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the provider registered under name, building it if needed
+func (r *Registry) Get(name string) (CloudProvider, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if provider, ok := r.providers[name]; ok {
+		return provider, nil
+	}
+
+	constructor, ok := r.constructors[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %s", name)
+	}
+
+	provider, err := constructor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s provider: %w", name, err)
+	}
+	r.providers[name] = provider
+	return provider, nil
+}
+
+// GetAccount returns the provider registered under name acting in the named
+// account. An empty account is the provider's default account.
+func (r *Registry) GetAccount(name, account string) (CloudProvider, error) {
+	if account == "" {
+		return r.Get(name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := name + "/" + account
+	if provider, ok := r.providers[key]; ok {
+		return provider, nil
+	}
+
+	constructor, ok := r.accounts[name]
+	if !ok {
+		return nil, fmt.Errorf("provider %s does not support accounts", name)
+	}
+
+	provider, err := constructor(account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s provider for account %s: %w", name, account, err)
+	}
+	r.providers[key] = provider
+	return provider, nil
+}
+
+// ForInstance returns the provider recorded on the instance, or the fallback
+// provider for instances stored before the provider was recorded. The
+// provider acts in the instance's account and, when it supports several
+// regions, is bound to the instance's region.
+func (r *Registry) ForInstance(instance *models.Instance) (CloudProvider, error) {
+	name := instance.Provider
+	if name == "" {
+		name = r.fallback
+	}
+	provider, err := r.GetAccount(name, instance.Account)
+	if err != nil {
+		return nil, err
+	}
+	return InRegion(provider, instance.Region)
+}
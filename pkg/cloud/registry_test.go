@@ -0,0 +1,150 @@
+package cloud_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/models"
+)
+
+func TestRegistry_GetBuildsOnce(t *testing.T) {
+	registry := cloud.NewRegistry("aws")
+	built := 0
+	registry.Register("aws", func() (cloud.CloudProvider, error) {
+		built++
+		return &regionalMock{region: "us-east-1"}, nil
+	})
+
+	first, err := registry.Get("aws")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	second, err := registry.Get("aws")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	if first != second {
+		t.Error("Expected the same provider to be reused")
+	}
+	if built != 1 {
+		t.Errorf("Expected the constructor to run once, ran %d times", built)
+	}
+}
+
+func TestRegistry_GetErrors(t *testing.T) {
+	registry := cloud.NewRegistry("aws")
+	attempts := 0
+	registry.Register("gcp", func() (cloud.CloudProvider, error) {
+		attempts++
+		return nil, errors.New("GCP_PROJECT environment variable is required")
+	})
+
+	if _, err := registry.Get("vultr"); err == nil {
+		t.Error("Expected an error for an unregistered provider")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := registry.Get("gcp"); err == nil {
+			t.Error("Expected the constructor error to be returned")
+		}
+	}
+	if attempts != 2 {
+		t.Errorf("Expected failed constructions to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestRegistry_ForInstance(t *testing.T) {
+	registry := cloud.NewRegistry("aws")
+	awsProvider := &regionalMock{region: "us-east-1"}
+	dockerProvider := &regionalMock{region: "local"}
+	registry.Register("aws", func() (cloud.CloudProvider, error) { return awsProvider, nil })
+	registry.Register("docker", func() (cloud.CloudProvider, error) { return dockerProvider, nil })
+
+	tests := []struct {
+		provider string
+		want     cloud.CloudProvider
+	}{
+		{provider: "", want: awsProvider}, // Stored before the provider was recorded
+		{provider: "aws", want: awsProvider},
+		{provider: "docker", want: dockerProvider},
+	}
+
+	for _, tt := range tests {
+		got, err := registry.ForInstance(&models.Instance{ID: "i-1", Provider: tt.provider})
+		if err != nil {
+			t.Fatalf("ForInstance(%q) failed: %v", tt.provider, err)
+		}
+		if got != tt.want {
+			t.Errorf("ForInstance(%q) returned the wrong provider", tt.provider)
+		}
+	}
+
+	if want := []string{"aws", "docker"}; !reflect.DeepEqual(registry.Names(), want) {
+		t.Errorf("Expected names %v, got %v", want, registry.Names())
+	}
+}
+
+func TestRegistry_ForInstanceRegion(t *testing.T) {
+	registry := cloud.NewRegistry("aws")
+	awsProvider := newSwitchingMock("us-east-1")
+	registry.Register("aws", func() (cloud.CloudProvider, error) { return awsProvider, nil })
+
+	home, err := registry.ForInstance(&models.Instance{ID: "i-1"})
+	if err != nil {
+		t.Fatalf("ForInstance failed: %v", err)
+	}
+	if home != awsProvider {
+		t.Error("Expected an instance without a region to use the provider's own region")
+	}
+
+	eu, err := registry.ForInstance(&models.Instance{ID: "i-2", Region: "eu-west-1"})
+	if err != nil {
+		t.Fatalf("ForInstance failed: %v", err)
+	}
+	if eu != awsProvider.regions["eu-west-1"] {
+		t.Error("Expected the eu-west-1 provider for an eu-west-1 instance")
+	}
+}
+
+func TestRegistry_ForInstanceAccount(t *testing.T) {
+	registry := cloud.NewRegistry("aws")
+	defaultProvider := &regionalMock{region: "us-east-1"}
+	registry.Register("aws", func() (cloud.CloudProvider, error) { return defaultProvider, nil })
+
+	built := make(map[string]int)
+	registry.RegisterAccounts("aws", func(account string) (cloud.CloudProvider, error) {
+		if account != "prod" {
+			return nil, errors.New("unknown AWS account: " + account)
+		}
+		built[account]++
+		return &regionalMock{region: "eu-west-1"}, nil
+	})
+
+	first, err := registry.ForInstance(&models.Instance{ID: "i-1", Account: "prod"})
+	if err != nil {
+		t.Fatalf("ForInstance failed: %v", err)
+	}
+	second, err := registry.ForInstance(&models.Instance{ID: "i-2", Provider: "aws", Account: "prod"})
+	if err != nil {
+		t.Fatalf("ForInstance failed: %v", err)
+	}
+	if first != second || first == defaultProvider {
+		t.Error("Expected one shared provider for the prod account")
+	}
+	if built["prod"] != 1 {
+		t.Errorf("Expected the prod provider to be built once, built %d times", built["prod"])
+	}
+
+	if got, _ := registry.ForInstance(&models.Instance{ID: "i-3"}); got != defaultProvider {
+		t.Error("Expected an instance without an account to use the default account")
+	}
+	if _, err := registry.ForInstance(&models.Instance{ID: "i-4", Account: "staging"}); err == nil {
+		t.Error("Expected an error for an unknown account")
+	}
+	if _, err := registry.GetAccount("docker", "prod"); err == nil {
+		t.Error("Expected an error for a provider without accounts")
+	}
+}
@@ -0,0 +1,124 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"instance-manager/pkg/logging"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RetryPolicy controls how provider calls are retried after transient
+// failures such as API throttling
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts including the first; 1 or less disables retries
+	BaseDelay   time.Duration // Delay before the first retry, doubled for each later one
+	MaxDelay    time.Duration // Upper bound of a single delay
+}
+
+// DefaultRetryPolicy retries a call up to three times, waiting about 0.5s,
+// 1s and 2s in between
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+}
+
+// retryableCodes are the API error codes of throttling and transient service
+// failures across the provider SDKs
+var retryableCodes = map[string]bool{
+	"RequestLimitExceeded":      true,
+	"Throttling":                true,
+	"ThrottlingException":       true,
+	"ThrottledException":        true,
+	"RequestThrottled":          true,
+	"RequestThrottledException": true,
+	"TooManyRequests":           true,
+	"TooManyRequestsException":  true,
+	"SlowDown":                  true,
+	"ServiceUnavailable":        true,
+	"Unavailable":               true,
+	"InternalError":             true,
+	"InternalFailure":           true,
+}
+
+// IsRetryable reports whether err is a transient failure worth retrying:
+// throttling, a rate limit or a temporary server error. Cancelled and timed
+// out calls are not retried.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var coded interface{ ErrorCode() string }
+	if errors.As(err, &coded) && retryableCodes[coded.ErrorCode()] {
+		return true
+	}
+
+	var response interface{ HTTPStatusCode() int }
+	if errors.As(err, &response) {
+		switch response.HTTPStatusCode() {
+		case 429, 500, 502, 503, 504:
+			return true
+		}
+	}
+	return false
+}
+
+// Do calls op until it succeeds, fails with an error that is not retryable,
+// runs out of attempts or ctx ends, waiting with exponential backoff and
+// jitter between attempts. It returns the last error of op. Each retry is
+// logged with the logger ctx carries.
+func (p RetryPolicy) Do(ctx context.Context, op func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil || attempt >= p.MaxAttempts || !IsRetryable(err) {
+			return err
+		}
+
+		delay := p.delay(attempt)
+		logging.FromContext(ctx).WithError(err).WithFields(logrus.Fields{
+			"attempt":  attempt,
+			"delay_ms": delay.Milliseconds(),
+		}).Warn("Provider call failed, retrying")
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns how long to wait after the given failed attempt: the base
+// delay doubled for each earlier retry and capped at the maximum, of which a
+// random half is jitter so concurrent callers spread out
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// Retry is Do for calls that return a value
+func Retry[T any](ctx context.Context, policy RetryPolicy, op func(ctx context.Context) (T, error)) (T, error) {
+	var result T
+	err := policy.Do(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = op(ctx)
+		return err
+	})
+	return result, err
+}
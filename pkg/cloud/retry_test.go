@@ -0,0 +1,122 @@
+package cloud_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/logging"
+
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// apiError mimics the coded errors returned by the provider SDKs
+type apiError struct {
+	code   string
+	status int
+}
+
+func (e *apiError) Error() string       { return e.code }
+func (e *apiError) ErrorCode() string   { return e.code }
+func (e *apiError) HTTPStatusCode() int { return e.status }
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{fmt.Errorf("failed to stop instance: %w", &apiError{code: "RequestLimitExceeded", status: 503}), true},
+		{&apiError{code: "ThrottlingException", status: 400}, true},
+		{&apiError{code: "SomethingElse", status: 429}, true},
+		{&apiError{code: "InvalidInstanceID.NotFound", status: 400}, false},
+		{&apiError{code: "UnauthorizedOperation", status: 403}, false},
+		{context.DeadlineExceeded, false},
+		{errors.New("plain failure"), false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		if got := cloud.IsRetryable(test.err); got != test.expected {
+			t.Errorf("IsRetryable(%v) = %v, expected %v", test.err, got, test.expected)
+		}
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	policy := cloud.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	throttled := &apiError{code: "RequestLimitExceeded", status: 503}
+
+	calls := 0
+	err := policy.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return throttled
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Expected success on the third attempt, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = policy.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return throttled
+	})
+	if !errors.Is(err, throttled) || calls != 3 {
+		t.Errorf("Expected the last error after 3 attempts, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	notFound := &apiError{code: "InvalidInstanceID.NotFound", status: 400}
+	err = policy.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return notFound
+	})
+	if !errors.Is(err, notFound) || calls != 1 {
+		t.Errorf("Expected no retries for a permanent error, got %v after %d calls", err, calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	slow := cloud.RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	calls = 0
+	err = slow.Do(ctx, func(ctx context.Context) error {
+		calls++
+		cancel()
+		return throttled
+	})
+	if !errors.Is(err, throttled) || calls != 1 {
+		t.Errorf("Expected retries to stop with the context, got %v after %d calls", err, calls)
+	}
+}
+
+func TestRetryPolicy_DoLogs(t *testing.T) {
+	policy := cloud.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	logger, logs := test.NewNullLogger()
+	ctx := logging.WithLogger(context.Background(), logger.WithField("request_id", "req-1"))
+
+	policy.Do(ctx, func(ctx context.Context) error {
+		return &apiError{code: "Throttling", status: 400}
+	})
+	entries := logs.AllEntries()
+	if len(entries) != 1 || entries[0].Data["request_id"] != "req-1" || entries[0].Data["attempt"] != 1 {
+		t.Errorf("Expected the retry to be logged with the context's logger, got %+v", entries)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := cloud.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	calls := 0
+	value, err := cloud.Retry(context.Background(), policy, func(ctx context.Context) (string, error) {
+		calls++
+		if calls == 1 {
+			return "", &apiError{code: "Throttling", status: 400}
+		}
+		return "running", nil
+	})
+	if err != nil || value != "running" {
+		t.Errorf("Expected the value of the second attempt, got %q, %v", value, err)
+	}
+}
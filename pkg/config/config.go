@@ -2,14 +2,324 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"strings"
 	"time"
+
+	"instance-manager/pkg/hooks"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/ratelimit"
 )
 
 // Config holds the application configuration
 type Config struct {
 	AWS           AWSConfig
+	GCP           GCPConfig
+	Azure         AzureConfig
+	DigitalOcean  DigitalOceanConfig
+	Hetzner       HetznerConfig
+	Vultr         VultrConfig
+	OCI           OCIConfig
+	Docker        DockerConfig
+	Libvirt       LibvirtConfig
 	DefaultValues DefaultValues
+	// AllowedInstanceFamilies restricts instance types to these prefixes (e.g. "t2.", "t3.").
+	// An empty list allows every instance type.
+	AllowedInstanceFamilies []string
+	// MaxLifetime caps how long after launch an instance may run, however
+	// often it is extended. Zero means no limit.
+	MaxLifetime time.Duration
+	// ConnectionTemplate is a Go template over instance fields used to render
+	// connection commands. Empty means models.DefaultConnectionTemplate.
+	ConnectionTemplate string
+	// Scheduler holds the polling intervals and warnings of the service command
+	Scheduler SchedulerConfig
+	// Notifications configures where scheduler notifications are sent
+	Notifications NotificationsConfig
+	// Retry controls how throttled and other transient provider calls are
+	// retried
+	Retry RetryConfig
+	// Leader elects which of several service replicas manages the instances
+	Leader LeaderConfig
+	// Storage selects where instance records are kept
+	Storage StorageConfig
+	// Web configures who the web server acts for
+	Web WebConfig
+	// Hooks are run by the service before and after it stops an instance
+	// and before it terminates one
+	Hooks []hooks.Hook
+}
+
+// SchedulerConfig holds the settings of the background scheduler
+type SchedulerConfig struct {
+	// Interval is how often instances are checked for expiry
+	Interval time.Duration
+	// ReloadInterval is the longest the scheduler works from cached storage
+	// before reading it again
+	ReloadInterval time.Duration
+	// Concurrency is how many instances are checked at once
+	Concurrency int
+	// InstanceTimeout limits how long checking a single instance may take
+	InstanceTimeout time.Duration
+	// WarnBefore are the lead times before expiry at which running instances
+	// are warned about. Empty disables the warnings.
+	WarnBefore []time.Duration
+	// GracePeriod keeps expired instances running this long before they are
+	// stopped. Zero stops them at expiry.
+	GracePeriod time.Duration
+	// TerminateStoppedAfterDays terminates instances that have been stopped
+	// for more than this many days and archives their records. Zero keeps
+	// stopped instances.
+	TerminateStoppedAfterDays int
+	// Idle stops running instances that stayed below its thresholds for its
+	// window before they expire
+	Idle IdleConfig
+	// Orphans finds instances tagged as managed at the provider that are
+	// missing from storage
+	Orphans OrphansConfig
+	// Prune removes the records of terminated instances from storage
+	Prune PruneConfig
+	// Backup writes rotating backups of storage
+	Backup BackupConfig
+}
+
+// IdleConfig holds the idle policy of the scheduler
+type IdleConfig struct {
+	// Window is how long an instance must stay idle before it is stopped.
+	// Zero disables the idle policy.
+	Window time.Duration
+	// CPUPercent is the CPU utilization below which an instance is idle
+	CPUPercent float64
+	// NetworkBytesPerSecond is the network traffic, in and out combined,
+	// below which an instance is idle; zero ignores network traffic
+	NetworkBytesPerSecond float64
+}
+
+// OrphansConfig holds the orphan check of the scheduler
+type OrphansConfig struct {
+	// Interval is how often the provider is listed for orphans. Zero
+	// disables the check.
+	Interval time.Duration
+	// Adopt saves orphans to storage, so they are stopped at their expiry,
+	// instead of only reporting them
+	Adopt bool
+}
+
+// PruneConfig holds the retention of terminated instance records
+type PruneConfig struct {
+	// TerminatedAfterDays removes the records of instances terminated more
+	// than this many days ago. Zero keeps them.
+	TerminatedAfterDays int
+	// Archive moves pruned records to the archive instead of deleting them
+	Archive bool
+}
+
+// BackupConfig holds the rotating backups of storage taken by the scheduler
+type BackupConfig struct {
+	// Dir is the directory the backups are written to; empty is the backups
+	// directory next to the storage file
+	Dir string
+	// Interval is how often a backup is taken. Zero disables backups.
+	Interval time.Duration
+	// Keep is how many backups are kept; older ones are removed. Zero keeps
+	// them all.
+	Keep int
+}
+
+// NotificationsConfig holds the destinations of scheduler notifications
+type NotificationsConfig struct {
+	// WebhookURL receives each notification as a JSON POST; empty only logs them
+	WebhookURL string
+	// WebURL is the base URL of the web UI, linked from expiry warnings
+	WebURL string
+}
+
+// RetryConfig holds the retry policy of provider calls
+type RetryConfig struct {
+	// MaxAttempts is how often a call is tried in total; 1 disables retries
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry, doubled for each later one
+	BaseDelay time.Duration
+	// MaxDelay caps a single wait between attempts
+	MaxDelay time.Duration
+}
+
+// LeaderConfig holds the leader election of service replicas
+type LeaderConfig struct {
+	// Backend is "file", "consul" or "etcd"; empty runs a single replica
+	// without an election. The etcd backend uses the cluster of the etcd
+	// storage backend.
+	Backend string
+	// LockFile is the file locked by the file backend; empty locks a file
+	// next to the instance storage
+	LockFile string
+	// ConsulAddress is the HTTP address of the Consul agent
+	ConsulAddress string
+	// ConsulToken is the ACL token sent to Consul; empty sends none
+	ConsulToken string
+	// Key is the Consul key held by the leader, or the prefix of the keys
+	// the replicas campaign with in the etcd election
+	Key string
+	// TTL is how long the Consul session or etcd lease of a leader that
+	// stopped renewing it stays valid
+	TTL time.Duration
+}
+
+// StorageConfig selects where instance records are kept
+type StorageConfig struct {
+	// Backend is "file", "dynamodb", "postgres", "redis", "etcd", "s3" or
+	// "memory"; empty means file
+	Backend string
+	// DynamoDB configures the dynamodb backend
+	DynamoDB DynamoDBStorageConfig
+	// PostgresURL is the postgres:// URL of the database of the postgres
+	// backend
+	PostgresURL string
+	// Redis configures the redis backend
+	Redis RedisStorageConfig
+	// Etcd configures the etcd backend and the etcd leader election
+	Etcd EtcdStorageConfig
+	// S3 configures the s3 backend
+	S3 S3StorageConfig
+	// EncryptionKey encrypts the storage file of the file backend; it is
+	// 32 bytes in base64 or hex, and only read from the environment
+	EncryptionKey string
+	// EncryptionKeyFile is a file holding EncryptionKey, such as a systemd
+	// credential
+	EncryptionKeyFile string
+}
+
+// WebConfig holds the users of the web server
+type WebConfig struct {
+	// UserHeader is the request header in which an authenticating reverse
+	// proxy names the user, such as X-Forwarded-User. When set, each user
+	// only sees and manages the instances they created; empty lets everyone
+	// manage every instance.
+	UserHeader string
+	// TrustedProxies are the addresses, as CIDRs or IPs, of the reverse
+	// proxies in front of the web server. Only they may send UserHeader and
+	// X-Forwarded-Host; empty lets any client send UserHeader and ignores
+	// X-Forwarded-Host.
+	TrustedProxies []string
+	// Admins are the users who see and manage every instance and may pause
+	// the scheduler, as if listed under the admin role
+	Admins []string
+	// Roles lists, for each role, the users who get it: admin, operator or
+	// viewer
+	Roles map[string][]string
+	// DefaultRole is the role of users given none by Roles, Admins or their
+	// identity provider's groups
+	DefaultRole string
+	// APIKeys are the keys, by name, that API requests must carry in their
+	// Authorization header; empty requires none unless APIKeysFile exists
+	APIKeys map[string]string
+	// APIKeysFile holds the hashes of the keys managed with the keys
+	// command; empty means api_keys.json next to the instance storage
+	APIKeysFile string
+	// UsersFile holds the users managed with the users command, who sign in
+	// on the login page once it exists; empty means users.json next to the
+	// instance storage
+	UsersFile string
+	// SessionSecret signs the session tokens of users who signed in; empty
+	// generates one and keeps it in session.key next to UsersFile
+	SessionSecret string
+	// SessionTTL is how long users stay signed in
+	SessionTTL time.Duration
+	// SSO lets users sign in through an identity provider
+	SSO SSOConfig
+	// RateLimit bounds how fast clients may call the API
+	RateLimit RateLimitConfig
+}
+
+// RateLimitConfig holds the token bucket rate limits of the web API; a zero
+// limit is off
+type RateLimitConfig struct {
+	// PerIP bounds the requests from each client address
+	PerIP ratelimit.Limit
+	// PerToken bounds the requests of each API key, session or proxy user
+	PerToken ratelimit.Limit
+	// Changes bounds how many instances each client creates, extends, stops
+	// and terminates
+	Changes ratelimit.Limit
+	// ClientIPHeader is the header in which a proxy in front passes the
+	// client address, such as X-Forwarded-For; empty uses the connection's
+	ClientIPHeader string
+}
+
+// SSOConfig holds the identity provider users sign in with
+type SSOConfig struct {
+	// Provider is oidc or github; empty turns single sign-on off
+	Provider string
+	// Name is shown on the login button
+	Name string
+	// Issuer is the issuer URL of an OIDC provider, or the URL of a GitHub
+	// Enterprise server
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback URL registered with the provider; empty
+	// derives it from each request
+	RedirectURL    string
+	Scopes         []string
+	UsernameClaim  string
+	GroupsClaim    string
+	AllowedGroups  []string
+	AllowedDomains []string
+	// Roles lists, for each role, the groups whose members get it
+	Roles map[string][]string
+}
+
+// RedisStorageConfig holds the server of the redis storage backend
+type RedisStorageConfig struct {
+	// URL is the redis:// or rediss:// URL of the server
+	URL string
+	// Prefix is prepended to the keys, so several deployments can share a
+	// server
+	Prefix string
+}
+
+// EtcdStorageConfig holds the cluster of the etcd storage backend
+type EtcdStorageConfig struct {
+	// Endpoints of the cluster, such as http://127.0.0.1:2379
+	Endpoints []string
+	// Prefix is prepended to the keys, so several deployments can share a
+	// cluster
+	Prefix string
+	// Username authenticates with Password when set
+	Username string
+	Password string
+}
+
+// S3StorageConfig holds the object of the s3 storage backend
+type S3StorageConfig struct {
+	// Bucket holding the object
+	Bucket string
+	// Key of the object; empty means instances.json
+	Key string
+	// Region of the bucket; empty uses the region of the AWS credential chain
+	Region string
+	// Endpoint overrides the regional endpoint, e.g. for MinIO
+	Endpoint string
+	// Profile is the shared AWS config profile to take credentials from
+	Profile string
+}
+
+// DynamoDBStorageConfig holds the table of the dynamodb storage backend
+type DynamoDBStorageConfig struct {
+	// Table is the name of the table holding the records
+	Table string
+	// Region of the table; empty uses the region of the AWS credential chain
+	Region string
+	// Endpoint overrides the regional endpoint, e.g. for DynamoDB Local
+	Endpoint string
+	// Profile is the shared AWS config profile to take credentials from
+	Profile string
+	// CreateTable creates the table when it does not exist
+	CreateTable bool
+	// Retention is how long records of terminated and archived instances
+	// are kept before DynamoDB expires them; zero keeps them
+	Retention time.Duration
 }
 
 // AWSConfig holds AWS-specific configuration
@@ -17,6 +327,144 @@ type AWSConfig struct {
 	AccessKey string
 	SecretKey string
 	Region    string
+	// Profile is a shared config profile (~/.aws/config) to take credentials from
+	Profile string
+	// RoleARN is an IAM role to assume with the credentials above
+	RoleARN string
+	// ExternalID is passed when assuming RoleARN, if the role requires one
+	ExternalID string
+	// RoleSessionName names the assumed role session; empty uses the
+	// provider's default
+	RoleSessionName string
+	// Endpoint overrides the AWS API endpoint for every account, e.g. to
+	// point at LocalStack
+	Endpoint string
+	// Accounts are named AWS accounts instances can be created in besides
+	// the default one
+	Accounts map[string]AWSAccount
+	// Metadata holds the instance metadata service settings of new instances
+	Metadata models.MetadataOptions
+}
+
+// AWSAccount holds the credentials of a named AWS account. Either an access
+// key pair or a shared config profile may be given; with neither the default
+// credential chain is used. An account that only names a role assumes it
+// with the default account's credentials.
+type AWSAccount struct {
+	AccessKey       string
+	SecretKey       string
+	Profile         string
+	RoleARN         string
+	ExternalID      string
+	RoleSessionName string
+	// Region overrides the default region for the account
+	Region string
+}
+
+// ForAccount returns the AWS configuration of the named account. An empty
+// name returns the default account's configuration.
+func (c AWSConfig) ForAccount(name string) (AWSConfig, error) {
+	if name == "" {
+		return c, nil
+	}
+
+	account, ok := c.Accounts[name]
+	if !ok {
+		return AWSConfig{}, fmt.Errorf("unknown AWS account: %s", name)
+	}
+	if (account.AccessKey == "") != (account.SecretKey == "") {
+		return AWSConfig{}, fmt.Errorf("AWS account %s needs both an access key and a secret key", name)
+	}
+
+	region := account.Region
+	if region == "" {
+		region = c.Region
+	}
+	resolved := AWSConfig{
+		AccessKey:       account.AccessKey,
+		SecretKey:       account.SecretKey,
+		Profile:         account.Profile,
+		Region:          region,
+		RoleARN:         account.RoleARN,
+		ExternalID:      account.ExternalID,
+		RoleSessionName: account.RoleSessionName,
+		Endpoint:        c.Endpoint,
+	}
+	if resolved.RoleARN != "" && resolved.AccessKey == "" && resolved.Profile == "" {
+		resolved.AccessKey = c.AccessKey
+		resolved.SecretKey = c.SecretKey
+		resolved.Profile = c.Profile
+	}
+	return resolved, nil
+}
+
+// GCPConfig holds GCP-specific configuration
+type GCPConfig struct {
+	Project string
+	Zone    string
+	// CredentialsFile is a service account key file. When empty, Application
+	// Default Credentials are used.
+	CredentialsFile string
+}
+
+// AzureConfig holds Azure-specific configuration. Credentials come from the
+// Azure default credential chain (environment, managed identity, Azure CLI).
+type AzureConfig struct {
+	SubscriptionID string
+	ResourceGroup  string
+	Location       string
+}
+
+// DigitalOceanConfig holds DigitalOcean-specific configuration
+type DigitalOceanConfig struct {
+	Token  string
+	Region string
+}
+
+// HetznerConfig holds Hetzner Cloud-specific configuration
+type HetznerConfig struct {
+	Token    string
+	Location string
+}
+
+// VultrConfig holds Vultr-specific configuration
+type VultrConfig struct {
+	APIKey string
+	Region string
+}
+
+// OCIConfig holds Oracle Cloud-specific configuration. API keys are read
+// from the OCI config file (~/.oci/config unless ConfigFile is set).
+type OCIConfig struct {
+	CompartmentID string
+	// Region overrides the region of the config file profile
+	Region string
+	// AvailabilityDomain is used when none is given; empty means the
+	// region's first availability domain
+	AvailabilityDomain string
+	ConfigFile         string
+	Profile            string
+}
+
+// DockerConfig holds settings for the local Docker provider
+type DockerConfig struct {
+	// Host is the daemon address, in DOCKER_HOST form
+	Host string
+	// Image overrides the sshd image containers are created from
+	Image string
+}
+
+// LibvirtConfig holds settings for KVM hosts managed through libvirt
+type LibvirtConfig struct {
+	// URI is the libvirt connection URI, e.g. qemu+ssh://admin@kvm01/system
+	URI string
+	// Pool is the storage pool holding BaseImage and instance disks
+	Pool string
+	// BaseImage is the cloud image volume instance disks are backed by
+	BaseImage string
+	Network   string
+	// Username is the login user of the base image
+	Username string
 }
 
 // DefaultValues holds default configuration values
@@ -24,32 +472,351 @@ type DefaultValues struct {
 	InstanceType     string
 	Duration         time.Duration
 	AvailabilityZone string
+	// ExpiryAction is what happens at expiry to instances not given an
+	// expiry action; empty means stop
+	ExpiryAction string
 }
 
-// LoadConfig loads configuration from environment variables
+// LoadConfig loads configuration from the config file, if present, with
+// environment variables taking precedence. AWS credentials are optional, but
+// an access key must come with its secret key.
 func LoadConfig() (*Config, error) {
-	config := &Config{
+	return LoadConfigForProvider("aws")
+}
+
+// LoadConfigForProvider loads configuration like LoadConfig, but only
+// requires the settings of the named cloud provider
+func LoadConfigForProvider(provider string) (*Config, error) {
+	config, err := loadSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	switch provider {
+	case "aws":
+		// Without a key pair the SDK's default credential chain is used
+		if config.AWS.AccessKey == "" && config.AWS.SecretKey != "" {
+			return nil, errors.New("AWS_ACCESS_KEY_ID environment variable is required with AWS_SECRET_ACCESS_KEY")
+		}
+		if config.AWS.SecretKey == "" && config.AWS.AccessKey != "" {
+			return nil, errors.New("AWS_SECRET_ACCESS_KEY environment variable is required with AWS_ACCESS_KEY_ID")
+		}
+	case "gcp":
+		if config.GCP.Project == "" {
+			return nil, errors.New("GCP_PROJECT environment variable is required")
+		}
+	case "azure":
+		if config.Azure.SubscriptionID == "" {
+			return nil, errors.New("AZURE_SUBSCRIPTION_ID environment variable is required")
+		}
+	case "digitalocean":
+		if config.DigitalOcean.Token == "" {
+			return nil, errors.New("DIGITALOCEAN_TOKEN environment variable is required")
+		}
+	case "hetzner":
+		if config.Hetzner.Token == "" {
+			return nil, errors.New("HCLOUD_TOKEN environment variable is required")
+		}
+	case "vultr":
+		if config.Vultr.APIKey == "" {
+			return nil, errors.New("VULTR_API_KEY environment variable is required")
+		}
+	case "oci":
+		if config.OCI.CompartmentID == "" {
+			return nil, errors.New("OCI_COMPARTMENT_ID environment variable is required")
+		}
+	case "libvirt":
+		if config.Libvirt.BaseImage == "" {
+			return nil, errors.New("LIBVIRT_BASE_IMAGE environment variable is required")
+		}
+	}
+
+	return config, nil
+}
+
+// LoadConnectionTemplate returns the configured connection template without
+// requiring AWS credentials, for commands that only read local storage
+func LoadConnectionTemplate() (string, error) {
+	config, err := loadSettings()
+	if err != nil {
+		return "", err
+	}
+	return config.ConnectionTemplate, nil
+}
+
+// LoadSchedulerConfig returns the configured scheduler intervals without
+// requiring provider credentials
+func LoadSchedulerConfig() (SchedulerConfig, error) {
+	config, err := loadSettings()
+	if err != nil {
+		return SchedulerConfig{}, err
+	}
+	return config.Scheduler, nil
+}
+
+// LoadNotificationsConfig returns the configured notification destinations
+// without requiring provider credentials
+func LoadNotificationsConfig() (NotificationsConfig, error) {
+	config, err := loadSettings()
+	if err != nil {
+		return NotificationsConfig{}, err
+	}
+	return config.Notifications, nil
+}
+
+// LoadRetryConfig returns the configured retry policy of provider calls
+// without requiring provider credentials
+func LoadRetryConfig() (RetryConfig, error) {
+	config, err := loadSettings()
+	if err != nil {
+		return RetryConfig{}, err
+	}
+	return config.Retry, nil
+}
+
+// LoadLeaderConfig returns the configured leader election without requiring
+// provider credentials
+func LoadLeaderConfig() (LeaderConfig, error) {
+	config, err := loadSettings()
+	if err != nil {
+		return LeaderConfig{}, err
+	}
+	return config.Leader, nil
+}
+
+// LoadStorageConfig returns the configured storage backend without
+// requiring provider credentials
+func LoadStorageConfig() (StorageConfig, error) {
+	config, err := loadSettings()
+	if err != nil {
+		return StorageConfig{}, err
+	}
+	return config.Storage, nil
+}
+
+// LoadWebConfig returns the configured users, API keys and sessions of the
+// web server
+// without requiring provider credentials
+func LoadWebConfig() (WebConfig, error) {
+	config, err := loadSettings()
+	if err != nil {
+		return WebConfig{}, err
+	}
+	return config.Web, nil
+}
+
+// LoadDefaultValues returns the configured defaults of new instances without
+// requiring provider credentials
+func LoadDefaultValues() (DefaultValues, error) {
+	config, err := loadSettings()
+	if err != nil {
+		return DefaultValues{}, err
+	}
+	return config.DefaultValues, nil
+}
+
+// LoadMaxLifetime returns the configured maximum instance lifetime without
+// requiring provider credentials
+func LoadMaxLifetime() (time.Duration, error) {
+	config, err := loadSettings()
+	if err != nil {
+		return 0, err
+	}
+	return config.MaxLifetime, nil
+}
+
+// LoadHooks returns the configured lifecycle hooks without requiring
+// provider credentials
+func LoadHooks() ([]hooks.Hook, error) {
+	config, err := loadSettings()
+	if err != nil {
+		return nil, err
+	}
+	return config.Hooks, nil
+}
+
+// loadSettings merges the defaults, the config file and the environment
+func loadSettings() (*Config, error) {
+	config := defaultConfig()
+
+	path := DefaultConfigPath()
+	if _, err := os.Stat(path); err == nil {
+		config, err = LoadConfigFromFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config.AWS.AccessKey = getEnvOrDefault("AWS_ACCESS_KEY_ID", config.AWS.AccessKey)
+	config.AWS.SecretKey = getEnvOrDefault("AWS_SECRET_ACCESS_KEY", config.AWS.SecretKey)
+	config.AWS.Region = getEnvOrDefault("AWS_REGION", config.AWS.Region)
+	config.AWS.Profile = getEnvOrDefault("AWS_PROFILE", config.AWS.Profile)
+	config.AWS.Endpoint = getEnvOrDefault("AWS_ENDPOINT_URL", config.AWS.Endpoint)
+	config.AWS.RoleARN = getEnvOrDefault("AWS_ROLE_ARN", config.AWS.RoleARN)
+	config.AWS.ExternalID = getEnvOrDefault("AWS_ROLE_EXTERNAL_ID", config.AWS.ExternalID)
+	config.AWS.RoleSessionName = getEnvOrDefault("AWS_ROLE_SESSION_NAME", config.AWS.RoleSessionName)
+	config.GCP.Project = getEnvOrDefault("GCP_PROJECT", config.GCP.Project)
+	config.GCP.Zone = getEnvOrDefault("GCP_ZONE", config.GCP.Zone)
+	config.GCP.CredentialsFile = getEnvOrDefault("GOOGLE_APPLICATION_CREDENTIALS", config.GCP.CredentialsFile)
+	config.Azure.SubscriptionID = getEnvOrDefault("AZURE_SUBSCRIPTION_ID", config.Azure.SubscriptionID)
+	config.Azure.ResourceGroup = getEnvOrDefault("AZURE_RESOURCE_GROUP", config.Azure.ResourceGroup)
+	config.Azure.Location = getEnvOrDefault("AZURE_LOCATION", config.Azure.Location)
+	config.DigitalOcean.Token = getEnvOrDefault("DIGITALOCEAN_TOKEN", config.DigitalOcean.Token)
+	config.DigitalOcean.Region = getEnvOrDefault("DIGITALOCEAN_REGION", config.DigitalOcean.Region)
+	config.Hetzner.Token = getEnvOrDefault("HCLOUD_TOKEN", config.Hetzner.Token)
+	config.Hetzner.Location = getEnvOrDefault("HCLOUD_LOCATION", config.Hetzner.Location)
+	config.Vultr.APIKey = getEnvOrDefault("VULTR_API_KEY", config.Vultr.APIKey)
+	config.Vultr.Region = getEnvOrDefault("VULTR_REGION", config.Vultr.Region)
+	config.OCI.CompartmentID = getEnvOrDefault("OCI_COMPARTMENT_ID", config.OCI.CompartmentID)
+	config.OCI.Region = getEnvOrDefault("OCI_REGION", config.OCI.Region)
+	config.OCI.AvailabilityDomain = getEnvOrDefault("OCI_AVAILABILITY_DOMAIN", config.OCI.AvailabilityDomain)
+	config.OCI.ConfigFile = getEnvOrDefault("OCI_CONFIG_FILE", config.OCI.ConfigFile)
+	config.OCI.Profile = getEnvOrDefault("OCI_PROFILE", config.OCI.Profile)
+	config.Docker.Host = getEnvOrDefault("DOCKER_HOST", config.Docker.Host)
+	config.Docker.Image = getEnvOrDefault("DOCKER_IMAGE", config.Docker.Image)
+	config.Libvirt.URI = getEnvOrDefault("LIBVIRT_DEFAULT_URI", config.Libvirt.URI)
+	config.Libvirt.Pool = getEnvOrDefault("LIBVIRT_POOL", config.Libvirt.Pool)
+	config.Libvirt.BaseImage = getEnvOrDefault("LIBVIRT_BASE_IMAGE", config.Libvirt.BaseImage)
+	config.Libvirt.Network = getEnvOrDefault("LIBVIRT_NETWORK", config.Libvirt.Network)
+	config.Libvirt.Username = getEnvOrDefault("LIBVIRT_USERNAME", config.Libvirt.Username)
+	if families := getEnvList("ALLOWED_INSTANCE_FAMILIES"); len(families) > 0 {
+		config.AllowedInstanceFamilies = families
+	}
+	config.ConnectionTemplate = getEnvOrDefault("CONNECTION_TEMPLATE", config.ConnectionTemplate)
+	if value := os.Getenv("MAX_LIFETIME"); value != "" {
+		maxLifetime, err := time.ParseDuration(value)
+		if err != nil || maxLifetime < 0 {
+			return nil, fmt.Errorf("invalid MAX_LIFETIME: %q", value)
+		}
+		config.MaxLifetime = maxLifetime
+	}
+	config.Notifications.WebhookURL = getEnvOrDefault("NOTIFY_WEBHOOK_URL", config.Notifications.WebhookURL)
+	config.Notifications.WebURL = getEnvOrDefault("NOTIFY_WEB_URL", config.Notifications.WebURL)
+	config.Leader.ConsulAddress = getEnvOrDefault("CONSUL_HTTP_ADDR", config.Leader.ConsulAddress)
+	config.Leader.ConsulToken = getEnvOrDefault("CONSUL_HTTP_TOKEN", config.Leader.ConsulToken)
+	config.Storage.PostgresURL = getEnvOrDefault("POSTGRES_URL", config.Storage.PostgresURL)
+	config.Storage.Redis.URL = getEnvOrDefault("REDIS_URL", config.Storage.Redis.URL)
+	if endpoints := getEnvList("ETCD_ENDPOINTS"); len(endpoints) > 0 {
+		config.Storage.Etcd.Endpoints = endpoints
+	}
+	config.Storage.Etcd.Username = getEnvOrDefault("ETCD_USERNAME", config.Storage.Etcd.Username)
+	config.Storage.Etcd.Password = getEnvOrDefault("ETCD_PASSWORD", config.Storage.Etcd.Password)
+	config.Storage.EncryptionKey = getEnvOrDefault("STORAGE_ENCRYPTION_KEY", config.Storage.EncryptionKey)
+	config.Storage.EncryptionKeyFile = getEnvOrDefault("STORAGE_ENCRYPTION_KEY_FILE", config.Storage.EncryptionKeyFile)
+	config.Web.UserHeader = getEnvOrDefault("WEB_USER_HEADER", config.Web.UserHeader)
+	if admins := getEnvList("WEB_ADMINS"); len(admins) > 0 {
+		config.Web.Admins = admins
+	}
+	if proxies := getEnvList("WEB_TRUSTED_PROXIES"); len(proxies) > 0 {
+		config.Web.TrustedProxies = proxies
+	}
+	if keys := getEnvList("WEB_API_KEYS"); len(keys) > 0 {
+		config.Web.APIKeys = make(map[string]string, len(keys))
+		for _, pair := range keys {
+			name, key, found := strings.Cut(pair, "=")
+			if !found || name == "" || key == "" {
+				return nil, errors.New("invalid WEB_API_KEYS: expected name=key pairs separated by commas")
+			}
+			config.Web.APIKeys[name] = key
+		}
+	}
+	config.Web.SSO.ClientID = getEnvOrDefault("WEB_SSO_CLIENT_ID", config.Web.SSO.ClientID)
+	config.Web.SSO.ClientSecret = getEnvOrDefault("WEB_SSO_CLIENT_SECRET", config.Web.SSO.ClientSecret)
+	config.Web.SessionSecret = getEnvOrDefault("WEB_SESSION_SECRET", config.Web.SessionSecret)
+	if config.Web.SessionSecret != "" && len(config.Web.SessionSecret) < 32 {
+		return nil, errors.New("invalid WEB_SESSION_SECRET: use at least 32 characters")
+	}
+	if _, err := models.ParseConnectionTemplate(config.ConnectionTemplate); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// defaultConfig returns the built-in configuration defaults
+func defaultConfig() *Config {
+	return &Config{
 		AWS: AWSConfig{
-			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
-			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
-			Region:    getEnvOrDefault("AWS_REGION", "us-east-1"),
+			Region: "us-east-1",
+		},
+		GCP: GCPConfig{
+			Zone: "us-central1-a",
+		},
+		Azure: AzureConfig{
+			ResourceGroup: "instance-manager",
+			Location:      "eastus",
+		},
+		DigitalOcean: DigitalOceanConfig{
+			Region: "nyc3",
+		},
+		Hetzner: HetznerConfig{
+			Location: "fsn1",
+		},
+		Vultr: VultrConfig{
+			Region: "ewr",
+		},
+		OCI: OCIConfig{
+			Profile: "DEFAULT",
+		},
+		Docker: DockerConfig{
+			Host: "unix:///var/run/docker.sock",
+		},
+		Libvirt: LibvirtConfig{
+			URI:      "qemu:///system",
+			Pool:     "default",
+			Network:  "default",
+			Username: "ubuntu",
 		},
 		DefaultValues: DefaultValues{
 			InstanceType:     "t2.nano",
 			Duration:         1 * time.Hour,
 			AvailabilityZone: "us-east-1a",
 		},
+		Scheduler: SchedulerConfig{
+			Interval:        30 * time.Second,
+			ReloadInterval:  10 * time.Second,
+			Concurrency:     10,
+			InstanceTimeout: 2 * time.Minute,
+			WarnBefore:      []time.Duration{30 * time.Minute, 10 * time.Minute, time.Minute},
+			Idle: IdleConfig{
+				CPUPercent:            5,
+				NetworkBytesPerSecond: 10 * 1024,
+			},
+			Orphans: OrphansConfig{
+				Interval: 15 * time.Minute,
+			},
+			Backup: BackupConfig{
+				Keep: 7,
+			},
+		},
+		Retry: RetryConfig{
+			MaxAttempts: 4,
+			BaseDelay:   500 * time.Millisecond,
+			MaxDelay:    10 * time.Second,
+		},
+		Leader: LeaderConfig{
+			ConsulAddress: "http://127.0.0.1:8500",
+			Key:           "instance-manager/leader",
+			TTL:           time.Minute,
+		},
+		Web: WebConfig{
+			DefaultRole: "operator",
+			SessionTTL:  12 * time.Hour,
+			RateLimit: RateLimitConfig{
+				PerIP:    ratelimit.Limit{PerMinute: 1200, Burst: 200},
+				PerToken: ratelimit.Limit{PerMinute: 600, Burst: 100},
+				Changes:  ratelimit.Limit{PerMinute: 10, Burst: 5},
+			},
+		},
+		Storage: StorageConfig{
+			DynamoDB: DynamoDBStorageConfig{
+				Table: "instance-manager",
+			},
+			Etcd: EtcdStorageConfig{
+				Endpoints: []string{"http://127.0.0.1:2379"},
+			},
+		},
 	}
-
-	// Validate required environment variables
-	if config.AWS.AccessKey == "" {
-		return nil, errors.New("AWS_ACCESS_KEY_ID environment variable is required")
-	}
-	if config.AWS.SecretKey == "" {
-		return nil, errors.New("AWS_SECRET_ACCESS_KEY environment variable is required")
-	}
-
-	return config, nil
 }
 
 // getEnvOrDefault returns the value of an environment variable or a default value
@@ -60,6 +827,17 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvList returns the comma-separated values of an environment variable
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 // ValidatePublicKeyPath validates that the public key file exists and is readable
 func ValidatePublicKeyPath(path string) error {
 	if path == "" {
@@ -9,6 +9,9 @@ import (
 )
 
 func TestLoadConfig(t *testing.T) {
+	// Ignore any config file on the machine running the tests
+	t.Setenv(config.ConfigPathEnv, filepath.Join(t.TempDir(), "missing.yaml"))
+
 	// Save original environment variables
 	originalAccessKey := os.Getenv("AWS_ACCESS_KEY_ID")
 	originalSecretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
@@ -49,6 +52,13 @@ func TestLoadConfig(t *testing.T) {
 			region:    "us-west-2",
 			hasError:  true,
 		},
+		{
+			name:      "default credential chain",
+			accessKey: "",
+			secretKey: "",
+			region:    "us-west-2",
+			hasError:  false,
+		},
 		{
 			name:      "default region",
 			accessKey: "test-access-key",
@@ -157,3 +167,235 @@ func TestValidatePublicKeyPath(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadConfig_Profile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("aws:\n  profile: file-profile\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	t.Setenv(config.ConfigPathEnv, path)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	t.Setenv("AWS_PROFILE", "")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("Expected the shared credentials to be optional, got %v", err)
+	}
+	if cfg.AWS.Profile != "file-profile" {
+		t.Errorf("Expected profile from the config file, got %q", cfg.AWS.Profile)
+	}
+
+	t.Setenv("AWS_PROFILE", "env-profile")
+	cfg, err = config.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.AWS.Profile != "env-profile" {
+		t.Errorf("Expected AWS_PROFILE to override the config file, got %q", cfg.AWS.Profile)
+	}
+}
+
+func TestLoadConfig_AllowedInstanceFamilies(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test-access-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test-secret-key")
+	t.Setenv("ALLOWED_INSTANCE_FAMILIES", "t2., t3.,")
+	t.Setenv(config.ConfigPathEnv, filepath.Join(t.TempDir(), "missing.yaml"))
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []string{"t2.", "t3."}
+	if len(cfg.AllowedInstanceFamilies) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, cfg.AllowedInstanceFamilies)
+	}
+	for i, prefix := range expected {
+		if cfg.AllowedInstanceFamilies[i] != prefix {
+			t.Errorf("Prefix %d: got %q, want %q", i, cfg.AllowedInstanceFamilies[i], prefix)
+		}
+	}
+}
+
+func TestLoadConfigForProvider_GCP(t *testing.T) {
+	t.Setenv(config.ConfigPathEnv, filepath.Join(t.TempDir(), "missing.yaml"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("GCP_PROJECT", "")
+	t.Setenv("GCP_ZONE", "")
+
+	if _, err := config.LoadConfigForProvider("gcp"); err == nil {
+		t.Error("Expected an error without GCP_PROJECT")
+	}
+
+	t.Setenv("GCP_PROJECT", "my-project")
+	cfg, err := config.LoadConfigForProvider("gcp")
+	if err != nil {
+		t.Fatalf("Expected GCP config without AWS credentials, got %v", err)
+	}
+	if cfg.GCP.Project != "my-project" {
+		t.Errorf("Expected project my-project, got %s", cfg.GCP.Project)
+	}
+	if cfg.GCP.Zone != "us-central1-a" {
+		t.Errorf("Expected default zone us-central1-a, got %s", cfg.GCP.Zone)
+	}
+
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test-secret-key")
+	if _, err := config.LoadConfigForProvider("aws"); err == nil {
+		t.Error("Expected an error for an AWS secret key without an access key")
+	}
+}
+
+func TestLoadConfigForProvider_Azure(t *testing.T) {
+	t.Setenv(config.ConfigPathEnv, filepath.Join(t.TempDir(), "missing.yaml"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AZURE_SUBSCRIPTION_ID", "")
+	t.Setenv("AZURE_RESOURCE_GROUP", "")
+	t.Setenv("AZURE_LOCATION", "westeurope")
+
+	if _, err := config.LoadConfigForProvider("azure"); err == nil {
+		t.Error("Expected an error without AZURE_SUBSCRIPTION_ID")
+	}
+
+	t.Setenv("AZURE_SUBSCRIPTION_ID", "00000000-0000-0000-0000-000000000000")
+	cfg, err := config.LoadConfigForProvider("azure")
+	if err != nil {
+		t.Fatalf("Expected Azure config without AWS credentials, got %v", err)
+	}
+	if cfg.Azure.ResourceGroup != "instance-manager" {
+		t.Errorf("Expected default resource group, got %s", cfg.Azure.ResourceGroup)
+	}
+	if cfg.Azure.Location != "westeurope" {
+		t.Errorf("Expected location westeurope, got %s", cfg.Azure.Location)
+	}
+}
+
+func TestLoadConfigForProvider_DigitalOcean(t *testing.T) {
+	t.Setenv(config.ConfigPathEnv, filepath.Join(t.TempDir(), "missing.yaml"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("DIGITALOCEAN_TOKEN", "")
+	t.Setenv("DIGITALOCEAN_REGION", "")
+
+	if _, err := config.LoadConfigForProvider("digitalocean"); err == nil {
+		t.Error("Expected an error without DIGITALOCEAN_TOKEN")
+	}
+
+	t.Setenv("DIGITALOCEAN_TOKEN", "dop_v1_test")
+	cfg, err := config.LoadConfigForProvider("digitalocean")
+	if err != nil {
+		t.Fatalf("Expected DigitalOcean config without AWS credentials, got %v", err)
+	}
+	if cfg.DigitalOcean.Token != "dop_v1_test" {
+		t.Errorf("Expected token from environment, got %s", cfg.DigitalOcean.Token)
+	}
+	if cfg.DigitalOcean.Region != "nyc3" {
+		t.Errorf("Expected default region nyc3, got %s", cfg.DigitalOcean.Region)
+	}
+}
+
+func TestLoadConfigForProvider_Hetzner(t *testing.T) {
+	t.Setenv(config.ConfigPathEnv, filepath.Join(t.TempDir(), "missing.yaml"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("HCLOUD_TOKEN", "")
+	t.Setenv("HCLOUD_LOCATION", "hel1")
+
+	if _, err := config.LoadConfigForProvider("hetzner"); err == nil {
+		t.Error("Expected an error without HCLOUD_TOKEN")
+	}
+
+	t.Setenv("HCLOUD_TOKEN", "test-token")
+	cfg, err := config.LoadConfigForProvider("hetzner")
+	if err != nil {
+		t.Fatalf("Expected Hetzner config without AWS credentials, got %v", err)
+	}
+	if cfg.Hetzner.Token != "test-token" || cfg.Hetzner.Location != "hel1" {
+		t.Errorf("Unexpected Hetzner config %+v", cfg.Hetzner)
+	}
+}
+
+func TestLoadConfigForProvider_Vultr(t *testing.T) {
+	t.Setenv(config.ConfigPathEnv, filepath.Join(t.TempDir(), "missing.yaml"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("VULTR_API_KEY", "")
+	t.Setenv("VULTR_REGION", "")
+
+	if _, err := config.LoadConfigForProvider("vultr"); err == nil {
+		t.Error("Expected an error without VULTR_API_KEY")
+	}
+
+	t.Setenv("VULTR_API_KEY", "test-key")
+	cfg, err := config.LoadConfigForProvider("vultr")
+	if err != nil {
+		t.Fatalf("Expected Vultr config without AWS credentials, got %v", err)
+	}
+	if cfg.Vultr.APIKey != "test-key" || cfg.Vultr.Region != "ewr" {
+		t.Errorf("Unexpected Vultr config %+v", cfg.Vultr)
+	}
+}
+
+func TestLoadConfigForProvider_OCI(t *testing.T) {
+	t.Setenv(config.ConfigPathEnv, filepath.Join(t.TempDir(), "missing.yaml"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("OCI_COMPARTMENT_ID", "")
+	t.Setenv("OCI_PROFILE", "")
+	t.Setenv("OCI_AVAILABILITY_DOMAIN", "AD-2")
+
+	if _, err := config.LoadConfigForProvider("oci"); err == nil {
+		t.Error("Expected an error without OCI_COMPARTMENT_ID")
+	}
+
+	t.Setenv("OCI_COMPARTMENT_ID", "ocid1.compartment.oc1..dev")
+	cfg, err := config.LoadConfigForProvider("oci")
+	if err != nil {
+		t.Fatalf("Expected OCI config without AWS credentials, got %v", err)
+	}
+	if cfg.OCI.Profile != "DEFAULT" || cfg.OCI.AvailabilityDomain != "AD-2" {
+		t.Errorf("Unexpected OCI config %+v", cfg.OCI)
+	}
+}
+
+func TestLoadConfigForProvider_Libvirt(t *testing.T) {
+	t.Setenv(config.ConfigPathEnv, filepath.Join(t.TempDir(), "missing.yaml"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("LIBVIRT_DEFAULT_URI", "qemu+ssh://admin@kvm01/system")
+	t.Setenv("LIBVIRT_BASE_IMAGE", "")
+	t.Setenv("LIBVIRT_POOL", "")
+
+	if _, err := config.LoadConfigForProvider("libvirt"); err == nil {
+		t.Error("Expected an error without LIBVIRT_BASE_IMAGE")
+	}
+
+	t.Setenv("LIBVIRT_BASE_IMAGE", "jammy-server-cloudimg-amd64.img")
+	cfg, err := config.LoadConfigForProvider("libvirt")
+	if err != nil {
+		t.Fatalf("Expected libvirt config without AWS credentials, got %v", err)
+	}
+	if cfg.Libvirt.URI != "qemu+ssh://admin@kvm01/system" || cfg.Libvirt.Pool != "default" || cfg.Libvirt.Username != "ubuntu" {
+		t.Errorf("Unexpected libvirt config %+v", cfg.Libvirt)
+	}
+}
+
+func TestLoadConfigForProvider_Docker(t *testing.T) {
+	t.Setenv(config.ConfigPathEnv, filepath.Join(t.TempDir(), "missing.yaml"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_IMAGE", "")
+
+	cfg, err := config.LoadConfigForProvider("docker")
+	if err != nil {
+		t.Fatalf("Expected Docker config without credentials, got %v", err)
+	}
+	if cfg.Docker.Host != "unix:///var/run/docker.sock" || cfg.Docker.Image != "" {
+		t.Errorf("Unexpected Docker config %+v", cfg.Docker)
+	}
+
+	t.Setenv("DOCKER_HOST", "tcp://127.0.0.1:2375")
+	cfg, err = config.LoadConfigForProvider("docker")
+	if err != nil {
+		t.Fatalf("LoadConfigForProvider failed: %v", err)
+	}
+	if cfg.Docker.Host != "tcp://127.0.0.1:2375" {
+		t.Errorf("Expected DOCKER_HOST to be used, got %s", cfg.Docker.Host)
+	}
+}
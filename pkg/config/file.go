@@ -0,0 +1,1173 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"instance-manager/pkg/apikey"
+	"instance-manager/pkg/hooks"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/ratelimit"
+	"instance-manager/pkg/users"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigPathEnv overrides the location of the config file
+const ConfigPathEnv = "INSTANCE_MANAGER_CONFIG"
+
+// fileConfig mirrors the layout of the YAML config file
+type fileConfig struct {
+	AWS struct {
+		AccessKeyID     string                    `yaml:"access_key_id"`
+		SecretAccessKey string                    `yaml:"secret_access_key"`
+		Region          string                    `yaml:"region"`
+		Profile         string                    `yaml:"profile"`
+		RoleARN         string                    `yaml:"role_arn"`
+		ExternalID      string                    `yaml:"external_id"`
+		RoleSessionName string                    `yaml:"role_session_name"`
+		EndpointURL     string                    `yaml:"endpoint_url"`
+		Accounts        map[string]fileAWSAccount `yaml:"accounts"`
+		Metadata        struct {
+			HTTPTokens string `yaml:"http_tokens"`
+			HopLimit   int32  `yaml:"hop_limit"`
+			Endpoint   string `yaml:"endpoint"`
+		} `yaml:"metadata"`
+	} `yaml:"aws"`
+	GCP struct {
+		Project         string `yaml:"project"`
+		Zone            string `yaml:"zone"`
+		CredentialsFile string `yaml:"credentials_file"`
+	} `yaml:"gcp"`
+	Azure struct {
+		SubscriptionID string `yaml:"subscription_id"`
+		ResourceGroup  string `yaml:"resource_group"`
+		Location       string `yaml:"location"`
+	} `yaml:"azure"`
+	DigitalOcean struct {
+		Token  string `yaml:"token"`
+		Region string `yaml:"region"`
+	} `yaml:"digitalocean"`
+	Hetzner struct {
+		Token    string `yaml:"token"`
+		Location string `yaml:"location"`
+	} `yaml:"hetzner"`
+	Vultr struct {
+		APIKey string `yaml:"api_key"`
+		Region string `yaml:"region"`
+	} `yaml:"vultr"`
+	OCI struct {
+		CompartmentID      string `yaml:"compartment_id"`
+		Region             string `yaml:"region"`
+		AvailabilityDomain string `yaml:"availability_domain"`
+		ConfigFile         string `yaml:"config_file"`
+		Profile            string `yaml:"profile"`
+	} `yaml:"oci"`
+	Docker struct {
+		Host  string `yaml:"host"`
+		Image string `yaml:"image"`
+	} `yaml:"docker"`
+	Libvirt struct {
+		URI       string `yaml:"uri"`
+		Pool      string `yaml:"pool"`
+		BaseImage string `yaml:"base_image"`
+		Network   string `yaml:"network"`
+		Username  string `yaml:"username"`
+	} `yaml:"libvirt"`
+	Defaults struct {
+		InstanceType     string `yaml:"instance_type"`
+		Duration         string `yaml:"duration"`
+		AvailabilityZone string `yaml:"availability_zone"`
+		ExpiryAction     string `yaml:"expiry_action"`
+	} `yaml:"defaults"`
+	Scheduler struct {
+		Interval                  string   `yaml:"interval"`
+		ReloadInterval            string   `yaml:"reload_interval"`
+		Concurrency               int      `yaml:"concurrency"`
+		InstanceTimeout           string   `yaml:"instance_timeout"`
+		WarnBefore                []string `yaml:"warn_before"`
+		GracePeriod               string   `yaml:"grace_period"`
+		TerminateStoppedAfterDays int      `yaml:"terminate_stopped_after_days"`
+		Idle                      struct {
+			Window                string   `yaml:"window"`
+			CPUPercent            *float64 `yaml:"cpu_percent"`
+			NetworkBytesPerSecond *float64 `yaml:"network_bytes_per_second"`
+		} `yaml:"idle"`
+		Orphans struct {
+			Interval string `yaml:"interval"`
+			Adopt    bool   `yaml:"adopt"`
+		} `yaml:"orphans"`
+		Prune struct {
+			TerminatedAfterDays int  `yaml:"terminated_after_days"`
+			Archive             bool `yaml:"archive"`
+		} `yaml:"prune"`
+		Backup struct {
+			Dir      string `yaml:"dir"`
+			Interval string `yaml:"interval"`
+			Keep     *int   `yaml:"keep"`
+		} `yaml:"backup"`
+	} `yaml:"scheduler"`
+	Notifications struct {
+		WebhookURL string `yaml:"webhook_url"`
+		WebURL     string `yaml:"web_url"`
+	} `yaml:"notifications"`
+	Retry struct {
+		MaxAttempts int    `yaml:"max_attempts"`
+		BaseDelay   string `yaml:"base_delay"`
+		MaxDelay    string `yaml:"max_delay"`
+	} `yaml:"retry"`
+	Leader struct {
+		Backend       string `yaml:"backend"`
+		LockFile      string `yaml:"lock_file"`
+		ConsulAddress string `yaml:"consul_address"`
+		ConsulToken   string `yaml:"consul_token"`
+		Key           string `yaml:"key"`
+		TTL           string `yaml:"ttl"`
+	} `yaml:"leader"`
+	Storage struct {
+		Backend           string `yaml:"backend"`
+		EncryptionKeyFile string `yaml:"encryption_key_file"`
+		DynamoDB          struct {
+			Table       string `yaml:"table"`
+			Region      string `yaml:"region"`
+			Endpoint    string `yaml:"endpoint"`
+			Profile     string `yaml:"profile"`
+			CreateTable bool   `yaml:"create_table"`
+			Retention   string `yaml:"retention"`
+		} `yaml:"dynamodb"`
+		Postgres struct {
+			URL string `yaml:"url"`
+		} `yaml:"postgres"`
+		Redis struct {
+			URL    string `yaml:"url"`
+			Prefix string `yaml:"prefix"`
+		} `yaml:"redis"`
+		Etcd struct {
+			Endpoints []string `yaml:"endpoints"`
+			Prefix    string   `yaml:"prefix"`
+			Username  string   `yaml:"username"`
+			Password  string   `yaml:"password"`
+		} `yaml:"etcd"`
+		S3 struct {
+			Bucket   string `yaml:"bucket"`
+			Key      string `yaml:"key"`
+			Region   string `yaml:"region"`
+			Endpoint string `yaml:"endpoint"`
+			Profile  string `yaml:"profile"`
+		} `yaml:"s3"`
+	} `yaml:"storage"`
+	Web struct {
+		UserHeader     string              `yaml:"user_header"`
+		TrustedProxies []string            `yaml:"trusted_proxies"`
+		Admins         []string            `yaml:"admins"`
+		Roles          map[string][]string `yaml:"roles"`
+		DefaultRole    string              `yaml:"default_role"`
+		APIKeys        map[string]string   `yaml:"api_keys"`
+		APIKeysFile    string              `yaml:"api_keys_file"`
+		UsersFile      string              `yaml:"users_file"`
+		SessionSecret  string              `yaml:"session_secret"`
+		SessionTTL     string              `yaml:"session_ttl"`
+		SSO            struct {
+			Provider       string              `yaml:"provider"`
+			Name           string              `yaml:"name"`
+			Issuer         string              `yaml:"issuer"`
+			ClientID       string              `yaml:"client_id"`
+			ClientSecret   string              `yaml:"client_secret"`
+			RedirectURL    string              `yaml:"redirect_url"`
+			Scopes         []string            `yaml:"scopes"`
+			UsernameClaim  string              `yaml:"username_claim"`
+			GroupsClaim    string              `yaml:"groups_claim"`
+			AllowedGroups  []string            `yaml:"allowed_groups"`
+			AllowedDomains []string            `yaml:"allowed_domains"`
+			Roles          map[string][]string `yaml:"roles"`
+		} `yaml:"sso"`
+		RateLimit struct {
+			PerIP          fileRateLimit `yaml:"per_ip"`
+			PerToken       fileRateLimit `yaml:"per_token"`
+			Changes        fileRateLimit `yaml:"changes"`
+			ClientIPHeader string        `yaml:"client_ip_header"`
+		} `yaml:"rate_limit"`
+	} `yaml:"web"`
+	Hooks []struct {
+		Name      string `yaml:"name"`
+		Event     string `yaml:"event"`
+		Command   string `yaml:"command"`
+		Webhook   string `yaml:"webhook"`
+		Timeout   string `yaml:"timeout"`
+		OnFailure string `yaml:"on_failure"`
+	} `yaml:"hooks"`
+	AllowedInstanceFamilies []string `yaml:"allowed_instance_families"`
+	MaxLifetime             string   `yaml:"max_lifetime"`
+	ConnectionTemplate      string   `yaml:"connection_template"`
+}
+
+// fileAWSAccount is a named AWS account in the config file
+type fileAWSAccount struct {
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	Profile         string `yaml:"profile"`
+	Region          string `yaml:"region"`
+	RoleARN         string `yaml:"role_arn"`
+	ExternalID      string `yaml:"external_id"`
+	RoleSessionName string `yaml:"role_session_name"`
+}
+
+// fileRateLimit is a token bucket rate limit in the config file; unset
+// fields keep their defaults
+type fileRateLimit struct {
+	RequestsPerMinute *float64 `yaml:"requests_per_minute"`
+	Burst             *int     `yaml:"burst"`
+}
+
+// apply sets the fields of the limit that are set, validating them
+func (f fileRateLimit) apply(limit *ratelimit.Limit) error {
+	if f.RequestsPerMinute != nil {
+		if *f.RequestsPerMinute < 0 {
+			return fmt.Errorf("requests_per_minute must not be negative: %g", *f.RequestsPerMinute)
+		}
+		limit.PerMinute = *f.RequestsPerMinute
+	}
+	if f.Burst != nil {
+		if *f.Burst < 0 {
+			return fmt.Errorf("burst must not be negative: %d", *f.Burst)
+		}
+		limit.Burst = *f.Burst
+	}
+	if limit.PerMinute > 0 && limit.Burst == 0 {
+		return errors.New("a rate limit needs a burst of at least 1")
+	}
+	return nil
+}
+
+// DefaultConfigPath returns the config file location: $INSTANCE_MANAGER_CONFIG
+// if set, otherwise ~/.instance-manager/config.yaml
+func DefaultConfigPath() string {
+	if path := os.Getenv(ConfigPathEnv); path != "" {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "instance-manager-config.yaml")
+	}
+	return filepath.Join(homeDir, ".instance-manager", "config.yaml")
+}
+
+// LoadConfigFromFile loads configuration from a YAML file on top of the
+// built-in defaults. Unlike LoadConfig it does not read the environment or
+// require credentials.
+func LoadConfigFromFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var file fileConfig
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	config := defaultConfig()
+	config.AWS.AccessKey = file.AWS.AccessKeyID
+	config.AWS.SecretKey = file.AWS.SecretAccessKey
+	if file.AWS.Region != "" {
+		config.AWS.Region = file.AWS.Region
+	}
+	config.AWS.Profile = file.AWS.Profile
+	config.AWS.RoleARN = file.AWS.RoleARN
+	config.AWS.ExternalID = file.AWS.ExternalID
+	config.AWS.RoleSessionName = file.AWS.RoleSessionName
+	config.AWS.Endpoint = file.AWS.EndpointURL
+	if len(file.AWS.Accounts) > 0 {
+		config.AWS.Accounts = make(map[string]AWSAccount, len(file.AWS.Accounts))
+		for name, account := range file.AWS.Accounts {
+			config.AWS.Accounts[name] = AWSAccount{
+				AccessKey:       account.AccessKeyID,
+				SecretKey:       account.SecretAccessKey,
+				Profile:         account.Profile,
+				Region:          account.Region,
+				RoleARN:         account.RoleARN,
+				ExternalID:      account.ExternalID,
+				RoleSessionName: account.RoleSessionName,
+			}
+		}
+	}
+	config.AWS.Metadata = models.MetadataOptions{
+		HTTPTokens: file.AWS.Metadata.HTTPTokens,
+		HopLimit:   file.AWS.Metadata.HopLimit,
+		Endpoint:   file.AWS.Metadata.Endpoint,
+	}
+	if err := config.AWS.Metadata.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid aws.metadata in %s: %w", path, err)
+	}
+	config.GCP.Project = file.GCP.Project
+	if file.GCP.Zone != "" {
+		config.GCP.Zone = file.GCP.Zone
+	}
+	config.GCP.CredentialsFile = file.GCP.CredentialsFile
+	config.Azure.SubscriptionID = file.Azure.SubscriptionID
+	if file.Azure.ResourceGroup != "" {
+		config.Azure.ResourceGroup = file.Azure.ResourceGroup
+	}
+	if file.Azure.Location != "" {
+		config.Azure.Location = file.Azure.Location
+	}
+	config.DigitalOcean.Token = file.DigitalOcean.Token
+	if file.DigitalOcean.Region != "" {
+		config.DigitalOcean.Region = file.DigitalOcean.Region
+	}
+	config.Hetzner.Token = file.Hetzner.Token
+	if file.Hetzner.Location != "" {
+		config.Hetzner.Location = file.Hetzner.Location
+	}
+	config.Vultr.APIKey = file.Vultr.APIKey
+	if file.Vultr.Region != "" {
+		config.Vultr.Region = file.Vultr.Region
+	}
+	config.OCI.CompartmentID = file.OCI.CompartmentID
+	config.OCI.Region = file.OCI.Region
+	config.OCI.AvailabilityDomain = file.OCI.AvailabilityDomain
+	config.OCI.ConfigFile = file.OCI.ConfigFile
+	if file.OCI.Profile != "" {
+		config.OCI.Profile = file.OCI.Profile
+	}
+	if file.Docker.Host != "" {
+		config.Docker.Host = file.Docker.Host
+	}
+	config.Docker.Image = file.Docker.Image
+	if file.Libvirt.URI != "" {
+		config.Libvirt.URI = file.Libvirt.URI
+	}
+	if file.Libvirt.Pool != "" {
+		config.Libvirt.Pool = file.Libvirt.Pool
+	}
+	config.Libvirt.BaseImage = file.Libvirt.BaseImage
+	if file.Libvirt.Network != "" {
+		config.Libvirt.Network = file.Libvirt.Network
+	}
+	if file.Libvirt.Username != "" {
+		config.Libvirt.Username = file.Libvirt.Username
+	}
+	if file.Defaults.InstanceType != "" {
+		config.DefaultValues.InstanceType = file.Defaults.InstanceType
+	}
+	if file.Defaults.Duration != "" {
+		duration, err := time.ParseDuration(file.Defaults.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid defaults.duration in %s: %w", path, err)
+		}
+		config.DefaultValues.Duration = duration
+	}
+	if file.Defaults.AvailabilityZone != "" {
+		config.DefaultValues.AvailabilityZone = file.Defaults.AvailabilityZone
+	}
+	if file.Defaults.ExpiryAction != "" {
+		if err := models.ValidateExpiryAction(file.Defaults.ExpiryAction); err != nil {
+			return nil, fmt.Errorf("invalid defaults.expiry_action in %s: %w", path, err)
+		}
+		config.DefaultValues.ExpiryAction = file.Defaults.ExpiryAction
+	}
+	if file.Scheduler.Interval != "" {
+		interval, err := parsePositiveDuration(file.Scheduler.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scheduler.interval in %s: %w", path, err)
+		}
+		config.Scheduler.Interval = interval
+	}
+	if file.Scheduler.ReloadInterval != "" {
+		interval, err := parsePositiveDuration(file.Scheduler.ReloadInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scheduler.reload_interval in %s: %w", path, err)
+		}
+		config.Scheduler.ReloadInterval = interval
+	}
+	if file.Scheduler.Concurrency < 0 {
+		return nil, fmt.Errorf("invalid scheduler.concurrency in %s: must not be negative: %d", path, file.Scheduler.Concurrency)
+	}
+	if file.Scheduler.Concurrency > 0 {
+		config.Scheduler.Concurrency = file.Scheduler.Concurrency
+	}
+	if file.Scheduler.InstanceTimeout != "" {
+		timeout, err := parsePositiveDuration(file.Scheduler.InstanceTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scheduler.instance_timeout in %s: %w", path, err)
+		}
+		config.Scheduler.InstanceTimeout = timeout
+	}
+	if file.Scheduler.WarnBefore != nil {
+		config.Scheduler.WarnBefore = make([]time.Duration, 0, len(file.Scheduler.WarnBefore))
+		for _, value := range file.Scheduler.WarnBefore {
+			leadTime, err := parsePositiveDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid scheduler.warn_before in %s: %w", path, err)
+			}
+			config.Scheduler.WarnBefore = append(config.Scheduler.WarnBefore, leadTime)
+		}
+	}
+	if file.Scheduler.GracePeriod != "" {
+		gracePeriod, err := time.ParseDuration(file.Scheduler.GracePeriod)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scheduler.grace_period in %s: %w", path, err)
+		}
+		if gracePeriod < 0 {
+			return nil, fmt.Errorf("invalid scheduler.grace_period in %s: duration must not be negative: %s", path, file.Scheduler.GracePeriod)
+		}
+		config.Scheduler.GracePeriod = gracePeriod
+	}
+	if file.Scheduler.TerminateStoppedAfterDays < 0 {
+		return nil, fmt.Errorf("invalid scheduler.terminate_stopped_after_days in %s: must not be negative: %d", path, file.Scheduler.TerminateStoppedAfterDays)
+	}
+	config.Scheduler.TerminateStoppedAfterDays = file.Scheduler.TerminateStoppedAfterDays
+	if file.Scheduler.Prune.TerminatedAfterDays < 0 {
+		return nil, fmt.Errorf("invalid scheduler.prune.terminated_after_days in %s: must not be negative: %d", path, file.Scheduler.Prune.TerminatedAfterDays)
+	}
+	config.Scheduler.Prune = PruneConfig{
+		TerminatedAfterDays: file.Scheduler.Prune.TerminatedAfterDays,
+		Archive:             file.Scheduler.Prune.Archive,
+	}
+	if file.Scheduler.Idle.Window != "" {
+		window, err := time.ParseDuration(file.Scheduler.Idle.Window)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scheduler.idle.window in %s: %w", path, err)
+		}
+		if window < 0 {
+			return nil, fmt.Errorf("invalid scheduler.idle.window in %s: duration must not be negative: %s", path, file.Scheduler.Idle.Window)
+		}
+		config.Scheduler.Idle.Window = window
+	}
+	if cpu := file.Scheduler.Idle.CPUPercent; cpu != nil {
+		if *cpu <= 0 || *cpu > 100 {
+			return nil, fmt.Errorf("invalid scheduler.idle.cpu_percent in %s: must be between 0 and 100: %g", path, *cpu)
+		}
+		config.Scheduler.Idle.CPUPercent = *cpu
+	}
+	if network := file.Scheduler.Idle.NetworkBytesPerSecond; network != nil {
+		if *network < 0 {
+			return nil, fmt.Errorf("invalid scheduler.idle.network_bytes_per_second in %s: must not be negative: %g", path, *network)
+		}
+		config.Scheduler.Idle.NetworkBytesPerSecond = *network
+	}
+	if file.Scheduler.Orphans.Interval != "" {
+		interval, err := time.ParseDuration(file.Scheduler.Orphans.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scheduler.orphans.interval in %s: %w", path, err)
+		}
+		if interval < 0 {
+			return nil, fmt.Errorf("invalid scheduler.orphans.interval in %s: duration must not be negative: %s", path, file.Scheduler.Orphans.Interval)
+		}
+		config.Scheduler.Orphans.Interval = interval
+	}
+	config.Scheduler.Orphans.Adopt = file.Scheduler.Orphans.Adopt
+	config.Scheduler.Backup.Dir = file.Scheduler.Backup.Dir
+	if file.Scheduler.Backup.Interval != "" {
+		interval, err := time.ParseDuration(file.Scheduler.Backup.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scheduler.backup.interval in %s: %w", path, err)
+		}
+		if interval < 0 {
+			return nil, fmt.Errorf("invalid scheduler.backup.interval in %s: duration must not be negative: %s", path, file.Scheduler.Backup.Interval)
+		}
+		config.Scheduler.Backup.Interval = interval
+	}
+	if keep := file.Scheduler.Backup.Keep; keep != nil {
+		if *keep < 0 {
+			return nil, fmt.Errorf("invalid scheduler.backup.keep in %s: must not be negative: %d", path, *keep)
+		}
+		config.Scheduler.Backup.Keep = *keep
+	}
+	config.Notifications.WebhookURL = file.Notifications.WebhookURL
+	config.Notifications.WebURL = file.Notifications.WebURL
+	if file.Retry.MaxAttempts < 0 {
+		return nil, fmt.Errorf("invalid retry.max_attempts in %s: must not be negative: %d", path, file.Retry.MaxAttempts)
+	}
+	if file.Retry.MaxAttempts > 0 {
+		config.Retry.MaxAttempts = file.Retry.MaxAttempts
+	}
+	if file.Retry.BaseDelay != "" {
+		delay, err := parsePositiveDuration(file.Retry.BaseDelay)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry.base_delay in %s: %w", path, err)
+		}
+		config.Retry.BaseDelay = delay
+	}
+	if file.Retry.MaxDelay != "" {
+		delay, err := parsePositiveDuration(file.Retry.MaxDelay)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry.max_delay in %s: %w", path, err)
+		}
+		config.Retry.MaxDelay = delay
+	}
+	switch file.Leader.Backend {
+	case "", "file", "consul", "etcd":
+		config.Leader.Backend = file.Leader.Backend
+	default:
+		return nil, fmt.Errorf("invalid leader.backend in %s: must be file, consul or etcd: %q", path, file.Leader.Backend)
+	}
+	config.Leader.LockFile = file.Leader.LockFile
+	if file.Leader.ConsulAddress != "" {
+		config.Leader.ConsulAddress = file.Leader.ConsulAddress
+	}
+	config.Leader.ConsulToken = file.Leader.ConsulToken
+	if file.Leader.Key != "" {
+		config.Leader.Key = file.Leader.Key
+	}
+	if file.Leader.TTL != "" {
+		ttl, err := time.ParseDuration(file.Leader.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid leader.ttl in %s: %w", path, err)
+		}
+		if ttl < 10*time.Second || ttl > 24*time.Hour {
+			return nil, fmt.Errorf("invalid leader.ttl in %s: must be between 10s and 24h: %s", path, file.Leader.TTL)
+		}
+		config.Leader.TTL = ttl
+	}
+	switch file.Storage.Backend {
+	case "", "file", "dynamodb", "postgres", "redis", "etcd", "s3", "memory":
+		config.Storage.Backend = file.Storage.Backend
+	default:
+		return nil, fmt.Errorf("invalid storage.backend in %s: must be file, dynamodb, postgres, redis, etcd, s3 or memory: %q", path, file.Storage.Backend)
+	}
+	if file.Storage.Backend == "s3" && file.Storage.S3.Bucket == "" {
+		return nil, fmt.Errorf("storage.s3.bucket is required in %s for the s3 storage backend", path)
+	}
+	config.Storage.S3.Bucket = file.Storage.S3.Bucket
+	config.Storage.S3.Key = file.Storage.S3.Key
+	config.Storage.S3.Region = file.Storage.S3.Region
+	config.Storage.S3.Endpoint = file.Storage.S3.Endpoint
+	config.Storage.S3.Profile = file.Storage.S3.Profile
+	config.Storage.PostgresURL = file.Storage.Postgres.URL
+	config.Storage.EncryptionKeyFile = file.Storage.EncryptionKeyFile
+	config.Storage.Redis.URL = file.Storage.Redis.URL
+	config.Storage.Redis.Prefix = file.Storage.Redis.Prefix
+	if len(file.Storage.Etcd.Endpoints) > 0 {
+		config.Storage.Etcd.Endpoints = file.Storage.Etcd.Endpoints
+	}
+	config.Storage.Etcd.Prefix = file.Storage.Etcd.Prefix
+	config.Storage.Etcd.Username = file.Storage.Etcd.Username
+	config.Storage.Etcd.Password = file.Storage.Etcd.Password
+	if file.Storage.DynamoDB.Table != "" {
+		config.Storage.DynamoDB.Table = file.Storage.DynamoDB.Table
+	}
+	config.Storage.DynamoDB.Region = file.Storage.DynamoDB.Region
+	config.Storage.DynamoDB.Endpoint = file.Storage.DynamoDB.Endpoint
+	config.Storage.DynamoDB.Profile = file.Storage.DynamoDB.Profile
+	config.Storage.DynamoDB.CreateTable = file.Storage.DynamoDB.CreateTable
+	if file.Storage.DynamoDB.Retention != "" {
+		retention, err := parsePositiveDuration(file.Storage.DynamoDB.Retention)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage.dynamodb.retention in %s: %w", path, err)
+		}
+		config.Storage.DynamoDB.Retention = retention
+	}
+	config.Web.UserHeader = file.Web.UserHeader
+	for _, proxy := range file.Web.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("invalid web.trusted_proxies in %s: %q is neither a CIDR nor an IP", path, proxy)
+		}
+	}
+	config.Web.TrustedProxies = file.Web.TrustedProxies
+	config.Web.Admins = file.Web.Admins
+	for role := range file.Web.Roles {
+		if err := users.ValidateRole(role); err != nil {
+			return nil, fmt.Errorf("invalid web.roles in %s: %w", path, err)
+		}
+	}
+	config.Web.Roles = file.Web.Roles
+	if file.Web.DefaultRole != "" {
+		if err := users.ValidateRole(file.Web.DefaultRole); err != nil {
+			return nil, fmt.Errorf("invalid web.default_role in %s: %w", path, err)
+		}
+		config.Web.DefaultRole = file.Web.DefaultRole
+	}
+	for name, key := range file.Web.APIKeys {
+		if err := apikey.ValidateName(name); err != nil {
+			return nil, fmt.Errorf("invalid web.api_keys in %s: %w", path, err)
+		}
+		if key == "" {
+			return nil, fmt.Errorf("invalid web.api_keys in %s: key %s is empty", path, name)
+		}
+	}
+	config.Web.APIKeys = file.Web.APIKeys
+	config.Web.APIKeysFile = file.Web.APIKeysFile
+	config.Web.UsersFile = file.Web.UsersFile
+	if file.Web.SessionSecret != "" && len(file.Web.SessionSecret) < 32 {
+		return nil, fmt.Errorf("invalid web.session_secret in %s: use at least 32 characters", path)
+	}
+	config.Web.SessionSecret = file.Web.SessionSecret
+	if file.Web.SessionTTL != "" {
+		ttl, err := parsePositiveDuration(file.Web.SessionTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid web.session_ttl in %s: %w", path, err)
+		}
+		config.Web.SessionTTL = ttl
+	}
+	if fileSSO := file.Web.SSO; fileSSO.Provider != "" {
+		switch fileSSO.Provider {
+		case "oidc":
+			if fileSSO.Issuer == "" {
+				return nil, fmt.Errorf("web.sso.provider oidc in %s requires web.sso.issuer", path)
+			}
+		case "github":
+		default:
+			return nil, fmt.Errorf("invalid web.sso.provider in %s: %q (use oidc or github)", path, fileSSO.Provider)
+		}
+		for role := range fileSSO.Roles {
+			if err := users.ValidateRole(role); err != nil {
+				return nil, fmt.Errorf("invalid web.sso.roles in %s: %w", path, err)
+			}
+		}
+		config.Web.SSO = SSOConfig{
+			Provider:       fileSSO.Provider,
+			Name:           fileSSO.Name,
+			Issuer:         fileSSO.Issuer,
+			ClientID:       fileSSO.ClientID,
+			ClientSecret:   fileSSO.ClientSecret,
+			RedirectURL:    fileSSO.RedirectURL,
+			Scopes:         fileSSO.Scopes,
+			UsernameClaim:  fileSSO.UsernameClaim,
+			GroupsClaim:    fileSSO.GroupsClaim,
+			AllowedGroups:  fileSSO.AllowedGroups,
+			AllowedDomains: fileSSO.AllowedDomains,
+			Roles:          fileSSO.Roles,
+		}
+	}
+	for name, limit := range map[string]struct {
+		file   fileRateLimit
+		config *ratelimit.Limit
+	}{
+		"per_ip":    {file.Web.RateLimit.PerIP, &config.Web.RateLimit.PerIP},
+		"per_token": {file.Web.RateLimit.PerToken, &config.Web.RateLimit.PerToken},
+		"changes":   {file.Web.RateLimit.Changes, &config.Web.RateLimit.Changes},
+	} {
+		if err := limit.file.apply(limit.config); err != nil {
+			return nil, fmt.Errorf("invalid web.rate_limit.%s in %s: %w", name, path, err)
+		}
+	}
+	config.Web.RateLimit.ClientIPHeader = file.Web.RateLimit.ClientIPHeader
+	for i, fileHook := range file.Hooks {
+		hook := hooks.Hook{
+			Name:      fileHook.Name,
+			Event:     fileHook.Event,
+			Command:   fileHook.Command,
+			Webhook:   fileHook.Webhook,
+			OnFailure: fileHook.OnFailure,
+		}
+		if fileHook.Timeout != "" {
+			timeout, err := parsePositiveDuration(fileHook.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hooks[%d].timeout in %s: %w", i, path, err)
+			}
+			hook.Timeout = timeout
+		}
+		if err := hook.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid hooks[%d] in %s: %w", i, path, err)
+		}
+		config.Hooks = append(config.Hooks, hook)
+	}
+	config.AllowedInstanceFamilies = file.AllowedInstanceFamilies
+	if file.MaxLifetime != "" {
+		maxLifetime, err := time.ParseDuration(file.MaxLifetime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_lifetime in %s: %w", path, err)
+		}
+		if maxLifetime < 0 {
+			return nil, fmt.Errorf("invalid max_lifetime in %s: duration must not be negative: %s", path, file.MaxLifetime)
+		}
+		config.MaxLifetime = maxLifetime
+	}
+	if file.ConnectionTemplate != "" {
+		if _, err := models.ParseConnectionTemplate(file.ConnectionTemplate); err != nil {
+			return nil, fmt.Errorf("invalid connection_template in %s: %w", path, err)
+		}
+		config.ConnectionTemplate = file.ConnectionTemplate
+	}
+
+	return config, nil
+}
+
+// parsePositiveDuration parses a duration that must be greater than zero
+func parsePositiveDuration(value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("duration must be positive: %s", value)
+	}
+	return duration, nil
+}
+
+// starterConfig is the commented config file written by WriteStarterConfig
+const starterConfig = `# instance-manager configuration
+#
+# Environment variables take precedence over the values in this file.
+
+aws:
+  # AWS credentials (AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY). Leave empty to
+  # use the default credential chain (AWS_PROFILE, ~/.aws, SSO, instance role).
+  # Prefer that over storing secrets here; if you do, keep this file private.
+  access_key_id: ""
+  secret_access_key: ""
+  # Region to manage instances in (AWS_REGION)
+  region: us-east-1
+  # Profile from ~/.aws/config or ~/.aws/credentials (AWS_PROFILE) to use when
+  # no access keys are set, such as an SSO (IAM Identity Center) profile. Sign
+  # in with "aws sso login --profile <name>"; the session is refreshed
+  # automatically until it expires.
+  # profile: dev
+  # IAM role to assume with the credentials above (AWS_ROLE_ARN), with an
+  # optional external ID (AWS_ROLE_EXTERNAL_ID) and session name
+  # (AWS_ROLE_SESSION_NAME). The role's credentials are refreshed automatically.
+  # role_arn: arn:aws:iam::123456789012:role/instance-manager
+  # external_id: ""
+  # role_session_name: instance-manager
+  # API endpoint override (AWS_ENDPOINT_URL), e.g. LocalStack for tests and
+  # demos without real AWS. Empty uses AWS.
+  # endpoint_url: http://localhost:4566
+  # Additional named accounts, selected with --account. Each takes either an
+  # access key pair or a profile from ~/.aws/config, and an optional region.
+  # An account with only a role_arn assumes that role with the credentials above.
+  # accounts:
+  #   prod:
+  #     profile: prod-admin
+  #     region: ap-southeast-2
+  #   staging:
+  #     role_arn: arn:aws:iam::210987654321:role/instance-manager
+  # Instance metadata service settings of new instances, overridden by the
+  # --metadata-* flags of create. IMDSv2 is required unless http_tokens is
+  # optional; a hop_limit of 2 lets containers on the instance reach it.
+  # metadata:
+  #   http_tokens: required
+  #   hop_limit: 2
+  #   endpoint: enabled
+
+gcp:
+  # Project to manage instances in, for --provider gcp (GCP_PROJECT)
+  project: ""
+  # Zone used when none is given (GCP_ZONE)
+  zone: us-central1-a
+  # Service account key file (GOOGLE_APPLICATION_CREDENTIALS). When empty,
+  # Application Default Credentials are used.
+  credentials_file: ""
+
+azure:
+  # Subscription to manage VMs in, for --provider azure (AZURE_SUBSCRIPTION_ID).
+  # Credentials come from the Azure default credential chain (AZURE_CLIENT_ID /
+  # AZURE_CLIENT_SECRET / AZURE_TENANT_ID, managed identity or az login).
+  subscription_id: ""
+  # Resource group holding managed VMs; created if missing (AZURE_RESOURCE_GROUP)
+  resource_group: instance-manager
+  # Location used when none is given (AZURE_LOCATION)
+  location: eastus
+
+digitalocean:
+  # API token, for --provider digitalocean (DIGITALOCEAN_TOKEN). Prefer the
+  # environment over storing it here.
+  token: ""
+  # Region to create droplets in (DIGITALOCEAN_REGION)
+  region: nyc3
+
+hetzner:
+  # API token, for --provider hetzner (HCLOUD_TOKEN). Prefer the environment
+  # over storing it here.
+  token: ""
+  # Location to create servers in (HCLOUD_LOCATION)
+  location: fsn1
+
+vultr:
+  # API key, for --provider vultr (VULTR_API_KEY). Prefer the environment over
+  # storing it here.
+  api_key: ""
+  # Region to create instances in (VULTR_REGION)
+  region: ewr
+
+oci:
+  # Compartment OCID to manage instances in, for --provider oci (OCI_COMPARTMENT_ID).
+  # API keys come from the OCI config file profile.
+  compartment_id: ""
+  # Region override; empty uses the profile's region (OCI_REGION)
+  region: ""
+  # Availability domain used when none is given, e.g. AD-1; empty uses the
+  # region's first one (OCI_AVAILABILITY_DOMAIN)
+  availability_domain: ""
+  # OCI config file and profile; empty uses ~/.oci/config (OCI_CONFIG_FILE, OCI_PROFILE)
+  config_file: ""
+  profile: DEFAULT
+
+docker:
+  # Daemon used by --provider docker, which runs instances as local sshd
+  # containers for development and demos (DOCKER_HOST)
+  host: unix:///var/run/docker.sock
+  # Image override; it must run sshd on port 2222 and authorize the key in
+  # PUBLIC_KEY. Empty uses linuxserver/openssh-server (DOCKER_IMAGE)
+  image: ""
+
+libvirt:
+  # Connection URI of the KVM host, for --provider libvirt (LIBVIRT_DEFAULT_URI),
+  # e.g. qemu+ssh://admin@kvm01.example.com/system
+  uri: qemu:///system
+  # Storage pool holding the base image and instance disks (LIBVIRT_POOL)
+  pool: default
+  # Cloud image volume in the pool that instance disks are backed by, e.g. an
+  # Ubuntu jammy-server-cloudimg-amd64.img (LIBVIRT_BASE_IMAGE)
+  base_image: ""
+  # Network instances are attached to (LIBVIRT_NETWORK)
+  network: default
+  # Login user of the base image (LIBVIRT_USERNAME)
+  username: ubuntu
+
+defaults:
+  # Instance type used when none is given
+  instance_type: t2.nano
+  # How long instances run before they are stopped (e.g. 30m, 2h)
+  duration: 1h
+  # Availability zone used when none is given
+  availability_zone: us-east-1a
+  # What the service does to instances at expiry: stop them, so they restart
+  # when extended; hibernate them (AWS only, set at launch); or terminate
+  # them so their volumes stop incurring costs, archiving their records.
+  # Used by create when --expiry-action is not given and by the service for
+  # instances without one, such as those created from the web UI.
+  expiry_action: stop
+
+scheduler:
+  # How often the service command checks instances for expiry. Large fleets
+  # may poll less often; overridden by service --interval.
+  interval: 30s
+  # Longest the service works from cached storage before reading it again;
+  # overridden by service --reload-interval.
+  reload_interval: 10s
+  # How many instances the service checks at once, so a large fleet fits in
+  # the interval; overridden by service --concurrency.
+  concurrency: 10
+  # Longest the service spends on a single instance before giving up on it
+  # until the next check; overridden by service --instance-timeout.
+  instance_timeout: 2m
+  # Lead times before expiry at which running instances are warned about, with
+  # a ready-made extend command; overridden by service --warn-before. An empty
+  # list disables the warnings.
+  warn_before: [30m, 10m, 1m]
+  # How long expired instances keep running before they are stopped, giving a
+  # last chance to extend them (e.g. 5m). Shown by status, schedule-preview
+  # and the web UI. 0 stops instances at expiry.
+  grace_period: 0s
+  # Terminate instances that have been stopped for more than this many days
+  # and archive their records (see the archived command), so stopped
+  # instances don't keep incurring volume costs; overridden by service
+  # --terminate-stopped-after-days. 0 keeps stopped instances.
+  terminate_stopped_after_days: 0
+  # Stop running instances that stay idle for the window, even before they
+  # expire. Idle means CloudWatch reports CPU utilization below cpu_percent
+  # and network traffic (in and out) below network_bytes_per_second, where 0
+  # ignores the network. Only AWS instances report utilization. Create
+  # instances with --keep-when-idle to opt out; extending the TTL of an
+  # idle-stopped instance restarts it. Overridden by service --idle-window,
+  # --idle-cpu-percent and --idle-network-bytes. A window of 0 disables this.
+  idle:
+    window: 0s
+    cpu_percent: 5
+    network_bytes_per_second: 10240
+  # Look for orphans: instances tagged ManagedBy=instance-manager at the
+  # service's provider that are missing from storage, e.g. after the storage
+  # file was lost. Orphans are logged and notified; with adopt they are saved
+  # to storage instead and stopped at the expiry recorded in their tags, or
+  # at once without one. See also the orphans command. Overridden by service
+  # --orphan-interval and --adopt-orphans. An interval of 0 disables this.
+  orphans:
+    interval: 15m
+    adopt: false
+  # Remove the records of instances terminated more than terminated_after_days
+  # ago, so storage doesn't grow forever; with archive they are moved to the
+  # archive (see the archived command) instead of deleted. See also the prune
+  # command. Overridden by service --prune-terminated-after-days and
+  # --archive-pruned. 0 keeps terminated records.
+  prune:
+    terminated_after_days: 0
+    archive: false
+  # Write a backup of storage every interval into dir (default: the backups
+  # directory next to the storage file), keeping the newest keep backups (0
+  # keeps all). Backups are encrypted with the storage encryption key when
+  # one is set. Restore one with the restore command. Overridden by service
+  # --backup-interval, --backup-dir and --backup-keep. An interval of 0
+  # disables this.
+  backup:
+    dir: ""
+    interval: 0s
+    keep: 7
+
+notifications:
+  # URL that receives each notification, such as expiry warnings, as a JSON
+  # POST (NOTIFY_WEBHOOK_URL). Empty only logs them.
+  webhook_url: ""
+  # Base URL of the web UI, linked from expiry warnings to extend the
+  # instance (NOTIFY_WEB_URL), e.g. http://localhost:8080
+  web_url: ""
+
+retry:
+  # How often a cloud provider call that fails with throttling (such as EC2
+  # RequestLimitExceeded) or a temporary server error is tried in total by
+  # the service, the web server and the CLI; overridden by --retry-attempts.
+  # 1 disables retries. Calls that create instances or images are not retried.
+  max_attempts: 4
+  # Wait before the first retry, doubled for each later one up to max_delay,
+  # with random jitter
+  base_delay: 500ms
+  max_delay: 10s
+
+leader:
+  # Run several service replicas, e.g. on two hosts for availability, with
+  # only the elected leader managing instances while the others stand by;
+  # overridden by service --leader. "file" locks lock_file, which suits
+  # replicas on one host or sharing a file system; "consul" holds key through
+  # a Consul session and "etcd" through a lease on the cluster of
+  # storage.etcd. Empty runs a single replica without an election.
+  backend: ""
+  # File locked by the file backend; empty locks instances.json.lock next to
+  # the instance storage. Overridden by service --lock-file.
+  lock_file: ""
+  # Consul agent and ACL token of the consul backend (CONSUL_HTTP_ADDR,
+  # CONSUL_HTTP_TOKEN)
+  consul_address: http://127.0.0.1:8500
+  consul_token: ""
+  # Consul key held by the leader, or prefix of the etcd election keys
+  key: instance-manager/leader
+  # How long the session or lease of a leader that stopped renewing it
+  # stays valid (10s-24h); must be longer than scheduler.interval. A crashed
+  # leader is replaced within about twice this.
+  ttl: 1m
+
+storage:
+  # Where instance records are kept. "file" keeps them in instances.json
+  # (see --storage-file); "dynamodb" keeps them in a DynamoDB table,
+  # "postgres" in a PostgreSQL database, "redis" on a Redis server and "etcd"
+  # in an etcd cluster, so the service, the web server and the CLI can share
+  # them across hosts. "s3"
+  # keeps instances.json in an S3 bucket, for a web server without a
+  # persistent disk. "memory" keeps them in the process until it exits, for
+  # throwaway demos of the web server. Overridden by --storage.
+  backend: file
+  # File holding a 32-byte key, in base64 or hex, to encrypt the storage
+  # file of the file backend with (STORAGE_ENCRYPTION_KEY_FILE); the key
+  # itself may be set in STORAGE_ENCRYPTION_KEY. Generate one with
+  # openssl rand -base64 32.
+  encryption_key_file: ""
+  dynamodb:
+    table: instance-manager
+    # Empty uses the region of the AWS credential chain (AWS_REGION)
+    region: ""
+    # Overrides the regional endpoint, e.g. http://localhost:8000 for
+    # DynamoDB Local
+    endpoint: ""
+    # Shared AWS config profile to take credentials from; empty uses the
+    # default credential chain
+    profile: ""
+    # Create the table, with on-demand capacity, when it does not exist
+    create_table: false
+    # How long records of terminated and archived instances are kept before
+    # DynamoDB expires them through the ttl attribute; empty keeps them
+    retention: ""
+  postgres:
+    # Database URL (POSTGRES_URL), such as
+    # postgres://instance-manager@db.example.com/instances?sslmode=require.
+    # The password may also come from PGPASSWORD. The schema is created and
+    # migrated on startup.
+    url: ""
+  redis:
+    # Server URL (REDIS_URL), such as redis://:secret@cache.example.com:6379/0;
+    # rediss:// connects with TLS
+    url: ""
+    # Prefix of the keys, so several deployments can share a server
+    prefix: "instance-manager:"
+  etcd:
+    # Cluster endpoints (ETCD_ENDPOINTS, comma-separated); https:// connects
+    # with TLS
+    endpoints:
+      - http://127.0.0.1:2379
+    # Prefix of the keys, so several deployments can share a cluster
+    prefix: /instance-manager/
+    # User and password when the cluster has authentication enabled
+    # (ETCD_USERNAME, ETCD_PASSWORD)
+    username: ""
+    password: ""
+  s3:
+    bucket: ""
+    # Object holding the records; a .gz key is stored gzip-compressed
+    key: instances.json
+    # Empty uses the region of the AWS credential chain (AWS_REGION)
+    region: ""
+    # Overrides the regional endpoint, e.g. http://localhost:9000 for MinIO
+    endpoint: ""
+    # Shared AWS config profile to take credentials from
+    profile: ""
+
+web:
+  # Request header in which an authenticating reverse proxy in front of the
+  # web server names the user, e.g. X-Forwarded-User (WEB_USER_HEADER). When
+  # set, requests without it are refused and each user only sees and manages
+  # the instances they created. Empty lets everyone manage every instance.
+  # Requests made with an API key or session act for their own user, whatever
+  # the header says.
+  user_header: ""
+  # Addresses (CIDRs or IPs) of the reverse proxies in front of the web
+  # server; only they may send user_header and X-Forwarded-Host, which names
+  # the host of the single sign-on callback (WEB_TRUSTED_PROXIES). Empty lets any client send user_header, so make
+  # sure clients cannot reach the web server directly.
+  trusted_proxies: []
+  # Users who see and manage every instance, including those created before
+  # user_header was set or users signed in, and may terminate instances and
+  # pause and resume the scheduler (WEB_ADMINS)
+  admins: []
+  # Users who get each role, once the web server tells users apart through
+  # user_header, sign-in or API keys: admin as above; operator creates,
+  # extends and stops their own instances; viewer sees every instance and
+  # changes none. Users signed in through sso may get a role from their
+  # groups instead.
+  roles:
+    admin: []
+    operator: []
+    viewer: []
+  # Role of users given none above or by their groups
+  default_role: operator
+  # API keys by name, required as "Authorization: Bearer <key>" on API
+  # requests (WEB_API_KEYS, as name=key pairs separated by commas). Changes
+  # made with a key are recorded under its name. Generate keys with openssl
+  # rand -base64 32, or let the keys command generate and keep them.
+  api_keys: {}
+  # File of the keys managed with the keys command; empty uses api_keys.json
+  # next to the instance storage. Once it exists, API requests need a key
+  # even when no key is configured above.
+  api_keys_file: ""
+  # File of the users managed with the users command, who sign in on the
+  # login page; empty uses users.json next to the instance storage. Once it
+  # exists, the web UI asks users to sign in and records their changes under
+  # their names.
+  users_file: ""
+  # Secret of at least 32 characters signing the sessions of users who
+  # signed in (WEB_SESSION_SECRET). Empty generates one and keeps it in
+  # session.key next to the users file. Changing it signs everyone out.
+  session_secret: ""
+  # How long users stay signed in
+  session_ttl: 12h
+  # Identity provider users sign in with instead of, or besides, passwords
+  sso:
+    # oidc for OpenID Connect providers such as Google Workspace, Okta or
+    # Keycloak, github for GitHub; empty turns single sign-on off
+    provider: ""
+    # Shown on the login button, e.g. Okta
+    name: ""
+    # Issuer URL of an OIDC provider, e.g. https://acme.okta.com, or the URL
+    # of a GitHub Enterprise server; empty for github means github.com
+    issuer: ""
+    # OAuth2 client registered with the provider (WEB_SSO_CLIENT_ID and
+    # WEB_SSO_CLIENT_SECRET)
+    client_id: ""
+    client_secret: ""
+    # Callback URL registered with the provider, e.g.
+    # https://instances.example.com/api/v1/auth/sso/callback; empty derives it
+    # from each request
+    redirect_url: ""
+    # Scopes to request; empty requests openid, email, profile and groups
+    # (when offered) from OIDC providers, and read:user, user:email and
+    # read:org from GitHub
+    scopes: []
+    # ID token claims naming the user (empty uses the verified email, or sub
+    # when there is none; name another claim only when users cannot change
+    # it at the provider) and listing their groups (empty uses groups). GitHub users
+    # are named by their login and their groups are their organizations and
+    # teams, e.g. acme and acme/platform.
+    username_claim: ""
+    groups_claim: ""
+    # Only members of these groups, or of a group granting a role, may sign
+    # in; empty lets in every user of the provider
+    allowed_groups: []
+    # Only users with a verified email in these domains may sign in, e.g.
+    # example.com for a Google Workspace
+    allowed_domains: []
+    # Groups whose members get each role (see web.roles); users in several
+    # get the most privileged one
+    roles:
+      admin: []
+      operator: []
+      viewer: []
+  # Token bucket rate limits of the API, which answer 429 with Retry-After
+  # once a client spends its burst; tokens refill at requests_per_minute.
+  # Setting requests_per_minute to 0 turns a limit off.
+  rate_limit:
+    # Requests from each client address, signed in or not
+    per_ip:
+      requests_per_minute: 1200
+      burst: 200
+    # Requests of each API key, signed-in user or user named by user_header
+    per_token:
+      requests_per_minute: 600
+      burst: 100
+    # Instances each client may create, extend, stop and terminate
+    changes:
+      requests_per_minute: 10
+      burst: 5
+    # Header in which a proxy in front of the web server passes the client
+    # address, e.g. X-Forwarded-For; empty limits the address of the
+    # connection, which is the proxy's
+    client_ip_header: ""
+
+# Commands and webhooks the service runs before it stops an instance
+# (pre-stop), once the stop was accepted (post-stop) and before it terminates
+# one (pre-terminate). Commands run with sh -c and get the instance in
+# INSTANCE_ID, INSTANCE_NAME, INSTANCE_PROVIDER, INSTANCE_REGION,
+# INSTANCE_PUBLIC_IP, INSTANCE_PRIVATE_IP, INSTANCE_USERNAME and
+# INSTANCE_SSH_PORT, the event and reason (expired, idle, budget, cron,
+# office-hours, stopped-too-long) in HOOK_EVENT and HOOK_REASON, and the same
+# details as JSON on stdin; webhooks receive the JSON as a POST. A hook that
+# fails or exceeds its timeout (default 1m) is logged and the action goes
+# ahead, unless on_failure is abort, which skips the stop or termination
+# until the next check. Hooks run within scheduler.instance_timeout.
+hooks: []
+#  - name: drain
+#    event: pre-stop
+#    command: ssh -p $INSTANCE_SSH_PORT $INSTANCE_USERNAME@$INSTANCE_PUBLIC_IP sudo systemctl stop my-app
+#    timeout: 2m
+#    on_failure: abort
+#  - name: audit
+#    event: pre-terminate
+#    webhook: https://hooks.example.com/instance-manager
+
+# Instance type prefixes users may launch, e.g. ["t2.", "t3."]
+# (ALLOWED_INSTANCE_FAMILIES). An empty list allows every supported type.
+allowed_instance_families: []
+
+# Longest an instance may run after launch, however often it is extended,
+# e.g. 72h (MAX_LIFETIME). create and extend, in the CLI and the web UI,
+# refuse TTLs beyond it, and the service stops instances when they reach it.
+# 0 means no limit.
+max_lifetime: 0s
+
+# Go template used to print connection commands in show, list and the web UI
+# (CONNECTION_TEMPLATE). Fields include .Username, .PublicIP, .PrivateIP, .ID,
+# .Region and .SSHPort (set for local Docker instances). Examples:
+#   ssh -J me@bastion.example.com {{.Username}}@{{.PrivateIP}}
+#   ssh -p 2222 {{.Username}}@{{.PublicIP}}
+connection_template: "ssh {{if .SSHPort}}-p {{.SSHPort}} {{end}}{{.Username}}@{{.PublicIP}}"
+`
+
+// WriteStarterConfig writes a commented starter config file to path. An
+// existing file is only replaced when force is set.
+func WriteStarterConfig(path string, force bool) error {
+	if !force {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("config file %s already exists (use --force to overwrite)", path)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to check config file: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(starterConfig), 0600); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
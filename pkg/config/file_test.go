@@ -0,0 +1,669 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/config"
+	"instance-manager/pkg/hooks"
+	"instance-manager/pkg/models"
+)
+
+func TestWriteStarterConfig_ParsesBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "config.yaml")
+
+	if err := config.WriteStarterConfig(path, false); err != nil {
+		t.Fatalf("WriteStarterConfig failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read config file: %v", err)
+	}
+	for _, key := range []string{"aws:", "access_key_id:", "secret_access_key:", "region:", "defaults:", "instance_type:", "duration:", "availability_zone:", "allowed_instance_families:", "connection_template:"} {
+		if !strings.Contains(string(data), key) {
+			t.Errorf("Starter config is missing key %q", key)
+		}
+	}
+
+	cfg, err := config.LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if cfg.AWS.Region != "us-east-1" {
+		t.Errorf("Expected region us-east-1, got %s", cfg.AWS.Region)
+	}
+	if cfg.DefaultValues.InstanceType != "t2.nano" {
+		t.Errorf("Expected instance type t2.nano, got %s", cfg.DefaultValues.InstanceType)
+	}
+	if cfg.DefaultValues.Duration != time.Hour {
+		t.Errorf("Expected duration 1h, got %s", cfg.DefaultValues.Duration)
+	}
+	if cfg.ConnectionTemplate != models.DefaultConnectionTemplate {
+		t.Errorf("Expected default connection template, got %q", cfg.ConnectionTemplate)
+	}
+}
+
+func TestWriteStarterConfig_RefusesOverwrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("aws:\n  region: eu-west-1\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	if err := config.WriteStarterConfig(path, false); err == nil {
+		t.Fatal("Expected an error when the config file exists")
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "eu-west-1") {
+		t.Error("Existing config file was modified")
+	}
+
+	if err := config.WriteStarterConfig(path, true); err != nil {
+		t.Fatalf("WriteStarterConfig with force failed: %v", err)
+	}
+	data, _ = os.ReadFile(path)
+	if strings.Contains(string(data), "eu-west-1") {
+		t.Error("Expected --force to overwrite the config file")
+	}
+}
+
+func TestLoadConfig_FileWithEnvOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `aws:
+  access_key_id: file-access-key
+  secret_access_key: file-secret-key
+  region: eu-west-1
+defaults:
+  duration: 2h
+  expiry_action: terminate
+allowed_instance_families: ["t3."]
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	t.Setenv(config.ConfigPathEnv, path)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_REGION", "us-west-2")
+	t.Setenv("ALLOWED_INSTANCE_FAMILIES", "")
+	t.Setenv("CONNECTION_TEMPLATE", "")
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	if cfg.AWS.AccessKey != "file-access-key" || cfg.AWS.SecretKey != "file-secret-key" {
+		t.Errorf("Expected credentials from the file, got %s/%s", cfg.AWS.AccessKey, cfg.AWS.SecretKey)
+	}
+	if cfg.AWS.Region != "us-west-2" {
+		t.Errorf("Expected AWS_REGION to override the file, got %s", cfg.AWS.Region)
+	}
+	if cfg.DefaultValues.Duration != 2*time.Hour {
+		t.Errorf("Expected duration 2h, got %s", cfg.DefaultValues.Duration)
+	}
+	if cfg.DefaultValues.ExpiryAction != models.ExpiryActionTerminate {
+		t.Errorf("Expected expiry action terminate, got %s", cfg.DefaultValues.ExpiryAction)
+	}
+	if len(cfg.AllowedInstanceFamilies) != 1 || cfg.AllowedInstanceFamilies[0] != "t3." {
+		t.Errorf("Expected allowed families [t3.], got %v", cfg.AllowedInstanceFamilies)
+	}
+}
+
+func TestLoadConfigFromFile_InvalidDuration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("defaults:\n  duration: forever\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	if _, err := config.LoadConfigFromFile(path); err == nil {
+		t.Error("Expected an error for an invalid duration")
+	}
+
+	if err := os.WriteFile(path, []byte("defaults:\n  expiry_action: delete\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := config.LoadConfigFromFile(path); err == nil {
+		t.Error("Expected an error for an invalid expiry action")
+	}
+}
+
+func TestLoadConfigFromFile_ConnectionTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "connection_template: \"ssh -J me@bastion {{.Username}}@{{.PrivateIP}}\"\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := config.LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if cfg.ConnectionTemplate != "ssh -J me@bastion {{.Username}}@{{.PrivateIP}}" {
+		t.Errorf("Unexpected connection template %q", cfg.ConnectionTemplate)
+	}
+
+	if err := os.WriteFile(path, []byte("connection_template: \"ssh {{.Username\"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := config.LoadConfigFromFile(path); err == nil {
+		t.Error("Expected an error for a malformed connection template")
+	}
+}
+
+func TestLoadConfigFromFile_Metadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "aws:\n  metadata:\n    http_tokens: required\n    hop_limit: 2\n    endpoint: enabled\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := config.LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	want := models.MetadataOptions{HTTPTokens: "required", HopLimit: 2, Endpoint: "enabled"}
+	if cfg.AWS.Metadata != want {
+		t.Errorf("Expected metadata options %+v, got %+v", want, cfg.AWS.Metadata)
+	}
+
+	if err := os.WriteFile(path, []byte("aws:\n  metadata:\n    hop_limit: 100\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := config.LoadConfigFromFile(path); err == nil {
+		t.Error("Expected an error for an out of range hop limit")
+	}
+}
+
+func TestLoadConfigFromFile_Retry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("retry:\n  max_attempts: 6\n  base_delay: 1s\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := config.LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if cfg.Retry.MaxAttempts != 6 || cfg.Retry.BaseDelay != time.Second || cfg.Retry.MaxDelay != 10*time.Second {
+		t.Errorf("Expected 6 attempts from 1s with the default 10s cap, got %+v", cfg.Retry)
+	}
+
+	if err := os.WriteFile(path, []byte("retry:\n  max_delay: -1s\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := config.LoadConfigFromFile(path); err == nil {
+		t.Error("Expected an error for a negative retry delay")
+	}
+}
+
+func TestLoadConfigFromFile_Scheduler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "scheduler:\n  interval: 2m\n  reload_interval: 30s\n  warn_before: [15m, 2m]\n  grace_period: 5m\n" +
+		"  concurrency: 25\n  instance_timeout: 45s\n" +
+		"  terminate_stopped_after_days: 14\n" +
+		"  idle:\n    window: 1h\n    cpu_percent: 2.5\n" +
+		"  orphans:\n    interval: 1h\n    adopt: true\n" +
+		"  prune:\n    terminated_after_days: 30\n    archive: true\n" +
+		"  backup:\n    dir: /var/backups/im\n    interval: 6h\n    keep: 0\n" +
+		"notifications:\n  webhook_url: https://hooks.example.com/im\n  web_url: http://localhost:8080\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := config.LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if cfg.Scheduler.Interval != 2*time.Minute {
+		t.Errorf("Expected interval 2m, got %s", cfg.Scheduler.Interval)
+	}
+	if cfg.Scheduler.ReloadInterval != 30*time.Second {
+		t.Errorf("Expected reload interval 30s, got %s", cfg.Scheduler.ReloadInterval)
+	}
+	if cfg.Scheduler.Concurrency != 25 || cfg.Scheduler.InstanceTimeout != 45*time.Second {
+		t.Errorf("Expected concurrency 25 with a 45s instance timeout, got %d and %s", cfg.Scheduler.Concurrency, cfg.Scheduler.InstanceTimeout)
+	}
+	if len(cfg.Scheduler.WarnBefore) != 2 || cfg.Scheduler.WarnBefore[0] != 15*time.Minute || cfg.Scheduler.WarnBefore[1] != 2*time.Minute {
+		t.Errorf("Expected warn_before [15m 2m], got %v", cfg.Scheduler.WarnBefore)
+	}
+	if cfg.Scheduler.GracePeriod != 5*time.Minute {
+		t.Errorf("Expected grace period 5m, got %s", cfg.Scheduler.GracePeriod)
+	}
+	if cfg.Scheduler.TerminateStoppedAfterDays != 14 {
+		t.Errorf("Expected terminate_stopped_after_days 14, got %d", cfg.Scheduler.TerminateStoppedAfterDays)
+	}
+	if cfg.Scheduler.Idle.Window != time.Hour || cfg.Scheduler.Idle.CPUPercent != 2.5 {
+		t.Errorf("Expected a 1h idle window below 2.5%% CPU, got %+v", cfg.Scheduler.Idle)
+	}
+	if cfg.Scheduler.Idle.NetworkBytesPerSecond != 10*1024 {
+		t.Errorf("Expected the default idle network threshold, got %g", cfg.Scheduler.Idle.NetworkBytesPerSecond)
+	}
+	if cfg.Scheduler.Orphans.Interval != time.Hour || !cfg.Scheduler.Orphans.Adopt {
+		t.Errorf("Expected orphans to be adopted every hour, got %+v", cfg.Scheduler.Orphans)
+	}
+	if cfg.Scheduler.Prune.TerminatedAfterDays != 30 || !cfg.Scheduler.Prune.Archive {
+		t.Errorf("Expected terminated records to be archived after 30 days, got %+v", cfg.Scheduler.Prune)
+	}
+	if cfg.Scheduler.Backup.Dir != "/var/backups/im" || cfg.Scheduler.Backup.Interval != 6*time.Hour || cfg.Scheduler.Backup.Keep != 0 {
+		t.Errorf("Expected every backup to be kept, taken every 6h, got %+v", cfg.Scheduler.Backup)
+	}
+	if cfg.Notifications.WebhookURL != "https://hooks.example.com/im" || cfg.Notifications.WebURL != "http://localhost:8080" {
+		t.Errorf("Unexpected notifications config: %+v", cfg.Notifications)
+	}
+
+	if err := os.WriteFile(path, []byte("scheduler:\n  interval: 0s\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := config.LoadConfigFromFile(path); err == nil {
+		t.Error("Expected an error for a zero interval")
+	}
+
+	if err := os.WriteFile(path, []byte("scheduler:\n  terminate_stopped_after_days: -1\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := config.LoadConfigFromFile(path); err == nil {
+		t.Error("Expected an error for a negative terminate_stopped_after_days")
+	}
+
+	if err := os.WriteFile(path, []byte("scheduler:\n  prune:\n    terminated_after_days: -1\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := config.LoadConfigFromFile(path); err == nil {
+		t.Error("Expected an error for a negative prune.terminated_after_days")
+	}
+
+	if err := os.WriteFile(path, []byte("scheduler:\n  backup:\n    keep: -1\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := config.LoadConfigFromFile(path); err == nil {
+		t.Error("Expected an error for a negative backup.keep")
+	}
+
+	if err := os.WriteFile(path, []byte("scheduler:\n  idle:\n    cpu_percent: 0\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := config.LoadConfigFromFile(path); err == nil {
+		t.Error("Expected an error for a zero idle CPU threshold")
+	}
+
+	if err := os.WriteFile(path, []byte("scheduler:\n  orphans:\n    interval: -1m\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := config.LoadConfigFromFile(path); err == nil {
+		t.Error("Expected an error for a negative orphan check interval")
+	}
+}
+
+func TestLoadConfigFromFile_Accounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `aws:
+  region: us-east-1
+  profile: sso-dev
+  accounts:
+    prod:
+      profile: prod-admin
+      region: eu-west-1
+    sandbox:
+      access_key_id: sandbox-key
+      secret_access_key: sandbox-secret
+    broken:
+      access_key_id: only-a-key
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := config.LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+
+	if cfg.AWS.Profile != "sso-dev" {
+		t.Errorf("Expected the default account to use profile sso-dev, got %q", cfg.AWS.Profile)
+	}
+
+	prod, err := cfg.AWS.ForAccount("prod")
+	if err != nil {
+		t.Fatalf("ForAccount(prod) failed: %v", err)
+	}
+	if prod.Profile != "prod-admin" || prod.Region != "eu-west-1" {
+		t.Errorf("Expected profile prod-admin in eu-west-1, got %s in %s", prod.Profile, prod.Region)
+	}
+
+	sandbox, err := cfg.AWS.ForAccount("sandbox")
+	if err != nil {
+		t.Fatalf("ForAccount(sandbox) failed: %v", err)
+	}
+	if sandbox.AccessKey != "sandbox-key" || sandbox.Region != "us-east-1" {
+		t.Errorf("Expected the sandbox key in the default region, got %s in %s", sandbox.AccessKey, sandbox.Region)
+	}
+
+	if _, err := cfg.AWS.ForAccount("broken"); err == nil {
+		t.Error("Expected an error for an account with half a key pair")
+	}
+	if _, err := cfg.AWS.ForAccount("missing"); err == nil {
+		t.Error("Expected an error for an unknown account")
+	}
+}
+
+func TestLoadConfigFromFile_AccountRole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `aws:
+  access_key_id: base-key
+  secret_access_key: base-secret
+  region: us-east-1
+  accounts:
+    staging:
+      role_arn: arn:aws:iam::210987654321:role/manager
+      external_id: ext-7
+      role_session_name: staging-session
+    audit:
+      profile: audit
+      role_arn: arn:aws:iam::111111111111:role/reader
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := config.LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+
+	staging, err := cfg.AWS.ForAccount("staging")
+	if err != nil {
+		t.Fatalf("ForAccount(staging) failed: %v", err)
+	}
+	if staging.RoleARN != "arn:aws:iam::210987654321:role/manager" || staging.ExternalID != "ext-7" || staging.RoleSessionName != "staging-session" {
+		t.Errorf("Unexpected role settings: %+v", staging)
+	}
+	if staging.AccessKey != "base-key" || staging.SecretKey != "base-secret" {
+		t.Errorf("Expected the role to be assumed with the default account's keys, got %s", staging.AccessKey)
+	}
+
+	audit, err := cfg.AWS.ForAccount("audit")
+	if err != nil {
+		t.Fatalf("ForAccount(audit) failed: %v", err)
+	}
+	if audit.Profile != "audit" || audit.AccessKey != "" {
+		t.Errorf("Expected the role to be assumed with the audit profile, got profile %q key %q", audit.Profile, audit.AccessKey)
+	}
+}
+
+func TestLoadConfig_Endpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `aws:
+  endpoint_url: http://localhost:4566
+  accounts:
+    sandbox:
+      profile: sandbox
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	t.Setenv(config.ConfigPathEnv, path)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	t.Setenv("AWS_ENDPOINT_URL", "")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	sandbox, err := cfg.AWS.ForAccount("sandbox")
+	if err != nil {
+		t.Fatalf("ForAccount(sandbox) failed: %v", err)
+	}
+	if sandbox.Endpoint != "http://localhost:4566" {
+		t.Errorf("Expected accounts to share the endpoint, got %q", sandbox.Endpoint)
+	}
+
+	t.Setenv("AWS_ENDPOINT_URL", "http://moto:5000")
+	cfg, err = config.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.AWS.Endpoint != "http://moto:5000" {
+		t.Errorf("Expected AWS_ENDPOINT_URL to override the config file, got %q", cfg.AWS.Endpoint)
+	}
+}
+
+func TestLoadConfigFromFile_Leader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("leader:\n  backend: consul\n  key: team/leader\n  ttl: 45s\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := config.LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if cfg.Leader.Backend != "consul" || cfg.Leader.Key != "team/leader" || cfg.Leader.TTL != 45*time.Second {
+		t.Errorf("Unexpected leader config: %+v", cfg.Leader)
+	}
+	if cfg.Leader.ConsulAddress != "http://127.0.0.1:8500" {
+		t.Errorf("Expected the default Consul address, got %q", cfg.Leader.ConsulAddress)
+	}
+
+	for _, content := range []string{"leader:\n  backend: zookeeper\n", "leader:\n  ttl: 5s\n"} {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+		if _, err := config.LoadConfigFromFile(path); err == nil {
+			t.Errorf("Expected an error for %q", content)
+		}
+	}
+}
+
+func TestLoadConfigFromFile_Storage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "storage:\n  backend: dynamodb\n  dynamodb:\n    region: eu-west-1\n    create_table: true\n    retention: 720h\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := config.LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	dynamo := cfg.Storage.DynamoDB
+	if cfg.Storage.Backend != "dynamodb" || dynamo.Region != "eu-west-1" || !dynamo.CreateTable || dynamo.Retention != 720*time.Hour {
+		t.Errorf("Unexpected storage config: %+v", cfg.Storage)
+	}
+	if dynamo.Table != "instance-manager" {
+		t.Errorf("Expected the default table, got %q", dynamo.Table)
+	}
+
+	if err := os.WriteFile(path, []byte("storage:\n  backend: postgres\n  postgres:\n    url: postgres://db/instances\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if cfg, err = config.LoadConfigFromFile(path); err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if cfg.Storage.Backend != "postgres" || cfg.Storage.PostgresURL != "postgres://db/instances" {
+		t.Errorf("Unexpected storage config: %+v", cfg.Storage)
+	}
+
+	if err := os.WriteFile(path, []byte("storage:\n  backend: redis\n  redis:\n    url: redis://cache:6379/2\n    prefix: \"staging:\"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if cfg, err = config.LoadConfigFromFile(path); err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if cfg.Storage.Backend != "redis" || cfg.Storage.Redis.URL != "redis://cache:6379/2" || cfg.Storage.Redis.Prefix != "staging:" {
+		t.Errorf("Unexpected storage config: %+v", cfg.Storage)
+	}
+
+	if err := os.WriteFile(path, []byte("storage:\n  backend: etcd\n  etcd:\n    endpoints: [etcd-1:2379, etcd-2:2379]\n    username: root\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if cfg, err = config.LoadConfigFromFile(path); err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if etcd := cfg.Storage.Etcd; cfg.Storage.Backend != "etcd" || len(etcd.Endpoints) != 2 || etcd.Username != "root" || etcd.Prefix != "" {
+		t.Errorf("Unexpected storage config: %+v", cfg.Storage)
+	}
+
+	if err := os.WriteFile(path, []byte("storage:\n  encryption_key_file: /run/credentials/storage.key\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if cfg, err = config.LoadConfigFromFile(path); err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if cfg.Storage.EncryptionKeyFile != "/run/credentials/storage.key" {
+		t.Errorf("Unexpected storage config: %+v", cfg.Storage)
+	}
+
+	if err := os.WriteFile(path, []byte("storage:\n  backend: s3\n  s3:\n    bucket: state\n    key: prod/instances.json.gz\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if cfg, err = config.LoadConfigFromFile(path); err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if cfg.Storage.Backend != "s3" || cfg.Storage.S3.Bucket != "state" || cfg.Storage.S3.Key != "prod/instances.json.gz" {
+		t.Errorf("Unexpected storage config: %+v", cfg.Storage)
+	}
+
+	for _, content := range []string{"storage:\n  backend: mongodb\n", "storage:\n  dynamodb:\n    retention: -1h\n", "storage:\n  backend: s3\n"} {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+		if _, err := config.LoadConfigFromFile(path); err == nil {
+			t.Errorf("Expected an error for %q", content)
+		}
+	}
+}
+
+func TestLoadConfigFromFile_Hooks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "hooks:\n  - name: drain\n    event: pre-stop\n    command: ./drain.sh\n    timeout: 2m\n    on_failure: abort\n  - event: pre-terminate\n    webhook: http://localhost/hook\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := config.LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if len(cfg.Hooks) != 2 {
+		t.Fatalf("Expected 2 hooks, got %+v", cfg.Hooks)
+	}
+	if drain := cfg.Hooks[0]; drain.Name != "drain" || drain.Event != hooks.PreStop || drain.Timeout != 2*time.Minute || drain.OnFailure != hooks.FailAbort {
+		t.Errorf("Unexpected hook: %+v", drain)
+	}
+
+	if err := os.WriteFile(path, []byte("hooks:\n  - event: post-stop\n    command: true\n    on_failure: abort\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := config.LoadConfigFromFile(path); err == nil {
+		t.Error("Expected an error for a post-stop hook that aborts")
+	}
+}
+
+func TestLoadConfigFromFile_Web(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("web:\n  user_header: X-Forwarded-User\n  trusted_proxies: [10.0.0.0/8, 192.0.2.1]\n  admins: [alice, ops]\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := config.LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if cfg.Web.UserHeader != "X-Forwarded-User" || len(cfg.Web.TrustedProxies) != 2 || len(cfg.Web.Admins) != 2 || cfg.Web.Admins[1] != "ops" {
+		t.Errorf("Unexpected web config: %+v", cfg.Web)
+	}
+
+	if err := os.WriteFile(path, []byte("web:\n  api_keys:\n    ci: secret\n  api_keys_file: /etc/instance-manager/keys.json\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if cfg, err = config.LoadConfigFromFile(path); err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if cfg.Web.APIKeys["ci"] != "secret" || cfg.Web.APIKeysFile != "/etc/instance-manager/keys.json" {
+		t.Errorf("Unexpected web config: %+v", cfg.Web)
+	}
+	if cfg.Web.SessionTTL != 12*time.Hour || cfg.Web.DefaultRole != "operator" {
+		t.Errorf("Expected sessions to last 12h by default, got %s", cfg.Web.SessionTTL)
+	}
+
+	if err := os.WriteFile(path, []byte("web:\n  users_file: /etc/instance-manager/users.json\n  session_secret: 0123456789abcdef0123456789abcdef\n  session_ttl: 8h\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if cfg, err = config.LoadConfigFromFile(path); err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if cfg.Web.UsersFile != "/etc/instance-manager/users.json" || len(cfg.Web.SessionSecret) != 32 || cfg.Web.SessionTTL != 8*time.Hour {
+		t.Errorf("Unexpected web config: %+v", cfg.Web)
+	}
+
+	if err := os.WriteFile(path, []byte("web:\n  sso:\n    provider: oidc\n    issuer: https://acme.okta.com\n    client_id: im\n    roles:\n      admin: [platform]\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if cfg, err = config.LoadConfigFromFile(path); err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if cfg.Web.SSO.Provider != "oidc" || cfg.Web.SSO.Issuer != "https://acme.okta.com" || cfg.Web.SSO.Roles["admin"][0] != "platform" {
+		t.Errorf("Unexpected single sign-on config: %+v", cfg.Web.SSO)
+	}
+
+	if err := os.WriteFile(path, []byte("web:\n  roles:\n    viewer: [auditor]\n  default_role: viewer\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if cfg, err = config.LoadConfigFromFile(path); err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if cfg.Web.Roles["viewer"][0] != "auditor" || cfg.Web.DefaultRole != "viewer" {
+		t.Errorf("Unexpected roles: %v, default %q", cfg.Web.Roles, cfg.Web.DefaultRole)
+	}
+
+	if cfg.Web.RateLimit.Changes.PerMinute != 10 || cfg.Web.RateLimit.Changes.Burst != 5 {
+		t.Errorf("Expected 10 changes a minute by default, got %+v", cfg.Web.RateLimit.Changes)
+	}
+	if err := os.WriteFile(path, []byte("web:\n  rate_limit:\n    per_ip:\n      requests_per_minute: 0\n    changes:\n      burst: 2\n    client_ip_header: X-Forwarded-For\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if cfg, err = config.LoadConfigFromFile(path); err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if limits := cfg.Web.RateLimit; limits.PerIP.Enabled() || limits.Changes.PerMinute != 10 || limits.Changes.Burst != 2 || limits.ClientIPHeader != "X-Forwarded-For" {
+		t.Errorf("Unexpected rate limits: %+v", limits)
+	}
+
+	for _, content := range []string{"web:\n  api_keys:\n    ci: \"\"\n", "web:\n  roles:\n    root: [alice]\n", "web:\n  rate_limit:\n    changes:\n      burst: -1\n", "web:\n  rate_limit:\n    per_token:\n      burst: 0\n", "web:\n  default_role: owner\n", "web:\n  api_keys:\n    bad name: secret\n", "web:\n  session_secret: short\n", "web:\n  session_ttl: forever\n", "web:\n  trusted_proxies: [proxy.example.com]\n",
+		"web:\n  sso:\n    provider: saml\n", "web:\n  sso:\n    provider: oidc\n", "web:\n  sso:\n    provider: github\n    roles:\n      owner: [acme]\n"} {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+		if _, err := config.LoadConfigFromFile(path); err == nil {
+			t.Errorf("Expected an error for %q", content)
+		}
+	}
+}
+
+func TestLoadConfigFromFile_MaxLifetime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("max_lifetime: 72h\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := config.LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if cfg.MaxLifetime != 72*time.Hour {
+		t.Errorf("Expected a 72h maximum lifetime, got %s", cfg.MaxLifetime)
+	}
+
+	if err := os.WriteFile(path, []byte("max_lifetime: 3 days\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := config.LoadConfigFromFile(path); err == nil {
+		t.Error("Expected an error for an invalid maximum lifetime")
+	}
+}
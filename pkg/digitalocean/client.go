@@ -0,0 +1,121 @@
+package digitalocean
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultEndpoint is the DigitalOcean API base URL
+const DefaultEndpoint = "https://api.digitalocean.com/v2"
+
+// APIError is an error response from the DigitalOcean API
+type APIError struct {
+	StatusCode int
+	ID         string `json:"id"`
+	Message    string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("digitalocean API returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("digitalocean API returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// isNotFound reports whether err is a 404 from the DigitalOcean API
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// droplet is the subset of the droplet resource used by the provider
+type droplet struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	SizeSlug  string    `json:"size_slug"`
+	Tags      []string  `json:"tags"`
+	Region    struct {
+		Slug string `json:"slug"`
+	} `json:"region"`
+	Networks struct {
+		V4 []struct {
+			IPAddress string `json:"ip_address"`
+			Type      string `json:"type"`
+		} `json:"v4"`
+	} `json:"networks"`
+}
+
+// sshKey is an SSH key registered with the account
+type sshKey struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"public_key"`
+}
+
+// createDropletRequest is the body of POST /droplets
+type createDropletRequest struct {
+	Name    string   `json:"name"`
+	Region  string   `json:"region"`
+	Size    string   `json:"size"`
+	Image   string   `json:"image"`
+	SSHKeys []string `json:"ssh_keys"`
+	Tags    []string `json:"tags"`
+}
+
+// client is a minimal DigitalOcean API client
+type client struct {
+	httpClient *http.Client
+	endpoint   string
+	token      string
+}
+
+// do sends a request with an optional JSON body and decodes the JSON
+// response into out, if given. Non-2xx responses are returned as *APIError.
+func (c *client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.endpoint, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		_ = json.NewDecoder(resp.Body).Decode(apiErr)
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
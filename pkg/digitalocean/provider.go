@@ -0,0 +1,388 @@
+package digitalocean
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultSize is the droplet size used when none is given
+	DefaultSize = "s-1vcpu-1gb"
+
+	// DefaultRegion is used when no region is configured
+	DefaultRegion = "nyc3"
+
+	// image is the distribution image for new droplets
+	image = "ubuntu-22-04-x64"
+
+	// managedTag marks droplets created by this tool
+	managedTag = "instance-manager"
+
+	durationTagPrefix = "im-duration:"
+	sessionTagPrefix  = "im-session:"
+)
+
+// Sizes lists common droplet size slugs offered by the web UI, default first
+var Sizes = []string{
+	DefaultSize,
+	"s-1vcpu-512mb-10gb",
+	"s-1vcpu-2gb",
+	"s-2vcpu-2gb",
+	"s-2vcpu-4gb",
+	"s-4vcpu-8gb",
+	"c-2",
+	"g-2vcpu-8gb",
+}
+
+// tagPattern matches the characters DigitalOcean accepts in tag names
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9:_-]+$`)
+
+// Provider implements the CloudProvider interface for DigitalOcean droplets.
+// Instance IDs are droplet IDs.
+type Provider struct {
+	client *client
+	region string
+}
+
+// NewProvider creates a new DigitalOcean provider using an API token
+func NewProvider(token, region string) (cloud.CloudProvider, error) {
+	if token == "" {
+		return nil, errors.New("DIGITALOCEAN_TOKEN environment variable is required")
+	}
+	if region == "" {
+		region = DefaultRegion
+	}
+	return NewProviderWithClient(&http.Client{Timeout: 30 * time.Second}, DefaultEndpoint, token, region), nil
+}
+
+// NewProviderWithClient creates a DigitalOcean provider that sends API
+// requests to endpoint using the given HTTP client
+func NewProviderWithClient(httpClient *http.Client, endpoint, token, region string) *Provider {
+	return &Provider{
+		client: &client{httpClient: httpClient, endpoint: endpoint, token: token},
+		region: region,
+	}
+}
+
+// ValidateCredentials checks that the API token is valid
+func (p *Provider) ValidateCredentials(ctx context.Context) error {
+	if err := p.client.do(ctx, http.MethodGet, "/account", nil, nil); err != nil {
+		return fmt.Errorf("invalid DigitalOcean token: %w", err)
+	}
+	return nil
+}
+
+// CreateInstance uploads the public key if the account doesn't have it yet
+// and creates a droplet tagged with its duration. Droplets have no firewall
+// by default, so OpenPorts needs no extra setup.
+func (p *Provider) CreateInstance(ctx context.Context, config models.InstanceConfig) (instance *models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "CreateInstance", "")
+	defer func() {
+		if instance != nil {
+			span.SetAttributes(tracing.AttrInstanceID.String(instance.ID))
+		}
+		tracing.EndSpan(span, err)
+	}()
+
+	if config.SecurityGroupID != "" {
+		return nil, errors.New("security group IDs are not supported on DigitalOcean")
+	}
+
+	tags := []string{managedTag, durationTagPrefix + strconv.FormatInt(int64(config.Duration/time.Second), 10)}
+	if config.Session != "" {
+		if !tagPattern.MatchString(config.Session) {
+			return nil, fmt.Errorf("session %q cannot be stored as a DigitalOcean tag (use letters, digits, '-' and '_')", config.Session)
+		}
+		tags = append(tags, sessionTagPrefix+config.Session)
+	}
+
+	// A key name refers to an SSH key already registered with the account
+	keyRef := config.KeyName
+	if keyRef == "" {
+		keyRef, err = p.importSSHKey(ctx, config.PublicKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import SSH key: %w", err)
+		}
+	}
+
+	size := config.InstanceType
+	if size == "" {
+		size = DefaultSize
+	}
+
+	launchTime := time.Now()
+	var result struct {
+		Droplet droplet `json:"droplet"`
+	}
+	err = p.client.do(ctx, http.MethodPost, "/droplets", createDropletRequest{
+		Name:    "im-" + strconv.FormatInt(launchTime.UnixNano(), 36),
+		Region:  p.region,
+		Size:    size,
+		Image:   image,
+		SSHKeys: []string{keyRef},
+		Tags:    tags,
+	}, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create droplet: %w", err)
+	}
+
+	instance = &models.Instance{
+		ID:               strconv.FormatInt(result.Droplet.ID, 10),
+		InstanceType:     size,
+		State:            "pending",
+		LaunchTime:       launchTime,
+		Duration:         config.Duration,
+		AvailabilityZone: p.region,
+		Region:           p.region,
+		KeyName:          keyRef,
+		Username:         "root",
+		ExpiresAt:        launchTime.Add(config.Duration),
+		Provider:         "digitalocean",
+		RestartPolicy:    config.RestartPolicy,
+		Session:          config.Session,
+	}
+
+	return instance, nil
+}
+
+// GetInstanceStatus retrieves the status of a droplet
+func (p *Provider) GetInstanceStatus(ctx context.Context, instanceID string) (_ *models.InstanceStatus, err error) {
+	ctx, span := p.startSpan(ctx, "GetInstanceStatus", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	var result struct {
+		Droplet droplet `json:"droplet"`
+	}
+	if err := p.client.do(ctx, http.MethodGet, "/droplets/"+url.PathEscape(instanceID), nil, &result); err != nil {
+		if isNotFound(err) {
+			return nil, errors.New("instance not found")
+		}
+		return nil, fmt.Errorf("failed to get droplet: %w", err)
+	}
+
+	state := dropletState(result.Droplet.Status)
+	publicIP, privateIP := dropletIPs(&result.Droplet)
+	return &models.InstanceStatus{
+		ID:        instanceID,
+		State:     state,
+		PublicIP:  publicIP,
+		PrivateIP: privateIP,
+		Username:  "root",
+		Ready:     state == "running",
+	}, nil
+}
+
+// StartInstance powers on a droplet
+func (p *Provider) StartInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StartInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.action(ctx, instanceID, "power_on"); err != nil {
+		return fmt.Errorf("failed to start instance: %w", err)
+	}
+	return nil
+}
+
+// StopInstance powers off a droplet. Powered-off droplets are still billed.
+func (p *Provider) StopInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StopInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.action(ctx, instanceID, "power_off"); err != nil {
+		return fmt.Errorf("failed to stop instance: %w", err)
+	}
+	return nil
+}
+
+// TerminateInstance destroys a droplet
+func (p *Provider) TerminateInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "TerminateInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.do(ctx, http.MethodDelete, "/droplets/"+url.PathEscape(instanceID), nil, nil); err != nil {
+		return fmt.Errorf("failed to terminate instance: %w", err)
+	}
+	return nil
+}
+
+// ListInstances lists the droplets tagged as managed by this tool
+func (p *Provider) ListInstances(ctx context.Context) (_ []*models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "ListInstances", "")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	var instances []*models.Instance
+	for page := 1; ; page++ {
+		var result struct {
+			Droplets []droplet `json:"droplets"`
+			Links    struct {
+				Pages struct {
+					Next string `json:"next"`
+				} `json:"pages"`
+			} `json:"links"`
+		}
+		path := fmt.Sprintf("/droplets?tag_name=%s&per_page=200&page=%d", managedTag, page)
+		if err := p.client.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+			return nil, fmt.Errorf("failed to list instances: %w", err)
+		}
+
+		for i := range result.Droplets {
+			instances = append(instances, toInstance(&result.Droplets[i]))
+		}
+		if result.Links.Pages.Next == "" {
+			break
+		}
+	}
+
+	return instances, nil
+}
+
+// importSSHKey registers the public key with the account unless a key with
+// the same fingerprint already exists, and returns the fingerprint
+func (p *Provider) importSSHKey(ctx context.Context, publicKeyPath string) (string, error) {
+	keyData, err := os.ReadFile(publicKeyPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read public key file: %w", err)
+	}
+	publicKey := strings.TrimSpace(string(keyData))
+
+	fingerprint, err := fingerprintMD5(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	err = p.client.do(ctx, http.MethodGet, "/account/keys/"+fingerprint, nil, nil)
+	if err == nil {
+		return fingerprint, nil
+	}
+	if !isNotFound(err) {
+		return "", err
+	}
+
+	err = p.client.do(ctx, http.MethodPost, "/account/keys", sshKey{
+		Name:      "instance-manager-" + strings.ReplaceAll(fingerprint, ":", "")[:16],
+		PublicKey: publicKey,
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	return fingerprint, nil
+}
+
+// action triggers a droplet action such as power_on or power_off
+func (p *Provider) action(ctx context.Context, instanceID, actionType string) error {
+	return p.client.do(ctx, http.MethodPost, "/droplets/"+url.PathEscape(instanceID)+"/actions",
+		map[string]string{"type": actionType}, nil)
+}
+
+// startSpan starts a tracing span for a DigitalOcean provider operation
+func (p *Provider) startSpan(ctx context.Context, operation, instanceID string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		tracing.AttrProvider.String("digitalocean"),
+		attribute.String("digitalocean.region", p.region),
+	}
+	if instanceID != "" {
+		attrs = append(attrs, tracing.AttrInstanceID.String(instanceID))
+	}
+	return tracing.StartSpan(ctx, "digitalocean."+operation, attrs...)
+}
+
+// toInstance converts a droplet to an instance, reading the duration and
+// session back from its tags
+func toInstance(d *droplet) *models.Instance {
+	instance := &models.Instance{
+		ID:               strconv.FormatInt(d.ID, 10),
+		InstanceType:     d.SizeSlug,
+		State:            dropletState(d.Status),
+		LaunchTime:       d.CreatedAt,
+		AvailabilityZone: d.Region.Slug,
+		Region:           d.Region.Slug,
+		Username:         "root",
+		Provider:         "digitalocean",
+	}
+	instance.PublicIP, instance.PrivateIP = dropletIPs(d)
+
+	for _, tag := range d.Tags {
+		switch {
+		case strings.HasPrefix(tag, durationTagPrefix):
+			seconds, err := strconv.ParseInt(strings.TrimPrefix(tag, durationTagPrefix), 10, 64)
+			if err == nil {
+				instance.Duration = time.Duration(seconds) * time.Second
+				instance.ExpiresAt = instance.LaunchTime.Add(instance.Duration)
+			}
+		case strings.HasPrefix(tag, sessionTagPrefix):
+			instance.Session = strings.TrimPrefix(tag, sessionTagPrefix)
+		}
+	}
+	return instance
+}
+
+// dropletState maps a droplet status to the states used by the scheduler,
+// which follow EC2's naming
+func dropletState(status string) string {
+	switch status {
+	case "new":
+		return "pending"
+	case "active":
+		return "running"
+	case "off":
+		return "stopped"
+	case "archive":
+		return "terminated"
+	default:
+		return status
+	}
+}
+
+// dropletIPs returns the public and private IPv4 addresses of a droplet
+func dropletIPs(d *droplet) (publicIP, privateIP string) {
+	for _, network := range d.Networks.V4 {
+		switch network.Type {
+		case "public":
+			if publicIP == "" {
+				publicIP = network.IPAddress
+			}
+		case "private":
+			if privateIP == "" {
+				privateIP = network.IPAddress
+			}
+		}
+	}
+	return publicIP, privateIP
+}
+
+// fingerprintMD5 returns the MD5 fingerprint DigitalOcean uses to identify an
+// SSH public key, e.g. "3b:16:bf:e4:8b:00:8b:b8:59:8c:a9:d3:f0:19:45:fa"
+func fingerprintMD5(publicKey string) (string, error) {
+	fields := strings.Fields(publicKey)
+	if len(fields) < 2 {
+		return "", errors.New("invalid public key format")
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid public key format: %w", err)
+	}
+
+	sum := md5.Sum(blob)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, ":"), nil
+}
@@ -0,0 +1,284 @@
+package digitalocean_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/digitalocean"
+	"instance-manager/pkg/models"
+)
+
+// MockDigitalOcean serves the subset of the DigitalOcean API used by the provider
+type MockDigitalOcean struct {
+	keys     map[string]bool
+	keyCalls []map[string]string
+	creates  []map[string]interface{}
+	actions  []string
+	deleted  []string
+	droplets map[string]map[string]interface{}
+}
+
+func NewMockDigitalOcean() *MockDigitalOcean {
+	return &MockDigitalOcean{
+		keys:     make(map[string]bool),
+		droplets: make(map[string]map[string]interface{}),
+	}
+}
+
+func (m *MockDigitalOcean) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"id": "unauthorized", "message": "Unable to authenticate you"})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2")
+	switch {
+	case path == "/account":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"account": map[string]string{"status": "active"}})
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/account/keys/"):
+		if !m.keys[strings.TrimPrefix(path, "/account/keys/")] {
+			writeJSON(w, http.StatusNotFound, map[string]string{"id": "not_found", "message": "The resource you were accessing could not be found."})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ssh_key": map[string]string{}})
+	case r.Method == http.MethodPost && path == "/account/keys":
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		m.keyCalls = append(m.keyCalls, body)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"ssh_key": body})
+	case r.Method == http.MethodPost && path == "/droplets":
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		m.creates = append(m.creates, body)
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"droplet": map[string]interface{}{"id": 3164444, "status": "new"}})
+	case r.Method == http.MethodGet && path == "/droplets":
+		var droplets []map[string]interface{}
+		for _, d := range m.droplets {
+			droplets = append(droplets, d)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"droplets": droplets, "links": map[string]interface{}{}})
+	case strings.HasSuffix(path, "/actions"):
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		m.actions = append(m.actions, body["type"])
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"action": map[string]string{"status": "in-progress"}})
+	case strings.HasPrefix(path, "/droplets/"):
+		id := strings.TrimPrefix(path, "/droplets/")
+		d, ok := m.droplets[id]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"id": "not_found"})
+			return
+		}
+		if r.Method == http.MethodDelete {
+			m.deleted = append(m.deleted, id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"droplet": d})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newTestProvider(t *testing.T, mock *MockDigitalOcean) *digitalocean.Provider {
+	t.Helper()
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+	return digitalocean.NewProviderWithClient(server.Client(), server.URL+"/v2", "test-token", "fra1")
+}
+
+func writePublicKey(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "id_rsa.pub")
+	if err := os.WriteFile(path, []byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQAB test@example\n"), 0600); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+	return path
+}
+
+func testDroplet(id int, status string, tags ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         id,
+		"name":       "im-test",
+		"status":     status,
+		"created_at": "2024-05-01T10:00:00Z",
+		"size_slug":  "s-1vcpu-1gb",
+		"tags":       tags,
+		"region":     map[string]string{"slug": "fra1"},
+		"networks": map[string]interface{}{
+			"v4": []map[string]string{
+				{"ip_address": "10.114.0.2", "type": "private"},
+				{"ip_address": "164.90.1.2", "type": "public"},
+			},
+		},
+	}
+}
+
+func TestCreateInstance(t *testing.T) {
+	mock := NewMockDigitalOcean()
+	provider := newTestProvider(t, mock)
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:  "s-2vcpu-2gb",
+		Duration:      2 * time.Hour,
+		PublicKeyPath: writePublicKey(t),
+		Session:       "exp-42",
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	if len(mock.keyCalls) != 1 {
+		t.Fatalf("Expected the public key to be uploaded once, got %d", len(mock.keyCalls))
+	}
+	if mock.keyCalls[0]["public_key"] != "ssh-rsa AAAAB3NzaC1yc2EAAAADAQAB test@example" {
+		t.Errorf("Unexpected uploaded key %q", mock.keyCalls[0]["public_key"])
+	}
+	if len(mock.creates) != 1 {
+		t.Fatalf("Expected 1 droplet create call, got %d", len(mock.creates))
+	}
+
+	body := mock.creates[0]
+	if body["size"] != "s-2vcpu-2gb" || body["region"] != "fra1" || body["image"] != "ubuntu-22-04-x64" {
+		t.Errorf("Unexpected create request %v", body)
+	}
+	tags, _ := json.Marshal(body["tags"])
+	if string(tags) != `["instance-manager","im-duration:7200","im-session:exp-42"]` {
+		t.Errorf("Unexpected tags %s", tags)
+	}
+	sshKeys := body["ssh_keys"].([]interface{})
+	if len(sshKeys) != 1 || !strings.Contains(sshKeys[0].(string), ":") {
+		t.Errorf("Expected the key fingerprint to be passed, got %v", sshKeys)
+	}
+
+	if instance.ID != "3164444" || instance.Provider != "digitalocean" || instance.Username != "root" || instance.Region != "fra1" {
+		t.Errorf("Unexpected instance %+v", instance)
+	}
+	if instance.ExpiresAt.Sub(instance.LaunchTime) != 2*time.Hour {
+		t.Errorf("Expected expiry 2h after launch, got %v", instance.ExpiresAt.Sub(instance.LaunchTime))
+	}
+}
+
+func TestCreateInstance_ReusesExistingKey(t *testing.T) {
+	mock := NewMockDigitalOcean()
+	provider := newTestProvider(t, mock)
+	keyPath := writePublicKey(t)
+
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{Duration: time.Hour, PublicKeyPath: keyPath}); err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	fingerprint := mock.creates[0]["ssh_keys"].([]interface{})[0].(string)
+	mock.keys[fingerprint] = true
+
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{Duration: time.Hour, PublicKeyPath: keyPath}); err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	if len(mock.keyCalls) != 1 {
+		t.Errorf("Expected the registered key to be reused, got %d uploads", len(mock.keyCalls))
+	}
+	if mock.creates[1]["size"] != digitalocean.DefaultSize {
+		t.Errorf("Expected default size %s, got %v", digitalocean.DefaultSize, mock.creates[1]["size"])
+	}
+}
+
+func TestCreateInstance_RejectsInvalidSession(t *testing.T) {
+	provider := newTestProvider(t, NewMockDigitalOcean())
+
+	_, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		Duration:      time.Hour,
+		PublicKeyPath: writePublicKey(t),
+		Session:       "team.alpha",
+	})
+	if err == nil {
+		t.Fatal("Expected an error for a session that can't be a tag")
+	}
+}
+
+func TestGetInstanceStatus(t *testing.T) {
+	mock := NewMockDigitalOcean()
+	mock.droplets["42"] = testDroplet(42, "active", "instance-manager")
+	provider := newTestProvider(t, mock)
+
+	status, err := provider.GetInstanceStatus(context.Background(), "42")
+	if err != nil {
+		t.Fatalf("GetInstanceStatus failed: %v", err)
+	}
+	if status.State != "running" || !status.Ready || status.PublicIP != "164.90.1.2" || status.PrivateIP != "10.114.0.2" {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	if _, err := provider.GetInstanceStatus(context.Background(), "404"); err == nil || err.Error() != "instance not found" {
+		t.Errorf("Expected instance not found, got %v", err)
+	}
+}
+
+func TestListInstances(t *testing.T) {
+	mock := NewMockDigitalOcean()
+	mock.droplets["42"] = testDroplet(42, "off", "instance-manager", "im-duration:3600", "im-session:exp-42")
+	provider := newTestProvider(t, mock)
+
+	instances, err := provider.ListInstances(context.Background())
+	if err != nil {
+		t.Fatalf("ListInstances failed: %v", err)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("Expected 1 instance, got %d", len(instances))
+	}
+
+	instance := instances[0]
+	if instance.ID != "42" || instance.State != "stopped" || instance.Session != "exp-42" || instance.Duration != time.Hour {
+		t.Errorf("Unexpected instance %+v", instance)
+	}
+	want := time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)
+	if !instance.ExpiresAt.Equal(want) {
+		t.Errorf("Expected expiry %v, got %v", want, instance.ExpiresAt)
+	}
+}
+
+func TestPowerAndDestroy(t *testing.T) {
+	mock := NewMockDigitalOcean()
+	mock.droplets["42"] = testDroplet(42, "active", "instance-manager")
+	provider := newTestProvider(t, mock)
+
+	if err := provider.StopInstance(context.Background(), "42"); err != nil {
+		t.Fatalf("StopInstance failed: %v", err)
+	}
+	if err := provider.StartInstance(context.Background(), "42"); err != nil {
+		t.Fatalf("StartInstance failed: %v", err)
+	}
+	if err := provider.TerminateInstance(context.Background(), "42"); err != nil {
+		t.Fatalf("TerminateInstance failed: %v", err)
+	}
+
+	if strings.Join(mock.actions, ",") != "power_off,power_on" {
+		t.Errorf("Unexpected actions %v", mock.actions)
+	}
+	if len(mock.deleted) != 1 || mock.deleted[0] != "42" {
+		t.Errorf("Expected droplet 42 to be destroyed, got %v", mock.deleted)
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	server := httptest.NewServer(NewMockDigitalOcean())
+	defer server.Close()
+
+	if err := digitalocean.NewProviderWithClient(server.Client(), server.URL+"/v2", "test-token", "fra1").ValidateCredentials(context.Background()); err != nil {
+		t.Errorf("Expected valid token, got %v", err)
+	}
+	if err := digitalocean.NewProviderWithClient(server.Client(), server.URL+"/v2", "wrong", "fra1").ValidateCredentials(context.Background()); err == nil {
+		t.Error("Expected an error for an invalid token")
+	}
+}
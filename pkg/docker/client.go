@@ -0,0 +1,204 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultHost is the Docker daemon socket used when DOCKER_HOST is unset
+	DefaultHost = "unix:///var/run/docker.sock"
+
+	// apiVersion is the Engine API version requests are pinned to
+	apiVersion = "/v1.41"
+)
+
+// APIError is an error response from the Docker Engine API
+type APIError struct {
+	StatusCode int
+	Message    string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("docker API returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("docker API returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// isNotFound reports whether err is a 404 from the Docker Engine API
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// containerSummary is an entry of GET /containers/json
+type containerSummary struct {
+	ID      string            `json:"Id"`
+	Names   []string          `json:"Names"`
+	Image   string            `json:"Image"`
+	State   string            `json:"State"`
+	Created int64             `json:"Created"`
+	Labels  map[string]string `json:"Labels"`
+	Ports   []struct {
+		PrivatePort int    `json:"PrivatePort"`
+		PublicPort  int    `json:"PublicPort"`
+		Type        string `json:"Type"`
+	} `json:"Ports"`
+}
+
+// containerDetails is the subset of GET /containers/{id}/json used by the
+// provider
+type containerDetails struct {
+	ID      string    `json:"Id"`
+	Name    string    `json:"Name"`
+	Created time.Time `json:"Created"`
+	State   struct {
+		Status string `json:"Status"`
+	} `json:"State"`
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	NetworkSettings struct {
+		IPAddress string `json:"IPAddress"`
+		Ports     map[string][]struct {
+			HostIP   string `json:"HostIp"`
+			HostPort string `json:"HostPort"`
+		} `json:"Ports"`
+	} `json:"NetworkSettings"`
+}
+
+// portBinding binds a container port to a host address
+type portBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+// hostConfig is the HostConfig of POST /containers/create
+type hostConfig struct {
+	PortBindings map[string][]portBinding `json:"PortBindings"`
+	NanoCPUs     int64                    `json:"NanoCpus,omitempty"`
+	Memory       int64                    `json:"Memory,omitempty"`
+}
+
+// createContainerRequest is the body of POST /containers/create
+type createContainerRequest struct {
+	Image        string              `json:"Image"`
+	Hostname     string              `json:"Hostname"`
+	Env          []string            `json:"Env"`
+	Labels       map[string]string   `json:"Labels"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+	HostConfig   hostConfig          `json:"HostConfig"`
+}
+
+// client is a minimal Docker Engine API client
+type client struct {
+	httpClient *http.Client
+	endpoint   string
+}
+
+// newHTTPClient returns an HTTP client and endpoint for a DOCKER_HOST value.
+// unix:// hosts are reached through the socket; tcp:// hosts over plain HTTP.
+func newHTTPClient(host string) (*http.Client, string, error) {
+	switch {
+	case strings.HasPrefix(host, "unix://"):
+		socket := strings.TrimPrefix(host, "unix://")
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		// The host part of the URL is ignored when dialing the socket
+		return &http.Client{Transport: transport, Timeout: 5 * time.Minute}, "http://docker", nil
+	case strings.HasPrefix(host, "tcp://"):
+		return &http.Client{Timeout: 5 * time.Minute}, "http://" + strings.TrimPrefix(host, "tcp://"), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported DOCKER_HOST %q (use unix:// or tcp://)", host)
+	}
+}
+
+// do sends a request with an optional JSON body and decodes the JSON
+// response into out, if given. Non-2xx responses are returned as *APIError.
+func (c *client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// pullImage pulls image, waiting for the pull to finish. The daemon reports
+// pull failures inside the progress stream rather than as a status code.
+func (c *client) pullImage(ctx context.Context, image string) error {
+	resp, err := c.send(ctx, http.MethodPost, "/images/create?fromImage="+url.QueryEscape(image), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message struct {
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&message); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read pull progress: %w", err)
+		}
+		if message.Error != "" {
+			return fmt.Errorf("failed to pull %s: %s", image, message.Error)
+		}
+	}
+}
+
+// send issues a request and returns the response for 2xx and 304 statuses.
+// 304 is returned when a container is already in the requested state.
+func (c *client) send(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.endpoint, "/")+apiVersion+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if (resp.StatusCode < 200 || resp.StatusCode > 299) && resp.StatusCode != http.StatusNotModified {
+		defer resp.Body.Close()
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		_ = json.NewDecoder(resp.Body).Decode(apiErr)
+		return nil, apiErr
+	}
+	return resp, nil
+}
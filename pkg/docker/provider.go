@@ -0,0 +1,372 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultImage runs sshd on port 2222 and authorizes the key passed in
+	// PUBLIC_KEY for USER_NAME. Custom images must follow the same convention.
+	DefaultImage = "lscr.io/linuxserver/openssh-server:latest"
+
+	// DefaultInstanceType is the resource preset used when none is given
+	DefaultInstanceType = "small"
+
+	// username is the login user created in the container
+	username = "dev"
+
+	// sshPort is the port sshd listens on inside the container
+	sshPort = "2222/tcp"
+
+	// hostIP is the address SSH ports are published on; containers are only
+	// reachable from the local machine
+	hostIP = "127.0.0.1"
+
+	labelManagedBy    = "managed-by"
+	labelDuration     = "im-duration"
+	labelSession      = "im-session"
+	labelInstanceType = "im-instance-type"
+	managedByValue    = "instance-manager"
+)
+
+// resources is the CPU and memory limit of an instance type preset
+type resources struct {
+	nanoCPUs int64
+	memory   int64
+}
+
+// InstanceTypes lists the resource presets offered by the web UI, default first
+var InstanceTypes = []string{DefaultInstanceType, "micro", "medium", "large"}
+
+var presets = map[string]resources{
+	"micro":  {nanoCPUs: 500_000_000, memory: 256 << 20},
+	"small":  {nanoCPUs: 1_000_000_000, memory: 512 << 20},
+	"medium": {nanoCPUs: 2_000_000_000, memory: 1 << 30},
+	"large":  {nanoCPUs: 4_000_000_000, memory: 2 << 30},
+}
+
+// Provider implements the CloudProvider interface on top of a local Docker
+// daemon, for development and demos. Each instance is a container running
+// sshd; instance IDs are short container IDs.
+type Provider struct {
+	client *client
+	image  string
+}
+
+// NewProvider creates a new Docker provider for the daemon at host (a
+// DOCKER_HOST value such as unix:///var/run/docker.sock)
+func NewProvider(host, image string) (cloud.CloudProvider, error) {
+	if host == "" {
+		host = DefaultHost
+	}
+	httpClient, endpoint, err := newHTTPClient(host)
+	if err != nil {
+		return nil, err
+	}
+	return NewProviderWithClient(httpClient, endpoint, image), nil
+}
+
+// NewProviderWithClient creates a Docker provider that sends API requests to
+// endpoint using the given HTTP client
+func NewProviderWithClient(httpClient *http.Client, endpoint, image string) *Provider {
+	if image == "" {
+		image = DefaultImage
+	}
+	return &Provider{
+		client: &client{httpClient: httpClient, endpoint: endpoint},
+		image:  image,
+	}
+}
+
+// ValidateCredentials checks that the Docker daemon is reachable
+func (p *Provider) ValidateCredentials(ctx context.Context) error {
+	if err := p.client.do(ctx, http.MethodGet, "/_ping", nil, nil); err != nil {
+		return fmt.Errorf("cannot reach the Docker daemon: %w", err)
+	}
+	return nil
+}
+
+// CreateInstance pulls the image and starts a container with sshd published
+// on a random local port, which is recorded in the instance's SSHPort
+func (p *Provider) CreateInstance(ctx context.Context, config models.InstanceConfig) (instance *models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "CreateInstance", "")
+	defer func() {
+		if instance != nil {
+			span.SetAttributes(tracing.AttrInstanceID.String(instance.ID))
+		}
+		tracing.EndSpan(span, err)
+	}()
+
+	if config.SecurityGroupID != "" {
+		return nil, errors.New("security group IDs are not supported on Docker")
+	}
+	if config.KeyName != "" {
+		return nil, errors.New("key pairs are not supported on Docker; use a public key instead")
+	}
+
+	instanceType := config.InstanceType
+	if instanceType == "" {
+		instanceType = DefaultInstanceType
+	}
+	limits, ok := presets[instanceType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported instance type %q for Docker (use one of %s)", instanceType, strings.Join(InstanceTypes, ", "))
+	}
+
+	keyData, err := os.ReadFile(config.PublicKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key file: %w", err)
+	}
+
+	if err := p.client.pullImage(ctx, p.image); err != nil {
+		return nil, fmt.Errorf("failed to pull image: %w", err)
+	}
+
+	labels := map[string]string{
+		labelManagedBy:    managedByValue,
+		labelDuration:     strconv.FormatInt(int64(config.Duration/time.Second), 10),
+		labelInstanceType: instanceType,
+	}
+	if config.Session != "" {
+		labels[labelSession] = config.Session
+	}
+
+	launchTime := time.Now()
+	name := "im-" + strconv.FormatInt(launchTime.UnixNano(), 36)
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err = p.client.do(ctx, http.MethodPost, "/containers/create?name="+name, createContainerRequest{
+		Image:    p.image,
+		Hostname: name,
+		Env: []string{
+			"PUBLIC_KEY=" + strings.TrimSpace(string(keyData)),
+			"USER_NAME=" + username,
+			"SUDO_ACCESS=true",
+			"PASSWORD_ACCESS=false",
+		},
+		Labels:       labels,
+		ExposedPorts: map[string]struct{}{sshPort: {}},
+		HostConfig: hostConfig{
+			// An empty host port lets the daemon pick a free one
+			PortBindings: map[string][]portBinding{sshPort: {{HostIP: hostIP}}},
+			NanoCPUs:     limits.nanoCPUs,
+			Memory:       limits.memory,
+		},
+	}, &created)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container: %w", err)
+	}
+
+	if err := p.client.do(ctx, http.MethodPost, "/containers/"+created.ID+"/start", nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to start container: %w", err)
+	}
+
+	details, err := p.inspect(ctx, created.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	instance = &models.Instance{
+		ID:               shortID(created.ID),
+		InstanceType:     instanceType,
+		State:            containerState(details.State.Status),
+		LaunchTime:       launchTime,
+		Duration:         config.Duration,
+		AvailabilityZone: "local",
+		Region:           "local",
+		PublicIP:         hostIP,
+		PrivateIP:        details.NetworkSettings.IPAddress,
+		SSHPort:          publishedPort(details),
+		Username:         username,
+		ExpiresAt:        launchTime.Add(config.Duration),
+		Provider:         "docker",
+		RestartPolicy:    config.RestartPolicy,
+		Session:          config.Session,
+	}
+
+	return instance, nil
+}
+
+// GetInstanceStatus retrieves the status of a container
+func (p *Provider) GetInstanceStatus(ctx context.Context, instanceID string) (_ *models.InstanceStatus, err error) {
+	ctx, span := p.startSpan(ctx, "GetInstanceStatus", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	details, err := p.inspect(ctx, instanceID)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, errors.New("instance not found")
+		}
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	state := containerState(details.State.Status)
+	status := &models.InstanceStatus{
+		ID:        instanceID,
+		State:     state,
+		PrivateIP: details.NetworkSettings.IPAddress,
+		Username:  username,
+		Ready:     state == "running",
+	}
+	// Ports are only published while the container runs, and may change
+	// across restarts
+	if port := publishedPort(details); port != 0 {
+		status.PublicIP = hostIP
+		status.SSHPort = port
+	}
+	return status, nil
+}
+
+// StartInstance starts a stopped container. The daemon may publish SSH on a
+// different local port than before.
+func (p *Provider) StartInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StartInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(instanceID)+"/start", nil, nil); err != nil {
+		return fmt.Errorf("failed to start instance: %w", err)
+	}
+	return nil
+}
+
+// StopInstance stops a container, giving it ten seconds to shut down
+func (p *Provider) StopInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StopInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(instanceID)+"/stop?t=10", nil, nil); err != nil {
+		return fmt.Errorf("failed to stop instance: %w", err)
+	}
+	return nil
+}
+
+// TerminateInstance removes a container and its anonymous volumes
+func (p *Provider) TerminateInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "TerminateInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.do(ctx, http.MethodDelete, "/containers/"+url.PathEscape(instanceID)+"?force=true&v=true", nil, nil); err != nil {
+		return fmt.Errorf("failed to terminate instance: %w", err)
+	}
+	return nil
+}
+
+// ListInstances lists the containers labelled as managed by this tool,
+// including stopped ones
+func (p *Provider) ListInstances(ctx context.Context) (_ []*models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "ListInstances", "")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	filters := fmt.Sprintf(`{"label":["%s=%s"]}`, labelManagedBy, managedByValue)
+	var containers []containerSummary
+	if err := p.client.do(ctx, http.MethodGet, "/containers/json?all=true&filters="+url.QueryEscape(filters), nil, &containers); err != nil {
+		return nil, fmt.Errorf("failed to list instances: %w", err)
+	}
+
+	instances := make([]*models.Instance, 0, len(containers))
+	for i := range containers {
+		instances = append(instances, toInstance(&containers[i]))
+	}
+	return instances, nil
+}
+
+// inspect returns the details of a container
+func (p *Provider) inspect(ctx context.Context, containerID string) (*containerDetails, error) {
+	var details containerDetails
+	if err := p.client.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(containerID)+"/json", nil, &details); err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+// startSpan starts a tracing span for a Docker provider operation
+func (p *Provider) startSpan(ctx context.Context, operation, instanceID string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		tracing.AttrProvider.String("docker"),
+		attribute.String("docker.image", p.image),
+	}
+	if instanceID != "" {
+		attrs = append(attrs, tracing.AttrInstanceID.String(instanceID))
+	}
+	return tracing.StartSpan(ctx, "docker."+operation, attrs...)
+}
+
+// toInstance converts a container summary to an instance, reading the
+// duration, session and instance type back from its labels
+func toInstance(c *containerSummary) *models.Instance {
+	instance := &models.Instance{
+		ID:               shortID(c.ID),
+		InstanceType:     c.Labels[labelInstanceType],
+		State:            containerState(c.State),
+		LaunchTime:       time.Unix(c.Created, 0),
+		AvailabilityZone: "local",
+		Region:           "local",
+		Username:         username,
+		Provider:         "docker",
+		Session:          c.Labels[labelSession],
+	}
+	for _, port := range c.Ports {
+		if port.Type == "tcp" && strconv.Itoa(port.PrivatePort)+"/tcp" == sshPort && port.PublicPort != 0 {
+			instance.PublicIP = hostIP
+			instance.SSHPort = port.PublicPort
+		}
+	}
+	if seconds, err := strconv.ParseInt(c.Labels[labelDuration], 10, 64); err == nil {
+		instance.Duration = time.Duration(seconds) * time.Second
+		instance.ExpiresAt = instance.LaunchTime.Add(instance.Duration)
+	}
+	return instance
+}
+
+// publishedPort returns the local port sshd is published on, or 0
+func publishedPort(details *containerDetails) int {
+	for _, binding := range details.NetworkSettings.Ports[sshPort] {
+		if port, err := strconv.Atoi(binding.HostPort); err == nil {
+			return port
+		}
+	}
+	return 0
+}
+
+// containerState maps a container status to the states used by the
+// scheduler, which follow EC2's naming
+func containerState(status string) string {
+	switch status {
+	case "created", "restarting":
+		return "pending"
+	case "running":
+		return "running"
+	case "exited", "paused":
+		return "stopped"
+	case "removing":
+		return "shutting-down"
+	case "dead":
+		return "terminated"
+	default:
+		return status
+	}
+}
+
+// shortID truncates a container ID to the 12 characters docker ps shows
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
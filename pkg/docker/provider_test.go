@@ -0,0 +1,309 @@
+package docker_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/docker"
+	"instance-manager/pkg/models"
+)
+
+const containerID = "4fa6e0f0c6786287e131c3852c58a2e01cc697a68231826813597e4994f1d6e2"
+
+// MockDocker serves the subset of the Docker Engine API used by the provider
+type MockDocker struct {
+	pulls      []string
+	pullError  string
+	creates    []map[string]interface{}
+	names      []string
+	actions    []string
+	removed    []string
+	listFilter string
+	containers map[string]map[string]interface{}
+	summaries  []map[string]interface{}
+}
+
+func NewMockDocker() *MockDocker {
+	return &MockDocker{containers: make(map[string]map[string]interface{})}
+}
+
+func (m *MockDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1.41")
+	switch {
+	case path == "/_ping":
+		_, _ = w.Write([]byte("OK"))
+	case r.Method == http.MethodPost && path == "/images/create":
+		m.pulls = append(m.pulls, r.URL.Query().Get("fromImage"))
+		_, _ = w.Write([]byte(`{"status":"Pulling from linuxserver/openssh-server"}` + "\n"))
+		if m.pullError != "" {
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": m.pullError})
+		}
+	case r.Method == http.MethodPost && path == "/containers/create":
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		m.creates = append(m.creates, body)
+		m.names = append(m.names, r.URL.Query().Get("name"))
+		m.containers[containerID] = testContainer("running", "49153")
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"Id": containerID, "Warnings": []string{}})
+	case r.Method == http.MethodGet && path == "/containers/json":
+		m.listFilter = r.URL.Query().Get("filters")
+		writeJSON(w, http.StatusOK, m.summaries)
+	case strings.HasSuffix(path, "/start") || strings.HasSuffix(path, "/stop"):
+		m.actions = append(m.actions, path[strings.LastIndex(path, "/")+1:])
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(path, "/containers/"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/json")
+		container, ok := m.lookup(id)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "No such container: " + id})
+			return
+		}
+		if r.Method == http.MethodDelete {
+			if r.URL.Query().Get("force") != "true" {
+				writeJSON(w, http.StatusConflict, map[string]string{"message": "container is running"})
+				return
+			}
+			m.removed = append(m.removed, id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, container)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// lookup finds a container by full or short ID, like the daemon does
+func (m *MockDocker) lookup(id string) (map[string]interface{}, bool) {
+	for fullID, container := range m.containers {
+		if strings.HasPrefix(fullID, id) {
+			return container, true
+		}
+	}
+	return nil, false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newTestProvider(t *testing.T, mock *MockDocker) *docker.Provider {
+	t.Helper()
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+	return docker.NewProviderWithClient(server.Client(), server.URL, "")
+}
+
+func writePublicKey(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "id_rsa.pub")
+	if err := os.WriteFile(path, []byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQAB test@example\n"), 0600); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+	return path
+}
+
+func testContainer(status, hostPort string) map[string]interface{} {
+	ports := map[string]interface{}{"2222/tcp": nil}
+	if hostPort != "" {
+		ports["2222/tcp"] = []map[string]string{{"HostIp": "127.0.0.1", "HostPort": hostPort}}
+	}
+	return map[string]interface{}{
+		"Id":      containerID,
+		"Name":    "/im-test",
+		"Created": "2024-05-01T10:00:00Z",
+		"State":   map[string]interface{}{"Status": status},
+		"Config":  map[string]interface{}{"Labels": map[string]string{"managed-by": "instance-manager"}},
+		"NetworkSettings": map[string]interface{}{
+			"IPAddress": "172.17.0.2",
+			"Ports":     ports,
+		},
+	}
+}
+
+func TestCreateInstance(t *testing.T) {
+	mock := NewMockDocker()
+	provider := newTestProvider(t, mock)
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:  "medium",
+		Duration:      2 * time.Hour,
+		PublicKeyPath: writePublicKey(t),
+		Session:       "exp-42",
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	if len(mock.pulls) != 1 || mock.pulls[0] != docker.DefaultImage {
+		t.Errorf("Expected the default image to be pulled, got %v", mock.pulls)
+	}
+	if len(mock.creates) != 1 || !strings.HasPrefix(mock.names[0], "im-") {
+		t.Fatalf("Expected 1 container create call with an im- name, got %v", mock.names)
+	}
+
+	body := mock.creates[0]
+	env, _ := json.Marshal(body["Env"])
+	if !strings.Contains(string(env), `"PUBLIC_KEY=ssh-rsa AAAAB3NzaC1yc2EAAAADAQAB test@example"`) {
+		t.Errorf("Expected the public key in the environment, got %s", env)
+	}
+	labels, _ := json.Marshal(body["Labels"])
+	if string(labels) != `{"im-duration":"7200","im-instance-type":"medium","im-session":"exp-42","managed-by":"instance-manager"}` {
+		t.Errorf("Unexpected labels %s", labels)
+	}
+	hostConfig, _ := json.Marshal(body["HostConfig"])
+	if string(hostConfig) != `{"Memory":1073741824,"NanoCpus":2000000000,"PortBindings":{"2222/tcp":[{"HostIp":"127.0.0.1","HostPort":""}]}}` {
+		t.Errorf("Unexpected host config %s", hostConfig)
+	}
+	if strings.Join(mock.actions, ",") != "start" {
+		t.Errorf("Expected the container to be started, got %v", mock.actions)
+	}
+
+	if instance.ID != containerID[:12] || instance.Provider != "docker" || instance.State != "running" {
+		t.Errorf("Unexpected instance %+v", instance)
+	}
+	command, err := instance.RenderConnection("")
+	if err != nil {
+		t.Fatalf("RenderConnection failed: %v", err)
+	}
+	if command != "ssh -p 49153 dev@127.0.0.1" {
+		t.Errorf("Unexpected connection command %q", command)
+	}
+}
+
+func TestCreateInstance_Errors(t *testing.T) {
+	mock := NewMockDocker()
+	provider := newTestProvider(t, mock)
+	keyPath := writePublicKey(t)
+
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{InstanceType: "t2.nano", PublicKeyPath: keyPath}); err == nil {
+		t.Error("Expected an error for an EC2 instance type")
+	}
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{KeyName: "laptop"}); err == nil {
+		t.Error("Expected an error for a key pair name")
+	}
+
+	mock.pullError = "manifest unknown"
+	_, err := provider.CreateInstance(context.Background(), models.InstanceConfig{PublicKeyPath: keyPath})
+	if err == nil || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Errorf("Expected the pull error to be reported, got %v", err)
+	}
+	if len(mock.creates) != 0 {
+		t.Errorf("Expected no containers to be created, got %d", len(mock.creates))
+	}
+}
+
+func TestGetInstanceStatus(t *testing.T) {
+	mock := NewMockDocker()
+	mock.containers[containerID] = testContainer("running", "49153")
+	mock.containers["0123456789ab"] = testContainer("exited", "")
+	provider := newTestProvider(t, mock)
+
+	status, err := provider.GetInstanceStatus(context.Background(), containerID[:12])
+	if err != nil {
+		t.Fatalf("GetInstanceStatus failed: %v", err)
+	}
+	if status.State != "running" || !status.Ready || status.PublicIP != "127.0.0.1" || status.SSHPort != 49153 || status.PrivateIP != "172.17.0.2" {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	status, err = provider.GetInstanceStatus(context.Background(), "0123456789ab")
+	if err != nil {
+		t.Fatalf("GetInstanceStatus failed: %v", err)
+	}
+	if status.State != "stopped" || status.Ready || status.PublicIP != "" || status.SSHPort != 0 {
+		t.Errorf("Expected a stopped instance without a published port, got %+v", status)
+	}
+
+	if _, err := provider.GetInstanceStatus(context.Background(), "missing"); err == nil || err.Error() != "instance not found" {
+		t.Errorf("Expected instance not found, got %v", err)
+	}
+}
+
+func TestListInstances(t *testing.T) {
+	mock := NewMockDocker()
+	mock.summaries = []map[string]interface{}{{
+		"Id":      containerID,
+		"State":   "running",
+		"Created": time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).Unix(),
+		"Labels": map[string]string{
+			"managed-by":       "instance-manager",
+			"im-duration":      "3600",
+			"im-session":       "exp-42",
+			"im-instance-type": "small",
+		},
+		"Ports": []map[string]interface{}{{"PrivatePort": 2222, "PublicPort": 49153, "Type": "tcp"}},
+	}}
+	provider := newTestProvider(t, mock)
+
+	instances, err := provider.ListInstances(context.Background())
+	if err != nil {
+		t.Fatalf("ListInstances failed: %v", err)
+	}
+	if mock.listFilter != `{"label":["managed-by=instance-manager"]}` {
+		t.Errorf("Unexpected list filter %s", mock.listFilter)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("Expected 1 instance, got %d", len(instances))
+	}
+
+	instance := instances[0]
+	if instance.ID != containerID[:12] || instance.State != "running" || instance.Session != "exp-42" || instance.InstanceType != "small" || instance.SSHPort != 49153 {
+		t.Errorf("Unexpected instance %+v", instance)
+	}
+	want := time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)
+	if !instance.ExpiresAt.Equal(want) {
+		t.Errorf("Expected expiry %v, got %v", want, instance.ExpiresAt)
+	}
+}
+
+func TestStopStartAndRemove(t *testing.T) {
+	mock := NewMockDocker()
+	mock.containers[containerID] = testContainer("running", "49153")
+	provider := newTestProvider(t, mock)
+	id := containerID[:12]
+
+	if err := provider.StopInstance(context.Background(), id); err != nil {
+		t.Fatalf("StopInstance failed: %v", err)
+	}
+	if err := provider.StartInstance(context.Background(), id); err != nil {
+		t.Fatalf("StartInstance failed: %v", err)
+	}
+	if err := provider.TerminateInstance(context.Background(), id); err != nil {
+		t.Fatalf("TerminateInstance failed: %v", err)
+	}
+
+	if strings.Join(mock.actions, ",") != "stop,start" {
+		t.Errorf("Unexpected actions %v", mock.actions)
+	}
+	if len(mock.removed) != 1 || mock.removed[0] != id {
+		t.Errorf("Expected container %s to be removed, got %v", id, mock.removed)
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	server := httptest.NewServer(NewMockDocker())
+	defer server.Close()
+
+	if err := docker.NewProviderWithClient(server.Client(), server.URL, "").ValidateCredentials(context.Background()); err != nil {
+		t.Errorf("Expected the daemon to be reachable, got %v", err)
+	}
+
+	server.Close()
+	if err := docker.NewProviderWithClient(server.Client(), server.URL, "").ValidateCredentials(context.Background()); err == nil {
+		t.Error("Expected an error when the daemon is unreachable")
+	}
+
+	if _, err := docker.NewProvider("ssh://user@host", ""); err == nil {
+		t.Error("Expected an error for an unsupported DOCKER_HOST")
+	}
+}
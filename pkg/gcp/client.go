@@ -0,0 +1,85 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// ComputeAPI is the subset of the Compute Engine API used by the provider
+type ComputeAPI interface {
+	GetProject(ctx context.Context, project string) error
+	GetInstance(ctx context.Context, project, zone, name string) (*compute.Instance, error)
+	InsertInstance(ctx context.Context, project, zone string, instance *compute.Instance) error
+	StartInstance(ctx context.Context, project, zone, name string) error
+	StopInstance(ctx context.Context, project, zone, name string) error
+	DeleteInstance(ctx context.Context, project, zone, name string) error
+	// ListInstances returns the instances in every zone that match the filter
+	ListInstances(ctx context.Context, project, filter string) ([]*compute.Instance, error)
+	GetFirewall(ctx context.Context, project, name string) (*compute.Firewall, error)
+	InsertFirewall(ctx context.Context, project string, firewall *compute.Firewall) error
+}
+
+// serviceClient implements ComputeAPI on top of the generated compute client
+type serviceClient struct {
+	service *compute.Service
+}
+
+func (c *serviceClient) GetProject(ctx context.Context, project string) error {
+	_, err := c.service.Projects.Get(project).Context(ctx).Do()
+	return err
+}
+
+func (c *serviceClient) GetInstance(ctx context.Context, project, zone, name string) (*compute.Instance, error) {
+	return c.service.Instances.Get(project, zone, name).Context(ctx).Do()
+}
+
+func (c *serviceClient) InsertInstance(ctx context.Context, project, zone string, instance *compute.Instance) error {
+	_, err := c.service.Instances.Insert(project, zone, instance).Context(ctx).Do()
+	return err
+}
+
+func (c *serviceClient) StartInstance(ctx context.Context, project, zone, name string) error {
+	_, err := c.service.Instances.Start(project, zone, name).Context(ctx).Do()
+	return err
+}
+
+func (c *serviceClient) StopInstance(ctx context.Context, project, zone, name string) error {
+	_, err := c.service.Instances.Stop(project, zone, name).Context(ctx).Do()
+	return err
+}
+
+func (c *serviceClient) DeleteInstance(ctx context.Context, project, zone, name string) error {
+	_, err := c.service.Instances.Delete(project, zone, name).Context(ctx).Do()
+	return err
+}
+
+func (c *serviceClient) ListInstances(ctx context.Context, project, filter string) ([]*compute.Instance, error) {
+	var instances []*compute.Instance
+	err := c.service.Instances.AggregatedList(project).Filter(filter).Pages(ctx,
+		func(page *compute.InstanceAggregatedList) error {
+			for _, scoped := range page.Items {
+				instances = append(instances, scoped.Instances...)
+			}
+			return nil
+		})
+	return instances, err
+}
+
+func (c *serviceClient) GetFirewall(ctx context.Context, project, name string) (*compute.Firewall, error) {
+	return c.service.Firewalls.Get(project, name).Context(ctx).Do()
+}
+
+func (c *serviceClient) InsertFirewall(ctx context.Context, project string, firewall *compute.Firewall) error {
+	_, err := c.service.Firewalls.Insert(project, firewall).Context(ctx).Do()
+	return err
+}
+
+// isNotFound reports whether err is a 404 from the Compute Engine API
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
@@ -0,0 +1,425 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+)
+
+const (
+	// DefaultMachineType is used when no machine type is given
+	DefaultMachineType = "e2-micro"
+
+	// DefaultZone is used when no zone is configured
+	DefaultZone = "us-central1-a"
+
+	// defaultUsername is the login user whose SSH key is injected into instances
+	defaultUsername = "instance-manager"
+
+	// sourceImage is the boot disk image family for new instances
+	sourceImage = "projects/debian-cloud/global/images/family/debian-12"
+
+	managedLabel     = "managed-by"
+	managedBy        = "instance-manager"
+	networkTag       = "instance-manager"
+	metadataDuration = "instance-manager-duration"
+	metadataExpires  = "instance-manager-expires-at"
+	metadataSession  = "instance-manager-session"
+)
+
+var zonePattern = regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+-[a-z]$`)
+
+// Provider implements the CloudProvider interface for Google Compute Engine.
+// Instance IDs have the form "zone/name".
+type Provider struct {
+	client  ComputeAPI
+	project string
+	zone    string
+}
+
+// NewProvider creates a new GCP provider for the given project. Credentials
+// are read from credentialsFile if set, otherwise from Application Default
+// Credentials.
+func NewProvider(project, zone, credentialsFile string) (cloud.CloudProvider, error) {
+	if project == "" {
+		return nil, errors.New("GCP_PROJECT environment variable is required")
+	}
+	if zone == "" {
+		zone = DefaultZone
+	}
+
+	var opts []option.ClientOption
+	if credentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(credentialsFile))
+	}
+	service, err := compute.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCP compute client: %w", err)
+	}
+
+	return NewProviderWithClient(&serviceClient{service: service}, project, zone), nil
+}
+
+// NewProviderWithClient creates a GCP provider backed by the given Compute Engine client
+func NewProviderWithClient(client ComputeAPI, project, zone string) *Provider {
+	return &Provider{
+		client:  client,
+		project: project,
+		zone:    zone,
+	}
+}
+
+// ValidateCredentials checks that the credentials can access the project
+func (p *Provider) ValidateCredentials(ctx context.Context) error {
+	if err := p.client.GetProject(ctx, p.project); err != nil {
+		return fmt.Errorf("invalid GCP credentials for project %s: %w", p.project, err)
+	}
+	return nil
+}
+
+// CreateInstance creates a new Compute Engine instance
+func (p *Provider) CreateInstance(ctx context.Context, config models.InstanceConfig) (instance *models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "CreateInstance", "")
+	defer func() {
+		if instance != nil {
+			span.SetAttributes(tracing.AttrInstanceID.String(instance.ID))
+		}
+		tracing.EndSpan(span, err)
+	}()
+
+	if config.KeyName != "" {
+		return nil, errors.New("key pairs are not supported on GCP; use a public key instead")
+	}
+	if config.SecurityGroupID != "" {
+		return nil, errors.New("security groups are not supported on GCP")
+	}
+
+	publicKey, err := os.ReadFile(config.PublicKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key file: %w", err)
+	}
+
+	if err := p.ensureFirewall(ctx, config.OpenPorts); err != nil {
+		return nil, err
+	}
+
+	zone := p.zone
+	if zonePattern.MatchString(config.AvailabilityZone) {
+		zone = config.AvailabilityZone
+	}
+	machineType := config.InstanceType
+	if machineType == "" {
+		machineType = DefaultMachineType
+	}
+
+	launchTime := time.Now()
+	expiresAt := launchTime.Add(config.Duration)
+	name := "im-" + strconv.FormatInt(launchTime.UnixNano(), 36)
+
+	metadata := map[string]string{
+		"ssh-keys":       defaultUsername + ":" + strings.TrimSpace(string(publicKey)),
+		metadataDuration: config.Duration.String(),
+		metadataExpires:  expiresAt.UTC().Format(time.RFC3339),
+	}
+	if config.Session != "" {
+		metadata[metadataSession] = config.Session
+	}
+
+	err = p.client.InsertInstance(ctx, p.project, zone, &compute.Instance{
+		Name:        name,
+		MachineType: fmt.Sprintf("zones/%s/machineTypes/%s", zone, machineType),
+		Labels:      map[string]string{managedLabel: managedBy},
+		Tags:        &compute.Tags{Items: []string{networkTag}},
+		Metadata:    toMetadata(metadata),
+		Disks: []*compute.AttachedDisk{
+			{
+				Boot:       true,
+				AutoDelete: true,
+				InitializeParams: &compute.AttachedDiskInitializeParams{
+					SourceImage: sourceImage,
+				},
+			},
+		},
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{
+				Network: "global/networks/default",
+				AccessConfigs: []*compute.AccessConfig{
+					{Name: "External NAT", Type: "ONE_TO_ONE_NAT"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to launch instance: %w", err)
+	}
+
+	instance = &models.Instance{
+		ID:               zone + "/" + name,
+		InstanceType:     machineType,
+		State:            "pending",
+		LaunchTime:       launchTime,
+		Duration:         config.Duration,
+		AvailabilityZone: zone,
+		Region:           zoneRegion(zone),
+		Username:         defaultUsername,
+		ExpiresAt:        expiresAt,
+		RestartPolicy:    config.RestartPolicy,
+		Session:          config.Session,
+	}
+
+	return instance, nil
+}
+
+// GetInstanceStatus retrieves the status of an instance
+func (p *Provider) GetInstanceStatus(ctx context.Context, instanceID string) (_ *models.InstanceStatus, err error) {
+	ctx, span := p.startSpan(ctx, "GetInstanceStatus", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	zone, name := p.splitID(instanceID)
+	instance, err := p.client.GetInstance(ctx, p.project, zone, name)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, errors.New("instance not found")
+		}
+		return nil, fmt.Errorf("failed to get instance: %w", err)
+	}
+
+	state := instanceState(instance.Status)
+	publicIP, privateIP := instanceIPs(instance)
+	return &models.InstanceStatus{
+		ID:        instanceID,
+		State:     state,
+		PublicIP:  publicIP,
+		PrivateIP: privateIP,
+		Username:  defaultUsername,
+		Ready:     state == "running",
+	}, nil
+}
+
+// StartInstance starts a stopped instance
+func (p *Provider) StartInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StartInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	zone, name := p.splitID(instanceID)
+	if err := p.client.StartInstance(ctx, p.project, zone, name); err != nil {
+		return fmt.Errorf("failed to start instance: %w", err)
+	}
+	return nil
+}
+
+// StopInstance stops a running instance
+func (p *Provider) StopInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StopInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	zone, name := p.splitID(instanceID)
+	if err := p.client.StopInstance(ctx, p.project, zone, name); err != nil {
+		return fmt.Errorf("failed to stop instance: %w", err)
+	}
+	return nil
+}
+
+// TerminateInstance deletes an instance and its boot disk
+func (p *Provider) TerminateInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "TerminateInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	zone, name := p.splitID(instanceID)
+	if err := p.client.DeleteInstance(ctx, p.project, zone, name); err != nil {
+		return fmt.Errorf("failed to terminate instance: %w", err)
+	}
+	return nil
+}
+
+// ListInstances lists the instances managed by this tool in every zone of the project
+func (p *Provider) ListInstances(ctx context.Context) (_ []*models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "ListInstances", "")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	result, err := p.client.ListInstances(ctx, p.project, fmt.Sprintf("labels.%s = %s", managedLabel, managedBy))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instances: %w", err)
+	}
+
+	var instances []*models.Instance
+	for _, instance := range result {
+		zone := lastSegment(instance.Zone)
+		inst := &models.Instance{
+			ID:               zone + "/" + instance.Name,
+			InstanceType:     lastSegment(instance.MachineType),
+			State:            instanceState(instance.Status),
+			AvailabilityZone: zone,
+			Region:           zoneRegion(zone),
+			Username:         defaultUsername,
+		}
+		inst.PublicIP, inst.PrivateIP = instanceIPs(instance)
+		if launchTime, err := time.Parse(time.RFC3339, instance.CreationTimestamp); err == nil {
+			inst.LaunchTime = launchTime
+		}
+
+		// Get duration and session from metadata
+		if instance.Metadata != nil {
+			for _, item := range instance.Metadata.Items {
+				if item.Value == nil {
+					continue
+				}
+				switch item.Key {
+				case metadataDuration:
+					if duration, err := time.ParseDuration(*item.Value); err == nil {
+						inst.Duration = duration
+						inst.ExpiresAt = inst.LaunchTime.Add(duration)
+					}
+				case metadataSession:
+					inst.Session = *item.Value
+				}
+			}
+		}
+
+		instances = append(instances, inst)
+	}
+
+	return instances, nil
+}
+
+// ensureFirewall creates the managed firewall rule that opens the given ports
+// to instances carrying the managed network tag, if it doesn't exist yet
+func (p *Provider) ensureFirewall(ctx context.Context, openPorts []int64) error {
+	ports, err := cloud.NormalizePorts(openPorts)
+	if err != nil {
+		return err
+	}
+
+	name := firewallName(ports)
+	if _, err := p.client.GetFirewall(ctx, p.project, name); err == nil {
+		return nil
+	} else if !isNotFound(err) {
+		return fmt.Errorf("failed to get firewall rule %s: %w", name, err)
+	}
+
+	allowed := make([]string, len(ports))
+	for i, port := range ports {
+		allowed[i] = strconv.FormatInt(port, 10)
+	}
+	err = p.client.InsertFirewall(ctx, p.project, &compute.Firewall{
+		Name:         name,
+		Description:  "Firewall rule for instance-manager",
+		Network:      "global/networks/default",
+		Direction:    "INGRESS",
+		SourceRanges: []string{"0.0.0.0/0"},
+		TargetTags:   []string{networkTag},
+		Allowed: []*compute.FirewallAllowed{
+			{IPProtocol: "tcp", Ports: allowed},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create firewall rule %s: %w", name, err)
+	}
+	return nil
+}
+
+// splitID splits a "zone/name" instance ID. A bare name is looked up in the
+// provider's default zone.
+func (p *Provider) splitID(instanceID string) (zone, name string) {
+	if zone, name, ok := strings.Cut(instanceID, "/"); ok {
+		return zone, name
+	}
+	return p.zone, instanceID
+}
+
+// startSpan starts a tracing span for a GCP provider operation
+func (p *Provider) startSpan(ctx context.Context, operation, instanceID string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		tracing.AttrProvider.String("gcp"),
+		attribute.String("gcp.project", p.project),
+	}
+	if instanceID != "" {
+		attrs = append(attrs, tracing.AttrInstanceID.String(instanceID))
+	}
+	return tracing.StartSpan(ctx, "gcp."+operation, attrs...)
+}
+
+// instanceState maps a Compute Engine status to the states used by the
+// scheduler, which follow EC2's naming
+func instanceState(status string) string {
+	switch status {
+	case "PROVISIONING", "STAGING":
+		return "pending"
+	case "RUNNING":
+		return "running"
+	case "STOPPING", "SUSPENDING":
+		return "stopping"
+	case "TERMINATED", "SUSPENDED":
+		return "stopped"
+	default:
+		return strings.ToLower(status)
+	}
+}
+
+// instanceIPs returns the external and internal IPs of the first network interface
+func instanceIPs(instance *compute.Instance) (publicIP, privateIP string) {
+	if len(instance.NetworkInterfaces) == 0 {
+		return "", ""
+	}
+	nic := instance.NetworkInterfaces[0]
+	for _, access := range nic.AccessConfigs {
+		if access.NatIP != "" {
+			publicIP = access.NatIP
+			break
+		}
+	}
+	return publicIP, nic.NetworkIP
+}
+
+// toMetadata converts a map to instance metadata items sorted by key
+func toMetadata(values map[string]string) *compute.Metadata {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	metadata := &compute.Metadata{}
+	for _, key := range keys {
+		value := values[key]
+		metadata.Items = append(metadata.Items, &compute.MetadataItems{Key: key, Value: &value})
+	}
+	return metadata
+}
+
+// firewallName returns the managed firewall rule name for a set of open ports
+func firewallName(ports []int64) string {
+	parts := make([]string, len(ports))
+	for i, port := range ports {
+		parts[i] = strconv.FormatInt(port, 10)
+	}
+	return "instance-manager-allow-" + strings.Join(parts, "-")
+}
+
+// zoneRegion returns the region of a zone, e.g. "us-central1" for "us-central1-a"
+func zoneRegion(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// lastSegment returns the final path segment of a resource URL
+func lastSegment(url string) string {
+	return url[strings.LastIndex(url, "/")+1:]
+}
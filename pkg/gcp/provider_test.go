@@ -0,0 +1,295 @@
+package gcp_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/gcp"
+	"instance-manager/pkg/models"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// MockCompute implements the subset of the Compute Engine API used by the provider
+type MockCompute struct {
+	instances      map[string]*compute.Instance // keyed by "zone/name"
+	firewalls      map[string]*compute.Firewall
+	insertCalls    []*compute.Instance
+	insertZones    []string
+	firewallCalls  []*compute.Firewall
+	stopCalls      []string
+	listFilters    []string
+	projectMissing bool
+}
+
+func NewMockCompute() *MockCompute {
+	return &MockCompute{
+		instances: make(map[string]*compute.Instance),
+		firewalls: map[string]*compute.Firewall{
+			"instance-manager-allow-22": {Name: "instance-manager-allow-22"},
+		},
+	}
+}
+
+func notFound() error {
+	return &googleapi.Error{Code: http.StatusNotFound, Message: "not found"}
+}
+
+func (m *MockCompute) GetProject(ctx context.Context, project string) error {
+	if m.projectMissing {
+		return notFound()
+	}
+	return nil
+}
+
+func (m *MockCompute) GetInstance(ctx context.Context, project, zone, name string) (*compute.Instance, error) {
+	instance, ok := m.instances[zone+"/"+name]
+	if !ok {
+		return nil, notFound()
+	}
+	return instance, nil
+}
+
+func (m *MockCompute) InsertInstance(ctx context.Context, project, zone string, instance *compute.Instance) error {
+	m.insertCalls = append(m.insertCalls, instance)
+	m.insertZones = append(m.insertZones, zone)
+	return nil
+}
+
+func (m *MockCompute) StartInstance(ctx context.Context, project, zone, name string) error {
+	return nil
+}
+
+func (m *MockCompute) StopInstance(ctx context.Context, project, zone, name string) error {
+	m.stopCalls = append(m.stopCalls, zone+"/"+name)
+	return nil
+}
+
+func (m *MockCompute) DeleteInstance(ctx context.Context, project, zone, name string) error {
+	delete(m.instances, zone+"/"+name)
+	return nil
+}
+
+func (m *MockCompute) ListInstances(ctx context.Context, project, filter string) ([]*compute.Instance, error) {
+	m.listFilters = append(m.listFilters, filter)
+	var instances []*compute.Instance
+	for _, instance := range m.instances {
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+func (m *MockCompute) GetFirewall(ctx context.Context, project, name string) (*compute.Firewall, error) {
+	firewall, ok := m.firewalls[name]
+	if !ok {
+		return nil, notFound()
+	}
+	return firewall, nil
+}
+
+func (m *MockCompute) InsertFirewall(ctx context.Context, project string, firewall *compute.Firewall) error {
+	m.firewallCalls = append(m.firewallCalls, firewall)
+	m.firewalls[firewall.Name] = firewall
+	return nil
+}
+
+func writePublicKey(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "id_rsa.pub")
+	if err := os.WriteFile(path, []byte("ssh-rsa AAAAB3NzaC1yc2E test@example\n"), 0600); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+	return path
+}
+
+func metadataValue(instance *compute.Instance, key string) string {
+	for _, item := range instance.Metadata.Items {
+		if item.Key == key && item.Value != nil {
+			return *item.Value
+		}
+	}
+	return ""
+}
+
+func TestCreateInstance(t *testing.T) {
+	mock := NewMockCompute()
+	provider := gcp.NewProviderWithClient(mock, "my-project", "us-central1-a")
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:     "e2-small",
+		Duration:         2 * time.Hour,
+		PublicKeyPath:    writePublicKey(t),
+		AvailabilityZone: "europe-west1-b",
+		Session:          "exp-42",
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	if len(mock.insertCalls) != 1 {
+		t.Fatalf("Expected 1 insert call, got %d", len(mock.insertCalls))
+	}
+	inserted := mock.insertCalls[0]
+	if mock.insertZones[0] != "europe-west1-b" {
+		t.Errorf("Expected zone europe-west1-b, got %s", mock.insertZones[0])
+	}
+	if inserted.MachineType != "zones/europe-west1-b/machineTypes/e2-small" {
+		t.Errorf("Unexpected machine type %s", inserted.MachineType)
+	}
+	if inserted.Labels["managed-by"] != "instance-manager" {
+		t.Errorf("Expected managed-by label, got %v", inserted.Labels)
+	}
+	if got := metadataValue(inserted, "ssh-keys"); got != "instance-manager:ssh-rsa AAAAB3NzaC1yc2E test@example" {
+		t.Errorf("Unexpected ssh-keys metadata %q", got)
+	}
+	if got := metadataValue(inserted, "instance-manager-duration"); got != "2h0m0s" {
+		t.Errorf("Expected duration metadata 2h0m0s, got %q", got)
+	}
+	if got := metadataValue(inserted, "instance-manager-session"); got != "exp-42" {
+		t.Errorf("Expected session metadata exp-42, got %q", got)
+	}
+
+	if instance.ID != "europe-west1-b/"+inserted.Name {
+		t.Errorf("Expected zone-qualified ID, got %s", instance.ID)
+	}
+	if instance.Region != "europe-west1" {
+		t.Errorf("Expected region europe-west1, got %s", instance.Region)
+	}
+	if instance.Username != "instance-manager" || instance.State != "pending" {
+		t.Errorf("Unexpected instance %+v", instance)
+	}
+	if len(mock.firewallCalls) != 0 {
+		t.Errorf("Expected existing SSH firewall rule to be reused, got %d inserts", len(mock.firewallCalls))
+	}
+}
+
+func TestCreateInstance_DefaultsAndFirewall(t *testing.T) {
+	mock := NewMockCompute()
+	provider := gcp.NewProviderWithClient(mock, "my-project", "us-central1-a")
+
+	// AWS-style zones fall back to the configured zone
+	_, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		Duration:         time.Hour,
+		PublicKeyPath:    writePublicKey(t),
+		AvailabilityZone: "us-east-1a",
+		OpenPorts:        []int64{443, 22},
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	if mock.insertZones[0] != "us-central1-a" {
+		t.Errorf("Expected default zone, got %s", mock.insertZones[0])
+	}
+	if !strings.HasSuffix(mock.insertCalls[0].MachineType, "/"+gcp.DefaultMachineType) {
+		t.Errorf("Expected default machine type, got %s", mock.insertCalls[0].MachineType)
+	}
+	if len(mock.firewallCalls) != 1 {
+		t.Fatalf("Expected 1 firewall insert, got %d", len(mock.firewallCalls))
+	}
+	firewall := mock.firewallCalls[0]
+	if firewall.Name != "instance-manager-allow-22-443" {
+		t.Errorf("Unexpected firewall name %s", firewall.Name)
+	}
+	if got := strings.Join(firewall.Allowed[0].Ports, ","); got != "22,443" {
+		t.Errorf("Expected ports 22,443, got %s", got)
+	}
+}
+
+func TestCreateInstance_RejectsKeyName(t *testing.T) {
+	provider := gcp.NewProviderWithClient(NewMockCompute(), "my-project", "us-central1-a")
+
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{KeyName: "my-key", Duration: time.Hour}); err == nil {
+		t.Error("Expected an error for a named key pair")
+	}
+}
+
+func TestGetInstanceStatus(t *testing.T) {
+	mock := NewMockCompute()
+	mock.instances["us-central1-a/im-1"] = &compute.Instance{
+		Name:   "im-1",
+		Status: "TERMINATED",
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{NetworkIP: "10.128.0.5", AccessConfigs: []*compute.AccessConfig{{NatIP: "34.1.2.3"}}},
+		},
+	}
+	provider := gcp.NewProviderWithClient(mock, "my-project", "us-central1-a")
+
+	for _, id := range []string{"us-central1-a/im-1", "im-1"} {
+		status, err := provider.GetInstanceStatus(context.Background(), id)
+		if err != nil {
+			t.Fatalf("GetInstanceStatus(%s) failed: %v", id, err)
+		}
+		if status.State != "stopped" || status.Ready {
+			t.Errorf("Expected stopped instance, got %s", status.State)
+		}
+		if status.PublicIP != "34.1.2.3" || status.PrivateIP != "10.128.0.5" {
+			t.Errorf("Unexpected IPs %s / %s", status.PublicIP, status.PrivateIP)
+		}
+	}
+
+	if _, err := provider.GetInstanceStatus(context.Background(), "us-central1-a/missing"); err == nil {
+		t.Error("Expected an error for a missing instance")
+	}
+}
+
+func TestListInstances(t *testing.T) {
+	mock := NewMockCompute()
+	duration := "3h0m0s"
+	session := "exp-42"
+	mock.instances["us-central1-b/im-2"] = &compute.Instance{
+		Name:              "im-2",
+		Zone:              "https://www.googleapis.com/compute/v1/projects/my-project/zones/us-central1-b",
+		MachineType:       "https://www.googleapis.com/compute/v1/projects/my-project/zones/us-central1-b/machineTypes/e2-medium",
+		Status:            "RUNNING",
+		CreationTimestamp: "2025-01-01T12:00:00Z",
+		Metadata: &compute.Metadata{Items: []*compute.MetadataItems{
+			{Key: "instance-manager-duration", Value: &duration},
+			{Key: "instance-manager-session", Value: &session},
+		}},
+	}
+	provider := gcp.NewProviderWithClient(mock, "my-project", "us-central1-a")
+
+	instances, err := provider.ListInstances(context.Background())
+	if err != nil {
+		t.Fatalf("ListInstances failed: %v", err)
+	}
+	if mock.listFilters[0] != "labels.managed-by = instance-manager" {
+		t.Errorf("Unexpected list filter %q", mock.listFilters[0])
+	}
+	if len(instances) != 1 {
+		t.Fatalf("Expected 1 instance, got %d", len(instances))
+	}
+
+	instance := instances[0]
+	if instance.ID != "us-central1-b/im-2" || instance.InstanceType != "e2-medium" || instance.State != "running" {
+		t.Errorf("Unexpected instance %+v", instance)
+	}
+	wantExpiry := time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC)
+	if !instance.ExpiresAt.Equal(wantExpiry) {
+		t.Errorf("Expected expiry %s, got %s", wantExpiry, instance.ExpiresAt)
+	}
+	if instance.Session != "exp-42" {
+		t.Errorf("Expected session exp-42, got %s", instance.Session)
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	mock := NewMockCompute()
+	provider := gcp.NewProviderWithClient(mock, "my-project", "us-central1-a")
+
+	if err := provider.ValidateCredentials(context.Background()); err != nil {
+		t.Errorf("Expected valid credentials, got %v", err)
+	}
+
+	mock.projectMissing = true
+	if err := provider.ValidateCredentials(context.Background()); err == nil {
+		t.Error("Expected an error when the project is not accessible")
+	}
+}
@@ -0,0 +1,130 @@
+package hetzner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultEndpoint is the Hetzner Cloud API base URL
+const DefaultEndpoint = "https://api.hetzner.cloud/v1"
+
+// APIError is an error response from the Hetzner Cloud API
+type APIError struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("hetzner API returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("hetzner API returned status %d: %s (%s)", e.StatusCode, e.Message, e.Code)
+}
+
+// isNotFound reports whether err is a 404 from the Hetzner Cloud API
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// server is the subset of the server resource used by the provider
+type server struct {
+	ID         int64             `json:"id"`
+	Name       string            `json:"name"`
+	Status     string            `json:"status"`
+	Created    time.Time         `json:"created"`
+	Labels     map[string]string `json:"labels"`
+	ServerType struct {
+		Name string `json:"name"`
+	} `json:"server_type"`
+	Datacenter struct {
+		Name     string `json:"name"`
+		Location struct {
+			Name string `json:"name"`
+		} `json:"location"`
+	} `json:"datacenter"`
+	PublicNet struct {
+		IPv4 struct {
+			IP string `json:"ip"`
+		} `json:"ipv4"`
+	} `json:"public_net"`
+	PrivateNet []struct {
+		IP string `json:"ip"`
+	} `json:"private_net"`
+}
+
+// sshKey is an SSH key registered with the project
+type sshKey struct {
+	ID          int64  `json:"id,omitempty"`
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	PublicKey   string `json:"public_key"`
+}
+
+// createServerRequest is the body of POST /servers
+type createServerRequest struct {
+	Name       string            `json:"name"`
+	ServerType string            `json:"server_type"`
+	Image      string            `json:"image"`
+	Location   string            `json:"location"`
+	SSHKeys    []string          `json:"ssh_keys"`
+	Labels     map[string]string `json:"labels"`
+}
+
+// client is a minimal Hetzner Cloud API client
+type client struct {
+	httpClient *http.Client
+	endpoint   string
+	token      string
+}
+
+// do sends a request with an optional JSON body and decodes the JSON
+// response into out, if given. Non-2xx responses are returned as *APIError.
+func (c *client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.endpoint, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var envelope struct {
+			Error APIError `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&envelope)
+		envelope.Error.StatusCode = resp.StatusCode
+		return &envelope.Error
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
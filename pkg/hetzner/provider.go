@@ -0,0 +1,373 @@
+package hetzner
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultServerType is the server type used when none is given
+	DefaultServerType = "cx22"
+
+	// DefaultLocation is used when no location is configured
+	DefaultLocation = "fsn1"
+
+	// image is the distribution image for new servers
+	image = "ubuntu-22.04"
+
+	// Labels stored on managed servers
+	managedByLabel = "managed-by"
+	managedBy      = "instance-manager"
+	durationLabel  = "duration"
+	sessionLabel   = "session"
+)
+
+// ServerTypes lists common shared-vCPU server types offered by the web UI,
+// default first
+var ServerTypes = []string{
+	DefaultServerType,
+	"cx32",
+	"cx42",
+	"cax11",
+	"cax21",
+	"cpx11",
+	"cpx21",
+	"cpx31",
+}
+
+// labelValuePattern matches the label values Hetzner accepts
+var labelValuePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]{0,61}[A-Za-z0-9])?$`)
+
+// Provider implements the CloudProvider interface for Hetzner Cloud servers.
+// Instance IDs are server IDs.
+type Provider struct {
+	client   *client
+	location string
+}
+
+// NewProvider creates a new Hetzner Cloud provider using an API token
+func NewProvider(token, location string) (cloud.CloudProvider, error) {
+	if token == "" {
+		return nil, errors.New("HCLOUD_TOKEN environment variable is required")
+	}
+	if location == "" {
+		location = DefaultLocation
+	}
+	return NewProviderWithClient(&http.Client{Timeout: 30 * time.Second}, DefaultEndpoint, token, location), nil
+}
+
+// NewProviderWithClient creates a Hetzner Cloud provider that sends API
+// requests to endpoint using the given HTTP client
+func NewProviderWithClient(httpClient *http.Client, endpoint, token, location string) *Provider {
+	return &Provider{
+		client:   &client{httpClient: httpClient, endpoint: endpoint, token: token},
+		location: location,
+	}
+}
+
+// ValidateCredentials checks that the API token is valid
+func (p *Provider) ValidateCredentials(ctx context.Context) error {
+	if err := p.client.do(ctx, http.MethodGet, "/locations", nil, nil); err != nil {
+		return fmt.Errorf("invalid Hetzner Cloud token: %w", err)
+	}
+	return nil
+}
+
+// CreateInstance registers the public key with the project if needed and
+// creates a server labelled with its duration. Servers have no firewall by
+// default, so OpenPorts needs no extra setup.
+func (p *Provider) CreateInstance(ctx context.Context, config models.InstanceConfig) (instance *models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "CreateInstance", "")
+	defer func() {
+		if instance != nil {
+			span.SetAttributes(tracing.AttrInstanceID.String(instance.ID))
+		}
+		tracing.EndSpan(span, err)
+	}()
+
+	if config.SecurityGroupID != "" {
+		return nil, errors.New("security group IDs are not supported on Hetzner Cloud")
+	}
+
+	labels := map[string]string{
+		managedByLabel: managedBy,
+		durationLabel:  strconv.FormatInt(int64(config.Duration/time.Second), 10),
+	}
+	if config.Session != "" {
+		if !labelValuePattern.MatchString(config.Session) {
+			return nil, fmt.Errorf("session %q cannot be stored as a Hetzner label (it must start and end with a letter or digit)", config.Session)
+		}
+		labels[sessionLabel] = config.Session
+	}
+
+	// A key name refers to an SSH key already registered with the project
+	keyName := config.KeyName
+	if keyName == "" {
+		keyName, err = p.importSSHKey(ctx, config.PublicKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import SSH key: %w", err)
+		}
+	}
+
+	serverType := config.InstanceType
+	if serverType == "" {
+		serverType = DefaultServerType
+	}
+
+	launchTime := time.Now()
+	var result struct {
+		Server server `json:"server"`
+	}
+	err = p.client.do(ctx, http.MethodPost, "/servers", createServerRequest{
+		Name:       "im-" + strconv.FormatInt(launchTime.UnixNano(), 36),
+		ServerType: serverType,
+		Image:      image,
+		Location:   p.location,
+		SSHKeys:    []string{keyName},
+		Labels:     labels,
+	}, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create server: %w", err)
+	}
+
+	instance = &models.Instance{
+		ID:               strconv.FormatInt(result.Server.ID, 10),
+		InstanceType:     serverType,
+		State:            "pending",
+		PublicIP:         result.Server.PublicNet.IPv4.IP,
+		LaunchTime:       launchTime,
+		Duration:         config.Duration,
+		AvailabilityZone: p.location,
+		Region:           p.location,
+		KeyName:          keyName,
+		Username:         "root",
+		ExpiresAt:        launchTime.Add(config.Duration),
+		Provider:         "hetzner",
+		RestartPolicy:    config.RestartPolicy,
+		Session:          config.Session,
+	}
+
+	return instance, nil
+}
+
+// GetInstanceStatus retrieves the status of a server
+func (p *Provider) GetInstanceStatus(ctx context.Context, instanceID string) (_ *models.InstanceStatus, err error) {
+	ctx, span := p.startSpan(ctx, "GetInstanceStatus", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	var result struct {
+		Server server `json:"server"`
+	}
+	if err := p.client.do(ctx, http.MethodGet, "/servers/"+url.PathEscape(instanceID), nil, &result); err != nil {
+		if isNotFound(err) {
+			return nil, errors.New("instance not found")
+		}
+		return nil, fmt.Errorf("failed to get server: %w", err)
+	}
+
+	state := serverState(result.Server.Status)
+	return &models.InstanceStatus{
+		ID:        instanceID,
+		State:     state,
+		PublicIP:  result.Server.PublicNet.IPv4.IP,
+		PrivateIP: privateIP(&result.Server),
+		Username:  "root",
+		Ready:     state == "running",
+	}, nil
+}
+
+// StartInstance powers on a server
+func (p *Provider) StartInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StartInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.action(ctx, instanceID, "poweron"); err != nil {
+		return fmt.Errorf("failed to start instance: %w", err)
+	}
+	return nil
+}
+
+// StopInstance powers off a server. Stopped servers are still billed.
+func (p *Provider) StopInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StopInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.action(ctx, instanceID, "poweroff"); err != nil {
+		return fmt.Errorf("failed to stop instance: %w", err)
+	}
+	return nil
+}
+
+// TerminateInstance deletes a server
+func (p *Provider) TerminateInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "TerminateInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.do(ctx, http.MethodDelete, "/servers/"+url.PathEscape(instanceID), nil, nil); err != nil {
+		return fmt.Errorf("failed to terminate instance: %w", err)
+	}
+	return nil
+}
+
+// ListInstances lists the servers labelled as managed by this tool
+func (p *Provider) ListInstances(ctx context.Context) (_ []*models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "ListInstances", "")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	selector := url.QueryEscape(managedByLabel + "=" + managedBy)
+	var instances []*models.Instance
+	for page := 1; ; page++ {
+		var result struct {
+			Servers []server `json:"servers"`
+			Meta    struct {
+				Pagination struct {
+					NextPage *int `json:"next_page"`
+				} `json:"pagination"`
+			} `json:"meta"`
+		}
+		path := fmt.Sprintf("/servers?label_selector=%s&per_page=50&page=%d", selector, page)
+		if err := p.client.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+			return nil, fmt.Errorf("failed to list instances: %w", err)
+		}
+
+		for i := range result.Servers {
+			instances = append(instances, toInstance(&result.Servers[i]))
+		}
+		if result.Meta.Pagination.NextPage == nil {
+			break
+		}
+	}
+
+	return instances, nil
+}
+
+// importSSHKey registers the public key with the project unless a key with
+// the same fingerprint already exists, and returns the key's name
+func (p *Provider) importSSHKey(ctx context.Context, publicKeyPath string) (string, error) {
+	keyData, err := os.ReadFile(publicKeyPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read public key file: %w", err)
+	}
+	publicKey := strings.TrimSpace(string(keyData))
+
+	fingerprint, err := fingerprintMD5(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	var existing struct {
+		SSHKeys []sshKey `json:"ssh_keys"`
+	}
+	if err := p.client.do(ctx, http.MethodGet, "/ssh_keys?fingerprint="+url.QueryEscape(fingerprint), nil, &existing); err != nil {
+		return "", err
+	}
+	if len(existing.SSHKeys) > 0 {
+		return existing.SSHKeys[0].Name, nil
+	}
+
+	name := "instance-manager-" + strings.ReplaceAll(fingerprint, ":", "")[:16]
+	if err := p.client.do(ctx, http.MethodPost, "/ssh_keys", sshKey{Name: name, PublicKey: publicKey}, nil); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// action triggers a server action such as poweron or poweroff
+func (p *Provider) action(ctx context.Context, instanceID, actionType string) error {
+	return p.client.do(ctx, http.MethodPost, "/servers/"+url.PathEscape(instanceID)+"/actions/"+actionType, nil, nil)
+}
+
+// startSpan starts a tracing span for a Hetzner Cloud provider operation
+func (p *Provider) startSpan(ctx context.Context, operation, instanceID string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		tracing.AttrProvider.String("hetzner"),
+		attribute.String("hetzner.location", p.location),
+	}
+	if instanceID != "" {
+		attrs = append(attrs, tracing.AttrInstanceID.String(instanceID))
+	}
+	return tracing.StartSpan(ctx, "hetzner."+operation, attrs...)
+}
+
+// toInstance converts a server to an instance, reading the duration and
+// session back from its labels
+func toInstance(s *server) *models.Instance {
+	instance := &models.Instance{
+		ID:               strconv.FormatInt(s.ID, 10),
+		InstanceType:     s.ServerType.Name,
+		State:            serverState(s.Status),
+		PublicIP:         s.PublicNet.IPv4.IP,
+		PrivateIP:        privateIP(s),
+		LaunchTime:       s.Created,
+		AvailabilityZone: s.Datacenter.Location.Name,
+		Region:           s.Datacenter.Location.Name,
+		Username:         "root",
+		Provider:         "hetzner",
+		Session:          s.Labels[sessionLabel],
+	}
+	if seconds, err := strconv.ParseInt(s.Labels[durationLabel], 10, 64); err == nil {
+		instance.Duration = time.Duration(seconds) * time.Second
+		instance.ExpiresAt = instance.LaunchTime.Add(instance.Duration)
+	}
+	return instance
+}
+
+// serverState maps a server status to the states used by the scheduler,
+// which follow EC2's naming
+func serverState(status string) string {
+	switch status {
+	case "initializing", "starting":
+		return "pending"
+	case "off":
+		return "stopped"
+	case "deleting":
+		return "shutting-down"
+	default:
+		return status
+	}
+}
+
+// privateIP returns the server's address on its first private network
+func privateIP(s *server) string {
+	if len(s.PrivateNet) == 0 {
+		return ""
+	}
+	return s.PrivateNet[0].IP
+}
+
+// fingerprintMD5 returns the MD5 fingerprint Hetzner uses to identify an SSH
+// public key, e.g. "3b:16:bf:e4:8b:00:8b:b8:59:8c:a9:d3:f0:19:45:fa"
+func fingerprintMD5(publicKey string) (string, error) {
+	fields := strings.Fields(publicKey)
+	if len(fields) < 2 {
+		return "", errors.New("invalid public key format")
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid public key format: %w", err)
+	}
+
+	sum := md5.Sum(blob)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, ":"), nil
+}
@@ -0,0 +1,298 @@
+package hetzner_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/hetzner"
+	"instance-manager/pkg/models"
+)
+
+// MockHetzner serves the subset of the Hetzner Cloud API used by the provider
+type MockHetzner struct {
+	keys         map[string]string // fingerprint -> name
+	fingerprints []string
+	keyCalls     []map[string]string
+	creates      []map[string]interface{}
+	actions      []string
+	deleted      []string
+	servers      map[string]map[string]interface{}
+	selectors    []string
+}
+
+func NewMockHetzner() *MockHetzner {
+	return &MockHetzner{
+		keys:    make(map[string]string),
+		servers: make(map[string]map[string]interface{}),
+	}
+}
+
+func (m *MockHetzner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "unable to authenticate")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1")
+	switch {
+	case path == "/locations":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"locations": []interface{}{}})
+	case r.Method == http.MethodGet && path == "/ssh_keys":
+		fingerprint := r.URL.Query().Get("fingerprint")
+		m.fingerprints = append(m.fingerprints, fingerprint)
+		var keys []map[string]string
+		if name, ok := m.keys[fingerprint]; ok {
+			keys = append(keys, map[string]string{"name": name})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ssh_keys": keys})
+	case r.Method == http.MethodPost && path == "/ssh_keys":
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		m.keyCalls = append(m.keyCalls, body)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"ssh_key": body})
+	case r.Method == http.MethodPost && path == "/servers":
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		m.creates = append(m.creates, body)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{
+			"server": map[string]interface{}{
+				"id":         4711,
+				"status":     "initializing",
+				"public_net": map[string]interface{}{"ipv4": map[string]string{"ip": "95.217.1.2"}},
+			},
+		})
+	case r.Method == http.MethodGet && path == "/servers":
+		m.selectors = append(m.selectors, r.URL.Query().Get("label_selector"))
+		var servers []map[string]interface{}
+		for _, s := range m.servers {
+			servers = append(servers, s)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"servers": servers,
+			"meta":    map[string]interface{}{"pagination": map[string]interface{}{"next_page": nil}},
+		})
+	case strings.Contains(path, "/actions/"):
+		m.actions = append(m.actions, path[strings.LastIndex(path, "/")+1:])
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"action": map[string]string{"status": "running"}})
+	case strings.HasPrefix(path, "/servers/"):
+		id := strings.TrimPrefix(path, "/servers/")
+		s, ok := m.servers[id]
+		if !ok {
+			writeError(w, http.StatusNotFound, "not_found", "server not found")
+			return
+		}
+		if r.Method == http.MethodDelete {
+			m.deleted = append(m.deleted, id)
+			writeJSON(w, http.StatusOK, map[string]interface{}{"action": map[string]string{"status": "running"}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"server": s})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{"error": map[string]string{"code": code, "message": message}})
+}
+
+func newTestProvider(t *testing.T, mock *MockHetzner) *hetzner.Provider {
+	t.Helper()
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+	return hetzner.NewProviderWithClient(server.Client(), server.URL+"/v1", "test-token", "hel1")
+}
+
+func writePublicKey(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "id_rsa.pub")
+	if err := os.WriteFile(path, []byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQAB test@example\n"), 0600); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+	return path
+}
+
+func testServer(id int, status string, labels map[string]string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          id,
+		"name":        "im-test",
+		"status":      status,
+		"created":     "2024-05-01T10:00:00+00:00",
+		"labels":      labels,
+		"server_type": map[string]string{"name": "cx22"},
+		"datacenter":  map[string]interface{}{"name": "hel1-dc2", "location": map[string]string{"name": "hel1"}},
+		"public_net":  map[string]interface{}{"ipv4": map[string]string{"ip": "95.217.1.2"}},
+		"private_net": []map[string]string{{"ip": "10.0.0.2"}},
+	}
+}
+
+func TestCreateInstance(t *testing.T) {
+	mock := NewMockHetzner()
+	provider := newTestProvider(t, mock)
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:  "cx32",
+		Duration:      2 * time.Hour,
+		PublicKeyPath: writePublicKey(t),
+		Session:       "exp.42",
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	if len(mock.keyCalls) != 1 || mock.keyCalls[0]["public_key"] != "ssh-rsa AAAAB3NzaC1yc2EAAAADAQAB test@example" {
+		t.Fatalf("Expected the public key to be uploaded once, got %v", mock.keyCalls)
+	}
+	if len(mock.creates) != 1 {
+		t.Fatalf("Expected 1 server create call, got %d", len(mock.creates))
+	}
+
+	body := mock.creates[0]
+	if body["server_type"] != "cx32" || body["location"] != "hel1" || body["image"] != "ubuntu-22.04" {
+		t.Errorf("Unexpected create request %v", body)
+	}
+	labels := body["labels"].(map[string]interface{})
+	if labels["managed-by"] != "instance-manager" || labels["duration"] != "7200" || labels["session"] != "exp.42" {
+		t.Errorf("Unexpected labels %v", labels)
+	}
+	if keys := body["ssh_keys"].([]interface{}); len(keys) != 1 || keys[0] != mock.keyCalls[0]["name"] {
+		t.Errorf("Expected the uploaded key to be used, got %v", keys)
+	}
+
+	if instance.ID != "4711" || instance.Provider != "hetzner" || instance.PublicIP != "95.217.1.2" || instance.Region != "hel1" {
+		t.Errorf("Unexpected instance %+v", instance)
+	}
+}
+
+func TestCreateInstance_ReusesExistingKey(t *testing.T) {
+	mock := NewMockHetzner()
+	provider := newTestProvider(t, mock)
+	keyPath := writePublicKey(t)
+
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{Duration: time.Hour, PublicKeyPath: keyPath}); err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	mock.keys[mock.fingerprints[0]] = "team-key"
+
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{Duration: time.Hour, PublicKeyPath: keyPath}); err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	if len(mock.keyCalls) != 1 {
+		t.Errorf("Expected the registered key to be reused, got %d uploads", len(mock.keyCalls))
+	}
+	if keys := mock.creates[1]["ssh_keys"].([]interface{}); keys[0] != "team-key" {
+		t.Errorf("Expected the registered key name, got %v", keys)
+	}
+	if mock.creates[1]["server_type"] != hetzner.DefaultServerType {
+		t.Errorf("Expected default server type %s, got %v", hetzner.DefaultServerType, mock.creates[1]["server_type"])
+	}
+}
+
+func TestCreateInstance_RejectsInvalidSession(t *testing.T) {
+	provider := newTestProvider(t, NewMockHetzner())
+
+	_, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		Duration:      time.Hour,
+		PublicKeyPath: writePublicKey(t),
+		Session:       "exp-",
+	})
+	if err == nil {
+		t.Fatal("Expected an error for a session that can't be a label value")
+	}
+}
+
+func TestGetInstanceStatus(t *testing.T) {
+	mock := NewMockHetzner()
+	mock.servers["4711"] = testServer(4711, "running", nil)
+	provider := newTestProvider(t, mock)
+
+	status, err := provider.GetInstanceStatus(context.Background(), "4711")
+	if err != nil {
+		t.Fatalf("GetInstanceStatus failed: %v", err)
+	}
+	if status.State != "running" || !status.Ready || status.PublicIP != "95.217.1.2" || status.PrivateIP != "10.0.0.2" {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	if _, err := provider.GetInstanceStatus(context.Background(), "404"); err == nil || err.Error() != "instance not found" {
+		t.Errorf("Expected instance not found, got %v", err)
+	}
+}
+
+func TestListInstances(t *testing.T) {
+	mock := NewMockHetzner()
+	mock.servers["4711"] = testServer(4711, "off", map[string]string{
+		"managed-by": "instance-manager",
+		"duration":   "3600",
+		"session":    "exp-42",
+	})
+	provider := newTestProvider(t, mock)
+
+	instances, err := provider.ListInstances(context.Background())
+	if err != nil {
+		t.Fatalf("ListInstances failed: %v", err)
+	}
+	if len(mock.selectors) != 1 || mock.selectors[0] != "managed-by=instance-manager" {
+		t.Errorf("Expected a managed-by label selector, got %v", mock.selectors)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("Expected 1 instance, got %d", len(instances))
+	}
+
+	instance := instances[0]
+	if instance.ID != "4711" || instance.State != "stopped" || instance.Session != "exp-42" || instance.Duration != time.Hour {
+		t.Errorf("Unexpected instance %+v", instance)
+	}
+	want := time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)
+	if !instance.ExpiresAt.Equal(want) {
+		t.Errorf("Expected expiry %v, got %v", want, instance.ExpiresAt)
+	}
+}
+
+func TestPowerAndDelete(t *testing.T) {
+	mock := NewMockHetzner()
+	mock.servers["4711"] = testServer(4711, "running", nil)
+	provider := newTestProvider(t, mock)
+
+	if err := provider.StopInstance(context.Background(), "4711"); err != nil {
+		t.Fatalf("StopInstance failed: %v", err)
+	}
+	if err := provider.StartInstance(context.Background(), "4711"); err != nil {
+		t.Fatalf("StartInstance failed: %v", err)
+	}
+	if err := provider.TerminateInstance(context.Background(), "4711"); err != nil {
+		t.Fatalf("TerminateInstance failed: %v", err)
+	}
+
+	if strings.Join(mock.actions, ",") != "poweroff,poweron" {
+		t.Errorf("Unexpected actions %v", mock.actions)
+	}
+	if len(mock.deleted) != 1 || mock.deleted[0] != "4711" {
+		t.Errorf("Expected server 4711 to be deleted, got %v", mock.deleted)
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	server := httptest.NewServer(NewMockHetzner())
+	defer server.Close()
+
+	if err := hetzner.NewProviderWithClient(server.Client(), server.URL+"/v1", "test-token", "hel1").ValidateCredentials(context.Background()); err != nil {
+		t.Errorf("Expected valid token, got %v", err)
+	}
+	if err := hetzner.NewProviderWithClient(server.Client(), server.URL+"/v1", "wrong", "hel1").ValidateCredentials(context.Background()); err == nil {
+		t.Error("Expected an error for an invalid token")
+	}
+}
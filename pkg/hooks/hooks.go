@@ -0,0 +1,248 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/models"
+)
+
+// Lifecycle events at which hooks run
+const (
+	// PreStop runs before the scheduler stops or hibernates an instance
+	PreStop = "pre-stop"
+	// PostStop runs once the provider accepted the stop of an instance
+	PostStop = "post-stop"
+	// PreTerminate runs before the scheduler terminates an instance
+	PreTerminate = "pre-terminate"
+)
+
+// Failure policies of hooks
+const (
+	// FailProceed logs a failed hook and goes on with the action
+	FailProceed = "proceed"
+	// FailAbort skips the action when the hook fails; the scheduler tries
+	// again, hooks included, on its next pass
+	FailAbort = "abort"
+)
+
+// DefaultTimeout limits hooks that don't set their own timeout
+const DefaultTimeout = time.Minute
+
+// ErrAborted is wrapped by the error of a hook that failed with the abort
+// policy
+var ErrAborted = errors.New("aborted by lifecycle hook")
+
+// Hook is a command or webhook run at a lifecycle event
+type Hook struct {
+	Name      string        // Shown in logs; defaults to the command or URL
+	Event     string        // PreStop, PostStop or PreTerminate
+	Command   string        // Run with sh -c, with the instance in INSTANCE_* variables and the payload on stdin
+	Webhook   string        // URL that receives the payload as a JSON POST
+	Timeout   time.Duration // Limit on a single run; zero uses DefaultTimeout
+	OnFailure string        // FailProceed or FailAbort; empty proceeds
+}
+
+// Validate checks that the hook has a known event and policy and exactly one
+// of a command and a webhook
+func (h Hook) Validate() error {
+	switch h.Event {
+	case PreStop, PostStop, PreTerminate:
+	default:
+		return fmt.Errorf("invalid hook event %q (must be %s, %s or %s)", h.Event, PreStop, PostStop, PreTerminate)
+	}
+	if (h.Command == "") == (h.Webhook == "") {
+		return fmt.Errorf("hook %s must set exactly one of command and webhook", h.name())
+	}
+	switch h.OnFailure {
+	case "", FailProceed:
+	case FailAbort:
+		if h.Event == PostStop {
+			return fmt.Errorf("hook %s: %s hooks run after the stop and cannot abort it", h.name(), PostStop)
+		}
+	default:
+		return fmt.Errorf("invalid failure policy %q of hook %s (must be %s or %s)", h.OnFailure, h.name(), FailProceed, FailAbort)
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("invalid timeout of hook %s: %s", h.name(), h.Timeout)
+	}
+	return nil
+}
+
+// name identifies the hook in errors
+func (h Hook) name() string {
+	switch {
+	case h.Name != "":
+		return h.Name
+	case h.Command != "":
+		return fmt.Sprintf("%q", h.Command)
+	default:
+		return h.Webhook
+	}
+}
+
+// Payload describes the instance and action a hook runs for
+type Payload struct {
+	Event        string `json:"event"`
+	Reason       string `json:"reason"` // Why the instance is stopped or terminated, e.g. "expired" or "idle"
+	InstanceID   string `json:"instance_id"`
+	InstanceName string `json:"instance_name,omitempty"`
+	Provider     string `json:"provider"`
+	Region       string `json:"region,omitempty"`
+	PublicIP     string `json:"public_ip,omitempty"`
+	PrivateIP    string `json:"private_ip,omitempty"`
+	Username     string `json:"username,omitempty"`
+	SSHPort      int    `json:"ssh_port,omitempty"`
+}
+
+// NewPayload describes the action on instance
+func NewPayload(event, reason string, instance *models.Instance) Payload {
+	return Payload{
+		Event:        event,
+		Reason:       reason,
+		InstanceID:   instance.ID,
+		InstanceName: instance.Name,
+		Provider:     instance.Provider,
+		Region:       instance.Region,
+		PublicIP:     instance.PublicIP,
+		PrivateIP:    instance.PrivateIP,
+		Username:     instance.Username,
+		SSHPort:      instance.SSHPort,
+	}
+}
+
+// environment returns the variables that describe the payload to commands
+func (p Payload) environment() []string {
+	sshPort := 22
+	if p.SSHPort != 0 {
+		sshPort = p.SSHPort
+	}
+	return []string{
+		"HOOK_EVENT=" + p.Event,
+		"HOOK_REASON=" + p.Reason,
+		"INSTANCE_ID=" + p.InstanceID,
+		"INSTANCE_NAME=" + p.InstanceName,
+		"INSTANCE_PROVIDER=" + p.Provider,
+		"INSTANCE_REGION=" + p.Region,
+		"INSTANCE_PUBLIC_IP=" + p.PublicIP,
+		"INSTANCE_PRIVATE_IP=" + p.PrivateIP,
+		"INSTANCE_USERNAME=" + p.Username,
+		fmt.Sprintf("INSTANCE_SSH_PORT=%d", sshPort),
+	}
+}
+
+// Runner runs the hooks registered for each lifecycle event
+type Runner struct {
+	hooks      []Hook
+	httpClient *http.Client
+}
+
+// NewRunner creates a runner for hooks, which must be valid
+func NewRunner(hooks []Hook) *Runner {
+	return &Runner{
+		hooks:      hooks,
+		httpClient: &http.Client{},
+	}
+}
+
+// Run runs the hooks registered for payload.Event in order, each limited by
+// its timeout. A hook that fails with the abort policy stops the remaining
+// hooks and its error wraps ErrAborted. The failures of hooks that proceed
+// are joined into the returned error.
+func (r *Runner) Run(ctx context.Context, payload Payload) error {
+	var errs []error
+	for _, hook := range r.hooks {
+		if hook.Event != payload.Event {
+			continue
+		}
+		err := r.run(ctx, hook, payload)
+		if err == nil {
+			continue
+		}
+		if hook.OnFailure == FailAbort {
+			return fmt.Errorf("%w: %s hook %s failed: %w", ErrAborted, hook.Event, hook.name(), err)
+		}
+		errs = append(errs, fmt.Errorf("%s hook %s failed: %w", hook.Event, hook.name(), err))
+	}
+	return errors.Join(errs...)
+}
+
+// run runs a single hook within its timeout
+func (r *Runner) run(ctx context.Context, hook Hook, payload Payload) error {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode hook payload: %w", err)
+	}
+	if hook.Webhook != "" {
+		return r.post(ctx, hook.Webhook, data)
+	}
+	return runCommand(ctx, hook.Command, payload, data)
+}
+
+// post sends the payload to a webhook. Non-2xx responses are returned as
+// errors.
+func (r *Runner) post(ctx context.Context, url string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// maxOutput is how much of a failed command's output is kept in its error
+const maxOutput = 512
+
+// runCommand runs command with sh -c and returns an error with the end of
+// its output when it fails
+func runCommand(ctx context.Context, command string, payload Payload, data []byte) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), payload.environment()...)
+	cmd.Stdin = bytes.NewReader(data)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	killGroupOnCancel(cmd)
+	// Don't wait for children that left the group and still hold the output
+	cmd.WaitDelay = 5 * time.Second
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		message := strings.TrimSpace(output.String())
+		if len(message) > maxOutput {
+			message = "..." + message[len(message)-maxOutput:]
+		}
+		if message != "" {
+			return fmt.Errorf("%w: %s", err, message)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package hooks_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/hooks"
+	"instance-manager/pkg/models"
+)
+
+func TestRunner_Command(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "hook.out")
+	runner := hooks.NewRunner([]hooks.Hook{
+		{Event: hooks.PreStop, Command: `echo "$HOOK_EVENT $HOOK_REASON $INSTANCE_ID $INSTANCE_USERNAME@$INSTANCE_PUBLIC_IP:$INSTANCE_SSH_PORT" > ` + out},
+		{Event: hooks.PreTerminate, Command: "exit 1"},
+	})
+
+	instance := &models.Instance{ID: "i-123", Username: "ubuntu", PublicIP: "203.0.113.10"}
+	if err := runner.Run(context.Background(), hooks.NewPayload(hooks.PreStop, "expired", instance)); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Expected the hook to write its output: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "pre-stop expired i-123 ubuntu@203.0.113.10:22" {
+		t.Errorf("Unexpected hook environment: %q", got)
+	}
+}
+
+func TestRunner_FailurePolicy(t *testing.T) {
+	instance := &models.Instance{ID: "i-123"}
+	payload := hooks.NewPayload(hooks.PreStop, "idle", instance)
+
+	proceeding := hooks.NewRunner([]hooks.Hook{{Name: "drain", Event: hooks.PreStop, Command: "echo draining failed >&2; exit 3"}})
+	err := proceeding.Run(context.Background(), payload)
+	if err == nil || errors.Is(err, hooks.ErrAborted) || !strings.Contains(err.Error(), "draining failed") {
+		t.Errorf("Expected a failure that proceeds with the hook output, got %v", err)
+	}
+
+	out := filepath.Join(t.TempDir(), "later.out")
+	aborting := hooks.NewRunner([]hooks.Hook{
+		{Name: "drain", Event: hooks.PreStop, Command: "exit 1", OnFailure: hooks.FailAbort},
+		{Name: "later", Event: hooks.PreStop, Command: "touch " + out},
+	})
+	if err := aborting.Run(context.Background(), payload); !errors.Is(err, hooks.ErrAborted) {
+		t.Errorf("Expected the hook to abort, got %v", err)
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Error("Expected the hooks after an aborting hook not to run")
+	}
+
+	slow := hooks.NewRunner([]hooks.Hook{{Event: hooks.PreStop, Command: "sleep 5", Timeout: 50 * time.Millisecond, OnFailure: hooks.FailAbort}})
+	start := time.Now()
+	if err := slow.Run(context.Background(), payload); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the hook to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Expected the timeout to end the hook, took %s", elapsed)
+	}
+}
+
+func TestRunner_Webhook(t *testing.T) {
+	var received hooks.Payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+		if received.Event == hooks.PreTerminate {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	runner := hooks.NewRunner([]hooks.Hook{
+		{Event: hooks.PostStop, Webhook: server.URL},
+		{Event: hooks.PreTerminate, Webhook: server.URL, OnFailure: hooks.FailAbort},
+	})
+	instance := &models.Instance{ID: "i-123", Name: "dev", Provider: "aws"}
+	if err := runner.Run(context.Background(), hooks.NewPayload(hooks.PostStop, "cron", instance)); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if received.InstanceID != "i-123" || received.InstanceName != "dev" || received.Reason != "cron" {
+		t.Errorf("Unexpected payload: %+v", received)
+	}
+
+	err := runner.Run(context.Background(), hooks.NewPayload(hooks.PreTerminate, "stopped-too-long", instance))
+	if !errors.Is(err, hooks.ErrAborted) || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Expected the failed webhook to abort, got %v", err)
+	}
+}
+
+func TestHook_Validate(t *testing.T) {
+	valid := []hooks.Hook{
+		{Event: hooks.PreStop, Command: "true", OnFailure: hooks.FailAbort},
+		{Event: hooks.PostStop, Webhook: "http://localhost/hook"},
+		{Event: hooks.PreTerminate, Command: "true", OnFailure: hooks.FailProceed, Timeout: time.Minute},
+	}
+	for _, hook := range valid {
+		if err := hook.Validate(); err != nil {
+			t.Errorf("Validate(%+v) failed: %v", hook, err)
+		}
+	}
+
+	invalid := []hooks.Hook{
+		{Event: "pre-start", Command: "true"},
+		{Event: hooks.PreStop},
+		{Event: hooks.PreStop, Command: "true", Webhook: "http://localhost/hook"},
+		{Event: hooks.PostStop, Command: "true", OnFailure: hooks.FailAbort},
+		{Event: hooks.PreStop, Command: "true", OnFailure: "retry"},
+		{Event: hooks.PreStop, Command: "true", Timeout: -time.Second},
+	}
+	for _, hook := range invalid {
+		if err := hook.Validate(); err == nil {
+			t.Errorf("Expected an error for %+v", hook)
+		}
+	}
+}
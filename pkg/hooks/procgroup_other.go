@@ -0,0 +1,9 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package hooks
+
+import "os/exec"
+
+// killGroupOnCancel leaves cmd to be killed alone when its context ends;
+// process groups are not available on this platform
+func killGroupOnCancel(cmd *exec.Cmd) {}
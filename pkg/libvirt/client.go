@@ -0,0 +1,206 @@
+package libvirt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+
+	golibvirt "github.com/digitalocean/go-libvirt"
+)
+
+// LibvirtAPI is the subset of the libvirt API used by the provider. Domains
+// and storage volumes are addressed by name. go-libvirt's RPC calls cannot be
+// cancelled, so the API takes no context.
+type LibvirtAPI interface {
+	LibVersion() (uint64, error)
+
+	// VolumePath returns the path of a volume in pool
+	VolumePath(pool, volume string) (string, error)
+	// CreateVolume creates a volume in pool from its XML definition
+	CreateVolume(pool, xml string) error
+	// UploadVolume writes data to the start of a volume
+	UploadVolume(pool, volume string, data []byte) error
+	DeleteVolume(pool, volume string) error
+
+	DefineDomain(xml string) error
+	UndefineDomain(name string) error
+	StartDomain(name string) error
+	// ShutdownDomain asks the guest to power off through ACPI
+	ShutdownDomain(name string) error
+	// DestroyDomain powers the domain off immediately
+	DestroyDomain(name string) error
+	DomainState(name string) (golibvirt.DomainState, error)
+	DomainXML(name string) (string, error)
+	// DomainAddresses returns the IPv4 addresses the domain's network leased
+	// to it over DHCP
+	DomainAddresses(name string) ([]string, error)
+	// ListDomains returns the names of every defined domain, running or not
+	ListDomains() ([]string, error)
+}
+
+// rpcClient implements LibvirtAPI over libvirt's RPC protocol
+type rpcClient struct {
+	conn *golibvirt.Libvirt
+}
+
+// newRPCClient connects to the libvirt daemon at uri, e.g. qemu:///system or
+// qemu+ssh://user@host/system
+func newRPCClient(uri string) (*rpcClient, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid libvirt URI %q: %w", uri, err)
+	}
+	conn, err := golibvirt.ConnectToURI(parsed)
+	if err != nil {
+		return nil, err
+	}
+	return &rpcClient{conn: conn}, nil
+}
+
+func (c *rpcClient) LibVersion() (uint64, error) {
+	return c.conn.ConnectGetLibVersion()
+}
+
+func (c *rpcClient) VolumePath(pool, volume string) (string, error) {
+	vol, err := c.lookupVolume(pool, volume)
+	if err != nil {
+		return "", err
+	}
+	return c.conn.StorageVolGetPath(vol)
+}
+
+func (c *rpcClient) CreateVolume(pool, xml string) error {
+	p, err := c.conn.StoragePoolLookupByName(pool)
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.StorageVolCreateXML(p, xml, 0)
+	return err
+}
+
+func (c *rpcClient) UploadVolume(pool, volume string, data []byte) error {
+	vol, err := c.lookupVolume(pool, volume)
+	if err != nil {
+		return err
+	}
+	return c.conn.StorageVolUpload(vol, bytes.NewReader(data), 0, uint64(len(data)), 0)
+}
+
+func (c *rpcClient) DeleteVolume(pool, volume string) error {
+	vol, err := c.lookupVolume(pool, volume)
+	if err != nil {
+		return err
+	}
+	return c.conn.StorageVolDelete(vol, 0)
+}
+
+func (c *rpcClient) DefineDomain(xml string) error {
+	_, err := c.conn.DomainDefineXML(xml)
+	return err
+}
+
+func (c *rpcClient) UndefineDomain(name string) error {
+	dom, err := c.conn.DomainLookupByName(name)
+	if err != nil {
+		return err
+	}
+	return c.conn.DomainUndefineFlags(dom, golibvirt.DomainUndefineNvram)
+}
+
+func (c *rpcClient) StartDomain(name string) error {
+	dom, err := c.conn.DomainLookupByName(name)
+	if err != nil {
+		return err
+	}
+	return c.conn.DomainCreate(dom)
+}
+
+func (c *rpcClient) ShutdownDomain(name string) error {
+	dom, err := c.conn.DomainLookupByName(name)
+	if err != nil {
+		return err
+	}
+	return c.conn.DomainShutdown(dom)
+}
+
+func (c *rpcClient) DestroyDomain(name string) error {
+	dom, err := c.conn.DomainLookupByName(name)
+	if err != nil {
+		return err
+	}
+	return c.conn.DomainDestroy(dom)
+}
+
+func (c *rpcClient) DomainState(name string) (golibvirt.DomainState, error) {
+	dom, err := c.conn.DomainLookupByName(name)
+	if err != nil {
+		return 0, err
+	}
+	state, _, err := c.conn.DomainGetState(dom, 0)
+	return golibvirt.DomainState(state), err
+}
+
+func (c *rpcClient) DomainXML(name string) (string, error) {
+	dom, err := c.conn.DomainLookupByName(name)
+	if err != nil {
+		return "", err
+	}
+	return c.conn.DomainGetXMLDesc(dom, 0)
+}
+
+func (c *rpcClient) DomainAddresses(name string) ([]string, error) {
+	dom, err := c.conn.DomainLookupByName(name)
+	if err != nil {
+		return nil, err
+	}
+	ifaces, err := c.conn.DomainInterfaceAddresses(dom, uint32(golibvirt.DomainInterfaceAddressesSrcLease), 0)
+	if err != nil {
+		return nil, err
+	}
+	var addresses []string
+	for _, iface := range ifaces {
+		for _, addr := range iface.Addrs {
+			if addr.Type == int32(golibvirt.IPAddrTypeIpv4) {
+				addresses = append(addresses, addr.Addr)
+			}
+		}
+	}
+	return addresses, nil
+}
+
+func (c *rpcClient) ListDomains() ([]string, error) {
+	domains, _, err := c.conn.ConnectListAllDomains(1, golibvirt.ConnectListDomainsActive|golibvirt.ConnectListDomainsInactive)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(domains))
+	for _, dom := range domains {
+		names = append(names, dom.Name)
+	}
+	return names, nil
+}
+
+func (c *rpcClient) lookupVolume(pool, volume string) (golibvirt.StorageVol, error) {
+	p, err := c.conn.StoragePoolLookupByName(pool)
+	if err != nil {
+		return golibvirt.StorageVol{}, err
+	}
+	return c.conn.StorageVolLookupByName(p, volume)
+}
+
+// isNotFound reports whether err is libvirt's missing domain or volume error
+func isNotFound(err error) bool {
+	var libvirtErr golibvirt.Error
+	if !errors.As(err, &libvirtErr) {
+		return false
+	}
+	return libvirtErr.Code == uint32(golibvirt.ErrNoDomain) || libvirtErr.Code == uint32(golibvirt.ErrNoStorageVol)
+}
+
+// isNotRunning reports whether err is libvirt refusing to stop a domain that
+// is already shut off
+func isNotRunning(err error) bool {
+	var libvirtErr golibvirt.Error
+	return errors.As(err, &libvirtErr) && libvirtErr.Code == uint32(golibvirt.ErrOperationInvalid)
+}
@@ -0,0 +1,399 @@
+package libvirt
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/tracing"
+
+	golibvirt "github.com/digitalocean/go-libvirt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultURI connects to the local system libvirt daemon
+	DefaultURI = "qemu:///system"
+
+	// DefaultInstanceType is the size preset used when none is given
+	DefaultInstanceType = "small"
+
+	// defaultUsername is the default user of Ubuntu cloud images
+	defaultUsername = "ubuntu"
+
+	// diskSizeGB is the virtual size of each instance's root disk
+	diskSizeGB = 20
+)
+
+// size is the vCPU count and memory of an instance type preset
+type size struct {
+	vcpus     int
+	memoryMiB int
+}
+
+// InstanceTypes lists the size presets offered by the web UI, default first
+var InstanceTypes = []string{DefaultInstanceType, "micro", "medium", "large", "xlarge"}
+
+var sizes = map[string]size{
+	"micro":  {vcpus: 1, memoryMiB: 512},
+	"small":  {vcpus: 1, memoryMiB: 1024},
+	"medium": {vcpus: 2, memoryMiB: 2048},
+	"large":  {vcpus: 4, memoryMiB: 4096},
+	"xlarge": {vcpus: 8, memoryMiB: 8192},
+}
+
+// Settings describes where instances are created on the libvirt host
+type Settings struct {
+	// Pool is the storage pool holding the base image and instance disks
+	Pool string
+	// BaseImage is the name of the cloud image volume in Pool that instance
+	// disks are backed by, e.g. jammy-server-cloudimg-amd64.img
+	BaseImage string
+	// Network is the libvirt network instances are attached to
+	Network string
+	// Username is the login user of the base image
+	Username string
+}
+
+// Provider implements the CloudProvider interface for KVM domains managed by
+// libvirt. Instance IDs are domain names.
+type Provider struct {
+	client   LibvirtAPI
+	uri      string
+	host     string
+	settings Settings
+}
+
+// NewProvider connects to the libvirt daemon at uri and creates a provider
+// for it. An empty uri connects to the local system daemon.
+func NewProvider(uri string, settings Settings) (cloud.CloudProvider, error) {
+	if settings.BaseImage == "" {
+		return nil, errors.New("LIBVIRT_BASE_IMAGE environment variable is required")
+	}
+	if uri == "" {
+		uri = DefaultURI
+	}
+	client, err := newRPCClient(uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to libvirt at %s: %w", uri, err)
+	}
+	return NewProviderWithClient(client, uri, settings), nil
+}
+
+// NewProviderWithClient creates a libvirt provider backed by the given client
+func NewProviderWithClient(client LibvirtAPI, uri string, settings Settings) *Provider {
+	if settings.Pool == "" {
+		settings.Pool = "default"
+	}
+	if settings.Network == "" {
+		settings.Network = "default"
+	}
+	if settings.Username == "" {
+		settings.Username = defaultUsername
+	}
+	return &Provider{client: client, uri: uri, host: uriHost(uri), settings: settings}
+}
+
+// ValidateCredentials checks that the daemon answers and the base image exists
+func (p *Provider) ValidateCredentials(ctx context.Context) error {
+	if _, err := p.client.LibVersion(); err != nil {
+		return fmt.Errorf("cannot reach libvirt at %s: %w", p.uri, err)
+	}
+	if _, err := p.client.VolumePath(p.settings.Pool, p.settings.BaseImage); err != nil {
+		return fmt.Errorf("base image %s not found in pool %s: %w", p.settings.BaseImage, p.settings.Pool, err)
+	}
+	return nil
+}
+
+// CreateInstance creates a copy-on-write disk over the base image and a
+// cloud-init seed image carrying the public key, then defines and boots a
+// domain using both
+func (p *Provider) CreateInstance(ctx context.Context, config models.InstanceConfig) (instance *models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "CreateInstance", "")
+	defer func() {
+		if instance != nil {
+			span.SetAttributes(tracing.AttrInstanceID.String(instance.ID))
+		}
+		tracing.EndSpan(span, err)
+	}()
+
+	if config.KeyName != "" {
+		return nil, errors.New("key pairs are not supported on libvirt; use a public key instead")
+	}
+	if config.SecurityGroupID != "" {
+		return nil, errors.New("security group IDs are not supported on libvirt")
+	}
+
+	instanceType := config.InstanceType
+	if instanceType == "" {
+		instanceType = DefaultInstanceType
+	}
+	preset, ok := sizes[instanceType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported instance type %q for libvirt (use one of %s)", instanceType, strings.Join(InstanceTypes, ", "))
+	}
+
+	publicKey, err := os.ReadFile(config.PublicKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key file: %w", err)
+	}
+
+	basePath, err := p.client.VolumePath(p.settings.Pool, p.settings.BaseImage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find base image %s: %w", p.settings.BaseImage, err)
+	}
+
+	launchTime := time.Now()
+	name := "im-" + strconv.FormatInt(launchTime.UnixNano(), 36)
+	diskVolume, seedVolume := volumeNames(name)
+
+	if err := p.client.CreateVolume(p.settings.Pool, overlayVolumeXML(diskVolume, basePath)); err != nil {
+		return nil, fmt.Errorf("failed to create disk: %w", err)
+	}
+	// Remove what was created so far if a later step fails
+	defer func() {
+		if err != nil {
+			p.cleanup(name)
+		}
+	}()
+
+	seed := buildSeedISO(map[string][]byte{
+		"user-data": userData(name, strings.TrimSpace(string(publicKey))),
+		"meta-data": metaData(name),
+	}, launchTime)
+	if err := p.client.CreateVolume(p.settings.Pool, rawVolumeXML(seedVolume, len(seed))); err != nil {
+		return nil, fmt.Errorf("failed to create cloud-init volume: %w", err)
+	}
+	if err := p.client.UploadVolume(p.settings.Pool, seedVolume, seed); err != nil {
+		return nil, fmt.Errorf("failed to upload cloud-init data: %w", err)
+	}
+
+	domXML, err := p.domainXML(name, preset, &instanceMetadata{
+		Duration:     int64(config.Duration / time.Second),
+		CreatedAt:    launchTime.UTC(),
+		InstanceType: instanceType,
+		Session:      config.Session,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := p.client.DefineDomain(domXML); err != nil {
+		return nil, fmt.Errorf("failed to define domain: %w", err)
+	}
+	if err := p.client.StartDomain(name); err != nil {
+		return nil, fmt.Errorf("failed to start domain: %w", err)
+	}
+
+	instance = &models.Instance{
+		ID:               name,
+		InstanceType:     instanceType,
+		State:            "pending",
+		LaunchTime:       launchTime,
+		Duration:         config.Duration,
+		AvailabilityZone: p.settings.Pool,
+		Region:           p.host,
+		Username:         p.settings.Username,
+		ExpiresAt:        launchTime.Add(config.Duration),
+		Provider:         "libvirt",
+		RestartPolicy:    config.RestartPolicy,
+		Session:          config.Session,
+	}
+
+	return instance, nil
+}
+
+// GetInstanceStatus retrieves the status of a domain. The address comes from
+// the network's DHCP lease, so a domain is only ready once it has booted far
+// enough to request one.
+func (p *Provider) GetInstanceStatus(ctx context.Context, instanceID string) (_ *models.InstanceStatus, err error) {
+	ctx, span := p.startSpan(ctx, "GetInstanceStatus", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	domainState, err := p.client.DomainState(instanceID)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, errors.New("instance not found")
+		}
+		return nil, fmt.Errorf("failed to get domain state: %w", err)
+	}
+
+	state := instanceState(domainState)
+	status := &models.InstanceStatus{
+		ID:       instanceID,
+		State:    state,
+		Username: p.settings.Username,
+	}
+	if state == "running" {
+		addresses, err := p.client.DomainAddresses(instanceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get domain addresses: %w", err)
+		}
+		if len(addresses) > 0 {
+			// Addresses on a libvirt network are both the public and the
+			// private address as far as the host is concerned
+			status.PublicIP = addresses[0]
+			status.PrivateIP = addresses[0]
+		}
+	}
+	status.Ready = state == "running" && status.PublicIP != ""
+	return status, nil
+}
+
+// StartInstance boots a shut-off domain
+func (p *Provider) StartInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StartInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.StartDomain(instanceID); err != nil {
+		return fmt.Errorf("failed to start instance: %w", err)
+	}
+	return nil
+}
+
+// StopInstance asks the guest to shut down through ACPI
+func (p *Provider) StopInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StopInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.ShutdownDomain(instanceID); err != nil {
+		return fmt.Errorf("failed to stop instance: %w", err)
+	}
+	return nil
+}
+
+// TerminateInstance powers a domain off, undefines it and deletes its disk
+// and cloud-init volumes
+func (p *Provider) TerminateInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "TerminateInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.DestroyDomain(instanceID); err != nil && !isNotRunning(err) {
+		return fmt.Errorf("failed to terminate instance: %w", err)
+	}
+	if err := p.client.UndefineDomain(instanceID); err != nil {
+		return fmt.Errorf("failed to terminate instance: %w", err)
+	}
+
+	diskVolume, seedVolume := volumeNames(instanceID)
+	for _, volume := range []string{diskVolume, seedVolume} {
+		if err := p.client.DeleteVolume(p.settings.Pool, volume); err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to delete volume %s: %w", volume, err)
+		}
+	}
+	return nil
+}
+
+// ListInstances lists the domains carrying this tool's metadata
+func (p *Provider) ListInstances(ctx context.Context) (_ []*models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "ListInstances", "")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	names, err := p.client.ListDomains()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instances: %w", err)
+	}
+
+	var instances []*models.Instance
+	for _, name := range names {
+		domXML, err := p.client.DomainXML(name)
+		if err != nil {
+			// The domain may have been undefined since it was listed
+			if isNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get domain %s: %w", name, err)
+		}
+		var dom domain
+		if err := xml.Unmarshal([]byte(domXML), &dom); err != nil {
+			return nil, fmt.Errorf("failed to parse domain %s: %w", name, err)
+		}
+		if dom.Metadata.Instance == nil {
+			continue
+		}
+
+		domainState, err := p.client.DomainState(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get domain state: %w", err)
+		}
+		instances = append(instances, p.toInstance(name, instanceState(domainState), dom.Metadata.Instance))
+	}
+	return instances, nil
+}
+
+// cleanup removes the volumes and domain of a partially created instance
+func (p *Provider) cleanup(name string) {
+	_ = p.client.DestroyDomain(name)
+	_ = p.client.UndefineDomain(name)
+	diskVolume, seedVolume := volumeNames(name)
+	_ = p.client.DeleteVolume(p.settings.Pool, diskVolume)
+	_ = p.client.DeleteVolume(p.settings.Pool, seedVolume)
+}
+
+// startSpan starts a tracing span for a libvirt provider operation
+func (p *Provider) startSpan(ctx context.Context, operation, instanceID string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		tracing.AttrProvider.String("libvirt"),
+		attribute.String("libvirt.uri", p.uri),
+	}
+	if instanceID != "" {
+		attrs = append(attrs, tracing.AttrInstanceID.String(instanceID))
+	}
+	return tracing.StartSpan(ctx, "libvirt."+operation, attrs...)
+}
+
+// toInstance converts a domain to an instance using the metadata recorded
+// when it was created
+func (p *Provider) toInstance(name, state string, meta *instanceMetadata) *models.Instance {
+	duration := time.Duration(meta.Duration) * time.Second
+	return &models.Instance{
+		ID:               name,
+		InstanceType:     meta.InstanceType,
+		State:            state,
+		LaunchTime:       meta.CreatedAt,
+		Duration:         duration,
+		AvailabilityZone: p.settings.Pool,
+		Region:           p.host,
+		Username:         p.settings.Username,
+		ExpiresAt:        meta.CreatedAt.Add(duration),
+		Provider:         "libvirt",
+		Session:          meta.Session,
+	}
+}
+
+// instanceState maps a libvirt domain state to the states used by the
+// scheduler, which follow EC2's naming
+func instanceState(state golibvirt.DomainState) string {
+	switch state {
+	case golibvirt.DomainRunning, golibvirt.DomainBlocked:
+		return "running"
+	case golibvirt.DomainShutdown:
+		return "stopping"
+	case golibvirt.DomainShutoff, golibvirt.DomainCrashed, golibvirt.DomainPaused, golibvirt.DomainPmsuspended:
+		return "stopped"
+	default:
+		return "pending"
+	}
+}
+
+// uriHost returns the host a libvirt URI points at, which instances report
+// as their region
+func uriHost(uri string) string {
+	if parsed, err := url.Parse(uri); err == nil && parsed.Hostname() != "" {
+		return parsed.Hostname()
+	}
+	return "localhost"
+}
+
+// volumeNames returns the names of an instance's disk and cloud-init volumes
+func volumeNames(name string) (disk, seed string) {
+	return name + ".qcow2", name + "-seed.iso"
+}
@@ -0,0 +1,337 @@
+package libvirt_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/libvirt"
+	"instance-manager/pkg/models"
+
+	golibvirt "github.com/digitalocean/go-libvirt"
+)
+
+// MockLibvirt is an in-memory libvirt host with a single storage pool
+type MockLibvirt struct {
+	volumes  map[string][]byte
+	volXML   map[string]string
+	domains  map[string]string
+	states   map[string]golibvirt.DomainState
+	leases   map[string][]string
+	calls    []string
+	failNext string
+}
+
+func NewMockLibvirt() *MockLibvirt {
+	return &MockLibvirt{
+		volumes: map[string][]byte{"jammy.img": nil},
+		volXML:  make(map[string]string),
+		domains: make(map[string]string),
+		states:  make(map[string]golibvirt.DomainState),
+		leases:  make(map[string][]string),
+	}
+}
+
+var (
+	errNoDomain = golibvirt.Error{Code: uint32(golibvirt.ErrNoDomain), Message: "Domain not found"}
+	errNoVolume = golibvirt.Error{Code: uint32(golibvirt.ErrNoStorageVol), Message: "Storage volume not found"}
+	errInvalid  = golibvirt.Error{Code: uint32(golibvirt.ErrOperationInvalid), Message: "domain is not running"}
+)
+
+func (m *MockLibvirt) record(call string) error {
+	m.calls = append(m.calls, call)
+	if m.failNext != "" && strings.HasPrefix(call, m.failNext) {
+		m.failNext = ""
+		return golibvirt.Error{Code: 1, Message: "internal error"}
+	}
+	return nil
+}
+
+func (m *MockLibvirt) LibVersion() (uint64, error) { return 8000000, nil }
+
+func (m *MockLibvirt) VolumePath(pool, volume string) (string, error) {
+	if _, ok := m.volumes[volume]; !ok {
+		return "", errNoVolume
+	}
+	return "/var/lib/libvirt/images/" + volume, nil
+}
+
+func (m *MockLibvirt) CreateVolume(pool, xml string) error {
+	name := xml[strings.Index(xml, "<name>")+6 : strings.Index(xml, "</name>")]
+	if err := m.record("CreateVolume " + name); err != nil {
+		return err
+	}
+	m.volumes[name] = nil
+	m.volXML[name] = xml
+	return nil
+}
+
+func (m *MockLibvirt) UploadVolume(pool, volume string, data []byte) error {
+	if err := m.record("UploadVolume " + volume); err != nil {
+		return err
+	}
+	m.volumes[volume] = data
+	return nil
+}
+
+func (m *MockLibvirt) DeleteVolume(pool, volume string) error {
+	if _, ok := m.volumes[volume]; !ok {
+		return errNoVolume
+	}
+	delete(m.volumes, volume)
+	return m.record("DeleteVolume " + volume)
+}
+
+func (m *MockLibvirt) DefineDomain(xml string) error {
+	name := xml[strings.Index(xml, "<name>")+6 : strings.Index(xml, "</name>")]
+	if err := m.record("DefineDomain " + name); err != nil {
+		return err
+	}
+	m.domains[name] = xml
+	m.states[name] = golibvirt.DomainShutoff
+	return nil
+}
+
+func (m *MockLibvirt) UndefineDomain(name string) error {
+	if _, ok := m.domains[name]; !ok {
+		return errNoDomain
+	}
+	delete(m.domains, name)
+	return m.record("UndefineDomain " + name)
+}
+
+func (m *MockLibvirt) StartDomain(name string) error {
+	if err := m.record("StartDomain " + name); err != nil {
+		return err
+	}
+	m.states[name] = golibvirt.DomainRunning
+	return nil
+}
+
+func (m *MockLibvirt) ShutdownDomain(name string) error {
+	m.states[name] = golibvirt.DomainShutdown
+	return m.record("ShutdownDomain " + name)
+}
+
+func (m *MockLibvirt) DestroyDomain(name string) error {
+	if m.states[name] != golibvirt.DomainRunning {
+		return errInvalid
+	}
+	m.states[name] = golibvirt.DomainShutoff
+	return m.record("DestroyDomain " + name)
+}
+
+func (m *MockLibvirt) DomainState(name string) (golibvirt.DomainState, error) {
+	if _, ok := m.domains[name]; !ok {
+		return 0, errNoDomain
+	}
+	return m.states[name], nil
+}
+
+func (m *MockLibvirt) DomainXML(name string) (string, error) {
+	xml, ok := m.domains[name]
+	if !ok {
+		return "", errNoDomain
+	}
+	return xml, nil
+}
+
+func (m *MockLibvirt) DomainAddresses(name string) ([]string, error) {
+	return m.leases[name], nil
+}
+
+func (m *MockLibvirt) ListDomains() ([]string, error) {
+	var names []string
+	for name := range m.domains {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func newTestProvider(mock *MockLibvirt) *libvirt.Provider {
+	return libvirt.NewProviderWithClient(mock, "qemu+ssh://admin@kvm01.lab/system", libvirt.Settings{BaseImage: "jammy.img"})
+}
+
+func writePublicKey(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "id_rsa.pub")
+	if err := os.WriteFile(path, []byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQAB test@example\n"), 0600); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+	return path
+}
+
+func TestCreateInstance(t *testing.T) {
+	mock := NewMockLibvirt()
+	provider := newTestProvider(mock)
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:  "medium",
+		Duration:      2 * time.Hour,
+		PublicKeyPath: writePublicKey(t),
+		Session:       "exp-42",
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	name := instance.ID
+	if !strings.HasPrefix(name, "im-") || instance.Provider != "libvirt" || instance.Region != "kvm01.lab" || instance.Username != "ubuntu" {
+		t.Errorf("Unexpected instance %+v", instance)
+	}
+
+	want := []string{
+		"CreateVolume " + name + ".qcow2",
+		"CreateVolume " + name + "-seed.iso",
+		"UploadVolume " + name + "-seed.iso",
+		"DefineDomain " + name,
+		"StartDomain " + name,
+	}
+	if strings.Join(mock.calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected calls:\n%s", strings.Join(mock.calls, "\n"))
+	}
+
+	if !strings.Contains(mock.volXML[name+".qcow2"], "<path>/var/lib/libvirt/images/jammy.img</path>") {
+		t.Errorf("Expected the disk to be backed by the base image, got %s", mock.volXML[name+".qcow2"])
+	}
+	seed := mock.volumes[name+"-seed.iso"]
+	if !bytes.Contains(seed, []byte("cidata")) || !bytes.Contains(seed, []byte("USER-DATA;1")) {
+		t.Error("Expected a cidata seed image with user-data")
+	}
+	if !bytes.Contains(seed, []byte(`- "ssh-rsa AAAAB3NzaC1yc2EAAAADAQAB test@example"`)) {
+		t.Error("Expected the public key in the cloud-init user-data")
+	}
+
+	domain := mock.domains[name]
+	for _, fragment := range []string{
+		`<memory unit="MiB">2048</memory>`,
+		`<vcpu>2</vcpu>`,
+		`<source pool="default" volume="` + name + `.qcow2"></source>`,
+		`<source network="default"></source>`,
+		`<instance-type>medium</instance-type>`,
+		`<duration>7200</duration>`,
+		`<session>exp-42</session>`,
+	} {
+		if !strings.Contains(domain, fragment) {
+			t.Errorf("Expected domain XML to contain %s, got\n%s", fragment, domain)
+		}
+	}
+}
+
+func TestCreateInstance_CleansUpOnFailure(t *testing.T) {
+	mock := NewMockLibvirt()
+	mock.failNext = "DefineDomain"
+	provider := newTestProvider(mock)
+
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{Duration: time.Hour, PublicKeyPath: writePublicKey(t)}); err == nil {
+		t.Fatal("Expected CreateInstance to fail")
+	}
+	if len(mock.volumes) != 1 {
+		t.Errorf("Expected only the base image to remain, got %d volumes", len(mock.volumes))
+	}
+
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{InstanceType: "t2.nano", PublicKeyPath: writePublicKey(t)}); err == nil {
+		t.Error("Expected an error for an EC2 instance type")
+	}
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{KeyName: "laptop"}); err == nil {
+		t.Error("Expected an error for a key pair name")
+	}
+}
+
+func TestGetInstanceStatus(t *testing.T) {
+	mock := NewMockLibvirt()
+	provider := newTestProvider(mock)
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{Duration: time.Hour, PublicKeyPath: writePublicKey(t)})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	status, err := provider.GetInstanceStatus(context.Background(), instance.ID)
+	if err != nil {
+		t.Fatalf("GetInstanceStatus failed: %v", err)
+	}
+	if status.State != "running" || status.Ready {
+		t.Errorf("Expected a running instance without a lease to not be ready, got %+v", status)
+	}
+
+	mock.leases[instance.ID] = []string{"192.168.122.57"}
+	status, err = provider.GetInstanceStatus(context.Background(), instance.ID)
+	if err != nil {
+		t.Fatalf("GetInstanceStatus failed: %v", err)
+	}
+	if !status.Ready || status.PublicIP != "192.168.122.57" {
+		t.Errorf("Expected a ready instance with its leased address, got %+v", status)
+	}
+
+	if _, err := provider.GetInstanceStatus(context.Background(), "missing"); err == nil || err.Error() != "instance not found" {
+		t.Errorf("Expected instance not found, got %v", err)
+	}
+}
+
+func TestListInstances(t *testing.T) {
+	mock := NewMockLibvirt()
+	mock.domains["unmanaged"] = `<domain type="kvm"><name>unmanaged</name></domain>`
+	provider := newTestProvider(mock)
+	created, err := provider.CreateInstance(context.Background(), models.InstanceConfig{Duration: time.Hour, PublicKeyPath: writePublicKey(t), Session: "exp-42"})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	if err := provider.StopInstance(context.Background(), created.ID); err != nil {
+		t.Fatalf("StopInstance failed: %v", err)
+	}
+
+	instances, err := provider.ListInstances(context.Background())
+	if err != nil {
+		t.Fatalf("ListInstances failed: %v", err)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("Expected 1 managed instance, got %d", len(instances))
+	}
+
+	instance := instances[0]
+	if instance.ID != created.ID || instance.State != "stopping" || instance.Session != "exp-42" || instance.InstanceType != libvirt.DefaultInstanceType {
+		t.Errorf("Unexpected instance %+v", instance)
+	}
+	if instance.Duration != time.Hour || !instance.ExpiresAt.Equal(created.ExpiresAt) {
+		t.Errorf("Expected expiry %v, got %v", created.ExpiresAt, instance.ExpiresAt)
+	}
+}
+
+func TestTerminateInstance(t *testing.T) {
+	mock := NewMockLibvirt()
+	provider := newTestProvider(mock)
+	running, err := provider.CreateInstance(context.Background(), models.InstanceConfig{Duration: time.Hour, PublicKeyPath: writePublicKey(t)})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	stopped, err := provider.CreateInstance(context.Background(), models.InstanceConfig{Duration: time.Hour, PublicKeyPath: writePublicKey(t)})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	mock.states[stopped.ID] = golibvirt.DomainShutoff
+
+	for _, id := range []string{running.ID, stopped.ID} {
+		if err := provider.TerminateInstance(context.Background(), id); err != nil {
+			t.Fatalf("TerminateInstance(%s) failed: %v", id, err)
+		}
+	}
+	if len(mock.domains) != 0 || len(mock.volumes) != 1 {
+		t.Errorf("Expected domains and instance volumes to be removed, got %d domains and %d volumes", len(mock.domains), len(mock.volumes))
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	mock := NewMockLibvirt()
+	if err := newTestProvider(mock).ValidateCredentials(context.Background()); err != nil {
+		t.Errorf("Expected valid settings, got %v", err)
+	}
+
+	delete(mock.volumes, "jammy.img")
+	if err := newTestProvider(mock).ValidateCredentials(context.Background()); err == nil {
+		t.Error("Expected an error when the base image is missing")
+	}
+}
@@ -0,0 +1,160 @@
+package libvirt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// seedLabel is the volume label cloud-init's NoCloud datasource looks for
+	seedLabel = "cidata"
+
+	sectorSize = 2048
+
+	// Fixed layout of the seed image: the descriptors follow the 16-sector
+	// system area, then the path tables, the root directory and the files
+	pvdSector        = 16
+	lPathTableSector = 18
+	mPathTableSector = 19
+	rootDirSector    = 20
+	firstFileSector  = 21
+)
+
+// userData returns the cloud-config that authorizes publicKey for the image's
+// default user
+func userData(hostname, publicKey string) []byte {
+	return []byte(fmt.Sprintf("#cloud-config\nhostname: %s\nssh_authorized_keys:\n  - %q\n", hostname, publicKey))
+}
+
+// metaData returns the NoCloud meta-data for an instance
+func metaData(instanceID string) []byte {
+	return []byte(fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", instanceID, instanceID))
+}
+
+// buildSeedISO returns an ISO 9660 image labelled cidata holding files in its
+// root directory. Names are written upper-case without Rock Ridge or Joliet
+// extensions; Linux presents them lower-case, which is what cloud-init reads.
+func buildSeedISO(files map[string][]byte, now time.Time) []byte {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Root directory: ".", ".." and one record per file
+	var dir bytes.Buffer
+	dir.Write(dirRecord([]byte{0}, rootDirSector, sectorSize, true, now))
+	dir.Write(dirRecord([]byte{1}, rootDirSector, sectorSize, true, now))
+	sector := uint32(firstFileSector)
+	var data bytes.Buffer
+	for _, name := range names {
+		content := files[name]
+		dir.Write(dirRecord([]byte(strings.ToUpper(name)+";1"), sector, uint32(len(content)), false, now))
+		data.Write(content)
+		data.Write(make([]byte, padding(len(content))))
+		sector += uint32((len(content) + sectorSize - 1) / sectorSize)
+	}
+	totalSectors := sector
+
+	image := make([]byte, firstFileSector*sectorSize, int(totalSectors)*sectorSize)
+	copy(image[pvdSector*sectorSize:], primaryVolumeDescriptor(totalSectors, now))
+
+	terminator := image[(pvdSector+1)*sectorSize:]
+	terminator[0] = 255
+	copy(terminator[1:], "CD001")
+	terminator[6] = 1
+
+	// Each path table holds the root directory only
+	lPathTable := image[lPathTableSector*sectorSize:]
+	lPathTable[0] = 1
+	binary.LittleEndian.PutUint32(lPathTable[2:], rootDirSector)
+	binary.LittleEndian.PutUint16(lPathTable[6:], 1)
+	mPathTable := image[mPathTableSector*sectorSize:]
+	mPathTable[0] = 1
+	binary.BigEndian.PutUint32(mPathTable[2:], rootDirSector)
+	binary.BigEndian.PutUint16(mPathTable[6:], 1)
+
+	copy(image[rootDirSector*sectorSize:], dir.Bytes())
+	return append(image, data.Bytes()...)
+}
+
+// primaryVolumeDescriptor returns the primary volume descriptor sector
+func primaryVolumeDescriptor(totalSectors uint32, now time.Time) []byte {
+	pvd := make([]byte, sectorSize)
+	pvd[0] = 1
+	copy(pvd[1:], "CD001")
+	pvd[6] = 1
+	copy(pvd[8:40], padRight("", 32))
+	copy(pvd[40:72], padRight(seedLabel, 32))
+	putBothEndian32(pvd[80:], totalSectors)
+	putBothEndian16(pvd[120:], 1)
+	putBothEndian16(pvd[124:], 1)
+	putBothEndian16(pvd[128:], sectorSize)
+	putBothEndian32(pvd[132:], 10)
+	binary.LittleEndian.PutUint32(pvd[140:], lPathTableSector)
+	binary.BigEndian.PutUint32(pvd[148:], mPathTableSector)
+	copy(pvd[156:190], dirRecord([]byte{0}, rootDirSector, sectorSize, true, now))
+	copy(pvd[190:702], padRight("", 512))
+	copy(pvd[702:813], padRight("", 111))
+
+	stamp := now.UTC().Format("20060102150405") + "00"
+	copy(pvd[813:], stamp)
+	copy(pvd[830:], stamp)
+	copy(pvd[847:], "0000000000000000")
+	copy(pvd[864:], "0000000000000000")
+	pvd[881] = 1
+	return pvd
+}
+
+// dirRecord returns a directory record for a file or directory
+func dirRecord(id []byte, sector, size uint32, isDir bool, now time.Time) []byte {
+	length := 33 + len(id)
+	if length%2 == 1 {
+		length++
+	}
+	record := make([]byte, length)
+	record[0] = byte(length)
+	putBothEndian32(record[2:], sector)
+	putBothEndian32(record[10:], size)
+
+	now = now.UTC()
+	record[18] = byte(now.Year() - 1900)
+	record[19] = byte(now.Month())
+	record[20] = byte(now.Day())
+	record[21] = byte(now.Hour())
+	record[22] = byte(now.Minute())
+	record[23] = byte(now.Second())
+	if isDir {
+		record[25] = 2
+	}
+	putBothEndian16(record[28:], 1)
+	record[32] = byte(len(id))
+	copy(record[33:], id)
+	return record
+}
+
+func putBothEndian16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func putBothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+func padRight(s string, n int) string {
+	return s + strings.Repeat(" ", n-len(s))
+}
+
+// padding returns the number of bytes needed to fill the last sector of n bytes
+func padding(n int) int {
+	if rem := n % sectorSize; rem != 0 {
+		return sectorSize - rem
+	}
+	return 0
+}
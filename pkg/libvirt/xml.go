@@ -0,0 +1,172 @@
+package libvirt
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// instanceMetadata is stored in the domain's <metadata>, under this tool's
+// namespace, so instances can be listed and their expiry recovered without
+// local state
+type instanceMetadata struct {
+	XMLName      xml.Name  `xml:"https://github.com/bdanp/instance-manager/libvirt instance"`
+	Duration     int64     `xml:"duration"` // seconds
+	CreatedAt    time.Time `xml:"created-at"`
+	InstanceType string    `xml:"instance-type"`
+	Session      string    `xml:"session,omitempty"`
+}
+
+// domain is the subset of the libvirt domain XML written and read by the
+// provider
+type domain struct {
+	XMLName  xml.Name `xml:"domain"`
+	Type     string   `xml:"type,attr"`
+	Name     string   `xml:"name"`
+	Metadata struct {
+		Instance *instanceMetadata
+	} `xml:"metadata"`
+	Memory   memory    `xml:"memory"`
+	VCPU     int       `xml:"vcpu"`
+	OS       domainOS  `xml:"os"`
+	Features *features `xml:"features"`
+	CPU      *cpu      `xml:"cpu"`
+	Devices  *devices  `xml:"devices"`
+}
+
+type memory struct {
+	Unit  string `xml:"unit,attr"`
+	Value int    `xml:",chardata"`
+}
+
+type domainOS struct {
+	Type string `xml:"type"`
+}
+
+type features struct {
+	ACPI struct{} `xml:"acpi"`
+	APIC struct{} `xml:"apic"`
+}
+
+type cpu struct {
+	Mode string `xml:"mode,attr"`
+}
+
+type devices struct {
+	Disks      []disk      `xml:"disk"`
+	Interfaces []iface     `xml:"interface"`
+	Serial     typedDevice `xml:"serial"`
+	Console    typedDevice `xml:"console"`
+}
+
+type disk struct {
+	Type     string    `xml:"type,attr"`
+	Device   string    `xml:"device,attr"`
+	Driver   driver    `xml:"driver"`
+	Source   volumeRef `xml:"source"`
+	Target   target    `xml:"target"`
+	ReadOnly *struct{} `xml:"readonly"`
+}
+
+type driver struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type volumeRef struct {
+	Pool   string `xml:"pool,attr"`
+	Volume string `xml:"volume,attr"`
+}
+
+type target struct {
+	Dev string `xml:"dev,attr"`
+	Bus string `xml:"bus,attr"`
+}
+
+type iface struct {
+	Type   string `xml:"type,attr"`
+	Source struct {
+		Network string `xml:"network,attr"`
+	} `xml:"source"`
+	Model typedDevice `xml:"model"`
+}
+
+// typedDevice is an element with only a type attribute
+type typedDevice struct {
+	Type string `xml:"type,attr"`
+}
+
+// domainXML returns the definition of a KVM domain booting from the
+// instance's disk with its cloud-init seed attached as a CD-ROM
+func (p *Provider) domainXML(name string, preset size, meta *instanceMetadata) (string, error) {
+	diskVolume, seedVolume := volumeNames(name)
+	network := iface{Type: "network", Model: typedDevice{Type: "virtio"}}
+	network.Source.Network = p.settings.Network
+
+	dom := domain{
+		Type:   "kvm",
+		Name:   name,
+		Memory: memory{Unit: "MiB", Value: preset.memoryMiB},
+		VCPU:   preset.vcpus,
+		OS:     domainOS{Type: "hvm"},
+		// ACPI lets StopInstance shut the guest down cleanly
+		Features: &features{},
+		CPU:      &cpu{Mode: "host-passthrough"},
+		Devices: &devices{
+			Disks: []disk{
+				{
+					Type:   "volume",
+					Device: "disk",
+					Driver: driver{Name: "qemu", Type: "qcow2"},
+					Source: volumeRef{Pool: p.settings.Pool, Volume: diskVolume},
+					Target: target{Dev: "vda", Bus: "virtio"},
+				},
+				{
+					Type:     "volume",
+					Device:   "cdrom",
+					Driver:   driver{Name: "qemu", Type: "raw"},
+					Source:   volumeRef{Pool: p.settings.Pool, Volume: seedVolume},
+					Target:   target{Dev: "sda", Bus: "sata"},
+					ReadOnly: &struct{}{},
+				},
+			},
+			Interfaces: []iface{network},
+			Serial:     typedDevice{Type: "pty"},
+			Console:    typedDevice{Type: "pty"},
+		},
+	}
+	dom.Metadata.Instance = meta
+
+	data, err := xml.MarshalIndent(dom, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode domain XML: %w", err)
+	}
+	return string(data), nil
+}
+
+// overlayVolumeXML defines a qcow2 volume backed by the base image, so each
+// instance only stores the blocks it changes
+func overlayVolumeXML(name, basePath string) string {
+	return fmt.Sprintf(`<volume>
+  <name>%s</name>
+  <capacity unit="G">%d</capacity>
+  <target><format type="qcow2"/></target>
+  <backingStore><path>%s</path><format type="qcow2"/></backingStore>
+</volume>`, escapeXML(name), diskSizeGB, escapeXML(basePath))
+}
+
+// rawVolumeXML defines a raw volume of the given size in bytes
+func rawVolumeXML(name string, size int) string {
+	return fmt.Sprintf(`<volume>
+  <name>%s</name>
+  <capacity unit="bytes">%d</capacity>
+  <target><format type="raw"/></target>
+</volume>`, escapeXML(name), size)
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,35 @@
+package logging
+
+import (
+	"context"
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+// loggerKey is the context key of the logger of a request
+type loggerKey struct{}
+
+// discard is the logger of contexts that carry none, so callers such as CLI
+// commands that log nothing themselves stay quiet
+var discard = &logrus.Logger{
+	Out:       io.Discard,
+	Formatter: new(logrus.TextFormatter),
+	Hooks:     make(logrus.LevelHooks),
+	Level:     logrus.PanicLevel,
+}
+
+// WithLogger returns a copy of ctx carrying logger, whose fields, such as
+// the ID of the request being served, mark the entries logged under ctx
+func WithLogger(ctx context.Context, logger *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger ctx carries, or one that discards its
+// entries
+func FromContext(ctx context.Context) *logrus.Entry {
+	if logger, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+		return logger
+	}
+	return logrus.NewEntry(discard)
+}
@@ -0,0 +1,55 @@
+package metrics_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"instance-manager/pkg/metrics"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := metrics.NewRegistry()
+	requests := registry.Counter("http_requests_total", "HTTP requests served", "method", "status")
+	latency := registry.Histogram("http_request_duration_seconds", "Time to serve HTTP requests", []float64{0.1, 1}, "method")
+	registry.GaugeFunc("instances", "Instances by state", func() ([]metrics.Sample, error) {
+		return []metrics.Sample{{Labels: []string{"running"}, Value: 2}, {Labels: []string{`odd "state"`}, Value: 1}}, nil
+	}, "state")
+	registry.GaugeFunc("broken", "A gauge that fails", func() ([]metrics.Sample, error) {
+		return nil, errors.New("storage is down")
+	})
+
+	requests.Inc("GET", "200")
+	requests.Inc("GET", "200")
+	requests.Add(3, "POST", "500")
+	latency.Observe(0.05, "GET")
+	latency.Observe(0.5, "GET")
+	latency.Observe(7, "GET")
+	if got := requests.Value("GET", "200"); got != 2 {
+		t.Errorf("Expected 2 GET requests, got %g", got)
+	}
+
+	var out strings.Builder
+	if err := registry.Write(&out); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	want := `# HELP http_requests_total HTTP requests served
+# TYPE http_requests_total counter
+http_requests_total{method="GET",status="200"} 2
+http_requests_total{method="POST",status="500"} 3
+# HELP http_request_duration_seconds Time to serve HTTP requests
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{method="GET",le="0.1"} 1
+http_request_duration_seconds_bucket{method="GET",le="1"} 2
+http_request_duration_seconds_bucket{method="GET",le="+Inf"} 3
+http_request_duration_seconds_sum{method="GET"} 7.55
+http_request_duration_seconds_count{method="GET"} 3
+# HELP instances Instances by state
+# TYPE instances gauge
+instances{state="odd \"state\""} 1
+instances{state="running"} 2
+`
+	if out.String() != want {
+		t.Errorf("Unexpected exposition:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
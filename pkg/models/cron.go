@@ -0,0 +1,186 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMacros are the predefined schedules accepted in place of five fields
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronMonthNames and cronDayNames are the names accepted in the month and
+// day-of-week fields
+var (
+	cronMonthNames = map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}
+	cronDayNames = map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}
+)
+
+// CronSchedule is a parsed five-field cron expression
+// ("minute hour day-of-month month day-of-week")
+type CronSchedule struct {
+	minute   [60]bool
+	hour     [24]bool
+	dom      [32]bool
+	month    [13]bool
+	dow      [7]bool
+	anyDom   bool // The day-of-month field is "*", so only the day of week restricts days
+	anyDow   bool // The day-of-week field is "*", so only the day of month restricts days
+	location *time.Location
+}
+
+// ParseCron parses a standard five-field cron expression such as
+// "0 8 * * 1-5" or a macro such as "@daily". Fields accept *, lists, ranges,
+// steps and three-letter month and day names. A leading "CRON_TZ=<zone>"
+// evaluates the expression in that IANA time zone instead of UTC.
+func ParseCron(expr string) (*CronSchedule, error) {
+	schedule := &CronSchedule{location: time.UTC}
+
+	spec := strings.TrimSpace(expr)
+	if zone, ok := strings.CutPrefix(spec, "CRON_TZ="); ok {
+		name, rest, _ := strings.Cut(zone, " ")
+		location, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: unknown time zone %s", expr, name)
+		}
+		schedule.location = location
+		spec = strings.TrimSpace(rest)
+	}
+	if macro, ok := cronMacros[spec]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields (minute hour day-of-month month day-of-week)", expr)
+	}
+
+	parsers := []struct {
+		name     string
+		min, max int
+		names    map[string]int
+		set      func(int)
+	}{
+		{"minute", 0, 59, nil, func(v int) { schedule.minute[v] = true }},
+		{"hour", 0, 23, nil, func(v int) { schedule.hour[v] = true }},
+		{"day-of-month", 1, 31, nil, func(v int) { schedule.dom[v] = true }},
+		{"month", 1, 12, cronMonthNames, func(v int) { schedule.month[v] = true }},
+		// 7 is accepted as Sunday
+		{"day-of-week", 0, 7, cronDayNames, func(v int) { schedule.dow[v%7] = true }},
+	}
+	for i, parser := range parsers {
+		if err := parseCronField(fields[i], parser.min, parser.max, parser.names, parser.set); err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %s field: %w", expr, parser.name, err)
+		}
+	}
+	schedule.anyDom = strings.HasPrefix(fields[2], "*")
+	schedule.anyDow = strings.HasPrefix(fields[4], "*")
+	return schedule, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps
+// and calls set for every value it matches
+func parseCronField(field string, min, max int, names map[string]int, set func(int)) error {
+	for _, part := range strings.Split(field, ",") {
+		valueRange, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+
+		first, last := min, max
+		if valueRange != "*" {
+			firstText, lastText, isRange := strings.Cut(valueRange, "-")
+			var err error
+			if first, err = parseCronValue(firstText, min, max, names); err != nil {
+				return err
+			}
+			last = first
+			if isRange {
+				if last, err = parseCronValue(lastText, min, max, names); err != nil {
+					return err
+				}
+			} else if hasStep {
+				last = max
+			}
+			if last < first {
+				return fmt.Errorf("invalid range %q", valueRange)
+			}
+		}
+
+		for value := first; value <= last; value += step {
+			set(value)
+		}
+	}
+	return nil
+}
+
+// parseCronValue parses a single number or name within [min, max]
+func parseCronValue(text string, min, max int, names map[string]int) (int, error) {
+	if value, ok := names[strings.ToLower(text)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", text)
+	}
+	if value < min || value > max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", value, min, max)
+	}
+	return value, nil
+}
+
+// Next returns the first time after t at which the schedule fires, or the
+// zero time if it never fires within five years
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	next := t.In(c.location).Truncate(time.Minute).Add(time.Minute)
+	limit := next.AddDate(5, 0, 0)
+	for next.Before(limit) {
+		switch {
+		case !c.month[next.Month()]:
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, c.location)
+		case !c.dayMatches(next):
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, c.location)
+		case !c.hour[next.Hour()]:
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, c.location)
+		case !c.minute[next.Minute()]:
+			next = next.Add(time.Minute)
+		default:
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches applies the cron rule that a day matches either restricted day
+// field when both are restricted
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[t.Weekday()]
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	default:
+		return dom || dow
+	}
+}
@@ -0,0 +1,48 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"instance-manager/pkg/models"
+)
+
+func TestCronSchedule_Next(t *testing.T) {
+	monday := time.Date(2024, 3, 4, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr     string
+		after    time.Time
+		expected time.Time
+	}{
+		{"0 20 * * *", monday, time.Date(2024, 3, 4, 20, 0, 0, 0, time.UTC)},
+		{"0 8 * * 1-5", monday, time.Date(2024, 3, 5, 8, 0, 0, 0, time.UTC)},
+		{"0 8 * * mon-fri", time.Date(2024, 3, 8, 9, 0, 0, 0, time.UTC), time.Date(2024, 3, 11, 8, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", monday, time.Date(2024, 3, 4, 12, 45, 0, 0, time.UTC)},
+		{"30 12 * * *", monday, time.Date(2024, 3, 5, 12, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", monday, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9 29 feb *", monday, time.Date(2028, 2, 29, 9, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", monday, time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC)}, // Friday or the 13th
+		{"0 0 * * 7", monday, time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)},
+		{"@daily", monday, time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=Europe/Berlin 0 20 * * *", monday, time.Date(2024, 3, 4, 19, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		schedule, err := models.ParseCron(test.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q) failed: %v", test.expr, err)
+			continue
+		}
+		if got := schedule.Next(test.after); !got.Equal(test.expected) {
+			t.Errorf("Next(%q) = %s, expected %s", test.expr, got, test.expected)
+		}
+	}
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "0 20 * *", "60 * * * *", "0 24 * * *", "0 0 0 * *", "0 0 * 13 *", "0 0 * * 8", "*/0 * * * *", "5-1 * * * *", "CRON_TZ=Nowhere/City 0 0 * * *", "@sometimes"} {
+		if _, err := models.ParseCron(expr); err == nil {
+			t.Errorf("Expected an error for %q", expr)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// InstanceFilter selects instances by their state, placement, owner, tags
+// and expiry. Empty fields match every instance, so the zero filter matches
+// them all.
+type InstanceFilter struct {
+	States        []string          // Only instances in one of these states
+	ExcludeStates []string          // Leave out instances in these states
+	Provider      string            // Cloud provider; records without one are aws
+	Region        string            // Provider region
+	Owner         string            // User who created the instance
+	Tags          map[string]string // Tags the instance must all carry
+	ExpiresAfter  time.Time         // Only instances expiring after this time
+	ExpiresBefore time.Time         // Only instances expiring before this time
+}
+
+// Matches reports whether the instance passes every predicate of the filter
+func (f InstanceFilter) Matches(i *Instance) bool {
+	if len(f.States) > 0 && !slices.Contains(f.States, i.State) {
+		return false
+	}
+	if slices.Contains(f.ExcludeStates, i.State) {
+		return false
+	}
+	if f.Provider != "" {
+		provider := i.Provider
+		if provider == "" {
+			provider = "aws" // Recorded before instances carried their provider
+		}
+		if provider != f.Provider {
+			return false
+		}
+	}
+	if f.Region != "" && i.Region != f.Region {
+		return false
+	}
+	if f.Owner != "" && i.Owner != f.Owner {
+		return false
+	}
+	for key, value := range f.Tags {
+		if v, ok := i.Tags[key]; !ok || v != value {
+			return false
+		}
+	}
+	if !f.ExpiresAfter.IsZero() && !i.ExpiresAt.After(f.ExpiresAfter) {
+		return false
+	}
+	if !f.ExpiresBefore.IsZero() && !i.ExpiresAt.Before(f.ExpiresBefore) {
+		return false
+	}
+	return true
+}
+
+// Filter returns the instances the filter matches, in their original order
+func (f InstanceFilter) Filter(instances []*Instance) []*Instance {
+	var filtered []*Instance
+	for _, instance := range instances {
+		if f.Matches(instance) {
+			filtered = append(filtered, instance)
+		}
+	}
+	return filtered
+}
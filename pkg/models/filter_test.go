@@ -0,0 +1,50 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"instance-manager/pkg/models"
+)
+
+func TestInstanceFilter_Matches(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	instance := &models.Instance{
+		ID:        "i-1",
+		State:     "running",
+		Region:    "eu-west-1",
+		Owner:     "alice",
+		Tags:      map[string]string{"team": "qa", "env": "dev"},
+		ExpiresAt: now.Add(time.Hour),
+	}
+
+	tests := []struct {
+		name     string
+		filter   models.InstanceFilter
+		expected bool
+	}{
+		{"zero filter", models.InstanceFilter{}, true},
+		{"state", models.InstanceFilter{States: []string{"stopped", "running"}}, true},
+		{"other state", models.InstanceFilter{States: []string{"stopped"}}, false},
+		{"excluded state", models.InstanceFilter{ExcludeStates: []string{"running"}}, false},
+		{"provider of a record without one", models.InstanceFilter{Provider: "aws"}, true},
+		{"other provider", models.InstanceFilter{Provider: "gcp"}, false},
+		{"region", models.InstanceFilter{Region: "eu-west-1"}, true},
+		{"other region", models.InstanceFilter{Region: "us-east-1"}, false},
+		{"owner", models.InstanceFilter{Owner: "alice"}, true},
+		{"other owner", models.InstanceFilter{Owner: "bob"}, false},
+		{"tags", models.InstanceFilter{Tags: map[string]string{"team": "qa"}}, true},
+		{"tag with another value", models.InstanceFilter{Tags: map[string]string{"team": "ops"}}, false},
+		{"missing tag", models.InstanceFilter{Tags: map[string]string{"team": "qa", "cost": "x"}}, false},
+		{"expiry window", models.InstanceFilter{ExpiresAfter: now, ExpiresBefore: now.Add(2 * time.Hour)}, true},
+		{"expires too late", models.InstanceFilter{ExpiresBefore: now.Add(30 * time.Minute)}, false},
+		{"expires too early", models.InstanceFilter{ExpiresAfter: now.Add(2 * time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Matches(instance); got != tt.expected {
+				t.Errorf("Matches() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -2,6 +2,9 @@ package models
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
 )
 
@@ -10,13 +13,148 @@ type InstanceConfig struct {
 	InstanceType     string
 	Duration         time.Duration
 	PublicKeyPath    string
+	KeyName          string // Existing key pair to use instead of importing PublicKeyPath
 	AvailabilityZone string
 	Region           string
+	OpenPorts        []int64  // Inbound TCP ports to open; defaults to SSH (22)
+	SecurityGroupID  string   // Existing security group to use instead of the managed one
+	SSHCIDRs         []string // CIDR blocks allowed to reach SSH; defaults to anywhere
+	RestartPolicy    string   // One of the RestartPolicy constants; empty means on-extend
+	Session          string   // Groups related instances so they can be listed and torn down together
+	Spot             bool     // Launch as a spot instance
+	SpotMaxPrice     string   // Maximum hourly spot price in USD; empty caps it at the on-demand price
+	OS               string   // Operating system from the provider's image catalog; empty uses its default
+	ExtraVolumes     []VolumeSpec
+	VPCID            string            // VPC to launch into; empty uses the default subnet of AvailabilityZone
+	SubnetID         string            // Subnet to launch into; must belong to VPCID when both are set
+	ElasticIP        bool              // Associate a newly allocated Elastic IP, released on terminate
+	Tags             map[string]string // User-defined tags added to the managed ones
+	Name             string            // Human-readable name; also the Name tag on AWS
+	SnapshotOnExpiry bool              // Snapshot the volumes before the scheduler stops the expired instance
+	ImageOnExpiry    bool              // Create an AMI before the scheduler stops the expired instance
+	ImageID          string            // AMI to launch instead of the latest image of OS
+	ExpiryAction     string            // One of the ExpiryAction constants; empty means stop
+	Metadata         MetadataOptions   // Instance metadata service settings
+
+	// ExtraSecurityGroupIDs are existing groups attached alongside the managed
+	// or requested one
+	ExtraSecurityGroupIDs []string
+}
+
+// Instance metadata service (IMDS) settings
+const (
+	// MetadataTokensRequired only serves IMDSv2 session-token requests
+	MetadataTokensRequired = "required"
+	// MetadataTokensOptional also serves IMDSv1 requests
+	MetadataTokensOptional = "optional"
+	// MetadataEndpointEnabled serves the metadata endpoint
+	MetadataEndpointEnabled = "enabled"
+	// MetadataEndpointDisabled turns the metadata endpoint off
+	MetadataEndpointDisabled = "disabled"
+	// MaxMetadataHopLimit is the largest PUT response hop limit EC2 accepts
+	MaxMetadataHopLimit = 64
+)
+
+// MetadataOptions configures the instance metadata service. Empty fields use
+// the provider's defaults: IMDSv2 required, the endpoint enabled and the
+// image's hop limit.
+type MetadataOptions struct {
+	HTTPTokens string // MetadataTokensRequired or MetadataTokensOptional
+	HopLimit   int32  // PUT response hop limit; 2 lets containers reach IMDSv2
+	Endpoint   string // MetadataEndpointEnabled or MetadataEndpointDisabled
+}
+
+// Validate checks that the metadata options hold supported values
+func (o MetadataOptions) Validate() error {
+	switch o.HTTPTokens {
+	case "", MetadataTokensRequired, MetadataTokensOptional:
+	default:
+		return fmt.Errorf("invalid metadata http tokens %q (must be %s or %s)", o.HTTPTokens, MetadataTokensRequired, MetadataTokensOptional)
+	}
+	if o.HopLimit < 0 || o.HopLimit > MaxMetadataHopLimit {
+		return fmt.Errorf("invalid metadata hop limit %d (must be 1-%d)", o.HopLimit, MaxMetadataHopLimit)
+	}
+	switch o.Endpoint {
+	case "", MetadataEndpointEnabled, MetadataEndpointDisabled:
+	default:
+		return fmt.Errorf("invalid metadata endpoint %q (must be %s or %s)", o.Endpoint, MetadataEndpointEnabled, MetadataEndpointDisabled)
+	}
+	return nil
+}
+
+// VolumeSpec describes a data volume attached to an instance at launch
+type VolumeSpec struct {
+	SizeGiB int32
+	Type    string // Volume type, e.g. gp3
+	Device  string // Device name, e.g. /dev/xvdf
+}
+
+// DefaultVolumeType is used for extra volumes that do not name a type
+const DefaultVolumeType = "gp3"
+
+// firstDataDevice is the device name given to the first extra volume that
+// does not name one; later volumes take the following letters
+const firstDataDevice = "/dev/sdf"
+
+// ParseVolumeSpecs parses extra volume specs of the form
+// "size=100,type=gp3,device=/dev/xvdf". The size in GiB is required; the type
+// defaults to DefaultVolumeType and volumes without a device are given the
+// next free one from /dev/sdf on.
+func ParseVolumeSpecs(specs []string) ([]VolumeSpec, error) {
+	volumes := make([]VolumeSpec, 0, len(specs))
+	used := make(map[string]bool)
+	for _, spec := range specs {
+		volume := VolumeSpec{Type: DefaultVolumeType}
+		for _, field := range strings.Split(spec, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+			if !ok || value == "" {
+				return nil, fmt.Errorf("invalid volume field %q in %q (expected key=value)", field, spec)
+			}
+			switch key {
+			case "size":
+				size, err := strconv.ParseInt(value, 10, 32)
+				if err != nil || size <= 0 {
+					return nil, fmt.Errorf("invalid volume size %q in %q (expected GiB)", value, spec)
+				}
+				volume.SizeGiB = int32(size)
+			case "type":
+				volume.Type = value
+			case "device":
+				volume.Device = value
+			default:
+				return nil, fmt.Errorf("unknown volume field %q in %q (expected size, type or device)", key, spec)
+			}
+		}
+		if volume.SizeGiB == 0 {
+			return nil, fmt.Errorf("volume %q needs a size", spec)
+		}
+		if volume.Device != "" {
+			if used[volume.Device] {
+				return nil, fmt.Errorf("device %s is used by more than one volume", volume.Device)
+			}
+			used[volume.Device] = true
+		}
+		volumes = append(volumes, volume)
+	}
+
+	next := firstDataDevice
+	for i := range volumes {
+		if volumes[i].Device != "" {
+			continue
+		}
+		for used[next] {
+			next = next[:len(next)-1] + string(next[len(next)-1]+1)
+		}
+		volumes[i].Device = next
+		used[next] = true
+	}
+	return volumes, nil
 }
 
 // Instance represents a cloud instance
 type Instance struct {
 	ID               string        `json:"id"`
+	Name             string        `json:"name,omitempty"`
 	InstanceType     string        `json:"instance_type"`
 	Provider         string        `json:"provider"` // Add provider field
 	PublicIP         string        `json:"public_ip,omitempty"`
@@ -25,9 +163,132 @@ type Instance struct {
 	LaunchTime       time.Time     `json:"launch_time"`
 	Duration         time.Duration `json:"duration"`
 	AvailabilityZone string        `json:"availability_zone"`
+	Region           string        `json:"region,omitempty"`
+	Account          string        `json:"account,omitempty"` // Named provider account; empty is the default account
+	VPCID            string        `json:"vpc_id,omitempty"`
+	SubnetID         string        `json:"subnet_id,omitempty"`
 	KeyName          string        `json:"key_name"`
+	OS               string        `json:"os,omitempty"`
 	Username         string        `json:"username"`
 	ExpiresAt        time.Time     `json:"expires_at"`
+	ReadyAt          time.Time     `json:"ready_at"`
+	StoppedAt        time.Time     `json:"stopped_at,omitempty"`    // When the instance was first seen stopped; cleared when it runs again
+	TerminatedAt     time.Time     `json:"terminated_at,omitempty"` // When the instance was terminated or first seen terminated
+	StopReason       string        `json:"stop_reason,omitempty"`   // Why the scheduler stopped an unexpired instance
+	RestartPolicy    string        `json:"restart_policy,omitempty"`
+	Session          string        `json:"session,omitempty"`
+	Owner            string        `json:"owner,omitempty"`         // User who created the instance
+	SSHPort          int           `json:"ssh_port,omitempty"`      // Set when SSH listens on a port other than 22
+	RestartCount     int           `json:"restart_count,omitempty"` // Restarts performed by the scheduler
+	Unhealthy        bool          `json:"unhealthy,omitempty"`     // Set when the scheduler gave up restarting the instance
+	SpotRequestID    string        `json:"spot_request_id,omitempty"`
+	VolumeIDs        []string      `json:"volume_ids,omitempty"` // Data volumes attached besides the root volume
+
+	// ElasticIPAllocationID is the Elastic IP kept across stop/start cycles
+	ElasticIPAllocationID string `json:"elastic_ip_allocation_id,omitempty"`
+
+	Tags map[string]string `json:"tags,omitempty"` // User-defined tags
+
+	// GPUCount and GPUModel describe the GPUs of GPU instance types
+	GPUCount int    `json:"gpu_count,omitempty"`
+	GPUModel string `json:"gpu_model,omitempty"`
+
+	// SnapshotOnExpiry makes the scheduler snapshot the volumes before it
+	// stops the instance at expiry
+	SnapshotOnExpiry bool `json:"snapshot_on_expiry,omitempty"`
+	// ImageOnExpiry makes the scheduler create an AMI of the instance before
+	// it stops the instance at expiry
+	ImageOnExpiry bool `json:"image_on_expiry,omitempty"`
+	// ExpiryAction is how the scheduler stops the instance at expiry
+	ExpiryAction string `json:"expiry_action,omitempty"`
+	// KeepWhenIdle exempts the instance from the scheduler's idle policy
+	KeepWhenIdle bool `json:"keep_when_idle,omitempty"`
+	// OfficeHours is the weekly window the instance runs in, as accepted by
+	// ParseOfficeHours; the scheduler stops it outside the window
+	OfficeHours string `json:"office_hours,omitempty"`
+	// StopCron and StartCron are cron expressions, as accepted by ParseCron,
+	// at which the scheduler stops and starts the instance within its TTL
+	StopCron  string `json:"stop_cron,omitempty"`
+	StartCron string `json:"start_cron,omitempty"`
+	// WarnedExpiresAt and WarnedLeadTime record the last expiry warning, so
+	// each lead time is warned about once per expiry time. A zero lead time
+	// marks the warning sent when the grace period started.
+	WarnedExpiresAt time.Time     `json:"warned_expires_at,omitempty"`
+	WarnedLeadTime  time.Duration `json:"warned_lead_time,omitempty"`
+	// SnapshottedExpiresAt records the expiry the volumes were snapshotted
+	// for, so a stop that fails and is retried does not snapshot them again
+	SnapshottedExpiresAt time.Time `json:"snapshotted_expires_at,omitempty"`
+	// ImagedExpiresAt likewise records the expiry an image was created for
+	ImagedExpiresAt time.Time `json:"imaged_expires_at,omitempty"`
+	// History is the lifecycle of the instance, oldest event first
+	History []HistoryEvent `json:"history,omitempty"`
+
+	// Revision is the version of the stored record the instance was read
+	// from. Backends with optimistic locking refuse to update a record that
+	// changed since; zero updates it regardless.
+	Revision int64 `json:"-"`
+}
+
+// StopReasonBudget marks an instance stopped to keep projected spend under the daily budget
+const StopReasonBudget = "budget"
+
+// StopReasonSpotInterruption marks a spot instance the cloud provider reclaimed
+const StopReasonSpotInterruption = "spot-interruption"
+
+// StopReasonOfficeHours marks an instance stopped outside its office hours
+const StopReasonOfficeHours = "office-hours"
+
+// StopReasonCron marks an instance stopped by its stop schedule
+const StopReasonCron = "cron"
+
+// StopReasonIdle marks an instance stopped by the idle policy before it expired
+const StopReasonIdle = "idle"
+
+// Restart policies control whether the scheduler restarts a stopped instance
+// whose TTL has not expired
+const (
+	// RestartPolicyAlways restarts the instance whenever it is found stopped.
+	// Such instances are also exempt from the daily budget.
+	RestartPolicyAlways = "always"
+	// RestartPolicyNever leaves a stopped instance stopped
+	RestartPolicyNever = "never"
+	// RestartPolicyOnExtend restarts the instance unless the scheduler stopped
+	// it to meet the daily budget, in which case it waits for a TTL extension
+	RestartPolicyOnExtend = "on-extend"
+)
+
+// ValidateRestartPolicy checks that the restart policy is one of the supported values
+func ValidateRestartPolicy(policy string) error {
+	switch policy {
+	case RestartPolicyAlways, RestartPolicyNever, RestartPolicyOnExtend:
+		return nil
+	default:
+		return fmt.Errorf("invalid restart policy %q (must be %s, %s or %s)",
+			policy, RestartPolicyAlways, RestartPolicyNever, RestartPolicyOnExtend)
+	}
+}
+
+// Expiry actions control how the scheduler stops an expired instance
+const (
+	// ExpiryActionStop stops the instance, discarding its memory
+	ExpiryActionStop = "stop"
+	// ExpiryActionHibernate saves the instance's memory to its root volume
+	// before stopping it, so processes resume where they left off. The
+	// instance must be launched with hibernation enabled.
+	ExpiryActionHibernate = "hibernate"
+	// ExpiryActionTerminate terminates the instance, so its volumes stop
+	// incurring costs, and archives its record. It cannot be restarted.
+	ExpiryActionTerminate = "terminate"
+)
+
+// ValidateExpiryAction checks that the expiry action is one of the supported values
+func ValidateExpiryAction(action string) error {
+	switch action {
+	case ExpiryActionStop, ExpiryActionHibernate, ExpiryActionTerminate:
+		return nil
+	default:
+		return fmt.Errorf("invalid expiry action %q (must be %s, %s or %s)", action, ExpiryActionStop, ExpiryActionHibernate, ExpiryActionTerminate)
+	}
 }
 
 // InstanceStatus represents the current status of an instance
@@ -36,13 +297,38 @@ type InstanceStatus struct {
 	State     string `json:"state"`
 	PublicIP  string `json:"public_ip,omitempty"`
 	PrivateIP string `json:"private_ip,omitempty"`
+	SSHPort   int    `json:"ssh_port,omitempty"`
 	Username  string `json:"username"`
 	Ready     bool   `json:"ready"`
+	// Interrupted is set when the provider reclaimed the spot instance
+	Interrupted bool `json:"interrupted,omitempty"`
+	// VolumeIDs are the data volumes attached besides the root volume
+	VolumeIDs []string `json:"volume_ids,omitempty"`
+	// GPUCount and GPUModel describe the GPUs of GPU instance types
+	GPUCount int    `json:"gpu_count,omitempty"`
+	GPUModel string `json:"gpu_model,omitempty"`
 }
 
 // IsExpired checks if the instance has exceeded its duration
 func (i *Instance) IsExpired() bool {
-	return time.Now().After(i.ExpiresAt)
+	return i.IsExpiredAt(time.Now())
+}
+
+// IsExpiredAt checks if the instance has exceeded its duration at the given time
+func (i *Instance) IsExpiredAt(now time.Time) bool {
+	return now.After(i.ExpiresAt)
+}
+
+// StopsAt returns when the scheduler stops the instance, given the grace
+// period it allows after expiry
+func (i *Instance) StopsAt(gracePeriod time.Duration) time.Time {
+	return i.ExpiresAt.Add(gracePeriod)
+}
+
+// InGracePeriod reports whether the instance has expired but is still within
+// the grace period, during which it can be extended before it is stopped
+func (i *Instance) InGracePeriod(now time.Time, gracePeriod time.Duration) bool {
+	return gracePeriod > 0 && i.IsExpiredAt(now) && !now.After(i.StopsAt(gracePeriod))
 }
 
 // GetConnectionString returns the SSH connection string for the instance
@@ -61,19 +347,353 @@ func (i *Instance) GetSSHCommand() string {
 	return ""
 }
 
+// DefaultConnectionTemplate renders the plain "ssh user@ip" connection
+// command, adding -p for instances with a non-standard SSH port
+const DefaultConnectionTemplate = "ssh {{if .SSHPort}}-p {{.SSHPort}} {{end}}{{.Username}}@{{.PublicIP}}"
+
+// ParseConnectionTemplate parses a connection template. The template is
+// executed against an Instance, so it can use any of its fields, e.g.
+// "ssh -J bastion {{.Username}}@{{.PrivateIP}}". An empty template yields
+// DefaultConnectionTemplate.
+func ParseConnectionTemplate(text string) (*template.Template, error) {
+	if strings.TrimSpace(text) == "" {
+		text = DefaultConnectionTemplate
+	}
+	tmpl, err := template.New("connection").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// RenderConnection renders the connection command for the instance using the
+// given template text. It returns an empty string while the instance has no
+// public IP or username, like GetConnectionString.
+func (i *Instance) RenderConnection(text string) (string, error) {
+	tmpl, err := ParseConnectionTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	if i.PublicIP == "" || i.Username == "" {
+		return "", nil
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, i); err != nil {
+		return "", fmt.Errorf("failed to render connection template: %w", err)
+	}
+	return b.String(), nil
+}
+
 // IsReady checks if the instance is ready for connections
 func (i *Instance) IsReady() bool {
 	return i.State == "running" && i.PublicIP != ""
 }
 
+// MarkReady records the time the instance first became ready for connections.
+// It returns true if ReadyAt was set by this call.
+func (i *Instance) MarkReady(now time.Time) bool {
+	if !i.ReadyAt.IsZero() || !i.IsReady() {
+		return false
+	}
+	i.ReadyAt = now
+	return true
+}
+
+// TimeToReady returns how long the instance took from launch to become ready,
+// or zero if it has not been observed ready yet
+func (i *Instance) TimeToReady() time.Duration {
+	if i.ReadyAt.IsZero() || i.LaunchTime.IsZero() {
+		return 0
+	}
+	return i.ReadyAt.Sub(i.LaunchTime)
+}
+
+// GetExpiryAction returns the instance's expiry action, defaulting to stop
+func (i *Instance) GetExpiryAction() string {
+	return i.ExpiryActionOr(ExpiryActionStop)
+}
+
+// ExpiryActionOr returns the instance's expiry action, or defaultAction when
+// the instance has none. An empty defaultAction means stop.
+func (i *Instance) ExpiryActionOr(defaultAction string) string {
+	switch {
+	case i.ExpiryAction != "":
+		return i.ExpiryAction
+	case defaultAction != "":
+		return defaultAction
+	default:
+		return ExpiryActionStop
+	}
+}
+
+// GetRestartPolicy returns the instance's restart policy, defaulting to on-extend
+func (i *Instance) GetRestartPolicy() string {
+	if i.RestartPolicy == "" {
+		return RestartPolicyOnExtend
+	}
+	return i.RestartPolicy
+}
+
+// GetOfficeHours parses the instance's office hours, returning nil when it
+// runs around the clock
+func (i *Instance) GetOfficeHours() (*OfficeHours, error) {
+	if i.OfficeHours == "" {
+		return nil, nil
+	}
+	return ParseOfficeHours(i.OfficeHours)
+}
+
+// NextCronStop returns when the stop schedule next fires after t, or the
+// zero time without a valid stop schedule
+func (i *Instance) NextCronStop(t time.Time) time.Time {
+	return nextCronFire(i.StopCron, t)
+}
+
+// NextCronStart returns when the start schedule next fires after t, or the
+// zero time without a valid start schedule
+func (i *Instance) NextCronStart(t time.Time) time.Time {
+	return nextCronFire(i.StartCron, t)
+}
+
+func nextCronFire(expr string, t time.Time) time.Time {
+	if expr == "" {
+		return time.Time{}
+	}
+	schedule, err := ParseCron(expr)
+	if err != nil {
+		return time.Time{}
+	}
+	return schedule.Next(t)
+}
+
 // NeedsIPUpdate checks if instance needs IP information updated
 func (i *Instance) NeedsIPUpdate() bool {
 	return (i.State == "running" || i.State == "pending") && i.PublicIP == ""
 }
 
+// FilterBySession returns the instances that belong to the given session
+func FilterBySession(instances []*Instance, session string) []*Instance {
+	var filtered []*Instance
+	for _, instance := range instances {
+		if instance.Session == session {
+			filtered = append(filtered, instance)
+		}
+	}
+	return filtered
+}
+
+// FindOrphans returns the listed instances that have no record in stored,
+// such as instances left running after the storage file was lost
+func FindOrphans(listed, stored []*Instance) []*Instance {
+	known := make(map[string]bool, len(stored))
+	for _, instance := range stored {
+		known[instance.ID] = true
+	}
+	var orphans []*Instance
+	for _, instance := range listed {
+		if !known[instance.ID] {
+			orphans = append(orphans, instance)
+		}
+	}
+	return orphans
+}
+
+// Adopt prepares an orphan for storage: it records the provider and account
+// that listed it and, when its tags carried no duration, makes it expire at
+// now so the scheduler stops it
+func (i *Instance) Adopt(provider, account string, now time.Time) {
+	i.Provider = provider
+	i.Account = account
+	if i.ExpiresAt.IsZero() {
+		i.ExpiresAt = now
+		if !i.LaunchTime.IsZero() {
+			i.Duration = now.Sub(i.LaunchTime)
+		}
+	}
+}
+
+// FilterByTags returns the instances carrying every one of the given tags
+func FilterByTags(instances []*Instance, tags map[string]string) []*Instance {
+	var filtered []*Instance
+	for _, instance := range instances {
+		matched := true
+		for key, value := range tags {
+			if v, ok := instance.Tags[key]; !ok || v != value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			filtered = append(filtered, instance)
+		}
+	}
+	return filtered
+}
+
+// Limits on user-defined tags, matching EC2's
+const (
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// ParseTags parses "key=value" tag specs. The value may be empty, but not the
+// key, and a key may only be given once.
+func ParseTags(specs []string) (map[string]string, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	tags := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		key, value, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tag %q (expected key=value)", spec)
+		}
+		if _, dup := tags[key]; dup {
+			return nil, fmt.Errorf("tag %q is given more than once", key)
+		}
+		tags[key] = value
+	}
+	if err := ValidateTags(tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// ValidateTags checks tag keys and values against EC2's limits. Keys starting
+// with "aws:" are reserved by AWS.
+func ValidateTags(tags map[string]string) error {
+	for key, value := range tags {
+		switch {
+		case strings.TrimSpace(key) == "":
+			return fmt.Errorf("tag key cannot be empty")
+		case len(key) > maxTagKeyLength:
+			return fmt.Errorf("tag key %q is longer than %d characters", key, maxTagKeyLength)
+		case len(value) > maxTagValueLength:
+			return fmt.Errorf("value of tag %q is longer than %d characters", key, maxTagValueLength)
+		case strings.HasPrefix(strings.ToLower(key), "aws:"):
+			return fmt.Errorf("tag key %q uses the reserved aws: prefix", key)
+		}
+	}
+	return nil
+}
+
+// SnapshotRecord records a volume snapshot taken before an instance was
+// terminated or stopped at expiry
+type SnapshotRecord struct {
+	SnapshotID string    `json:"snapshot_id"`
+	InstanceID string    `json:"instance_id"`
+	VolumeID   string    `json:"volume_id,omitempty"`
+	Region     string    `json:"region,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ImageRecord records a machine image created from an instance so the
+// environment can be recreated later
+type ImageRecord struct {
+	ImageID    string    `json:"image_id"`
+	Name       string    `json:"name"`
+	InstanceID string    `json:"instance_id"`
+	OS         string    `json:"os,omitempty"`
+	Region     string    `json:"region,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ArchiveReasonExpired marks an instance the scheduler terminated at expiry
+// because of its terminate expiry action
+const ArchiveReasonExpired = "expired"
+
+// ArchiveReasonPruned marks the record of a terminated instance moved to the
+// archive once its retention period ended
+const ArchiveReasonPruned = "pruned"
+
+// ArchiveReasonStoppedTooLong marks an instance the scheduler terminated
+// after it stayed stopped longer than allowed
+const ArchiveReasonStoppedTooLong = "stopped-too-long"
+
+// ArchiveReasonTerminated marks an instance terminated by a user
+const ArchiveReasonTerminated = "terminated"
+
+// ArchivedInstance keeps the record of a terminated instance after it is
+// removed from the managed instances
+type ArchivedInstance struct {
+	Instance   *Instance `json:"instance"`
+	Reason     string    `json:"reason"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// SchedulerRun is the report of one scheduler pass, kept so operators can
+// tell whether the service is working
+type SchedulerRun struct {
+	StartedAt  time.Time      `json:"started_at"`
+	Duration   time.Duration  `json:"duration"`
+	Instances  int            `json:"instances"`         // Instances checked
+	Actions    map[string]int `json:"actions,omitempty"` // Count of each action taken, such as stopped or restarted
+	DryRun     bool           `json:"dry_run,omitempty"` // The actions were only logged
+	ErrorCount int            `json:"error_count"`
+	Errors     []string       `json:"errors,omitempty"` // The first errors of the pass
+}
+
+// SchedulerPause suspends the scheduler's lifecycle actions, for example
+// during an incident or maintenance, without stopping the service
+type SchedulerPause struct {
+	PausedAt time.Time `json:"paused_at"`
+	PausedBy string    `json:"paused_by,omitempty"` // Who paused the scheduler
+	Until    time.Time `json:"until,omitempty"`     // The pause ends by itself at this time; zero lasts until resumed
+	Reason   string    `json:"reason,omitempty"`    // Why the scheduler was paused
+}
+
+// Active reports whether the pause still holds at now
+func (p *SchedulerPause) Active(now time.Time) bool {
+	return p != nil && (p.Until.IsZero() || now.Before(p.Until))
+}
+
+// Lifecycle events recorded in an instance's history
+const (
+	EventCreated    = "created"
+	EventAdopted    = "adopted"
+	EventExtended   = "extended"
+	EventStopped    = "stopped"
+	EventStarted    = "started"
+	EventRestarted  = "restarted"
+	EventUnhealthy  = "unhealthy"
+	EventTerminated = "terminated"
+)
+
+// ActorScheduler is the actor of the events recorded by the scheduler
+const ActorScheduler = "scheduler"
+
+// MaxHistory is the number of events kept in an instance's history; older
+// events are dropped so long-lived instances do not grow without bound
+const MaxHistory = 100
+
+// HistoryEvent is one lifecycle event of an instance
+type HistoryEvent struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Actor  string    `json:"actor,omitempty"`  // Who caused the event, such as a user or the scheduler
+	Detail string    `json:"detail,omitempty"` // Why it happened or what changed
+}
+
+// RecordEvent appends an event to the instance's history, dropping the
+// oldest events beyond MaxHistory
+func (i *Instance) RecordEvent(at time.Time, event, actor, detail string) {
+	i.History = append(i.History, HistoryEvent{Time: at, Event: event, Actor: actor, Detail: detail})
+	if extra := len(i.History) - MaxHistory; extra > 0 {
+		i.History = append(i.History[:0:0], i.History[extra:]...)
+	}
+}
+
 // InstanceRecord represents an instance record for storage
 type InstanceRecord struct {
 	Instance  *Instance `json:"instance"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
+
+// Utilization is the peak resource usage of an instance over a window
+type Utilization struct {
+	CPUPercent            float64       // Highest average CPU utilization of any sample period
+	NetworkBytesPerSecond float64       // Highest network traffic, in and out combined, of any sample period
+	Covered               time.Duration // How much of the window the samples cover
+}
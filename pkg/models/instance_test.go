@@ -48,6 +48,40 @@ func TestInstance_IsExpired(t *testing.T) {
 	}
 }
 
+func TestInstance_IsExpiredAt(t *testing.T) {
+	expiresAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	instance := &models.Instance{ID: "i-123", ExpiresAt: expiresAt}
+
+	if instance.IsExpiredAt(expiresAt.Add(-time.Minute)) {
+		t.Error("Expected instance not to be expired before ExpiresAt")
+	}
+	if !instance.IsExpiredAt(expiresAt.Add(time.Minute)) {
+		t.Error("Expected instance to be expired after ExpiresAt")
+	}
+}
+
+func TestInstance_InGracePeriod(t *testing.T) {
+	expiresAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	instance := &models.Instance{ID: "i-123", ExpiresAt: expiresAt}
+	grace := 5 * time.Minute
+
+	if instance.InGracePeriod(expiresAt.Add(-time.Minute), grace) {
+		t.Error("Expected no grace period before ExpiresAt")
+	}
+	if !instance.InGracePeriod(expiresAt.Add(time.Minute), grace) {
+		t.Error("Expected instance to be in its grace period just after ExpiresAt")
+	}
+	if instance.InGracePeriod(expiresAt.Add(6*time.Minute), grace) {
+		t.Error("Expected the grace period to have ended")
+	}
+	if instance.InGracePeriod(expiresAt.Add(time.Minute), 0) {
+		t.Error("Expected no grace period when it is zero")
+	}
+	if !instance.StopsAt(grace).Equal(expiresAt.Add(grace)) {
+		t.Errorf("Expected StopsAt %s, got %s", expiresAt.Add(grace), instance.StopsAt(grace))
+	}
+}
+
 func TestInstance_GetConnectionString(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -91,3 +125,272 @@ func TestInstance_GetConnectionString(t *testing.T) {
 		})
 	}
 }
+
+func TestInstance_RenderConnection(t *testing.T) {
+	instance := &models.Instance{
+		ID:        "i-123",
+		PublicIP:  "54.1.2.3",
+		PrivateIP: "10.0.0.12",
+		Username:  "ubuntu",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{
+			name:     "default",
+			template: "",
+			expected: "ssh ubuntu@54.1.2.3",
+		},
+		{
+			name:     "proxy jump",
+			template: "ssh -J ops@bastion.example.com {{.Username}}@{{.PrivateIP}}",
+			expected: "ssh -J ops@bastion.example.com ubuntu@10.0.0.12",
+		},
+		{
+			name:     "custom port",
+			template: "ssh -p 2222 {{.Username}}@{{.PublicIP}}",
+			expected: "ssh -p 2222 ubuntu@54.1.2.3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := instance.RenderConnection(tt.template)
+			if err != nil {
+				t.Fatalf("RenderConnection() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("RenderConnection() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+
+	local := &models.Instance{PublicIP: "127.0.0.1", Username: "dev", SSHPort: 32768}
+	if got, err := local.RenderConnection(""); err != nil || got != "ssh -p 32768 dev@127.0.0.1" {
+		t.Errorf("Expected the default template to include the SSH port, got %q, %v", got, err)
+	}
+
+	if got, err := (&models.Instance{Username: "ubuntu"}).RenderConnection(""); err != nil || got != "" {
+		t.Errorf("Expected no connection without a public IP, got %q, %v", got, err)
+	}
+	if _, err := instance.RenderConnection("ssh {{.Username"); err == nil {
+		t.Error("Expected error for malformed template")
+	}
+	if _, err := instance.RenderConnection("ssh {{.Port}}"); err == nil {
+		t.Error("Expected error for unknown field")
+	}
+}
+
+func TestInstance_MarkReady(t *testing.T) {
+	launch := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	instance := &models.Instance{ID: "i-123", State: "pending", LaunchTime: launch}
+
+	if instance.MarkReady(launch.Add(time.Minute)) {
+		t.Error("Expected pending instance without IP not to be marked ready")
+	}
+
+	instance.State = "running"
+	instance.PublicIP = "1.2.3.4"
+	if !instance.MarkReady(launch.Add(90 * time.Second)) {
+		t.Fatal("Expected running instance with IP to be marked ready")
+	}
+	if got := instance.TimeToReady(); got != 90*time.Second {
+		t.Errorf("TimeToReady() = %s, want 1m30s", got)
+	}
+
+	if instance.MarkReady(launch.Add(10 * time.Minute)) {
+		t.Error("Expected ReadyAt not to be overwritten")
+	}
+	if !instance.ReadyAt.Equal(launch.Add(90 * time.Second)) {
+		t.Errorf("ReadyAt changed to %s", instance.ReadyAt)
+	}
+}
+
+func TestValidateRestartPolicy(t *testing.T) {
+	for _, policy := range []string{models.RestartPolicyAlways, models.RestartPolicyNever, models.RestartPolicyOnExtend} {
+		if err := models.ValidateRestartPolicy(policy); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", policy, err)
+		}
+	}
+
+	for _, policy := range []string{"", "sometimes", "Always"} {
+		if err := models.ValidateRestartPolicy(policy); err == nil {
+			t.Errorf("Expected %q to be invalid", policy)
+		}
+	}
+
+	instance := &models.Instance{}
+	if got := instance.GetRestartPolicy(); got != models.RestartPolicyOnExtend {
+		t.Errorf("Expected default restart policy %q, got %q", models.RestartPolicyOnExtend, got)
+	}
+}
+
+func TestValidateExpiryAction(t *testing.T) {
+	for _, action := range []string{models.ExpiryActionStop, models.ExpiryActionHibernate, models.ExpiryActionTerminate} {
+		if err := models.ValidateExpiryAction(action); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", action, err)
+		}
+	}
+
+	for _, action := range []string{"", "delete", "Hibernate"} {
+		if err := models.ValidateExpiryAction(action); err == nil {
+			t.Errorf("Expected %q to be invalid", action)
+		}
+	}
+
+	instance := &models.Instance{}
+	if got := instance.GetExpiryAction(); got != models.ExpiryActionStop {
+		t.Errorf("Expected default expiry action %q, got %q", models.ExpiryActionStop, got)
+	}
+	if got := instance.ExpiryActionOr(models.ExpiryActionTerminate); got != models.ExpiryActionTerminate {
+		t.Errorf("Expected the given default expiry action, got %q", got)
+	}
+	instance.ExpiryAction = models.ExpiryActionHibernate
+	if got := instance.ExpiryActionOr(models.ExpiryActionTerminate); got != models.ExpiryActionHibernate {
+		t.Errorf("Expected the instance's own expiry action, got %q", got)
+	}
+}
+
+func TestMetadataOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  models.MetadataOptions
+		hasError bool
+	}{
+		{"defaults", models.MetadataOptions{}, false},
+		{"IMDSv1 allowed", models.MetadataOptions{HTTPTokens: models.MetadataTokensOptional, HopLimit: 2}, false},
+		{"endpoint disabled", models.MetadataOptions{Endpoint: models.MetadataEndpointDisabled}, false},
+		{"invalid tokens", models.MetadataOptions{HTTPTokens: "v2"}, true},
+		{"hop limit too large", models.MetadataOptions{HopLimit: 65}, true},
+		{"negative hop limit", models.MetadataOptions{HopLimit: -1}, true},
+		{"invalid endpoint", models.MetadataOptions{Endpoint: "off"}, true},
+	}
+
+	for _, tt := range tests {
+		if err := tt.options.Validate(); (err != nil) != tt.hasError {
+			t.Errorf("%s: Validate() error = %v, want error %v", tt.name, err, tt.hasError)
+		}
+	}
+}
+
+func TestParseVolumeSpecs(t *testing.T) {
+	volumes, err := models.ParseVolumeSpecs([]string{
+		"size=100,type=io2,device=/dev/sdf",
+		"size=20",
+		"size=50, device=/dev/xvdh",
+	})
+	if err != nil {
+		t.Fatalf("ParseVolumeSpecs failed: %v", err)
+	}
+
+	expected := []models.VolumeSpec{
+		{SizeGiB: 100, Type: "io2", Device: "/dev/sdf"},
+		{SizeGiB: 20, Type: models.DefaultVolumeType, Device: "/dev/sdg"},
+		{SizeGiB: 50, Type: models.DefaultVolumeType, Device: "/dev/xvdh"},
+	}
+	if len(volumes) != len(expected) {
+		t.Fatalf("Expected %d volumes, got %d", len(expected), len(volumes))
+	}
+	for i := range expected {
+		if volumes[i] != expected[i] {
+			t.Errorf("Volume %d: got %+v, want %+v", i, volumes[i], expected[i])
+		}
+	}
+
+	for _, invalid := range [][]string{
+		{"type=gp3"},
+		{"size=0"},
+		{"size=ten"},
+		{"size=10,color=red"},
+		{"size=10,device=/dev/sdf", "size=20,device=/dev/sdf"},
+	} {
+		if _, err := models.ParseVolumeSpecs(invalid); err == nil {
+			t.Errorf("Expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := models.ParseTags([]string{"team=data", "note=a=b", "empty="})
+	if err != nil {
+		t.Fatalf("ParseTags failed: %v", err)
+	}
+	if len(tags) != 3 || tags["team"] != "data" || tags["note"] != "a=b" || tags["empty"] != "" {
+		t.Errorf("Unexpected tags: %v", tags)
+	}
+
+	for _, invalid := range [][]string{
+		{"team"},
+		{"=data"},
+		{"team=data", "team=ops"},
+		{"aws:cloudformation=x"},
+	} {
+		if _, err := models.ParseTags(invalid); err == nil {
+			t.Errorf("Expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestFilterByTags(t *testing.T) {
+	instances := []*models.Instance{
+		{ID: "i-1", Tags: map[string]string{"team": "data", "env": "dev"}},
+		{ID: "i-2", Tags: map[string]string{"team": "data"}},
+		{ID: "i-3"},
+	}
+
+	filtered := models.FilterByTags(instances, map[string]string{"team": "data", "env": "dev"})
+	if len(filtered) != 1 || filtered[0].ID != "i-1" {
+		t.Errorf("Expected only i-1, got %v", filtered)
+	}
+	if filtered := models.FilterByTags(instances, map[string]string{"team": "data"}); len(filtered) != 2 {
+		t.Errorf("Expected 2 instances tagged team=data, got %d", len(filtered))
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	listed := []*models.Instance{{ID: "i-1"}, {ID: "i-2"}, {ID: "i-3"}}
+	stored := []*models.Instance{{ID: "i-2"}, {ID: "i-4"}}
+
+	orphans := models.FindOrphans(listed, stored)
+	if len(orphans) != 2 || orphans[0].ID != "i-1" || orphans[1].ID != "i-3" {
+		t.Errorf("Expected i-1 and i-3 to be orphans, got %v", orphans)
+	}
+	if orphans := models.FindOrphans(listed, listed); len(orphans) != 0 {
+		t.Errorf("Expected no orphans when every instance is stored, got %v", orphans)
+	}
+}
+
+func TestInstance_Adopt(t *testing.T) {
+	now := time.Now()
+	tagged := &models.Instance{ID: "i-1", LaunchTime: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)}
+	tagged.Adopt("aws", "prod", now)
+	if tagged.Provider != "aws" || tagged.Account != "prod" || !tagged.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected the provider and account to be recorded and the tagged expiry kept, got %+v", tagged)
+	}
+
+	untagged := &models.Instance{ID: "i-2", LaunchTime: now.Add(-time.Hour)}
+	untagged.Adopt("gcp", "", now)
+	if !untagged.ExpiresAt.Equal(now) || untagged.Duration != time.Hour {
+		t.Errorf("Expected an instance without a duration to expire at once, got %+v", untagged)
+	}
+}
+
+func TestInstance_RecordEvent(t *testing.T) {
+	now := time.Now()
+	instance := &models.Instance{ID: "i-1"}
+	instance.RecordEvent(now, models.EventCreated, "alice", "")
+	instance.RecordEvent(now.Add(time.Hour), models.EventExtended, "web", "by 2h")
+	if len(instance.History) != 2 || instance.History[1].Event != models.EventExtended || instance.History[1].Detail != "by 2h" {
+		t.Fatalf("Unexpected history %+v", instance.History)
+	}
+
+	for i := 0; i < models.MaxHistory; i++ {
+		instance.RecordEvent(now.Add(time.Duration(i)*time.Minute), models.EventStopped, models.ActorScheduler, "")
+	}
+	if len(instance.History) != models.MaxHistory || instance.History[0].Event != models.EventStopped {
+		t.Errorf("Expected the history to keep the last %d events, got %d starting with %s", models.MaxHistory, len(instance.History), instance.History[0].Event)
+	}
+}
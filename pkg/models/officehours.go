@@ -0,0 +1,154 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// weekdayNames maps the accepted day abbreviations to weekdays
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// OfficeHours is a weekly window during which an instance runs, such as
+// 08:00-19:00 on weekdays in Europe/Berlin. A window whose end is not after
+// its start runs past midnight into the next day.
+type OfficeHours struct {
+	Start    time.Duration // Opening time as an offset from midnight
+	End      time.Duration // Closing time as an offset from midnight
+	Days     [7]bool       // Days the window opens on, indexed by time.Weekday
+	Location *time.Location
+}
+
+// ParseOfficeHours parses office hours written as
+// "HH:MM-HH:MM [days] [time zone]", e.g. "08:00-19:00 Mon-Fri Europe/Berlin".
+// Days are a comma-separated list of three-letter names and ranges, and
+// default to every day. The time zone is an IANA name and defaults to UTC.
+func ParseOfficeHours(spec string) (*OfficeHours, error) {
+	fields := strings.Fields(strings.ReplaceAll(spec, "–", "-"))
+	if len(fields) == 0 || len(fields) > 3 {
+		return nil, fmt.Errorf("invalid office hours %q (expected \"HH:MM-HH:MM [days] [time zone]\")", spec)
+	}
+
+	hours := &OfficeHours{Location: time.UTC}
+	start, end, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid office hours %q: window must be HH:MM-HH:MM", spec)
+	}
+	var err error
+	if hours.Start, err = parseClock(start); err != nil {
+		return nil, fmt.Errorf("invalid office hours %q: %w", spec, err)
+	}
+	if hours.End, err = parseClock(end); err != nil {
+		return nil, fmt.Errorf("invalid office hours %q: %w", spec, err)
+	}
+	if hours.Start == hours.End {
+		return nil, fmt.Errorf("invalid office hours %q: window must not be empty", spec)
+	}
+
+	rest := fields[1:]
+	if len(rest) > 0 {
+		if days, err := parseDays(rest[0]); err == nil {
+			hours.Days = days
+			rest = rest[1:]
+		} else if len(rest) == 2 {
+			return nil, fmt.Errorf("invalid office hours %q: %w", spec, err)
+		}
+	}
+	if hours.Days == [7]bool{} {
+		hours.Days = [7]bool{true, true, true, true, true, true, true}
+	}
+	if len(rest) > 0 {
+		location, err := time.LoadLocation(rest[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid office hours %q: unknown time zone %s", spec, rest[0])
+		}
+		hours.Location = location
+	}
+	return hours, nil
+}
+
+// parseClock parses a 24-hour HH:MM time into the offset from midnight
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (expected HH:MM)", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseDays parses a comma-separated list of day names and ranges such as
+// "Mon-Fri" or "Mon,Wed,Sat-Sun"
+func parseDays(value string) ([7]bool, error) {
+	var days [7]bool
+	for _, part := range strings.Split(value, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		from, ok := weekdayNames[strings.ToLower(first)]
+		if !ok {
+			return days, fmt.Errorf("unknown day %q", first)
+		}
+		to := from
+		if isRange {
+			if to, ok = weekdayNames[strings.ToLower(last)]; !ok {
+				return days, fmt.Errorf("unknown day %q", last)
+			}
+		}
+		for day := from; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// Contains reports whether t falls within the office hours
+func (h *OfficeHours) Contains(t time.Time) bool {
+	local := t.In(h.Location)
+	offset := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+	today := local.Weekday()
+	if h.Start < h.End {
+		return h.Days[today] && offset >= h.Start && offset < h.End
+	}
+	// The window runs past midnight: it is open late on its own days and
+	// early on the following days
+	yesterday := (today + 6) % 7
+	return h.Days[today] && offset >= h.Start || h.Days[yesterday] && offset < h.End
+}
+
+// NextOpen returns the first time after t at which the office hours open
+func (h *OfficeHours) NextOpen(t time.Time) time.Time {
+	return h.next(t, h.Start, 0)
+}
+
+// NextClose returns the first time after t at which the office hours close
+func (h *OfficeHours) NextClose(t time.Time) time.Time {
+	closeDay := 0
+	if h.End <= h.Start {
+		closeDay = 1
+	}
+	return h.next(t, h.End, closeDay)
+}
+
+// next returns the first time after t that lies offset past midnight,
+// dayShift days after a day the window opens on
+func (h *OfficeHours) next(t time.Time, offset time.Duration, dayShift int) time.Time {
+	local := t.In(h.Location)
+	hour, minute := int(offset/time.Hour), int(offset%time.Hour/time.Minute)
+	for i := -1; i <= 8; i++ {
+		candidate := time.Date(local.Year(), local.Month(), local.Day()+i, hour, minute, 0, 0, h.Location)
+		openDay := (candidate.Weekday() + 7 - time.Weekday(dayShift)) % 7
+		if h.Days[openDay] && candidate.After(t) {
+			return candidate
+		}
+	}
+	return time.Time{}
+}
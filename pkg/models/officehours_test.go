@@ -0,0 +1,97 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"instance-manager/pkg/models"
+)
+
+func TestParseOfficeHours(t *testing.T) {
+	hours, err := models.ParseOfficeHours("08:00–19:00 Mon–Fri Europe/Berlin")
+	if err != nil {
+		t.Fatalf("ParseOfficeHours failed: %v", err)
+	}
+	if hours.Start != 8*time.Hour || hours.End != 19*time.Hour {
+		t.Errorf("Expected 08:00-19:00, got %s-%s", hours.Start, hours.End)
+	}
+	if hours.Location.String() != "Europe/Berlin" {
+		t.Errorf("Expected Europe/Berlin, got %s", hours.Location)
+	}
+	if !hours.Days[time.Monday] || !hours.Days[time.Friday] || hours.Days[time.Saturday] || hours.Days[time.Sunday] {
+		t.Errorf("Expected Mon-Fri, got %v", hours.Days)
+	}
+
+	hours, err = models.ParseOfficeHours("22:00-06:00 Sat-Sun")
+	if err != nil {
+		t.Fatalf("ParseOfficeHours failed: %v", err)
+	}
+	if hours.Location != time.UTC || !hours.Days[time.Saturday] || !hours.Days[time.Sunday] || hours.Days[time.Monday] {
+		t.Errorf("Unexpected office hours: %+v", hours)
+	}
+
+	for _, spec := range []string{"", "08:00", "8-19", "08:00-08:00", "08:00-19:00 Mon-Fry UTC", "08:00-19:00 Nowhere/City", "08:00-19:00 Mon UTC extra"} {
+		if _, err := models.ParseOfficeHours(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestOfficeHours_Contains(t *testing.T) {
+	hours, err := models.ParseOfficeHours("08:00-19:00 Mon-Fri Europe/Berlin")
+	if err != nil {
+		t.Fatalf("ParseOfficeHours failed: %v", err)
+	}
+	berlin := hours.Location
+
+	tests := []struct {
+		at       time.Time
+		expected bool
+	}{
+		{time.Date(2024, 3, 4, 8, 0, 0, 0, berlin), true},   // Monday opening
+		{time.Date(2024, 3, 4, 18, 59, 0, 0, berlin), true}, // Monday evening
+		{time.Date(2024, 3, 4, 19, 0, 0, 0, berlin), false}, // Monday closing
+		{time.Date(2024, 3, 4, 7, 0, 0, 0, time.UTC), true}, // 08:00 in Berlin
+		{time.Date(2024, 3, 9, 12, 0, 0, 0, berlin), false}, // Saturday
+	}
+	for _, test := range tests {
+		if got := hours.Contains(test.at); got != test.expected {
+			t.Errorf("Contains(%s) = %v, expected %v", test.at, got, test.expected)
+		}
+	}
+
+	overnight, err := models.ParseOfficeHours("22:00-06:00 Fri")
+	if err != nil {
+		t.Fatalf("ParseOfficeHours failed: %v", err)
+	}
+	if !overnight.Contains(time.Date(2024, 3, 8, 23, 0, 0, 0, time.UTC)) || !overnight.Contains(time.Date(2024, 3, 9, 5, 0, 0, 0, time.UTC)) {
+		t.Error("Expected the Friday night window to run into Saturday morning")
+	}
+	if overnight.Contains(time.Date(2024, 3, 8, 5, 0, 0, 0, time.UTC)) {
+		t.Error("Expected Friday early morning to be outside the window")
+	}
+}
+
+func TestOfficeHours_NextOpenClose(t *testing.T) {
+	hours, err := models.ParseOfficeHours("08:00-19:00 Mon-Fri")
+	if err != nil {
+		t.Fatalf("ParseOfficeHours failed: %v", err)
+	}
+
+	friday := time.Date(2024, 3, 8, 20, 0, 0, 0, time.UTC)
+	if got, want := hours.NextOpen(friday), time.Date(2024, 3, 11, 8, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected the next opening on Monday %s, got %s", want, got)
+	}
+	monday := time.Date(2024, 3, 11, 9, 0, 0, 0, time.UTC)
+	if got, want := hours.NextClose(monday), time.Date(2024, 3, 11, 19, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected the next closing at %s, got %s", want, got)
+	}
+
+	overnight, err := models.ParseOfficeHours("22:00-06:00 Fri")
+	if err != nil {
+		t.Fatalf("ParseOfficeHours failed: %v", err)
+	}
+	if got, want := overnight.NextClose(friday), time.Date(2024, 3, 9, 6, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected the overnight window to close on Saturday %s, got %s", want, got)
+	}
+}
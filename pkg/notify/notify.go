@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Event names carried by notifications
+const (
+	// EventExpiryWarning is sent when an instance is about to expire
+	EventExpiryWarning = "expiry_warning"
+	// EventGracePeriod is sent when an instance has expired and is kept
+	// running for the grace period before it is stopped
+	EventGracePeriod = "grace_period"
+	// EventIdleStopped is sent when the idle policy stops an instance before
+	// it expires
+	EventIdleStopped = "idle_stopped"
+	// EventTerminated is sent when the scheduler terminates an instance
+	EventTerminated = "terminated"
+	// EventOrphanFound is sent when the scheduler finds a managed instance
+	// at a provider that has no record in storage
+	EventOrphanFound = "orphan_found"
+)
+
+// Notification describes an instance lifecycle event for the people using it
+type Notification struct {
+	Event        string    `json:"event"`
+	InstanceID   string    `json:"instance_id"`
+	InstanceName string    `json:"instance_name,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	// TimeLeft is how long until the instance expires or, during the grace
+	// period, until it is stopped
+	TimeLeft time.Duration `json:"time_left"`
+	Message  string        `json:"message"`
+	// ExtendCommand is a ready-to-run command that extends the instance
+	ExtendCommand string `json:"extend_command,omitempty"`
+	// ExtendURL opens the web UI's extend dialog for the instance
+	ExtendURL string `json:"extend_url,omitempty"`
+}
+
+// Notifier delivers notifications
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// NotifierFunc adapts a function to the Notifier interface
+type NotifierFunc func(ctx context.Context, notification Notification) error
+
+// Notify calls the function
+func (f NotifierFunc) Notify(ctx context.Context, notification Notification) error {
+	return f(ctx, notification)
+}
+
+// Webhook posts each notification as JSON to a URL
+type Webhook struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewWebhook creates a notifier that posts to the given URL
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		url:        url,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify posts the notification. Non-2xx responses are returned as errors.
+func (w *Webhook) Notify(ctx context.Context, notification Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notification webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ExtendCommand returns the CLI command that extends the instance by
+// duration, e.g. "1h"
+func ExtendCommand(instanceID, duration string) string {
+	return fmt.Sprintf("instance-manager extend --instance-id %s --duration %s", instanceID, duration)
+}
+
+// ExtendURL returns the web UI link that opens the extend dialog for the
+// instance, or an empty string when no web UI URL is configured
+func ExtendURL(webURL, instanceID string) string {
+	if webURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(webURL, "/") + "/?extend=" + url.QueryEscape(instanceID)
+}
@@ -0,0 +1,62 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/notify"
+)
+
+func TestWebhookNotify(t *testing.T) {
+	var received notify.Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Expected POST, got %s", r.Method)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode notification: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	notification := notify.Notification{
+		Event:         notify.EventExpiryWarning,
+		InstanceID:    "i-123",
+		TimeLeft:      10 * time.Minute,
+		ExtendCommand: notify.ExtendCommand("i-123", "1h"),
+	}
+	if err := notify.NewWebhook(server.URL).Notify(context.Background(), notification); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if received.InstanceID != "i-123" || received.Event != notify.EventExpiryWarning {
+		t.Errorf("Unexpected notification received: %+v", received)
+	}
+	if received.ExtendCommand != "instance-manager extend --instance-id i-123 --duration 1h" {
+		t.Errorf("Unexpected extend command: %q", received.ExtendCommand)
+	}
+}
+
+func TestWebhookNotify_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if err := notify.NewWebhook(server.URL).Notify(context.Background(), notify.Notification{}); err == nil {
+		t.Error("Expected an error for a 500 response")
+	}
+}
+
+func TestExtendURL(t *testing.T) {
+	if got := notify.ExtendURL("", "i-123"); got != "" {
+		t.Errorf("Expected no link without a web URL, got %q", got)
+	}
+	if got := notify.ExtendURL("https://im.example.com/", "dev box"); got != "https://im.example.com/?extend=dev+box" {
+		t.Errorf("Unexpected extend URL: %q", got)
+	}
+}
@@ -0,0 +1,203 @@
+package oci
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/core"
+	"github.com/oracle/oci-go-sdk/v65/identity"
+)
+
+// OCIAPI is the subset of the OCI Compute, Virtual Network and Identity
+// APIs used by the provider. List methods return every page.
+type OCIAPI interface {
+	ListAvailabilityDomains(ctx context.Context, compartmentID string) ([]identity.AvailabilityDomain, error)
+	ListVcns(ctx context.Context, compartmentID string) ([]core.Vcn, error)
+	ListSubnets(ctx context.Context, compartmentID, vcnID string) ([]core.Subnet, error)
+	// ListImages returns the Ubuntu images compatible with shape, newest first
+	ListImages(ctx context.Context, compartmentID, shape string) ([]core.Image, error)
+
+	LaunchInstance(ctx context.Context, details core.LaunchInstanceDetails) (*core.Instance, error)
+	GetInstance(ctx context.Context, instanceID string) (*core.Instance, error)
+	InstanceAction(ctx context.Context, instanceID string, action core.InstanceActionActionEnum) error
+	TerminateInstance(ctx context.Context, instanceID string) error
+	ListInstances(ctx context.Context, compartmentID string) ([]core.Instance, error)
+	// GetPrimaryVnic returns the VNIC of the instance's first attachment, or
+	// nil if none is attached yet
+	GetPrimaryVnic(ctx context.Context, compartmentID, instanceID string) (*core.Vnic, error)
+}
+
+// sdkClient implements OCIAPI on top of the OCI SDK clients
+type sdkClient struct {
+	compute  core.ComputeClient
+	network  core.VirtualNetworkClient
+	identity identity.IdentityClient
+}
+
+func newSDKClient(configProvider common.ConfigurationProvider, region string) (*sdkClient, error) {
+	compute, err := core.NewComputeClientWithConfigurationProvider(configProvider)
+	if err != nil {
+		return nil, err
+	}
+	network, err := core.NewVirtualNetworkClientWithConfigurationProvider(configProvider)
+	if err != nil {
+		return nil, err
+	}
+	identityClient, err := identity.NewIdentityClientWithConfigurationProvider(configProvider)
+	if err != nil {
+		return nil, err
+	}
+	if region != "" {
+		compute.SetRegion(region)
+		network.SetRegion(region)
+		identityClient.SetRegion(region)
+	}
+
+	return &sdkClient{compute: compute, network: network, identity: identityClient}, nil
+}
+
+func (c *sdkClient) ListAvailabilityDomains(ctx context.Context, compartmentID string) ([]identity.AvailabilityDomain, error) {
+	resp, err := c.identity.ListAvailabilityDomains(ctx, identity.ListAvailabilityDomainsRequest{
+		CompartmentId: common.String(compartmentID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Items, nil
+}
+
+func (c *sdkClient) ListVcns(ctx context.Context, compartmentID string) ([]core.Vcn, error) {
+	var vcns []core.Vcn
+	req := core.ListVcnsRequest{
+		CompartmentId:  common.String(compartmentID),
+		LifecycleState: core.VcnLifecycleStateAvailable,
+	}
+	for {
+		resp, err := c.network.ListVcns(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		vcns = append(vcns, resp.Items...)
+		if resp.OpcNextPage == nil {
+			return vcns, nil
+		}
+		req.Page = resp.OpcNextPage
+	}
+}
+
+func (c *sdkClient) ListSubnets(ctx context.Context, compartmentID, vcnID string) ([]core.Subnet, error) {
+	var subnets []core.Subnet
+	req := core.ListSubnetsRequest{
+		CompartmentId:  common.String(compartmentID),
+		VcnId:          common.String(vcnID),
+		LifecycleState: core.SubnetLifecycleStateAvailable,
+	}
+	for {
+		resp, err := c.network.ListSubnets(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, resp.Items...)
+		if resp.OpcNextPage == nil {
+			return subnets, nil
+		}
+		req.Page = resp.OpcNextPage
+	}
+}
+
+func (c *sdkClient) ListImages(ctx context.Context, compartmentID, shape string) ([]core.Image, error) {
+	resp, err := c.compute.ListImages(ctx, core.ListImagesRequest{
+		CompartmentId:          common.String(compartmentID),
+		OperatingSystem:        common.String(imageOS),
+		OperatingSystemVersion: common.String(imageOSVersion),
+		Shape:                  common.String(shape),
+		SortBy:                 core.ListImagesSortByTimecreated,
+		SortOrder:              core.ListImagesSortOrderDesc,
+		LifecycleState:         core.ImageLifecycleStateAvailable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Items, nil
+}
+
+func (c *sdkClient) LaunchInstance(ctx context.Context, details core.LaunchInstanceDetails) (*core.Instance, error) {
+	resp, err := c.compute.LaunchInstance(ctx, core.LaunchInstanceRequest{
+		LaunchInstanceDetails: details,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Instance, nil
+}
+
+func (c *sdkClient) GetInstance(ctx context.Context, instanceID string) (*core.Instance, error) {
+	resp, err := c.compute.GetInstance(ctx, core.GetInstanceRequest{
+		InstanceId: common.String(instanceID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Instance, nil
+}
+
+func (c *sdkClient) InstanceAction(ctx context.Context, instanceID string, action core.InstanceActionActionEnum) error {
+	_, err := c.compute.InstanceAction(ctx, core.InstanceActionRequest{
+		InstanceId: common.String(instanceID),
+		Action:     action,
+	})
+	return err
+}
+
+func (c *sdkClient) TerminateInstance(ctx context.Context, instanceID string) error {
+	_, err := c.compute.TerminateInstance(ctx, core.TerminateInstanceRequest{
+		InstanceId:         common.String(instanceID),
+		PreserveBootVolume: common.Bool(false),
+	})
+	return err
+}
+
+func (c *sdkClient) ListInstances(ctx context.Context, compartmentID string) ([]core.Instance, error) {
+	var instances []core.Instance
+	req := core.ListInstancesRequest{CompartmentId: common.String(compartmentID)}
+	for {
+		resp, err := c.compute.ListInstances(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, resp.Items...)
+		if resp.OpcNextPage == nil {
+			return instances, nil
+		}
+		req.Page = resp.OpcNextPage
+	}
+}
+
+func (c *sdkClient) GetPrimaryVnic(ctx context.Context, compartmentID, instanceID string) (*core.Vnic, error) {
+	attachments, err := c.compute.ListVnicAttachments(ctx, core.ListVnicAttachmentsRequest{
+		CompartmentId: common.String(compartmentID),
+		InstanceId:    common.String(instanceID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, attachment := range attachments.Items {
+		if attachment.LifecycleState != core.VnicAttachmentLifecycleStateAttached || attachment.VnicId == nil {
+			continue
+		}
+		resp, err := c.network.GetVnic(ctx, core.GetVnicRequest{VnicId: attachment.VnicId})
+		if err != nil {
+			return nil, err
+		}
+		return &resp.Vnic, nil
+	}
+	return nil, nil
+}
+
+// isNotFound reports whether err is a 404 from an OCI service
+func isNotFound(err error) bool {
+	var serviceErr common.ServiceError
+	return errors.As(err, &serviceErr) && serviceErr.GetHTTPStatusCode() == http.StatusNotFound
+}
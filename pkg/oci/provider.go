@@ -0,0 +1,459 @@
+package oci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/tracing"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/core"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultShape is the Always Free AMD shape used when none is given
+	DefaultShape = "VM.Standard.E2.1.Micro"
+
+	// defaultUsername is the login user of the Ubuntu platform images
+	defaultUsername = "ubuntu"
+
+	imageOS        = "Canonical Ubuntu"
+	imageOSVersion = "22.04"
+
+	managedBy = "instance-manager"
+
+	// Flexible shapes are launched with this many OCPUs and GB of memory,
+	// which stays within the Always Free allowance for VM.Standard.A1.Flex
+	flexOCPUs    = 1
+	flexMemoryGB = 6
+)
+
+// Shapes lists common shapes offered by the web UI, default first
+var Shapes = []string{
+	DefaultShape,
+	"VM.Standard.A1.Flex",
+	"VM.Standard.E4.Flex",
+	"VM.Standard.E5.Flex",
+	"VM.Standard3.Flex",
+}
+
+// Provider implements the CloudProvider interface for OCI compute instances.
+// Instance IDs are instance OCIDs.
+type Provider struct {
+	client             OCIAPI
+	compartmentID      string
+	region             string
+	availabilityDomain string
+}
+
+// NewProvider creates a new OCI provider for the given compartment. API keys
+// are read from the profile in configFile, or from the DEFAULT profile of
+// ~/.oci/config when configFile is empty. An empty availabilityDomain selects
+// the region's first availability domain.
+func NewProvider(compartmentID, region, availabilityDomain, configFile, profile string) (cloud.CloudProvider, error) {
+	if compartmentID == "" {
+		return nil, errors.New("OCI_COMPARTMENT_ID environment variable is required")
+	}
+
+	configProvider := common.DefaultConfigProvider()
+	if configFile != "" {
+		if profile == "" {
+			profile = "DEFAULT"
+		}
+		configProvider = common.CustomProfileConfigProvider(configFile, profile)
+	}
+	client, err := newSDKClient(configProvider, region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCI clients: %w", err)
+	}
+
+	return NewProviderWithClient(client, compartmentID, region, availabilityDomain), nil
+}
+
+// NewProviderWithClient creates an OCI provider backed by the given client
+func NewProviderWithClient(client OCIAPI, compartmentID, region, availabilityDomain string) *Provider {
+	return &Provider{
+		client:             client,
+		compartmentID:      compartmentID,
+		region:             region,
+		availabilityDomain: availabilityDomain,
+	}
+}
+
+// ValidateCredentials checks that the credentials can access the compartment
+func (p *Provider) ValidateCredentials(ctx context.Context) error {
+	if _, err := p.client.ListAvailabilityDomains(ctx, p.compartmentID); err != nil {
+		return fmt.Errorf("invalid OCI credentials for compartment %s: %w", p.compartmentID, err)
+	}
+	return nil
+}
+
+// CreateInstance launches a new OCI compute instance in a discovered subnet
+func (p *Provider) CreateInstance(ctx context.Context, config models.InstanceConfig) (instance *models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "CreateInstance", "")
+	defer func() {
+		if instance != nil {
+			span.SetAttributes(tracing.AttrInstanceID.String(instance.ID))
+		}
+		tracing.EndSpan(span, err)
+	}()
+
+	if config.KeyName != "" {
+		return nil, errors.New("key pairs are not supported on OCI; use a public key instead")
+	}
+	if config.SecurityGroupID != "" {
+		return nil, errors.New("security group IDs are not supported on OCI")
+	}
+
+	publicKey, err := os.ReadFile(config.PublicKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key file: %w", err)
+	}
+
+	availabilityDomain, err := p.resolveAvailabilityDomain(ctx, config.AvailabilityZone)
+	if err != nil {
+		return nil, err
+	}
+	subnetID, err := p.getDefaultSubnet(ctx, availabilityDomain)
+	if err != nil {
+		return nil, err
+	}
+
+	shape := config.InstanceType
+	if shape == "" {
+		shape = DefaultShape
+	}
+	images, err := p.client.ListImages(ctx, p.compartmentID, shape)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	if len(images) == 0 || images[0].Id == nil {
+		return nil, fmt.Errorf("no %s %s image found for shape %s", imageOS, imageOSVersion, shape)
+	}
+
+	launchTime := time.Now()
+	expiresAt := launchTime.Add(config.Duration)
+	name := "im-" + strconv.FormatInt(launchTime.UnixNano(), 36)
+
+	details := core.LaunchInstanceDetails{
+		AvailabilityDomain: common.String(availabilityDomain),
+		CompartmentId:      common.String(p.compartmentID),
+		DisplayName:        common.String(name),
+		Shape:              common.String(shape),
+		SourceDetails:      core.InstanceSourceViaImageDetails{ImageId: images[0].Id},
+		CreateVnicDetails: &core.CreateVnicDetails{
+			SubnetId:       common.String(subnetID),
+			AssignPublicIp: common.Bool(true),
+		},
+		Metadata: map[string]string{
+			"ssh_authorized_keys": strings.TrimSpace(string(publicKey)),
+		},
+		FreeformTags: managedTags(config.Duration, expiresAt, config.Session),
+	}
+	if strings.HasSuffix(shape, ".Flex") {
+		details.ShapeConfig = &core.LaunchInstanceShapeConfigDetails{
+			Ocpus:       common.Float32(flexOCPUs),
+			MemoryInGBs: common.Float32(flexMemoryGB),
+		}
+	}
+
+	launched, err := p.client.LaunchInstance(ctx, details)
+	if err != nil {
+		return nil, fmt.Errorf("failed to launch instance: %w", err)
+	}
+	if launched.Id == nil {
+		return nil, errors.New("no instance returned from launch")
+	}
+
+	instance = &models.Instance{
+		ID:               *launched.Id,
+		InstanceType:     shape,
+		State:            instanceState(launched.LifecycleState),
+		LaunchTime:       launchTime,
+		Duration:         config.Duration,
+		AvailabilityZone: availabilityDomain,
+		Region:           p.region,
+		Username:         defaultUsername,
+		ExpiresAt:        expiresAt,
+		Provider:         "oci",
+		RestartPolicy:    config.RestartPolicy,
+		Session:          config.Session,
+	}
+
+	return instance, nil
+}
+
+// GetInstanceStatus retrieves the status of an instance
+func (p *Provider) GetInstanceStatus(ctx context.Context, instanceID string) (_ *models.InstanceStatus, err error) {
+	ctx, span := p.startSpan(ctx, "GetInstanceStatus", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	inst, err := p.client.GetInstance(ctx, instanceID)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, errors.New("instance not found")
+		}
+		return nil, fmt.Errorf("failed to get instance: %w", err)
+	}
+
+	state := instanceState(inst.LifecycleState)
+	status := &models.InstanceStatus{
+		ID:       instanceID,
+		State:    state,
+		Username: defaultUsername,
+		Ready:    state == "running",
+	}
+	status.PublicIP, status.PrivateIP = p.instanceIPs(ctx, instanceID)
+	return status, nil
+}
+
+// StartInstance starts a stopped instance
+func (p *Provider) StartInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StartInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.InstanceAction(ctx, instanceID, core.InstanceActionActionStart); err != nil {
+		return fmt.Errorf("failed to start instance: %w", err)
+	}
+	return nil
+}
+
+// StopInstance stops an instance. Stopped standard shapes are not billed for
+// compute, only for their boot volume.
+func (p *Provider) StopInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StopInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.InstanceAction(ctx, instanceID, core.InstanceActionActionStop); err != nil {
+		return fmt.Errorf("failed to stop instance: %w", err)
+	}
+	return nil
+}
+
+// TerminateInstance terminates an instance and deletes its boot volume
+func (p *Provider) TerminateInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "TerminateInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.TerminateInstance(ctx, instanceID); err != nil {
+		return fmt.Errorf("failed to terminate instance: %w", err)
+	}
+	return nil
+}
+
+// ListInstances lists the instances managed by this tool in the compartment
+func (p *Provider) ListInstances(ctx context.Context) (_ []*models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "ListInstances", "")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	items, err := p.client.ListInstances(ctx, p.compartmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instances: %w", err)
+	}
+
+	var instances []*models.Instance
+	for _, item := range items {
+		if item.FreeformTags["ManagedBy"] != managedBy || item.Id == nil {
+			continue
+		}
+		if item.LifecycleState == core.InstanceLifecycleStateTerminated {
+			continue
+		}
+
+		inst := &models.Instance{
+			ID:       *item.Id,
+			State:    instanceState(item.LifecycleState),
+			Region:   p.region,
+			Username: defaultUsername,
+			Provider: "oci",
+			Session:  item.FreeformTags["Session"],
+		}
+		if item.Shape != nil {
+			inst.InstanceType = *item.Shape
+		}
+		if item.AvailabilityDomain != nil {
+			inst.AvailabilityZone = *item.AvailabilityDomain
+		}
+		if item.Region != nil {
+			inst.Region = *item.Region
+		}
+		if item.TimeCreated != nil {
+			inst.LaunchTime = item.TimeCreated.Time
+		}
+		if duration, err := time.ParseDuration(item.FreeformTags["Duration"]); err == nil {
+			inst.Duration = duration
+			inst.ExpiresAt = inst.LaunchTime.Add(duration)
+		}
+		if inst.State == "running" {
+			inst.PublicIP, inst.PrivateIP = p.instanceIPs(ctx, inst.ID)
+		}
+
+		instances = append(instances, inst)
+	}
+
+	return instances, nil
+}
+
+// resolveAvailabilityDomain returns the full name of the requested
+// availability domain. The request may be the full name ("Uocm:PHX-AD-1") or
+// a suffix of it ("PHX-AD-1", "AD-1"). An empty request uses the configured
+// domain, falling back to the first one in the region.
+func (p *Provider) resolveAvailabilityDomain(ctx context.Context, requested string) (string, error) {
+	if requested == "" {
+		requested = p.availabilityDomain
+	}
+
+	domains, err := p.client.ListAvailabilityDomains(ctx, p.compartmentID)
+	if err != nil {
+		return "", fmt.Errorf("failed to list availability domains: %w", err)
+	}
+
+	var names []string
+	for _, domain := range domains {
+		if domain.Name != nil {
+			names = append(names, *domain.Name)
+		}
+	}
+	if len(names) == 0 {
+		return "", errors.New("no availability domains found")
+	}
+	sort.Strings(names)
+
+	if requested == "" {
+		return names[0], nil
+	}
+	for _, name := range names {
+		if name == requested || strings.HasSuffix(name, ":"+requested) || strings.HasSuffix(name, "-"+requested) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("availability domain %s not found; available: %s", requested, strings.Join(names, ", "))
+}
+
+// getDefaultSubnet discovers a subnet for the availability domain across the
+// compartment's VCNs. Public subnets in the domain are preferred, then
+// regional public subnets, then any subnet usable from the domain.
+func (p *Provider) getDefaultSubnet(ctx context.Context, availabilityDomain string) (string, error) {
+	vcns, err := p.client.ListVcns(ctx, p.compartmentID)
+	if err != nil {
+		return "", fmt.Errorf("failed to list VCNs: %w", err)
+	}
+
+	var adPublic, regionalPublic, private []core.Subnet
+	for _, vcn := range vcns {
+		if vcn.Id == nil {
+			continue
+		}
+		subnets, err := p.client.ListSubnets(ctx, p.compartmentID, *vcn.Id)
+		if err != nil {
+			return "", fmt.Errorf("failed to list subnets: %w", err)
+		}
+
+		for _, subnet := range subnets {
+			if subnet.Id == nil {
+				continue
+			}
+			// AD-specific subnets only serve instances in their own domain
+			if subnet.AvailabilityDomain != nil && *subnet.AvailabilityDomain != availabilityDomain {
+				continue
+			}
+			public := subnet.ProhibitPublicIpOnVnic == nil || !*subnet.ProhibitPublicIpOnVnic
+			switch {
+			case public && subnet.AvailabilityDomain != nil:
+				adPublic = append(adPublic, subnet)
+			case public:
+				regionalPublic = append(regionalPublic, subnet)
+			default:
+				private = append(private, subnet)
+			}
+		}
+	}
+
+	if len(adPublic) > 0 {
+		return *adPublic[0].Id, nil
+	}
+	if len(regionalPublic) > 0 {
+		return *regionalPublic[0].Id, nil
+	}
+	if len(private) == 0 {
+		return "", fmt.Errorf("no available subnets found for %s in compartment %s. Please create a VCN and subnet first", availabilityDomain, p.compartmentID)
+	}
+
+	// Use the first private subnet and log a warning
+	fmt.Printf("Warning: No public subnet found for %s, using private subnet %s; the instance will have no public IP\n",
+		availabilityDomain, *private[0].Id)
+	return *private[0].Id, nil
+}
+
+// instanceIPs returns the public and private IPs of the instance's primary
+// VNIC. Lookup errors are ignored since the addresses are informational.
+func (p *Provider) instanceIPs(ctx context.Context, instanceID string) (publicIP, privateIP string) {
+	vnic, err := p.client.GetPrimaryVnic(ctx, p.compartmentID, instanceID)
+	if err != nil || vnic == nil {
+		return "", ""
+	}
+	if vnic.PublicIp != nil {
+		publicIP = *vnic.PublicIp
+	}
+	if vnic.PrivateIp != nil {
+		privateIP = *vnic.PrivateIp
+	}
+	return publicIP, privateIP
+}
+
+// startSpan starts a tracing span for an OCI provider operation
+func (p *Provider) startSpan(ctx context.Context, operation, instanceID string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		tracing.AttrProvider.String("oci"),
+		attribute.String("oci.region", p.region),
+	}
+	if instanceID != "" {
+		attrs = append(attrs, tracing.AttrInstanceID.String(instanceID))
+	}
+	return tracing.StartSpan(ctx, "oci."+operation, attrs...)
+}
+
+// instanceState maps an OCI lifecycle state to the states used by the
+// scheduler, which follow EC2's naming
+func instanceState(state core.InstanceLifecycleStateEnum) string {
+	switch state {
+	case core.InstanceLifecycleStateProvisioning, core.InstanceLifecycleStateStarting, core.InstanceLifecycleStateCreatingImage:
+		return "pending"
+	case core.InstanceLifecycleStateRunning:
+		return "running"
+	case core.InstanceLifecycleStateStopping:
+		return "stopping"
+	case core.InstanceLifecycleStateStopped:
+		return "stopped"
+	case core.InstanceLifecycleStateTerminating:
+		return "shutting-down"
+	case core.InstanceLifecycleStateTerminated:
+		return "terminated"
+	default:
+		return strings.ToLower(string(state))
+	}
+}
+
+// managedTags returns the freeform tags every managed instance carries, plus
+// the Session tag for instances created in a session
+func managedTags(duration time.Duration, expiresAt time.Time, session string) map[string]string {
+	tags := map[string]string{
+		"ManagedBy": managedBy,
+		"Duration":  duration.String(),
+		"ExpiresAt": expiresAt.UTC().Format(time.RFC3339),
+	}
+	if session != "" {
+		tags["Session"] = session
+	}
+	return tags
+}
@@ -0,0 +1,289 @@
+package oci_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/oci"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/core"
+	"github.com/oracle/oci-go-sdk/v65/identity"
+)
+
+// MockOCI implements the subset of the OCI APIs used by the provider
+type MockOCI struct {
+	domains   []string
+	vcns      []string
+	subnets   map[string][]core.Subnet
+	launches  []core.LaunchInstanceDetails
+	instances map[string]*core.Instance
+	vnics     map[string]*core.Vnic
+	actions   []core.InstanceActionActionEnum
+}
+
+func NewMockOCI() *MockOCI {
+	return &MockOCI{
+		domains: []string{"Uocm:PHX-AD-2", "Uocm:PHX-AD-1"},
+		vcns:    []string{"ocid1.vcn.oc1..a"},
+		subnets: map[string][]core.Subnet{
+			"ocid1.vcn.oc1..a": {
+				{Id: common.String("ocid1.subnet.oc1..regional"), ProhibitPublicIpOnVnic: common.Bool(false)},
+			},
+		},
+		instances: make(map[string]*core.Instance),
+		vnics:     make(map[string]*core.Vnic),
+	}
+}
+
+// serviceError is a minimal common.ServiceError
+type serviceError struct{ status int }
+
+func (e serviceError) Error() string           { return "service error" }
+func (e serviceError) GetHTTPStatusCode() int  { return e.status }
+func (e serviceError) GetMessage() string      { return "not found" }
+func (e serviceError) GetCode() string         { return "NotAuthorizedOrNotFound" }
+func (e serviceError) GetOpcRequestID() string { return "request" }
+
+func (m *MockOCI) ListAvailabilityDomains(ctx context.Context, compartmentID string) ([]identity.AvailabilityDomain, error) {
+	var domains []identity.AvailabilityDomain
+	for _, name := range m.domains {
+		domains = append(domains, identity.AvailabilityDomain{Name: common.String(name)})
+	}
+	return domains, nil
+}
+
+func (m *MockOCI) ListVcns(ctx context.Context, compartmentID string) ([]core.Vcn, error) {
+	var vcns []core.Vcn
+	for _, id := range m.vcns {
+		vcns = append(vcns, core.Vcn{Id: common.String(id)})
+	}
+	return vcns, nil
+}
+
+func (m *MockOCI) ListSubnets(ctx context.Context, compartmentID, vcnID string) ([]core.Subnet, error) {
+	return m.subnets[vcnID], nil
+}
+
+func (m *MockOCI) ListImages(ctx context.Context, compartmentID, shape string) ([]core.Image, error) {
+	return []core.Image{{Id: common.String("ocid1.image.oc1..ubuntu-" + shape)}}, nil
+}
+
+func (m *MockOCI) LaunchInstance(ctx context.Context, details core.LaunchInstanceDetails) (*core.Instance, error) {
+	m.launches = append(m.launches, details)
+	instance := &core.Instance{
+		Id:             common.String("ocid1.instance.oc1..new"),
+		LifecycleState: core.InstanceLifecycleStateProvisioning,
+	}
+	return instance, nil
+}
+
+func (m *MockOCI) GetInstance(ctx context.Context, instanceID string) (*core.Instance, error) {
+	instance, ok := m.instances[instanceID]
+	if !ok {
+		return nil, serviceError{status: http.StatusNotFound}
+	}
+	return instance, nil
+}
+
+func (m *MockOCI) InstanceAction(ctx context.Context, instanceID string, action core.InstanceActionActionEnum) error {
+	m.actions = append(m.actions, action)
+	return nil
+}
+
+func (m *MockOCI) TerminateInstance(ctx context.Context, instanceID string) error {
+	delete(m.instances, instanceID)
+	return nil
+}
+
+func (m *MockOCI) ListInstances(ctx context.Context, compartmentID string) ([]core.Instance, error) {
+	var instances []core.Instance
+	for _, instance := range m.instances {
+		instances = append(instances, *instance)
+	}
+	return instances, nil
+}
+
+func (m *MockOCI) GetPrimaryVnic(ctx context.Context, compartmentID, instanceID string) (*core.Vnic, error) {
+	return m.vnics[instanceID], nil
+}
+
+func writePublicKey(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "id_rsa.pub")
+	if err := os.WriteFile(path, []byte("ssh-rsa AAAAB3NzaC1yc2E test@example\n"), 0600); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+	return path
+}
+
+func TestCreateInstance(t *testing.T) {
+	mock := NewMockOCI()
+	provider := oci.NewProviderWithClient(mock, "ocid1.compartment.oc1..dev", "us-phoenix-1", "")
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		Duration:      2 * time.Hour,
+		PublicKeyPath: writePublicKey(t),
+		Session:       "exp-42",
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	if len(mock.launches) != 1 {
+		t.Fatalf("Expected 1 launch call, got %d", len(mock.launches))
+	}
+
+	details := mock.launches[0]
+	if *details.AvailabilityDomain != "Uocm:PHX-AD-1" {
+		t.Errorf("Expected the first availability domain, got %s", *details.AvailabilityDomain)
+	}
+	if *details.Shape != oci.DefaultShape || details.ShapeConfig != nil {
+		t.Errorf("Expected default fixed shape, got %s %+v", *details.Shape, details.ShapeConfig)
+	}
+	if *details.CreateVnicDetails.SubnetId != "ocid1.subnet.oc1..regional" {
+		t.Errorf("Expected the regional subnet, got %s", *details.CreateVnicDetails.SubnetId)
+	}
+	if details.Metadata["ssh_authorized_keys"] != "ssh-rsa AAAAB3NzaC1yc2E test@example" {
+		t.Errorf("Unexpected SSH key %q", details.Metadata["ssh_authorized_keys"])
+	}
+	tags := details.FreeformTags
+	if tags["ManagedBy"] != "instance-manager" || tags["Duration"] != "2h0m0s" || tags["Session"] != "exp-42" {
+		t.Errorf("Unexpected tags %v", tags)
+	}
+
+	if instance.ID != "ocid1.instance.oc1..new" || instance.State != "pending" || instance.Username != "ubuntu" || instance.Provider != "oci" {
+		t.Errorf("Unexpected instance %+v", instance)
+	}
+}
+
+func TestCreateInstance_AvailabilityDomainAndSubnet(t *testing.T) {
+	mock := NewMockOCI()
+	mock.vcns = append(mock.vcns, "ocid1.vcn.oc1..b")
+	mock.subnets["ocid1.vcn.oc1..b"] = []core.Subnet{
+		{Id: common.String("ocid1.subnet.oc1..ad1"), AvailabilityDomain: common.String("Uocm:PHX-AD-1")},
+		{Id: common.String("ocid1.subnet.oc1..ad2"), AvailabilityDomain: common.String("Uocm:PHX-AD-2")},
+	}
+	provider := oci.NewProviderWithClient(mock, "ocid1.compartment.oc1..dev", "us-phoenix-1", "")
+
+	_, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:     "VM.Standard.A1.Flex",
+		Duration:         time.Hour,
+		PublicKeyPath:    writePublicKey(t),
+		AvailabilityZone: "AD-2",
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	details := mock.launches[0]
+	if *details.AvailabilityDomain != "Uocm:PHX-AD-2" {
+		t.Errorf("Expected AD-2, got %s", *details.AvailabilityDomain)
+	}
+	if *details.CreateVnicDetails.SubnetId != "ocid1.subnet.oc1..ad2" {
+		t.Errorf("Expected the AD-2 public subnet to be preferred, got %s", *details.CreateVnicDetails.SubnetId)
+	}
+	if details.ShapeConfig == nil || *details.ShapeConfig.Ocpus != 1 {
+		t.Errorf("Expected a shape config for a flexible shape, got %+v", details.ShapeConfig)
+	}
+
+	_, err = provider.CreateInstance(context.Background(), models.InstanceConfig{
+		Duration:         time.Hour,
+		PublicKeyPath:    writePublicKey(t),
+		AvailabilityZone: "AD-3",
+	})
+	if err == nil {
+		t.Error("Expected an error for an unknown availability domain")
+	}
+}
+
+func TestCreateInstance_NoSubnet(t *testing.T) {
+	mock := NewMockOCI()
+	mock.vcns = nil
+	provider := oci.NewProviderWithClient(mock, "ocid1.compartment.oc1..dev", "us-phoenix-1", "")
+
+	_, err := provider.CreateInstance(context.Background(), models.InstanceConfig{Duration: time.Hour, PublicKeyPath: writePublicKey(t)})
+	if err == nil {
+		t.Fatal("Expected an error without any subnet")
+	}
+	if len(mock.launches) != 0 {
+		t.Error("Expected no launch without a subnet")
+	}
+}
+
+func TestGetInstanceStatus(t *testing.T) {
+	mock := NewMockOCI()
+	mock.instances["ocid1.instance.oc1..a"] = &core.Instance{
+		Id:             common.String("ocid1.instance.oc1..a"),
+		LifecycleState: core.InstanceLifecycleStateRunning,
+	}
+	mock.vnics["ocid1.instance.oc1..a"] = &core.Vnic{PublicIp: common.String("129.146.1.2"), PrivateIp: common.String("10.0.0.5")}
+	provider := oci.NewProviderWithClient(mock, "ocid1.compartment.oc1..dev", "us-phoenix-1", "")
+
+	status, err := provider.GetInstanceStatus(context.Background(), "ocid1.instance.oc1..a")
+	if err != nil {
+		t.Fatalf("GetInstanceStatus failed: %v", err)
+	}
+	if status.State != "running" || !status.Ready || status.PublicIP != "129.146.1.2" || status.PrivateIP != "10.0.0.5" {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	if _, err := provider.GetInstanceStatus(context.Background(), "ocid1.instance.oc1..missing"); err == nil || err.Error() != "instance not found" {
+		t.Errorf("Expected instance not found, got %v", err)
+	}
+}
+
+func TestListInstances(t *testing.T) {
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	mock := NewMockOCI()
+	mock.instances["managed"] = &core.Instance{
+		Id:                 common.String("managed"),
+		Shape:              common.String(oci.DefaultShape),
+		AvailabilityDomain: common.String("Uocm:PHX-AD-1"),
+		LifecycleState:     core.InstanceLifecycleStateStopped,
+		TimeCreated:        &common.SDKTime{Time: created},
+		FreeformTags:       map[string]string{"ManagedBy": "instance-manager", "Duration": "1h0m0s", "Session": "exp-42"},
+	}
+	mock.instances["terminated"] = &core.Instance{
+		Id:             common.String("terminated"),
+		LifecycleState: core.InstanceLifecycleStateTerminated,
+		FreeformTags:   map[string]string{"ManagedBy": "instance-manager"},
+	}
+	mock.instances["other"] = &core.Instance{Id: common.String("other"), LifecycleState: core.InstanceLifecycleStateRunning}
+	provider := oci.NewProviderWithClient(mock, "ocid1.compartment.oc1..dev", "us-phoenix-1", "")
+
+	instances, err := provider.ListInstances(context.Background())
+	if err != nil {
+		t.Fatalf("ListInstances failed: %v", err)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("Expected 1 managed instance, got %d", len(instances))
+	}
+
+	instance := instances[0]
+	if instance.ID != "managed" || instance.State != "stopped" || instance.Session != "exp-42" || instance.AvailabilityZone != "Uocm:PHX-AD-1" {
+		t.Errorf("Unexpected instance %+v", instance)
+	}
+	if !instance.ExpiresAt.Equal(created.Add(time.Hour)) {
+		t.Errorf("Expected expiry an hour after creation, got %v", instance.ExpiresAt)
+	}
+}
+
+func TestStartStopInstance(t *testing.T) {
+	mock := NewMockOCI()
+	provider := oci.NewProviderWithClient(mock, "ocid1.compartment.oc1..dev", "us-phoenix-1", "")
+
+	if err := provider.StopInstance(context.Background(), "ocid1.instance.oc1..a"); err != nil {
+		t.Fatalf("StopInstance failed: %v", err)
+	}
+	if err := provider.StartInstance(context.Background(), "ocid1.instance.oc1..a"); err != nil {
+		t.Fatalf("StartInstance failed: %v", err)
+	}
+	if len(mock.actions) != 2 || mock.actions[0] != core.InstanceActionActionStop || mock.actions[1] != core.InstanceActionActionStart {
+		t.Errorf("Unexpected actions %v", mock.actions)
+	}
+}
@@ -0,0 +1,109 @@
+// Package ratelimit bounds how fast clients may call the web server, with a
+// token bucket per client: each request takes a token, and tokens refill at
+// a steady rate up to a burst.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is the rate of a token bucket: PerMinute tokens refill every
+// minute, and the bucket holds up to Burst
+type Limit struct {
+	PerMinute float64
+	Burst     int
+}
+
+// Enabled reports whether the limit bounds anything; a zero rate or burst
+// turns it off
+func (l Limit) Enabled() bool {
+	return l.PerMinute > 0 && l.Burst > 0
+}
+
+// sweepInterval is how often idle buckets are dropped
+const sweepInterval = time.Minute
+
+// bucket is the token bucket of a client
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Limiter keeps a token bucket per client key
+type Limiter struct {
+	limit Limit
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// New returns a limiter of limit, or nil when the limit is off. A nil
+// limiter allows everything.
+func New(limit Limit) *Limiter {
+	if !limit.Enabled() {
+		return nil
+	}
+	return &Limiter{limit: limit, now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+// Limit returns the limit the limiter enforces
+func (l *Limiter) Limit() Limit {
+	if l == nil {
+		return Limit{}
+	}
+	return l.limit
+}
+
+// Allow takes a token from the bucket of key. When the bucket is empty it
+// returns false and how long until a token refills.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration(math.Ceil((1 - b.tokens) / l.perSecond() * float64(time.Second)))
+	return false, wait
+}
+
+// refill returns the tokens of a bucket at now
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.updated).Seconds()*l.perSecond()
+	return math.Min(tokens, float64(l.limit.Burst))
+}
+
+func (l *Limiter) perSecond() float64 {
+	return l.limit.PerMinute / 60
+}
+
+// sweep drops the buckets that refilled completely, which a new bucket
+// replaces alike, so idle clients take no memory
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := New(Limit{PerMinute: 60, Burst: 2})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	// A client spends its burst, then waits for a token to refill
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.Allow("alice"); !ok {
+			t.Fatalf("Expected request %d of the burst to be allowed", i+1)
+		}
+	}
+	if ok, wait := limiter.Allow("alice"); ok || wait != time.Second {
+		t.Errorf("Expected the third request to wait a second, got %v, %s", ok, wait)
+	}
+	if ok, _ := limiter.Allow("bob"); !ok {
+		t.Error("Expected another client to have its own bucket")
+	}
+	now = now.Add(500 * time.Millisecond)
+	if ok, wait := limiter.Allow("alice"); ok || wait != 500*time.Millisecond {
+		t.Errorf("Expected half a second left to wait, got %v, %s", ok, wait)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := limiter.Allow("alice"); !ok {
+		t.Error("Expected a refilled token to be allowed")
+	}
+
+	// Idle clients are dropped once their buckets refill
+	now = now.Add(time.Hour)
+	limiter.Allow("carol")
+	if len(limiter.buckets) != 1 {
+		t.Errorf("Expected the idle buckets to be dropped, got %d", len(limiter.buckets))
+	}
+
+	off := New(Limit{})
+	if ok, _ := off.Allow("alice"); off != nil || !ok {
+		t.Error("Expected a zero limit to allow everything")
+	}
+}
@@ -0,0 +1,87 @@
+package sso
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// githubProvider reads users and their organizations and teams from the
+// GitHub API. Their groups are their organizations, such as acme, and their
+// teams, such as acme/platform.
+type githubProvider struct {
+	baseURL string // Where users sign in, such as https://github.com
+	apiURL  string
+	client  *http.Client
+}
+
+// newGitHubProvider returns the provider of github.com, or of the GitHub
+// Enterprise server at baseURL
+func newGitHubProvider(baseURL string, client *http.Client) *githubProvider {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	if baseURL == "" || baseURL == "https://github.com" {
+		return &githubProvider{baseURL: "https://github.com", apiURL: "https://api.github.com", client: client}
+	}
+	return &githubProvider{baseURL: baseURL, apiURL: baseURL + "/api/v3", client: client}
+}
+
+// endpoint returns the OAuth2 endpoints of the server
+func (g *githubProvider) endpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:  g.baseURL + "/login/oauth/authorize",
+		TokenURL: g.baseURL + "/login/oauth/access_token",
+	}
+}
+
+// identity reads the user an access token belongs to
+func (g *githubProvider) identity(ctx context.Context, token *oauth2.Token) (*Identity, error) {
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := getJSON(ctx, g.client, g.apiURL+"/user", token.AccessToken, &user); err != nil {
+		return nil, fmt.Errorf("failed to read the GitHub user: %w", err)
+	}
+	if user.Login == "" {
+		return nil, fmt.Errorf("the GitHub API returned no user login")
+	}
+	identity := &Identity{Name: user.Login}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, g.client, g.apiURL+"/user/emails", token.AccessToken, &emails); err == nil {
+		for _, email := range emails {
+			if email.Primary && email.Verified {
+				identity.Email = email.Email
+			}
+		}
+	}
+
+	var orgs []struct {
+		Login string `json:"login"`
+	}
+	if err := getJSON(ctx, g.client, g.apiURL+"/user/orgs?per_page=100", token.AccessToken, &orgs); err != nil {
+		return nil, fmt.Errorf("failed to read the GitHub organizations of %s: %w", user.Login, err)
+	}
+	for _, org := range orgs {
+		identity.Groups = append(identity.Groups, org.Login)
+	}
+	var teams []struct {
+		Slug         string `json:"slug"`
+		Organization struct {
+			Login string `json:"login"`
+		} `json:"organization"`
+	}
+	if err := getJSON(ctx, g.client, g.apiURL+"/user/teams?per_page=100", token.AccessToken, &teams); err != nil {
+		return nil, fmt.Errorf("failed to read the GitHub teams of %s: %w", user.Login, err)
+	}
+	for _, team := range teams {
+		identity.Groups = append(identity.Groups, team.Organization.Login+"/"+team.Slug)
+	}
+	return identity, nil
+}
@@ -0,0 +1,297 @@
+package sso
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// discovery is the part of an OIDC discovery document the provider uses
+type discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	ScopesSupported       []string `json:"scopes_supported"`
+}
+
+// jwksRefreshInterval is how often keys are fetched again at most, when an
+// ID token is signed with a key not seen yet
+const jwksRefreshInterval = time.Minute
+
+// oidcProvider discovers an OIDC provider and verifies its ID tokens
+type oidcProvider struct {
+	issuer string
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]crypto.PublicKey // By key ID
+	fetchedAt time.Time
+}
+
+// discover returns the discovery document of the provider, fetching it
+// once
+func (o *oidcProvider) discover(ctx context.Context) (*discovery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+	var doc discovery
+	if err := getJSON(ctx, o.client, o.issuer+"/.well-known/openid-configuration", "", &doc); err != nil {
+		return nil, fmt.Errorf("failed to discover the OIDC provider %s: %w", o.issuer, err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != o.issuer {
+		return nil, fmt.Errorf("the OIDC provider at %s names another issuer: %s", o.issuer, doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("the OIDC provider %s lacks an authorization, token or key endpoint", o.issuer)
+	}
+	o.discovery = &doc
+	return o.discovery, nil
+}
+
+// identity verifies the ID token that came with token and reads the user
+// from its claims, asking the userinfo endpoint for groups it lacks
+func (o *oidcProvider) identity(ctx context.Context, token *oauth2.Token, config Config, nonce string) (*Identity, error) {
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, errors.New("the OIDC provider returned no ID token; is the openid scope granted?")
+	}
+	claims, err := o.verify(ctx, rawIDToken, config.ClientID, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	groupsClaim := config.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	if _, ok := claims[groupsClaim]; !ok && o.discovery.UserinfoEndpoint != "" {
+		var userinfo map[string]interface{}
+		if err := getJSON(ctx, o.client, o.discovery.UserinfoEndpoint, token.AccessToken, &userinfo); err == nil && userinfo["sub"] == claims["sub"] {
+			for key, value := range userinfo {
+				if _, ok := claims[key]; !ok {
+					claims[key] = value
+				}
+			}
+		}
+	}
+
+	identity := &Identity{Groups: stringList(claims[groupsClaim])}
+	if emailVerified(claims) {
+		identity.Email, _ = claims["email"].(string)
+	}
+	// Users name admins and own instances by their user name, so it only
+	// comes from a verified email or the subject: providers may let users
+	// set an unverified email or their preferred_username to anything
+	switch config.UsernameClaim {
+	case "":
+		identity.Name = identity.Email
+		if identity.Name == "" {
+			identity.Name, _ = claims["sub"].(string)
+		}
+	case "email":
+		identity.Name = identity.Email
+	default:
+		identity.Name, _ = claims[config.UsernameClaim].(string)
+	}
+	if identity.Name == "" {
+		claim := config.UsernameClaim
+		if claim == "" {
+			claim = "sub"
+		}
+		return nil, fmt.Errorf("the ID token has no %s claim naming the user", claim)
+	}
+	return identity, nil
+}
+
+// emailVerified reports whether the provider verified the email of the
+// claims. A missing email_verified claim counts as unverified; some
+// providers send it as a string.
+func emailVerified(claims jwt.MapClaims) bool {
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		return verified
+	case string:
+		return verified == "true"
+	}
+	return false
+}
+
+// verify checks the signature, issuer, audience, expiry and nonce of an ID
+// token and returns its claims
+func (o *oidcProvider) verify(ctx context.Context, rawIDToken, clientID, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return o.key(ctx, kid)
+	}, jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256"}),
+		jwt.WithIssuer(o.discovery.Issuer), jwt.WithAudience(clientID), jwt.WithExpirationRequired(), jwt.WithLeeway(time.Minute))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if claimed, _ := claims["nonce"].(string); claimed != nonce {
+		return nil, errors.New("invalid ID token: the nonce does not match")
+	}
+	return claims, nil
+}
+
+// key returns the signing key with an ID, fetching the keys of the provider
+// again when it is not known yet
+func (o *oidcProvider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if key, ok := o.lookupKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(o.fetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, o.client, o.discovery.JWKSURI, "", &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch the signing keys: %w", err)
+	}
+	o.keys = make(map[string]crypto.PublicKey, len(set.Keys))
+	o.fetchedAt = time.Now()
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			o.keys[jwk.Kid] = key
+		}
+	}
+	if key, ok := o.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey returns the known key with an ID, or the only key when the
+// token names none
+func (o *oidcProvider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(o.keys) == 1 {
+		for _, key := range o.keys {
+			return key, true
+		}
+	}
+	key, ok := o.keys[kid]
+	return key, ok
+}
+
+// jsonWebKey is a public key of a JWK set
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the RSA or ECDSA key of a JWK
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// getJSON decodes the JSON at url into v, authorized with a bearer token
+// unless it is empty
+func getJSON(ctx context.Context, client *http.Client, url, bearer string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// stringList returns the strings of a claim holding a list of them, or a
+// single one
+func stringList(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var list []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
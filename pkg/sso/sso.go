@@ -0,0 +1,208 @@
+// Package sso signs users in to the web server through an identity
+// provider: any OpenID Connect provider, such as Google Workspace or Okta,
+// or GitHub, which speaks plain OAuth2. The groups the provider reports map
+// to roles, so no password database is needed.
+package sso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/users"
+
+	"golang.org/x/oauth2"
+)
+
+// Kinds of identity providers
+const (
+	KindOIDC   = "oidc"
+	KindGitHub = "github"
+)
+
+// ErrNotAllowed is returned for users the configuration does not let in
+var ErrNotAllowed = errors.New("not allowed to sign in")
+
+// Config configures an identity provider
+type Config struct {
+	// Kind is KindOIDC or KindGitHub
+	Kind string
+	// Name is shown on the login button, such as Okta
+	Name string
+	// Issuer is the issuer URL of an OIDC provider, or the base URL of a
+	// GitHub Enterprise server; empty for GitHub means github.com
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback URL registered with the provider; empty
+	// derives it from each request
+	RedirectURL string
+	// Scopes replace the default scopes: openid, email, profile and groups
+	// for OIDC, read:user, user:email and read:org for GitHub
+	Scopes []string
+	// UsernameClaim names the ID token claim holding the user name; empty
+	// uses the email when verified, or else sub. The email is only used when
+	// verified, whatever the claim.
+	UsernameClaim string
+	// GroupsClaim names the claim holding the groups; empty uses groups
+	GroupsClaim string
+	// AllowedGroups, when set, lets only the members of one of them sign in
+	AllowedGroups []string
+	// AllowedDomains, when set, lets only users with a verified email in one
+	// of them sign in
+	AllowedDomains []string
+	// Roles lists, for each role, the groups whose members get it
+	Roles map[string][]string
+}
+
+// Identity is a user the provider signed in
+type Identity struct {
+	Name   string
+	Email  string
+	Groups []string
+}
+
+// Provider signs users in through an identity provider
+type Provider struct {
+	config Config
+	client *http.Client
+	oidc   *oidcProvider   // Set for OIDC providers
+	github *githubProvider // Set for GitHub
+}
+
+// New returns the provider of config. OIDC providers are discovered on
+// first use, so the web server starts while the provider is unreachable.
+func New(config Config) (*Provider, error) {
+	if config.ClientID == "" || config.ClientSecret == "" {
+		return nil, errors.New("an identity provider needs a client ID and secret")
+	}
+	p := &Provider{config: config, client: &http.Client{Timeout: 30 * time.Second}}
+	switch config.Kind {
+	case KindOIDC, "":
+		if config.Issuer == "" {
+			return nil, errors.New("an OIDC provider needs an issuer URL")
+		}
+		p.config.Kind = KindOIDC
+		p.oidc = &oidcProvider{issuer: strings.TrimSuffix(config.Issuer, "/"), client: p.client}
+	case KindGitHub:
+		p.github = newGitHubProvider(config.Issuer, p.client)
+	default:
+		return nil, fmt.Errorf("unknown identity provider %q: use %s or %s", config.Kind, KindOIDC, KindGitHub)
+	}
+	return p, nil
+}
+
+// Name returns the name of the provider shown to users
+func (p *Provider) Name() string {
+	if p.config.Name != "" {
+		return p.config.Name
+	}
+	if p.config.Kind == KindGitHub {
+		return "GitHub"
+	}
+	return "single sign-on"
+}
+
+// RedirectURL returns the configured callback URL, or "" to derive it from
+// requests
+func (p *Provider) RedirectURL() string {
+	return p.config.RedirectURL
+}
+
+// AuthCodeURL returns the URL that sends the browser to the provider to
+// sign in, carrying state and nonce, with verifier as the PKCE verifier
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURL, state, nonce, verifier string) (string, error) {
+	oauthConfig, err := p.oauthConfig(ctx, redirectURL)
+	if err != nil {
+		return "", err
+	}
+	opts := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}
+	if p.oidc != nil {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+	}
+	return oauthConfig.AuthCodeURL(state, opts...), nil
+}
+
+// Exchange trades the code the provider returned for the identity of the
+// user, checking the nonce of OIDC ID tokens
+func (p *Provider) Exchange(ctx context.Context, redirectURL, code, nonce, verifier string) (*Identity, error) {
+	oauthConfig, err := p.oauthConfig(ctx, redirectURL)
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange the authorization code: %w", err)
+	}
+	if p.github != nil {
+		return p.github.identity(ctx, token)
+	}
+	return p.oidc.identity(ctx, token, p.config, nonce)
+}
+
+// Authorize returns the most privileged role the groups of an identity
+// grant, or ErrNotAllowed when the allowed groups or domains leave it out.
+// Users in no role's groups get the empty role.
+func (p *Provider) Authorize(identity *Identity) (string, error) {
+	if len(p.config.AllowedDomains) > 0 {
+		_, domain, _ := strings.Cut(identity.Email, "@")
+		if !slices.ContainsFunc(p.config.AllowedDomains, func(allowed string) bool { return strings.EqualFold(allowed, domain) }) {
+			return "", fmt.Errorf("%w: %s is not in an allowed domain", ErrNotAllowed, identity.Name)
+		}
+	}
+	role := ""
+	for _, candidate := range users.Roles {
+		if inAny(identity.Groups, p.config.Roles[candidate]) {
+			role = candidate
+			break
+		}
+	}
+	if len(p.config.AllowedGroups) > 0 && role == "" && !inAny(identity.Groups, p.config.AllowedGroups) {
+		return "", fmt.Errorf("%w: %s is not in an allowed group", ErrNotAllowed, identity.Name)
+	}
+	return role, nil
+}
+
+// oauthConfig returns the OAuth2 configuration of the provider, with the
+// callback at redirectURL unless one is configured
+func (p *Provider) oauthConfig(ctx context.Context, redirectURL string) (*oauth2.Config, error) {
+	if p.config.RedirectURL != "" {
+		redirectURL = p.config.RedirectURL
+	}
+	config := &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       p.config.Scopes,
+	}
+	if p.github != nil {
+		config.Endpoint = p.github.endpoint()
+		if len(config.Scopes) == 0 {
+			config.Scopes = []string{"read:user", "user:email", "read:org"}
+		}
+		return config, nil
+	}
+
+	discovery, err := p.oidc.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config.Endpoint = oauth2.Endpoint{AuthURL: discovery.AuthorizationEndpoint, TokenURL: discovery.TokenEndpoint}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+		if slices.Contains(discovery.ScopesSupported, "groups") {
+			config.Scopes = append(config.Scopes, "groups")
+		}
+	}
+	return config, nil
+}
+
+// inAny reports whether any of groups is one of wanted
+func inAny(groups, wanted []string) bool {
+	return slices.ContainsFunc(groups, func(group string) bool { return slices.Contains(wanted, group) })
+}
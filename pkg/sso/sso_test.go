@@ -0,0 +1,226 @@
+package sso_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/sso"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeOIDC is an OIDC provider issuing ID tokens with claims
+type fakeOIDC struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	claims   jwt.MapClaims
+	verifier string // The PKCE verifier the token request carried
+}
+
+func newFakeOIDC(t *testing.T) *fakeOIDC {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate a key: %v", err)
+	}
+	f := &fakeOIDC{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+			"jwks_uri":               f.URL + "/keys",
+			"scopes_supported":       []string{"openid", "email", "groups"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		f.verifier = r.Form.Get("code_verifier")
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, f.claims)
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Errorf("Failed to sign the ID token: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "access", "token_type": "Bearer", "id_token": signed})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func TestOIDC(t *testing.T) {
+	idp := newFakeOIDC(t)
+	provider, err := sso.New(sso.Config{
+		Kind:          sso.KindOIDC,
+		Issuer:        idp.URL,
+		ClientID:      "instance-manager",
+		ClientSecret:  "secret",
+		AllowedGroups: []string{"engineering"},
+		Roles:         map[string][]string{"admin": {"platform"}},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx := context.Background()
+	redirect := "https://im.example.com/api/auth/oidc/callback"
+
+	authURL, err := provider.AuthCodeURL(ctx, redirect, "state-1", "nonce-1", "verifier-with-at-least-43-characters-0123456789")
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	parsed, _ := url.Parse(authURL)
+	query := parsed.Query()
+	if !strings.HasPrefix(authURL, idp.URL+"/authorize") || query.Get("state") != "state-1" || query.Get("nonce") != "nonce-1" ||
+		query.Get("code_challenge_method") != "S256" || query.Get("redirect_uri") != redirect || !strings.Contains(query.Get("scope"), "groups") {
+		t.Errorf("Unexpected authorization URL %s", authURL)
+	}
+
+	idp.claims = jwt.MapClaims{
+		"iss":            idp.URL,
+		"aud":            "instance-manager",
+		"sub":            "00u1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"groups":         []string{"engineering", "platform"},
+		"nonce":          "nonce-1",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+	identity, err := provider.Exchange(ctx, redirect, "code", "nonce-1", "verifier-with-at-least-43-characters-0123456789")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if identity.Name != "alice@example.com" || len(identity.Groups) != 2 || idp.verifier != "verifier-with-at-least-43-characters-0123456789" {
+		t.Errorf("Unexpected identity %+v (verifier %q)", identity, idp.verifier)
+	}
+	if role, err := provider.Authorize(identity); err != nil || role != "admin" {
+		t.Errorf("Expected the platform group to grant admin, got %q, %v", role, err)
+	}
+	if role, err := provider.Authorize(&sso.Identity{Name: "bob", Groups: []string{"engineering"}}); err != nil || role != "" {
+		t.Errorf("Expected engineering to sign in without a role, got %q, %v", role, err)
+	}
+	if _, err := provider.Authorize(&sso.Identity{Name: "mallory", Groups: []string{"sales"}}); !errors.Is(err, sso.ErrNotAllowed) {
+		t.Errorf("Expected ErrNotAllowed outside the allowed groups, got %v", err)
+	}
+
+	// An unverified email, or a missing email_verified claim, never names the
+	// user; neither does preferred_username, which users may set themselves
+	saved := idp.claims
+	for name, verified := range map[string]interface{}{"unverified": false, "unstated": nil} {
+		claims := jwt.MapClaims{"preferred_username": "admin"}
+		for k, v := range saved {
+			claims[k] = v
+		}
+		claims["email"] = "root@example.com"
+		if verified == nil {
+			delete(claims, "email_verified")
+		} else {
+			claims["email_verified"] = verified
+		}
+		idp.claims = claims
+		identity, err := provider.Exchange(ctx, redirect, "code", "nonce-1", "verifier-with-at-least-43-characters-0123456789")
+		if err != nil {
+			t.Fatalf("Exchange failed: %v", err)
+		}
+		if identity.Name != "00u1" || identity.Email != "" {
+			t.Errorf("Expected an %s email to leave the user named by sub, got %+v", name, identity)
+		}
+	}
+	idp.claims = saved
+
+	// ID tokens with another nonce, audience or issuer, or expired, are refused
+	for name, change := range map[string]func(jwt.MapClaims){
+		"nonce":    func(c jwt.MapClaims) { c["nonce"] = "replayed" },
+		"audience": func(c jwt.MapClaims) { c["aud"] = "another-client" },
+		"issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"expired":  func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+	} {
+		claims := jwt.MapClaims{}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		change(claims)
+		saved := idp.claims
+		idp.claims = claims
+		if _, err := provider.Exchange(ctx, redirect, "code", "nonce-1", "verifier-with-at-least-43-characters-0123456789"); err == nil {
+			t.Errorf("Expected an ID token with a bad %s to be refused", name)
+		}
+		idp.claims = saved
+	}
+}
+
+func TestGitHub(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "gho_test", "token_type": "bearer"}`))
+	})
+	mux.HandleFunc("/api/v3/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gho_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"login": "octocat"}`))
+	})
+	mux.HandleFunc("/api/v3/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"email": "octocat@example.com", "primary": true, "verified": true}]`))
+	})
+	mux.HandleFunc("/api/v3/user/orgs", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"login": "acme"}]`))
+	})
+	mux.HandleFunc("/api/v3/user/teams", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"slug": "platform", "organization": {"login": "acme"}}]`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider, err := sso.New(sso.Config{
+		Kind:           sso.KindGitHub,
+		Issuer:         server.URL,
+		ClientID:       "client",
+		ClientSecret:   "secret",
+		AllowedDomains: []string{"example.com"},
+		Roles:          map[string][]string{"admin": {"acme/platform"}},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if provider.Name() != "GitHub" {
+		t.Errorf("Expected the provider to be named GitHub, got %q", provider.Name())
+	}
+	identity, err := provider.Exchange(context.Background(), "http://localhost/callback", "code", "", "verifier")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if identity.Name != "octocat" || identity.Email != "octocat@example.com" || strings.Join(identity.Groups, ",") != "acme,acme/platform" {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+	if role, err := provider.Authorize(identity); err != nil || role != "admin" {
+		t.Errorf("Expected the acme/platform team to grant admin, got %q, %v", role, err)
+	}
+	identity.Email = "octocat@elsewhere.com"
+	if _, err := provider.Authorize(identity); !errors.Is(err, sso.ErrNotAllowed) {
+		t.Errorf("Expected ErrNotAllowed outside the allowed domains, got %v", err)
+	}
+}
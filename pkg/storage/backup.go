@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/models"
+)
+
+// backupEntry is the name of the storage contents within a backup archive
+const backupEntry = "instances.json"
+
+// backupPrefix and backupExt name the backups written by BackupToDir, which
+// sort by the time they were taken
+const (
+	backupPrefix = "instances-"
+	backupExt    = ".tar.gz"
+)
+
+// WriteBackup writes the contents of a storage to w as a gzip-compressed tar
+// archive holding them as JSON. With a key the archive is encrypted like an
+// encrypted storage file.
+func WriteBackup(w io.Writer, record *StorageRecord, key []byte) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal storage data: %w", err)
+	}
+
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	header := &tar.Header{
+		Name:    backupEntry,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: record.UpdatedAt,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	out := archive.Bytes()
+	if key != nil {
+		out, err = encrypt(key, out)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt backup: %w", err)
+		}
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// ReadBackup reads the contents of a storage from a backup written by
+// WriteBackup. An encrypted backup needs the key it was written with.
+func ReadBackup(r io.Reader, key []byte) (*StorageRecord, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if isEncrypted(data) {
+		if key == nil {
+			return nil, ErrEncrypted
+		}
+		data, err = decrypt(key, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt backup: %w", err)
+		}
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("not a backup archive: %s is missing", backupEntry)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("not a backup archive: %w", err)
+		}
+		if header.Name != backupEntry {
+			continue
+		}
+		var record StorageRecord
+		if err := json.NewDecoder(tr).Decode(&record); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal backup: %w", err)
+		}
+		if record.Instances == nil {
+			record.Instances = make(map[string]*models.InstanceRecord)
+		}
+		return &record, nil
+	}
+}
+
+// Restore writes the instances, archived records, snapshots and images of a
+// backup to store, replacing the records with the same IDs and keeping the
+// others. The scheduler's state is not restored, so a pause in effect when
+// the backup was taken does not come back. Restored archive entries are
+// dated by the restore.
+func Restore(store Storage, backup *StorageRecord) error {
+	ids := make([]string, 0, len(backup.Instances))
+	for id := range backup.Instances {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		instance := backup.Instances[id].Instance
+		instance.Revision = 0
+		if err := store.SaveInstance(instance); err != nil {
+			return fmt.Errorf("failed to restore instance %s: %w", id, err)
+		}
+	}
+
+	archived, err := store.ListArchived()
+	if err != nil {
+		return err
+	}
+	isArchived := make(map[string]bool, len(archived))
+	for _, record := range archived {
+		isArchived[record.Instance.ID] = true
+	}
+	for _, record := range backup.Archived {
+		if isArchived[record.Instance.ID] || backup.Instances[record.Instance.ID] != nil {
+			continue
+		}
+		record.Instance.Revision = 0
+		if err := store.SaveInstance(record.Instance); err != nil {
+			return fmt.Errorf("failed to restore archived instance %s: %w", record.Instance.ID, err)
+		}
+		if err := store.ArchiveInstance(record.Instance.ID, record.Reason); err != nil {
+			return fmt.Errorf("failed to restore archived instance %s: %w", record.Instance.ID, err)
+		}
+	}
+
+	snapshots, err := store.ListSnapshots()
+	if err != nil {
+		return err
+	}
+	recorded := make(map[string]bool, len(snapshots))
+	for _, snapshot := range snapshots {
+		recorded[snapshot.SnapshotID] = true
+	}
+	for _, snapshot := range backup.Snapshots {
+		if recorded[snapshot.SnapshotID] {
+			continue
+		}
+		if err := store.RecordSnapshot(snapshot); err != nil {
+			return fmt.Errorf("failed to restore snapshot %s: %w", snapshot.SnapshotID, err)
+		}
+	}
+
+	images, err := store.ListImages()
+	if err != nil {
+		return err
+	}
+	recorded = make(map[string]bool, len(images))
+	for _, image := range images {
+		recorded[image.ImageID] = true
+	}
+	for _, image := range backup.Images {
+		if recorded[image.ImageID] {
+			continue
+		}
+		if err := store.RecordImage(image); err != nil {
+			return fmt.Errorf("failed to restore image %s: %w", image.ImageID, err)
+		}
+	}
+	return nil
+}
+
+// BackupToDir writes a backup of store into dir, named after the time it was
+// taken, and removes the oldest backups beyond keep; zero keeps them all. It
+// returns the path of the new backup.
+func BackupToDir(store Storage, dir string, keep int, key []byte, now time.Time) (string, error) {
+	record, err := store.Snapshot()
+	if err != nil {
+		return "", fmt.Errorf("failed to read storage: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteBackup(&buf, record, key); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, backupPrefix+now.UTC().Format("20060102T150405Z")+backupExt)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	if keep > 0 {
+		if err := rotateBackups(dir, keep); err != nil {
+			return path, fmt.Errorf("failed to remove old backups: %w", err)
+		}
+	}
+	return path, nil
+}
+
+// rotateBackups removes the oldest backups in dir beyond keep
+func rotateBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupExt) {
+			backups = append(backups, name)
+		}
+	}
+	// ReadDir sorts by name, which is the order the backups were taken in
+	for len(backups) > keep {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
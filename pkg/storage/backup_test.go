@@ -0,0 +1,128 @@
+package storage_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/storage"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	source := storage.NewFileStorage(filepath.Join(dir, "instances.json"))
+	if err := source.SaveInstance(&models.Instance{ID: "i-1", Name: "build-box", PublicIP: "203.0.113.7"}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+	if err := source.SaveInstance(&models.Instance{ID: "i-2", State: "terminated"}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+	if err := source.ArchiveInstance("i-2", models.ArchiveReasonTerminated); err != nil {
+		t.Fatalf("ArchiveInstance failed: %v", err)
+	}
+	if err := source.RecordSnapshot(&models.SnapshotRecord{SnapshotID: "snap-1", InstanceID: "i-1"}); err != nil {
+		t.Fatalf("RecordSnapshot failed: %v", err)
+	}
+	if err := source.PauseScheduler(&models.SchedulerPause{PausedAt: time.Now()}); err != nil {
+		t.Fatalf("PauseScheduler failed: %v", err)
+	}
+
+	record, err := source.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := storage.WriteBackup(&buf, record, nil); err != nil {
+		t.Fatalf("WriteBackup failed: %v", err)
+	}
+	backup, err := storage.ReadBackup(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatalf("ReadBackup failed: %v", err)
+	}
+
+	target := storage.NewFileStorage(filepath.Join(dir, "restored.json"))
+	if err := storage.Restore(target, backup); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	// Restoring twice does not duplicate records
+	if err := storage.Restore(target, backup); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	if instance, err := target.GetInstance("i-1"); err != nil || instance.PublicIP != "203.0.113.7" {
+		t.Errorf("Expected i-1 to be restored, got %+v, %v", instance, err)
+	}
+	if archived, err := target.ListArchived(); err != nil || len(archived) != 1 || archived[0].Instance.ID != "i-2" {
+		t.Errorf("Expected the archived i-2 to be restored, got %v, %v", archived, err)
+	}
+	if snapshots, err := target.ListSnapshots(); err != nil || len(snapshots) != 1 {
+		t.Errorf("Expected one snapshot to be restored, got %v, %v", snapshots, err)
+	}
+	if pause, err := target.SchedulerPause(); err != nil || pause != nil {
+		t.Errorf("Expected the pause not to be restored, got %v, %v", pause, err)
+	}
+
+	if _, err := storage.ReadBackup(bytes.NewReader([]byte("{}")), nil); err == nil {
+		t.Error("Expected an error reading something other than a backup")
+	}
+}
+
+func TestBackup_Encrypted(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, storage.EncryptionKeySize)
+	record := &storage.StorageRecord{Instances: map[string]*models.InstanceRecord{
+		"i-1": {Instance: &models.Instance{ID: "i-1", PublicIP: "203.0.113.7"}},
+	}}
+
+	var buf bytes.Buffer
+	if err := storage.WriteBackup(&buf, record, key); err != nil {
+		t.Fatalf("WriteBackup failed: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("i-1")) {
+		t.Error("Expected the backup to be encrypted")
+	}
+	if _, err := storage.ReadBackup(bytes.NewReader(buf.Bytes()), nil); !errors.Is(err, storage.ErrEncrypted) {
+		t.Errorf("Expected ErrEncrypted without a key, got %v", err)
+	}
+	backup, err := storage.ReadBackup(bytes.NewReader(buf.Bytes()), key)
+	if err != nil || backup.Instances["i-1"] == nil {
+		t.Errorf("Expected the backup to be read with its key, got %v, %v", backup, err)
+	}
+}
+
+func TestBackupToDir_Rotates(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewFileStorage(filepath.Join(dir, "instances.json"))
+	if err := store.SaveInstance(&models.Instance{ID: "i-1"}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+
+	backups := filepath.Join(dir, "backups")
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var paths []string
+	for i := 0; i < 4; i++ {
+		path, err := storage.BackupToDir(store, backups, 2, nil, start.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatalf("BackupToDir failed: %v", err)
+		}
+		paths = append(paths, path)
+	}
+	if filepath.Base(paths[0]) != "instances-20260102T030405Z.tar.gz" {
+		t.Errorf("Unexpected backup name %s", paths[0])
+	}
+
+	entries, err := os.ReadDir(backups)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name() != filepath.Base(paths[2]) || entries[1].Name() != filepath.Base(paths[3]) {
+		t.Errorf("Expected the two newest backups to be kept, got %v", entries)
+	}
+	info, err := os.Stat(paths[3])
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the backup to be readable by its owner only, got %v, %v", info.Mode(), err)
+	}
+}
@@ -0,0 +1,723 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"instance-manager/pkg/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Keys of the items a DynamoDBStorage keeps in its table, each under the
+// partition key pk
+const (
+	dynamoInstancePrefix = "instance#"
+	dynamoArchivedPrefix = "archived#"
+	dynamoSnapshotPrefix = "snapshot#"
+	dynamoImagePrefix    = "image#"
+	dynamoLastRunKey     = "state#last_run"
+	dynamoPauseKey       = "state#pause"
+)
+
+// dynamoRequestTimeout bounds each request to DynamoDB
+const dynamoRequestTimeout = 30 * time.Second
+
+// dynamoConflictRetries is how often an unconditional write is retried when
+// another process wrote the same record in between
+const dynamoConflictRetries = 5
+
+// DynamoDBOptions configures a DynamoDBStorage
+type DynamoDBOptions struct {
+	Table    string
+	Region   string
+	Endpoint string // Overrides the regional endpoint, e.g. for DynamoDB Local
+	Profile  string // Shared config profile to take credentials from
+	// CreateTable creates the table, with on-demand capacity, when missing
+	CreateTable bool
+	// Retention makes DynamoDB delete the records of terminated instances
+	// and archived records this long after they were terminated or
+	// archived, through the ttl attribute. Zero keeps them.
+	Retention time.Duration
+	// Credentials overrides the default AWS credential chain
+	Credentials aws.CredentialsProvider
+}
+
+// DynamoDBStorage keeps instance records in a DynamoDB table, so the service
+// and the web server can run on several hosts sharing state. Each record is
+// an item whose data attribute holds its JSON. Instance records carry a
+// version attribute: updates are conditional on it, so an update of an
+// instance that another process changed since it was read fails with
+// ErrConflict instead of overwriting the change.
+type DynamoDBStorage struct {
+	client      *dynamodb.Client
+	table       string
+	region      string
+	createTable bool
+	retention   time.Duration
+
+	mu    sync.Mutex
+	ready bool // The table was found or created
+	feed  changeFeed
+}
+
+// dynamoItem is a DynamoDB item. Only string (S) and number (N) attributes
+// are used, so items are built by hand rather than marshalled.
+type dynamoItem = map[string]types.AttributeValue
+
+// NewDynamoDBStorage creates a storage backed by the DynamoDB table in
+// opts. The table is checked, and created if requested, on first use.
+func NewDynamoDBStorage(ctx context.Context, opts DynamoDBOptions) (*DynamoDBStorage, error) {
+	if opts.Table == "" {
+		return nil, errors.New("a DynamoDB table name is required")
+	}
+
+	cfg := aws.Config{Region: opts.Region, Credentials: opts.Credentials}
+	if cfg.Credentials == nil || cfg.Region == "" {
+		loadOpts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(opts.Region)}
+		if opts.Profile != "" {
+			loadOpts = append(loadOpts, awsconfig.WithSharedConfigProfile(opts.Profile))
+		}
+		loaded, err := awsconfig.LoadDefaultConfig(ctx, loadOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+		}
+		if opts.Credentials != nil {
+			loaded.Credentials = opts.Credentials
+		}
+		cfg = loaded
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("a region is required for the DynamoDB storage")
+	}
+
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+		}
+	})
+	return &DynamoDBStorage{
+		client:      client,
+		table:       opts.Table,
+		region:      cfg.Region,
+		createTable: opts.CreateTable,
+		retention:   opts.Retention,
+	}, nil
+}
+
+// SaveInstance stores an instance record, replacing any with the same ID
+func (d *DynamoDBStorage) SaveInstance(instance *models.Instance) error {
+	now := time.Now()
+	record := &models.InstanceRecord{Instance: instance, CreatedAt: now, UpdatedAt: now}
+	version, err := d.putInstance(record, 0, false)
+	if err != nil {
+		return err
+	}
+	instance.Revision = version
+	d.feed.changed()
+	return nil
+}
+
+// GetInstance returns the record of an instance
+func (d *DynamoDBStorage) GetInstance(instanceID string) (*models.Instance, error) {
+	record, _, err := d.getInstanceRecord(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("instance %s not found", instanceID)
+	}
+	return record.Instance, nil
+}
+
+// UpdateInstance replaces the record of a stored instance. When the
+// instance carries a revision, the update fails with ErrConflict if the
+// record changed since.
+func (d *DynamoDBStorage) UpdateInstance(instance *models.Instance) error {
+	for attempt := 0; ; attempt++ {
+		record, version, err := d.getInstanceRecord(instance.ID)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return fmt.Errorf("instance %s not found", instance.ID)
+		}
+		if instance.Revision != 0 && instance.Revision != version {
+			return fmt.Errorf("failed to update instance %s: %w", instance.ID, ErrConflict)
+		}
+
+		record.Instance = instance
+		record.UpdatedAt = time.Now()
+		newVersion, err := d.putInstance(record, version, true)
+		if errors.Is(err, ErrConflict) && instance.Revision == 0 && attempt < dynamoConflictRetries {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update instance %s: %w", instance.ID, err)
+		}
+		instance.Revision = newVersion
+		d.feed.changed()
+		return nil
+	}
+}
+
+// DeleteInstance removes the record of an instance
+func (d *DynamoDBStorage) DeleteInstance(instanceID string) error {
+	if err := d.deleteItem(dynamoInstancePrefix + instanceID); err != nil {
+		return err
+	}
+	d.feed.changed()
+	return nil
+}
+
+// ListInstances returns all stored instances
+func (d *DynamoDBStorage) ListInstances() ([]*models.Instance, error) {
+	records, err := d.instanceRecords()
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]*models.Instance, 0, len(records))
+	for _, record := range records {
+		instances = append(instances, record.Instance)
+	}
+	return instances, nil
+}
+
+// ListInstancesFiltered returns the stored instances the filter matches
+func (d *DynamoDBStorage) ListInstancesFiltered(filter models.InstanceFilter) ([]*models.Instance, error) {
+	instances, err := d.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+	return filter.Filter(instances), nil
+}
+
+// FindByName returns the stored instances with the given name
+func (d *DynamoDBStorage) FindByName(name string) ([]*models.Instance, error) {
+	instances, err := d.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+	return namedInstances(instances, name), nil
+}
+
+// GetExpiredInstances returns instances that have exceeded their duration
+func (d *DynamoDBStorage) GetExpiredInstances() ([]*models.Instance, error) {
+	records, err := d.instanceRecords()
+	if err != nil {
+		return nil, err
+	}
+	return expiredInstances(records), nil
+}
+
+// GetTerminatedBefore returns terminated instances whose termination
+// happened before cutoff
+func (d *DynamoDBStorage) GetTerminatedBefore(cutoff time.Time) ([]*models.Instance, error) {
+	records, err := d.instanceRecords()
+	if err != nil {
+		return nil, err
+	}
+	return terminatedBefore(records, cutoff), nil
+}
+
+// ArchiveInstance moves an instance record to the archive in a single
+// transaction, noting why it was archived
+func (d *DynamoDBStorage) ArchiveInstance(instanceID, reason string) error {
+	for attempt := 0; ; attempt++ {
+		record, version, err := d.getInstanceRecord(instanceID)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return fmt.Errorf("instance %s not found", instanceID)
+		}
+
+		archived := &models.ArchivedInstance{
+			Instance:   record.Instance,
+			Reason:     reason,
+			ArchivedAt: time.Now(),
+		}
+		item, err := d.dataItem(fmt.Sprintf("%s%020d#%s", dynamoArchivedPrefix, archived.ArchivedAt.UnixNano(), instanceID), archived)
+		if err != nil {
+			return err
+		}
+		d.setTTL(item, archived.ArchivedAt)
+
+		err = d.call(func(ctx context.Context) error {
+			_, err := d.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+				TransactItems: []types.TransactWriteItem{
+					{Put: &types.Put{
+						TableName: aws.String(d.table),
+						Item:      item,
+					}},
+					{Delete: &types.Delete{
+						TableName:                 aws.String(d.table),
+						Key:                       dynamoKey(dynamoInstancePrefix + instanceID),
+						ConditionExpression:       aws.String("#version = :version"),
+						ExpressionAttributeNames:  map[string]string{"#version": "version"},
+						ExpressionAttributeValues: dynamoItem{":version": dynamoNumber(version)},
+					}},
+				},
+			})
+			return err
+		})
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && attempt < dynamoConflictRetries {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to archive instance %s: %w", instanceID, err)
+		}
+		d.feed.changed()
+		return nil
+	}
+}
+
+// ListArchived returns the archived instance records, oldest first
+func (d *DynamoDBStorage) ListArchived() ([]*models.ArchivedInstance, error) {
+	var archived []*models.ArchivedInstance
+	if err := d.scanData(dynamoArchivedPrefix, func(data []byte) error {
+		var record models.ArchivedInstance
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		archived = append(archived, &record)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(archived, func(i, j int) bool {
+		return archived[i].ArchivedAt.Before(archived[j].ArchivedAt)
+	})
+	return archived, nil
+}
+
+// RecordSnapshot stores a record of a volume snapshot
+func (d *DynamoDBStorage) RecordSnapshot(snapshot *models.SnapshotRecord) error {
+	return d.putData(dynamoSnapshotPrefix+snapshot.SnapshotID, snapshot)
+}
+
+// ListSnapshots returns the recorded snapshots, oldest first
+func (d *DynamoDBStorage) ListSnapshots() ([]*models.SnapshotRecord, error) {
+	var snapshots []*models.SnapshotRecord
+	if err := d.scanData(dynamoSnapshotPrefix, func(data []byte) error {
+		var snapshot models.SnapshotRecord
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return err
+		}
+		snapshots = append(snapshots, &snapshot)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// DeleteSnapshot removes the record of a snapshot
+func (d *DynamoDBStorage) DeleteSnapshot(snapshotID string) error {
+	return d.deleteItem(dynamoSnapshotPrefix + snapshotID)
+}
+
+// RecordImage stores a record of a machine image
+func (d *DynamoDBStorage) RecordImage(image *models.ImageRecord) error {
+	return d.putData(dynamoImagePrefix+image.ImageID, image)
+}
+
+// ListImages returns the recorded machine images, oldest first
+func (d *DynamoDBStorage) ListImages() ([]*models.ImageRecord, error) {
+	var images []*models.ImageRecord
+	if err := d.scanData(dynamoImagePrefix, func(data []byte) error {
+		var image models.ImageRecord
+		if err := json.Unmarshal(data, &image); err != nil {
+			return err
+		}
+		images = append(images, &image)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].CreatedAt.Before(images[j].CreatedAt)
+	})
+	return images, nil
+}
+
+// RecordSchedulerRun stores the report of the latest scheduler pass
+func (d *DynamoDBStorage) RecordSchedulerRun(run *models.SchedulerRun) error {
+	return d.putData(dynamoLastRunKey, run)
+}
+
+// LastSchedulerRun returns the report of the latest scheduler pass, or nil
+// when the scheduler has not run yet
+func (d *DynamoDBStorage) LastSchedulerRun() (*models.SchedulerRun, error) {
+	var run *models.SchedulerRun
+	if _, err := d.getData(dynamoLastRunKey, &run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// PauseScheduler suspends the scheduler's lifecycle actions
+func (d *DynamoDBStorage) PauseScheduler(pause *models.SchedulerPause) error {
+	return d.putData(dynamoPauseKey, pause)
+}
+
+// ResumeScheduler ends a pause, reporting false when there was none
+func (d *DynamoDBStorage) ResumeScheduler() (bool, error) {
+	pause, err := d.SchedulerPause()
+	if err != nil || pause == nil {
+		return false, err
+	}
+	return true, d.deleteItem(dynamoPauseKey)
+}
+
+// SchedulerPause returns the pause in effect, or nil
+func (d *DynamoDBStorage) SchedulerPause() (*models.SchedulerPause, error) {
+	var pause *models.SchedulerPause
+	if _, err := d.getData(dynamoPauseKey, &pause); err != nil {
+		return nil, err
+	}
+	if !pause.Active(time.Now()) {
+		return nil, nil
+	}
+	return pause, nil
+}
+
+// Watch reports changes to the instance records, including those made by
+// other processes, until ctx is done
+func (d *DynamoDBStorage) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	return d.feed.watch(ctx, d.instanceRecords)
+}
+
+// Snapshot returns the full contents of the table
+func (d *DynamoDBStorage) Snapshot() (*StorageRecord, error) {
+	record := &StorageRecord{UpdatedAt: time.Now()}
+	var err error
+	if record.Instances, err = d.instanceRecords(); err != nil {
+		return nil, err
+	}
+	if record.Snapshots, err = d.ListSnapshots(); err != nil {
+		return nil, err
+	}
+	if record.Images, err = d.ListImages(); err != nil {
+		return nil, err
+	}
+	if record.Archived, err = d.ListArchived(); err != nil {
+		return nil, err
+	}
+	if record.LastRun, err = d.LastSchedulerRun(); err != nil {
+		return nil, err
+	}
+	if record.Pause, err = d.SchedulerPause(); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Location returns the table and its region
+func (d *DynamoDBStorage) Location() string {
+	return fmt.Sprintf("dynamodb://%s (%s)", d.table, d.region)
+}
+
+// getInstanceRecord returns the record of an instance and its version, or
+// nil when it is not stored
+func (d *DynamoDBStorage) getInstanceRecord(instanceID string) (*models.InstanceRecord, int64, error) {
+	var record *models.InstanceRecord
+	item, err := d.getData(dynamoInstancePrefix+instanceID, &record)
+	if err != nil || record == nil {
+		return nil, 0, err
+	}
+	version := itemVersion(item)
+	record.Instance.Revision = version
+	return record, version, nil
+}
+
+// instanceRecords returns all instance records keyed by instance ID
+func (d *DynamoDBStorage) instanceRecords() (map[string]*models.InstanceRecord, error) {
+	records := make(map[string]*models.InstanceRecord)
+	err := d.scan(dynamoInstancePrefix, func(item dynamoItem) error {
+		var record models.InstanceRecord
+		if err := json.Unmarshal([]byte(itemString(item, "data")), &record); err != nil {
+			return err
+		}
+		record.Instance.Revision = itemVersion(item)
+		records[record.Instance.ID] = &record
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// putInstance writes an instance record with the version after version. An
+// existing record is only replaced when it still has version, and with
+// mustExist a missing one is not created; otherwise ErrConflict is returned.
+func (d *DynamoDBStorage) putInstance(record *models.InstanceRecord, version int64, mustExist bool) (int64, error) {
+	for attempt := 0; ; attempt++ {
+		if !mustExist {
+			// Replace whatever is stored
+			_, current, err := d.getInstanceRecord(record.Instance.ID)
+			if err != nil {
+				return 0, err
+			}
+			version = current
+		}
+
+		item, err := d.dataItem(dynamoInstancePrefix+record.Instance.ID, record)
+		if err != nil {
+			return 0, err
+		}
+		item["version"] = dynamoNumber(version + 1)
+		if !record.Instance.ExpiresAt.IsZero() {
+			item["expires_at"] = dynamoNumber(record.Instance.ExpiresAt.Unix())
+		}
+		if record.Instance.State == "terminated" {
+			terminatedAt := record.Instance.TerminatedAt
+			if terminatedAt.IsZero() {
+				terminatedAt = record.UpdatedAt
+			}
+			d.setTTL(item, terminatedAt)
+		}
+
+		input := &dynamodb.PutItemInput{
+			TableName: aws.String(d.table),
+			Item:      item,
+		}
+		if version == 0 {
+			input.ConditionExpression = aws.String("attribute_not_exists(pk)")
+		} else {
+			input.ConditionExpression = aws.String("#version = :version")
+			input.ExpressionAttributeNames = map[string]string{"#version": "version"}
+			input.ExpressionAttributeValues = dynamoItem{":version": dynamoNumber(version)}
+		}
+		err = d.call(func(ctx context.Context) error {
+			_, err := d.client.PutItem(ctx, input)
+			return err
+		})
+		var failed *types.ConditionalCheckFailedException
+		if errors.As(err, &failed) {
+			if !mustExist && attempt < dynamoConflictRetries {
+				continue
+			}
+			return 0, ErrConflict
+		}
+		if err != nil {
+			return 0, err
+		}
+		return version + 1, nil
+	}
+}
+
+// setTTL makes DynamoDB delete item the retention period after from
+func (d *DynamoDBStorage) setTTL(item dynamoItem, from time.Time) {
+	if d.retention > 0 {
+		item["ttl"] = dynamoNumber(from.Add(d.retention).Unix())
+	}
+}
+
+// dataItem returns an item with key pk holding value as JSON
+func (d *DynamoDBStorage) dataItem(pk string, value interface{}) (dynamoItem, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal record: %w", err)
+	}
+	return dynamoItem{
+		"pk":   &types.AttributeValueMemberS{Value: pk},
+		"data": &types.AttributeValueMemberS{Value: string(data)},
+	}, nil
+}
+
+// putData stores value as JSON under key pk, replacing what is there
+func (d *DynamoDBStorage) putData(pk string, value interface{}) error {
+	item, err := d.dataItem(pk, value)
+	if err != nil {
+		return err
+	}
+	return d.call(func(ctx context.Context) error {
+		_, err := d.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(d.table), Item: item})
+		return err
+	})
+}
+
+// getData reads the JSON stored under key pk into value, leaving it alone
+// when the item does not exist, and returns the item
+func (d *DynamoDBStorage) getData(pk string, value interface{}) (dynamoItem, error) {
+	var item dynamoItem
+	err := d.call(func(ctx context.Context) error {
+		output, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(d.table),
+			Key:            dynamoKey(pk),
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+		item = output.Item
+		return nil
+	})
+	if err != nil || item == nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(itemString(item, "data")), value); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal record %s: %w", pk, err)
+	}
+	return item, nil
+}
+
+// deleteItem removes the item with key pk
+func (d *DynamoDBStorage) deleteItem(pk string) error {
+	return d.call(func(ctx context.Context) error {
+		_, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(d.table), Key: dynamoKey(pk)})
+		return err
+	})
+}
+
+// scanData calls fn with the JSON of every item whose key starts with prefix
+func (d *DynamoDBStorage) scanData(prefix string, fn func(data []byte) error) error {
+	return d.scan(prefix, func(item dynamoItem) error {
+		return fn([]byte(itemString(item, "data")))
+	})
+}
+
+// scan calls fn with every item whose key starts with prefix, following
+// the pages of the scan
+func (d *DynamoDBStorage) scan(prefix string, fn func(item dynamoItem) error) error {
+	return d.call(func(ctx context.Context) error {
+		pages := dynamodb.NewScanPaginator(d.client, &dynamodb.ScanInput{
+			TableName:                 aws.String(d.table),
+			ConsistentRead:            aws.Bool(true),
+			FilterExpression:          aws.String("begins_with(pk, :prefix)"),
+			ExpressionAttributeValues: dynamoItem{":prefix": &types.AttributeValueMemberS{Value: prefix}},
+		})
+		for pages.HasMorePages() {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				return err
+			}
+			for _, item := range page.Items {
+				if err := fn(item); err != nil {
+					return fmt.Errorf("failed to unmarshal record %s: %w", itemString(item, "pk"), err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// call makes sure the table exists and runs request against the DynamoDB
+// API, within dynamoRequestTimeout
+func (d *DynamoDBStorage) call(request func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoRequestTimeout)
+	defer cancel()
+
+	if err := d.ensureTable(ctx); err != nil {
+		return err
+	}
+	return request(ctx)
+}
+
+// ensureTable checks that the table exists, creating it with an on-demand
+// billing mode and the ttl attribute enabled when allowed
+func (d *DynamoDBStorage) ensureTable(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ready {
+		return nil
+	}
+
+	_, err := d.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(d.table)})
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		if !d.createTable {
+			return fmt.Errorf("DynamoDB table %s does not exist; create it or enable storage.dynamodb.create_table", d.table)
+		}
+		if err := d.createAndWait(ctx); err != nil {
+			return err
+		}
+		d.ready = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to describe DynamoDB table %s: %w", d.table, err)
+	}
+	d.ready = true
+	return nil
+}
+
+// createAndWait creates the table and waits until it is active
+func (d *DynamoDBStorage) createAndWait(ctx context.Context) error {
+	_, err := d.client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:            aws.String(d.table),
+		BillingMode:          types.BillingModePayPerRequest,
+		AttributeDefinitions: []types.AttributeDefinition{{AttributeName: aws.String("pk"), AttributeType: types.ScalarAttributeTypeS}},
+		KeySchema:            []types.KeySchemaElement{{AttributeName: aws.String("pk"), KeyType: types.KeyTypeHash}},
+	})
+	var inUse *types.ResourceInUseException
+	if err != nil && !errors.As(err, &inUse) {
+		return fmt.Errorf("failed to create DynamoDB table %s: %w", d.table, err)
+	}
+
+	waiter := dynamodb.NewTableExistsWaiter(d.client, func(o *dynamodb.TableExistsWaiterOptions) {
+		o.MinDelay = time.Second
+		o.MaxDelay = 5 * time.Second
+	})
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(d.table)}, dynamoRequestTimeout); err != nil {
+		return fmt.Errorf("DynamoDB table %s did not become active: %w", d.table, err)
+	}
+
+	_, err = d.client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(d.table),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String("ttl"),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable TTL on DynamoDB table %s: %w", d.table, err)
+	}
+	return nil
+}
+
+// dynamoKey returns the key of the item pk
+func dynamoKey(pk string) dynamoItem {
+	return dynamoItem{"pk": &types.AttributeValueMemberS{Value: pk}}
+}
+
+// dynamoNumber returns a number attribute
+func dynamoNumber(n int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
+
+// itemString returns the string attribute name of an item, empty when
+// missing
+func itemString(item dynamoItem, name string) string {
+	if value, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return value.Value
+	}
+	return ""
+}
+
+// itemVersion returns the version attribute of an item, zero when missing
+func itemVersion(item dynamoItem) int64 {
+	value, ok := item["version"].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0
+	}
+	version, _ := strconv.ParseInt(value.Value, 10, 64)
+	return version
+}
+
+var _ Storage = (*DynamoDBStorage)(nil)
@@ -0,0 +1,322 @@
+package storage_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/storage"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+type dynamoItem = map[string]map[string]string
+
+// fakeDynamoDB serves the DynamoDB operations and condition expressions
+// used by DynamoDBStorage, returning scans two items per page
+type fakeDynamoDB struct {
+	mu         sync.Mutex
+	created    bool
+	ttlEnabled bool
+	items      map[string]dynamoItem
+}
+
+func (f *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256") {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Key                       dynamoItem
+		Item                      dynamoItem
+		ConditionExpression       string
+		ExpressionAttributeValues dynamoItem
+		ExclusiveStartKey         dynamoItem
+		TransactItems             []map[string]struct {
+			Key                       dynamoItem
+			Item                      dynamoItem
+			ConditionExpression       string
+			ExpressionAttributeValues dynamoItem
+		}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fail := func(errorType string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"__type": "com.amazonaws.dynamodb.v20120810#" + errorType, "message": errorType})
+	}
+	operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+	if !f.created && operation != "DescribeTable" && operation != "CreateTable" {
+		fail("ResourceNotFoundException")
+		return
+	}
+
+	var response interface{} = map[string]interface{}{}
+	switch operation {
+	case "DescribeTable":
+		if !f.created {
+			fail("ResourceNotFoundException")
+			return
+		}
+		response = map[string]interface{}{"Table": map[string]string{"TableStatus": "ACTIVE"}}
+	case "CreateTable":
+		f.created = true
+	case "UpdateTimeToLive":
+		f.ttlEnabled = true
+	case "GetItem":
+		if item, ok := f.items[req.Key["pk"]["S"]]; ok {
+			response = map[string]interface{}{"Item": item}
+		}
+	case "PutItem":
+		if !f.holds(req.Item["pk"]["S"], req.ConditionExpression, req.ExpressionAttributeValues) {
+			fail("ConditionalCheckFailedException")
+			return
+		}
+		f.items[req.Item["pk"]["S"]] = req.Item
+	case "DeleteItem":
+		delete(f.items, req.Key["pk"]["S"])
+	case "Scan":
+		response = f.scan(req.ExpressionAttributeValues[":prefix"]["S"], req.ExclusiveStartKey["pk"]["S"])
+	case "TransactWriteItems":
+		for _, action := range req.TransactItems {
+			for _, op := range action {
+				pk := op.Key["pk"]["S"] + op.Item["pk"]["S"]
+				if !f.holds(pk, op.ConditionExpression, op.ExpressionAttributeValues) {
+					fail("TransactionCanceledException")
+					return
+				}
+			}
+		}
+		for _, action := range req.TransactItems {
+			if put, ok := action["Put"]; ok {
+				f.items[put.Item["pk"]["S"]] = put.Item
+			}
+			if del, ok := action["Delete"]; ok {
+				delete(f.items, del.Key["pk"]["S"])
+			}
+		}
+	default:
+		http.Error(w, "unsupported operation "+operation, http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// holds evaluates the condition expressions DynamoDBStorage sends
+func (f *fakeDynamoDB) holds(pk, condition string, values dynamoItem) bool {
+	existing, exists := f.items[pk]
+	switch condition {
+	case "":
+		return true
+	case "attribute_not_exists(pk)":
+		return !exists
+	case "#version = :version":
+		return exists && existing["version"]["N"] == values[":version"]["N"]
+	}
+	return false
+}
+
+// scan returns a page of the items whose key starts with prefix, after
+// the key startAfter
+func (f *fakeDynamoDB) scan(prefix, startAfter string) map[string]interface{} {
+	var keys []string
+	for pk := range f.items {
+		if strings.HasPrefix(pk, prefix) && pk > startAfter {
+			keys = append(keys, pk)
+		}
+	}
+	sort.Strings(keys)
+
+	response := map[string]interface{}{}
+	items := []dynamoItem{}
+	for i, pk := range keys {
+		if i == 2 {
+			response["LastEvaluatedKey"] = dynamoItem{"pk": {"S": keys[i-1]}}
+			break
+		}
+		items = append(items, f.items[pk])
+	}
+	response["Items"] = items
+	return response
+}
+
+func newDynamoDBStorage(t *testing.T, fake *fakeDynamoDB, createTable bool) *storage.DynamoDBStorage {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	store, err := storage.NewDynamoDBStorage(context.Background(), storage.DynamoDBOptions{
+		Table:       "instances",
+		Region:      "us-east-1",
+		Endpoint:    server.URL,
+		CreateTable: createTable,
+		Retention:   24 * time.Hour,
+		Credentials: credentials.NewStaticCredentialsProvider("AKIDEXAMPLE", "secret", ""),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create DynamoDB storage: %v", err)
+	}
+	return store
+}
+
+func TestDynamoDBStorage_CreatesTable(t *testing.T) {
+	fake := &fakeDynamoDB{items: make(map[string]dynamoItem)}
+
+	missing := newDynamoDBStorage(t, fake, false)
+	if _, err := missing.ListInstances(); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("Expected an error for the missing table, got %v", err)
+	}
+
+	store := newDynamoDBStorage(t, fake, true)
+	if err := store.SaveInstance(&models.Instance{ID: "i-123", State: "running"}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	if !fake.created || !fake.ttlEnabled {
+		t.Errorf("Expected the table to be created with TTL enabled, got created=%v ttl=%v", fake.created, fake.ttlEnabled)
+	}
+}
+
+func TestDynamoDBStorage_Instances(t *testing.T) {
+	fake := &fakeDynamoDB{items: make(map[string]dynamoItem)}
+	store := newDynamoDBStorage(t, fake, true)
+
+	now := time.Now()
+	for _, instance := range []*models.Instance{
+		{ID: "i-1", Name: "web", State: "running", ExpiresAt: now.Add(-time.Minute)},
+		{ID: "i-2", Name: "web", State: "running", ExpiresAt: now.Add(time.Hour)},
+		{ID: "i-3", State: "terminated", ExpiresAt: now.Add(time.Hour), TerminatedAt: now.Add(-48 * time.Hour)},
+	} {
+		if err := store.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+
+	instances, err := store.ListInstances()
+	if err != nil || len(instances) != 3 {
+		t.Fatalf("Expected 3 instances across scan pages, got %d, %v", len(instances), err)
+	}
+	if named, _ := store.FindByName("web"); len(named) != 2 {
+		t.Errorf("Expected 2 instances named web, got %d", len(named))
+	}
+	if expired, _ := store.GetExpiredInstances(); len(expired) != 1 || expired[0].ID != "i-1" {
+		t.Errorf("Expected i-1 to be expired, got %v", expired)
+	}
+	if terminated, _ := store.GetTerminatedBefore(now.Add(-24 * time.Hour)); len(terminated) != 1 || terminated[0].ID != "i-3" {
+		t.Errorf("Expected i-3 to be terminated before the cutoff, got %v", terminated)
+	}
+	if fake.items["instance#i-3"]["ttl"]["N"] == "" || fake.items["instance#i-1"]["ttl"]["N"] != "" {
+		t.Error("Expected only the terminated record to get a TTL")
+	}
+
+	// Two processes read the same record; the second update must not
+	// overwrite the first
+	first, err := store.GetInstance("i-2")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	second, _ := store.GetInstance("i-2")
+	first.ExpiresAt = now.Add(2 * time.Hour)
+	if err := store.UpdateInstance(first); err != nil {
+		t.Fatalf("Failed to update instance: %v", err)
+	}
+	second.State = "stopped"
+	if err := store.UpdateInstance(second); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("Expected a conflict updating a stale record, got %v", err)
+	}
+	// The first process may keep updating its copy
+	first.State = "stopping"
+	if err := store.UpdateInstance(first); err != nil {
+		t.Errorf("Expected the up-to-date copy to update, got %v", err)
+	}
+	got, _ := store.GetInstance("i-2")
+	if got.State != "stopping" || !got.ExpiresAt.Equal(first.ExpiresAt) {
+		t.Errorf("Unexpected instance after updates: %+v", got)
+	}
+
+	if err := store.UpdateInstance(&models.Instance{ID: "i-missing"}); err == nil {
+		t.Error("Expected updating a missing instance to fail")
+	}
+
+	if err := store.DeleteInstance("i-1"); err != nil {
+		t.Fatalf("Failed to delete instance: %v", err)
+	}
+	if _, err := store.GetInstance("i-1"); err == nil {
+		t.Error("Expected the deleted instance to be gone")
+	}
+}
+
+func TestDynamoDBStorage_ArchiveAndState(t *testing.T) {
+	fake := &fakeDynamoDB{items: make(map[string]dynamoItem)}
+	store := newDynamoDBStorage(t, fake, true)
+
+	if err := store.SaveInstance(&models.Instance{ID: "i-1", State: "terminated"}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	if err := store.ArchiveInstance("i-1", models.ArchiveReasonPruned); err != nil {
+		t.Fatalf("Failed to archive instance: %v", err)
+	}
+	if _, err := store.GetInstance("i-1"); err == nil {
+		t.Error("Expected the archived instance to leave the managed instances")
+	}
+	archived, err := store.ListArchived()
+	if err != nil || len(archived) != 1 || archived[0].Reason != models.ArchiveReasonPruned || archived[0].Instance.ID != "i-1" {
+		t.Fatalf("Unexpected archive: %v, %v", archived, err)
+	}
+
+	if err := store.RecordSnapshot(&models.SnapshotRecord{SnapshotID: "snap-1", InstanceID: "i-1"}); err != nil {
+		t.Fatalf("Failed to record snapshot: %v", err)
+	}
+	if err := store.RecordImage(&models.ImageRecord{ImageID: "ami-1", InstanceID: "i-1"}); err != nil {
+		t.Fatalf("Failed to record image: %v", err)
+	}
+	if err := store.DeleteSnapshot("snap-1"); err != nil {
+		t.Fatalf("Failed to delete snapshot: %v", err)
+	}
+	if snapshots, _ := store.ListSnapshots(); len(snapshots) != 0 {
+		t.Errorf("Expected no snapshots, got %v", snapshots)
+	}
+	if images, _ := store.ListImages(); len(images) != 1 {
+		t.Errorf("Expected one image, got %v", images)
+	}
+
+	if run, err := store.LastSchedulerRun(); err != nil || run != nil {
+		t.Errorf("Expected no run yet, got %v, %v", run, err)
+	}
+	if err := store.RecordSchedulerRun(&models.SchedulerRun{Instances: 4}); err != nil {
+		t.Fatalf("Failed to record run: %v", err)
+	}
+	if run, _ := store.LastSchedulerRun(); run == nil || run.Instances != 4 {
+		t.Errorf("Unexpected run: %+v", run)
+	}
+
+	if err := store.PauseScheduler(&models.SchedulerPause{PausedAt: time.Now(), Reason: "maintenance"}); err != nil {
+		t.Fatalf("Failed to pause: %v", err)
+	}
+	if pause, _ := store.SchedulerPause(); pause == nil || pause.Reason != "maintenance" {
+		t.Errorf("Unexpected pause: %+v", pause)
+	}
+	if resumed, err := store.ResumeScheduler(); !resumed || err != nil {
+		t.Errorf("Expected to resume, got %v, %v", resumed, err)
+	}
+
+	snapshot, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to snapshot storage: %v", err)
+	}
+	if len(snapshot.Archived) != 1 || len(snapshot.Images) != 1 || snapshot.LastRun == nil || snapshot.Pause != nil {
+		t.Errorf("Unexpected storage contents: %+v", snapshot)
+	}
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// encryptedMagic is the header of data encrypted by a storage, followed by
+// the GCM nonce and the sealed data
+var encryptedMagic = []byte("IMENC1\n")
+
+// EncryptionKeySize is the size of a storage encryption key: AES-256
+const EncryptionKeySize = 32
+
+// ErrEncrypted is returned when reading an encrypted storage file without
+// a key
+var ErrEncrypted = errors.New("the storage file is encrypted and no encryption key is set (STORAGE_ENCRYPTION_KEY)")
+
+// errDecrypt is returned when encrypted data cannot be opened with the key
+var errDecrypt = errors.New("failed to decrypt: wrong encryption key or corrupted data")
+
+// ParseEncryptionKey decodes a 32-byte key given in base64 or hex, as
+// generated by `openssl rand -base64 32`
+func ParseEncryptionKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == EncryptionKeySize {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(s); err == nil && len(key) == EncryptionKeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("invalid encryption key: must be %d bytes in base64 or hex", EncryptionKeySize)
+}
+
+// isEncrypted reports whether data was encrypted by encrypt
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// encrypt seals data with AES-256-GCM under key, with a random nonce
+func encrypt(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(append([]byte{}, encryptedMagic...), nonce...)
+	return gcm.Seal(sealed, nonce, data, encryptedMagic), nil
+}
+
+// decrypt opens data sealed by encrypt, failing when it was sealed under
+// another key or was tampered with
+func decrypt(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < gcm.NonceSize() {
+		return nil, errDecrypt
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], encryptedMagic)
+	if err != nil {
+		return nil, errDecrypt
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
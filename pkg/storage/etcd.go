@@ -0,0 +1,544 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"instance-manager/internal/etcd"
+	"instance-manager/pkg/models"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Keys of the data an EtcdStorage keeps, each under its prefix
+const (
+	etcdInstancePrefix = "instances/"     // An instance record by instance ID
+	etcdArchivedPrefix = "archived/"      // An archived record by archive time and instance ID
+	etcdSnapshotPrefix = "snapshots/"     // A snapshot record by snapshot ID
+	etcdImagePrefix    = "images/"        // An image record by image ID
+	etcdLastRunKey     = "state/last_run" // Report of the latest scheduler pass
+	etcdPauseKey       = "state/pause"    // Pause of the scheduler
+)
+
+// DefaultEtcdPrefix is the key prefix of an EtcdStorage when none is
+// configured
+const DefaultEtcdPrefix = "/instance-manager/"
+
+// etcdRequestTimeout bounds each operation on etcd
+const etcdRequestTimeout = 30 * time.Second
+
+// etcdConflictRetries is how often a transaction is retried when another
+// process wrote the same record in between
+const etcdConflictRetries = 5
+
+// EtcdOptions configures an EtcdStorage
+type EtcdOptions struct {
+	// Endpoints of the cluster, such as http://127.0.0.1:2379
+	Endpoints []string
+	// Prefix is prepended to the keys, so several deployments can share a
+	// cluster; empty means DefaultEtcdPrefix
+	Prefix   string
+	Username string // Authenticates with Password when set
+	Password string
+}
+
+// EtcdStorage keeps instance records in etcd, so several service replicas
+// and web servers can share them. Each record is a key holding its JSON.
+// Writes of an instance record are transactions conditional on the revision
+// that last changed it, so an update of an instance that another process
+// changed since it was read fails with ErrConflict instead of overwriting
+// the change.
+type EtcdStorage struct {
+	client *clientv3.Client
+	prefix string
+	feed   changeFeed
+}
+
+// NewEtcdStorage connects to the etcd cluster of opts
+func NewEtcdStorage(ctx context.Context, opts EtcdOptions) (*EtcdStorage, error) {
+	client, err := etcd.NewClient(opts.Endpoints, opts.Username, opts.Password)
+	if err != nil {
+		return nil, err
+	}
+	prefix := opts.Prefix
+	if prefix == "" {
+		prefix = DefaultEtcdPrefix
+	}
+	e := &EtcdStorage{client: client, prefix: prefix}
+	if _, err := client.Get(ctx, e.key(etcdLastRunKey)); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to reach etcd at %s: %w", strings.Join(client.Endpoints(), ","), err)
+	}
+	return e, nil
+}
+
+// SaveInstance stores an instance record, replacing any with the same ID
+func (e *EtcdStorage) SaveInstance(instance *models.Instance) error {
+	revision, err := e.transact(instance.ID, func(record *models.InstanceRecord) ([]clientv3.Op, error) {
+		now := time.Now()
+		createdAt := now
+		if record != nil {
+			createdAt = record.CreatedAt
+		}
+		return e.putInstanceOps(&models.InstanceRecord{Instance: instance, CreatedAt: createdAt, UpdatedAt: now})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save instance %s: %w", instance.ID, err)
+	}
+	instance.Revision = revision
+	e.feed.changed()
+	return nil
+}
+
+// GetInstance returns the record of an instance
+func (e *EtcdStorage) GetInstance(instanceID string) (*models.Instance, error) {
+	ctx, cancel := e.context()
+	defer cancel()
+
+	kv, err := e.get(ctx, e.key(etcdInstancePrefix+instanceID))
+	if err != nil {
+		return nil, err
+	}
+	if kv == nil {
+		return nil, fmt.Errorf("instance %s not found", instanceID)
+	}
+	record, err := decodeEtcdInstance(kv)
+	if err != nil {
+		return nil, err
+	}
+	return record.Instance, nil
+}
+
+// UpdateInstance replaces the record of a stored instance. When the
+// instance carries a revision, the update fails with ErrConflict if the
+// record changed since.
+func (e *EtcdStorage) UpdateInstance(instance *models.Instance) error {
+	revision, err := e.transact(instance.ID, func(record *models.InstanceRecord) ([]clientv3.Op, error) {
+		if record == nil {
+			return nil, fmt.Errorf("instance %s not found", instance.ID)
+		}
+		if instance.Revision != 0 && instance.Revision != record.Instance.Revision {
+			return nil, ErrConflict
+		}
+		record.Instance = instance
+		record.UpdatedAt = time.Now()
+		return e.putInstanceOps(record)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update instance %s: %w", instance.ID, err)
+	}
+	instance.Revision = revision
+	e.feed.changed()
+	return nil
+}
+
+// DeleteInstance removes the record of an instance
+func (e *EtcdStorage) DeleteInstance(instanceID string) error {
+	ctx, cancel := e.context()
+	defer cancel()
+
+	if _, err := e.client.Delete(ctx, e.key(etcdInstancePrefix+instanceID)); err != nil {
+		return err
+	}
+	e.feed.changed()
+	return nil
+}
+
+// ListInstances returns all stored instances, sorted by ID
+func (e *EtcdStorage) ListInstances() ([]*models.Instance, error) {
+	records, err := e.records()
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]*models.Instance, 0, len(records))
+	for _, record := range records {
+		instances = append(instances, record.Instance)
+	}
+	return instances, nil
+}
+
+// ListInstancesFiltered returns the stored instances the filter matches
+func (e *EtcdStorage) ListInstancesFiltered(filter models.InstanceFilter) ([]*models.Instance, error) {
+	instances, err := e.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+	return filter.Filter(instances), nil
+}
+
+// FindByName returns the stored instances with the given name
+func (e *EtcdStorage) FindByName(name string) ([]*models.Instance, error) {
+	instances, err := e.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+	return namedInstances(instances, name), nil
+}
+
+// GetExpiredInstances returns instances that have exceeded their duration
+func (e *EtcdStorage) GetExpiredInstances() ([]*models.Instance, error) {
+	records, err := e.instanceRecords()
+	if err != nil {
+		return nil, err
+	}
+	return expiredInstances(records), nil
+}
+
+// GetTerminatedBefore returns terminated instances whose termination
+// happened before cutoff, sorted by ID
+func (e *EtcdStorage) GetTerminatedBefore(cutoff time.Time) ([]*models.Instance, error) {
+	records, err := e.instanceRecords()
+	if err != nil {
+		return nil, err
+	}
+	return terminatedBefore(records, cutoff), nil
+}
+
+// ArchiveInstance moves an instance record to the archive in a single
+// transaction, noting why it was archived
+func (e *EtcdStorage) ArchiveInstance(instanceID, reason string) error {
+	_, err := e.transact(instanceID, func(record *models.InstanceRecord) ([]clientv3.Op, error) {
+		if record == nil {
+			return nil, fmt.Errorf("instance %s not found", instanceID)
+		}
+		archivedAt := time.Now()
+		data, err := json.Marshal(&models.ArchivedInstance{
+			Instance:   record.Instance,
+			Reason:     reason,
+			ArchivedAt: archivedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+		// The zero-padded time keeps the archive in order
+		archivedKey := fmt.Sprintf("%s%020d/%s", etcdArchivedPrefix, archivedAt.UnixNano(), instanceID)
+		return []clientv3.Op{
+			clientv3.OpPut(e.key(archivedKey), string(data)),
+			clientv3.OpDelete(e.key(etcdInstancePrefix + instanceID)),
+		}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive instance %s: %w", instanceID, err)
+	}
+	e.feed.changed()
+	return nil
+}
+
+// ListArchived returns the archived instance records, oldest first
+func (e *EtcdStorage) ListArchived() ([]*models.ArchivedInstance, error) {
+	var archived []*models.ArchivedInstance
+	err := e.values(etcdArchivedPrefix, func(data []byte) error {
+		var record models.ArchivedInstance
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		archived = append(archived, &record)
+		return nil
+	})
+	return archived, err
+}
+
+// RecordSnapshot stores a record of a volume snapshot
+func (e *EtcdStorage) RecordSnapshot(snapshot *models.SnapshotRecord) error {
+	return e.putValue(etcdSnapshotPrefix+snapshot.SnapshotID, snapshot)
+}
+
+// ListSnapshots returns the recorded snapshots, oldest first
+func (e *EtcdStorage) ListSnapshots() ([]*models.SnapshotRecord, error) {
+	var snapshots []*models.SnapshotRecord
+	if err := e.values(etcdSnapshotPrefix, func(data []byte) error {
+		var snapshot models.SnapshotRecord
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return err
+		}
+		snapshots = append(snapshots, &snapshot)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(snapshots, func(a, b *models.SnapshotRecord) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// DeleteSnapshot removes the record of a snapshot
+func (e *EtcdStorage) DeleteSnapshot(snapshotID string) error {
+	ctx, cancel := e.context()
+	defer cancel()
+	_, err := e.client.Delete(ctx, e.key(etcdSnapshotPrefix+snapshotID))
+	return err
+}
+
+// RecordImage stores a record of a machine image
+func (e *EtcdStorage) RecordImage(image *models.ImageRecord) error {
+	return e.putValue(etcdImagePrefix+image.ImageID, image)
+}
+
+// ListImages returns the recorded machine images, oldest first
+func (e *EtcdStorage) ListImages() ([]*models.ImageRecord, error) {
+	var images []*models.ImageRecord
+	if err := e.values(etcdImagePrefix, func(data []byte) error {
+		var image models.ImageRecord
+		if err := json.Unmarshal(data, &image); err != nil {
+			return err
+		}
+		images = append(images, &image)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(images, func(a, b *models.ImageRecord) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return images, nil
+}
+
+// RecordSchedulerRun stores the report of the latest scheduler pass
+func (e *EtcdStorage) RecordSchedulerRun(run *models.SchedulerRun) error {
+	return e.putValue(etcdLastRunKey, run)
+}
+
+// LastSchedulerRun returns the report of the latest scheduler pass, or nil
+// when the scheduler has not run yet
+func (e *EtcdStorage) LastSchedulerRun() (*models.SchedulerRun, error) {
+	var run *models.SchedulerRun
+	if err := e.getValue(etcdLastRunKey, &run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// PauseScheduler suspends the scheduler's lifecycle actions
+func (e *EtcdStorage) PauseScheduler(pause *models.SchedulerPause) error {
+	return e.putValue(etcdPauseKey, pause)
+}
+
+// ResumeScheduler ends a pause, reporting false when there was none
+func (e *EtcdStorage) ResumeScheduler() (bool, error) {
+	pause, err := e.SchedulerPause()
+	if err != nil || pause == nil {
+		return false, err
+	}
+	ctx, cancel := e.context()
+	defer cancel()
+	if _, err := e.client.Delete(ctx, e.key(etcdPauseKey)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SchedulerPause returns the pause in effect, or nil
+func (e *EtcdStorage) SchedulerPause() (*models.SchedulerPause, error) {
+	var pause *models.SchedulerPause
+	if err := e.getValue(etcdPauseKey, &pause); err != nil {
+		return nil, err
+	}
+	if !pause.Active(time.Now()) {
+		return nil, nil
+	}
+	return pause, nil
+}
+
+// Watch reports changes to the instance records, including those made by
+// other processes, until ctx is done
+func (e *EtcdStorage) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	return e.feed.watch(ctx, e.instanceRecords)
+}
+
+// Snapshot returns the full contents of the storage
+func (e *EtcdStorage) Snapshot() (*StorageRecord, error) {
+	record := &StorageRecord{UpdatedAt: time.Now()}
+	var err error
+	if record.Instances, err = e.instanceRecords(); err != nil {
+		return nil, err
+	}
+	if record.Snapshots, err = e.ListSnapshots(); err != nil {
+		return nil, err
+	}
+	if record.Images, err = e.ListImages(); err != nil {
+		return nil, err
+	}
+	if record.Archived, err = e.ListArchived(); err != nil {
+		return nil, err
+	}
+	if record.LastRun, err = e.LastSchedulerRun(); err != nil {
+		return nil, err
+	}
+	if record.Pause, err = e.SchedulerPause(); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Location returns the endpoints and the key prefix
+func (e *EtcdStorage) Location() string {
+	return fmt.Sprintf("etcd %s (keys %s*)", strings.Join(e.client.Endpoints(), ","), e.prefix)
+}
+
+// key returns the full name of a key
+func (e *EtcdStorage) key(name string) string {
+	return e.prefix + name
+}
+
+// context returns the context of one operation
+func (e *EtcdStorage) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), etcdRequestTimeout)
+}
+
+// transact runs an optimistic transaction on the record of an instance. It
+// reads the record, calls fn with it (nil when missing), and applies the
+// operations fn returns on condition that the record is unchanged. When
+// another process wrote the record in between, fn is called again with the
+// new record. It returns the revision of the change.
+func (e *EtcdStorage) transact(instanceID string, fn func(record *models.InstanceRecord) ([]clientv3.Op, error)) (int64, error) {
+	ctx, cancel := e.context()
+	defer cancel()
+
+	key := e.key(etcdInstancePrefix + instanceID)
+	for attempt := 0; ; attempt++ {
+		kv, err := e.get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		var record *models.InstanceRecord
+		compare := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+		if kv != nil {
+			if record, err = decodeEtcdInstance(kv); err != nil {
+				return 0, err
+			}
+			compare = clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)
+		}
+		ops, err := fn(record)
+		if err != nil {
+			return 0, err
+		}
+
+		resp, err := e.client.Txn(ctx).If(compare).Then(ops...).Commit()
+		if err != nil {
+			return 0, err
+		}
+		if resp.Succeeded {
+			return resp.Header.Revision, nil
+		}
+		if attempt >= etcdConflictRetries {
+			return 0, ErrConflict
+		}
+	}
+}
+
+// putInstanceOps returns the operations that store record
+func (e *EtcdStorage) putInstanceOps(record *models.InstanceRecord) ([]clientv3.Op, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return []clientv3.Op{clientv3.OpPut(e.key(etcdInstancePrefix+record.Instance.ID), string(data))}, nil
+}
+
+// records returns all instance records, sorted by instance ID
+func (e *EtcdStorage) records() ([]*models.InstanceRecord, error) {
+	ctx, cancel := e.context()
+	defer cancel()
+
+	resp, err := e.client.Get(ctx, e.key(etcdInstancePrefix), clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*models.InstanceRecord, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		record, err := decodeEtcdInstance(kv)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// instanceRecords returns all instance records keyed by instance ID
+func (e *EtcdStorage) instanceRecords() (map[string]*models.InstanceRecord, error) {
+	records, err := e.records()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*models.InstanceRecord, len(records))
+	for _, record := range records {
+		byID[record.Instance.ID] = record
+	}
+	return byID, nil
+}
+
+// values calls fn with the value of each key starting with prefix, in order
+// of key
+func (e *EtcdStorage) values(prefix string, fn func(data []byte) error) error {
+	ctx, cancel := e.context()
+	defer cancel()
+
+	resp, err := e.client.Get(ctx, e.key(prefix), clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	for _, kv := range resp.Kvs {
+		if err := fn(kv.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putValue stores the JSON of value under key
+func (e *EtcdStorage) putValue(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := e.context()
+	defer cancel()
+	_, err = e.client.Put(ctx, e.key(key), string(data))
+	return err
+}
+
+// getValue decodes the value stored under key into out, leaving it
+// untouched when there is none
+func (e *EtcdStorage) getValue(key string, out interface{}) error {
+	ctx, cancel := e.context()
+	defer cancel()
+
+	kv, err := e.get(ctx, e.key(key))
+	if err != nil || kv == nil {
+		return err
+	}
+	return json.Unmarshal(kv.Value, out)
+}
+
+// get returns a key, or nil when it does not exist
+func (e *EtcdStorage) get(ctx context.Context, key string) (*mvccpb.KeyValue, error) {
+	resp, err := e.client.Get(ctx, key)
+	if err != nil || len(resp.Kvs) == 0 {
+		return nil, err
+	}
+	return resp.Kvs[0], nil
+}
+
+// decodeEtcdInstance decodes an instance record, taking its revision from
+// the revision that last changed the key
+func decodeEtcdInstance(kv *mvccpb.KeyValue) (*models.InstanceRecord, error) {
+	var record models.InstanceRecord
+	if err := json.Unmarshal(kv.Value, &record); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", kv.Key, err)
+	}
+	if record.Instance == nil {
+		return nil, errors.New("failed to decode " + string(kv.Key) + ": no instance")
+	}
+	record.Instance.Revision = kv.ModRevision
+	return &record, nil
+}
+
+var _ Storage = (*EtcdStorage)(nil)
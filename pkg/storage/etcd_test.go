@@ -0,0 +1,179 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"instance-manager/internal/etcd/etcdtest"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/storage"
+)
+
+func newEtcdStorage(t *testing.T, server *etcdtest.Server, opts storage.EtcdOptions) *storage.EtcdStorage {
+	opts.Endpoints = []string{server.URL}
+	store, err := storage.NewEtcdStorage(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Failed to open etcd storage: %v", err)
+	}
+	return store
+}
+
+func TestEtcdStorage_Instances(t *testing.T) {
+	server := etcdtest.New(t)
+	store := newEtcdStorage(t, server, storage.EtcdOptions{})
+	now := time.Now()
+
+	instances := []*models.Instance{
+		{ID: "i-1", Name: "web", State: "running", ExpiresAt: now.Add(-time.Minute)},
+		{ID: "i-2", Name: "db", State: "running", ExpiresAt: now.Add(time.Hour)},
+		{ID: "i-3", Name: "web", State: "terminated", ExpiresAt: now.Add(2 * time.Hour), TerminatedAt: now.Add(-48 * time.Hour)},
+	}
+	for _, instance := range instances {
+		if err := store.SaveInstance(instance); err != nil {
+			t.Fatalf("SaveInstance failed: %v", err)
+		}
+		if instance.Revision == 0 {
+			t.Errorf("Expected %s to carry the revision of its record", instance.ID)
+		}
+	}
+	if server.Get("/instance-manager/instances/i-1") == nil {
+		t.Error("Expected the records under the default prefix")
+	}
+
+	all, err := store.ListInstances()
+	if err != nil || len(all) != 3 || all[0].ID != "i-1" {
+		t.Fatalf("Expected 3 instances, got %v, %v", all, err)
+	}
+	if named, _ := store.FindByName("web"); len(named) != 2 {
+		t.Errorf("Expected 2 instances named web, got %d", len(named))
+	}
+	expired, err := store.GetExpiredInstances()
+	if err != nil || len(expired) != 1 || expired[0].ID != "i-1" {
+		t.Errorf("Expected i-1 to be expired, got %v, %v", expired, err)
+	}
+	terminated, err := store.GetTerminatedBefore(now.Add(-24 * time.Hour))
+	if err != nil || len(terminated) != 1 || terminated[0].ID != "i-3" {
+		t.Errorf("Expected i-3 to be terminated, got %v, %v", terminated, err)
+	}
+	filtered, err := store.ListInstancesFiltered(models.InstanceFilter{ExcludeStates: []string{"terminated"}, ExpiresAfter: now})
+	if err != nil || len(filtered) != 1 || filtered[0].ID != "i-2" {
+		t.Errorf("Expected only i-2 to match the filter, got %v, %v", filtered, err)
+	}
+
+	if err := store.ArchiveInstance("i-3", "pruned"); err != nil {
+		t.Fatalf("ArchiveInstance failed: %v", err)
+	}
+	archived, err := store.ListArchived()
+	if err != nil || len(archived) != 1 || archived[0].Instance.ID != "i-3" || archived[0].Reason != "pruned" {
+		t.Errorf("Unexpected archive: %v, %v", archived, err)
+	}
+	if _, err := store.GetInstance("i-3"); err == nil {
+		t.Error("Expected the archived instance to be removed")
+	}
+	if err := store.ArchiveInstance("i-3", "pruned"); err == nil {
+		t.Error("Expected archiving a missing instance to fail")
+	}
+
+	if err := store.DeleteInstance("i-2"); err != nil {
+		t.Fatalf("DeleteInstance failed: %v", err)
+	}
+	if all, err := store.ListInstances(); err != nil || len(all) != 1 {
+		t.Errorf("Expected 1 instance left, got %d, %v", len(all), err)
+	}
+}
+
+func TestEtcdStorage_Conflicts(t *testing.T) {
+	server := etcdtest.New(t)
+	store := newEtcdStorage(t, server, storage.EtcdOptions{Prefix: "/team/"})
+	other := newEtcdStorage(t, server, storage.EtcdOptions{Prefix: "/team/"})
+
+	if err := store.SaveInstance(&models.Instance{ID: "i-1", State: "running"}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+
+	// A stale revision is refused
+	first, _ := store.GetInstance("i-1")
+	second, _ := other.GetInstance("i-1")
+	first.State = "stopped"
+	if err := store.UpdateInstance(first); err != nil {
+		t.Fatalf("UpdateInstance failed: %v", err)
+	}
+	second.State = "running"
+	if err := other.UpdateInstance(second); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+
+	// A write between reading and committing fails the transaction once;
+	// without a revision the update is retried on top of it
+	writes := 0
+	server.SetBeforeTxn(func() {
+		if writes == 0 {
+			kv := server.Get("/team/instances/i-1")
+			server.Put(string(kv.Key), string(kv.Value))
+		}
+		writes++
+	})
+	unversioned := &models.Instance{ID: "i-1", State: "terminated"}
+	if err := store.UpdateInstance(unversioned); err != nil {
+		t.Fatalf("UpdateInstance without a revision failed: %v", err)
+	}
+	if writes != 2 {
+		t.Errorf("Expected the transaction to be retried once, got %d attempts", writes)
+	}
+	got, err := store.GetInstance("i-1")
+	if err != nil || got.State != "terminated" || got.Revision != unversioned.Revision {
+		t.Errorf("Expected the retried update to be stored, got %+v, %v", got, err)
+	}
+}
+
+func TestEtcdStorage_StateAndAuth(t *testing.T) {
+	server := etcdtest.New(t)
+	server.SetPassword("secret")
+	if _, err := storage.NewEtcdStorage(context.Background(), storage.EtcdOptions{Endpoints: []string{server.URL}}); err == nil {
+		t.Fatal("Expected an unauthenticated connection to fail")
+	}
+	// An unreachable endpoint is skipped for the next one
+	store, err := storage.NewEtcdStorage(context.Background(), storage.EtcdOptions{
+		Endpoints: []string{"http://127.0.0.1:1", server.URL},
+		Username:  "root",
+		Password:  "secret",
+	})
+	if err != nil {
+		t.Fatalf("Failed to open etcd storage: %v", err)
+	}
+
+	if pause, err := store.SchedulerPause(); err != nil || pause != nil {
+		t.Fatalf("Expected no pause, got %v, %v", pause, err)
+	}
+	if err := store.PauseScheduler(&models.SchedulerPause{PausedAt: time.Now(), Reason: "maintenance"}); err != nil {
+		t.Fatalf("PauseScheduler failed: %v", err)
+	}
+	if resumed, err := store.ResumeScheduler(); err != nil || !resumed {
+		t.Errorf("Expected the pause to end, got %v, %v", resumed, err)
+	}
+	if resumed, err := store.ResumeScheduler(); err != nil || resumed {
+		t.Errorf("Expected no pause to end, got %v, %v", resumed, err)
+	}
+
+	if err := store.RecordSnapshot(&models.SnapshotRecord{SnapshotID: "snap-1", InstanceID: "i-1", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("RecordSnapshot failed: %v", err)
+	}
+	if err := store.RecordSchedulerRun(&models.SchedulerRun{StartedAt: time.Now()}); err != nil {
+		t.Fatalf("RecordSchedulerRun failed: %v", err)
+	}
+	snapshot, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if len(snapshot.Snapshots) != 1 || snapshot.LastRun == nil {
+		t.Errorf("Unexpected snapshot: %+v", snapshot)
+	}
+	if err := store.DeleteSnapshot("snap-1"); err != nil {
+		t.Fatalf("DeleteSnapshot failed: %v", err)
+	}
+	if snapshots, _ := store.ListSnapshots(); len(snapshots) != 0 {
+		t.Errorf("Expected no snapshots, got %d", len(snapshots))
+	}
+}
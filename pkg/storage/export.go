@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/models"
+)
+
+// Formats of exported instance records
+const (
+	// ExportJSON writes the full records as a JSON array
+	ExportJSON = "json"
+	// ExportCSV writes the main fields of the records, one row each, for
+	// spreadsheets and reporting tools
+	ExportCSV = "csv"
+)
+
+// ExportFormatFor returns the format named by format, or when it is empty
+// the one implied by the extension of path, defaulting to JSON
+func ExportFormatFor(format, path string) (string, error) {
+	if format == "" {
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			return ExportCSV, nil
+		}
+		return ExportJSON, nil
+	}
+	switch format = strings.ToLower(format); format {
+	case ExportJSON, ExportCSV:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported format %q (must be %s or %s)", format, ExportJSON, ExportCSV)
+	}
+}
+
+// csvColumns are the columns of a CSV export, in order
+var csvColumns = []string{
+	"id", "name", "provider", "account", "region", "availability_zone", "instance_type", "state",
+	"owner", "session", "public_ip", "private_ip", "launch_time", "duration", "expires_at", "tags",
+}
+
+// ExportInstances writes instances to w in the given format
+func ExportInstances(w io.Writer, instances []*models.Instance, format string) error {
+	switch format {
+	case ExportJSON:
+		if instances == nil {
+			instances = []*models.Instance{}
+		}
+		data, err := json.MarshalIndent(instances, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal instances: %w", err)
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvColumns); err != nil {
+			return err
+		}
+		for _, instance := range instances {
+			if err := cw.Write(csvRow(instance)); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// ImportInstances reads instances written by ExportInstances in the given
+// format. CSV rows only carry the fields of the CSV columns; columns may be
+// in any order and only id is required.
+func ImportInstances(r io.Reader, format string) ([]*models.Instance, error) {
+	switch format {
+	case ExportJSON:
+		var instances []*models.Instance
+		if err := json.NewDecoder(r).Decode(&instances); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal instances: %w", err)
+		}
+		for i, instance := range instances {
+			if instance == nil || instance.ID == "" {
+				return nil, fmt.Errorf("instance %d has no id", i+1)
+			}
+		}
+		return instances, nil
+	case ExportCSV:
+		return readCSV(r)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// csvRow returns the CSV columns of an instance
+func csvRow(instance *models.Instance) []string {
+	tags := make([]string, 0, len(instance.Tags))
+	for key, value := range instance.Tags {
+		tags = append(tags, key+"="+value)
+	}
+	sort.Strings(tags)
+	duration := ""
+	if instance.Duration != 0 {
+		duration = instance.Duration.String()
+	}
+	return []string{
+		instance.ID,
+		instance.Name,
+		instance.Provider,
+		instance.Account,
+		instance.Region,
+		instance.AvailabilityZone,
+		instance.InstanceType,
+		instance.State,
+		instance.Owner,
+		instance.Session,
+		instance.PublicIP,
+		instance.PrivateIP,
+		formatCSVTime(instance.LaunchTime),
+		duration,
+		formatCSVTime(instance.ExpiresAt),
+		strings.Join(tags, ";"),
+	}
+}
+
+// readCSV reads the instances of a CSV export
+func readCSV(r io.Reader) ([]*models.Instance, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	if _, ok := columns["id"]; !ok {
+		return nil, errors.New("CSV header has no id column")
+	}
+
+	var instances []*models.Instance
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return instances, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		instance := &models.Instance{
+			ID:               field("id"),
+			Name:             field("name"),
+			Provider:         field("provider"),
+			Account:          field("account"),
+			Region:           field("region"),
+			AvailabilityZone: field("availability_zone"),
+			InstanceType:     field("instance_type"),
+			State:            field("state"),
+			Owner:            field("owner"),
+			Session:          field("session"),
+			PublicIP:         field("public_ip"),
+			PrivateIP:        field("private_ip"),
+		}
+		if instance.ID == "" {
+			return nil, fmt.Errorf("line %d: id is empty", line)
+		}
+		if instance.LaunchTime, err = parseCSVTime(field("launch_time")); err != nil {
+			return nil, fmt.Errorf("line %d: invalid launch_time: %w", line, err)
+		}
+		if instance.ExpiresAt, err = parseCSVTime(field("expires_at")); err != nil {
+			return nil, fmt.Errorf("line %d: invalid expires_at: %w", line, err)
+		}
+		if value := field("duration"); value != "" {
+			if instance.Duration, err = time.ParseDuration(value); err != nil {
+				return nil, fmt.Errorf("line %d: invalid duration: %w", line, err)
+			}
+		}
+		if value := field("tags"); value != "" {
+			if instance.Tags, err = models.ParseTags(strings.Split(value, ";")); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		instances = append(instances, instance)
+	}
+}
+
+// formatCSVTime formats a time as RFC 3339, leaving the zero time empty
+func formatCSVTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func parseCSVTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
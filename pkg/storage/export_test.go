@@ -0,0 +1,95 @@
+package storage_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/storage"
+)
+
+func TestExportImport(t *testing.T) {
+	launched := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	instances := []*models.Instance{
+		{
+			ID:           "i-1",
+			Name:         "build-box",
+			Provider:     "aws",
+			Region:       "eu-west-1",
+			InstanceType: "t3.micro",
+			State:        "running",
+			Owner:        "alice",
+			PublicIP:     "203.0.113.7",
+			LaunchTime:   launched,
+			Duration:     2 * time.Hour,
+			ExpiresAt:    launched.Add(2 * time.Hour),
+			Tags:         map[string]string{"team": "qa", "env": "dev"},
+			History:      []models.HistoryEvent{{Time: launched, Event: models.EventCreated, Actor: "alice"}},
+		},
+		{ID: "i-2", Name: "with, comma", State: "stopped"},
+	}
+
+	for _, format := range []string{storage.ExportJSON, storage.ExportCSV} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := storage.ExportInstances(&buf, instances, format); err != nil {
+				t.Fatalf("ExportInstances failed: %v", err)
+			}
+			imported, err := storage.ImportInstances(&buf, format)
+			if err != nil {
+				t.Fatalf("ImportInstances failed: %v", err)
+			}
+			if len(imported) != 2 {
+				t.Fatalf("Expected 2 instances, got %d", len(imported))
+			}
+			got := imported[0]
+			if got.ID != "i-1" || got.Owner != "alice" || got.PublicIP != "203.0.113.7" || got.Duration != 2*time.Hour ||
+				!got.ExpiresAt.Equal(launched.Add(2*time.Hour)) || got.Tags["team"] != "qa" || got.Tags["env"] != "dev" {
+				t.Errorf("Unexpected first instance: %+v", got)
+			}
+			if imported[1].Name != "with, comma" {
+				t.Errorf("Expected the name to survive quoting, got %q", imported[1].Name)
+			}
+			if format == storage.ExportJSON && len(got.History) != 1 {
+				t.Errorf("Expected JSON to keep the history, got %v", got.History)
+			}
+		})
+	}
+}
+
+func TestImportInstances_CSV(t *testing.T) {
+	// Columns may come in any order and only id is required
+	csv := "state,ID,expires_at\nrunning,i-1,2026-03-02T11:00:00Z\n"
+	instances, err := storage.ImportInstances(strings.NewReader(csv), storage.ExportCSV)
+	if err != nil || len(instances) != 1 || instances[0].ID != "i-1" || instances[0].State != "running" {
+		t.Fatalf("Unexpected import: %v, %v", instances, err)
+	}
+
+	if _, err := storage.ImportInstances(strings.NewReader("name\nweb\n"), storage.ExportCSV); err == nil {
+		t.Error("Expected an error without an id column")
+	}
+	if _, err := storage.ImportInstances(strings.NewReader("id,expires_at\ni-1,tomorrow\n"), storage.ExportCSV); err == nil {
+		t.Error("Expected an error for an invalid time")
+	}
+}
+
+func TestExportFormatFor(t *testing.T) {
+	tests := []struct {
+		format, path, expected string
+	}{
+		{"", "instances.csv", storage.ExportCSV},
+		{"", "instances.json", storage.ExportJSON},
+		{"", "", storage.ExportJSON},
+		{"CSV", "instances.json", storage.ExportCSV},
+	}
+	for _, tt := range tests {
+		if got, err := storage.ExportFormatFor(tt.format, tt.path); err != nil || got != tt.expected {
+			t.Errorf("ExportFormatFor(%q, %q) = %q, %v, expected %q", tt.format, tt.path, got, err, tt.expected)
+		}
+	}
+	if _, err := storage.ExportFormatFor("xml", ""); err == nil {
+		t.Error("Expected an error for an unsupported format")
+	}
+}
@@ -1,20 +1,46 @@
 package storage
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"instance-manager/pkg/models"
 )
 
-// FileStorage implements instance storage using a JSON file
+// Suffixes of the files kept next to the storage file
+const (
+	backupSuffix = ".bak"    // The previous version of the storage file
+	lockSuffix   = ".rwlock" // Locked by processes using the storage file
+)
+
+// gzipMagic is the header that identifies gzip-compressed data
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// FileStorage implements instance storage using a JSON file. Files with a .gz
+// extension are written gzip-compressed; compressed files are detected by their
+// header when loading regardless of extension. The file is only readable by
+// its owner, and is encrypted when the storage has an encryption key.
+//
+// Each write goes to a temporary file that then replaces the storage file, so
+// a crash leaves either the old or the new contents; the previous version is
+// kept with a .bak suffix. Processes sharing the file take a lock on a file
+// next to it with a .rwlock suffix: shared to read, exclusive for a change.
 type FileStorage struct {
 	filePath string
+	compress bool
+	key      []byte // AES-256-GCM key of the file; nil writes it in plaintext
 	mutex    sync.RWMutex
+	feed     changeFeed
 }
 
 // NewFileStorage creates a new file storage instance
@@ -30,23 +56,41 @@ func NewFileStorage(filePath string) *FileStorage {
 
 	// Ensure directory exists
 	dir := filepath.Dir(filePath)
-	_ = os.MkdirAll(dir, 0755)
+	_ = os.MkdirAll(dir, 0700)
 
 	return &FileStorage{
 		filePath: filePath,
+		compress: strings.HasSuffix(filePath, ".gz"),
+	}
+}
+
+// NewEncryptedFileStorage creates a file storage that encrypts the file
+// with AES-256-GCM under key. A plaintext file is still read, and is
+// encrypted when it is next written.
+func NewEncryptedFileStorage(filePath string, key []byte) (*FileStorage, error) {
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("invalid encryption key: must be %d bytes, got %d", EncryptionKeySize, len(key))
 	}
+	fs := NewFileStorage(filePath)
+	fs.key = key
+	return fs, nil
 }
 
 // StorageRecord represents the structure stored in the file
 type StorageRecord struct {
 	Instances map[string]*models.InstanceRecord `json:"instances"`
+	Snapshots []*models.SnapshotRecord          `json:"snapshots,omitempty"`
+	Images    []*models.ImageRecord             `json:"images,omitempty"`
+	Archived  []*models.ArchivedInstance        `json:"archived,omitempty"`
+	LastRun   *models.SchedulerRun              `json:"last_run,omitempty"`
+	Pause     *models.SchedulerPause            `json:"pause,omitempty"`
 	UpdatedAt time.Time                         `json:"updated_at"`
 }
 
 // SaveInstance saves an instance record to storage
 func (fs *FileStorage) SaveInstance(instance *models.Instance) error {
-	fs.mutex.Lock()
-	defer fs.mutex.Unlock()
+	unlock := fs.lock()
+	defer unlock()
 
 	record := &models.InstanceRecord{
 		Instance:  instance,
@@ -56,9 +100,7 @@ func (fs *FileStorage) SaveInstance(instance *models.Instance) error {
 
 	data, err := fs.loadData()
 	if err != nil {
-		data = &StorageRecord{
-			Instances: make(map[string]*models.InstanceRecord),
-		}
+		return err
 	}
 
 	data.Instances[instance.ID] = record
@@ -69,8 +111,8 @@ func (fs *FileStorage) SaveInstance(instance *models.Instance) error {
 
 // GetInstance retrieves an instance record from storage
 func (fs *FileStorage) GetInstance(instanceID string) (*models.Instance, error) {
-	fs.mutex.RLock()
-	defer fs.mutex.RUnlock()
+	unlock := fs.rlock()
+	defer unlock()
 
 	data, err := fs.loadData()
 	if err != nil {
@@ -87,12 +129,12 @@ func (fs *FileStorage) GetInstance(instanceID string) (*models.Instance, error)
 
 // ListInstances returns all stored instances
 func (fs *FileStorage) ListInstances() ([]*models.Instance, error) {
-	fs.mutex.RLock()
-	defer fs.mutex.RUnlock()
+	unlock := fs.rlock()
+	defer unlock()
 
 	data, err := fs.loadData()
 	if err != nil {
-		return []*models.Instance{}, nil // Return empty slice if file doesn't exist
+		return nil, err
 	}
 
 	var instances []*models.Instance
@@ -103,10 +145,137 @@ func (fs *FileStorage) ListInstances() ([]*models.Instance, error) {
 	return instances, nil
 }
 
+// ListInstancesFiltered returns the stored instances the filter matches
+func (fs *FileStorage) ListInstancesFiltered(filter models.InstanceFilter) ([]*models.Instance, error) {
+	instances, err := fs.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+	return filter.Filter(instances), nil
+}
+
+// FindByName returns the stored instances with the given name
+func (fs *FileStorage) FindByName(name string) ([]*models.Instance, error) {
+	instances, err := fs.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	return namedInstances(instances, name), nil
+}
+
+// RecordSnapshot stores a record of a snapshot so it can be found after the
+// instance is gone
+func (fs *FileStorage) RecordSnapshot(snapshot *models.SnapshotRecord) error {
+	unlock := fs.lock()
+	defer unlock()
+
+	data, err := fs.loadData()
+	if err != nil {
+		return err
+	}
+
+	data.Snapshots = append(data.Snapshots, snapshot)
+	data.UpdatedAt = time.Now()
+
+	return fs.saveData(data)
+}
+
+// ListSnapshots returns the recorded snapshots, oldest first
+func (fs *FileStorage) ListSnapshots() ([]*models.SnapshotRecord, error) {
+	unlock := fs.rlock()
+	defer unlock()
+
+	data, err := fs.loadData()
+	if err != nil {
+		return nil, err
+	}
+	return data.Snapshots, nil
+}
+
+// DeleteSnapshot removes the record of a snapshot
+func (fs *FileStorage) DeleteSnapshot(snapshotID string) error {
+	unlock := fs.lock()
+	defer unlock()
+
+	data, err := fs.loadData()
+	if err != nil {
+		return err
+	}
+
+	data.Snapshots = slices.DeleteFunc(data.Snapshots, func(snapshot *models.SnapshotRecord) bool {
+		return snapshot.SnapshotID == snapshotID
+	})
+	data.UpdatedAt = time.Now()
+
+	return fs.saveData(data)
+}
+
+// RecordImage stores a record of a machine image created from an instance
+func (fs *FileStorage) RecordImage(image *models.ImageRecord) error {
+	unlock := fs.lock()
+	defer unlock()
+
+	data, err := fs.loadData()
+	if err != nil {
+		return err
+	}
+
+	data.Images = append(data.Images, image)
+	data.UpdatedAt = time.Now()
+
+	return fs.saveData(data)
+}
+
+// ListImages returns the recorded machine images, oldest first
+func (fs *FileStorage) ListImages() ([]*models.ImageRecord, error) {
+	unlock := fs.rlock()
+	defer unlock()
+
+	data, err := fs.loadData()
+	if err != nil {
+		return nil, err
+	}
+	return data.Images, nil
+}
+
+// Snapshot returns the full contents of the storage file
+func (fs *FileStorage) Snapshot() (*StorageRecord, error) {
+	unlock := fs.rlock()
+	defer unlock()
+
+	return fs.loadData()
+}
+
+// Watch reports changes to the instance records, including those made by
+// other processes, until ctx is done
+func (fs *FileStorage) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	return fs.feed.watch(ctx, func() (map[string]*models.InstanceRecord, error) {
+		unlock := fs.rlock()
+		defer unlock()
+
+		data, err := fs.loadData()
+		if err != nil {
+			return nil, err
+		}
+		return data.Instances, nil
+	})
+}
+
+// Path returns the location of the storage file
+func (fs *FileStorage) Path() string {
+	return fs.filePath
+}
+
+// Location returns the path of the storage file
+func (fs *FileStorage) Location() string {
+	return fs.filePath
+}
+
 // UpdateInstance updates an instance record in storage
 func (fs *FileStorage) UpdateInstance(instance *models.Instance) error {
-	fs.mutex.Lock()
-	defer fs.mutex.Unlock()
+	unlock := fs.lock()
+	defer unlock()
 
 	data, err := fs.loadData()
 	if err != nil {
@@ -127,38 +296,200 @@ func (fs *FileStorage) UpdateInstance(instance *models.Instance) error {
 
 // DeleteInstance removes an instance record from storage
 func (fs *FileStorage) DeleteInstance(instanceID string) error {
-	fs.mutex.Lock()
-	defer fs.mutex.Unlock()
+	unlock := fs.lock()
+	defer unlock()
+
+	data, err := fs.loadData()
+	if err != nil {
+		return err
+	}
+
+	delete(data.Instances, instanceID)
+	data.UpdatedAt = time.Now()
+
+	return fs.saveData(data)
+}
+
+// ArchiveInstance moves an instance record from the managed instances to the
+// archive, noting why it was archived
+func (fs *FileStorage) ArchiveInstance(instanceID, reason string) error {
+	unlock := fs.lock()
+	defer unlock()
 
 	data, err := fs.loadData()
 	if err != nil {
 		return err
 	}
 
+	record, exists := data.Instances[instanceID]
+	if !exists {
+		return fmt.Errorf("instance %s not found", instanceID)
+	}
+
 	delete(data.Instances, instanceID)
+	data.Archived = append(data.Archived, &models.ArchivedInstance{
+		Instance:   record.Instance,
+		Reason:     reason,
+		ArchivedAt: time.Now(),
+	})
 	data.UpdatedAt = time.Now()
 
 	return fs.saveData(data)
 }
 
+// ListArchived returns the archived instance records, oldest first
+func (fs *FileStorage) ListArchived() ([]*models.ArchivedInstance, error) {
+	unlock := fs.rlock()
+	defer unlock()
+
+	data, err := fs.loadData()
+	if err != nil {
+		return nil, err
+	}
+	return data.Archived, nil
+}
+
+// RecordSchedulerRun keeps the report of the latest scheduler pass
+func (fs *FileStorage) RecordSchedulerRun(run *models.SchedulerRun) error {
+	unlock := fs.lock()
+	defer unlock()
+
+	data, err := fs.loadData()
+	if err != nil {
+		return err
+	}
+
+	data.LastRun = run
+	data.UpdatedAt = time.Now()
+
+	return fs.saveData(data)
+}
+
+// LastSchedulerRun returns the report of the latest scheduler pass, or nil
+// when the scheduler has not run yet
+func (fs *FileStorage) LastSchedulerRun() (*models.SchedulerRun, error) {
+	unlock := fs.rlock()
+	defer unlock()
+
+	data, err := fs.loadData()
+	if err != nil {
+		return nil, err
+	}
+	return data.LastRun, nil
+}
+
+// PauseScheduler suspends the scheduler's lifecycle actions until
+// ResumeScheduler is called or the pause ends by itself
+func (fs *FileStorage) PauseScheduler(pause *models.SchedulerPause) error {
+	unlock := fs.lock()
+	defer unlock()
+
+	data, err := fs.loadData()
+	if err != nil {
+		return err
+	}
+
+	data.Pause = pause
+	data.UpdatedAt = time.Now()
+
+	return fs.saveData(data)
+}
+
+// ResumeScheduler ends a pause of the scheduler. It returns false when the
+// scheduler was not paused.
+func (fs *FileStorage) ResumeScheduler() (bool, error) {
+	unlock := fs.lock()
+	defer unlock()
+
+	data, err := fs.loadData()
+	if err != nil {
+		return false, err
+	}
+	if !data.Pause.Active(time.Now()) {
+		return false, nil
+	}
+
+	data.Pause = nil
+	data.UpdatedAt = time.Now()
+
+	return true, fs.saveData(data)
+}
+
+// SchedulerPause returns the pause of the scheduler in effect, or nil when it
+// is not paused
+func (fs *FileStorage) SchedulerPause() (*models.SchedulerPause, error) {
+	unlock := fs.rlock()
+	defer unlock()
+
+	data, err := fs.loadData()
+	if err != nil {
+		return nil, err
+	}
+	if !data.Pause.Active(time.Now()) {
+		return nil, nil
+	}
+	return data.Pause, nil
+}
+
 // GetExpiredInstances returns instances that have exceeded their duration
 func (fs *FileStorage) GetExpiredInstances() ([]*models.Instance, error) {
-	fs.mutex.RLock()
-	defer fs.mutex.RUnlock()
+	unlock := fs.rlock()
+	defer unlock()
 
 	data, err := fs.loadData()
 	if err != nil {
-		return []*models.Instance{}, nil
+		return nil, err
 	}
 
-	var expiredInstances []*models.Instance
-	for _, record := range data.Instances {
-		if record.Instance.IsExpired() {
-			expiredInstances = append(expiredInstances, record.Instance)
-		}
+	return expiredInstances(data.Instances), nil
+}
+
+// GetTerminatedBefore returns terminated instances whose termination happened
+// before cutoff. Records without a termination time fall back to when they
+// were last updated.
+func (fs *FileStorage) GetTerminatedBefore(cutoff time.Time) ([]*models.Instance, error) {
+	unlock := fs.rlock()
+	defer unlock()
+
+	data, err := fs.loadData()
+	if err != nil {
+		return nil, err
 	}
 
-	return expiredInstances, nil
+	return terminatedBefore(data.Instances, cutoff), nil
+}
+
+// lock takes the storage's lock for a change: the mutex against other
+// goroutines and an exclusive lock on the lock file against other processes.
+// It returns the function that releases both.
+func (fs *FileStorage) lock() func() {
+	fs.mutex.Lock()
+	return fs.lockFile(true, fs.mutex.Unlock)
+}
+
+// rlock takes the storage's lock for reading, shared with other readers
+func (fs *FileStorage) rlock() func() {
+	fs.mutex.RLock()
+	return fs.lockFile(false, fs.mutex.RUnlock)
+}
+
+// lockFile locks the lock file and returns the function that unlocks it and
+// then calls release. When the lock file cannot be created, such as in a
+// read-only directory, only the mutex protects the storage.
+func (fs *FileStorage) lockFile(exclusive bool, release func()) func() {
+	file, err := os.OpenFile(fs.filePath+lockSuffix, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return release
+	}
+	if err := lockFile(file, exclusive); err != nil {
+		file.Close()
+		return release
+	}
+	return func() {
+		_ = unlockFile(file)
+		file.Close()
+		release()
+	}
 }
 
 // loadData loads data from the storage file
@@ -174,8 +505,27 @@ func (fs *FileStorage) loadData() (*StorageRecord, error) {
 		return nil, fmt.Errorf("failed to read storage file: %w", err)
 	}
 
+	if isEncrypted(data) {
+		if fs.key == nil {
+			return nil, ErrEncrypted
+		}
+		data, err = decrypt(fs.key, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt storage file: %w", err)
+		}
+	}
+	if bytes.HasPrefix(data, gzipMagic) {
+		data, err = decompress(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress storage file: %w", err)
+		}
+	}
+
 	var record StorageRecord
 	if err := json.Unmarshal(data, &record); err != nil {
+		if _, statErr := os.Stat(fs.filePath + backupSuffix); statErr == nil {
+			return nil, fmt.Errorf("failed to unmarshal storage data: %w (the previous version is in %s)", err, fs.filePath+backupSuffix)
+		}
 		return nil, fmt.Errorf("failed to unmarshal storage data: %w", err)
 	}
 
@@ -188,15 +538,128 @@ func (fs *FileStorage) loadData() (*StorageRecord, error) {
 
 // saveData saves data to the storage file
 func (fs *FileStorage) saveData(data *StorageRecord) error {
-	jsonData, err := json.MarshalIndent(data, "", "  ")
+	var jsonData []byte
+	var err error
+	if fs.compress {
+		// Indentation only costs space once the file is no longer human-readable
+		jsonData, err = json.Marshal(data)
+	} else {
+		jsonData, err = json.MarshalIndent(data, "", "  ")
+	}
 	if err != nil {
 		return fmt.Errorf("failed to marshal storage data: %w", err)
 	}
 
-	err = os.WriteFile(fs.filePath, jsonData, 0644)
+	if fs.compress {
+		jsonData, err = compress(jsonData)
+		if err != nil {
+			return fmt.Errorf("failed to compress storage data: %w", err)
+		}
+	}
+
+	if fs.key != nil {
+		jsonData, err = encrypt(fs.key, jsonData)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt storage data: %w", err)
+		}
+	}
+
+	if err := fs.replaceFile(jsonData); err != nil {
+		return err
+	}
+	fs.feed.changed()
+	return nil
+}
+
+// replaceFile atomically replaces the storage file with data, keeping the
+// previous version as the backup. The new file is only readable by its
+// owner, whatever the permissions of the old one.
+func (fs *FileStorage) replaceFile(data []byte) error {
+	dir, base := filepath.Split(fs.filePath)
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp-*")
 	if err != nil {
 		return fmt.Errorf("failed to write storage file: %w", err)
 	}
+	defer os.Remove(tmp.Name())
 
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write storage file: %w", err)
+	}
+
+	if err := fs.backup(); err != nil {
+		return fmt.Errorf("failed to back up storage file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), fs.filePath); err != nil {
+		return fmt.Errorf("failed to replace storage file: %w", err)
+	}
+	syncDir(dir)
 	return nil
 }
+
+// backup makes the current storage file the backup, replacing the previous
+// one. The file is linked rather than copied where the file system allows.
+func (fs *FileStorage) backup() error {
+	backupPath := fs.filePath + backupSuffix
+	tmpPath := backupPath + ".tmp"
+	_ = os.Remove(tmpPath)
+	if err := os.Link(fs.filePath, tmpPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		data, err := os.ReadFile(fs.filePath)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+			return err
+		}
+	}
+	// Files written by earlier versions were readable by everyone
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, backupPath)
+}
+
+// syncDir flushes a directory, so a rename in it survives a crash. Some
+// platforms and file systems cannot sync a directory, which is ignored.
+func syncDir(dir string) {
+	if dir == "" {
+		dir = "."
+	}
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
+
+// compress gzip-compresses data
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress decompresses gzip data
+func decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
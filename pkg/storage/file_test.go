@@ -1,7 +1,15 @@
 package storage_test
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -170,6 +178,68 @@ func TestFileStorage_DeleteInstance(t *testing.T) {
 	}
 }
 
+func TestFileStorage_ArchiveInstance(t *testing.T) {
+	fs := storage.NewFileStorage(filepath.Join(t.TempDir(), "test_instances.json"))
+
+	instance := &models.Instance{ID: "i-123456789", State: "terminated"}
+	if err := fs.SaveInstance(instance); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+
+	if err := fs.ArchiveInstance(instance.ID, models.ArchiveReasonStoppedTooLong); err != nil {
+		t.Fatalf("ArchiveInstance failed: %v", err)
+	}
+	if _, err := fs.GetInstance(instance.ID); err == nil {
+		t.Error("Expected archived instance to leave the managed instances")
+	}
+
+	archived, err := fs.ListArchived()
+	if err != nil {
+		t.Fatalf("ListArchived failed: %v", err)
+	}
+	if len(archived) != 1 || archived[0].Instance.ID != instance.ID || archived[0].Reason != models.ArchiveReasonStoppedTooLong {
+		t.Errorf("Unexpected archive: %+v", archived)
+	}
+
+	if err := fs.ArchiveInstance("i-missing", models.ArchiveReasonStoppedTooLong); err == nil {
+		t.Error("Expected an error when archiving a missing instance")
+	}
+}
+
+func TestArchiveTerminated(t *testing.T) {
+	fs := storage.NewFileStorage(filepath.Join(t.TempDir(), "test_instances.json"))
+	now := time.Now()
+
+	if err := fs.SaveInstance(&models.Instance{ID: "i-1", Name: "build-box", State: "running", PublicIP: "203.0.113.7"}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+	if err := storage.ArchiveTerminated(fs, "i-1", "alice", now); err != nil {
+		t.Fatalf("ArchiveTerminated failed: %v", err)
+	}
+	if _, err := fs.GetInstance("i-1"); err == nil {
+		t.Error("Expected the terminated instance to leave the managed instances")
+	}
+
+	record, err := storage.FindArchived(fs, "build-box")
+	if err != nil || record == nil {
+		t.Fatalf("Expected the archived record to be found by name, got %v, %v", record, err)
+	}
+	instance := record.Instance
+	if record.Reason != models.ArchiveReasonTerminated || instance.State != "terminated" || !instance.TerminatedAt.Equal(now) || instance.PublicIP != "203.0.113.7" {
+		t.Errorf("Unexpected archived record %+v, %+v", record, instance)
+	}
+	if len(instance.History) != 1 || instance.History[0].Event != models.EventTerminated || instance.History[0].Actor != "alice" {
+		t.Errorf("Expected the termination to be recorded, got %+v", instance.History)
+	}
+
+	if record, err := storage.FindArchived(fs, "i-missing"); err != nil || record != nil {
+		t.Errorf("Expected no archived record, got %v, %v", record, err)
+	}
+	if err := storage.ArchiveTerminated(fs, "i-missing", "alice", now); err == nil {
+		t.Error("Expected an error when archiving a missing instance")
+	}
+}
+
 func TestFileStorage_GetExpiredInstances(t *testing.T) {
 	// Create temporary file for testing
 	tempDir := t.TempDir()
@@ -211,3 +281,337 @@ func TestFileStorage_GetExpiredInstances(t *testing.T) {
 		t.Errorf("Wrong expired instance: got %s, want i-expired", expired[0].ID)
 	}
 }
+
+func TestFileStorage_GetTerminatedBefore(t *testing.T) {
+	fs := storage.NewFileStorage(filepath.Join(t.TempDir(), "test_instances.json"))
+
+	now := time.Now()
+	instances := []*models.Instance{
+		{ID: "i-old", State: "terminated", TerminatedAt: now.Add(-48 * time.Hour)},
+		{ID: "i-recent", State: "terminated", TerminatedAt: now.Add(-time.Hour)},
+		{ID: "i-unknown", State: "terminated"},
+		{ID: "i-running", State: "running"},
+	}
+	for _, instance := range instances {
+		if err := fs.SaveInstance(instance); err != nil {
+			t.Fatalf("SaveInstance failed: %v", err)
+		}
+	}
+
+	terminated, err := fs.GetTerminatedBefore(now.Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("GetTerminatedBefore failed: %v", err)
+	}
+	if len(terminated) != 1 || terminated[0].ID != "i-old" {
+		t.Errorf("Expected only i-old, got %+v", terminated)
+	}
+
+	// Without a termination time the record's update time is used
+	terminated, err = fs.GetTerminatedBefore(now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetTerminatedBefore failed: %v", err)
+	}
+	if len(terminated) != 3 {
+		t.Errorf("Expected 3 terminated instances, got %d", len(terminated))
+	}
+}
+
+func TestFileStorage_CompressedRoundTrip(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "instances.json.gz")
+
+	fs := storage.NewFileStorage(filePath)
+	instance := &models.Instance{
+		ID:        "i-compressed",
+		State:     "running",
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	}
+	if err := fs.SaveInstance(instance); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Failed to read storage file: %v", err)
+	}
+	if len(raw) < 2 || raw[0] != 0x1f || raw[1] != 0x8b {
+		t.Fatalf("Expected gzip header in storage file")
+	}
+
+	retrieved, err := storage.NewFileStorage(filePath).GetInstance("i-compressed")
+	if err != nil {
+		t.Fatalf("GetInstance failed: %v", err)
+	}
+	if retrieved.State != "running" {
+		t.Errorf("State mismatch: got %s, want running", retrieved.State)
+	}
+}
+
+func TestFileStorage_PlaintextStillLoads(t *testing.T) {
+	tempDir := t.TempDir()
+	plainPath := filepath.Join(tempDir, "instances.json")
+
+	fs := storage.NewFileStorage(plainPath)
+	if err := fs.SaveInstance(&models.Instance{ID: "i-plain", State: "running"}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+
+	raw, err := os.ReadFile(plainPath)
+	if err != nil {
+		t.Fatalf("Failed to read storage file: %v", err)
+	}
+	if !strings.HasPrefix(string(raw), "{") {
+		t.Fatalf("Expected plaintext JSON storage file")
+	}
+
+	// A plaintext store renamed to .gz is detected by its missing gzip header
+	gzPath := filepath.Join(tempDir, "instances.json.gz")
+	if err := os.WriteFile(gzPath, raw, 0644); err != nil {
+		t.Fatalf("Failed to write storage file: %v", err)
+	}
+
+	if _, err := storage.NewFileStorage(gzPath).GetInstance("i-plain"); err != nil {
+		t.Errorf("GetInstance from plaintext .gz file failed: %v", err)
+	}
+}
+
+func TestFileStorage_RecordSnapshot(t *testing.T) {
+	storage := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+
+	if err := storage.SaveInstance(&models.Instance{ID: "i-snap", State: "running"}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	snapshot := &models.SnapshotRecord{
+		SnapshotID: "snap-123",
+		InstanceID: "i-snap",
+		CreatedAt:  time.Now(),
+	}
+	if err := storage.RecordSnapshot(snapshot); err != nil {
+		t.Fatalf("Failed to record snapshot: %v", err)
+	}
+
+	// The snapshot record outlives the instance
+	if err := storage.DeleteInstance("i-snap"); err != nil {
+		t.Fatalf("Failed to delete instance: %v", err)
+	}
+
+	snapshots, err := storage.ListSnapshots()
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].SnapshotID != "snap-123" || snapshots[0].InstanceID != "i-snap" {
+		t.Errorf("Expected the snap-123 record for i-snap, got %+v", snapshots)
+	}
+}
+
+func TestFileStorage_DeleteSnapshot(t *testing.T) {
+	storage := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+
+	for _, id := range []string{"snap-1", "snap-2"} {
+		if err := storage.RecordSnapshot(&models.SnapshotRecord{SnapshotID: id, InstanceID: "i-snap"}); err != nil {
+			t.Fatalf("Failed to record snapshot: %v", err)
+		}
+	}
+	if err := storage.DeleteSnapshot("snap-1"); err != nil {
+		t.Fatalf("Failed to delete snapshot: %v", err)
+	}
+
+	snapshots, err := storage.ListSnapshots()
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].SnapshotID != "snap-2" {
+		t.Errorf("Expected only snap-2 to remain, got %+v", snapshots)
+	}
+}
+
+func TestFileStorage_FindByName(t *testing.T) {
+	storage := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+
+	for _, instance := range []*models.Instance{
+		{ID: "i-web", Name: "web"},
+		{ID: "i-db1", Name: "db"},
+		{ID: "i-db2", Name: "db"},
+		{ID: "i-unnamed"},
+	} {
+		if err := storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+
+	matches, err := storage.FindByName("web")
+	if err != nil {
+		t.Fatalf("FindByName failed: %v", err)
+	}
+	if len(matches) != 1 || matches[0].ID != "i-web" {
+		t.Errorf("Expected i-web, got %+v", matches)
+	}
+	if matches, _ := storage.FindByName("db"); len(matches) != 2 {
+		t.Errorf("Expected 2 instances named db, got %d", len(matches))
+	}
+	if matches, _ := storage.FindByName("missing"); len(matches) != 0 {
+		t.Errorf("Expected no instances named missing, got %d", len(matches))
+	}
+}
+
+func TestFileStorage_ListInstancesFiltered(t *testing.T) {
+	storage := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	now := time.Now()
+
+	for _, instance := range []*models.Instance{
+		{ID: "i-1", State: "running", Owner: "alice", Tags: map[string]string{"team": "qa"}, ExpiresAt: now.Add(time.Hour)},
+		{ID: "i-2", State: "stopped", Owner: "alice", Tags: map[string]string{"team": "qa"}, ExpiresAt: now.Add(3 * time.Hour)},
+		{ID: "i-3", State: "running", Owner: "bob", Provider: "gcp", ExpiresAt: now.Add(time.Hour)},
+		{ID: "i-4", State: "terminated", Owner: "alice", ExpiresAt: now.Add(-time.Hour)},
+	} {
+		if err := storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		filter   models.InstanceFilter
+		expected int
+	}{
+		{"all", models.InstanceFilter{}, 4},
+		{"running with a tag", models.InstanceFilter{States: []string{"running"}, Tags: map[string]string{"team": "qa"}}, 1},
+		{"not terminated", models.InstanceFilter{ExcludeStates: []string{"terminated"}}, 3},
+		{"owner", models.InstanceFilter{Owner: "alice"}, 3},
+		{"provider", models.InstanceFilter{Provider: "aws"}, 3},
+		{"expiring within two hours", models.InstanceFilter{ExpiresAfter: now, ExpiresBefore: now.Add(2 * time.Hour)}, 2},
+	}
+	for _, tt := range tests {
+		matches, err := storage.ListInstancesFiltered(tt.filter)
+		if err != nil {
+			t.Fatalf("ListInstancesFiltered failed: %v", err)
+		}
+		if len(matches) != tt.expected {
+			t.Errorf("%s: expected %d instances, got %d", tt.name, tt.expected, len(matches))
+		}
+	}
+}
+
+func TestFileStorage_Encrypted(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "instances.json")
+	key := bytes.Repeat([]byte{7}, storage.EncryptionKeySize)
+
+	// A plaintext file is encrypted when it is next written
+	if err := os.WriteFile(filePath, []byte(`{"instances":{"i-old":{"instance":{"id":"i-old","public_ip":"203.0.113.9"}}}}`), 0644); err != nil {
+		t.Fatalf("Failed to write storage file: %v", err)
+	}
+	fs, err := storage.NewEncryptedFileStorage(filePath, key)
+	if err != nil {
+		t.Fatalf("NewEncryptedFileStorage failed: %v", err)
+	}
+	if err := fs.SaveInstance(&models.Instance{ID: "i-new", Username: "alice"}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Failed to read storage file: %v", err)
+	}
+	if bytes.Contains(raw, []byte("203.0.113.9")) || bytes.Contains(raw, []byte("alice")) {
+		t.Error("Expected the storage file to be encrypted")
+	}
+	if info, err := os.Stat(filePath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the storage file to be readable by its owner only, got %v, %v", info.Mode().Perm(), err)
+	}
+
+	reopened, _ := storage.NewEncryptedFileStorage(filePath, key)
+	if instances, err := reopened.ListInstances(); err != nil || len(instances) != 2 {
+		t.Errorf("Expected 2 instances, got %d, %v", len(instances), err)
+	}
+
+	// Without the key the file is neither read nor replaced
+	plain := storage.NewFileStorage(filePath)
+	if _, err := plain.ListInstances(); !errors.Is(err, storage.ErrEncrypted) {
+		t.Errorf("Expected ErrEncrypted, got %v", err)
+	}
+	if err := plain.SaveInstance(&models.Instance{ID: "i-other"}); err == nil {
+		t.Error("Expected SaveInstance to refuse an encrypted file")
+	}
+	wrong, _ := storage.NewEncryptedFileStorage(filePath, bytes.Repeat([]byte{8}, storage.EncryptionKeySize))
+	if _, err := wrong.GetInstance("i-new"); err == nil {
+		t.Error("Expected an error with the wrong key")
+	}
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, storage.EncryptionKeySize)
+	for _, encoded := range []string{base64.StdEncoding.EncodeToString(key), hex.EncodeToString(key) + "\n"} {
+		if parsed, err := storage.ParseEncryptionKey(encoded); err != nil || !bytes.Equal(parsed, key) {
+			t.Errorf("ParseEncryptionKey(%q) = %x, %v", encoded, parsed, err)
+		}
+	}
+	if _, err := storage.ParseEncryptionKey("c2hvcnQ="); err == nil {
+		t.Error("Expected an error for a short key")
+	}
+}
+
+func TestFileStorage_AtomicWritesKeepBackup(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "instances.json")
+	fs := storage.NewFileStorage(filePath)
+
+	if err := fs.SaveInstance(&models.Instance{ID: "i-1"}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+	if err := fs.SaveInstance(&models.Instance{ID: "i-2"}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+
+	backup, err := os.ReadFile(filePath + ".bak")
+	if err != nil {
+		t.Fatalf("Expected a backup of the previous version: %v", err)
+	}
+	if !bytes.Contains(backup, []byte("i-1")) || bytes.Contains(backup, []byte("i-2")) {
+		t.Errorf("Expected the backup to hold the previous version, got %s", backup)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp") {
+			t.Errorf("Expected no temporary files to be left, found %s", entry.Name())
+		}
+	}
+
+	// A corrupted file is reported along with the backup, and not replaced
+	if err := os.WriteFile(filePath, []byte(`{"instances":`), 0600); err != nil {
+		t.Fatalf("Failed to corrupt storage file: %v", err)
+	}
+	if _, err := fs.GetInstance("i-1"); err == nil || !strings.Contains(err.Error(), ".bak") {
+		t.Errorf("Expected an error naming the backup, got %v", err)
+	}
+	if err := fs.SaveInstance(&models.Instance{ID: "i-3"}); err == nil {
+		t.Error("Expected SaveInstance to refuse a corrupted file")
+	}
+	if backup, _ := os.ReadFile(filePath + ".bak"); !bytes.Contains(backup, []byte("i-1")) {
+		t.Error("Expected the backup to be kept")
+	}
+}
+
+func TestFileStorage_ConcurrentStores(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "instances.json")
+
+	// Separate stores lock the file like separate processes would
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fs := storage.NewFileStorage(filePath)
+			for j := 0; j < 10; j++ {
+				if err := fs.SaveInstance(&models.Instance{ID: fmt.Sprintf("i-%d-%d", i, j)}); err != nil {
+					t.Errorf("SaveInstance failed: %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	instances, err := storage.NewFileStorage(filePath).ListInstances()
+	if err != nil || len(instances) != 40 {
+		t.Errorf("Expected every write to be kept, got %d instances, %v", len(instances), err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package storage
+
+import "os"
+
+// lockFile does nothing where file locks are not available: changes are
+// then only serialized within a process, though each write stays atomic
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
+
+// unlockFile releases a lock taken by lockFile
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,386 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"instance-manager/pkg/models"
+)
+
+// MemoryStorage keeps instance records in the memory of the process, for
+// tests and throwaway demos; they are lost when the process exits. Records
+// are copied in and out, so callers cannot change them without saving them,
+// as with the other backends. Instance records carry a version, and updates
+// of an instance that carries a revision fail with ErrConflict when the
+// record changed since it was read.
+type MemoryStorage struct {
+	mu       sync.RWMutex
+	data     *StorageRecord
+	versions map[string]int64 // Version of each instance record
+	feed     changeFeed
+}
+
+var _ Storage = (*MemoryStorage)(nil)
+
+// NewMemoryStorage creates an empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		data:     &StorageRecord{Instances: make(map[string]*models.InstanceRecord)},
+		versions: make(map[string]int64),
+	}
+}
+
+// SaveInstance stores an instance record, replacing any with the same ID
+func (m *MemoryStorage) SaveInstance(instance *models.Instance) error {
+	stored, err := clone(instance)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	now := time.Now()
+	record := &models.InstanceRecord{Instance: stored, CreatedAt: now, UpdatedAt: now}
+	if existing := m.data.Instances[instance.ID]; existing != nil {
+		record.CreatedAt = existing.CreatedAt
+	}
+	m.versions[instance.ID]++
+	instance.Revision = m.versions[instance.ID]
+	m.data.Instances[instance.ID] = record
+	m.data.UpdatedAt = now
+	m.mu.Unlock()
+
+	m.feed.changed()
+	return nil
+}
+
+// GetInstance returns the record of an instance
+func (m *MemoryStorage) GetInstance(instanceID string) (*models.Instance, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	record, exists := m.data.Instances[instanceID]
+	if !exists {
+		return nil, fmt.Errorf("instance %s not found", instanceID)
+	}
+	return m.instance(record)
+}
+
+// UpdateInstance replaces the record of a stored instance. When the
+// instance carries a revision, the update fails with ErrConflict if the
+// record changed since.
+func (m *MemoryStorage) UpdateInstance(instance *models.Instance) error {
+	stored, err := clone(instance)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	existing, exists := m.data.Instances[instance.ID]
+	if !exists {
+		m.mu.Unlock()
+		return fmt.Errorf("instance %s not found", instance.ID)
+	}
+	if instance.Revision != 0 && instance.Revision != m.versions[instance.ID] {
+		m.mu.Unlock()
+		return fmt.Errorf("failed to update instance %s: %w", instance.ID, ErrConflict)
+	}
+	now := time.Now()
+	// Records are replaced rather than changed, as watchers may hold them
+	m.data.Instances[instance.ID] = &models.InstanceRecord{Instance: stored, CreatedAt: existing.CreatedAt, UpdatedAt: now}
+	m.versions[instance.ID]++
+	instance.Revision = m.versions[instance.ID]
+	m.data.UpdatedAt = now
+	m.mu.Unlock()
+
+	m.feed.changed()
+	return nil
+}
+
+// DeleteInstance removes the record of an instance
+func (m *MemoryStorage) DeleteInstance(instanceID string) error {
+	m.mu.Lock()
+	delete(m.data.Instances, instanceID)
+	delete(m.versions, instanceID)
+	m.data.UpdatedAt = time.Now()
+	m.mu.Unlock()
+
+	m.feed.changed()
+	return nil
+}
+
+// ListInstances returns all stored instances, sorted by ID
+func (m *MemoryStorage) ListInstances() ([]*models.Instance, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.data.Instances))
+	for id := range m.data.Instances {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	instances := make([]*models.Instance, 0, len(ids))
+	for _, id := range ids {
+		instance, err := m.instance(m.data.Instances[id])
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// ListInstancesFiltered returns the stored instances the filter matches
+func (m *MemoryStorage) ListInstancesFiltered(filter models.InstanceFilter) ([]*models.Instance, error) {
+	instances, err := m.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+	return filter.Filter(instances), nil
+}
+
+// FindByName returns the stored instances with the given name
+func (m *MemoryStorage) FindByName(name string) ([]*models.Instance, error) {
+	instances, err := m.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+	return namedInstances(instances, name), nil
+}
+
+// GetExpiredInstances returns instances that have exceeded their duration
+func (m *MemoryStorage) GetExpiredInstances() ([]*models.Instance, error) {
+	records, err := m.instanceRecords()
+	if err != nil {
+		return nil, err
+	}
+	return expiredInstances(records), nil
+}
+
+// GetTerminatedBefore returns terminated instances whose termination happened
+// before cutoff, sorted by ID. Records without a termination time fall back
+// to when they were last updated.
+func (m *MemoryStorage) GetTerminatedBefore(cutoff time.Time) ([]*models.Instance, error) {
+	records, err := m.instanceRecords()
+	if err != nil {
+		return nil, err
+	}
+	return terminatedBefore(records, cutoff), nil
+}
+
+// ArchiveInstance moves an instance record to the archive, noting why it was
+// archived
+func (m *MemoryStorage) ArchiveInstance(instanceID, reason string) error {
+	m.mu.Lock()
+	record, exists := m.data.Instances[instanceID]
+	if !exists {
+		m.mu.Unlock()
+		return fmt.Errorf("instance %s not found", instanceID)
+	}
+	now := time.Now()
+	delete(m.data.Instances, instanceID)
+	delete(m.versions, instanceID)
+	m.data.Archived = append(m.data.Archived, &models.ArchivedInstance{
+		Instance:   record.Instance,
+		Reason:     reason,
+		ArchivedAt: now,
+	})
+	m.data.UpdatedAt = now
+	m.mu.Unlock()
+
+	m.feed.changed()
+	return nil
+}
+
+// ListArchived returns the archived instance records, oldest first
+func (m *MemoryStorage) ListArchived() ([]*models.ArchivedInstance, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return cloneAll(m.data.Archived)
+}
+
+// RecordSnapshot stores a record of a volume snapshot
+func (m *MemoryStorage) RecordSnapshot(snapshot *models.SnapshotRecord) error {
+	stored, err := clone(snapshot)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.Snapshots = append(m.data.Snapshots, stored)
+	m.data.UpdatedAt = time.Now()
+	return nil
+}
+
+// ListSnapshots returns the recorded snapshots, oldest first
+func (m *MemoryStorage) ListSnapshots() ([]*models.SnapshotRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return cloneAll(m.data.Snapshots)
+}
+
+// DeleteSnapshot removes the record of a snapshot
+func (m *MemoryStorage) DeleteSnapshot(snapshotID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.Snapshots = slices.DeleteFunc(m.data.Snapshots, func(snapshot *models.SnapshotRecord) bool {
+		return snapshot.SnapshotID == snapshotID
+	})
+	m.data.UpdatedAt = time.Now()
+	return nil
+}
+
+// RecordImage stores a record of a machine image created from an instance
+func (m *MemoryStorage) RecordImage(image *models.ImageRecord) error {
+	stored, err := clone(image)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.Images = append(m.data.Images, stored)
+	m.data.UpdatedAt = time.Now()
+	return nil
+}
+
+// ListImages returns the recorded machine images, oldest first
+func (m *MemoryStorage) ListImages() ([]*models.ImageRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return cloneAll(m.data.Images)
+}
+
+// RecordSchedulerRun keeps the report of the latest scheduler pass
+func (m *MemoryStorage) RecordSchedulerRun(run *models.SchedulerRun) error {
+	stored, err := clone(run)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.LastRun = stored
+	m.data.UpdatedAt = time.Now()
+	return nil
+}
+
+// LastSchedulerRun returns the report of the latest scheduler pass, or nil
+// when the scheduler has not run yet
+func (m *MemoryStorage) LastSchedulerRun() (*models.SchedulerRun, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return clone(m.data.LastRun)
+}
+
+// PauseScheduler suspends the scheduler's lifecycle actions until
+// ResumeScheduler is called or the pause ends by itself
+func (m *MemoryStorage) PauseScheduler(pause *models.SchedulerPause) error {
+	stored, err := clone(pause)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.Pause = stored
+	m.data.UpdatedAt = time.Now()
+	return nil
+}
+
+// ResumeScheduler ends a pause of the scheduler. It returns false when the
+// scheduler was not paused.
+func (m *MemoryStorage) ResumeScheduler() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.data.Pause.Active(time.Now()) {
+		return false, nil
+	}
+	m.data.Pause = nil
+	m.data.UpdatedAt = time.Now()
+	return true, nil
+}
+
+// SchedulerPause returns the pause of the scheduler in effect, or nil when it
+// is not paused
+func (m *MemoryStorage) SchedulerPause() (*models.SchedulerPause, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.data.Pause.Active(time.Now()) {
+		return nil, nil
+	}
+	return clone(m.data.Pause)
+}
+
+// Watch reports changes to the instance records until ctx is done. Every
+// change is made through the storage itself, so it is reported at once.
+func (m *MemoryStorage) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	return m.feed.watch(ctx, m.instanceRecords)
+}
+
+// Snapshot returns a copy of the full contents of the storage
+func (m *MemoryStorage) Snapshot() (*StorageRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return clone(m.data)
+}
+
+// Location describes where the records are kept
+func (m *MemoryStorage) Location() string {
+	return "memory"
+}
+
+// instanceRecords returns the instance records by ID. The records are shared
+// with the storage, which replaces rather than changes them.
+func (m *MemoryStorage) instanceRecords() (map[string]*models.InstanceRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	records := make(map[string]*models.InstanceRecord, len(m.data.Instances))
+	for id, record := range m.data.Instances {
+		records[id] = record
+	}
+	return records, nil
+}
+
+// instance returns a copy of the instance of a record with its revision. The
+// caller holds the lock.
+func (m *MemoryStorage) instance(record *models.InstanceRecord) (*models.Instance, error) {
+	instance, err := clone(record.Instance)
+	if err != nil {
+		return nil, err
+	}
+	instance.Revision = m.versions[instance.ID]
+	return instance, nil
+}
+
+// clone returns a copy of value through its JSON encoding, as the backends
+// that serialize their records would return it
+func clone[T any](value *T) (*T, error) {
+	if value == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var copied T
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return nil, err
+	}
+	return &copied, nil
+}
+
+// cloneAll returns copies of values
+func cloneAll[T any](values []*T) ([]*T, error) {
+	var copies []*T
+	for _, value := range values {
+		copied, err := clone(value)
+		if err != nil {
+			return nil, err
+		}
+		copies = append(copies, copied)
+	}
+	return copies, nil
+}
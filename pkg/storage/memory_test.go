@@ -0,0 +1,113 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/storage"
+)
+
+func TestMemoryStorage_Instances(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	instance := &models.Instance{ID: "i-1", Name: "box", State: "running", ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := store.SaveInstance(instance); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+
+	// Records are copies: changing one is not stored until it is saved
+	instance.State = "stopped"
+	got, err := store.GetInstance("i-1")
+	if err != nil || got.State != "running" {
+		t.Fatalf("Expected the stored record to be unchanged, got %+v, %v", got, err)
+	}
+	got.Name = "changed"
+	if again, _ := store.GetInstance("i-1"); again.Name != "box" {
+		t.Errorf("Expected a read record to be a copy, got %q", again.Name)
+	}
+
+	if found, _ := store.FindByName("box"); len(found) != 1 {
+		t.Errorf("Expected to find one instance named box, got %d", len(found))
+	}
+	if expired, _ := store.GetExpiredInstances(); len(expired) != 1 {
+		t.Errorf("Expected one expired instance, got %d", len(expired))
+	}
+	if filtered, _ := store.ListInstancesFiltered(models.InstanceFilter{States: []string{"stopped"}}); len(filtered) != 0 {
+		t.Errorf("Expected no stopped instances, got %d", len(filtered))
+	}
+
+	if err := store.ArchiveInstance("i-1", models.ArchiveReasonTerminated); err != nil {
+		t.Fatalf("ArchiveInstance failed: %v", err)
+	}
+	if _, err := store.GetInstance("i-1"); err == nil {
+		t.Error("Expected the archived instance to be gone")
+	}
+	archived, err := store.ListArchived()
+	if err != nil || len(archived) != 1 || archived[0].Instance.ID != "i-1" {
+		t.Errorf("Expected i-1 to be archived, got %v, %v", archived, err)
+	}
+	if err := store.UpdateInstance(&models.Instance{ID: "i-1"}); err == nil {
+		t.Error("Expected updating a missing instance to fail")
+	}
+}
+
+func TestMemoryStorage_Conflicts(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	if err := store.SaveInstance(&models.Instance{ID: "i-1", State: "running"}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+
+	first, _ := store.GetInstance("i-1")
+	second, _ := store.GetInstance("i-1")
+	first.State = "stopped"
+	if err := store.UpdateInstance(first); err != nil {
+		t.Fatalf("UpdateInstance failed: %v", err)
+	}
+	second.State = "running"
+	if err := store.UpdateInstance(second); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+
+	// An instance without a revision overwrites the record
+	if err := store.UpdateInstance(&models.Instance{ID: "i-1", State: "running"}); err != nil {
+		t.Errorf("UpdateInstance without a revision failed: %v", err)
+	}
+}
+
+func TestMemoryStorage_StateAndWatch(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	if err := store.PauseScheduler(&models.SchedulerPause{PausedAt: time.Now(), Reason: "maintenance"}); err != nil {
+		t.Fatalf("PauseScheduler failed: %v", err)
+	}
+	if pause, err := store.SchedulerPause(); err != nil || pause == nil || pause.Reason != "maintenance" {
+		t.Errorf("Expected the pause, got %+v, %v", pause, err)
+	}
+	if resumed, err := store.ResumeScheduler(); err != nil || !resumed {
+		t.Errorf("Expected the scheduler to resume, got %v, %v", resumed, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := store.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	if err := store.SaveInstance(&models.Instance{ID: "i-1"}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+	select {
+	case event := <-events:
+		if event.Type != storage.ChangeCreated || event.InstanceID != "i-1" {
+			t.Errorf("Expected i-1 to be created, got %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a change")
+	}
+
+	record, err := store.Snapshot()
+	if err != nil || len(record.Instances) != 1 || record.Pause != nil {
+		t.Errorf("Unexpected snapshot: %+v, %v", record, err)
+	}
+}
@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"instance-manager/pkg/models"
+)
+
+// ownerStorage is the view of a storage one user has: the instances they own,
+// with the archive, snapshot and image records of those instances. Records
+// of other users' instances read as missing and cannot be written.
+type ownerStorage struct {
+	Storage
+	owner string
+}
+
+// ForOwner returns the view of store limited to the instances owner owns.
+// Instances saved through it without an owner are given owner. Records
+// without an owner, kept from before instances had one, are only visible
+// through store itself. The scheduler state is shared by every view.
+func ForOwner(store Storage, owner string) Storage {
+	return &ownerStorage{Storage: store, owner: owner}
+}
+
+// SaveInstance stores an instance record of the owner, replacing any with the
+// same ID that the owner owns
+func (o *ownerStorage) SaveInstance(instance *models.Instance) error {
+	if instance.Owner == "" {
+		instance.Owner = o.owner
+	}
+	if instance.Owner != o.owner {
+		return fmt.Errorf("instance %s is owned by %s", instance.ID, instance.Owner)
+	}
+	if existing, err := o.Storage.GetInstance(instance.ID); err == nil && existing.Owner != o.owner {
+		return fmt.Errorf("instance %s is owned by another user", instance.ID)
+	}
+	return o.Storage.SaveInstance(instance)
+}
+
+// GetInstance returns the record of an instance of the owner
+func (o *ownerStorage) GetInstance(instanceID string) (*models.Instance, error) {
+	instance, err := o.Storage.GetInstance(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if instance.Owner != o.owner {
+		return nil, fmt.Errorf("instance %s not found", instanceID)
+	}
+	return instance, nil
+}
+
+// UpdateInstance replaces the record of a stored instance of the owner
+func (o *ownerStorage) UpdateInstance(instance *models.Instance) error {
+	if _, err := o.GetInstance(instance.ID); err != nil {
+		return err
+	}
+	if instance.Owner == "" {
+		instance.Owner = o.owner
+	}
+	if instance.Owner != o.owner {
+		return fmt.Errorf("cannot give instance %s to %s", instance.ID, instance.Owner)
+	}
+	return o.Storage.UpdateInstance(instance)
+}
+
+// DeleteInstance removes the record of an instance of the owner
+func (o *ownerStorage) DeleteInstance(instanceID string) error {
+	if _, err := o.GetInstance(instanceID); err != nil {
+		return err
+	}
+	return o.Storage.DeleteInstance(instanceID)
+}
+
+// ListInstances returns the stored instances of the owner
+func (o *ownerStorage) ListInstances() ([]*models.Instance, error) {
+	return o.Storage.ListInstancesFiltered(models.InstanceFilter{Owner: o.owner})
+}
+
+// ListInstancesFiltered returns the stored instances of the owner the filter
+// matches. A filter for another owner matches nothing.
+func (o *ownerStorage) ListInstancesFiltered(filter models.InstanceFilter) ([]*models.Instance, error) {
+	if filter.Owner != "" && filter.Owner != o.owner {
+		return nil, nil
+	}
+	filter.Owner = o.owner
+	return o.Storage.ListInstancesFiltered(filter)
+}
+
+// FindByName returns the stored instances of the owner with the given name
+func (o *ownerStorage) FindByName(name string) ([]*models.Instance, error) {
+	instances, err := o.Storage.FindByName(name)
+	return o.owned(instances), err
+}
+
+// GetExpiredInstances returns the instances of the owner that have exceeded
+// their duration
+func (o *ownerStorage) GetExpiredInstances() ([]*models.Instance, error) {
+	instances, err := o.Storage.GetExpiredInstances()
+	return o.owned(instances), err
+}
+
+// GetTerminatedBefore returns the terminated instances of the owner whose
+// termination happened before cutoff, sorted by ID
+func (o *ownerStorage) GetTerminatedBefore(cutoff time.Time) ([]*models.Instance, error) {
+	instances, err := o.Storage.GetTerminatedBefore(cutoff)
+	return o.owned(instances), err
+}
+
+// ArchiveInstance moves the record of an instance of the owner to the archive
+func (o *ownerStorage) ArchiveInstance(instanceID, reason string) error {
+	if _, err := o.GetInstance(instanceID); err != nil {
+		return err
+	}
+	return o.Storage.ArchiveInstance(instanceID, reason)
+}
+
+// ListArchived returns the archived records of the owner's instances, oldest
+// first
+func (o *ownerStorage) ListArchived() ([]*models.ArchivedInstance, error) {
+	archived, err := o.Storage.ListArchived()
+	if err != nil {
+		return nil, err
+	}
+	var owned []*models.ArchivedInstance
+	for _, entry := range archived {
+		if entry.Instance != nil && entry.Instance.Owner == o.owner {
+			owned = append(owned, entry)
+		}
+	}
+	return owned, nil
+}
+
+// ListSnapshots returns the recorded snapshots of the owner's instances,
+// including those archived, oldest first
+func (o *ownerStorage) ListSnapshots() ([]*models.SnapshotRecord, error) {
+	snapshots, err := o.Storage.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := o.instanceIDs()
+	if err != nil {
+		return nil, err
+	}
+	var owned []*models.SnapshotRecord
+	for _, snapshot := range snapshots {
+		if ids[snapshot.InstanceID] {
+			owned = append(owned, snapshot)
+		}
+	}
+	return owned, nil
+}
+
+// ListImages returns the recorded machine images of the owner's instances,
+// including those archived, oldest first
+func (o *ownerStorage) ListImages() ([]*models.ImageRecord, error) {
+	images, err := o.Storage.ListImages()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := o.instanceIDs()
+	if err != nil {
+		return nil, err
+	}
+	var owned []*models.ImageRecord
+	for _, image := range images {
+		if ids[image.InstanceID] {
+			owned = append(owned, image)
+		}
+	}
+	return owned, nil
+}
+
+// Watch reports changes to the records of the owner's instances until ctx is
+// done. An instance given to another owner is reported as deleted.
+func (o *ownerStorage) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	changes, err := o.Storage.Watch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan ChangeEvent, watchBuffer)
+	go func() {
+		defer close(events)
+		for change := range changes {
+			ownedBefore := change.Previous != nil && change.Previous.Owner == o.owner
+			ownedAfter := change.Instance != nil && change.Instance.Owner == o.owner
+			switch {
+			case !ownedBefore && !ownedAfter:
+				continue
+			case !ownedBefore:
+				change.Type, change.Previous = ChangeCreated, nil
+			case !ownedAfter:
+				change.Type, change.Instance = ChangeDeleted, nil
+			}
+			select {
+			case events <- change:
+			default:
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Snapshot returns the records of the owner's instances with their archive,
+// snapshot and image records, and the scheduler state
+func (o *ownerStorage) Snapshot() (*StorageRecord, error) {
+	record, err := o.Storage.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	instances := make(map[string]*models.InstanceRecord)
+	for id, instance := range record.Instances {
+		if instance.Instance != nil && instance.Instance.Owner == o.owner {
+			instances[id] = instance
+		}
+	}
+	ids := make(map[string]bool, len(instances))
+	for id := range instances {
+		ids[id] = true
+	}
+	var archived []*models.ArchivedInstance
+	for _, entry := range record.Archived {
+		if entry.Instance != nil && entry.Instance.Owner == o.owner {
+			archived = append(archived, entry)
+			ids[entry.Instance.ID] = true
+		}
+	}
+	var snapshots []*models.SnapshotRecord
+	for _, snapshot := range record.Snapshots {
+		if ids[snapshot.InstanceID] {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	var images []*models.ImageRecord
+	for _, image := range record.Images {
+		if ids[image.InstanceID] {
+			images = append(images, image)
+		}
+	}
+	record.Instances, record.Archived, record.Snapshots, record.Images = instances, archived, snapshots, images
+	return record, nil
+}
+
+// Location describes where the records are kept and whose they are
+func (o *ownerStorage) Location() string {
+	return fmt.Sprintf("%s (owner %s)", o.Storage.Location(), o.owner)
+}
+
+// owned returns the instances of the owner
+func (o *ownerStorage) owned(instances []*models.Instance) []*models.Instance {
+	var owned []*models.Instance
+	for _, instance := range instances {
+		if instance.Owner == o.owner {
+			owned = append(owned, instance)
+		}
+	}
+	return owned
+}
+
+// instanceIDs returns the IDs of the owner's instances, stored or archived
+func (o *ownerStorage) instanceIDs() (map[string]bool, error) {
+	instances, err := o.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+	archived, err := o.ListArchived()
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(instances)+len(archived))
+	for _, instance := range instances {
+		ids[instance.ID] = true
+	}
+	for _, entry := range archived {
+		ids[entry.Instance.ID] = true
+	}
+	return ids, nil
+}
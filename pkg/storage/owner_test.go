@@ -0,0 +1,87 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/storage"
+)
+
+func TestForOwner(t *testing.T) {
+	store := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	alice := storage.ForOwner(store, "alice")
+	bob := storage.ForOwner(store, "bob")
+
+	if err := alice.SaveInstance(&models.Instance{ID: "i-alice", Name: "box"}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+	if err := bob.SaveInstance(&models.Instance{ID: "i-bob", Name: "box"}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+	if err := store.SaveInstance(&models.Instance{ID: "i-legacy", Name: "box"}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+	if err := store.RecordSnapshot(&models.SnapshotRecord{SnapshotID: "snap-1", InstanceID: "i-bob"}); err != nil {
+		t.Fatalf("RecordSnapshot failed: %v", err)
+	}
+
+	if instance, err := store.GetInstance("i-alice"); err != nil || instance.Owner != "alice" {
+		t.Errorf("Expected the view to record alice as the owner, got %+v, %v", instance, err)
+	}
+	instances, err := alice.ListInstances()
+	if err != nil || len(instances) != 1 || instances[0].ID != "i-alice" {
+		t.Errorf("Expected alice to list only the instance alice owns, got %v, %v", instances, err)
+	}
+	if found, _ := alice.FindByName("box"); len(found) != 1 {
+		t.Errorf("Expected alice to find one instance named box, got %d", len(found))
+	}
+	if all, _ := store.ListInstances(); len(all) != 3 {
+		t.Errorf("Expected the storage itself to list every instance, got %d", len(all))
+	}
+	if filtered, _ := alice.ListInstancesFiltered(models.InstanceFilter{Owner: "bob"}); len(filtered) != 0 {
+		t.Errorf("Expected a filter for bob to match none of alice's instances, got %v", filtered)
+	}
+
+	// Other users' instances read as missing and cannot be changed
+	if _, err := alice.GetInstance("i-bob"); err == nil {
+		t.Error("Expected alice not to read bob's instance")
+	}
+	if _, err := alice.GetInstance("i-legacy"); err == nil {
+		t.Error("Expected alice not to read an instance without an owner")
+	}
+	if err := alice.SaveInstance(&models.Instance{ID: "i-bob"}); err == nil {
+		t.Error("Expected alice not to overwrite bob's instance")
+	}
+	if err := alice.ArchiveInstance("i-bob", models.ArchiveReasonTerminated); err == nil {
+		t.Error("Expected alice not to archive bob's instance")
+	}
+	if err := alice.DeleteInstance("i-bob"); err == nil {
+		t.Error("Expected alice not to delete bob's instance")
+	}
+	if _, err := store.GetInstance("i-bob"); err != nil {
+		t.Errorf("Expected bob's instance to be kept: %v", err)
+	}
+
+	// Archived instances and their snapshots stay with their owner
+	if err := bob.ArchiveInstance("i-bob", models.ArchiveReasonTerminated); err != nil {
+		t.Fatalf("ArchiveInstance failed: %v", err)
+	}
+	if archived, _ := alice.ListArchived(); len(archived) != 0 {
+		t.Errorf("Expected alice to see no archived instances, got %d", len(archived))
+	}
+	if snapshots, _ := bob.ListSnapshots(); len(snapshots) != 1 {
+		t.Errorf("Expected bob to see the snapshot of the archived instance, got %d", len(snapshots))
+	}
+	if snapshots, _ := alice.ListSnapshots(); len(snapshots) != 0 {
+		t.Errorf("Expected alice to see no snapshots, got %d", len(snapshots))
+	}
+
+	record, err := alice.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if len(record.Instances) != 1 || record.Instances["i-alice"] == nil || len(record.Archived) != 0 || len(record.Snapshots) != 0 {
+		t.Errorf("Expected alice's snapshot to hold only the records alice owns, got %+v", record)
+	}
+}
@@ -0,0 +1,665 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/models"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // Registers the pgx driver
+)
+
+// postgresQueryTimeout bounds each query to PostgreSQL
+const postgresQueryTimeout = 30 * time.Second
+
+// postgresMigrationLock is the advisory lock key held while migrating, so
+// processes starting together apply each migration once
+const postgresMigrationLock = 7275318460313563648
+
+// Keys of the service_state table
+const (
+	postgresLastRunKey = "last_run"
+	postgresPauseKey   = "pause"
+)
+
+// postgresMigrations are the schema changes in the order they are applied.
+// Each is recorded in schema_migrations once applied; append new ones,
+// never edit applied ones.
+var postgresMigrations = [][]string{
+	{
+		`CREATE TABLE instances (
+			id text PRIMARY KEY,
+			name text NOT NULL DEFAULT '',
+			provider text NOT NULL DEFAULT '',
+			account text NOT NULL DEFAULT '',
+			region text NOT NULL DEFAULT '',
+			instance_type text NOT NULL DEFAULT '',
+			state text NOT NULL DEFAULT '',
+			expires_at timestamptz NOT NULL,
+			terminated_at timestamptz,
+			created_at timestamptz NOT NULL,
+			updated_at timestamptz NOT NULL,
+			version bigint NOT NULL,
+			data jsonb NOT NULL
+		)`,
+		`CREATE INDEX instances_name_idx ON instances (name)`,
+		`CREATE INDEX instances_expires_at_idx ON instances (expires_at)`,
+		`CREATE INDEX instances_state_idx ON instances (state)`,
+		`CREATE TABLE archived_instances (
+			seq bigserial PRIMARY KEY,
+			instance_id text NOT NULL,
+			reason text NOT NULL DEFAULT '',
+			archived_at timestamptz NOT NULL,
+			data jsonb NOT NULL
+		)`,
+		`CREATE INDEX archived_instances_instance_id_idx ON archived_instances (instance_id)`,
+		`CREATE TABLE snapshots (
+			snapshot_id text PRIMARY KEY,
+			instance_id text NOT NULL DEFAULT '',
+			created_at timestamptz NOT NULL,
+			data jsonb NOT NULL
+		)`,
+		`CREATE TABLE images (
+			image_id text PRIMARY KEY,
+			instance_id text NOT NULL DEFAULT '',
+			created_at timestamptz NOT NULL,
+			data jsonb NOT NULL
+		)`,
+		`CREATE TABLE service_state (
+			key text PRIMARY KEY,
+			updated_at timestamptz NOT NULL,
+			data jsonb NOT NULL
+		)`,
+	},
+	{
+		`ALTER TABLE instances ADD COLUMN owner text NOT NULL DEFAULT ''`,
+		`UPDATE instances SET owner = COALESCE(data->>'owner', '')`,
+		`CREATE INDEX instances_owner_idx ON instances (owner)`,
+	},
+}
+
+// instanceColumns are the columns an instance is read from
+const instanceColumns = "data, version, created_at, updated_at"
+
+// PostgresStorage keeps instance records in PostgreSQL, so they can be
+// shared by several hosts and reported on with SQL. Each instance is a row
+// of the instances table: the full record is JSON in the data column, and
+// the fields reports filter on most (name, provider, account, region,
+// instance type, state, owner, expiry and termination) have columns of their
+// own.
+// Updates of an instance that carries a revision are conditional on the
+// version column, as with DynamoDBStorage.
+type PostgresStorage struct {
+	db       *sql.DB
+	location string
+	feed     changeFeed
+}
+
+// NewPostgresStorage connects to the database at url, a postgres:// URL,
+// and migrates its schema to the current version
+func NewPostgresStorage(ctx context.Context, url string) (*PostgresStorage, error) {
+	db, err := sql.Open("pgx", url)
+	if err != nil {
+		return nil, err
+	}
+	p := &PostgresStorage{db: db, location: redactURL(url)}
+	if err := p.migrate(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate the database schema: %w", err)
+	}
+	return p, nil
+}
+
+// Close closes the connections to the database
+func (p *PostgresStorage) Close() error {
+	return p.db.Close()
+}
+
+// migrate applies the migrations the database lacks, in one transaction
+func (p *PostgresStorage) migrate(ctx context.Context) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, int64(postgresMigrationLock)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL
+	)`); err != nil {
+		return err
+	}
+	var current int64
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	for i := int(current); i < len(postgresMigrations); i++ {
+		for _, statement := range postgresMigrations[i] {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("migration %d: %w", i+1, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`, i+1, time.Now()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveInstance stores an instance record, replacing any with the same ID
+func (p *PostgresStorage) SaveInstance(instance *models.Instance) error {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	data, err := json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var version int64
+	err = p.db.QueryRowContext(ctx, `INSERT INTO instances
+		(id, name, provider, account, region, instance_type, state, owner, expires_at, terminated_at, created_at, updated_at, version, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11, 1, $12)
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name, provider = EXCLUDED.provider, account = EXCLUDED.account,
+			region = EXCLUDED.region, instance_type = EXCLUDED.instance_type, state = EXCLUDED.state,
+			owner = EXCLUDED.owner, expires_at = EXCLUDED.expires_at, terminated_at = EXCLUDED.terminated_at,
+			updated_at = EXCLUDED.updated_at, version = instances.version + 1, data = EXCLUDED.data
+		RETURNING version`,
+		instance.ID, instance.Name, instance.Provider, instance.Account, instance.Region, instance.InstanceType,
+		instance.State, instance.Owner, instance.ExpiresAt, nullTime(instance.TerminatedAt), now, data,
+	).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("failed to save instance %s: %w", instance.ID, err)
+	}
+	instance.Revision = version
+	p.feed.changed()
+	return nil
+}
+
+// GetInstance returns the record of an instance
+func (p *PostgresStorage) GetInstance(instanceID string) (*models.Instance, error) {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	record, err := scanInstanceRecord(p.db.QueryRowContext(ctx, `SELECT `+instanceColumns+` FROM instances WHERE id = $1`, instanceID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("instance %s not found", instanceID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record.Instance, nil
+}
+
+// UpdateInstance replaces the record of a stored instance. When the
+// instance carries a revision, the update fails with ErrConflict if the
+// record changed since.
+func (p *PostgresStorage) UpdateInstance(instance *models.Instance) error {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	data, err := json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+	var version int64
+	err = p.db.QueryRowContext(ctx, `UPDATE instances SET
+			name = $2, provider = $3, account = $4, region = $5, instance_type = $6, state = $7, owner = $8,
+			expires_at = $9, terminated_at = $10, updated_at = $11, version = version + 1, data = $12
+		WHERE id = $1 AND ($13::bigint = 0 OR version = $13::bigint)
+		RETURNING version`,
+		instance.ID, instance.Name, instance.Provider, instance.Account, instance.Region, instance.InstanceType,
+		instance.State, instance.Owner, instance.ExpiresAt, nullTime(instance.TerminatedAt), time.Now(), data, instance.Revision,
+	).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, getErr := p.GetInstance(instance.ID); getErr != nil {
+			return getErr
+		}
+		return fmt.Errorf("failed to update instance %s: %w", instance.ID, ErrConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update instance %s: %w", instance.ID, err)
+	}
+	instance.Revision = version
+	p.feed.changed()
+	return nil
+}
+
+// DeleteInstance removes the record of an instance
+func (p *PostgresStorage) DeleteInstance(instanceID string) error {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	if _, err := p.db.ExecContext(ctx, `DELETE FROM instances WHERE id = $1`, instanceID); err != nil {
+		return err
+	}
+	p.feed.changed()
+	return nil
+}
+
+// ListInstances returns all stored instances
+func (p *PostgresStorage) ListInstances() ([]*models.Instance, error) {
+	return p.queryInstances(`SELECT ` + instanceColumns + ` FROM instances ORDER BY id`)
+}
+
+// ListInstancesFiltered returns the stored instances the filter matches. Its
+// predicates are evaluated by the database.
+func (p *PostgresStorage) ListInstancesFiltered(filter models.InstanceFilter) ([]*models.Instance, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	list := func(values []string) string {
+		params := make([]string, len(values))
+		for i, value := range values {
+			params[i] = arg(value)
+		}
+		return "(" + strings.Join(params, ", ") + ")"
+	}
+	if len(filter.States) > 0 {
+		conditions = append(conditions, "state IN "+list(filter.States))
+	}
+	if len(filter.ExcludeStates) > 0 {
+		conditions = append(conditions, "state NOT IN "+list(filter.ExcludeStates))
+	}
+	if filter.Provider == "aws" {
+		// Instances recorded before they carried their provider are aws
+		conditions = append(conditions, "provider IN ('aws', '')")
+	} else if filter.Provider != "" {
+		conditions = append(conditions, "provider = "+arg(filter.Provider))
+	}
+	if filter.Region != "" {
+		conditions = append(conditions, "region = "+arg(filter.Region))
+	}
+	if filter.Owner != "" {
+		conditions = append(conditions, "owner = "+arg(filter.Owner))
+	}
+	if len(filter.Tags) > 0 {
+		tags, err := json.Marshal(filter.Tags)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, "data->'tags' @> "+arg(string(tags))+"::jsonb")
+	}
+	if !filter.ExpiresAfter.IsZero() {
+		conditions = append(conditions, "expires_at > "+arg(filter.ExpiresAfter))
+	}
+	if !filter.ExpiresBefore.IsZero() {
+		conditions = append(conditions, "expires_at < "+arg(filter.ExpiresBefore))
+	}
+
+	query := `SELECT ` + instanceColumns + ` FROM instances`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	instances, err := p.queryInstances(query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return filter.Filter(instances), nil
+}
+
+// FindByName returns the stored instances with the given name
+func (p *PostgresStorage) FindByName(name string) ([]*models.Instance, error) {
+	return p.queryInstances(`SELECT `+instanceColumns+` FROM instances WHERE name = $1 ORDER BY id`, name)
+}
+
+// GetExpiredInstances returns instances that have exceeded their duration
+func (p *PostgresStorage) GetExpiredInstances() ([]*models.Instance, error) {
+	return p.queryInstances(`SELECT `+instanceColumns+` FROM instances WHERE expires_at < $1 ORDER BY id`, time.Now())
+}
+
+// GetTerminatedBefore returns terminated instances whose termination
+// happened before cutoff, sorted by ID
+func (p *PostgresStorage) GetTerminatedBefore(cutoff time.Time) ([]*models.Instance, error) {
+	return p.queryInstances(`SELECT `+instanceColumns+` FROM instances
+		WHERE state = 'terminated' AND COALESCE(terminated_at, updated_at) < $1 ORDER BY id`, cutoff)
+}
+
+// ArchiveInstance moves an instance record to the archive in a single
+// transaction, noting why it was archived
+func (p *PostgresStorage) ArchiveInstance(instanceID, reason string) error {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	record, err := scanInstanceRecord(tx.QueryRowContext(ctx, `SELECT `+instanceColumns+` FROM instances WHERE id = $1 FOR UPDATE`, instanceID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("instance %s not found", instanceID)
+	}
+	if err != nil {
+		return err
+	}
+	archived := &models.ArchivedInstance{
+		Instance:   record.Instance,
+		Reason:     reason,
+		ArchivedAt: time.Now(),
+	}
+	data, err := json.Marshal(archived)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO archived_instances (instance_id, reason, archived_at, data) VALUES ($1, $2, $3, $4)`,
+		instanceID, reason, archived.ArchivedAt, data); err != nil {
+		return fmt.Errorf("failed to archive instance %s: %w", instanceID, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM instances WHERE id = $1`, instanceID); err != nil {
+		return fmt.Errorf("failed to archive instance %s: %w", instanceID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	p.feed.changed()
+	return nil
+}
+
+// ListArchived returns the archived instance records, oldest first
+func (p *PostgresStorage) ListArchived() ([]*models.ArchivedInstance, error) {
+	var archived []*models.ArchivedInstance
+	err := p.queryData(`SELECT data FROM archived_instances ORDER BY archived_at, seq`, func(data []byte) error {
+		var record models.ArchivedInstance
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		archived = append(archived, &record)
+		return nil
+	})
+	return archived, err
+}
+
+// RecordSnapshot stores a record of a volume snapshot
+func (p *PostgresStorage) RecordSnapshot(snapshot *models.SnapshotRecord) error {
+	return p.upsertRecord(`INSERT INTO snapshots (snapshot_id, instance_id, created_at, data) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (snapshot_id) DO UPDATE SET instance_id = EXCLUDED.instance_id, created_at = EXCLUDED.created_at, data = EXCLUDED.data`,
+		snapshot, snapshot.SnapshotID, snapshot.InstanceID, snapshot.CreatedAt)
+}
+
+// ListSnapshots returns the recorded snapshots, oldest first
+func (p *PostgresStorage) ListSnapshots() ([]*models.SnapshotRecord, error) {
+	var snapshots []*models.SnapshotRecord
+	err := p.queryData(`SELECT data FROM snapshots ORDER BY created_at, snapshot_id`, func(data []byte) error {
+		var snapshot models.SnapshotRecord
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return err
+		}
+		snapshots = append(snapshots, &snapshot)
+		return nil
+	})
+	return snapshots, err
+}
+
+// DeleteSnapshot removes the record of a snapshot
+func (p *PostgresStorage) DeleteSnapshot(snapshotID string) error {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `DELETE FROM snapshots WHERE snapshot_id = $1`, snapshotID)
+	return err
+}
+
+// RecordImage stores a record of a machine image
+func (p *PostgresStorage) RecordImage(image *models.ImageRecord) error {
+	return p.upsertRecord(`INSERT INTO images (image_id, instance_id, created_at, data) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (image_id) DO UPDATE SET instance_id = EXCLUDED.instance_id, created_at = EXCLUDED.created_at, data = EXCLUDED.data`,
+		image, image.ImageID, image.InstanceID, image.CreatedAt)
+}
+
+// ListImages returns the recorded machine images, oldest first
+func (p *PostgresStorage) ListImages() ([]*models.ImageRecord, error) {
+	var images []*models.ImageRecord
+	err := p.queryData(`SELECT data FROM images ORDER BY created_at, image_id`, func(data []byte) error {
+		var image models.ImageRecord
+		if err := json.Unmarshal(data, &image); err != nil {
+			return err
+		}
+		images = append(images, &image)
+		return nil
+	})
+	return images, err
+}
+
+// RecordSchedulerRun stores the report of the latest scheduler pass
+func (p *PostgresStorage) RecordSchedulerRun(run *models.SchedulerRun) error {
+	return p.putState(postgresLastRunKey, run)
+}
+
+// LastSchedulerRun returns the report of the latest scheduler pass, or nil
+// when the scheduler has not run yet
+func (p *PostgresStorage) LastSchedulerRun() (*models.SchedulerRun, error) {
+	var run *models.SchedulerRun
+	if err := p.getState(postgresLastRunKey, &run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// PauseScheduler suspends the scheduler's lifecycle actions
+func (p *PostgresStorage) PauseScheduler(pause *models.SchedulerPause) error {
+	return p.putState(postgresPauseKey, pause)
+}
+
+// ResumeScheduler ends a pause, reporting false when there was none
+func (p *PostgresStorage) ResumeScheduler() (bool, error) {
+	pause, err := p.SchedulerPause()
+	if err != nil || pause == nil {
+		return false, err
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+	if _, err := p.db.ExecContext(ctx, `DELETE FROM service_state WHERE key = $1`, postgresPauseKey); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SchedulerPause returns the pause in effect, or nil
+func (p *PostgresStorage) SchedulerPause() (*models.SchedulerPause, error) {
+	var pause *models.SchedulerPause
+	if err := p.getState(postgresPauseKey, &pause); err != nil {
+		return nil, err
+	}
+	if !pause.Active(time.Now()) {
+		return nil, nil
+	}
+	return pause, nil
+}
+
+// Watch reports changes to the instance records, including those made by
+// other processes, until ctx is done
+func (p *PostgresStorage) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	return p.feed.watch(ctx, p.instanceRecords)
+}
+
+// Snapshot returns the full contents of the database
+func (p *PostgresStorage) Snapshot() (*StorageRecord, error) {
+	record := &StorageRecord{UpdatedAt: time.Now()}
+	var err error
+	if record.Instances, err = p.instanceRecords(); err != nil {
+		return nil, err
+	}
+	if record.Snapshots, err = p.ListSnapshots(); err != nil {
+		return nil, err
+	}
+	if record.Images, err = p.ListImages(); err != nil {
+		return nil, err
+	}
+	if record.Archived, err = p.ListArchived(); err != nil {
+		return nil, err
+	}
+	if record.LastRun, err = p.LastSchedulerRun(); err != nil {
+		return nil, err
+	}
+	if record.Pause, err = p.SchedulerPause(); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// instanceRecords returns the stored instance records by ID
+func (p *PostgresStorage) instanceRecords() (map[string]*models.InstanceRecord, error) {
+	ctx, cancel := p.context()
+	defer cancel()
+	rows, err := p.db.QueryContext(ctx, `SELECT `+instanceColumns+` FROM instances`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make(map[string]*models.InstanceRecord)
+	for rows.Next() {
+		record, err := scanInstanceRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records[record.Instance.ID] = record
+	}
+	return records, rows.Err()
+}
+
+// Location returns the database URL without its password
+func (p *PostgresStorage) Location() string {
+	return p.location
+}
+
+// context returns the context of one query
+func (p *PostgresStorage) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), postgresQueryTimeout)
+}
+
+// queryInstances returns the instances of the rows of query, which selects
+// instanceColumns
+func (p *PostgresStorage) queryInstances(query string, args ...interface{}) ([]*models.Instance, error) {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var instances []*models.Instance
+	for rows.Next() {
+		record, err := scanInstanceRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, record.Instance)
+	}
+	return instances, rows.Err()
+}
+
+// queryData calls fn with the data column of each row of query
+func (p *PostgresStorage) queryData(query string, fn func(data []byte) error) error {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	rows, err := p.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// upsertRecord runs statement with the given key columns and the JSON of
+// value as the last parameter
+func (p *PostgresStorage) upsertRecord(statement string, value interface{}, args ...interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := p.context()
+	defer cancel()
+	_, err = p.db.ExecContext(ctx, statement, append(args, data)...)
+	return err
+}
+
+// putState stores value under key in the service_state table
+func (p *PostgresStorage) putState(key string, value interface{}) error {
+	return p.upsertRecord(`INSERT INTO service_state (key, updated_at, data) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET updated_at = EXCLUDED.updated_at, data = EXCLUDED.data`,
+		value, key, time.Now())
+}
+
+// getState decodes the value stored under key into out, leaving it
+// untouched when there is none
+func (p *PostgresStorage) getState(key string, out interface{}) error {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	var data []byte
+	err := p.db.QueryRowContext(ctx, `SELECT data FROM service_state WHERE key = $1`, key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanInstanceRecord reads a row of instanceColumns
+func scanInstanceRecord(row rowScanner) (*models.InstanceRecord, error) {
+	var data []byte
+	record := &models.InstanceRecord{Instance: &models.Instance{}}
+	if err := row.Scan(&data, &record.Instance.Revision, &record.CreatedAt, &record.UpdatedAt); err != nil {
+		return nil, err
+	}
+	// Revision is not part of the JSON, so it survives decoding
+	if err := json.Unmarshal(data, record.Instance); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// nullTime returns nil for the zero time, which is stored as NULL
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// redactURL returns rawURL without its password
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "postgres"
+	}
+	return u.Redacted()
+}
+
+var _ Storage = (*PostgresStorage)(nil)
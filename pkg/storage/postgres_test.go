@@ -0,0 +1,135 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/storage"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// newPostgresStorage connects to the database in TEST_POSTGRES_URL, which
+// must be a scratch database: its tables are dropped first
+func newPostgresStorage(t *testing.T) *storage.PostgresStorage {
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("Skipping PostgreSQL test: TEST_POSTGRES_URL not set")
+	}
+	db, err := sql.Open("pgx", url)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`DROP TABLE IF EXISTS schema_migrations, instances, archived_instances, snapshots, images, service_state`); err != nil {
+		t.Fatalf("Failed to reset the database: %v", err)
+	}
+
+	store, err := storage.NewPostgresStorage(context.Background(), url)
+	if err != nil {
+		t.Fatalf("Failed to open PostgreSQL storage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestPostgresStorage_Instances(t *testing.T) {
+	store := newPostgresStorage(t)
+	now := time.Now()
+
+	instances := []*models.Instance{
+		{ID: "i-1", Name: "web", State: "running", ExpiresAt: now.Add(-time.Minute)},
+		{ID: "i-2", Name: "db", State: "running", ExpiresAt: now.Add(time.Hour)},
+		{ID: "i-3", Name: "web", State: "terminated", ExpiresAt: now.Add(time.Hour), TerminatedAt: now.Add(-48 * time.Hour)},
+	}
+	for _, instance := range instances {
+		if err := store.SaveInstance(instance); err != nil {
+			t.Fatalf("SaveInstance failed: %v", err)
+		}
+	}
+
+	all, err := store.ListInstances()
+	if err != nil || len(all) != 3 {
+		t.Fatalf("Expected 3 instances, got %d, %v", len(all), err)
+	}
+	named, err := store.FindByName("web")
+	if err != nil || len(named) != 2 {
+		t.Errorf("Expected 2 instances named web, got %d, %v", len(named), err)
+	}
+	expired, err := store.GetExpiredInstances()
+	if err != nil || len(expired) != 1 || expired[0].ID != "i-1" {
+		t.Errorf("Expected i-1 to be expired, got %v, %v", expired, err)
+	}
+	terminated, err := store.GetTerminatedBefore(now.Add(-24 * time.Hour))
+	if err != nil || len(terminated) != 1 || terminated[0].ID != "i-3" {
+		t.Errorf("Expected i-3 to be terminated, got %v, %v", terminated, err)
+	}
+	filtered, err := store.ListInstancesFiltered(models.InstanceFilter{
+		States:       []string{"running"},
+		Provider:     "aws",
+		ExpiresAfter: now,
+	})
+	if err != nil || len(filtered) != 1 || filtered[0].ID != "i-2" {
+		t.Errorf("Expected only i-2 to match the filter, got %v, %v", filtered, err)
+	}
+
+	// A stale revision is refused
+	first, _ := store.GetInstance("i-2")
+	second, _ := store.GetInstance("i-2")
+	first.State = "stopped"
+	if err := store.UpdateInstance(first); err != nil {
+		t.Fatalf("UpdateInstance failed: %v", err)
+	}
+	second.State = "running"
+	if err := store.UpdateInstance(second); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+	got, err := store.GetInstance("i-2")
+	if err != nil || got.State != "stopped" {
+		t.Errorf("Expected the first update to be kept, got %+v, %v", got, err)
+	}
+
+	if err := store.ArchiveInstance("i-3", "pruned"); err != nil {
+		t.Fatalf("ArchiveInstance failed: %v", err)
+	}
+	archived, err := store.ListArchived()
+	if err != nil || len(archived) != 1 || archived[0].Instance.ID != "i-3" || archived[0].Reason != "pruned" {
+		t.Errorf("Unexpected archive: %v, %v", archived, err)
+	}
+	if _, err := store.GetInstance("i-3"); err == nil {
+		t.Error("Expected the archived instance to be removed")
+	}
+}
+
+func TestPostgresStorage_State(t *testing.T) {
+	store := newPostgresStorage(t)
+
+	if pause, err := store.SchedulerPause(); err != nil || pause != nil {
+		t.Fatalf("Expected no pause, got %v, %v", pause, err)
+	}
+	if err := store.PauseScheduler(&models.SchedulerPause{PausedAt: time.Now(), Reason: "maintenance"}); err != nil {
+		t.Fatalf("PauseScheduler failed: %v", err)
+	}
+	if resumed, err := store.ResumeScheduler(); err != nil || !resumed {
+		t.Errorf("Expected the pause to end, got %v, %v", resumed, err)
+	}
+
+	if err := store.RecordSnapshot(&models.SnapshotRecord{SnapshotID: "snap-1", InstanceID: "i-1", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("RecordSnapshot failed: %v", err)
+	}
+	if err := store.RecordSchedulerRun(&models.SchedulerRun{StartedAt: time.Now()}); err != nil {
+		t.Fatalf("RecordSchedulerRun failed: %v", err)
+	}
+	snapshot, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if len(snapshot.Snapshots) != 1 || snapshot.LastRun == nil {
+		t.Errorf("Unexpected snapshot: %+v", snapshot)
+	}
+}
@@ -0,0 +1,601 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"instance-manager/pkg/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Keys of the data a RedisStorage keeps, each under its prefix
+const (
+	redisInstancePrefix = "instance:"      // Hash of an instance record: its JSON in data, and version
+	redisExpiresKey     = "expires"        // Sorted set of instance IDs scored by expiry in Unix milliseconds
+	redisArchivedKey    = "archived"       // List of archived records, oldest first
+	redisSnapshotsKey   = "snapshots"      // Hash of snapshot records by snapshot ID
+	redisImagesKey      = "images"         // Hash of image records by image ID
+	redisLastRunKey     = "state:last_run" // Report of the latest scheduler pass
+	redisPauseKey       = "state:pause"    // Pause of the scheduler
+)
+
+// DefaultRedisPrefix is the key prefix of a RedisStorage when none is
+// configured
+const DefaultRedisPrefix = "instance-manager:"
+
+// redisRequestTimeout bounds each operation on Redis
+const redisRequestTimeout = 30 * time.Second
+
+// redisConflictRetries is how often a transaction is retried when another
+// process wrote the same record in between
+const redisConflictRetries = 5
+
+// RedisStorage keeps instance records in Redis, so several service
+// replicas and web servers can share them. Each instance is a hash holding
+// its record as JSON and a version. A sorted set of instance IDs scored by
+// expiry doubles as the index of all instances and makes
+// GetExpiredInstances a range query. Writes are WATCH/MULTI/EXEC
+// transactions, so an update of an instance that another process changed
+// since it was read fails with ErrConflict instead of overwriting the
+// change.
+type RedisStorage struct {
+	client *redis.Client
+	prefix string
+	feed   changeFeed
+}
+
+// NewRedisStorage connects to the Redis server at url, such as
+// redis://:secret@localhost:6379/0, keeping its keys under prefix.
+// rediss:// connects with TLS.
+func NewRedisStorage(ctx context.Context, url, prefix string) (*RedisStorage, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	if prefix == "" {
+		prefix = DefaultRedisPrefix
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to reach Redis at %s: %w", opts.Addr, err)
+	}
+	return &RedisStorage{client: client, prefix: prefix}, nil
+}
+
+// Close closes the connections to Redis
+func (r *RedisStorage) Close() error {
+	return r.client.Close()
+}
+
+// SaveInstance stores an instance record, replacing any with the same ID
+func (r *RedisStorage) SaveInstance(instance *models.Instance) error {
+	var newVersion int64
+	err := r.transact(instance.ID, func(ctx context.Context, pipe redis.Pipeliner, record *models.InstanceRecord, version int64) error {
+		now := time.Now()
+		createdAt := now
+		if record != nil {
+			createdAt = record.CreatedAt
+		}
+		newVersion = version + 1
+		return r.putInstance(ctx, pipe, &models.InstanceRecord{Instance: instance, CreatedAt: createdAt, UpdatedAt: now}, newVersion)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save instance %s: %w", instance.ID, err)
+	}
+	instance.Revision = newVersion
+	r.feed.changed()
+	return nil
+}
+
+// GetInstance returns the record of an instance
+func (r *RedisStorage) GetInstance(instanceID string) (*models.Instance, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	fields, err := r.client.HMGet(ctx, r.key(redisInstancePrefix+instanceID), "data", "version").Result()
+	if err != nil {
+		return nil, err
+	}
+	record, _, err := decodeRedisInstance(fields)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("instance %s not found", instanceID)
+	}
+	return record.Instance, nil
+}
+
+// UpdateInstance replaces the record of a stored instance. When the
+// instance carries a revision, the update fails with ErrConflict if the
+// record changed since.
+func (r *RedisStorage) UpdateInstance(instance *models.Instance) error {
+	var newVersion int64
+	err := r.transact(instance.ID, func(ctx context.Context, pipe redis.Pipeliner, record *models.InstanceRecord, version int64) error {
+		if record == nil {
+			return fmt.Errorf("instance %s not found", instance.ID)
+		}
+		if instance.Revision != 0 && instance.Revision != version {
+			return ErrConflict
+		}
+		record.Instance = instance
+		record.UpdatedAt = time.Now()
+		newVersion = version + 1
+		return r.putInstance(ctx, pipe, record, newVersion)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update instance %s: %w", instance.ID, err)
+	}
+	instance.Revision = newVersion
+	r.feed.changed()
+	return nil
+}
+
+// DeleteInstance removes the record of an instance
+func (r *RedisStorage) DeleteInstance(instanceID string) error {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.key(redisInstancePrefix+instanceID))
+		pipe.ZRem(ctx, r.key(redisExpiresKey), instanceID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	r.feed.changed()
+	return nil
+}
+
+// ListInstances returns all stored instances, soonest expiring first
+func (r *RedisStorage) ListInstances() ([]*models.Instance, error) {
+	return r.instancesByExpiry("-inf", "+inf")
+}
+
+// ListInstancesFiltered returns the stored instances the filter matches. An
+// expiry window is looked up through a range query of the expiry index.
+func (r *RedisStorage) ListInstancesFiltered(filter models.InstanceFilter) ([]*models.Instance, error) {
+	min, max := "-inf", "+inf"
+	if !filter.ExpiresAfter.IsZero() {
+		min = strconv.FormatInt(filter.ExpiresAfter.UnixMilli(), 10)
+	}
+	if !filter.ExpiresBefore.IsZero() {
+		max = strconv.FormatInt(filter.ExpiresBefore.UnixMilli(), 10)
+	}
+	instances, err := r.instancesByExpiry(min, max)
+	if err != nil {
+		return nil, err
+	}
+	return filter.Filter(instances), nil
+}
+
+// FindByName returns the stored instances with the given name
+func (r *RedisStorage) FindByName(name string) ([]*models.Instance, error) {
+	instances, err := r.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+	return namedInstances(instances, name), nil
+}
+
+// GetExpiredInstances returns instances that have exceeded their duration,
+// found through a range query of the expiry index
+func (r *RedisStorage) GetExpiredInstances() ([]*models.Instance, error) {
+	now := time.Now()
+	candidates, err := r.instancesByExpiry("-inf", "("+strconv.FormatInt(now.UnixMilli()+1, 10))
+	if err != nil {
+		return nil, err
+	}
+	var expired []*models.Instance
+	for _, instance := range candidates {
+		if instance.IsExpiredAt(now) {
+			expired = append(expired, instance)
+		}
+	}
+	return expired, nil
+}
+
+// GetTerminatedBefore returns terminated instances whose termination
+// happened before cutoff, sorted by ID
+func (r *RedisStorage) GetTerminatedBefore(cutoff time.Time) ([]*models.Instance, error) {
+	records, err := r.instanceRecords()
+	if err != nil {
+		return nil, err
+	}
+	return terminatedBefore(records, cutoff), nil
+}
+
+// ArchiveInstance moves an instance record to the archive in a single
+// transaction, noting why it was archived
+func (r *RedisStorage) ArchiveInstance(instanceID, reason string) error {
+	err := r.transact(instanceID, func(ctx context.Context, pipe redis.Pipeliner, record *models.InstanceRecord, version int64) error {
+		if record == nil {
+			return fmt.Errorf("instance %s not found", instanceID)
+		}
+		data, err := json.Marshal(&models.ArchivedInstance{
+			Instance:   record.Instance,
+			Reason:     reason,
+			ArchivedAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		pipe.RPush(ctx, r.key(redisArchivedKey), data)
+		pipe.Del(ctx, r.key(redisInstancePrefix+instanceID))
+		pipe.ZRem(ctx, r.key(redisExpiresKey), instanceID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive instance %s: %w", instanceID, err)
+	}
+	r.feed.changed()
+	return nil
+}
+
+// ListArchived returns the archived instance records, oldest first
+func (r *RedisStorage) ListArchived() ([]*models.ArchivedInstance, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	items, err := r.client.LRange(ctx, r.key(redisArchivedKey), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	var archived []*models.ArchivedInstance
+	for _, item := range items {
+		var record models.ArchivedInstance
+		if err := json.Unmarshal([]byte(item), &record); err != nil {
+			return nil, err
+		}
+		archived = append(archived, &record)
+	}
+	return archived, nil
+}
+
+// RecordSnapshot stores a record of a volume snapshot
+func (r *RedisStorage) RecordSnapshot(snapshot *models.SnapshotRecord) error {
+	return r.putField(redisSnapshotsKey, snapshot.SnapshotID, snapshot)
+}
+
+// ListSnapshots returns the recorded snapshots, oldest first
+func (r *RedisStorage) ListSnapshots() ([]*models.SnapshotRecord, error) {
+	var snapshots []*models.SnapshotRecord
+	if err := r.hashValues(redisSnapshotsKey, func(data []byte) error {
+		var snapshot models.SnapshotRecord
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return err
+		}
+		snapshots = append(snapshots, &snapshot)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// DeleteSnapshot removes the record of a snapshot
+func (r *RedisStorage) DeleteSnapshot(snapshotID string) error {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	return r.client.HDel(ctx, r.key(redisSnapshotsKey), snapshotID).Err()
+}
+
+// RecordImage stores a record of a machine image
+func (r *RedisStorage) RecordImage(image *models.ImageRecord) error {
+	return r.putField(redisImagesKey, image.ImageID, image)
+}
+
+// ListImages returns the recorded machine images, oldest first
+func (r *RedisStorage) ListImages() ([]*models.ImageRecord, error) {
+	var images []*models.ImageRecord
+	if err := r.hashValues(redisImagesKey, func(data []byte) error {
+		var image models.ImageRecord
+		if err := json.Unmarshal(data, &image); err != nil {
+			return err
+		}
+		images = append(images, &image)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].CreatedAt.Before(images[j].CreatedAt)
+	})
+	return images, nil
+}
+
+// RecordSchedulerRun stores the report of the latest scheduler pass
+func (r *RedisStorage) RecordSchedulerRun(run *models.SchedulerRun) error {
+	return r.putValue(redisLastRunKey, run)
+}
+
+// LastSchedulerRun returns the report of the latest scheduler pass, or nil
+// when the scheduler has not run yet
+func (r *RedisStorage) LastSchedulerRun() (*models.SchedulerRun, error) {
+	var run *models.SchedulerRun
+	if err := r.getValue(redisLastRunKey, &run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// PauseScheduler suspends the scheduler's lifecycle actions
+func (r *RedisStorage) PauseScheduler(pause *models.SchedulerPause) error {
+	return r.putValue(redisPauseKey, pause)
+}
+
+// ResumeScheduler ends a pause, reporting false when there was none
+func (r *RedisStorage) ResumeScheduler() (bool, error) {
+	pause, err := r.SchedulerPause()
+	if err != nil || pause == nil {
+		return false, err
+	}
+
+	ctx, cancel := r.context()
+	defer cancel()
+	if err := r.client.Del(ctx, r.key(redisPauseKey)).Err(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SchedulerPause returns the pause in effect, or nil
+func (r *RedisStorage) SchedulerPause() (*models.SchedulerPause, error) {
+	var pause *models.SchedulerPause
+	if err := r.getValue(redisPauseKey, &pause); err != nil {
+		return nil, err
+	}
+	if !pause.Active(time.Now()) {
+		return nil, nil
+	}
+	return pause, nil
+}
+
+// Watch reports changes to the instance records, including those made by
+// other processes, until ctx is done
+func (r *RedisStorage) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	return r.feed.watch(ctx, r.instanceRecords)
+}
+
+// Snapshot returns the full contents of the storage
+func (r *RedisStorage) Snapshot() (*StorageRecord, error) {
+	record := &StorageRecord{UpdatedAt: time.Now()}
+	var err error
+	if record.Instances, err = r.instanceRecords(); err != nil {
+		return nil, err
+	}
+	if record.Snapshots, err = r.ListSnapshots(); err != nil {
+		return nil, err
+	}
+	if record.Images, err = r.ListImages(); err != nil {
+		return nil, err
+	}
+	if record.Archived, err = r.ListArchived(); err != nil {
+		return nil, err
+	}
+	if record.LastRun, err = r.LastSchedulerRun(); err != nil {
+		return nil, err
+	}
+	if record.Pause, err = r.SchedulerPause(); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Location returns the server and the key prefix
+func (r *RedisStorage) Location() string {
+	return fmt.Sprintf("redis://%s (keys %s*)", r.client.Options().Addr, r.prefix)
+}
+
+// key returns the full name of a key
+func (r *RedisStorage) key(name string) string {
+	return r.prefix + name
+}
+
+// context returns the context of one operation
+func (r *RedisStorage) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), redisRequestTimeout)
+}
+
+// transact runs an optimistic transaction on the record of an instance. It
+// watches the record, calls fn with it (nil when missing) and its version,
+// and runs the commands fn queues on pipe in a MULTI/EXEC block. When
+// another process writes the record in between, the block is discarded and
+// fn is called again with the new record.
+func (r *RedisStorage) transact(instanceID string, fn func(ctx context.Context, pipe redis.Pipeliner, record *models.InstanceRecord, version int64) error) error {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	key := r.key(redisInstancePrefix + instanceID)
+	for attempt := 0; ; attempt++ {
+		err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+			fields, err := tx.HMGet(ctx, key, "data", "version").Result()
+			if err != nil {
+				return err
+			}
+			record, version, err := decodeRedisInstance(fields)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				return fn(ctx, pipe, record, version)
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+		if attempt >= redisConflictRetries {
+			return ErrConflict
+		}
+	}
+}
+
+// putInstance queues the commands that store record with version and
+// index its expiry
+func (r *RedisStorage) putInstance(ctx context.Context, pipe redis.Pipeliner, record *models.InstanceRecord, version int64) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	id := record.Instance.ID
+	pipe.HSet(ctx, r.key(redisInstancePrefix+id), "data", data, "version", version)
+	pipe.ZAdd(ctx, r.key(redisExpiresKey), redis.Z{Score: float64(record.Instance.ExpiresAt.UnixMilli()), Member: id})
+	return nil
+}
+
+// instancesByExpiry returns the instances whose expiry score is between
+// min and max, soonest expiring first
+func (r *RedisStorage) instancesByExpiry(min, max string) ([]*models.Instance, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	records, err := r.recordsByExpiry(ctx, min, max)
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]*models.Instance, 0, len(records))
+	for _, record := range records {
+		instances = append(instances, record.Instance)
+	}
+	return instances, nil
+}
+
+// instanceRecords returns all instance records keyed by instance ID
+func (r *RedisStorage) instanceRecords() (map[string]*models.InstanceRecord, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	records, err := r.recordsByExpiry(ctx, "-inf", "+inf")
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*models.InstanceRecord, len(records))
+	for _, record := range records {
+		byID[record.Instance.ID] = record
+	}
+	return byID, nil
+}
+
+// recordsByExpiry looks up the IDs in the expiry index between min and max
+// and reads their records in one pipeline. Records deleted in between are
+// skipped.
+func (r *RedisStorage) recordsByExpiry(ctx context.Context, min, max string) ([]*models.InstanceRecord, error) {
+	ids, err := r.client.ZRangeByScore(ctx, r.key(redisExpiresKey), &redis.ZRangeBy{Min: min, Max: max}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	replies := make([]*redis.SliceCmd, len(ids))
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			replies[i] = pipe.HMGet(ctx, r.key(redisInstancePrefix+id), "data", "version")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*models.InstanceRecord, 0, len(ids))
+	for _, reply := range replies {
+		record, _, err := decodeRedisInstance(reply.Val())
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// putField stores the JSON of value in field of the hash named key
+func (r *RedisStorage) putField(key, field string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := r.context()
+	defer cancel()
+	return r.client.HSet(ctx, r.key(key), field, data).Err()
+}
+
+// hashValues calls fn with each value of the hash named key
+func (r *RedisStorage) hashValues(key string, fn func(data []byte) error) error {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	values, err := r.client.HVals(ctx, r.key(key)).Result()
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err := fn([]byte(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putValue stores the JSON of value under key
+func (r *RedisStorage) putValue(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := r.context()
+	defer cancel()
+	return r.client.Set(ctx, r.key(key), data, 0).Err()
+}
+
+// getValue decodes the value stored under key into out, leaving it
+// untouched when there is none
+func (r *RedisStorage) getValue(key string, out interface{}) error {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	data, err := r.client.Get(ctx, r.key(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// decodeRedisInstance decodes the reply of HMGET data version into an
+// instance record and its version, or nil when the record does not exist
+func decodeRedisInstance(fields []interface{}) (*models.InstanceRecord, int64, error) {
+	if len(fields) != 2 {
+		return nil, 0, fmt.Errorf("unexpected reply for an instance record: %v", fields)
+	}
+	data, ok := fields[0].(string)
+	if !ok {
+		return nil, 0, nil
+	}
+	var record models.InstanceRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return nil, 0, err
+	}
+	version, _ := fields[1].(string)
+	record.Instance.Revision, _ = strconv.ParseInt(version, 10, 64)
+	return &record, record.Instance.Revision, nil
+}
+
+var _ Storage = (*RedisStorage)(nil)
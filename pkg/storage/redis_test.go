@@ -0,0 +1,440 @@
+package storage_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/storage"
+)
+
+// fakeRedis speaks enough of RESP2 to back a RedisStorage, including
+// WATCH/MULTI/EXEC. beforeExec, when set, runs before each EXEC, so tests
+// can write a watched key from "another process".
+type fakeRedis struct {
+	listener net.Listener
+
+	mu         sync.Mutex
+	strings    map[string]string
+	hashes     map[string]map[string]string
+	zsets      map[string]map[string]float64
+	lists      map[string][]string
+	versions   map[string]int
+	beforeExec func(s *fakeRedis)
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &fakeRedis{
+		listener: listener,
+		strings:  map[string]string{},
+		hashes:   map[string]map[string]string{},
+		zsets:    map[string]map[string]float64{},
+		lists:    map[string][]string{},
+		versions: map[string]int{},
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	watched := map[string]int{}
+	var queued [][]string
+	inMulti := false
+
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		name := strings.ToUpper(args[0])
+		var reply string
+		switch {
+		case name == "MULTI":
+			inMulti, queued = true, nil
+			reply = "+OK\r\n"
+		case name == "EXEC":
+			s.mu.Lock()
+			if s.beforeExec != nil {
+				hook := s.beforeExec
+				s.beforeExec = nil
+				s.mu.Unlock()
+				hook(s)
+				s.mu.Lock()
+			}
+			aborted := false
+			for key, version := range watched {
+				if s.versions[key] != version {
+					aborted = true
+				}
+			}
+			if aborted {
+				reply = "*-1\r\n"
+			} else {
+				reply = "*" + strconv.Itoa(len(queued)) + "\r\n"
+				for _, command := range queued {
+					reply += s.run(command)
+				}
+			}
+			s.mu.Unlock()
+			inMulti, queued, watched = false, nil, map[string]int{}
+		case name == "WATCH":
+			s.mu.Lock()
+			for _, key := range args[1:] {
+				watched[key] = s.versions[key]
+			}
+			s.mu.Unlock()
+			reply = "+OK\r\n"
+		case name == "UNWATCH":
+			watched = map[string]int{}
+			reply = "+OK\r\n"
+		case inMulti:
+			queued = append(queued, args)
+			reply = "+QUEUED\r\n"
+		default:
+			s.mu.Lock()
+			reply = s.run(args)
+			s.mu.Unlock()
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// run executes a command with the lock held and returns its encoded reply
+func (s *fakeRedis) run(args []string) string {
+	key := ""
+	if len(args) > 1 {
+		key = args[1]
+	}
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "GET":
+		value, ok := s.strings[key]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(value)
+	case "SET":
+		s.strings[key] = args[2]
+		s.versions[key]++
+		return "+OK\r\n"
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			_, isString := s.strings[key]
+			_, isHash := s.hashes[key]
+			if isString || isHash {
+				deleted++
+			}
+			delete(s.strings, key)
+			delete(s.hashes, key)
+			s.versions[key]++
+		}
+		return ":" + strconv.Itoa(deleted) + "\r\n"
+	case "HSET":
+		if s.hashes[key] == nil {
+			s.hashes[key] = map[string]string{}
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			s.hashes[key][args[i]] = args[i+1]
+		}
+		s.versions[key]++
+		return ":1\r\n"
+	case "HMGET":
+		reply := "*" + strconv.Itoa(len(args)-2) + "\r\n"
+		for _, field := range args[2:] {
+			if value, ok := s.hashes[key][field]; ok {
+				reply += bulk(value)
+			} else {
+				reply += "$-1\r\n"
+			}
+		}
+		return reply
+	case "HVALS":
+		var values []string
+		for _, value := range s.hashes[key] {
+			values = append(values, value)
+		}
+		return array(values)
+	case "HDEL":
+		for _, field := range args[2:] {
+			delete(s.hashes[key], field)
+		}
+		s.versions[key]++
+		return ":1\r\n"
+	case "ZADD":
+		if s.zsets[key] == nil {
+			s.zsets[key] = map[string]float64{}
+		}
+		score, _ := strconv.ParseFloat(args[2], 64)
+		s.zsets[key][args[3]] = score
+		s.versions[key]++
+		return ":1\r\n"
+	case "ZREM":
+		delete(s.zsets[key], args[2])
+		s.versions[key]++
+		return ":1\r\n"
+	case "ZRANGEBYSCORE":
+		var members []string
+		for member, score := range s.zsets[key] {
+			if scoreAbove(score, args[2]) && scoreBelow(score, args[3]) {
+				members = append(members, member)
+			}
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return s.zsets[key][members[i]] < s.zsets[key][members[j]]
+		})
+		return array(members)
+	case "RPUSH":
+		s.lists[key] = append(s.lists[key], args[2:]...)
+		s.versions[key]++
+		return ":" + strconv.Itoa(len(s.lists[key])) + "\r\n"
+	case "LRANGE":
+		return array(s.lists[key])
+	default:
+		return "-ERR unknown command '" + args[0] + "'\r\n"
+	}
+}
+
+func scoreAbove(score float64, min string) bool {
+	if min == "-inf" {
+		return true
+	}
+	if strings.HasPrefix(min, "(") {
+		bound, _ := strconv.ParseFloat(min[1:], 64)
+		return score > bound
+	}
+	bound, _ := strconv.ParseFloat(min, 64)
+	return score >= bound
+}
+
+func scoreBelow(score float64, max string) bool {
+	if max == "+inf" {
+		return true
+	}
+	if strings.HasPrefix(max, "(") {
+		bound, _ := strconv.ParseFloat(max[1:], 64)
+		return score < bound
+	}
+	bound, _ := strconv.ParseFloat(max, 64)
+	return score <= bound
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, count)
+	for i := range args {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(header[1:]))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func bulk(value string) string {
+	return "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+}
+
+func array(values []string) string {
+	reply := "*" + strconv.Itoa(len(values)) + "\r\n"
+	for _, value := range values {
+		reply += bulk(value)
+	}
+	return reply
+}
+
+func newRedisStorage(t *testing.T, server *fakeRedis) *storage.RedisStorage {
+	store, err := storage.NewRedisStorage(context.Background(), fmt.Sprintf("redis://%s/0", server.listener.Addr()), "")
+	if err != nil {
+		t.Fatalf("Failed to open Redis storage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestRedisStorage_Instances(t *testing.T) {
+	server := newFakeRedis(t)
+	store := newRedisStorage(t, server)
+	now := time.Now()
+
+	instances := []*models.Instance{
+		{ID: "i-1", Name: "web", State: "running", ExpiresAt: now.Add(-time.Minute)},
+		{ID: "i-2", Name: "db", State: "running", ExpiresAt: now.Add(time.Hour)},
+		{ID: "i-3", Name: "web", State: "terminated", ExpiresAt: now.Add(2 * time.Hour), TerminatedAt: now.Add(-48 * time.Hour)},
+	}
+	for _, instance := range instances {
+		if err := store.SaveInstance(instance); err != nil {
+			t.Fatalf("SaveInstance failed: %v", err)
+		}
+	}
+
+	all, err := store.ListInstances()
+	if err != nil || len(all) != 3 {
+		t.Fatalf("Expected 3 instances, got %d, %v", len(all), err)
+	}
+	if all[0].ID != "i-1" || all[2].ID != "i-3" {
+		t.Errorf("Expected instances in expiry order, got %s, %s, %s", all[0].ID, all[1].ID, all[2].ID)
+	}
+	named, err := store.FindByName("web")
+	if err != nil || len(named) != 2 {
+		t.Errorf("Expected 2 instances named web, got %d, %v", len(named), err)
+	}
+	expired, err := store.GetExpiredInstances()
+	if err != nil || len(expired) != 1 || expired[0].ID != "i-1" {
+		t.Errorf("Expected i-1 to be expired, got %v, %v", expired, err)
+	}
+	terminated, err := store.GetTerminatedBefore(now.Add(-24 * time.Hour))
+	if err != nil || len(terminated) != 1 || terminated[0].ID != "i-3" {
+		t.Errorf("Expected i-3 to be terminated, got %v, %v", terminated, err)
+	}
+	filtered, err := store.ListInstancesFiltered(models.InstanceFilter{ExcludeStates: []string{"terminated"}, ExpiresAfter: now})
+	if err != nil || len(filtered) != 1 || filtered[0].ID != "i-2" {
+		t.Errorf("Expected only i-2 to match the filter, got %v, %v", filtered, err)
+	}
+
+	// Extending an instance moves it in the expiry index
+	extended, _ := store.GetInstance("i-1")
+	extended.ExpiresAt = now.Add(3 * time.Hour)
+	if err := store.UpdateInstance(extended); err != nil {
+		t.Fatalf("UpdateInstance failed: %v", err)
+	}
+	if expired, err := store.GetExpiredInstances(); err != nil || len(expired) != 0 {
+		t.Errorf("Expected no expired instances after the extension, got %v, %v", expired, err)
+	}
+
+	if err := store.ArchiveInstance("i-3", "pruned"); err != nil {
+		t.Fatalf("ArchiveInstance failed: %v", err)
+	}
+	archived, err := store.ListArchived()
+	if err != nil || len(archived) != 1 || archived[0].Instance.ID != "i-3" || archived[0].Reason != "pruned" {
+		t.Errorf("Unexpected archive: %v, %v", archived, err)
+	}
+	if _, err := store.GetInstance("i-3"); err == nil {
+		t.Error("Expected the archived instance to be removed")
+	}
+
+	if err := store.DeleteInstance("i-2"); err != nil {
+		t.Fatalf("DeleteInstance failed: %v", err)
+	}
+	if all, err := store.ListInstances(); err != nil || len(all) != 1 {
+		t.Errorf("Expected 1 instance left, got %d, %v", len(all), err)
+	}
+}
+
+func TestRedisStorage_Conflicts(t *testing.T) {
+	server := newFakeRedis(t)
+	store := newRedisStorage(t, server)
+	other := newRedisStorage(t, server)
+
+	if err := store.SaveInstance(&models.Instance{ID: "i-1", State: "running", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+
+	// A stale revision is refused
+	first, _ := store.GetInstance("i-1")
+	second, _ := other.GetInstance("i-1")
+	first.State = "stopped"
+	if err := store.UpdateInstance(first); err != nil {
+		t.Fatalf("UpdateInstance failed: %v", err)
+	}
+	second.State = "running"
+	if err := other.UpdateInstance(second); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+
+	// A write between WATCH and EXEC discards the transaction; without a
+	// revision the update is retried on top of it
+	server.mu.Lock()
+	server.beforeExec = func(s *fakeRedis) {
+		s.mu.Lock()
+		s.versions["instance-manager:instance:i-1"]++
+		s.mu.Unlock()
+	}
+	server.mu.Unlock()
+	unversioned := &models.Instance{ID: "i-1", State: "terminated", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.UpdateInstance(unversioned); err != nil {
+		t.Fatalf("UpdateInstance without a revision failed: %v", err)
+	}
+	got, err := store.GetInstance("i-1")
+	if err != nil || got.State != "terminated" {
+		t.Errorf("Expected the retried update to be stored, got %+v, %v", got, err)
+	}
+}
+
+func TestRedisStorage_State(t *testing.T) {
+	store := newRedisStorage(t, newFakeRedis(t))
+
+	if pause, err := store.SchedulerPause(); err != nil || pause != nil {
+		t.Fatalf("Expected no pause, got %v, %v", pause, err)
+	}
+	if err := store.PauseScheduler(&models.SchedulerPause{PausedAt: time.Now(), Reason: "maintenance"}); err != nil {
+		t.Fatalf("PauseScheduler failed: %v", err)
+	}
+	if resumed, err := store.ResumeScheduler(); err != nil || !resumed {
+		t.Errorf("Expected the pause to end, got %v, %v", resumed, err)
+	}
+
+	if err := store.RecordSnapshot(&models.SnapshotRecord{SnapshotID: "snap-1", InstanceID: "i-1", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("RecordSnapshot failed: %v", err)
+	}
+	if err := store.RecordSchedulerRun(&models.SchedulerRun{StartedAt: time.Now()}); err != nil {
+		t.Fatalf("RecordSchedulerRun failed: %v", err)
+	}
+	snapshot, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if len(snapshot.Snapshots) != 1 || snapshot.LastRun == nil {
+		t.Errorf("Unexpected snapshot: %+v", snapshot)
+	}
+}
+
+func TestNewRedisStorage_InvalidURL(t *testing.T) {
+	if _, err := storage.NewRedisStorage(context.Background(), "http://localhost:6379", ""); err == nil {
+		t.Error("Expected an error for a non-Redis URL")
+	}
+}
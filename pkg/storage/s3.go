@@ -0,0 +1,534 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"instance-manager/pkg/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// DefaultS3Key is the object an S3Storage keeps its records in when none is
+// configured
+const DefaultS3Key = "instances.json"
+
+// s3RequestTimeout bounds each request to S3
+const s3RequestTimeout = 30 * time.Second
+
+// s3ConflictRetries is how often a change is retried when another process
+// wrote the object in between
+const s3ConflictRetries = 5
+
+// S3Options configures an S3Storage
+type S3Options struct {
+	Bucket string
+	// Key is the object holding the records; objects with a .gz extension
+	// are stored gzip-compressed
+	Key    string
+	Region string
+	// Endpoint overrides the regional endpoint, e.g. for MinIO. Objects are
+	// then addressed path-style, as endpoint/bucket/key.
+	Endpoint string
+	Profile  string // Shared config profile to take credentials from
+	// Credentials overrides the default AWS credential chain
+	Credentials aws.CredentialsProvider
+}
+
+// S3Storage keeps the storage file in an S3 object, so the web server can
+// run in a container or on Lambda without a persistent disk. The object
+// holds the same JSON document as FileStorage. Every change reads the
+// object and writes it back conditional on its ETag; when another process
+// wrote it in between, the change is applied again to the new contents.
+// Instances carry the time their record was last updated as their
+// revision, so an update of an instance that another process changed since
+// it was read fails with ErrConflict instead of overwriting the change.
+type S3Storage struct {
+	client   *s3.Client
+	bucket   string
+	key      string
+	compress bool
+
+	mu     sync.Mutex
+	etag   string // ETag of the cached object
+	cached []byte // Contents of the object when it was last read or written
+	feed   changeFeed
+}
+
+// s3Status returns the HTTP status of the response S3 failed a request
+// with, or 0
+func s3Status(err error) int {
+	var response interface{ HTTPStatusCode() int }
+	if errors.As(err, &response) {
+		return response.HTTPStatusCode()
+	}
+	return 0
+}
+
+// NewS3Storage creates a storage backed by the object in opts. The object
+// is created on the first change.
+func NewS3Storage(ctx context.Context, opts S3Options) (*S3Storage, error) {
+	if opts.Bucket == "" {
+		return nil, errors.New("an S3 bucket name is required")
+	}
+	key := strings.TrimPrefix(opts.Key, "/")
+	if key == "" {
+		key = DefaultS3Key
+	}
+
+	cfg := aws.Config{Region: opts.Region, Credentials: opts.Credentials}
+	if cfg.Credentials == nil || cfg.Region == "" {
+		loadOpts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(opts.Region)}
+		if opts.Profile != "" {
+			loadOpts = append(loadOpts, awsconfig.WithSharedConfigProfile(opts.Profile))
+		}
+		loaded, err := awsconfig.LoadDefaultConfig(ctx, loadOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+		}
+		if opts.Credentials != nil {
+			loaded.Credentials = opts.Credentials
+		}
+		cfg = loaded
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("a region is required for the S3 storage")
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &S3Storage{
+		client:   client,
+		bucket:   opts.Bucket,
+		key:      key,
+		compress: strings.HasSuffix(key, ".gz"),
+	}, nil
+}
+
+// SaveInstance stores an instance record, replacing any with the same ID
+func (s *S3Storage) SaveInstance(instance *models.Instance) error {
+	var now time.Time
+	err := s.modify(func(data *StorageRecord) error {
+		now = time.Now()
+		data.Instances[instance.ID] = &models.InstanceRecord{Instance: instance, CreatedAt: now, UpdatedAt: now}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save instance %s: %w", instance.ID, err)
+	}
+	instance.Revision = now.UnixNano()
+	return nil
+}
+
+// GetInstance returns the record of an instance
+func (s *S3Storage) GetInstance(instanceID string) (*models.Instance, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	record, exists := data.Instances[instanceID]
+	if !exists {
+		return nil, fmt.Errorf("instance %s not found", instanceID)
+	}
+	return record.Instance, nil
+}
+
+// UpdateInstance replaces the record of a stored instance. When the
+// instance carries a revision, the update fails with ErrConflict if the
+// record changed since.
+func (s *S3Storage) UpdateInstance(instance *models.Instance) error {
+	var now time.Time
+	err := s.modify(func(data *StorageRecord) error {
+		record, exists := data.Instances[instance.ID]
+		if !exists {
+			return fmt.Errorf("instance %s not found", instance.ID)
+		}
+		if instance.Revision != 0 && instance.Revision != record.UpdatedAt.UnixNano() {
+			return ErrConflict
+		}
+		now = time.Now()
+		data.Instances[instance.ID] = &models.InstanceRecord{Instance: instance, CreatedAt: record.CreatedAt, UpdatedAt: now}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update instance %s: %w", instance.ID, err)
+	}
+	instance.Revision = now.UnixNano()
+	return nil
+}
+
+// DeleteInstance removes the record of an instance
+func (s *S3Storage) DeleteInstance(instanceID string) error {
+	return s.modify(func(data *StorageRecord) error {
+		delete(data.Instances, instanceID)
+		return nil
+	})
+}
+
+// ListInstances returns all stored instances
+func (s *S3Storage) ListInstances() ([]*models.Instance, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]*models.Instance, 0, len(data.Instances))
+	for _, record := range data.Instances {
+		instances = append(instances, record.Instance)
+	}
+	return instances, nil
+}
+
+// ListInstancesFiltered returns the stored instances the filter matches
+func (s *S3Storage) ListInstancesFiltered(filter models.InstanceFilter) ([]*models.Instance, error) {
+	instances, err := s.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+	return filter.Filter(instances), nil
+}
+
+// FindByName returns the stored instances with the given name
+func (s *S3Storage) FindByName(name string) ([]*models.Instance, error) {
+	instances, err := s.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+	return namedInstances(instances, name), nil
+}
+
+// GetExpiredInstances returns instances that have exceeded their duration
+func (s *S3Storage) GetExpiredInstances() ([]*models.Instance, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return expiredInstances(data.Instances), nil
+}
+
+// GetTerminatedBefore returns terminated instances whose termination
+// happened before cutoff, sorted by ID
+func (s *S3Storage) GetTerminatedBefore(cutoff time.Time) ([]*models.Instance, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return terminatedBefore(data.Instances, cutoff), nil
+}
+
+// ArchiveInstance moves an instance record to the archive, noting why it
+// was archived
+func (s *S3Storage) ArchiveInstance(instanceID, reason string) error {
+	return s.modify(func(data *StorageRecord) error {
+		record, exists := data.Instances[instanceID]
+		if !exists {
+			return fmt.Errorf("instance %s not found", instanceID)
+		}
+		delete(data.Instances, instanceID)
+		data.Archived = append(data.Archived, &models.ArchivedInstance{
+			Instance:   record.Instance,
+			Reason:     reason,
+			ArchivedAt: time.Now(),
+		})
+		return nil
+	})
+}
+
+// ListArchived returns the archived instance records, oldest first
+func (s *S3Storage) ListArchived() ([]*models.ArchivedInstance, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return data.Archived, nil
+}
+
+// RecordSnapshot stores a record of a volume snapshot
+func (s *S3Storage) RecordSnapshot(snapshot *models.SnapshotRecord) error {
+	return s.modify(func(data *StorageRecord) error {
+		data.Snapshots = append(data.Snapshots, snapshot)
+		return nil
+	})
+}
+
+// ListSnapshots returns the recorded snapshots, oldest first
+func (s *S3Storage) ListSnapshots() ([]*models.SnapshotRecord, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return data.Snapshots, nil
+}
+
+// DeleteSnapshot removes the record of a snapshot
+func (s *S3Storage) DeleteSnapshot(snapshotID string) error {
+	return s.modify(func(data *StorageRecord) error {
+		data.Snapshots = slices.DeleteFunc(data.Snapshots, func(snapshot *models.SnapshotRecord) bool {
+			return snapshot.SnapshotID == snapshotID
+		})
+		return nil
+	})
+}
+
+// RecordImage stores a record of a machine image
+func (s *S3Storage) RecordImage(image *models.ImageRecord) error {
+	return s.modify(func(data *StorageRecord) error {
+		data.Images = append(data.Images, image)
+		return nil
+	})
+}
+
+// ListImages returns the recorded machine images, oldest first
+func (s *S3Storage) ListImages() ([]*models.ImageRecord, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return data.Images, nil
+}
+
+// RecordSchedulerRun stores the report of the latest scheduler pass
+func (s *S3Storage) RecordSchedulerRun(run *models.SchedulerRun) error {
+	return s.modify(func(data *StorageRecord) error {
+		data.LastRun = run
+		return nil
+	})
+}
+
+// LastSchedulerRun returns the report of the latest scheduler pass, or nil
+// when the scheduler has not run yet
+func (s *S3Storage) LastSchedulerRun() (*models.SchedulerRun, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return data.LastRun, nil
+}
+
+// PauseScheduler suspends the scheduler's lifecycle actions
+func (s *S3Storage) PauseScheduler(pause *models.SchedulerPause) error {
+	return s.modify(func(data *StorageRecord) error {
+		data.Pause = pause
+		return nil
+	})
+}
+
+// errNotPaused stops a change of the object when there is no pause to end
+var errNotPaused = errors.New("the scheduler is not paused")
+
+// ResumeScheduler ends a pause, reporting false when there was none
+func (s *S3Storage) ResumeScheduler() (bool, error) {
+	err := s.modify(func(data *StorageRecord) error {
+		if !data.Pause.Active(time.Now()) {
+			return errNotPaused
+		}
+		data.Pause = nil
+		return nil
+	})
+	if errors.Is(err, errNotPaused) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SchedulerPause returns the pause in effect, or nil
+func (s *S3Storage) SchedulerPause() (*models.SchedulerPause, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if !data.Pause.Active(time.Now()) {
+		return nil, nil
+	}
+	return data.Pause, nil
+}
+
+// Watch reports changes to the instance records, including those made by
+// other processes, until ctx is done
+func (s *S3Storage) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	return s.feed.watch(ctx, func() (map[string]*models.InstanceRecord, error) {
+		data, err := s.load()
+		if err != nil {
+			return nil, err
+		}
+		return data.Instances, nil
+	})
+}
+
+// Snapshot returns the full contents of the storage
+func (s *S3Storage) Snapshot() (*StorageRecord, error) {
+	return s.load()
+}
+
+// Location returns the bucket and key of the object
+func (s *S3Storage) Location() string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, s.key)
+}
+
+// load reads the object. It is only downloaded again when it changed since
+// it was last read.
+func (s *S3Storage) load() (*StorageRecord, error) {
+	data, _, err := s.read()
+	return data, err
+}
+
+// read reads the object and returns its contents and ETag. A missing
+// object reads as empty, with no ETag.
+func (s *S3Storage) read() (*StorageRecord, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	s.mu.Lock()
+	etag, cached := s.etag, s.cached
+	s.mu.Unlock()
+
+	input := &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(s.key)}
+	if etag != "" {
+		input.IfNoneMatch = aws.String(etag)
+	}
+	var body []byte
+	output, err := s.client.GetObject(ctx, input)
+	var noSuchKey *types.NoSuchKey
+	switch {
+	case errors.As(err, &noSuchKey) || s3Status(err) == http.StatusNotFound:
+		etag = ""
+	case s3Status(err) == http.StatusNotModified:
+		body = cached
+	case err != nil:
+		return nil, "", fmt.Errorf("failed to read %s: %w", s.Location(), err)
+	default:
+		defer output.Body.Close()
+		if body, err = io.ReadAll(output.Body); err != nil {
+			return nil, "", fmt.Errorf("failed to read %s: %w", s.Location(), err)
+		}
+		etag = aws.ToString(output.ETag)
+	}
+	s.remember(etag, body)
+
+	record, err := s.decode(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return record, etag, nil
+}
+
+// modify applies fn to the contents of the object and writes them back,
+// conditional on the object not having changed since it was read. When it
+// changed, fn is applied again to the new contents.
+func (s *S3Storage) modify(fn func(data *StorageRecord) error) error {
+	for attempt := 0; ; attempt++ {
+		data, etag, err := s.read()
+		if err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+		data.UpdatedAt = time.Now()
+
+		err = s.write(data, etag)
+		if errors.Is(err, ErrConflict) && attempt < s3ConflictRetries {
+			continue
+		}
+		if err == nil {
+			s.feed.changed()
+		}
+		return err
+	}
+}
+
+// write stores data in the object if its ETag is still etag, or if it
+// does not exist yet when etag is empty
+func (s *S3Storage) write(data *StorageRecord, etag string) error {
+	var body []byte
+	var err error
+	if s.compress {
+		body, err = json.Marshal(data)
+	} else {
+		body, err = json.MarshalIndent(data, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal storage data: %w", err)
+	}
+	if s.compress {
+		if body, err = compress(body); err != nil {
+			return fmt.Errorf("failed to compress storage data: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}
+	if etag != "" {
+		input.IfMatch = aws.String(etag)
+	} else {
+		input.IfNoneMatch = aws.String("*")
+	}
+	if s.compress {
+		input.ContentType = aws.String("application/gzip")
+	}
+
+	output, err := s.client.PutObject(ctx, input)
+	if err != nil {
+		// 409 is returned when a conflicting write is still in progress
+		if status := s3Status(err); status == http.StatusPreconditionFailed || status == http.StatusConflict {
+			return ErrConflict
+		}
+		return fmt.Errorf("failed to write %s: %w", s.Location(), err)
+	}
+	s.remember(aws.ToString(output.ETag), body)
+	return nil
+}
+
+// remember caches the contents of the object at etag
+func (s *S3Storage) remember(etag string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.etag, s.cached = etag, body
+}
+
+// decode decodes the contents of the object, setting the revision of each
+// instance to when its record was last updated
+func (s *S3Storage) decode(body []byte) (*StorageRecord, error) {
+	record := &StorageRecord{}
+	if len(body) > 0 {
+		if bytes.HasPrefix(body, gzipMagic) {
+			var err error
+			if body, err = decompress(body); err != nil {
+				return nil, fmt.Errorf("failed to decompress storage object: %w", err)
+			}
+		}
+		if err := json.Unmarshal(body, record); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal storage data: %w", err)
+		}
+	}
+	if record.Instances == nil {
+		record.Instances = make(map[string]*models.InstanceRecord)
+	}
+	for _, instanceRecord := range record.Instances {
+		instanceRecord.Instance.Revision = instanceRecord.UpdatedAt.UnixNano()
+	}
+	return record, nil
+}
+
+var _ Storage = (*S3Storage)(nil)
@@ -0,0 +1,224 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/storage"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// fakeS3 serves the objects of one bucket path-style, honouring the
+// conditional headers S3Storage sends
+type fakeS3 struct {
+	mu          sync.Mutex
+	objects     map[string][]byte
+	etags       map[string]string
+	version     int
+	downloads   int
+	notModified int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256") || r.Header.Get("X-Amz-Content-Sha256") == "" {
+		f.fail(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/bucket/") {
+		f.fail(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	etag, exists := f.etags[key]
+
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			f.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			f.notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		f.downloads++
+		w.Header().Set("ETag", etag)
+		w.Write(f.objects[key])
+	case http.MethodPut:
+		if match := r.Header.Get("If-Match"); match != "" && match != etag {
+			f.fail(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			f.fail(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		f.version++
+		f.objects[key] = body
+		f.etags[key] = fmt.Sprintf(`"%d"`, f.version)
+		w.Header().Set("ETag", f.etags[key])
+	default:
+		f.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeS3) fail(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func newS3Storage(t *testing.T, fake *fakeS3, key string) *storage.S3Storage {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	store, err := storage.NewS3Storage(context.Background(), storage.S3Options{
+		Bucket:      "bucket",
+		Key:         key,
+		Region:      "us-east-1",
+		Endpoint:    server.URL,
+		Credentials: credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	})
+	if err != nil {
+		t.Fatalf("NewS3Storage failed: %v", err)
+	}
+	return store
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, etags: map[string]string{}}
+}
+
+func TestS3Storage_Instances(t *testing.T) {
+	fake := newFakeS3()
+	store := newS3Storage(t, fake, "")
+	now := time.Now()
+
+	if instances, err := store.ListInstances(); err != nil || len(instances) != 0 {
+		t.Fatalf("Expected no instances in a missing object, got %v, %v", instances, err)
+	}
+	instances := []*models.Instance{
+		{ID: "i-1", Name: "web", State: "running", ExpiresAt: now.Add(-time.Minute)},
+		{ID: "i-2", Name: "db", State: "running", ExpiresAt: now.Add(time.Hour)},
+		{ID: "i-3", Name: "web", State: "terminated", ExpiresAt: now.Add(time.Hour), TerminatedAt: now.Add(-48 * time.Hour)},
+	}
+	for _, instance := range instances {
+		if err := store.SaveInstance(instance); err != nil {
+			t.Fatalf("SaveInstance failed: %v", err)
+		}
+	}
+
+	all, err := store.ListInstances()
+	if err != nil || len(all) != 3 {
+		t.Fatalf("Expected 3 instances, got %d, %v", len(all), err)
+	}
+	if named, err := store.FindByName("web"); err != nil || len(named) != 2 {
+		t.Errorf("Expected 2 instances named web, got %d, %v", len(named), err)
+	}
+	if expired, err := store.GetExpiredInstances(); err != nil || len(expired) != 1 || expired[0].ID != "i-1" {
+		t.Errorf("Expected i-1 to be expired, got %v, %v", expired, err)
+	}
+	if terminated, err := store.GetTerminatedBefore(now.Add(-24 * time.Hour)); err != nil || len(terminated) != 1 || terminated[0].ID != "i-3" {
+		t.Errorf("Expected i-3 to be terminated, got %v, %v", terminated, err)
+	}
+
+	if err := store.ArchiveInstance("i-3", "pruned"); err != nil {
+		t.Fatalf("ArchiveInstance failed: %v", err)
+	}
+	archived, err := store.ListArchived()
+	if err != nil || len(archived) != 1 || archived[0].Instance.ID != "i-3" || archived[0].Reason != "pruned" {
+		t.Errorf("Unexpected archive: %v, %v", archived, err)
+	}
+
+	// Reads of an unchanged object are answered from the cache
+	fake.mu.Lock()
+	downloads := fake.downloads
+	fake.mu.Unlock()
+	if _, err := store.ListInstances(); err != nil {
+		t.Fatalf("ListInstances failed: %v", err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.downloads != downloads || fake.notModified == 0 {
+		t.Errorf("Expected an unchanged object not to be downloaded again, got %d downloads", fake.downloads-downloads)
+	}
+}
+
+func TestS3Storage_ConcurrentWriters(t *testing.T) {
+	fake := newFakeS3()
+	first := newS3Storage(t, fake, "state/instances.json")
+	second := newS3Storage(t, fake, "state/instances.json")
+
+	var wg sync.WaitGroup
+	for i, store := range []*storage.S3Storage{first, second} {
+		wg.Add(1)
+		go func(i int, store *storage.S3Storage) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if err := store.SaveInstance(&models.Instance{ID: fmt.Sprintf("i-%d-%d", i, j), ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+					t.Errorf("SaveInstance failed: %v", err)
+				}
+			}
+		}(i, store)
+	}
+	wg.Wait()
+
+	all, err := first.ListInstances()
+	if err != nil || len(all) != 10 {
+		t.Fatalf("Expected the writes of both stores to be kept, got %d instances, %v", len(all), err)
+	}
+
+	// A stale revision is refused
+	stale, _ := second.GetInstance("i-0-0")
+	fresh, _ := first.GetInstance("i-0-0")
+	fresh.State = "stopped"
+	if err := first.UpdateInstance(fresh); err != nil {
+		t.Fatalf("UpdateInstance failed: %v", err)
+	}
+	stale.State = "running"
+	if err := second.UpdateInstance(stale); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+	if got, err := second.GetInstance("i-0-0"); err != nil || got.State != "stopped" {
+		t.Errorf("Expected the first update to be kept, got %+v, %v", got, err)
+	}
+}
+
+func TestS3Storage_Compressed(t *testing.T) {
+	fake := newFakeS3()
+	store := newS3Storage(t, fake, "instances.json.gz")
+
+	if err := store.PauseScheduler(&models.SchedulerPause{PausedAt: time.Now(), Reason: "maintenance"}); err != nil {
+		t.Fatalf("PauseScheduler failed: %v", err)
+	}
+	fake.mu.Lock()
+	object := fake.objects["instances.json.gz"]
+	fake.mu.Unlock()
+	if !bytes.HasPrefix(object, []byte{0x1f, 0x8b}) {
+		t.Errorf("Expected a gzip-compressed object")
+	}
+
+	if resumed, err := store.ResumeScheduler(); err != nil || !resumed {
+		t.Errorf("Expected the pause to end, got %v, %v", resumed, err)
+	}
+	if resumed, err := store.ResumeScheduler(); err != nil || resumed {
+		t.Errorf("Expected no pause to end, got %v, %v", resumed, err)
+	}
+	if store.Location() != "s3://bucket/instances.json.gz" {
+		t.Errorf("Unexpected location %s", store.Location())
+	}
+}
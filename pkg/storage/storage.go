@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/models"
+)
+
+// Storage keeps the instance records and the state the service shares with
+// the CLI and the web server. FileStorage is the default implementation.
+type Storage interface {
+	// SaveInstance stores an instance record, replacing any with the same ID
+	SaveInstance(instance *models.Instance) error
+	// GetInstance returns the record of an instance, or an error when it is
+	// not stored
+	GetInstance(instanceID string) (*models.Instance, error)
+	// UpdateInstance replaces the record of a stored instance
+	UpdateInstance(instance *models.Instance) error
+	// DeleteInstance removes the record of an instance
+	DeleteInstance(instanceID string) error
+	// ListInstances returns all stored instances
+	ListInstances() ([]*models.Instance, error)
+	// ListInstancesFiltered returns the stored instances the filter matches
+	ListInstancesFiltered(filter models.InstanceFilter) ([]*models.Instance, error)
+	// FindByName returns the stored instances with the given name
+	FindByName(name string) ([]*models.Instance, error)
+	// GetExpiredInstances returns instances that have exceeded their duration
+	GetExpiredInstances() ([]*models.Instance, error)
+	// GetTerminatedBefore returns terminated instances whose termination
+	// happened before cutoff, sorted by ID
+	GetTerminatedBefore(cutoff time.Time) ([]*models.Instance, error)
+
+	// ArchiveInstance moves an instance record to the archive, noting why
+	ArchiveInstance(instanceID, reason string) error
+	// ListArchived returns the archived instance records, oldest first
+	ListArchived() ([]*models.ArchivedInstance, error)
+
+	// RecordSnapshot stores a record of a volume snapshot
+	RecordSnapshot(snapshot *models.SnapshotRecord) error
+	// ListSnapshots returns the recorded snapshots, oldest first
+	ListSnapshots() ([]*models.SnapshotRecord, error)
+	// DeleteSnapshot removes the record of a snapshot
+	DeleteSnapshot(snapshotID string) error
+	// RecordImage stores a record of a machine image
+	RecordImage(image *models.ImageRecord) error
+	// ListImages returns the recorded machine images, oldest first
+	ListImages() ([]*models.ImageRecord, error)
+
+	// RecordSchedulerRun stores the report of the latest scheduler pass
+	RecordSchedulerRun(run *models.SchedulerRun) error
+	// LastSchedulerRun returns the report of the latest scheduler pass, or
+	// nil when the scheduler has not run yet
+	LastSchedulerRun() (*models.SchedulerRun, error)
+	// PauseScheduler suspends the scheduler's lifecycle actions
+	PauseScheduler(pause *models.SchedulerPause) error
+	// ResumeScheduler ends a pause, reporting false when there was none
+	ResumeScheduler() (bool, error)
+	// SchedulerPause returns the pause in effect, or nil
+	SchedulerPause() (*models.SchedulerPause, error)
+
+	// Watch reports changes to the instance records, including those made
+	// by other processes, until ctx is done. Changes made through the
+	// storage itself are reported at once, others within a few seconds.
+	Watch(ctx context.Context) (<-chan ChangeEvent, error)
+
+	// Snapshot returns the full contents of the storage
+	Snapshot() (*StorageRecord, error)
+	// Location describes where the records are kept, for operators
+	Location() string
+}
+
+var _ Storage = (*FileStorage)(nil)
+
+// ErrConflict is returned when a record changed since it was read, by
+// backends with optimistic locking
+var ErrConflict = errors.New("the record was changed by another process")
+
+// namedInstances returns the instances with the given name
+func namedInstances(instances []*models.Instance, name string) []*models.Instance {
+	var matches []*models.Instance
+	for _, instance := range instances {
+		if instance.Name == name {
+			matches = append(matches, instance)
+		}
+	}
+	return matches
+}
+
+// expiredInstances returns the instances of records that exceeded their
+// duration
+func expiredInstances(records map[string]*models.InstanceRecord) []*models.Instance {
+	var expired []*models.Instance
+	for _, record := range records {
+		if record.Instance.IsExpired() {
+			expired = append(expired, record.Instance)
+		}
+	}
+	return expired
+}
+
+// terminatedBefore returns the terminated instances of records whose
+// termination happened before cutoff, sorted by ID. Records without a
+// termination time fall back to when they were last updated.
+func terminatedBefore(records map[string]*models.InstanceRecord, cutoff time.Time) []*models.Instance {
+	var terminated []*models.Instance
+	for _, record := range records {
+		if record.Instance.State != "terminated" {
+			continue
+		}
+		terminatedAt := record.Instance.TerminatedAt
+		if terminatedAt.IsZero() {
+			terminatedAt = record.UpdatedAt
+		}
+		if terminatedAt.Before(cutoff) {
+			terminated = append(terminated, record.Instance)
+		}
+	}
+	slices.SortFunc(terminated, func(a, b *models.Instance) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return terminated
+}
+
+// ArchiveTerminated records that actor terminated a stored instance and moves
+// its record to the archive, so its address and configuration can still be
+// looked up
+func ArchiveTerminated(store Storage, instanceID, actor string, now time.Time) error {
+	instance, err := store.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+	instance.State = "terminated"
+	if instance.TerminatedAt.IsZero() {
+		instance.TerminatedAt = now
+	}
+	instance.RecordEvent(now, models.EventTerminated, actor, "")
+	if err := store.UpdateInstance(instance); err != nil {
+		return err
+	}
+	return store.ArchiveInstance(instanceID, models.ArchiveReasonTerminated)
+}
+
+// FindArchived returns the most recently archived record of the instance
+// with the given ID or name, or nil when none is archived
+func FindArchived(store Storage, ref string) (*models.ArchivedInstance, error) {
+	archived, err := store.ListArchived()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(archived) - 1; i >= 0; i-- {
+		if archived[i].Instance.ID == ref || archived[i].Instance.Name == ref {
+			return archived[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"instance-manager/pkg/models"
+)
+
+// Kinds of change reported by Watch
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted" // Also reported when the record is archived
+)
+
+// ChangeEvent reports a change to a stored instance record. The instances are
+// shared by every watcher and must not be modified.
+type ChangeEvent struct {
+	Type       string
+	InstanceID string
+	Instance   *models.Instance // The record after the change; nil when deleted
+	Previous   *models.Instance // The record before the change; nil when created
+}
+
+// watchInterval is how often a watched storage is read for changes made by
+// other processes. Changes made through the same storage are read at once.
+const watchInterval = 5 * time.Second
+
+// watchBuffer is how many events a watcher may fall behind by. Events beyond
+// it are dropped rather than holding up the other watchers.
+const watchBuffer = 64
+
+// changeFeed reports the changes to the instance records of a storage to its
+// watchers. It reads the records while anyone watches, every watchInterval
+// and whenever the storage writes a record, and compares them with the
+// previous read.
+type changeFeed struct {
+	mu       sync.Mutex
+	watchers map[chan ChangeEvent]struct{}
+	known    map[string]*models.InstanceRecord
+	wake     chan struct{}
+	stop     context.CancelFunc
+}
+
+// watch returns a channel of the changes to the records returned by load,
+// closed once ctx is done
+func (f *changeFeed) watch(ctx context.Context, load func() (map[string]*models.InstanceRecord, error)) (<-chan ChangeEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.watchers) == 0 {
+		records, err := load()
+		if err != nil {
+			return nil, err
+		}
+		f.known = records
+		f.watchers = make(map[chan ChangeEvent]struct{})
+		f.wake = make(chan struct{}, 1)
+		pollCtx, stop := context.WithCancel(context.Background())
+		f.stop = stop
+		go f.poll(pollCtx, f.wake, load)
+	}
+
+	events := make(chan ChangeEvent, watchBuffer)
+	f.watchers[events] = struct{}{}
+	go func() {
+		<-ctx.Done()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.watchers, events)
+		close(events)
+		if len(f.watchers) == 0 {
+			f.stop()
+			f.wake = nil
+		}
+	}()
+	return events, nil
+}
+
+// changed wakes the feed after the storage wrote an instance record, so its
+// watchers learn of the change without waiting for the next read
+func (f *changeFeed) changed() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.wake == nil {
+		return
+	}
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// poll reads the records until ctx is done. A failed read is retried at the
+// next tick.
+func (f *changeFeed) poll(ctx context.Context, wake <-chan struct{}, load func() (map[string]*models.InstanceRecord, error)) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+
+		records, err := load()
+		if err != nil {
+			continue
+		}
+		f.mu.Lock()
+		if ctx.Err() == nil {
+			f.compare(records)
+		}
+		f.mu.Unlock()
+	}
+}
+
+// compare sends the watchers the differences between the records last read
+// and records, in order of instance ID
+func (f *changeFeed) compare(records map[string]*models.InstanceRecord) {
+	ids := make([]string, 0, len(records)+len(f.known))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	for id := range f.known {
+		if records[id] == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		before, after := f.known[id], records[id]
+		switch {
+		case before == nil:
+			f.send(ChangeEvent{Type: ChangeCreated, InstanceID: id, Instance: after.Instance})
+		case after == nil:
+			f.send(ChangeEvent{Type: ChangeDeleted, InstanceID: id, Previous: before.Instance})
+		case !after.UpdatedAt.Equal(before.UpdatedAt):
+			f.send(ChangeEvent{Type: ChangeUpdated, InstanceID: id, Instance: after.Instance, Previous: before.Instance})
+		}
+	}
+	f.known = records
+}
+
+// send sends an event to every watcher with room for it
+func (f *changeFeed) send(event ChangeEvent) {
+	for events := range f.watchers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
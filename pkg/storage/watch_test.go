@@ -0,0 +1,66 @@
+package storage_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/storage"
+)
+
+func TestFileStorage_Watch(t *testing.T) {
+	store := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err := store.SaveInstance(&models.Instance{ID: "i-old"}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := store.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	next := func() storage.ChangeEvent {
+		t.Helper()
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for a change")
+			return storage.ChangeEvent{}
+		}
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	if err := store.SaveInstance(&models.Instance{ID: "i-1", ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("SaveInstance failed: %v", err)
+	}
+	if event := next(); event.Type != storage.ChangeCreated || event.InstanceID != "i-1" || event.Previous != nil {
+		t.Errorf("Expected i-1 to be created, got %+v", event)
+	}
+
+	instance, _ := store.GetInstance("i-1")
+	instance.ExpiresAt = expiresAt.Add(time.Hour)
+	if err := store.UpdateInstance(instance); err != nil {
+		t.Fatalf("UpdateInstance failed: %v", err)
+	}
+	event := next()
+	if event.Type != storage.ChangeUpdated || event.InstanceID != "i-1" {
+		t.Fatalf("Expected i-1 to be updated, got %+v", event)
+	}
+	if !event.Instance.ExpiresAt.After(event.Previous.ExpiresAt) {
+		t.Errorf("Expected the event to carry the extension, got %s after %s", event.Instance.ExpiresAt, event.Previous.ExpiresAt)
+	}
+
+	if err := store.ArchiveInstance("i-old", models.ArchiveReasonTerminated); err != nil {
+		t.Fatalf("ArchiveInstance failed: %v", err)
+	}
+	if event := next(); event.Type != storage.ChangeDeleted || event.InstanceID != "i-old" || event.Instance != nil {
+		t.Errorf("Expected i-old to be deleted, got %+v", event)
+	}
+
+	cancel()
+	for range events {
+	}
+}
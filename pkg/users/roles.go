@@ -0,0 +1,32 @@
+package users
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Roles of users, each granted what the ones after it are
+const (
+	RoleAdmin    = "admin"    // Manages every instance and the scheduler
+	RoleOperator = "operator" // Creates, extends and stops their own instances
+	RoleViewer   = "viewer"   // Sees every instance and changes none
+)
+
+// Roles lists the roles, the most privileged first
+var Roles = []string{RoleAdmin, RoleOperator, RoleViewer}
+
+// ValidateRole reports whether role is one of Roles
+func ValidateRole(role string) error {
+	if !slices.Contains(Roles, role) {
+		return fmt.Errorf("unknown role %q: use %s", role, strings.Join(Roles, ", "))
+	}
+	return nil
+}
+
+// Allows reports whether role grants what required does. Unknown roles
+// grant nothing.
+func Allows(role, required string) bool {
+	rank := slices.Index(Roles, role)
+	return rank >= 0 && rank <= slices.Index(Roles, required)
+}
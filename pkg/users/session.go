@@ -0,0 +1,132 @@
+package users
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// issuer names the web server in the tokens it issues
+const issuer = "instance-manager"
+
+// ErrInvalidSession is returned for a token that is malformed, expired,
+// signed with another secret, or issued to a user who was since removed or
+// changed their password
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+// Session is who a session token was issued to
+type Session struct {
+	User string
+	Role string // The role granted by the identity provider's groups; empty grants none
+	SSO  bool   // Signed in with the identity provider rather than a password
+}
+
+// sessionClaims are the claims of a session token
+type sessionClaims struct {
+	jwt.RegisteredClaims
+	// Password is the fingerprint of the password the user signed in with
+	Password string `json:"pwd,omitempty"`
+	Role     string `json:"role,omitempty"`
+	SSO      bool   `json:"sso,omitempty"`
+}
+
+// Sessions issues and verifies the session tokens of the users of a file:
+// JWTs signed with HMAC-SHA256
+type Sessions struct {
+	users  *File
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewSessions returns sessions of the users of file, signed with secret and
+// lasting ttl
+func NewSessions(file *File, secret []byte, ttl time.Duration) *Sessions {
+	return &Sessions{users: file, secret: secret, ttl: ttl}
+}
+
+// Issue returns a token of a session of user, and when it expires
+func (s *Sessions) Issue(user *User) (string, time.Time, error) {
+	return s.issue(sessionClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: user.Name},
+		Password:         user.fingerprint(),
+	})
+}
+
+// IssueSSO returns a token of a session of a user the identity provider
+// signed in with a role, and when it expires. Such sessions last until they
+// expire, as the user file does not know the user.
+func (s *Sessions) IssueSSO(name, role string) (string, time.Time, error) {
+	return s.issue(sessionClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: name},
+		Role:             role,
+		SSO:              true,
+	})
+}
+
+// issue signs claims, adding when they were issued and expire
+func (s *Sessions) issue(claims sessionClaims) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(s.ttl).Truncate(time.Second)
+	claims.Issuer = issuer
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(expires)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign the session: %w", err)
+	}
+	return token, expires, nil
+}
+
+// Verify returns the session of a token
+func (s *Sessions) Verify(token string) (*Session, error) {
+	var claims sessionClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(issuer), jwt.WithExpirationRequired())
+	if err != nil || claims.Subject == "" {
+		return nil, ErrInvalidSession
+	}
+	if claims.SSO {
+		return &Session{User: claims.Subject, Role: claims.Role, SSO: true}, nil
+	}
+	user := s.users.lookup(claims.Subject)
+	if user == nil || user.fingerprint() != claims.Password {
+		return nil, ErrInvalidSession
+	}
+	return &Session{User: user.Name}, nil
+}
+
+// LoadSecret returns the secret kept in the file at path, creating the file
+// with a random secret when it does not exist
+func LoadSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		secret, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(secret) < 32 {
+			return nil, fmt.Errorf("invalid session secret in %s: expected 32 bytes in hex", path)
+		}
+		return secret, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read the session secret: %w", err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate a session secret: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create the session secret directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(secret)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write the session secret: %w", err)
+	}
+	return secret, nil
+}
@@ -0,0 +1,242 @@
+// Package users manages the accounts that sign in to the web server: a file
+// of users with bcrypt password hashes, and the signed session tokens issued
+// when they sign in.
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the length passwords must have at least
+const MinPasswordLength = 8
+
+// ErrInvalidCredentials is returned for an unknown user or a wrong password
+var ErrInvalidCredentials = errors.New("invalid user name or password")
+
+// namePattern is the form of user names
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]*$`)
+
+// dummyHash is compared against when a user does not exist, so signing in
+// takes as long for unknown users as for wrong passwords
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("instance-manager"), bcrypt.DefaultCost)
+
+// User is an account as kept in a user file
+type User struct {
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"password_hash"` // bcrypt
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// fingerprint identifies the password of the user, so sessions issued before
+// it changed can be told apart
+func (u *User) fingerprint() string {
+	sum := sha256.Sum256([]byte(u.PasswordHash))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ValidateName reports whether name can name a user
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid user name %q: use letters, digits, '.', '_', '@' and '-'", name)
+	}
+	return nil
+}
+
+// GeneratePassword returns a new random password
+func GeneratePassword() (string, error) {
+	secret := make([]byte, 15)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate a password: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// File is a JSON file of users. Lookups read it again whenever it changes,
+// so users added or removed with the users command take effect without a
+// restart. It is safe for concurrent use.
+type File struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	cached  []User
+}
+
+// NewFile returns the user file at path
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Path returns the path of the file
+func (f *File) Path() string {
+	return f.path
+}
+
+// Exists reports whether the file exists, which turns on sign-in
+func (f *File) Exists() bool {
+	_, err := os.Stat(f.path)
+	return err == nil
+}
+
+// List returns the users in the file, sorted by name. A missing file holds
+// no users.
+func (f *File) List() ([]User, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users: %w", err)
+	}
+	var users []User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("failed to parse users in %s: %w", f.path, err)
+	}
+	slices.SortFunc(users, func(a, b User) int { return strings.Compare(a.Name, b.Name) })
+	return users, nil
+}
+
+// Add creates a user with a password
+func (f *File) Add(name, password string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	users, err := f.List()
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(users, func(user User) bool { return user.Name == name }) {
+		return fmt.Errorf("user %s already exists", name)
+	}
+	now := time.Now().UTC()
+	return f.write(append(users, User{Name: name, PasswordHash: hash, CreatedAt: now, UpdatedAt: now}))
+}
+
+// SetPassword replaces the password of a user, which ends their sessions
+func (f *File) SetPassword(name, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	users, err := f.List()
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(users, func(user User) bool { return user.Name == name })
+	if index < 0 {
+		return fmt.Errorf("no user named %s", name)
+	}
+	users[index].PasswordHash = hash
+	users[index].UpdatedAt = time.Now().UTC()
+	return f.write(users)
+}
+
+// Remove deletes a user, which ends their sessions
+func (f *File) Remove(name string) error {
+	users, err := f.List()
+	if err != nil {
+		return err
+	}
+	kept := slices.DeleteFunc(users, func(user User) bool { return user.Name == name })
+	if len(kept) == len(users) {
+		return fmt.Errorf("no user named %s", name)
+	}
+	return f.write(kept)
+}
+
+// Authenticate returns the user with the name and password, or
+// ErrInvalidCredentials
+func (f *File) Authenticate(name, password string) (*User, error) {
+	user := f.lookup(name)
+	if user == nil {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// lookup returns the user named name, or nil, reading the file when it
+// changed. A file that cannot be read holds no users.
+func (f *File) lookup(name string) *User {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		f.cached, f.modTime, f.size = nil, time.Time{}, 0
+		return nil
+	}
+	if !info.ModTime().Equal(f.modTime) || info.Size() != f.size {
+		users, err := f.List()
+		if err != nil {
+			return nil
+		}
+		f.cached, f.modTime, f.size = users, info.ModTime(), info.Size()
+	}
+	for i := range f.cached {
+		if f.cached[i].Name == name {
+			user := f.cached[i]
+			return &user
+		}
+	}
+	return nil
+}
+
+// write replaces the file with users, readable only by its owner
+func (f *File) write(users []User) error {
+	if users == nil {
+		users = []User{}
+	}
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+		return fmt.Errorf("failed to create the user directory: %w", err)
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write users: %w", err)
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write users: %w", err)
+	}
+	return nil
+}
+
+// hashPassword returns the bcrypt hash of a password
+func hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("passwords must have at least %d characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash the password: %w", err)
+	}
+	return string(hash), nil
+}
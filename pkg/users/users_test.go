@@ -0,0 +1,137 @@
+package users_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/users"
+)
+
+func TestFile(t *testing.T) {
+	file := users.NewFile(filepath.Join(t.TempDir(), "users.json"))
+	if file.Exists() {
+		t.Fatal("Expected no user file yet")
+	}
+	if err := file.Add("alice", "correct horse"); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := file.Add("alice", "another password"); err == nil {
+		t.Error("Expected a second user named alice to be refused")
+	}
+	if err := file.Add("bob", "short"); err == nil {
+		t.Error("Expected a short password to be refused")
+	}
+	if err := file.Add("bad name", "long enough"); err == nil {
+		t.Error("Expected an invalid name to be refused")
+	}
+
+	data, _ := os.ReadFile(file.Path())
+	if strings.Contains(string(data), "correct horse") {
+		t.Error("Expected the file to hold only the password hash")
+	}
+	if user, err := file.Authenticate("alice", "correct horse"); err != nil || user.Name != "alice" {
+		t.Errorf("Expected alice to sign in, got %+v, %v", user, err)
+	}
+	for _, credentials := range [][2]string{{"alice", "wrong password"}, {"mallory", "correct horse"}} {
+		if _, err := file.Authenticate(credentials[0], credentials[1]); !errors.Is(err, users.ErrInvalidCredentials) {
+			t.Errorf("Expected ErrInvalidCredentials for %v, got %v", credentials, err)
+		}
+	}
+
+	if err := file.SetPassword("alice", "battery staple"); err != nil {
+		t.Fatalf("SetPassword failed: %v", err)
+	}
+	if _, err := file.Authenticate("alice", "correct horse"); err == nil {
+		t.Error("Expected the old password to be refused")
+	}
+	if err := file.Remove("alice"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if list, err := file.List(); err != nil || len(list) != 0 {
+		t.Errorf("Expected no users left, got %v, %v", list, err)
+	}
+	if !file.Exists() {
+		t.Error("Expected the emptied user file to be kept")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	dir := t.TempDir()
+	file := users.NewFile(filepath.Join(dir, "users.json"))
+	if err := file.Add("alice", "correct horse"); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	secret, err := users.LoadSecret(filepath.Join(dir, "session.key"))
+	if err != nil {
+		t.Fatalf("LoadSecret failed: %v", err)
+	}
+	if again, err := users.LoadSecret(filepath.Join(dir, "session.key")); err != nil || string(again) != string(secret) {
+		t.Errorf("Expected the secret to be kept, got %v", err)
+	}
+
+	sessions := users.NewSessions(file, secret, time.Hour)
+	user, _ := file.Authenticate("alice", "correct horse")
+	token, expires, err := sessions.Issue(user)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if time.Until(expires) < 59*time.Minute {
+		t.Errorf("Expected the session to last an hour, expires %s", expires)
+	}
+	if session, err := sessions.Verify(token); err != nil || session.User != "alice" || session.SSO {
+		t.Errorf("Expected the session of alice, got %+v, %v", session, err)
+	}
+
+	// Users signed in by the identity provider are not in the file
+	token, _, err = sessions.IssueSSO("bob@example.com", users.RoleAdmin)
+	if err != nil {
+		t.Fatalf("IssueSSO failed: %v", err)
+	}
+	if session, err := sessions.Verify(token); err != nil || session.User != "bob@example.com" || session.Role != users.RoleAdmin || !session.SSO {
+		t.Errorf("Expected the admin session of bob, got %+v, %v", session, err)
+	}
+	token, _, _ = sessions.Issue(user)
+
+	other := users.NewSessions(file, []byte("another secret of at least 32 bytes"), time.Hour)
+	if _, err := other.Verify(token); !errors.Is(err, users.ErrInvalidSession) {
+		t.Errorf("Expected a token signed with another secret to be refused, got %v", err)
+	}
+	expired := users.NewSessions(file, secret, -time.Minute)
+	stale, _, _ := expired.Issue(user)
+	if _, err := sessions.Verify(stale); !errors.Is(err, users.ErrInvalidSession) {
+		t.Errorf("Expected an expired token to be refused, got %v", err)
+	}
+
+	// Changing the password ends the sessions signed in with the old one
+	time.Sleep(10 * time.Millisecond)
+	if err := file.SetPassword("alice", "battery staple"); err != nil {
+		t.Fatalf("SetPassword failed: %v", err)
+	}
+	if _, err := sessions.Verify(token); !errors.Is(err, users.ErrInvalidSession) {
+		t.Errorf("Expected the session to end with the password change, got %v", err)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	for _, check := range []struct {
+		role, required string
+		allowed        bool
+	}{
+		{users.RoleAdmin, users.RoleViewer, true},
+		{users.RoleOperator, users.RoleOperator, true},
+		{users.RoleOperator, users.RoleAdmin, false},
+		{users.RoleViewer, users.RoleOperator, false},
+		{"", users.RoleViewer, false},
+	} {
+		if allowed := users.Allows(check.role, check.required); allowed != check.allowed {
+			t.Errorf("Allows(%q, %q) = %v, expected %v", check.role, check.required, allowed, check.allowed)
+		}
+	}
+	if err := users.ValidateRole("owner"); err == nil {
+		t.Error("Expected an unknown role to be refused")
+	}
+}
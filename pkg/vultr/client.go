@@ -0,0 +1,120 @@
+package vultr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultEndpoint is the Vultr API base URL
+const DefaultEndpoint = "https://api.vultr.com/v2"
+
+// APIError is an error response from the Vultr API
+type APIError struct {
+	StatusCode int
+	Message    string `json:"error"`
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("vultr API returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("vultr API returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// isNotFound reports whether err is a 404 from the Vultr API
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// instance is the subset of the instance resource used by the provider
+type instance struct {
+	ID          string   `json:"id"`
+	Label       string   `json:"label"`
+	Status      string   `json:"status"`
+	PowerStatus string   `json:"power_status"`
+	MainIP      string   `json:"main_ip"`
+	InternalIP  string   `json:"internal_ip"`
+	Plan        string   `json:"plan"`
+	Region      string   `json:"region"`
+	DateCreated string   `json:"date_created"`
+	Tags        []string `json:"tags"`
+}
+
+// sshKey is an SSH key registered with the account
+type sshKey struct {
+	ID     string `json:"id,omitempty"`
+	Name   string `json:"name"`
+	SSHKey string `json:"ssh_key"`
+}
+
+// createInstanceRequest is the body of POST /instances
+type createInstanceRequest struct {
+	Region   string   `json:"region"`
+	Plan     string   `json:"plan"`
+	OSID     int      `json:"os_id"`
+	Label    string   `json:"label"`
+	Hostname string   `json:"hostname"`
+	SSHKeyID []string `json:"sshkey_id"`
+	Tags     []string `json:"tags"`
+}
+
+// listMeta holds the cursor of the next page of a list response
+type listMeta struct {
+	Links struct {
+		Next string `json:"next"`
+	} `json:"links"`
+}
+
+// client is a minimal Vultr API client
+type client struct {
+	httpClient *http.Client
+	endpoint   string
+	apiKey     string
+}
+
+// do sends a request with an optional JSON body and decodes the JSON
+// response into out, if given. Non-2xx responses are returned as *APIError.
+func (c *client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.endpoint, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		_ = json.NewDecoder(resp.Body).Decode(apiErr)
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,369 @@
+package vultr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultPlan is the plan used when none is given
+	DefaultPlan = "vc2-1c-1gb"
+
+	// DefaultRegion is used when no region is configured
+	DefaultRegion = "ewr"
+
+	// ubuntu2204 is the Vultr OS ID of Ubuntu 22.04 x64
+	ubuntu2204 = 1743
+
+	// managedTag marks instances created by this tool
+	managedTag = "instance-manager"
+
+	durationTagPrefix = "im-duration:"
+	sessionTagPrefix  = "im-session:"
+)
+
+// Plans lists common cloud compute plans offered by the web UI, default first
+var Plans = []string{
+	DefaultPlan,
+	"vc2-1c-2gb",
+	"vc2-2c-4gb",
+	"vc2-4c-8gb",
+	"vhf-1c-1gb",
+	"vhf-2c-4gb",
+}
+
+// Provider implements the CloudProvider interface for Vultr cloud compute
+// instances. Instance IDs are Vultr instance IDs.
+type Provider struct {
+	client *client
+	region string
+}
+
+// NewProvider creates a new Vultr provider using an API key
+func NewProvider(apiKey, region string) (cloud.CloudProvider, error) {
+	if apiKey == "" {
+		return nil, errors.New("VULTR_API_KEY environment variable is required")
+	}
+	if region == "" {
+		region = DefaultRegion
+	}
+	return NewProviderWithClient(&http.Client{Timeout: 30 * time.Second}, DefaultEndpoint, apiKey, region), nil
+}
+
+// NewProviderWithClient creates a Vultr provider that sends API requests to
+// endpoint using the given HTTP client
+func NewProviderWithClient(httpClient *http.Client, endpoint, apiKey, region string) *Provider {
+	return &Provider{
+		client: &client{httpClient: httpClient, endpoint: endpoint, apiKey: apiKey},
+		region: region,
+	}
+}
+
+// ValidateCredentials checks that the API key is valid
+func (p *Provider) ValidateCredentials(ctx context.Context) error {
+	if err := p.client.do(ctx, http.MethodGet, "/account", nil, nil); err != nil {
+		return fmt.Errorf("invalid Vultr API key: %w", err)
+	}
+	return nil
+}
+
+// CreateInstance registers the public key with the account if needed and
+// creates an instance tagged with its duration. Instances have no firewall
+// group by default, so OpenPorts needs no extra setup.
+func (p *Provider) CreateInstance(ctx context.Context, config models.InstanceConfig) (inst *models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "CreateInstance", "")
+	defer func() {
+		if inst != nil {
+			span.SetAttributes(tracing.AttrInstanceID.String(inst.ID))
+		}
+		tracing.EndSpan(span, err)
+	}()
+
+	if config.SecurityGroupID != "" {
+		return nil, errors.New("security group IDs are not supported on Vultr")
+	}
+
+	tags := []string{managedTag, durationTagPrefix + strconv.FormatInt(int64(config.Duration/time.Second), 10)}
+	if config.Session != "" {
+		tags = append(tags, sessionTagPrefix+config.Session)
+	}
+
+	var keyID string
+	if config.KeyName != "" {
+		keyID, err = p.findSSHKey(ctx, func(key sshKey) bool { return key.Name == config.KeyName })
+		if err == nil && keyID == "" {
+			err = fmt.Errorf("SSH key %q not found", config.KeyName)
+		}
+	} else {
+		keyID, err = p.importSSHKey(ctx, config.PublicKeyPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to import SSH key: %w", err)
+	}
+
+	plan := config.InstanceType
+	if plan == "" {
+		plan = DefaultPlan
+	}
+
+	launchTime := time.Now()
+	name := "im-" + strconv.FormatInt(launchTime.UnixNano(), 36)
+	var result struct {
+		Instance instance `json:"instance"`
+	}
+	err = p.client.do(ctx, http.MethodPost, "/instances", createInstanceRequest{
+		Region:   p.region,
+		Plan:     plan,
+		OSID:     ubuntu2204,
+		Label:    name,
+		Hostname: name,
+		SSHKeyID: []string{keyID},
+		Tags:     tags,
+	}, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create instance: %w", err)
+	}
+
+	inst = &models.Instance{
+		ID:               result.Instance.ID,
+		InstanceType:     plan,
+		State:            "pending",
+		LaunchTime:       launchTime,
+		Duration:         config.Duration,
+		AvailabilityZone: p.region,
+		Region:           p.region,
+		KeyName:          config.KeyName,
+		Username:         "root",
+		ExpiresAt:        launchTime.Add(config.Duration),
+		Provider:         "vultr",
+		RestartPolicy:    config.RestartPolicy,
+		Session:          config.Session,
+	}
+
+	return inst, nil
+}
+
+// GetInstanceStatus retrieves the status of an instance
+func (p *Provider) GetInstanceStatus(ctx context.Context, instanceID string) (_ *models.InstanceStatus, err error) {
+	ctx, span := p.startSpan(ctx, "GetInstanceStatus", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	var result struct {
+		Instance instance `json:"instance"`
+	}
+	if err := p.client.do(ctx, http.MethodGet, "/instances/"+url.PathEscape(instanceID), nil, &result); err != nil {
+		if isNotFound(err) {
+			return nil, errors.New("instance not found")
+		}
+		return nil, fmt.Errorf("failed to get instance: %w", err)
+	}
+
+	state := instanceState(&result.Instance)
+	return &models.InstanceStatus{
+		ID:        instanceID,
+		State:     state,
+		PublicIP:  ipAddress(result.Instance.MainIP),
+		PrivateIP: ipAddress(result.Instance.InternalIP),
+		Username:  "root",
+		Ready:     state == "running",
+	}, nil
+}
+
+// StartInstance starts a halted instance
+func (p *Provider) StartInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StartInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.do(ctx, http.MethodPost, "/instances/"+url.PathEscape(instanceID)+"/start", nil, nil); err != nil {
+		return fmt.Errorf("failed to start instance: %w", err)
+	}
+	return nil
+}
+
+// StopInstance halts an instance. Halted instances are still billed.
+func (p *Provider) StopInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "StopInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.do(ctx, http.MethodPost, "/instances/"+url.PathEscape(instanceID)+"/halt", nil, nil); err != nil {
+		return fmt.Errorf("failed to stop instance: %w", err)
+	}
+	return nil
+}
+
+// TerminateInstance deletes an instance
+func (p *Provider) TerminateInstance(ctx context.Context, instanceID string) (err error) {
+	ctx, span := p.startSpan(ctx, "TerminateInstance", instanceID)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := p.client.do(ctx, http.MethodDelete, "/instances/"+url.PathEscape(instanceID), nil, nil); err != nil {
+		return fmt.Errorf("failed to terminate instance: %w", err)
+	}
+	return nil
+}
+
+// ListInstances lists the instances tagged as managed by this tool
+func (p *Provider) ListInstances(ctx context.Context) (_ []*models.Instance, err error) {
+	ctx, span := p.startSpan(ctx, "ListInstances", "")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	var instances []*models.Instance
+	cursor := ""
+	for {
+		var result struct {
+			Instances []instance `json:"instances"`
+			Meta      listMeta   `json:"meta"`
+		}
+		path := "/instances?tag=" + managedTag + "&per_page=100&cursor=" + url.QueryEscape(cursor)
+		if err := p.client.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+			return nil, fmt.Errorf("failed to list instances: %w", err)
+		}
+
+		for i := range result.Instances {
+			instances = append(instances, toInstance(&result.Instances[i]))
+		}
+		if cursor = result.Meta.Links.Next; cursor == "" {
+			break
+		}
+	}
+
+	return instances, nil
+}
+
+// importSSHKey registers the public key with the account unless the same key
+// is already registered, and returns the key's ID
+func (p *Provider) importSSHKey(ctx context.Context, publicKeyPath string) (string, error) {
+	keyData, err := os.ReadFile(publicKeyPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read public key file: %w", err)
+	}
+	publicKey := strings.TrimSpace(string(keyData))
+	fields := strings.Fields(publicKey)
+	if len(fields) < 2 {
+		return "", errors.New("invalid public key format")
+	}
+
+	// Compare the key type and data, ignoring the comment
+	keyID, err := p.findSSHKey(ctx, func(key sshKey) bool {
+		existing := strings.Fields(key.SSHKey)
+		return len(existing) >= 2 && existing[0] == fields[0] && existing[1] == fields[1]
+	})
+	if err != nil || keyID != "" {
+		return keyID, err
+	}
+
+	var result struct {
+		SSHKey sshKey `json:"ssh_key"`
+	}
+	name := "instance-manager-" + strconv.FormatInt(time.Now().Unix(), 36)
+	if err := p.client.do(ctx, http.MethodPost, "/ssh-keys", sshKey{Name: name, SSHKey: publicKey}, &result); err != nil {
+		return "", err
+	}
+	return result.SSHKey.ID, nil
+}
+
+// findSSHKey returns the ID of the first registered SSH key that matches, or
+// an empty string if none does
+func (p *Provider) findSSHKey(ctx context.Context, match func(sshKey) bool) (string, error) {
+	cursor := ""
+	for {
+		var result struct {
+			SSHKeys []sshKey `json:"ssh_keys"`
+			Meta    listMeta `json:"meta"`
+		}
+		if err := p.client.do(ctx, http.MethodGet, "/ssh-keys?per_page=100&cursor="+url.QueryEscape(cursor), nil, &result); err != nil {
+			return "", err
+		}
+		for _, key := range result.SSHKeys {
+			if match(key) {
+				return key.ID, nil
+			}
+		}
+		if cursor = result.Meta.Links.Next; cursor == "" {
+			return "", nil
+		}
+	}
+}
+
+// startSpan starts a tracing span for a Vultr provider operation
+func (p *Provider) startSpan(ctx context.Context, operation, instanceID string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		tracing.AttrProvider.String("vultr"),
+		attribute.String("vultr.region", p.region),
+	}
+	if instanceID != "" {
+		attrs = append(attrs, tracing.AttrInstanceID.String(instanceID))
+	}
+	return tracing.StartSpan(ctx, "vultr."+operation, attrs...)
+}
+
+// toInstance converts a Vultr instance to an instance, reading the duration
+// and session back from its tags
+func toInstance(v *instance) *models.Instance {
+	inst := &models.Instance{
+		ID:               v.ID,
+		InstanceType:     v.Plan,
+		State:            instanceState(v),
+		PublicIP:         ipAddress(v.MainIP),
+		PrivateIP:        ipAddress(v.InternalIP),
+		AvailabilityZone: v.Region,
+		Region:           v.Region,
+		Username:         "root",
+		Provider:         "vultr",
+	}
+	if created, err := time.Parse(time.RFC3339, v.DateCreated); err == nil {
+		inst.LaunchTime = created
+	}
+
+	for _, tag := range v.Tags {
+		switch {
+		case strings.HasPrefix(tag, durationTagPrefix):
+			seconds, err := strconv.ParseInt(strings.TrimPrefix(tag, durationTagPrefix), 10, 64)
+			if err == nil {
+				inst.Duration = time.Duration(seconds) * time.Second
+				inst.ExpiresAt = inst.LaunchTime.Add(inst.Duration)
+			}
+		case strings.HasPrefix(tag, sessionTagPrefix):
+			inst.Session = strings.TrimPrefix(tag, sessionTagPrefix)
+		}
+	}
+	return inst
+}
+
+// instanceState maps an instance's status and power status to the states
+// used by the scheduler, which follow EC2's naming
+func instanceState(v *instance) string {
+	switch {
+	case v.Status == "pending":
+		return "pending"
+	case v.PowerStatus == "stopped":
+		return "stopped"
+	case v.PowerStatus == "running":
+		return "running"
+	default:
+		return v.Status
+	}
+}
+
+// ipAddress returns addr, or an empty string for the placeholder Vultr
+// reports before an address is assigned
+func ipAddress(addr string) string {
+	if addr == "0.0.0.0" {
+		return ""
+	}
+	return addr
+}
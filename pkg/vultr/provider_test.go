@@ -0,0 +1,273 @@
+package vultr_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/vultr"
+)
+
+// MockVultr serves the subset of the Vultr API used by the provider
+type MockVultr struct {
+	keys      []map[string]string
+	keyCalls  []map[string]string
+	creates   []map[string]interface{}
+	actions   []string
+	deleted   []string
+	instances map[string]map[string]interface{}
+}
+
+func NewMockVultr() *MockVultr {
+	return &MockVultr{instances: make(map[string]map[string]interface{})}
+}
+
+func (m *MockVultr) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer test-key" {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "Invalid API token.", "status": 401})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2")
+	switch {
+	case path == "/account":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"account": map[string]string{"name": "test"}})
+	case r.Method == http.MethodGet && path == "/ssh-keys":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ssh_keys": m.keys, "meta": map[string]interface{}{"links": map[string]string{"next": ""}}})
+	case r.Method == http.MethodPost && path == "/ssh-keys":
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		m.keyCalls = append(m.keyCalls, body)
+		body["id"] = "key-new"
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"ssh_key": body})
+	case r.Method == http.MethodPost && path == "/instances":
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		m.creates = append(m.creates, body)
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"instance": map[string]interface{}{"id": "cb676a46-66fd-4dfb-b839-443f2e6c0b60", "status": "pending", "main_ip": "0.0.0.0"}})
+	case r.Method == http.MethodGet && path == "/instances":
+		var instances []map[string]interface{}
+		for _, inst := range m.instances {
+			instances = append(instances, inst)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"instances": instances, "meta": map[string]interface{}{"links": map[string]string{"next": ""}}})
+	case strings.HasSuffix(path, "/start") || strings.HasSuffix(path, "/halt"):
+		m.actions = append(m.actions, path[strings.LastIndex(path, "/")+1:])
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(path, "/instances/"):
+		id := strings.TrimPrefix(path, "/instances/")
+		inst, ok := m.instances[id]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "Invalid instance-id.", "status": 404})
+			return
+		}
+		if r.Method == http.MethodDelete {
+			m.deleted = append(m.deleted, id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"instance": inst})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newTestProvider(t *testing.T, mock *MockVultr) *vultr.Provider {
+	t.Helper()
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+	return vultr.NewProviderWithClient(server.Client(), server.URL+"/v2", "test-key", "ams")
+}
+
+func writePublicKey(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "id_rsa.pub")
+	if err := os.WriteFile(path, []byte("ssh-rsa AAAAB3NzaC1yc2E test@example\n"), 0600); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+	return path
+}
+
+func testInstance(id, status, powerStatus string, tags ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           id,
+		"label":        "im-test",
+		"status":       status,
+		"power_status": powerStatus,
+		"main_ip":      "45.76.1.2",
+		"internal_ip":  "10.1.96.3",
+		"plan":         "vc2-1c-1gb",
+		"region":       "ams",
+		"date_created": "2024-05-01T10:00:00+00:00",
+		"tags":         tags,
+	}
+}
+
+func TestCreateInstance(t *testing.T) {
+	mock := NewMockVultr()
+	provider := newTestProvider(t, mock)
+
+	instance, err := provider.CreateInstance(context.Background(), models.InstanceConfig{
+		InstanceType:  "vc2-2c-4gb",
+		Duration:      2 * time.Hour,
+		PublicKeyPath: writePublicKey(t),
+		Session:       "exp-42",
+	})
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+
+	if len(mock.keyCalls) != 1 || mock.keyCalls[0]["ssh_key"] != "ssh-rsa AAAAB3NzaC1yc2E test@example" {
+		t.Fatalf("Expected the public key to be uploaded once, got %v", mock.keyCalls)
+	}
+	if len(mock.creates) != 1 {
+		t.Fatalf("Expected 1 instance create call, got %d", len(mock.creates))
+	}
+
+	body := mock.creates[0]
+	if body["plan"] != "vc2-2c-4gb" || body["region"] != "ams" || body["os_id"] != float64(1743) {
+		t.Errorf("Unexpected create request %v", body)
+	}
+	tags, _ := json.Marshal(body["tags"])
+	if string(tags) != `["instance-manager","im-duration:7200","im-session:exp-42"]` {
+		t.Errorf("Unexpected tags %s", tags)
+	}
+	if keys, _ := json.Marshal(body["sshkey_id"]); string(keys) != `["key-new"]` {
+		t.Errorf("Expected the uploaded key to be used, got %s", keys)
+	}
+
+	if instance.ID != "cb676a46-66fd-4dfb-b839-443f2e6c0b60" || instance.Provider != "vultr" || instance.Username != "root" {
+		t.Errorf("Unexpected instance %+v", instance)
+	}
+}
+
+func TestCreateInstance_ReusesExistingKey(t *testing.T) {
+	mock := NewMockVultr()
+	mock.keys = []map[string]string{
+		{"id": "key-other", "name": "other", "ssh_key": "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5 other"},
+		{"id": "key-team", "name": "team", "ssh_key": "ssh-rsa AAAAB3NzaC1yc2E laptop"},
+	}
+	provider := newTestProvider(t, mock)
+
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{Duration: time.Hour, PublicKeyPath: writePublicKey(t)}); err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{Duration: time.Hour, KeyName: "other"}); err != nil {
+		t.Fatalf("CreateInstance with key name failed: %v", err)
+	}
+
+	if len(mock.keyCalls) != 0 {
+		t.Errorf("Expected registered keys to be reused, got %d uploads", len(mock.keyCalls))
+	}
+	first, _ := json.Marshal(mock.creates[0]["sshkey_id"])
+	second, _ := json.Marshal(mock.creates[1]["sshkey_id"])
+	if string(first) != `["key-team"]` || string(second) != `["key-other"]` {
+		t.Errorf("Unexpected key IDs %s and %s", first, second)
+	}
+	if mock.creates[0]["plan"] != vultr.DefaultPlan {
+		t.Errorf("Expected default plan %s, got %v", vultr.DefaultPlan, mock.creates[0]["plan"])
+	}
+
+	if _, err := provider.CreateInstance(context.Background(), models.InstanceConfig{Duration: time.Hour, KeyName: "missing"}); err == nil {
+		t.Error("Expected an error for an unknown key name")
+	}
+}
+
+func TestGetInstanceStatus(t *testing.T) {
+	mock := NewMockVultr()
+	mock.instances["i-1"] = testInstance("i-1", "active", "running", "instance-manager")
+	mock.instances["i-2"] = testInstance("i-2", "pending", "running")
+	mock.instances["i-2"]["main_ip"] = "0.0.0.0"
+	provider := newTestProvider(t, mock)
+
+	status, err := provider.GetInstanceStatus(context.Background(), "i-1")
+	if err != nil {
+		t.Fatalf("GetInstanceStatus failed: %v", err)
+	}
+	if status.State != "running" || !status.Ready || status.PublicIP != "45.76.1.2" || status.PrivateIP != "10.1.96.3" {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	status, err = provider.GetInstanceStatus(context.Background(), "i-2")
+	if err != nil {
+		t.Fatalf("GetInstanceStatus failed: %v", err)
+	}
+	if status.State != "pending" || status.Ready || status.PublicIP != "" {
+		t.Errorf("Expected a pending instance without an address, got %+v", status)
+	}
+
+	if _, err := provider.GetInstanceStatus(context.Background(), "missing"); err == nil || err.Error() != "instance not found" {
+		t.Errorf("Expected instance not found, got %v", err)
+	}
+}
+
+func TestListInstances(t *testing.T) {
+	mock := NewMockVultr()
+	mock.instances["i-1"] = testInstance("i-1", "active", "stopped", "instance-manager", "im-duration:3600", "im-session:exp-42")
+	provider := newTestProvider(t, mock)
+
+	instances, err := provider.ListInstances(context.Background())
+	if err != nil {
+		t.Fatalf("ListInstances failed: %v", err)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("Expected 1 instance, got %d", len(instances))
+	}
+
+	instance := instances[0]
+	if instance.ID != "i-1" || instance.State != "stopped" || instance.Session != "exp-42" || instance.Duration != time.Hour {
+		t.Errorf("Unexpected instance %+v", instance)
+	}
+	want := time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)
+	if !instance.ExpiresAt.Equal(want) {
+		t.Errorf("Expected expiry %v, got %v", want, instance.ExpiresAt)
+	}
+}
+
+func TestHaltStartAndDelete(t *testing.T) {
+	mock := NewMockVultr()
+	mock.instances["i-1"] = testInstance("i-1", "active", "running")
+	provider := newTestProvider(t, mock)
+
+	if err := provider.StopInstance(context.Background(), "i-1"); err != nil {
+		t.Fatalf("StopInstance failed: %v", err)
+	}
+	if err := provider.StartInstance(context.Background(), "i-1"); err != nil {
+		t.Fatalf("StartInstance failed: %v", err)
+	}
+	if err := provider.TerminateInstance(context.Background(), "i-1"); err != nil {
+		t.Fatalf("TerminateInstance failed: %v", err)
+	}
+
+	if strings.Join(mock.actions, ",") != "halt,start" {
+		t.Errorf("Unexpected actions %v", mock.actions)
+	}
+	if len(mock.deleted) != 1 || mock.deleted[0] != "i-1" {
+		t.Errorf("Expected instance i-1 to be deleted, got %v", mock.deleted)
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	server := httptest.NewServer(NewMockVultr())
+	defer server.Close()
+
+	if err := vultr.NewProviderWithClient(server.Client(), server.URL+"/v2", "test-key", "ams").ValidateCredentials(context.Background()); err != nil {
+		t.Errorf("Expected valid API key, got %v", err)
+	}
+	if err := vultr.NewProviderWithClient(server.Client(), server.URL+"/v2", "wrong", "ams").ValidateCredentials(context.Background()); err == nil {
+		t.Error("Expected an error for an invalid API key")
+	}
+}
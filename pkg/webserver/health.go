@@ -0,0 +1,201 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Readiness checks
+const (
+	// readinessTimeout bounds each check, so a hung dependency fails the
+	// probe rather than holding it
+	readinessTimeout = 5 * time.Second
+	// providerCheckTTL is how long the outcome of validating the provider's
+	// credentials is kept, sparing its API the probes of every load balancer
+	providerCheckTTL = time.Minute
+)
+
+// Statuses of dependency checks
+const (
+	checkOK      = "ok"
+	checkFailing = "failing"
+	checkPaused  = "paused"
+	checkUnknown = "unknown"
+)
+
+// dependencyCheck is the state of a dependency the readiness probe checks
+type dependencyCheck struct {
+	Status    string    `json:"status"`
+	Critical  bool      `json:"critical"` // Whether failing makes the server unready
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// readiness is the answer of the readiness probe
+type readiness struct {
+	Status string                      `json:"status"` // ready or not ready
+	Checks map[string]*dependencyCheck `json:"checks"`
+}
+
+// SetSchedulerInterval sets how often the scheduler runs its passes, so the
+// readiness probe reports a scheduler that missed several. Zero leaves the
+// scheduler out.
+func (s *Server) SetSchedulerInterval(interval time.Duration) {
+	s.schedulerInterval = interval
+}
+
+// handleLiveness answers while the process serves requests at all
+func (s *Server) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	s.jsonResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Alive",
+		Data:    map[string]string{"status": "alive"},
+	})
+}
+
+// handleReadiness checks the dependencies of the server, answering 503
+// unless storage and the provider work and the server is not shutting down
+func (s *Server) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func(ctx context.Context) *dependencyCheck{
+		"storage":  s.checkStorage,
+		"provider": s.checkProvider,
+	}
+	if s.schedulerInterval > 0 {
+		checks["scheduler"] = s.checkScheduler
+	}
+
+	result := readiness{Status: "ready", Checks: make(map[string]*dependencyCheck, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) *dependencyCheck) {
+			defer wg.Done()
+			outcome := check(r.Context())
+			mu.Lock()
+			defer mu.Unlock()
+			result.Checks[name] = outcome
+		}(name, check)
+	}
+	wg.Wait()
+
+	var failing []string
+	for name, check := range result.Checks {
+		if check.Critical && check.Status != checkOK {
+			failing = append(failing, name)
+		}
+	}
+	sort.Strings(failing)
+	select {
+	case <-s.closing:
+		failing = append(failing, "shutting down")
+	default:
+	}
+
+	if len(failing) > 0 {
+		result.Status = "not ready"
+		s.jsonResponse(w, http.StatusServiceUnavailable, APIResponse{
+			Success: false,
+			Error:   "Not ready: " + strings.Join(failing, ", "),
+			Data:    result,
+		})
+		return
+	}
+	s.jsonResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Ready",
+		Data:    result,
+	})
+}
+
+// checkStorage reads the latest scheduler report, which every backend keeps
+// under a single key
+func (s *Server) checkStorage(ctx context.Context) *dependencyCheck {
+	err := withinTimeout(ctx, func(context.Context) error {
+		_, err := s.storage.LastSchedulerRun()
+		return err
+	})
+	return newCheck(true, err)
+}
+
+// checkProvider validates the credentials of the server's provider, at most
+// once per providerCheckTTL
+func (s *Server) checkProvider(ctx context.Context) *dependencyCheck {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	if s.providerCheck != nil && time.Since(s.providerCheck.CheckedAt) < providerCheckTTL {
+		return s.providerCheck
+	}
+	if s.provider == nil {
+		return newCheck(true, fmt.Errorf("no %s provider", s.providerName))
+	}
+	s.providerCheck = newCheck(true, withinTimeout(ctx, s.provider.ValidateCredentials))
+	return s.providerCheck
+}
+
+// checkScheduler reports whether the scheduler passes over the instances
+// every interval, as its reports in storage tell. The scheduler runs in
+// another process, the service, so it does not make the web server unready.
+func (s *Server) checkScheduler(ctx context.Context) *dependencyCheck {
+	var check *dependencyCheck
+	err := withinTimeout(ctx, func(context.Context) error {
+		run, err := s.storage.LastSchedulerRun()
+		if err != nil {
+			return err
+		}
+		pause, err := s.storage.SchedulerPause()
+		if err != nil {
+			return err
+		}
+		switch {
+		case pause.Active(time.Now()):
+			check = &dependencyCheck{Status: checkPaused, Error: pause.Reason}
+		case run == nil:
+			check = &dependencyCheck{Status: checkUnknown, Error: "the scheduler has not run yet"}
+		default:
+			// Passes run every interval, so missing several means the
+			// service is down or standing by for another replica
+			if since := time.Since(run.StartedAt); since > 3*s.schedulerInterval+run.Duration {
+				return fmt.Errorf("no pass for %s although the interval is %s", since.Round(time.Second), s.schedulerInterval)
+			}
+		}
+		return nil
+	})
+	if check == nil || err != nil {
+		check = newCheck(false, err)
+	}
+	check.CheckedAt = time.Now()
+	return check
+}
+
+// newCheck returns the outcome of a check that failed with err, or passed
+// when it is nil
+func newCheck(critical bool, err error) *dependencyCheck {
+	check := &dependencyCheck{Status: checkOK, Critical: critical, CheckedAt: time.Now()}
+	if err != nil {
+		check.Status = checkFailing
+		check.Error = err.Error()
+	}
+	return check
+}
+
+// withinTimeout runs check, failing it when it takes longer than
+// readinessTimeout. Storage calls take no context, so a hung one is left
+// running in the background.
+func withinTimeout(ctx context.Context, check func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("no answer within %s", readinessTimeout)
+	}
+}
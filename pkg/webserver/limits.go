@@ -0,0 +1,105 @@
+package webserver
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/ratelimit"
+)
+
+// RateLimits bound how fast clients may call the API, so a runaway script
+// cannot launch hundreds of instances or hammer the cloud provider. A zero
+// limit is off.
+type RateLimits struct {
+	// PerIP bounds the requests from each client address, signed in or not
+	PerIP ratelimit.Limit
+	// PerToken bounds the requests of each API key, session or proxy user
+	PerToken ratelimit.Limit
+	// Changes bounds how many instances each client creates, extends, stops
+	// and terminates
+	Changes ratelimit.Limit
+	// ClientIPHeader is the header in which a proxy in front of the server
+	// passes the client address, such as X-Forwarded-For; empty uses the
+	// address of the connection
+	ClientIPHeader string
+}
+
+// SetRateLimits sets the rate limits of the API
+func (s *Server) SetRateLimits(limits RateLimits) {
+	s.ipLimiter = ratelimit.New(limits.PerIP)
+	s.tokenLimiter = ratelimit.New(limits.PerToken)
+	s.changeLimiter = ratelimit.New(limits.Changes)
+	s.clientIPHeader = limits.ClientIPHeader
+}
+
+// allowIP reports whether the client address of a request is within its
+// rate limit, answering 429 when it is not
+func (s *Server) allowIP(w http.ResponseWriter, r *http.Request) bool {
+	ok, wait := s.ipLimiter.Allow(s.clientIP(r))
+	if !ok {
+		s.tooManyRequests(w, r, wait, "requests from this address")
+	}
+	return ok
+}
+
+// allowToken reports whether the user of a request is within its rate
+// limit, answering 429 when it is not
+func (s *Server) allowToken(w http.ResponseWriter, r *http.Request) bool {
+	ok, wait := s.tokenLimiter.Allow(s.clientKey(r))
+	if !ok {
+		s.tooManyRequests(w, r, wait, "requests")
+	}
+	return ok
+}
+
+// limitChanges returns a handler refusing the requests of clients that
+// changed too many instances of late
+func (s *Server) limitChanges(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := s.changeLimiter.Allow(s.clientKey(r)); !ok {
+			s.tooManyRequests(w, r, wait, "instance changes")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// tooManyRequests answers 429, telling the client when to retry
+func (s *Server) tooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration, what string) {
+	s.log(r.Context()).WithField("client", s.clientKey(r)).WithField("path", r.URL.Path).Debug("Rate limited a request")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	s.jsonResponse(w, http.StatusTooManyRequests, APIResponse{
+		Success: false,
+		Error:   fmt.Sprintf("Too many %s; retry in %s", what, wait.Round(time.Second)),
+	})
+}
+
+// clientKey returns the key of the bucket of a request's client: its user,
+// named by its API key, session or proxy header, or else its address
+func (s *Server) clientKey(r *http.Request) string {
+	if user := s.user(r); user != "" {
+		return "user:" + user
+	}
+	return "ip:" + s.clientIP(r)
+}
+
+// clientIP returns the address of the client of a request
+func (s *Server) clientIP(r *http.Request) string {
+	if s.clientIPHeader != "" {
+		if value := r.Header.Get(s.clientIPHeader); value != "" {
+			// The proxy in front appends the address it saw last
+			addresses := strings.Split(value, ",")
+			return strings.TrimSpace(addresses[len(addresses)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,144 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/storage"
+
+	"golang.org/x/net/websocket"
+)
+
+// liveSyncInterval is how often instances that are starting or stopping are
+// synced with their providers while browsers watch, so an assigned IP shows
+// up without waiting for the scheduler
+const liveSyncInterval = 5 * time.Second
+
+// livePingInterval is how often idle live update connections are pinged, so
+// proxies do not close them
+const livePingInterval = 30 * time.Second
+
+// liveWriteTimeout bounds each write to a browser that stopped reading
+const liveWriteTimeout = 10 * time.Second
+
+// liveUpdate is a change to an instance pushed over /api/v1/ws
+type liveUpdate struct {
+	Type       string        `json:"type"` // created, updated or deleted
+	InstanceID string        `json:"instance_id"`
+	Instance   *instanceView `json:"instance,omitempty"` // Unset when deleted
+}
+
+// handleLiveUpdates upgrades the request to a WebSocket and pushes the
+// changes to the instances the user sees until either side closes it
+func (s *Server) handleLiveUpdates(w http.ResponseWriter, r *http.Request) {
+	// Watching before the upgrade reports every change made once the
+	// browser is connected
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := s.storageFor(r)
+	changes, err := store.Watch(ctx)
+	if err != nil {
+		s.log(r.Context()).WithError(err).Warn("Failed to watch instances for live updates")
+		s.jsonResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Failed to watch instances: %v", err),
+		})
+		return
+	}
+	server := websocket.Server{
+		Handshake: checkOrigin,
+		Handler: func(conn *websocket.Conn) {
+			s.streamUpdates(ctx, cancel, conn, store, changes)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// checkOrigin refuses WebSocket handshakes from pages on other sites, which
+// the browser would otherwise let use the user's cookies. Clients that send
+// no Origin, such as scripts, are let through.
+func checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host != r.Host {
+		return websocket.ErrBadWebSocketOrigin
+	}
+	config.Origin = parsed
+	return nil
+}
+
+// streamUpdates writes the changes to the instances of store to conn until
+// ctx is done, cancelling it when the browser closes the connection
+func (s *Server) streamUpdates(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn, store storage.Storage, changes <-chan storage.ChangeEvent) {
+	// The server's read and write timeouts still apply to the hijacked
+	// connection; it lives on until either side closes it instead
+	conn.SetDeadline(time.Time{})
+
+	// Browsers send nothing but close frames; reading notices them
+	go func() {
+		defer cancel()
+		var message string
+		for websocket.Message.Receive(conn, &message) == nil {
+		}
+	}()
+
+	settle := time.NewTicker(liveSyncInterval)
+	defer settle.Stop()
+	ping := time.NewTicker(livePingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.closing:
+			return
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			update := liveUpdate{Type: change.Type, InstanceID: change.InstanceID}
+			if change.Instance != nil {
+				view := s.instanceView(change.Instance, time.Now())
+				update.Instance = &view
+			}
+			conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+			if err := websocket.JSON.Send(conn, update); err != nil {
+				return
+			}
+		case <-settle.C:
+			s.syncSettling(ctx, store)
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+			conn.PayloadType = websocket.PingFrame
+			_, err := conn.Write(nil)
+			conn.PayloadType = websocket.TextFrame
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// syncSettling syncs the instances of store that are starting or stopping
+// with their providers. The changes reach the browsers through the watch.
+func (s *Server) syncSettling(ctx context.Context, store storage.Storage) {
+	instances, err := store.ListInstances()
+	if err != nil {
+		s.log(ctx).WithError(err).Debug("Failed to list instances for live updates")
+		return
+	}
+	var settling []*models.Instance
+	for _, instance := range instances {
+		if instance.NeedsIPUpdate() || instance.State == "stopping" || instance.State == "shutting-down" {
+			settling = append(settling, instance)
+		}
+	}
+	s.syncInstances(ctx, store, settling)
+}
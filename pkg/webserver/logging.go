@@ -0,0 +1,139 @@
+package webserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/logging"
+	"instance-manager/pkg/tracing"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader carries the ID of a request, from a proxy in front that
+// assigned one and back to the client
+const requestIDHeader = "X-Request-ID"
+
+// requestIDPattern is the form of request IDs taken from clients; others
+// are replaced, so logs hold no injected text
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestInfo is what the log entry and metrics of a request record
+type requestInfo struct {
+	id    string
+	route string // Pattern of the API route that served the request
+}
+
+// requestInfoKey is the context key of the requestInfo of a request
+type requestInfoKey struct{}
+
+// requestID returns the ID of the request ctx serves, or ""
+func requestID(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// setRoute records the pattern of the route serving a request
+func setRoute(r *http.Request, pattern string) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		info.route = pattern
+	}
+}
+
+// newRequestID returns a random request ID
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
+
+// log returns the logger of the request ctx serves, whose entries carry its
+// ID
+func (s *Server) log(ctx context.Context) *logrus.Entry {
+	if requestID(ctx) != "" {
+		return logging.FromContext(ctx)
+	}
+	return logrus.NewEntry(s.logger)
+}
+
+// logRequests returns a handler that gives each request an ID, returned in
+// the X-Request-ID header, logs its method, path, status and duration, and
+// records them in the metrics. An ID the client or a proxy sent is kept.
+// The request's context carries a logger with the ID, so the provider calls
+// made for it log their retries under the ID too.
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		trace.SpanFromContext(r.Context()).SetAttributes(tracing.AttrRequestID.String(id))
+
+		info := &requestInfo{id: id}
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+		ctx = logging.WithLogger(ctx, s.logger.WithField("request_id", id))
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		elapsed := time.Since(start)
+		if info.route == "" {
+			info.route = routeLabel(r.URL.Path)
+		}
+		s.observeRequest(r, info.route, recorder.status, elapsed)
+		entry := s.logger.WithFields(logrus.Fields{
+			"request_id":  id,
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"route":       info.route,
+			"status":      recorder.status,
+			"duration_ms": elapsed.Milliseconds(),
+			"client":      s.clientIP(r),
+		})
+		switch {
+		case recorder.status >= http.StatusInternalServerError:
+			entry.Error("Served a request")
+		case strings.HasPrefix(r.URL.Path, "/api/"):
+			entry.Info("Served a request")
+		default:
+			// The pages and assets of the web UI
+			entry.Debug("Served a request")
+		}
+	})
+}
+
+// responseRecorder captures the status code a handler writes
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code before writing it
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack hands the connection over to the handler, for WebSocket upgrades
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the connection cannot be hijacked")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
@@ -0,0 +1,98 @@
+package webserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"instance-manager/pkg/metrics"
+	"instance-manager/pkg/storage"
+)
+
+// serverMetrics are the metrics the web server exposes at /metrics
+type serverMetrics struct {
+	registry       *metrics.Registry
+	requests       *metrics.Counter   // By method, route and status
+	duration       *metrics.Histogram // By method and route
+	providerErrors *metrics.Counter   // By provider and operation
+}
+
+// newMetrics returns the metrics of the server, counting its instances in
+// storage on every scrape
+func (s *Server) newMetrics() *serverMetrics {
+	registry := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry:       registry,
+		requests:       registry.Counter("instance_manager_http_requests_total", "HTTP requests served, by method, route and status", "method", "route", "status"),
+		duration:       registry.Histogram("instance_manager_http_request_duration_seconds", "Time to serve HTTP requests, by method and route", metrics.DefaultBuckets, "method", "route"),
+		providerErrors: registry.Counter("instance_manager_provider_errors_total", "Failed cloud provider calls made by the web server, by provider and operation", "provider", "operation"),
+	}
+	registry.GaugeFunc("instance_manager_instances", "Stored instances, by state and provider", func() ([]metrics.Sample, error) {
+		instances, err := s.storage.ListInstances()
+		if err != nil {
+			s.logger.WithError(err).Warn("Failed to count instances for metrics")
+			return nil, err
+		}
+		samples := make([]metrics.Sample, 0, len(instances))
+		for _, instance := range instances {
+			samples = append(samples, metrics.Sample{Labels: []string{instance.State, s.providerOf(instance.Provider)}, Value: 1})
+		}
+		return samples, nil
+	}, "state", "provider")
+	return m
+}
+
+// handleMetrics serves the metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.metrics.registry.Handler().ServeHTTP(w, r)
+}
+
+// observeRequest records a request served in the metrics
+func (s *Server) observeRequest(r *http.Request, route string, status int, elapsed time.Duration) {
+	method := r.Method
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		// Clients pick methods freely; keep the series few
+		method = "OTHER"
+	}
+	s.metrics.requests.Inc(method, route, strconv.Itoa(status))
+	if status != http.StatusSwitchingProtocols {
+		// WebSocket connections last as long as the browser stays
+		s.metrics.duration.Observe(elapsed.Seconds(), method, route)
+	}
+}
+
+// routeLabel returns the route label of a request no API route served
+func routeLabel(path string) string {
+	switch {
+	case path == "/metrics" || path == "/healthz" || path == "/readyz":
+		return path
+	case strings.HasPrefix(path, "/api/"):
+		return "unmatched"
+	}
+	return "static"
+}
+
+// providerError counts a failed call to the named provider
+func (s *Server) providerError(provider, operation string) {
+	s.metrics.providerErrors.Inc(s.providerOf(provider), operation)
+}
+
+// providerOf returns the name of the provider of an instance, which is the
+// server's provider for instances stored without one
+func (s *Server) providerOf(provider string) string {
+	if provider == "" {
+		return s.providerName
+	}
+	return provider
+}
+
+// storedProvider returns the name of the provider of a stored instance
+func (s *Server) storedProvider(store storage.Storage, instanceID string) string {
+	if instance, err := store.GetInstance(instanceID); err == nil {
+		return s.providerOf(instance.Provider)
+	}
+	return s.providerName
+}
@@ -0,0 +1,570 @@
+package webserver
+
+import "net/http"
+
+// handleOpenAPI serves the OpenAPI 3 description of the API
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(getOpenAPISpec()))
+}
+
+// handleAPIDocs serves Swagger UI on the OpenAPI description
+func (s *Server) handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(getAPIDocsHTML()))
+}
+
+func getAPIDocsHTML() string {
+	return `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Instance Manager API</title>
+    <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5.17.14/swagger-ui.css">
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="https://unpkg.com/swagger-ui-dist@5.17.14/swagger-ui-bundle.js"></script>
+    <script>
+        window.ui = SwaggerUIBundle({
+            url: '/api/openapi.json',
+            dom_id: '#swagger-ui',
+            deepLinking: true,
+            // Sessions from the web UI apply to "Try it out" as well
+            withCredentials: true
+        });
+    </script>
+</body>
+</html>`
+}
+
+// getOpenAPISpec returns the OpenAPI 3 description of the API. Keep it in
+// step with routes: TestOpenAPISpec checks that every route is described.
+func getOpenAPISpec() string {
+	return `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Instance Manager API",
+    "version": "1.0",
+    "description": "Creates cloud instances with a time to live and manages them until they expire. Every response is a JSON envelope with success, message, data and error. Depending on the configuration, requests authenticate with an API key or session token as a bearer token, the session cookie set by signing in, or a user header set by an authenticating proxy. Each route takes a role: viewer, operator or admin. Clients over their rate limit, per address, per user or, for creating, stopping and terminating instances, per change, get 429 with a Retry-After header. The unversioned routes of earlier releases, such as POST /api/instances/extend?instance_id=<id>, still work but are deprecated: their responses carry a Deprecation header and a Link to the version 1 route, and they will be removed in the next release."
+  },
+  "servers": [{"url": "/"}],
+  "security": [{"bearer": []}, {"session": []}, {}],
+  "tags": [
+    {"name": "instances", "description": "Create, list and manage instances"},
+    {"name": "scheduler", "description": "The background service that stops expired instances"},
+    {"name": "auth", "description": "Sign-in and the current user"},
+    {"name": "server", "description": "The web server itself"}
+  ],
+  "paths": {
+    "/api/openapi.json": {
+      "get": {
+        "tags": ["server"],
+        "summary": "This OpenAPI description",
+        "security": [],
+        "responses": {"200": {"description": "The OpenAPI 3 document", "content": {"application/json": {}}}}
+      }
+    },
+    "/api/docs": {
+      "get": {
+        "tags": ["server"],
+        "summary": "Swagger UI on this description",
+        "security": [],
+        "responses": {"200": {"description": "An HTML page", "content": {"text/html": {}}}}
+      }
+    },
+    "/api/v1/health": {
+      "get": {
+        "tags": ["server"],
+        "summary": "Report that the server is up",
+        "security": [],
+        "responses": {
+          "200": {"description": "The server is healthy, with the name of its provider in data.provider", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Response"}}}}
+        }
+      }
+    },
+    "/api/v1/instances": {
+      "get": {
+        "tags": ["instances"],
+        "summary": "List instances",
+        "description": "Lists the instances the user sees a page at a time, latest expiry first unless sorted otherwise. The instances on the page are synced with their providers, and pagination carries the total. Viewers and admins see every instance; other users see their own. Role: viewer.",
+        "parameters": [
+          {"name": "state", "in": "query", "description": "States to keep, repeatable or comma-separated", "schema": {"type": "array", "items": {"type": "string"}}, "style": "form", "explode": true},
+          {"name": "provider", "in": "query", "schema": {"type": "string"}},
+          {"name": "region", "in": "query", "schema": {"type": "string"}},
+          {"name": "owner", "in": "query", "schema": {"type": "string"}},
+          {"name": "tag", "in": "query", "description": "Tags as key=value, repeatable; all must match", "schema": {"type": "array", "items": {"type": "string"}}, "style": "form", "explode": true},
+          {"name": "expires_after", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "expires_before", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "expires_within", "in": "query", "description": "A duration from now, such as 2h", "schema": {"type": "string"}},
+          {"name": "page", "in": "query", "schema": {"type": "integer", "minimum": 1, "default": 1}},
+          {"name": "per_page", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 500, "default": 50}},
+          {"name": "sort", "in": "query", "description": "The field to sort by, prefixed with - for descending order", "schema": {"type": "string", "enum": ["expires_at", "-expires_at", "launch_time", "-launch_time", "name", "-name", "state", "-state", "owner", "-owner", "provider", "-provider"], "default": "-expires_at"}}
+        ],
+        "responses": {
+          "200": {"description": "The instances", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Response"}, {"properties": {"data": {"type": "array", "items": {"$ref": "#/components/schemas/InstanceView"}}}}]}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"}
+        }
+      },
+      "post": {
+        "tags": ["instances"],
+        "summary": "Create an instance",
+        "description": "Creates an instance owned by the user. Role: operator.",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/CreateInstanceRequest"}}}},
+        "responses": {
+          "201": {"description": "The instance was created", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Response"}, {"properties": {"data": {"$ref": "#/components/schemas/Instance"}}}]}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "409": {"description": "An instance with the name exists", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Response"}}}},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/v1/instances/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/InstanceID"}],
+      "get": {
+        "tags": ["instances"],
+        "summary": "Get the status of an instance from its provider",
+        "description": "Role: viewer.",
+        "responses": {
+          "200": {"description": "The instance and its status", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Response"}, {"properties": {"data": {"$ref": "#/components/schemas/InstanceStatusReport"}}}]}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "tags": ["instances"],
+        "summary": "Terminate an instance",
+        "description": "Terminates the instance and moves its record to the archive. Role: admin.",
+        "responses": {
+          "200": {"description": "The instance is terminating", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Response"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/v1/instances/{id}/extend": {
+      "parameters": [{"$ref": "#/components/parameters/InstanceID"}],
+      "post": {
+        "tags": ["instances"],
+        "summary": "Extend the time to live of an instance",
+        "description": "Moves the expiry of the instance later by duration. Role: operator, for their own instances.",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ExtendInstanceRequest"}}}},
+        "responses": {
+          "200": {"description": "The extended instance", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Response"}, {"properties": {"data": {"$ref": "#/components/schemas/Instance"}}}]}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"description": "The role is not enough, or the extension runs past the maximum lifetime", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Response"}}}},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/v1/instances/{id}/stop": {
+      "parameters": [{"$ref": "#/components/parameters/InstanceID"}],
+      "post": {
+        "tags": ["instances"],
+        "summary": "Stop an instance",
+        "description": "Role: operator, for their own instances.",
+        "responses": {
+          "200": {"description": "The instance is stopping", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Response"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/v1/instances/{id}/history": {
+      "parameters": [{"$ref": "#/components/parameters/InstanceID"}],
+      "get": {
+        "tags": ["instances"],
+        "summary": "Get the lifecycle events of an instance, oldest first",
+        "description": "Role: viewer.",
+        "responses": {
+          "200": {"description": "The events", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Response"}, {"properties": {"data": {"type": "array", "items": {"$ref": "#/components/schemas/HistoryEvent"}}}}]}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    },
+    "/api/v1/instance-types": {
+      "get": {
+        "tags": ["instances"],
+        "summary": "List the instance types offered, default first",
+        "description": "Role: viewer.",
+        "responses": {
+          "200": {"description": "The instance types", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Response"}, {"properties": {"data": {"type": "array", "items": {"type": "string"}}}}]}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"}
+        }
+      }
+    },
+    "/api/v1/ws": {
+      "get": {
+        "tags": ["instances"],
+        "summary": "Stream changes to the instances over a WebSocket",
+        "description": "Upgrades to a WebSocket that carries a LiveUpdate JSON message for each change to the instances the user sees. Handshakes whose Origin is another site are refused. Role: viewer.",
+        "responses": {
+          "101": {"description": "Switched to the WebSocket protocol; each message is a LiveUpdate", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/LiveUpdate"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"}
+        }
+      }
+    },
+    "/api/v1/schedule": {
+      "get": {
+        "tags": ["scheduler"],
+        "summary": "Preview the actions the scheduler takes next",
+        "description": "Role: viewer.",
+        "responses": {
+          "200": {"description": "The upcoming actions, soonest first", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Response"}, {"properties": {"data": {"type": "array", "items": {"$ref": "#/components/schemas/ScheduledAction"}}}}]}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/v1/scheduler/status": {
+      "get": {
+        "tags": ["scheduler"],
+        "summary": "Get the report of the latest scheduler pass",
+        "description": "data is null until the scheduler has run; the message says whether it is paused. Users who only see their own instances get the errors about them alone, without the counts. Role: viewer.",
+        "responses": {
+          "200": {"description": "The latest pass", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Response"}, {"properties": {"data": {"$ref": "#/components/schemas/SchedulerRun"}}}]}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/v1/scheduler/pause": {
+      "post": {
+        "tags": ["scheduler"],
+        "summary": "Pause the scheduler",
+        "description": "Role: admin.",
+        "requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/PauseSchedulerRequest"}}}},
+        "responses": {
+          "200": {"description": "The pause in effect", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Response"}, {"properties": {"data": {"$ref": "#/components/schemas/SchedulerPause"}}}]}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/v1/scheduler/resume": {
+      "post": {
+        "tags": ["scheduler"],
+        "summary": "End a pause of the scheduler",
+        "description": "Role: admin.",
+        "responses": {
+          "200": {"description": "The scheduler runs again, or was not paused", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Response"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/v1/auth/login": {
+      "post": {
+        "tags": ["auth"],
+        "summary": "Sign in with a user name and password",
+        "description": "Returns a session token and sets it as the session cookie. Answers 404 unless user accounts are enabled.",
+        "security": [],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/LoginRequest"}}}},
+        "responses": {
+          "200": {"description": "Signed in", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Response"}, {"properties": {"data": {"$ref": "#/components/schemas/Session"}}}]}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"description": "Wrong user name or password", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Response"}}}},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    },
+    "/api/v1/auth/logout": {
+      "post": {
+        "tags": ["auth"],
+        "summary": "Sign out, clearing the session cookie",
+        "security": [],
+        "responses": {"200": {"description": "Signed out", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Response"}}}}}
+      }
+    },
+    "/api/v1/auth/me": {
+      "get": {
+        "tags": ["auth"],
+        "summary": "Get the current user and their role",
+        "description": "Role: viewer.",
+        "responses": {
+          "200": {"description": "The current user", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Response"}, {"properties": {"data": {"$ref": "#/components/schemas/Session"}}}]}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"}
+        }
+      }
+    },
+    "/api/v1/auth/sso/login": {
+      "get": {
+        "tags": ["auth"],
+        "summary": "Start signing in with the identity provider",
+        "description": "Redirects the browser to the identity provider, which returns it to /api/auth/sso/callback. Answers 404 unless single sign-on is enabled.",
+        "security": [],
+        "parameters": [{"name": "next", "in": "query", "description": "Local path to return to once signed in", "schema": {"type": "string"}}],
+        "responses": {
+          "302": {"description": "Redirect to the identity provider"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    },
+    "/api/v1/auth/sso/callback": {
+      "get": {
+        "tags": ["auth"],
+        "summary": "Finish signing in with the identity provider",
+        "description": "The identity provider sends the browser here. Sets the session cookie and redirects to the page sign-in started from, or to the login page with an error.",
+        "security": [],
+        "parameters": [
+          {"name": "code", "in": "query", "schema": {"type": "string"}},
+          {"name": "state", "in": "query", "schema": {"type": "string"}}
+        ],
+        "responses": {"303": {"description": "Redirect into the web UI, or to the login page with an error"}}
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": {"type": "http", "scheme": "bearer", "description": "An API key, or the token returned by signing in"},
+      "session": {"type": "apiKey", "in": "cookie", "name": "instance_manager_session"}
+    },
+    "parameters": {
+      "InstanceID": {"name": "id", "in": "path", "required": true, "description": "The ID of the instance", "schema": {"type": "string"}}
+    },
+    "responses": {
+      "BadRequest": {"description": "The request is invalid", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Response"}}}},
+      "Unauthorized": {"description": "The request carries no valid credentials; WWW-Authenticate says which to send", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Response"}}}},
+      "Forbidden": {"description": "The role of the user may not do this", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Response"}}}},
+      "TooManyRequests": {"description": "The client changed too many instances of late; retry after the Retry-After header's seconds", "headers": {"Retry-After": {"schema": {"type": "integer"}}}, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Response"}}}},
+      "NotFound": {"description": "No such instance among those the user sees", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Response"}}}},
+      "Error": {"description": "The provider or the storage failed", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Response"}}}}
+    },
+    "schemas": {
+      "Response": {
+        "type": "object",
+        "required": ["success", "message"],
+        "properties": {
+          "success": {"type": "boolean"},
+          "message": {"type": "string"},
+          "data": {"description": "The result, which depends on the route"},
+          "error": {"type": "string", "description": "Why the request failed"},
+          "pagination": {"$ref": "#/components/schemas/Pagination"}
+        }
+      },
+      "Pagination": {
+        "type": "object",
+        "description": "The page of a list a response holds",
+        "properties": {
+          "page": {"type": "integer"},
+          "per_page": {"type": "integer"},
+          "total": {"type": "integer", "description": "Items in the whole list"},
+          "pages": {"type": "integer"}
+        }
+      },
+      "Instance": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "name": {"type": "string"},
+          "instance_type": {"type": "string"},
+          "provider": {"type": "string"},
+          "public_ip": {"type": "string"},
+          "private_ip": {"type": "string"},
+          "state": {"type": "string", "example": "running"},
+          "launch_time": {"type": "string", "format": "date-time"},
+          "duration": {"type": "integer", "format": "int64", "description": "Time to live in nanoseconds"},
+          "availability_zone": {"type": "string"},
+          "region": {"type": "string"},
+          "account": {"type": "string"},
+          "vpc_id": {"type": "string"},
+          "subnet_id": {"type": "string"},
+          "key_name": {"type": "string"},
+          "os": {"type": "string"},
+          "username": {"type": "string"},
+          "expires_at": {"type": "string", "format": "date-time"},
+          "ready_at": {"type": "string", "format": "date-time"},
+          "stopped_at": {"type": "string", "format": "date-time"},
+          "terminated_at": {"type": "string", "format": "date-time"},
+          "stop_reason": {"type": "string"},
+          "restart_policy": {"type": "string"},
+          "session": {"type": "string"},
+          "owner": {"type": "string"},
+          "ssh_port": {"type": "integer"},
+          "restart_count": {"type": "integer"},
+          "unhealthy": {"type": "boolean"},
+          "spot_request_id": {"type": "string"},
+          "volume_ids": {"type": "array", "items": {"type": "string"}},
+          "elastic_ip_allocation_id": {"type": "string"},
+          "tags": {"type": "object", "additionalProperties": {"type": "string"}},
+          "gpu_count": {"type": "integer"},
+          "gpu_model": {"type": "string"},
+          "snapshot_on_expiry": {"type": "boolean"},
+          "image_on_expiry": {"type": "boolean"},
+          "expiry_action": {"type": "string", "enum": ["stop", "hibernate", "terminate"]},
+          "keep_when_idle": {"type": "boolean"},
+          "office_hours": {"type": "string"},
+          "stop_cron": {"type": "string"},
+          "start_cron": {"type": "string"},
+          "history": {"type": "array", "items": {"$ref": "#/components/schemas/HistoryEvent"}}
+        }
+      },
+      "InstanceView": {
+        "allOf": [
+          {"$ref": "#/components/schemas/Instance"},
+          {
+            "type": "object",
+            "properties": {
+              "connection_command": {"type": "string", "description": "The rendered connection template, such as an SSH command"},
+              "stops_at": {"type": "string", "format": "date-time", "description": "When the scheduler acts on the instance: its expiry plus the grace period"},
+              "in_grace_period": {"type": "boolean"},
+              "next_cron_stop": {"type": "string", "format": "date-time"},
+              "next_cron_start": {"type": "string", "format": "date-time"}
+            }
+          }
+        ]
+      },
+      "InstanceStatus": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "state": {"type": "string"},
+          "public_ip": {"type": "string"},
+          "private_ip": {"type": "string"},
+          "ssh_port": {"type": "integer"},
+          "username": {"type": "string"},
+          "ready": {"type": "boolean"},
+          "interrupted": {"type": "boolean"},
+          "volume_ids": {"type": "array", "items": {"type": "string"}},
+          "gpu_count": {"type": "integer"},
+          "gpu_model": {"type": "string"}
+        }
+      },
+      "InstanceStatusReport": {
+        "type": "object",
+        "properties": {
+          "instance": {"$ref": "#/components/schemas/Instance"},
+          "status": {"$ref": "#/components/schemas/InstanceStatus"},
+          "is_expired": {"type": "boolean"},
+          "time_remaining": {"type": "number", "description": "Seconds until expiry; negative once expired"}
+        }
+      },
+      "HistoryEvent": {
+        "type": "object",
+        "properties": {
+          "time": {"type": "string", "format": "date-time"},
+          "event": {"type": "string", "example": "extended"},
+          "actor": {"type": "string", "description": "Who caused the event, such as a user or the scheduler"},
+          "detail": {"type": "string"}
+        }
+      },
+      "LiveUpdate": {
+        "type": "object",
+        "properties": {
+          "type": {"type": "string", "enum": ["created", "updated", "deleted"]},
+          "instance_id": {"type": "string"},
+          "instance": {"$ref": "#/components/schemas/InstanceView"}
+        }
+      },
+      "ScheduledAction": {
+        "type": "object",
+        "properties": {
+          "instance_id": {"type": "string"},
+          "action": {"type": "string", "example": "stop"},
+          "at": {"type": "string", "format": "date-time"},
+          "time_until": {"type": "integer", "format": "int64", "description": "Nanoseconds until the action"}
+        }
+      },
+      "SchedulerRun": {
+        "type": "object",
+        "nullable": true,
+        "properties": {
+          "started_at": {"type": "string", "format": "date-time"},
+          "duration": {"type": "integer", "format": "int64", "description": "Nanoseconds the pass took"},
+          "instances": {"type": "integer", "description": "Instances checked"},
+          "actions": {"type": "object", "additionalProperties": {"type": "integer"}, "description": "Count of each action taken, such as stopped"},
+          "dry_run": {"type": "boolean"},
+          "error_count": {"type": "integer"},
+          "errors": {"type": "array", "items": {"type": "string"}}
+        }
+      },
+      "SchedulerPause": {
+        "type": "object",
+        "properties": {
+          "paused_at": {"type": "string", "format": "date-time"},
+          "paused_by": {"type": "string"},
+          "until": {"type": "string", "format": "date-time"},
+          "reason": {"type": "string"}
+        }
+      },
+      "Session": {
+        "type": "object",
+        "properties": {
+          "user": {"type": "string"},
+          "method": {"type": "string", "enum": ["session", "api_key", "header"]},
+          "role": {"type": "string", "enum": ["admin", "operator", "viewer"]},
+          "token": {"type": "string", "description": "The session token, returned on sign-in"},
+          "expires_at": {"type": "string", "format": "date-time"},
+          "manages_all": {"type": "boolean"}
+        }
+      },
+      "CreateInstanceRequest": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string", "description": "Unique among the user's instances"},
+          "instance_type": {"type": "string", "description": "Defaults to the first type offered"},
+          "duration": {"type": "string", "default": "1h"},
+          "public_key_path": {"type": "string", "description": "Path on the server of a public key to import; this or key_name is required"},
+          "key_name": {"type": "string"},
+          "availability_zone": {"type": "string"},
+          "provider": {"type": "string", "description": "Must be the provider the server manages"},
+          "spot": {"type": "boolean"},
+          "spot_max_price": {"type": "string", "description": "Hourly USD; empty caps it at the on-demand price"},
+          "expiry_action": {"type": "string", "enum": ["stop", "hibernate", "terminate"]},
+          "tags": {"type": "object", "additionalProperties": {"type": "string"}}
+        }
+      },
+      "ExtendInstanceRequest": {
+        "type": "object",
+        "required": ["duration"],
+        "properties": {"duration": {"type": "string", "example": "2h"}}
+      },
+      "PauseSchedulerRequest": {
+        "type": "object",
+        "properties": {
+          "reason": {"type": "string"},
+          "duration": {"type": "string", "description": "How long the pause lasts; empty lasts until resumed"}
+        }
+      },
+      "LoginRequest": {
+        "type": "object",
+        "required": ["username", "password"],
+        "properties": {
+          "username": {"type": "string"},
+          "password": {"type": "string", "format": "password"}
+        }
+      }
+    }
+  }
+}`
+}
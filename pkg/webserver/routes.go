@@ -0,0 +1,216 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"instance-manager/pkg/users"
+)
+
+// route is a route of the API: a method and path, the role it requires and
+// its handler. Routes without a role are public.
+type route struct {
+	method  string
+	pattern string // Path, where a {id} segment names an instance
+	role    string
+	handler http.HandlerFunc
+}
+
+// routes returns the routes of the API, version 1
+func (s *Server) routes() []route {
+	return []route{
+		{http.MethodGet, "/api/openapi.json", "", s.handleOpenAPI},
+		{http.MethodGet, "/api/docs", "", s.handleAPIDocs},
+		{http.MethodGet, "/api/v1/health", "", s.handleHealth},
+		{http.MethodPost, "/api/v1/auth/login", "", s.handleLogin},
+		{http.MethodPost, "/api/v1/auth/logout", "", s.handleLogout},
+		{http.MethodGet, "/api/v1/auth/sso/login", "", s.handleSSOLogin},
+		{http.MethodGet, "/api/v1/auth/sso/callback", "", s.handleSSOCallback},
+		{http.MethodGet, "/api/v1/auth/me", users.RoleViewer, s.handleMe},
+		{http.MethodGet, "/api/v1/instances", users.RoleViewer, s.handleInstances},
+		{http.MethodPost, "/api/v1/instances", users.RoleOperator, s.limitChanges(s.handleCreateInstance)},
+		{http.MethodGet, "/api/v1/instances/{id}", users.RoleViewer, s.handleInstanceStatus},
+		{http.MethodDelete, "/api/v1/instances/{id}", users.RoleAdmin, s.limitChanges(s.handleTerminateInstance)},
+		{http.MethodPost, "/api/v1/instances/{id}/extend", users.RoleOperator, s.limitChanges(s.handleExtendInstance)},
+		{http.MethodPost, "/api/v1/instances/{id}/stop", users.RoleOperator, s.limitChanges(s.handleStopInstance)},
+		{http.MethodGet, "/api/v1/instances/{id}/history", users.RoleViewer, s.handleInstanceHistory},
+		{http.MethodGet, "/api/v1/instance-types", users.RoleViewer, s.handleInstanceTypes},
+		{http.MethodGet, "/api/v1/schedule", users.RoleViewer, s.handleSchedule},
+		{http.MethodGet, "/api/v1/scheduler/status", users.RoleViewer, s.handleSchedulerStatus},
+		{http.MethodPost, "/api/v1/scheduler/pause", users.RoleAdmin, s.handlePauseScheduler},
+		{http.MethodPost, "/api/v1/scheduler/resume", users.RoleAdmin, s.handleResumeScheduler},
+		{http.MethodGet, "/api/v1/ws", users.RoleViewer, s.handleLiveUpdates},
+	}
+}
+
+// legacyRoute is a route of the unversioned API, which takes instance IDs
+// in the instance_id query parameter. It is deprecated in favour of its
+// successor, a version 1 route.
+type legacyRoute struct {
+	route
+	successor string
+}
+
+// legacyRoutes returns the routes of the unversioned API, kept for one
+// release after version 1
+func (s *Server) legacyRoutes() []legacyRoute {
+	// moved takes the role and handler of the successor route, which may
+	// take another method
+	moved := func(method, pattern, successorMethod, successor string) legacyRoute {
+		for _, route := range s.routes() {
+			if route.pattern == successor && route.method == successorMethod {
+				route.method, route.pattern = method, pattern
+				return legacyRoute{route: route, successor: successor}
+			}
+		}
+		panic("no version 1 route " + successorMethod + " " + successor)
+	}
+	legacy := func(method, pattern, successor string) legacyRoute {
+		return moved(method, pattern, method, successor)
+	}
+	return []legacyRoute{
+		legacy(http.MethodGet, "/api/health", "/api/v1/health"),
+		legacy(http.MethodPost, "/api/auth/login", "/api/v1/auth/login"),
+		legacy(http.MethodPost, "/api/auth/logout", "/api/v1/auth/logout"),
+		legacy(http.MethodGet, "/api/auth/sso/login", "/api/v1/auth/sso/login"),
+		legacy(http.MethodGet, "/api/auth/sso/callback", "/api/v1/auth/sso/callback"),
+		legacy(http.MethodGet, "/api/auth/me", "/api/v1/auth/me"),
+		legacy(http.MethodGet, "/api/instances", "/api/v1/instances"),
+		legacy(http.MethodPost, "/api/instances/create", "/api/v1/instances"),
+		legacy(http.MethodGet, "/api/instances/status", "/api/v1/instances/{id}"),
+		moved(http.MethodPost, "/api/instances/terminate", http.MethodDelete, "/api/v1/instances/{id}"),
+		legacy(http.MethodPost, "/api/instances/extend", "/api/v1/instances/{id}/extend"),
+		legacy(http.MethodPost, "/api/instances/stop", "/api/v1/instances/{id}/stop"),
+		legacy(http.MethodGet, "/api/instances/history", "/api/v1/instances/{id}/history"),
+		legacy(http.MethodGet, "/api/instance-types", "/api/v1/instance-types"),
+		legacy(http.MethodGet, "/api/schedule", "/api/v1/schedule"),
+		legacy(http.MethodGet, "/api/scheduler/status", "/api/v1/scheduler/status"),
+		legacy(http.MethodPost, "/api/scheduler/pause", "/api/v1/scheduler/pause"),
+		legacy(http.MethodPost, "/api/scheduler/resume", "/api/v1/scheduler/resume"),
+		legacy(http.MethodGet, "/api/ws", "/api/v1/ws"),
+	}
+}
+
+// protect returns the handler of a route, refusing requests whose user
+// lacks its role or is over its rate limit
+func (s *Server) protect(route route) http.HandlerFunc {
+	if route.role == "" {
+		return route.handler
+	}
+	return s.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if role := s.role(r); !users.Allows(role, route.role) {
+			s.jsonResponse(w, http.StatusForbidden, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("The %s role may not do this; it takes the %s role", role, route.role),
+			})
+			return
+		}
+		if !s.allowToken(w, r) {
+			return
+		}
+		route.handler(w, r)
+	})
+}
+
+// deprecate returns the handler of a legacy route, which names its
+// successor in the Deprecation and Link headers of every response
+func deprecate(legacy legacyRoute, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		successor := strings.Replace(legacy.successor, "{id}", requestInstanceID(r), 1)
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		handler(w, r)
+	}
+}
+
+// instanceIDKey is the context key of the instance ID in the path of a
+// request
+type instanceIDKey struct{}
+
+// requestInstanceID returns the instance ID the path of a request names,
+// or else its instance_id query parameter
+func requestInstanceID(r *http.Request) string {
+	if id, ok := r.Context().Value(instanceIDKey{}).(string); ok {
+		return id
+	}
+	return r.URL.Query().Get("instance_id")
+}
+
+// compiledRoute is a route with the handler that enforces its role
+type compiledRoute struct {
+	method   string
+	pattern  string
+	segments []string
+	handler  http.HandlerFunc
+}
+
+// match reports whether the route's path matches the segments of a path,
+// returning the instance ID the path names
+func (c compiledRoute) match(segments []string) (string, bool) {
+	if len(segments) != len(c.segments) {
+		return "", false
+	}
+	id := ""
+	for i, segment := range c.segments {
+		switch {
+		case segment == "{id}" && segments[i] != "":
+			id = segments[i]
+		case segment != segments[i]:
+			return "", false
+		}
+	}
+	return id, true
+}
+
+// apiHandler returns the handler of every route under /api. It answers 404
+// for unknown paths and 405 for methods a path does not take, in the same
+// envelope as the routes, and 429 for clients over their rate limit.
+func (s *Server) apiHandler() http.Handler {
+	var compiled []compiledRoute
+	for _, route := range s.routes() {
+		compiled = append(compiled, compiledRoute{route.method, route.pattern, strings.Split(route.pattern, "/"), s.protect(route)})
+	}
+	for _, legacy := range s.legacyRoutes() {
+		compiled = append(compiled, compiledRoute{legacy.method, legacy.pattern, strings.Split(legacy.pattern, "/"), deprecate(legacy, s.protect(legacy.route))})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowIP(w, r) {
+			return
+		}
+		segments := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+		var allowed []string
+		for _, route := range compiled {
+			id, ok := route.match(segments)
+			if !ok {
+				continue
+			}
+			if route.method == r.Method || (route.method == http.MethodGet && r.Method == http.MethodHead) {
+				if id != "" {
+					r = r.WithContext(context.WithValue(r.Context(), instanceIDKey{}, id))
+				}
+				setRoute(r, route.pattern)
+				route.handler(w, r)
+				return
+			}
+			allowed = append(allowed, route.method)
+		}
+
+		if len(allowed) == 0 {
+			s.jsonResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("No API route at %s; see /api/openapi.json", r.URL.Path),
+			})
+			return
+		}
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		s.jsonResponse(w, http.StatusMethodNotAllowed, APIResponse{
+			Success: false,
+			Error:   "Method not allowed",
+		})
+	})
+}
@@ -1,43 +1,141 @@
 package webserver
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net"
 	"net/http"
+	"net/url"
+	"path"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"instance-manager/internal/scheduler"
 	"instance-manager/internal/utils"
+	"instance-manager/pkg/apikey"
 	"instance-manager/pkg/cloud"
 	"instance-manager/pkg/models"
+	"instance-manager/pkg/ratelimit"
+	"instance-manager/pkg/sso"
 	"instance-manager/pkg/storage"
+	"instance-manager/pkg/tracing"
+	"instance-manager/pkg/users"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
 )
 
 // Server holds the web server state
 type Server struct {
-	provider cloud.CloudProvider
-	storage  *storage.FileStorage
-	logger   *logrus.Logger
-	port     int
+	provider        cloud.CloudProvider
+	providers       cloud.Resolver
+	providerName    string
+	account         string
+	instanceTypes   []string
+	storage         storage.Storage
+	logger          *logrus.Logger
+	port            int
+	allowedFamilies []string
+	connTemplate    string
+	callTimeout     time.Duration
+	retry           cloud.RetryPolicy
+	metadata        models.MetadataOptions
+	gracePeriod     time.Duration
+	expiryAction    string // Expiry action of instances without one
+	maxLifetime     time.Duration
+	userHeader      string            // Header naming the user; empty serves everyone alike
+	trustedProxies  []*net.IPNet      // Peers whose user and forwarded headers are trusted
+	admins          []string          // Users who manage every instance
+	roles           map[string]string // Role of each user given one
+	defaultRole     string            // Role of the other users once users are told apart
+	apiKeys         *apikey.Keyring
+	accounts        *users.File        // Users who sign in; nil turns sign-in off
+	sessions        *users.Sessions    // Sessions of the users who signed in
+	sso             *sso.Provider      // Identity provider users sign in with; nil turns it off
+	ipLimiter       *ratelimit.Limiter // Requests of each client address; nil is unlimited
+	tokenLimiter    *ratelimit.Limiter // Requests of each user
+	changeLimiter   *ratelimit.Limiter // Instances each user creates, extends, stops and terminates
+	clientIPHeader  string             // Header in which a proxy passes the client address
+	metrics         *serverMetrics
+	static          fs.FS // Files of the web UI
+
+	schedulerInterval time.Duration    // How often the scheduler runs; zero leaves it out of readiness
+	healthMu          sync.Mutex       // Guards providerCheck
+	providerCheck     *dependencyCheck // Latest validation of the provider's credentials
+
+	mu         sync.Mutex
+	handler    http.Handler  // Routes of the server, built on first use
+	httpServer *http.Server  // Created on first use by Serve or Shutdown
+	closing    chan struct{} // Closed on Shutdown, ending the live update streams
+	startOnce  sync.Once
+	startErr   error // Returned by every call of Start
 }
 
+// defaultCallTimeout bounds each cloud provider call made while serving a request
+const defaultCallTimeout = time.Minute
+
+// Timeouts of the connections the server accepts. Writes get long enough for
+// an instance to be created with retried provider calls.
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Second
+	writeTimeout      = 5 * time.Minute
+	idleTimeout       = 2 * time.Minute
+)
+
 // APIResponse represents the API response format
 type APIResponse struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Success    bool        `json:"success"`
+	Message    string      `json:"message"`
+	Data       interface{} `json:"data,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Pagination *Pagination `json:"pagination,omitempty"` // Set by lists served a page at a time
+}
+
+// Pagination describes the page of a list a response holds
+type Pagination struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"` // Items in the whole list
+	Pages   int `json:"pages"`
 }
 
 // CreateInstanceRequest represents the request to create an instance
 type CreateInstanceRequest struct {
+	Name             string `json:"name"`
 	InstanceType     string `json:"instance_type"`
 	Duration         string `json:"duration"`
 	PublicKeyPath    string `json:"public_key_path"`
+	KeyName          string `json:"key_name"`
 	AvailabilityZone string `json:"availability_zone"`
 	Provider         string `json:"provider"` // Add provider field
+	Spot             bool   `json:"spot"`
+	SpotMaxPrice     string `json:"spot_max_price"` // Hourly USD; empty caps it at the on-demand price
+	ExpiryAction     string `json:"expiry_action"`  // Empty uses the default expiry action
+
+	Tags map[string]string `json:"tags"` // User-defined tags
+}
+
+// instanceView is an instance as returned by the instances API, with its
+// rendered connection command, when the scheduler stops it and when its stop
+// and start schedules fire next
+type instanceView struct {
+	*models.Instance
+	ConnectionCommand string     `json:"connection_command,omitempty"`
+	StopsAt           time.Time  `json:"stops_at"`
+	InGracePeriod     bool       `json:"in_grace_period,omitempty"`
+	NextCronStop      *time.Time `json:"next_cron_stop,omitempty"`
+	NextCronStart     *time.Time `json:"next_cron_start,omitempty"`
 }
 
 // ExtendInstanceRequest represents the request to extend an instance
@@ -45,33 +143,269 @@ type ExtendInstanceRequest struct {
 	Duration string `json:"duration"`
 }
 
+// PauseSchedulerRequest represents the request to pause the scheduler
+type PauseSchedulerRequest struct {
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"` // How long the pause lasts; empty lasts until resumed
+}
+
 // NewServer creates a new web server instance
-func NewServer(provider cloud.CloudProvider, storage *storage.FileStorage, logger *logrus.Logger, port int) *Server {
-	return &Server{
-		provider: provider,
-		storage:  storage,
-		logger:   logger,
-		port:     port,
+func NewServer(provider cloud.CloudProvider, storage storage.Storage, logger *logrus.Logger, port int) *Server {
+	s := &Server{
+		provider:     provider,
+		providers:    cloud.Static(provider),
+		providerName: "aws",
+		storage:      storage,
+		logger:       logger,
+		port:         port,
+		callTimeout:  defaultCallTimeout,
+		retry:        cloud.DefaultRetryPolicy,
+		closing:      make(chan struct{}),
+		static:       embeddedStatic(),
 	}
+	s.metrics = s.newMetrics()
+	return s
 }
 
-// Start starts the web server
-func (s *Server) Start() error {
-	// Setup routes
-	http.HandleFunc("/api/health", s.handleHealth)
-	http.HandleFunc("/api/instances", s.handleInstances)
-	http.HandleFunc("/api/instances/create", s.handleCreateInstance)
-	http.HandleFunc("/api/instances/status", s.handleInstanceStatus)
-	http.HandleFunc("/api/instances/extend", s.handleExtendInstance)
-	http.HandleFunc("/api/instances/stop", s.handleStopInstance)
-	http.HandleFunc("/api/instances/terminate", s.handleTerminateInstance)
+// SetProvider records the name of the cloud provider the server manages and
+// the instance types offered for it, default first. A nil list offers the
+// supported EC2 instance types.
+func (s *Server) SetProvider(name string, instanceTypes []string) {
+	s.providerName = name
+	s.instanceTypes = instanceTypes
+}
 
-	// Serve static files
-	http.HandleFunc("/", s.handleStaticFiles)
+// SetAccount records the named account the server's provider acts in, so
+// instances it creates are resolved with that account's credentials later
+func (s *Server) SetAccount(account string) {
+	s.account = account
+}
+
+// SetRegistry makes the server manage each stored instance through the
+// provider recorded on it. New instances are still created with the server's
+// provider.
+func (s *Server) SetRegistry(registry *cloud.Registry) {
+	s.providers = registry.ForInstance
+}
+
+// SetCallTimeout limits how long a single cloud provider call may take while
+// serving a request. Zero or less removes the limit; calls are still cancelled
+// when the client goes away.
+func (s *Server) SetCallTimeout(timeout time.Duration) {
+	s.callTimeout = timeout
+}
+
+// SetRetryPolicy sets how provider calls that fail with throttling or other
+// transient errors are retried. Creating instances is never retried.
+func (s *Server) SetRetryPolicy(policy cloud.RetryPolicy) {
+	s.retry = policy
+}
+
+// SetAllowedInstanceFamilies restricts the instance types that can be created to
+// the given prefixes. An empty list allows every instance type.
+func (s *Server) SetAllowedInstanceFamilies(prefixes []string) {
+	s.allowedFamilies = prefixes
+}
+
+// SetMaxLifetime rejects creating or extending instances beyond maxLifetime
+// after launch. Zero removes the limit.
+func (s *Server) SetMaxLifetime(maxLifetime time.Duration) {
+	s.maxLifetime = maxLifetime
+}
+
+// SetConnectionTemplate sets the template used to render each instance's
+// connection command. An empty template uses models.DefaultConnectionTemplate.
+func (s *Server) SetConnectionTemplate(tmpl string) {
+	s.connTemplate = tmpl
+}
+
+// SetMetadataOptions sets the instance metadata service settings of instances
+// created through the web UI
+func (s *Server) SetMetadataOptions(opts models.MetadataOptions) {
+	s.metadata = opts
+}
+
+// SetGracePeriod sets the grace period the scheduler allows after expiry, so
+// the UI shows when expired instances are stopped
+func (s *Server) SetGracePeriod(gracePeriod time.Duration) {
+	s.gracePeriod = gracePeriod
+}
+
+// SetDefaultExpiryAction sets the expiry action given to instances created
+// without one, which the schedule also shows for them
+func (s *Server) SetDefaultExpiryAction(action string) {
+	s.expiryAction = action
+}
+
+// SetUserHeader makes the server act for the user an authenticating reverse
+// proxy names in header, such as X-Forwarded-User. Each user then only sees
+// and manages the instances they created, and API requests without the
+// header are refused. An empty header lets everyone manage every instance.
+func (s *Server) SetUserHeader(header string) {
+	s.userHeader = header
+}
 
+// SetTrustedProxies sets the addresses, as CIDRs or single IPs, of the
+// reverse proxies in front of the server. Only they may name the user in the
+// user header, and only their X-Forwarded-Host header sets the host of the
+// single sign-on callback. Without any, every peer may send the user header
+// and X-Forwarded-Host is ignored.
+func (s *Server) SetTrustedProxies(proxies []string) error {
+	nets, err := parseCIDRs(proxies)
+	if err != nil {
+		return err
+	}
+	s.trustedProxies = nets
+	return nil
+}
+
+// SetAdmins sets the users who see and manage every instance, and who alone
+// may pause and resume the scheduler once a user header is set or users
+// sign in
+func (s *Server) SetAdmins(users []string) {
+	s.admins = users
+}
+
+// SetAPIKeys makes the API require one of the keys of keyring in the
+// Authorization header, once the keyring is enabled. A request made with a
+// key acts for the user named after the key unless the user header names
+// one.
+func (s *Server) SetAPIKeys(keyring *apikey.Keyring) {
+	s.apiKeys = keyring
+}
+
+// SetUserAccounts lets the users of file sign in on the login page, or with
+// the auth API, once the file exists. The API then requires a session of
+// one of them, or an API key, and records the changes made in a session
+// under the user's name.
+func (s *Server) SetUserAccounts(file *users.File, sessions *users.Sessions) {
+	s.accounts = file
+	s.sessions = sessions
+}
+
+// SetSSO lets users sign in through an identity provider, with sessions
+// issued as set by SetUserAccounts. Users the provider's groups make admins
+// see and manage every instance.
+func (s *Server) SetSSO(provider *sso.Provider) {
+	s.sso = provider
+}
+
+// SetRoles sets the users who get each role, and the role of the users
+// given none, once the server tells users apart
+func (s *Server) SetRoles(roles map[string][]string, defaultRole string) {
+	s.roles = make(map[string]string)
+	// The most privileged role wins for users listed under several
+	for i := len(users.Roles) - 1; i >= 0; i-- {
+		for _, user := range roles[users.Roles[i]] {
+			s.roles[user] = users.Roles[i]
+		}
+	}
+	s.defaultRole = defaultRole
+}
+
+// Start serves on the server's port until Shutdown is called. Calling it
+// again does not listen a second time: it waits for the first call to return
+// and returns the same error.
+func (s *Server) Start() error {
+	s.startOnce.Do(func() { s.startErr = s.start() })
+	return s.startErr
+}
+
+func (s *Server) start() error {
 	addr := fmt.Sprintf(":%d", s.port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
 	s.logger.Infof("Starting web server on http://localhost%s", addr)
-	return http.ListenAndServe(addr, nil)
+	return s.Serve(listener)
+}
+
+// Serve serves the web UI and API on listener until Shutdown is called,
+// when it returns nil
+func (s *Server) Serve(listener net.Listener) error {
+	err := s.server().Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting connections and waits for the requests in flight
+// to finish, until ctx is done. The server cannot serve again afterwards.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.log(ctx).Info("Shutting down web server")
+	return s.server().Shutdown(ctx)
+}
+
+// Handler returns the handler serving the web UI and API, with tracing and
+// request logging, for serving elsewhere or testing with httptest. The
+// routes are registered on the server's own mux the first time, so call it
+// once the server is configured.
+func (s *Server) Handler() http.Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buildHandler()
+}
+
+// buildHandler returns the handler of the server, registering the routes the
+// first time. s.mu must be held.
+func (s *Server) buildHandler() http.Handler {
+	if s.handler != nil {
+		return s.handler
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", s.apiHandler())
+	mux.Handle("/metrics", s.protect(route{http.MethodGet, "/metrics", users.RoleViewer, s.handleMetrics}))
+	mux.HandleFunc("/healthz", s.handleLiveness)
+	mux.HandleFunc("/readyz", s.handleReadiness)
+
+	// Serve static files
+	mux.HandleFunc("/", s.handleStaticFiles)
+
+	s.handler = tracing.Middleware(s.logRequests(mux))
+	return s.handler
+}
+
+// server returns the HTTP server, creating it the first time
+func (s *Server) server() *http.Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.httpServer != nil {
+		return s.httpServer
+	}
+
+	s.httpServer = &http.Server{
+		Handler:           s.buildHandler(),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	// Shutdown neither waits for nor closes hijacked WebSocket connections
+	s.httpServer.RegisterOnShutdown(func() { close(s.closing) })
+	return s.httpServer
+}
+
+// callContext returns the context for a single provider call made within
+// ctx, usually the context of the request being served
+func (s *Server) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.callTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.callTimeout)
+}
+
+// call makes an idempotent provider call while serving r, retrying transient
+// failures under the retry policy with each attempt limited by the call
+// timeout
+func (s *Server) call(r *http.Request, op func(ctx context.Context) error) error {
+	return s.retry.Do(r.Context(), func(ctx context.Context) error {
+		ctx, cancel := s.callContext(ctx)
+		defer cancel()
+		return op(ctx)
+	})
 }
 
 // Handlers
@@ -80,99 +414,567 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	s.jsonResponse(w, http.StatusOK, APIResponse{
 		Success: true,
 		Message: "Service is healthy",
+		Data:    map[string]string{"provider": s.providerName},
 	})
 }
 
+// parseInstanceFilter reads an instance filter from the query parameters of
+// the instance list: state (repeatable or comma-separated), provider, region,
+// owner, tag=key=value (repeatable; all must match), expires_after and
+// expires_before as RFC 3339 times, and expires_within as a duration from now
+func parseInstanceFilter(query url.Values, now time.Time) (models.InstanceFilter, error) {
+	filter := models.InstanceFilter{
+		Provider: query.Get("provider"),
+		Region:   query.Get("region"),
+		Owner:    query.Get("owner"),
+	}
+	for _, value := range query["state"] {
+		for _, state := range strings.Split(value, ",") {
+			if state = strings.TrimSpace(state); state != "" {
+				filter.States = append(filter.States, state)
+			}
+		}
+	}
+	tags, err := models.ParseTags(query["tag"])
+	if err != nil {
+		return filter, fmt.Errorf("invalid tag: %w", err)
+	}
+	filter.Tags = tags
+	for name, field := range map[string]*time.Time{
+		"expires_after":  &filter.ExpiresAfter,
+		"expires_before": &filter.ExpiresBefore,
+	} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s %q (expected an RFC 3339 time)", name, value)
+			}
+			*field = t
+		}
+	}
+	if value := query.Get("expires_within"); value != "" {
+		within, err := time.ParseDuration(value)
+		if err != nil || within <= 0 {
+			return filter, fmt.Errorf("invalid expires_within %q (expected a positive duration)", value)
+		}
+		filter.ExpiresAfter = now
+		filter.ExpiresBefore = now.Add(within)
+	}
+	return filter, nil
+}
+
+// Pages of the instance list
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+// instanceSorts are the fields the instance list sorts by, each ordering
+// two instances
+var instanceSorts = map[string]func(a, b *models.Instance) int{
+	"expires_at":  func(a, b *models.Instance) int { return a.ExpiresAt.Compare(b.ExpiresAt) },
+	"launch_time": func(a, b *models.Instance) int { return a.LaunchTime.Compare(b.LaunchTime) },
+	"name":        func(a, b *models.Instance) int { return strings.Compare(a.Name, b.Name) },
+	"state":       func(a, b *models.Instance) int { return strings.Compare(a.State, b.State) },
+	"owner":       func(a, b *models.Instance) int { return strings.Compare(a.Owner, b.Owner) },
+	"provider":    func(a, b *models.Instance) int { return strings.Compare(a.Provider, b.Provider) },
+}
+
+// listOptions are the page and order of the instance list
+type listOptions struct {
+	page, perPage int
+	sort          string // A field of instanceSorts
+	descending    bool
+}
+
+// parseListOptions parses the query parameters paging and ordering the
+// instance list: page from 1, per_page up to maxPerPage, and sort naming a
+// field, prefixed with - for descending order. The latest expiry comes first
+// by default.
+func parseListOptions(query url.Values) (listOptions, error) {
+	options := listOptions{page: 1, perPage: defaultPerPage, sort: "expires_at", descending: true}
+	for name, field := range map[string]*int{"page": &options.page, "per_page": &options.perPage} {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return options, fmt.Errorf("invalid %s %q (expected a positive number)", name, value)
+			}
+			*field = n
+		}
+	}
+	if options.perPage > maxPerPage {
+		return options, fmt.Errorf("invalid per_page %d (at most %d)", options.perPage, maxPerPage)
+	}
+	if value := query.Get("sort"); value != "" {
+		options.sort = strings.TrimPrefix(value, "-")
+		options.descending = strings.HasPrefix(value, "-")
+		if instanceSorts[options.sort] == nil {
+			fields := make([]string, 0, len(instanceSorts))
+			for field := range instanceSorts {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			return options, fmt.Errorf("invalid sort %q (expected one of %s, prefixed with - for descending order)", value, strings.Join(fields, ", "))
+		}
+	}
+	return options, nil
+}
+
+// paginate sorts instances and returns the page of them options ask for,
+// with its description
+func paginate(instances []*models.Instance, options listOptions) ([]*models.Instance, *Pagination) {
+	compare := instanceSorts[options.sort]
+	sort.SliceStable(instances, func(i, j int) bool {
+		c := compare(instances[i], instances[j])
+		if c == 0 {
+			// Keep pages stable between requests
+			c = strings.Compare(instances[i].ID, instances[j].ID)
+		}
+		if options.descending {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	pagination := &Pagination{
+		Page:    options.page,
+		PerPage: options.perPage,
+		Total:   len(instances),
+		Pages:   (len(instances) + options.perPage - 1) / options.perPage,
+	}
+	start := (options.page - 1) * options.perPage
+	if start >= len(instances) {
+		return nil, pagination
+	}
+	end := start + options.perPage
+	if end > len(instances) {
+		end = len(instances)
+	}
+	return instances[start:end], pagination
+}
+
 func (s *Server) handleInstances(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		s.jsonResponse(w, http.StatusMethodNotAllowed, APIResponse{
+	filter, err := parseInstanceFilter(r.URL.Query(), time.Now())
+	if err != nil {
+		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Error:   "Method not allowed",
+			Error:   err.Error(),
+		})
+		return
+	}
+	options, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
 		})
 		return
 	}
 
-	instances, err := s.storage.ListInstances()
+	store := s.storageFor(r)
+	instances, err := store.ListInstancesFiltered(filter)
 	if err != nil {
-		s.logger.WithError(err).Error("Failed to list instances")
+		s.log(r.Context()).WithError(err).Error("Failed to list instances")
 		s.jsonResponse(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   fmt.Sprintf("Failed to get instances: %v", err),
 		})
 		return
 	}
-	sort.Slice(instances, func(i, j int) bool {
-		return instances[i].ExpiresAt.After(instances[j].ExpiresAt)
+	// Only the instances on the page are synced with their providers
+	page, pagination := paginate(instances, options)
+	s.syncInstances(r.Context(), store, page)
+
+	now := time.Now()
+	views := make([]instanceView, 0, len(page))
+	for _, instance := range page {
+		views = append(views, s.instanceView(instance, now))
+	}
+
+	s.log(r.Context()).WithField("count", len(page)).WithField("total", pagination.Total).Debug("Listed instances")
+	s.jsonResponse(w, http.StatusOK, APIResponse{
+		Success:    true,
+		Message:    fmt.Sprintf("Retrieved %d of %d instances", len(page), pagination.Total),
+		Data:       views,
+		Pagination: pagination,
 	})
-	// Sync each instance with latest AWS data
+}
+
+// syncInstances updates instances with the latest state and addresses their
+// providers report, saving those that changed to store
+func (s *Server) syncInstances(ctx context.Context, store storage.Storage, instances []*models.Instance) {
 	for _, instance := range instances {
-		status, err := s.provider.GetInstanceStatus(instance.ID)
+		provider, err := s.providers(instance)
 		if err != nil {
-			s.logger.WithError(err).Debug("Failed to sync instance", map[string]interface{}{"instance_id": instance.ID})
+			s.log(ctx).WithError(err).Debug("Failed to resolve instance provider", map[string]interface{}{"instance_id": instance.ID})
+			continue
+		}
+		status, err := cloud.Retry(ctx, s.retry, func(ctx context.Context) (*models.InstanceStatus, error) {
+			ctx, cancel := s.callContext(ctx)
+			defer cancel()
+			return provider.GetInstanceStatus(ctx, instance.ID)
+		})
+		if err != nil {
+			s.providerError(instance.Provider, "get_status")
+			s.log(ctx).WithError(err).Debug("Failed to sync instance", map[string]interface{}{"instance_id": instance.ID})
 			continue
 		}
 
 		// Update instance with latest data if changed
-		if status.PublicIP != instance.PublicIP || status.PrivateIP != instance.PrivateIP || status.State != instance.State {
+		if status.PublicIP != instance.PublicIP || status.PrivateIP != instance.PrivateIP || status.SSHPort != instance.SSHPort || status.State != instance.State {
 			instance.PublicIP = status.PublicIP
 			instance.PrivateIP = status.PrivateIP
+			instance.SSHPort = status.SSHPort
 			instance.State = status.State
 			instance.Username = status.Username // Also update username if available
+			instance.MarkReady(time.Now())
 
 			// Save updated instance silently
-			if err := s.storage.SaveInstance(instance); err != nil {
-				s.logger.WithError(err).Debug("Failed to save synced instance data")
+			if err := store.SaveInstance(instance); err != nil {
+				s.log(ctx).WithError(err).Debug("Failed to save synced instance data")
 			}
 		}
 	}
+}
+
+// instanceView returns the view of an instance the instances API returns
+func (s *Server) instanceView(instance *models.Instance, now time.Time) instanceView {
+	command, err := instance.RenderConnection(s.connTemplate)
+	if err != nil {
+		s.logger.WithError(err).WithField("instance_id", instance.ID).Warn("Failed to render connection command")
+	}
+	return instanceView{
+		Instance:          instance,
+		ConnectionCommand: command,
+		StopsAt:           instance.StopsAt(s.gracePeriod),
+		InGracePeriod:     instance.InGracePeriod(now, s.gracePeriod),
+		NextCronStop:      nonZeroTime(instance.NextCronStop(now)),
+		NextCronStart:     nonZeroTime(instance.NextCronStart(now)),
+	}
+}
 
-	s.logger.WithField("count", len(instances)).Debug("Listed instances")
+func (s *Server) handleInstanceTypes(w http.ResponseWriter, r *http.Request) {
+	types := utils.AllowedInstanceTypes(s.allowedFamilies)
+	if s.instanceTypes != nil {
+		types = nil
+		for _, instanceType := range s.instanceTypes {
+			if utils.ValidateInstanceFamily(instanceType, s.allowedFamilies) == nil {
+				types = append(types, instanceType)
+			}
+		}
+	}
+	s.jsonResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Retrieved %d instance types", len(types)),
+		Data:    types,
+	})
+}
+
+// validateInstanceType checks the instance type against the types offered
+// for the server's provider
+func (s *Server) validateInstanceType(instanceType string) error {
+	if s.instanceTypes == nil {
+		return utils.ValidateInstanceType(instanceType)
+	}
+	for _, allowed := range s.instanceTypes {
+		if instanceType == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported instance type %q for %s", instanceType, s.providerName)
+}
+
+func (s *Server) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	instances, err := s.storageFor(r).ListInstances()
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to list instances")
+		s.jsonResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Failed to get instances: %v", err),
+		})
+		return
+	}
+
+	actions := scheduler.PreviewSchedule(instances, time.Now(), scheduler.PreviewOptions{
+		GracePeriod:         s.gracePeriod,
+		DefaultExpiryAction: s.expiryAction,
+	})
+	s.jsonResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Retrieved %d upcoming actions", len(actions)),
+		Data:    actions,
+	})
+}
+
+func (s *Server) handleSchedulerStatus(w http.ResponseWriter, r *http.Request) {
+	run, err := s.storage.LastSchedulerRun()
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to read the scheduler status")
+		s.jsonResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Failed to get the scheduler status: %v", err),
+		})
+		return
+	}
+
+	pause, err := s.storage.SchedulerPause()
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to read whether the scheduler is paused")
+		s.jsonResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Failed to get the scheduler status: %v", err),
+		})
+		return
+	}
+
+	message := "The scheduler has not run yet"
+	if run != nil {
+		message = fmt.Sprintf("The scheduler last ran at %s", run.StartedAt.Format(time.RFC3339))
+	}
+	if pause != nil {
+		message = fmt.Sprintf("%s; it is paused since %s", message, pause.PausedAt.Format(time.RFC3339))
+	}
+	s.jsonResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: message,
+		Data:    s.scopedRun(r, run),
+	})
+}
+
+// scopedRun returns the part of a scheduler report the user of a request may
+// see. Users who see every instance get all of it; others only the errors
+// about their own instances, without the counts, which cover every owner.
+func (s *Server) scopedRun(r *http.Request, run *models.SchedulerRun) *models.SchedulerRun {
+	if run == nil || s.seesAll(r) {
+		return run
+	}
+	store := s.storageFor(r)
+	scoped := &models.SchedulerRun{StartedAt: run.StartedAt, Duration: run.Duration, DryRun: run.DryRun}
+	for _, message := range run.Errors {
+		// The scheduler prefixes the errors about an instance with its ID;
+		// the others may concern anyone's
+		rest, ok := strings.CutPrefix(message, "instance ")
+		if !ok {
+			continue
+		}
+		instanceID, _, ok := strings.Cut(rest, ":")
+		if !ok {
+			continue
+		}
+		if _, err := store.GetInstance(instanceID); err == nil {
+			scoped.Errors = append(scoped.Errors, message)
+		}
+	}
+	scoped.ErrorCount = len(scoped.Errors)
+	return scoped
+}
+
+func (s *Server) handlePauseScheduler(w http.ResponseWriter, r *http.Request) {
+	if !s.managesAll(r) {
+		s.jsonResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Error:   "Only admins may pause the scheduler",
+		})
+		return
+	}
+
+	var req PauseSchedulerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+
+	now := time.Now()
+	pause := &models.SchedulerPause{PausedAt: now, PausedBy: s.actor(r), Reason: req.Reason}
+	if req.Duration != "" {
+		duration, err := utils.ParseDuration(req.Duration)
+		if err != nil {
+			s.jsonResponse(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("Invalid duration: %v", err),
+			})
+			return
+		}
+		pause.Until = now.Add(duration)
+	}
+
+	if err := s.storage.PauseScheduler(pause); err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to pause the scheduler")
+		s.jsonResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Failed to pause the scheduler: %v", err),
+		})
+		return
+	}
+
+	s.log(r.Context()).WithFields(logrus.Fields{"reason": req.Reason, "user": pause.PausedBy}).Info("Paused the scheduler")
+	message := "Paused the scheduler until it is resumed"
+	if !pause.Until.IsZero() {
+		message = fmt.Sprintf("Paused the scheduler until %s", pause.Until.Format(time.RFC3339))
+	}
+	s.jsonResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: message,
+		Data:    pause,
+	})
+}
+
+func (s *Server) handleResumeScheduler(w http.ResponseWriter, r *http.Request) {
+	if !s.managesAll(r) {
+		s.jsonResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Error:   "Only admins may resume the scheduler",
+		})
+		return
+	}
+
+	resumed, err := s.storage.ResumeScheduler()
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to resume the scheduler")
+		s.jsonResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Failed to resume the scheduler: %v", err),
+		})
+		return
+	}
+
+	message := "The scheduler was not paused"
+	if resumed {
+		s.log(r.Context()).WithField("user", s.actor(r)).Info("Resumed the scheduler")
+		message = "Resumed the scheduler"
+	}
 	s.jsonResponse(w, http.StatusOK, APIResponse{
 		Success: true,
-		Message: fmt.Sprintf("Retrieved %d instances", len(instances)),
-		Data:    instances,
+		Message: message,
 	})
 }
 
 func (s *Server) handleCreateInstance(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		s.jsonResponse(w, http.StatusMethodNotAllowed, APIResponse{
+	var req CreateInstanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to decode create request")
+		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+
+	// Set defaults
+	if req.Provider == "" {
+		req.Provider = s.providerName
+	}
+	if req.Provider != s.providerName {
+		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("This server manages %s instances, not %s", s.providerName, req.Provider),
+		})
+		return
+	}
+	if req.InstanceType == "" {
+		req.InstanceType = "t2.nano"
+		if len(s.instanceTypes) > 0 {
+			req.InstanceType = s.instanceTypes[0]
+		}
+	}
+	if req.Duration == "" {
+		req.Duration = "1h"
+	}
+	if req.AvailabilityZone == "" && s.instanceTypes == nil {
+		req.AvailabilityZone = "us-east-1a"
+	}
+
+	// Validate instance type against the supported types and allow-list
+	if err := s.validateInstanceType(req.InstanceType); err != nil {
+		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	if err := utils.ValidateInstanceFamily(req.InstanceType, s.allowedFamilies); err != nil {
+		s.log(r.Context()).WithError(err).Warn("Rejected instance type")
+		s.jsonResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// Validate public key path
+	if req.PublicKeyPath == "" && req.KeyName == "" {
+		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "public_key_path or key_name is required",
+		})
+		return
+	}
+
+	if req.Spot && s.providerName != "aws" {
+		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Error:   "Method not allowed",
+			Error:   fmt.Sprintf("Spot instances are not supported by %s", s.providerName),
 		})
 		return
 	}
-
-	var req CreateInstanceRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.logger.WithError(err).Error("Failed to decode create request")
+	if err := utils.ValidateSpotPrice(req.SpotMaxPrice); err != nil {
 		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Error:   fmt.Sprintf("Invalid request: %v", err),
+			Error:   err.Error(),
 		})
 		return
 	}
-
-	// Set defaults
-	if req.InstanceType == "" {
-		req.InstanceType = "t2.nano"
-	}
-	if req.Duration == "" {
-		req.Duration = "1h"
+	if req.ExpiryAction == "" {
+		req.ExpiryAction = s.expiryAction
 	}
-	if req.AvailabilityZone == "" {
-		req.AvailabilityZone = "us-east-1a"
+	if req.ExpiryAction != "" {
+		if err := models.ValidateExpiryAction(req.ExpiryAction); err != nil {
+			s.jsonResponse(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		if req.ExpiryAction == models.ExpiryActionHibernate && s.providerName != "aws" {
+			s.jsonResponse(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("Hibernation is not supported by %s", s.providerName),
+			})
+			return
+		}
 	}
-	// In handleCreateInstance, set provider default if empty
-	if req.Provider == "" {
-		req.Provider = "aws"
+
+	if req.Name != "" {
+		if err := utils.ValidateInstanceName(req.Name); err != nil {
+			s.jsonResponse(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		if existing, err := s.storageFor(r).FindByName(req.Name); err == nil && len(existing) > 0 {
+			s.jsonResponse(w, http.StatusConflict, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("An instance named %s already exists (%s)", req.Name, existing[0].ID),
+			})
+			return
+		}
 	}
 
-	// Validate public key path
-	if req.PublicKeyPath == "" {
+	if len(req.Tags) > 0 && s.providerName != "aws" {
+		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Tags are not supported by %s", s.providerName),
+		})
+		return
+	}
+	if err := models.ValidateTags(req.Tags); err != nil {
 		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Error:   "public_key_path is required",
+			Error:   err.Error(),
 		})
 		return
 	}
@@ -180,32 +982,50 @@ func (s *Server) handleCreateInstance(w http.ResponseWriter, r *http.Request) {
 	// Validate duration
 	duration, err := utils.ParseDuration(req.Duration)
 	if err != nil {
-		s.logger.WithError(err).Warn("Invalid duration")
+		s.log(r.Context()).WithError(err).Warn("Invalid duration")
 		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
 			Success: false,
 			Error:   fmt.Sprintf("Invalid duration: %v", err),
 		})
 		return
 	}
+	if s.maxLifetime > 0 && duration > s.maxLifetime {
+		s.jsonResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Duration %s exceeds the maximum lifetime of %s", utils.FormatDuration(duration), utils.FormatDuration(s.maxLifetime)),
+		})
+		return
+	}
 
 	// Create instance
 	config := models.InstanceConfig{
 		InstanceType:     req.InstanceType,
 		Duration:         duration,
 		PublicKeyPath:    req.PublicKeyPath,
+		KeyName:          req.KeyName,
 		AvailabilityZone: req.AvailabilityZone,
 		Region:           "us-east-1", // or from config
+		Spot:             req.Spot,
+		SpotMaxPrice:     req.SpotMaxPrice,
+		ExpiryAction:     req.ExpiryAction,
+		Tags:             req.Tags,
+		Name:             req.Name,
+		Metadata:         s.metadata,
 	}
 
-	s.logger.WithFields(map[string]interface{}{
+	s.log(r.Context()).WithFields(map[string]interface{}{
 		"type":     req.InstanceType,
 		"duration": duration.String(),
 		"zone":     req.AvailabilityZone,
+		"spot":     req.Spot,
 	}).Info("Creating instance")
 
-	instance, err := s.provider.CreateInstance(config)
+	ctx, cancel := s.callContext(r.Context())
+	defer cancel()
+	instance, err := s.provider.CreateInstance(ctx, config)
 	if err != nil {
-		s.logger.WithError(err).Error("Failed to create instance")
+		s.providerError("", "create")
+		s.log(r.Context()).WithError(err).Error("Failed to create instance")
 		s.jsonResponse(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   fmt.Sprintf("Failed to create instance: %v", err),
@@ -215,8 +1035,15 @@ func (s *Server) handleCreateInstance(w http.ResponseWriter, r *http.Request) {
 
 	// Store instance
 	instance.Provider = req.Provider // Set provider on instance
-	if err := s.storage.SaveInstance(instance); err != nil {
-		s.logger.WithError(err).Error("Failed to save instance")
+	instance.Account = s.account
+	instance.ExpiryAction = req.ExpiryAction
+	if instance.Name == "" {
+		instance.Name = req.Name
+	}
+	instance.Owner = s.actor(r)
+	instance.RecordEvent(time.Now(), models.EventCreated, instance.Owner, "")
+	if err := s.storageFor(r).SaveInstance(instance); err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to save instance")
 		s.jsonResponse(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   fmt.Sprintf("Failed to save instance: %v", err),
@@ -224,7 +1051,7 @@ func (s *Server) handleCreateInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.logger.WithField("instance_id", instance.ID).Info("Instance created successfully")
+	s.log(r.Context()).WithField("instance_id", instance.ID).Info("Instance created successfully")
 	s.jsonResponse(w, http.StatusCreated, APIResponse{
 		Success: true,
 		Message: "Instance created successfully",
@@ -233,15 +1060,7 @@ func (s *Server) handleCreateInstance(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleInstanceStatus(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		s.jsonResponse(w, http.StatusMethodNotAllowed, APIResponse{
-			Success: false,
-			Error:   "Method not allowed",
-		})
-		return
-	}
-
-	instanceID := r.URL.Query().Get("instance_id")
+	instanceID := requestInstanceID(r)
 	if instanceID == "" {
 		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
 			Success: false,
@@ -250,9 +1069,10 @@ func (s *Server) handleInstanceStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	instance, err := s.storage.GetInstance(instanceID)
+	store := s.storageFor(r)
+	instance, err := store.GetInstance(instanceID)
 	if err != nil {
-		s.logger.WithError(err).Warn("Instance not found in storage", map[string]interface{}{"instance_id": instanceID})
+		s.log(r.Context()).WithError(err).Warn("Instance not found in storage", map[string]interface{}{"instance_id": instanceID})
 		s.jsonResponse(w, http.StatusNotFound, APIResponse{
 			Success: false,
 			Error:   fmt.Sprintf("Instance not found: %v", err),
@@ -260,9 +1080,23 @@ func (s *Server) handleInstanceStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	status, err := s.provider.GetInstanceStatus(instanceID)
+	provider, err := s.providers(instance)
+	if err != nil {
+		s.jsonResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Failed to get instance status: %v", err),
+		})
+		return
+	}
+
+	status, err := cloud.Retry(r.Context(), s.retry, func(ctx context.Context) (*models.InstanceStatus, error) {
+		ctx, cancel := s.callContext(ctx)
+		defer cancel()
+		return provider.GetInstanceStatus(ctx, instanceID)
+	})
 	if err != nil {
-		s.logger.WithError(err).Warn("Failed to get status from AWS", map[string]interface{}{"instance_id": instanceID})
+		s.providerError(instance.Provider, "get_status")
+		s.log(r.Context()).WithError(err).Warn("Failed to get status from AWS", map[string]interface{}{"instance_id": instanceID})
 		s.jsonResponse(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   fmt.Sprintf("Failed to get instance status: %v", err),
@@ -275,12 +1109,13 @@ func (s *Server) handleInstanceStatus(w http.ResponseWriter, r *http.Request) {
 		instance.PublicIP = status.PublicIP
 		instance.PrivateIP = status.PrivateIP
 		instance.State = status.State
+		instance.MarkReady(time.Now())
 
 		// Save updated instance
-		if err := s.storage.SaveInstance(instance); err != nil {
-			s.logger.WithError(err).Warn("Failed to sync instance data")
+		if err := store.SaveInstance(instance); err != nil {
+			s.log(r.Context()).WithError(err).Warn("Failed to sync instance data")
 		} else {
-			s.logger.WithField("instance_id", instanceID).Debug("Instance data synced from AWS")
+			s.log(r.Context()).WithField("instance_id", instanceID).Debug("Instance data synced from AWS")
 		}
 	}
 
@@ -299,15 +1134,7 @@ func (s *Server) handleInstanceStatus(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleExtendInstance(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		s.jsonResponse(w, http.StatusMethodNotAllowed, APIResponse{
-			Success: false,
-			Error:   "Method not allowed",
-		})
-		return
-	}
-
-	instanceID := r.URL.Query().Get("instance_id")
+	instanceID := requestInstanceID(r)
 	if instanceID == "" {
 		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
 			Success: false,
@@ -334,7 +1161,8 @@ func (s *Server) handleExtendInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	instance, err := s.storage.GetInstance(instanceID)
+	store := s.storageFor(r)
+	instance, err := store.GetInstance(instanceID)
 	if err != nil {
 		s.jsonResponse(w, http.StatusNotFound, APIResponse{
 			Success: false,
@@ -343,10 +1171,23 @@ func (s *Server) handleExtendInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := utils.ValidateLifetime(instance.LaunchTime, instance.ExpiresAt.Add(duration), s.maxLifetime); err != nil {
+		s.jsonResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Cannot extend by %s: %v", utils.FormatDuration(duration), err),
+		})
+		return
+	}
+
 	// Extend the expiry time
 	instance.ExpiresAt = instance.ExpiresAt.Add(duration)
+	// Allow the scheduler to restart a budget-stopped or unhealthy instance
+	instance.StopReason = ""
+	instance.RestartCount = 0
+	instance.Unhealthy = false
+	instance.RecordEvent(time.Now(), models.EventExtended, s.actor(r), fmt.Sprintf("by %s until %s", utils.FormatDuration(duration), instance.ExpiresAt.Format(time.RFC3339)))
 
-	if err := s.storage.SaveInstance(instance); err != nil {
+	if err := store.SaveInstance(instance); err != nil {
 		s.jsonResponse(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   fmt.Sprintf("Failed to extend instance: %v", err),
@@ -362,24 +1203,27 @@ func (s *Server) handleExtendInstance(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleStopInstance(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		s.jsonResponse(w, http.StatusMethodNotAllowed, APIResponse{
+	instanceID := requestInstanceID(r)
+	if instanceID == "" {
+		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Error:   "Method not allowed",
+			Error:   "instance_id query parameter is required",
 		})
 		return
 	}
 
-	instanceID := r.URL.Query().Get("instance_id")
-	if instanceID == "" {
-		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
+	store := s.storageFor(r)
+	provider, err := s.providerFor(r, store, instanceID)
+	if err != nil {
+		s.jsonResponse(w, providerErrorStatus(err), APIResponse{
 			Success: false,
-			Error:   "instance_id query parameter is required",
+			Error:   fmt.Sprintf("Failed to stop instance: %v", err),
 		})
 		return
 	}
 
-	if err := s.provider.StopInstance(instanceID); err != nil {
+	if err := s.call(r, func(ctx context.Context) error { return provider.StopInstance(ctx, instanceID) }); err != nil {
+		s.providerError(s.storedProvider(store, instanceID), "stop")
 		s.jsonResponse(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   fmt.Sprintf("Failed to stop instance: %v", err),
@@ -387,10 +1231,11 @@ func (s *Server) handleStopInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Update ExpiresAt to now so service will not restart
-	instance, err := s.storage.GetInstance(instanceID)
+	instance, err := store.GetInstance(instanceID)
 	if err == nil {
 		instance.ExpiresAt = time.Now()
-		_ = s.storage.SaveInstance(instance)
+		instance.RecordEvent(instance.ExpiresAt, models.EventStopped, s.actor(r), "")
+		_ = store.SaveInstance(instance)
 	}
 
 	s.jsonResponse(w, http.StatusOK, APIResponse{
@@ -400,14 +1245,40 @@ func (s *Server) handleStopInstance(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleTerminateInstance(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		s.jsonResponse(w, http.StatusMethodNotAllowed, APIResponse{
+	instanceID := requestInstanceID(r)
+	if instanceID == "" {
+		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Error:   "Method not allowed",
+			Error:   "instance_id query parameter is required",
+		})
+		return
+	}
+	store := s.storageFor(r)
+	provider, err := s.providerFor(r, store, instanceID)
+	if err != nil {
+		s.jsonResponse(w, providerErrorStatus(err), APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Failed to terminate instance: %v", err),
+		})
+		return
+	}
+	if err := s.call(r, func(ctx context.Context) error { return provider.TerminateInstance(ctx, instanceID) }); err != nil {
+		s.providerError(s.storedProvider(store, instanceID), "terminate")
+		s.jsonResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Failed to terminate instance: %v", err),
 		})
 		return
 	}
-	instanceID := r.URL.Query().Get("instance_id")
+	_ = storage.ArchiveTerminated(store, instanceID, s.actor(r), time.Now())
+	s.jsonResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Instance terminated successfully",
+	})
+}
+
+func (s *Server) handleInstanceHistory(w http.ResponseWriter, r *http.Request) {
+	instanceID := requestInstanceID(r)
 	if instanceID == "" {
 		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
 			Success: false,
@@ -415,46 +1286,532 @@ func (s *Server) handleTerminateInstance(w http.ResponseWriter, r *http.Request)
 		})
 		return
 	}
-	if err := s.provider.TerminateInstance(instanceID); err != nil {
+
+	instance, err := s.storageFor(r).GetInstance(instanceID)
+	if err != nil {
+		s.jsonResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Instance not found: %v", err),
+		})
+		return
+	}
+
+	history := instance.History
+	if history == nil {
+		history = []models.HistoryEvent{}
+	}
+	s.jsonResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Retrieved %d events", len(history)),
+		Data:    history,
+	})
+}
+
+// LoginRequest represents the request to sign in
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// session is a session as returned by the auth API
+type session struct {
+	User       string     `json:"user"`
+	Method     string     `json:"method,omitempty"` // How the request authenticated: "session", "api_key" or "header"
+	Role       string     `json:"role,omitempty"`   // Role of the user: admin, operator or viewer
+	Token      string     `json:"token,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	ManagesAll bool       `json:"manages_all"`
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if !s.passwordsEnabled() {
+		s.jsonResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "User accounts are not enabled",
+		})
+		return
+	}
+
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+
+	user, err := s.accounts.Authenticate(strings.TrimSpace(req.Username), req.Password)
+	if err != nil {
+		s.log(r.Context()).WithField("user", req.Username).Warn("Refused a sign-in")
+		s.jsonResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Error:   "Invalid user name or password",
+		})
+		return
+	}
+	token, expires, err := s.sessions.Issue(user)
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to issue a session")
 		s.jsonResponse(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Error:   fmt.Sprintf("Failed to terminate instance: %v", err),
+			Error:   "Failed to sign in",
 		})
 		return
 	}
-	_ = s.storage.DeleteInstance(instanceID)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	s.log(r.Context()).WithField("user", user.Name).Info("User signed in")
+	r = r.WithContext(context.WithValue(r.Context(), identityContextKey{}, identity{name: user.Name, method: "session"}))
 	s.jsonResponse(w, http.StatusOK, APIResponse{
 		Success: true,
-		Message: "Instance terminated successfully",
+		Message: fmt.Sprintf("Signed in as %s", user.Name),
+		Data: session{
+			User:       user.Name,
+			Method:     "session",
+			Role:       s.role(r),
+			Token:      token,
+			ExpiresAt:  &expires,
+			ManagesAll: s.managesAll(r),
+		},
+	})
+}
+
+// handleLogout clears the session cookie. Session tokens stay valid until
+// they expire; changing the password of a user or removing them ends their
+// sessions at once.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	s.jsonResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Signed out",
+	})
+}
+
+// handleMe returns who a request acts for
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	id, _ := r.Context().Value(identityContextKey{}).(identity)
+	method := id.method
+	if user := s.user(r); user != id.name {
+		method = "header"
+	}
+	s.jsonResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: session{
+			User:       s.user(r),
+			Method:     method,
+			Role:       s.role(r),
+			ManagesAll: s.managesAll(r),
+		},
+	})
+}
+
+// ssoCookie names the cookie holding the state of a sign-in through the
+// identity provider while the browser is away
+const ssoCookie = "instance_manager_sso"
+
+// ssoFlow is the state of a sign-in through the identity provider
+type ssoFlow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Next     string `json:"next"`
+}
+
+// handleSSOLogin sends the browser to the identity provider to sign in
+func (s *Server) handleSSOLogin(w http.ResponseWriter, r *http.Request) {
+	if s.sso == nil || s.sessions == nil {
+		s.jsonResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "Single sign-on is not enabled",
+		})
+		return
+	}
+
+	flow := ssoFlow{State: randomToken(), Nonce: randomToken(), Verifier: oauth2.GenerateVerifier(), Next: localPath(r.URL.Query().Get("next"))}
+	authURL, err := s.sso.AuthCodeURL(r.Context(), s.ssoRedirectURL(r), flow.State, flow.Nonce, flow.Verifier)
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to start a single sign-on")
+		http.Redirect(w, r, "/login?error="+url.QueryEscape("The identity provider is unavailable"), http.StatusSeeOther)
+		return
+	}
+	data, _ := json.Marshal(flow)
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(data),
+		Path:     path.Dir(r.URL.Path),
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		// Lax, as the provider sends the browser back from another site
+		SameSite: http.SameSiteLaxMode,
 	})
+	http.Redirect(w, r, authURL, http.StatusFound)
 }
 
-func (s *Server) handleStaticFiles(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path == "/" {
-		w.Header().Set("Content-Type", "text/html")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, getIndexHTML())
+// handleSSOCallback signs in the user the identity provider sends back and
+// returns the browser to the page it came from
+func (s *Server) handleSSOCallback(w http.ResponseWriter, r *http.Request) {
+	fail := func(message string) {
+		http.Redirect(w, r, "/login?error="+url.QueryEscape(message), http.StatusSeeOther)
+	}
+	if s.sso == nil || s.sessions == nil {
+		fail("Single sign-on is not enabled")
 		return
 	}
 
-	if r.URL.Path == "/css/style.css" {
-		w.Header().Set("Content-Type", "text/css")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, getStyleCSS())
+	var flow ssoFlow
+	cookie, err := r.Cookie(ssoCookie)
+	if err == nil {
+		var data []byte
+		if data, err = base64.RawURLEncoding.DecodeString(cookie.Value); err == nil {
+			err = json.Unmarshal(data, &flow)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: ssoCookie, Path: path.Dir(r.URL.Path), MaxAge: -1, HttpOnly: true, Secure: secureRequest(r)})
+	query := r.URL.Query()
+	if err != nil || flow.State == "" || query.Get("state") != flow.State {
+		fail("The sign-in expired or did not start here; try again")
+		return
+	}
+	if message := query.Get("error"); message != "" {
+		if description := query.Get("error_description"); description != "" {
+			message = description
+		}
+		fail("The identity provider refused the sign-in: " + message)
 		return
 	}
 
-	if r.URL.Path == "/js/app.js" {
-		w.Header().Set("Content-Type", "application/javascript")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, getAppJS())
+	identity, err := s.sso.Exchange(r.Context(), s.ssoRedirectURL(r), query.Get("code"), flow.Nonce, flow.Verifier)
+	if err != nil {
+		s.log(r.Context()).WithError(err).Warn("Failed to complete a single sign-on")
+		fail("Failed to sign in with " + s.sso.Name())
+		return
+	}
+	role, err := s.sso.Authorize(identity)
+	if err != nil {
+		s.log(r.Context()).WithError(err).Warn("Refused a single sign-on")
+		fail(fmt.Sprintf("%s may not sign in here", identity.Name))
+		return
+	}
+	token, expires, err := s.sessions.IssueSSO(identity.Name, role)
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to issue a session")
+		fail("Failed to sign in")
 		return
 	}
 
-	s.jsonResponse(w, http.StatusNotFound, APIResponse{
-		Success: false,
-		Error:   "Not found",
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteStrictMode,
 	})
+	s.log(r.Context()).WithFields(logrus.Fields{"user": identity.Name, "role": role}).Info("User signed in through the identity provider")
+	http.Redirect(w, r, flow.Next, http.StatusSeeOther)
+}
+
+// ssoRedirectURL returns the callback URL the identity provider sends the
+// browser back to: the configured one, or else that of the server the
+// request reached. X-Forwarded-Host is only honoured from trusted proxies,
+// so clients cannot have sign-ins sent to a host of their choosing.
+func (s *Server) ssoRedirectURL(r *http.Request) string {
+	if configured := s.sso.RedirectURL(); configured != "" {
+		return configured
+	}
+	scheme := "http"
+	if secureRequest(r) {
+		scheme = "https"
+	}
+	host := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" && len(s.trustedProxies) > 0 && s.fromTrustedProxy(r) {
+		host = forwarded
+	}
+	// The callback sits next to the route sign-in started from, so sign-ins
+	// started from the deprecated routes return to them
+	return scheme + "://" + host + path.Dir(r.URL.Path) + "/callback"
+}
+
+// localPath returns next when it is a path on this server, and / otherwise,
+// so sign-ins cannot send the browser elsewhere
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// randomToken returns a random URL-safe token
+func randomToken() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// secureRequest reports whether a request reached the server, or the proxy
+// in front of it, over HTTPS, so cookies set in reply are marked secure
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// actor names who made a request in instance histories: its user, or web
+// when it has none
+func (s *Server) actor(r *http.Request) string {
+	if user := s.user(r); user != "" {
+		return user
+	}
+	return "web"
+}
+
+// user returns the user signed in or the name of the API key the request
+// was made with, or else the user the user header names, or "" when there is
+// none of them. The header is only read from trusted proxies, and never for
+// requests that carry their own identity, so they cannot act for another
+// user.
+func (s *Server) user(r *http.Request) string {
+	if id, _ := r.Context().Value(identityContextKey{}).(identity); id.name != "" {
+		return id.name
+	}
+	if s.userHeader != "" && (len(s.trustedProxies) == 0 || s.fromTrustedProxy(r)) {
+		return strings.TrimSpace(r.Header.Get(s.userHeader))
+	}
+	return ""
+}
+
+// fromTrustedProxy reports whether a request came from one of the trusted
+// proxies
+func (s *Server) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range s.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDRs parses addresses given as CIDRs or single IPs
+func parseCIDRs(addresses []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(addresses))
+	for _, address := range addresses {
+		if !strings.Contains(address, "/") {
+			ip := net.ParseIP(address)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", address)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			address = fmt.Sprintf("%s/%d", address, bits)
+		}
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", address)
+		}
+		nets = append(nets, network)
+	}
+	return nets, nil
+}
+
+// role returns the role of the user of a request: the one the server gives
+// them, or else the one their identity provider's groups grant. Other users
+// get the default role once the server tells users apart, and are admins
+// until then.
+func (s *Server) role(r *http.Request) string {
+	user := s.user(r)
+	if slices.Contains(s.admins, user) {
+		return users.RoleAdmin
+	}
+	if role, ok := s.roles[user]; ok {
+		return role
+	}
+	if id, _ := r.Context().Value(identityContextKey{}).(identity); id.role != "" && id.name == user {
+		return id.role
+	}
+	if !s.scopesUsers() {
+		return users.RoleAdmin
+	}
+	if s.defaultRole == "" {
+		return users.RoleOperator
+	}
+	return s.defaultRole
+}
+
+// managesAll reports whether the user of a request manages every instance:
+// when they are an admin
+func (s *Server) managesAll(r *http.Request) bool {
+	return s.role(r) == users.RoleAdmin
+}
+
+// seesAll reports whether the user of a request sees every instance: when
+// they are an admin or a viewer
+func (s *Server) seesAll(r *http.Request) bool {
+	role := s.role(r)
+	return role == users.RoleAdmin || role == users.RoleViewer
+}
+
+// scopesUsers reports whether users only see and manage their own
+// instances: when a proxy names them or they sign in
+func (s *Server) scopesUsers() bool {
+	return s.userHeader != "" || s.signInEnabled()
+}
+
+// storageFor returns the storage as the user of a request sees it
+func (s *Server) storageFor(r *http.Request) storage.Storage {
+	if s.seesAll(r) {
+		return s.storage
+	}
+	return storage.ForOwner(s.storage, s.user(r))
+}
+
+// identity is who authenticated a request: a user signed in or an API key
+type identity struct {
+	name   string
+	method string // "session" or "api_key"
+	role   string // Role granted by the identity provider
+}
+
+// identityContextKey keys the identity of a request in its context
+type identityContextKey struct{}
+
+// sessionCookie names the cookie holding the session token of a user
+// signed in on the login page
+const sessionCookie = "instance_manager_session"
+
+// signInEnabled reports whether users sign in: with a password once their
+// file exists, or through an identity provider
+func (s *Server) signInEnabled() bool {
+	return s.passwordsEnabled() || (s.sessions != nil && s.sso != nil)
+}
+
+// passwordsEnabled reports whether users sign in with a password
+func (s *Server) passwordsEnabled() bool {
+	return s.accounts != nil && s.sessions != nil && s.accounts.Exists()
+}
+
+// identify returns who authenticated a request with an API key, or with a
+// session token in the Authorization header or the session cookie
+func (s *Server) identify(r *http.Request) (identity, bool) {
+	token := bearerToken(r)
+	if token != "" && s.apiKeys != nil {
+		if name, ok := s.apiKeys.Match(token); ok {
+			return identity{name: name, method: "api_key"}, true
+		}
+	}
+	if !s.signInEnabled() {
+		return identity{}, false
+	}
+	if cookie, err := r.Cookie(sessionCookie); token == "" && err == nil {
+		token = cookie.Value
+	}
+	if token == "" {
+		return identity{}, false
+	}
+	session, err := s.sessions.Verify(token)
+	if err != nil {
+		return identity{}, false
+	}
+	return identity{name: session.User, method: "session", role: session.Role}, true
+}
+
+// authenticate refuses requests without a valid API key or session when the
+// server requires one, and requests that do not name a user when the server
+// has a user header
+func (s *Server) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := s.identify(r)
+		if ok {
+			r = r.WithContext(context.WithValue(r.Context(), identityContextKey{}, id))
+		}
+		keysEnabled := s.apiKeys != nil && s.apiKeys.Enabled()
+		if !ok && (keysEnabled || s.signInEnabled()) {
+			challenge, message := `Bearer realm="instance-manager"`, "Missing or invalid API key in the Authorization header"
+			if s.signInEnabled() {
+				// The login parameter sends the browser to the login page
+				challenge += `, login="/login"`
+				message = "Sign in, or pass a session token or API key in the Authorization header"
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+			s.jsonResponse(w, http.StatusUnauthorized, APIResponse{
+				Success: false,
+				Error:   message,
+			})
+			return
+		}
+		if s.userHeader != "" && s.user(r) == "" {
+			s.jsonResponse(w, http.StatusUnauthorized, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("Missing the %s header naming the user", s.userHeader),
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// bearerToken returns the token of an "Authorization: Bearer <token>" header,
+// or "" when there is none
+func bearerToken(r *http.Request) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// errNotOwned is returned by providerFor for instances the user of a request
+// may not manage
+var errNotOwned = errors.New("instance not found")
+
+// providerFor returns the provider managing a stored instance, read from
+// store as the user of r sees it. Instances missing from storage are assumed
+// to belong to the server's provider when the user manages every instance,
+// and are otherwise refused with errNotOwned.
+func (s *Server) providerFor(r *http.Request, store storage.Storage, instanceID string) (cloud.CloudProvider, error) {
+	instance, err := store.GetInstance(instanceID)
+	if err != nil {
+		if !s.managesAll(r) {
+			return nil, errNotOwned
+		}
+		return s.provider, nil
+	}
+	return s.providers(instance)
+}
+
+// providerErrorStatus returns the status of a response to a failure of
+// providerFor
+func providerErrorStatus(err error) int {
+	if errors.Is(err, errNotOwned) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
 }
 
 // Helper methods
@@ -468,3 +1825,12 @@ func (s *Server) jsonResponse(w http.ResponseWriter, status int, data interface{
 }
 
 // Content functions remain the same
+
+// nonZeroTime returns a pointer to t, or nil for the zero time so it is
+// omitted from JSON
+func nonZeroTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
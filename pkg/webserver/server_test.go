@@ -0,0 +1,1519 @@
+package webserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"instance-manager/internal/scheduler"
+	"instance-manager/pkg/apikey"
+	"instance-manager/pkg/cloud"
+	"instance-manager/pkg/logging"
+	"instance-manager/pkg/models"
+	"instance-manager/pkg/ratelimit"
+	"instance-manager/pkg/sso"
+	"instance-manager/pkg/storage"
+	"instance-manager/pkg/users"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/net/websocket"
+)
+
+func newTestServer(t *testing.T) *Server {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := storage.NewMemoryStorage()
+	return NewServer(nil, store, logger, 0)
+}
+
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder) APIResponse {
+	var resp APIResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp
+}
+
+func TestHandleInstanceTypes_AllowList(t *testing.T) {
+	server := newTestServer(t)
+	server.SetAllowedInstanceFamilies([]string{"t3."})
+
+	rec := httptest.NewRecorder()
+	server.handleInstanceTypes(rec, httptest.NewRequest(http.MethodGet, "/api/instance-types", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	resp := decodeResponse(t, rec)
+	types, ok := resp.Data.([]interface{})
+	if !ok {
+		t.Fatalf("Expected list of types, got %T", resp.Data)
+	}
+	if len(types) != 7 {
+		t.Errorf("Expected 7 t3 types, got %d: %v", len(types), types)
+	}
+	for _, instanceType := range types {
+		if s, _ := instanceType.(string); len(s) < 3 || s[:3] != "t3." {
+			t.Errorf("Unexpected instance type %v in t3 allow-list", instanceType)
+		}
+	}
+}
+
+func TestHandleCreateInstance_DisallowedFamily(t *testing.T) {
+	server := newTestServer(t)
+	server.SetAllowedInstanceFamilies([]string{"t2.", "t3."})
+
+	body, _ := json.Marshal(CreateInstanceRequest{
+		InstanceType:  "m5.large",
+		Duration:      "1h",
+		PublicKeyPath: "/tmp/key.pub",
+	})
+	rec := httptest.NewRecorder()
+	server.handleCreateInstance(rec, httptest.NewRequest(http.MethodPost, "/api/instances/create", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %d", rec.Code)
+	}
+	if resp := decodeResponse(t, rec); resp.Success {
+		t.Error("Expected unsuccessful response for disallowed family")
+	}
+}
+
+func TestHandleSchedule(t *testing.T) {
+	server := newTestServer(t)
+	for _, instance := range []*models.Instance{
+		{ID: "i-late", State: "running", ExpiresAt: time.Now().Add(2 * time.Hour)},
+		{ID: "i-early", State: "running", ExpiresAt: time.Now().Add(1 * time.Hour)},
+	} {
+		if err := server.storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleSchedule(rec, httptest.NewRequest(http.MethodGet, "/api/schedule", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var resp struct {
+		Data []scheduler.ScheduledAction `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Data) != 2 {
+		t.Fatalf("Expected 2 actions, got %d", len(resp.Data))
+	}
+	if resp.Data[0].InstanceID != "i-early" || resp.Data[1].InstanceID != "i-late" {
+		t.Errorf("Expected actions ordered by time, got %s, %s", resp.Data[0].InstanceID, resp.Data[1].InstanceID)
+	}
+}
+
+func TestHandleSchedulerStatus(t *testing.T) {
+	server := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	server.handleSchedulerStatus(rec, httptest.NewRequest(http.MethodGet, "/api/scheduler/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if resp := decodeResponse(t, rec); resp.Data != nil {
+		t.Errorf("Expected no run before the scheduler ran, got %v", resp.Data)
+	}
+
+	run := &models.SchedulerRun{
+		StartedAt:  time.Now(),
+		Duration:   time.Second,
+		Instances:  3,
+		Actions:    map[string]int{"stopped": 2},
+		ErrorCount: 1,
+		Errors:     []string{"instance i-123: Failed to stop expired instance"},
+	}
+	if err := server.storage.RecordSchedulerRun(run); err != nil {
+		t.Fatalf("Failed to record run: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	server.handleSchedulerStatus(rec, httptest.NewRequest(http.MethodGet, "/api/scheduler/status", nil))
+	var resp struct {
+		Data *models.SchedulerRun `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Data == nil || resp.Data.Instances != 3 || resp.Data.Actions["stopped"] != 2 || resp.Data.ErrorCount != 1 {
+		t.Errorf("Unexpected scheduler status: %+v", resp.Data)
+	}
+
+	// Users who only see their own instances only get the errors about them
+	server.SetUserHeader("X-Forwarded-User")
+	for id, owner := range map[string]string{"i-123": "bob", "i-456": "alice"} {
+		if err := server.storage.SaveInstance(&models.Instance{ID: id, Owner: owner, State: "running"}); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+	run.Errors = []string{"instance i-123: Failed to stop expired instance", "instance i-456: Failed to get status", "Failed to list instances"}
+	run.ErrorCount = 3
+	if err := server.storage.RecordSchedulerRun(run); err != nil {
+		t.Fatalf("Failed to record run: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/scheduler/status", nil)
+	req.Header.Set("X-Forwarded-User", "alice")
+	rec = httptest.NewRecorder()
+	server.handleSchedulerStatus(rec, req)
+	resp.Data = nil
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Data == nil || resp.Data.ErrorCount != 1 || len(resp.Data.Errors) != 1 || resp.Data.Errors[0] != "instance i-456: Failed to get status" ||
+		resp.Data.Instances != 0 || len(resp.Data.Actions) != 0 {
+		t.Errorf("Expected alice to only see the error about i-456, got %+v", resp.Data)
+	}
+}
+
+func TestHandlePauseAndResumeScheduler(t *testing.T) {
+	server := newTestServer(t)
+
+	body := strings.NewReader(`{"reason": "incident", "duration": "2h"}`)
+	rec := httptest.NewRecorder()
+	server.handlePauseScheduler(rec, httptest.NewRequest(http.MethodPost, "/api/scheduler/pause", body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	pause, err := server.storage.SchedulerPause()
+	if err != nil || pause == nil {
+		t.Fatalf("Expected the scheduler to be paused, got %+v, %v", pause, err)
+	}
+	if pause.Reason != "incident" || pause.Until.Sub(pause.PausedAt) != 2*time.Hour {
+		t.Errorf("Unexpected pause: %+v", pause)
+	}
+
+	rec = httptest.NewRecorder()
+	server.handleSchedulerStatus(rec, httptest.NewRequest(http.MethodGet, "/api/scheduler/status", nil))
+	if resp := decodeResponse(t, rec); !strings.Contains(resp.Message, "paused since") {
+		t.Errorf("Expected the status to mention the pause, got %q", resp.Message)
+	}
+
+	rec = httptest.NewRecorder()
+	server.handlePauseScheduler(rec, httptest.NewRequest(http.MethodPost, "/api/scheduler/pause", strings.NewReader(`{"duration": "soon"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid duration, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	server.handleResumeScheduler(rec, httptest.NewRequest(http.MethodPost, "/api/scheduler/resume", nil))
+	if resp := decodeResponse(t, rec); resp.Message != "Resumed the scheduler" {
+		t.Errorf("Unexpected resume message: %q", resp.Message)
+	}
+	if pause, _ := server.storage.SchedulerPause(); pause != nil {
+		t.Errorf("Expected the scheduler to be resumed, got %+v", pause)
+	}
+}
+
+func TestHandleSchedule_GracePeriod(t *testing.T) {
+	server := newTestServer(t)
+	server.SetGracePeriod(5 * time.Minute)
+	expiresAt := time.Now().Add(time.Hour)
+	if err := server.storage.SaveInstance(&models.Instance{ID: "i-grace", State: "running", ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleSchedule(rec, httptest.NewRequest(http.MethodGet, "/api/schedule", nil))
+
+	var resp struct {
+		Data []scheduler.ScheduledAction `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Data) != 1 || resp.Data[0].Action != scheduler.ActionStop {
+		t.Fatalf("Expected one stop action, got %+v", resp.Data)
+	}
+	if !resp.Data[0].At.Equal(expiresAt.Add(5 * time.Minute)) {
+		t.Errorf("Expected the stop at the end of the grace period, got %s", resp.Data[0].At)
+	}
+}
+
+func TestHandleInstances_CronSchedules(t *testing.T) {
+	server := newTestServer(t)
+	server.providers = cloud.Static(&recordingProvider{})
+	if err := server.storage.SaveInstance(&models.Instance{ID: "i-cron", State: "running", StopCron: "0 20 * * *"}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleInstances(rec, httptest.NewRequest(http.MethodGet, "/api/instances", nil))
+
+	var resp struct {
+		Data []instanceView `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Data) != 1 {
+		t.Fatalf("Expected 1 instance, got %d", len(resp.Data))
+	}
+	next := resp.Data[0].NextCronStop
+	if next == nil || next.UTC().Hour() != 20 || next.Minute() != 0 || !next.After(time.Now()) {
+		t.Errorf("Expected the next stop at 20:00 UTC, got %v", next)
+	}
+	if resp.Data[0].NextCronStart != nil {
+		t.Errorf("Expected no scheduled start, got %v", resp.Data[0].NextCronStart)
+	}
+}
+
+func TestHandleInstances_Filter(t *testing.T) {
+	server := newTestServer(t)
+	server.providers = cloud.Static(&recordingProvider{})
+	for _, instance := range []*models.Instance{
+		{ID: "i-qa", State: "running", Owner: "alice", Tags: map[string]string{"team": "qa"}, ExpiresAt: time.Now().Add(time.Hour)},
+		{ID: "i-ops", State: "running", Owner: "alice", Tags: map[string]string{"team": "ops"}, ExpiresAt: time.Now().Add(time.Hour)},
+		{ID: "i-bob", State: "running", Owner: "bob", Tags: map[string]string{"team": "qa"}, ExpiresAt: time.Now().Add(time.Hour)},
+		{ID: "i-gone", State: "terminated", Owner: "alice", Tags: map[string]string{"team": "qa"}, ExpiresAt: time.Now().Add(time.Hour)},
+	} {
+		if err := server.storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleInstances(rec, httptest.NewRequest(http.MethodGet, "/api/instances?state=running,stopped&owner=alice&tag=team=qa&expires_within=2h", nil))
+
+	var resp struct {
+		Data []instanceView `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Data) != 1 || resp.Data[0].ID != "i-qa" {
+		t.Errorf("Expected only i-qa to match, got %+v", resp.Data)
+	}
+
+	rec = httptest.NewRecorder()
+	server.handleInstances(rec, httptest.NewRequest(http.MethodGet, "/api/instances?expires_before=tomorrow", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid time, got %d", rec.Code)
+	}
+}
+
+func TestHandleInstances_Pages(t *testing.T) {
+	server := newTestServer(t)
+	provider := &recordingProvider{}
+	server.providers = cloud.Static(provider)
+	now := time.Now()
+	for i := 1; i <= 5; i++ {
+		instance := &models.Instance{ID: fmt.Sprintf("i-%d", i), Name: fmt.Sprintf("box-%d", 6-i), State: "running", ExpiresAt: now.Add(time.Duration(i) * time.Hour)}
+		if err := server.storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+	list := func(query string) (ids []string, pagination *Pagination) {
+		rec := httptest.NewRecorder()
+		server.handleInstances(rec, httptest.NewRequest(http.MethodGet, "/api/v1/instances?"+query, nil))
+		var resp struct {
+			Data       []instanceView `json:"data"`
+			Pagination *Pagination    `json:"pagination"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("Expected the instances for %q, got %d: %s", query, rec.Code, rec.Body.String())
+		}
+		for _, view := range resp.Data {
+			ids = append(ids, view.ID)
+		}
+		return ids, resp.Pagination
+	}
+
+	// The latest expiry comes first by default
+	ids, pagination := list("per_page=2")
+	if strings.Join(ids, ",") != "i-5,i-4" || *pagination != (Pagination{Page: 1, PerPage: 2, Total: 5, Pages: 3}) {
+		t.Errorf("Unexpected first page %v, %+v", ids, pagination)
+	}
+	if ids, _ := list("per_page=2&page=3&sort=expires_at"); strings.Join(ids, ",") != "i-5" {
+		t.Errorf("Expected i-5 alone on the last page by soonest expiry, got %v", ids)
+	}
+	if ids, _ := list("sort=-name&per_page=1"); strings.Join(ids, ",") != "i-1" {
+		t.Errorf("Expected box-5 first by name descending, got %v", ids)
+	}
+	if ids, pagination := list("page=9"); len(ids) != 0 || pagination.Total != 5 {
+		t.Errorf("Expected no instances past the last page, got %v, %+v", ids, pagination)
+	}
+
+	// Only the page is synced with the providers
+	provider.statusCalls = 0
+	list("per_page=2")
+	if provider.statusCalls != 2 {
+		t.Errorf("Expected the 2 instances on the page to be synced, got %d", provider.statusCalls)
+	}
+
+	for _, query := range []string{"page=0", "per_page=many", "per_page=501", "sort=size"} {
+		rec := httptest.NewRecorder()
+		server.handleInstances(rec, httptest.NewRequest(http.MethodGet, "/api/v1/instances?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestHandleCreateInstance_ProviderMismatch(t *testing.T) {
+	server := newTestServer(t)
+	server.SetProvider("digitalocean", []string{"s-1vcpu-1gb", "s-2vcpu-2gb"})
+
+	body, _ := json.Marshal(CreateInstanceRequest{
+		Duration:      "1h",
+		PublicKeyPath: "/tmp/key.pub",
+		Provider:      "aws",
+	})
+	rec := httptest.NewRecorder()
+	server.handleCreateInstance(rec, httptest.NewRequest(http.MethodPost, "/api/instances/create", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", rec.Code)
+	}
+
+	body, _ = json.Marshal(CreateInstanceRequest{
+		InstanceType:  "t2.nano",
+		Duration:      "1h",
+		PublicKeyPath: "/tmp/key.pub",
+	})
+	rec = httptest.NewRecorder()
+	server.handleCreateInstance(rec, httptest.NewRequest(http.MethodPost, "/api/instances/create", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for an EC2 type on DigitalOcean, got %d", rec.Code)
+	}
+}
+
+func TestHandleCreateInstance_Spot(t *testing.T) {
+	server := newTestServer(t)
+	server.SetProvider("digitalocean", []string{"s-1vcpu-1gb"})
+
+	body, _ := json.Marshal(CreateInstanceRequest{
+		Duration:      "1h",
+		PublicKeyPath: "/tmp/key.pub",
+		Spot:          true,
+	})
+	rec := httptest.NewRecorder()
+	server.handleCreateInstance(rec, httptest.NewRequest(http.MethodPost, "/api/instances/create", bytes.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for spot on DigitalOcean, got %d", rec.Code)
+	}
+
+	server = newTestServer(t)
+	body, _ = json.Marshal(CreateInstanceRequest{
+		InstanceType:  "t2.nano",
+		Duration:      "1h",
+		PublicKeyPath: "/tmp/key.pub",
+		Spot:          true,
+		SpotMaxPrice:  "cheap",
+	})
+	rec = httptest.NewRecorder()
+	server.handleCreateInstance(rec, httptest.NewRequest(http.MethodPost, "/api/instances/create", bytes.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for an invalid spot price, got %d", rec.Code)
+	}
+}
+
+func TestHandleCreateInstance_Tags(t *testing.T) {
+	server := newTestServer(t)
+	body, _ := json.Marshal(CreateInstanceRequest{
+		InstanceType:  "t2.nano",
+		Duration:      "1h",
+		PublicKeyPath: "/tmp/key.pub",
+		Tags:          map[string]string{"aws:owner": "me"},
+	})
+	rec := httptest.NewRecorder()
+	server.handleCreateInstance(rec, httptest.NewRequest(http.MethodPost, "/api/instances/create", bytes.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for a reserved tag key, got %d", rec.Code)
+	}
+
+	server = newTestServer(t)
+	server.SetProvider("digitalocean", []string{"s-1vcpu-1gb"})
+	body, _ = json.Marshal(CreateInstanceRequest{
+		Duration:      "1h",
+		PublicKeyPath: "/tmp/key.pub",
+		Tags:          map[string]string{"team": "data"},
+	})
+	rec = httptest.NewRecorder()
+	server.handleCreateInstance(rec, httptest.NewRequest(http.MethodPost, "/api/instances/create", bytes.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for tags on DigitalOcean, got %d", rec.Code)
+	}
+}
+
+func TestHandleCreateInstance_DuplicateName(t *testing.T) {
+	server := newTestServer(t)
+	if err := server.storage.SaveInstance(&models.Instance{ID: "i-web", Name: "web"}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	body, _ := json.Marshal(CreateInstanceRequest{
+		Name:          "web",
+		InstanceType:  "t2.nano",
+		Duration:      "1h",
+		PublicKeyPath: "/tmp/key.pub",
+	})
+	rec := httptest.NewRecorder()
+	server.handleCreateInstance(rec, httptest.NewRequest(http.MethodPost, "/api/instances/create", bytes.NewReader(body)))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("Expected status 409 for a name already in use, got %d", rec.Code)
+	}
+}
+
+func TestHandleExtendInstance_MaxLifetime(t *testing.T) {
+	server := newTestServer(t)
+	server.SetMaxLifetime(72 * time.Hour)
+	launch := time.Now().Add(-70 * time.Hour)
+	if err := server.storage.SaveInstance(&models.Instance{ID: "i-old", LaunchTime: launch, ExpiresAt: launch.Add(71 * time.Hour)}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	extend := func(duration string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(ExtendInstanceRequest{Duration: duration})
+		rec := httptest.NewRecorder()
+		server.handleExtendInstance(rec, httptest.NewRequest(http.MethodPost, "/api/instances/extend?instance_id=i-old", bytes.NewReader(body)))
+		return rec
+	}
+
+	if rec := extend("2h"); rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403 for an extension beyond the maximum lifetime, got %d", rec.Code)
+	}
+	if rec := extend("1h"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for an extension up to the maximum lifetime, got %d", rec.Code)
+	}
+	instance, err := server.storage.GetInstance("i-old")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if !instance.ExpiresAt.Equal(launch.Add(72 * time.Hour)) {
+		t.Errorf("Expected the instance to expire at the end of its maximum lifetime, got %s", instance.ExpiresAt)
+	}
+}
+
+func TestHandleInstanceTypes_Provider(t *testing.T) {
+	server := newTestServer(t)
+	server.SetProvider("digitalocean", []string{"s-1vcpu-1gb", "s-2vcpu-2gb", "c-2"})
+	server.SetAllowedInstanceFamilies([]string{"s-"})
+
+	rec := httptest.NewRecorder()
+	server.handleInstanceTypes(rec, httptest.NewRequest(http.MethodGet, "/api/instance-types", nil))
+
+	types, _ := decodeResponse(t, rec).Data.([]interface{})
+	if len(types) != 2 || types[0] != "s-1vcpu-1gb" || types[1] != "s-2vcpu-2gb" {
+		t.Errorf("Expected the allowed droplet sizes, got %v", types)
+	}
+}
+
+// recordingProvider records the instances it is asked to terminate and the
+// deadline of the last termination call
+type recordingProvider struct {
+	terminated    []string
+	deadline      time.Time
+	statusCalls   int
+	terminateErr  error // Returned by TerminateInstance when set
+	credentialErr error // Returned by ValidateCredentials when set
+}
+
+func (p *recordingProvider) CreateInstance(ctx context.Context, config models.InstanceConfig) (*models.Instance, error) {
+	return nil, nil
+}
+
+func (p *recordingProvider) GetInstanceStatus(ctx context.Context, instanceID string) (*models.InstanceStatus, error) {
+	p.statusCalls++
+	return &models.InstanceStatus{ID: instanceID, State: "running"}, nil
+}
+
+func (p *recordingProvider) StartInstance(ctx context.Context, instanceID string) error { return nil }
+func (p *recordingProvider) StopInstance(ctx context.Context, instanceID string) error  { return nil }
+func (p *recordingProvider) ValidateCredentials(ctx context.Context) error {
+	return p.credentialErr
+}
+
+func (p *recordingProvider) TerminateInstance(ctx context.Context, instanceID string) error {
+	if p.terminateErr != nil {
+		return p.terminateErr
+	}
+	p.terminated = append(p.terminated, instanceID)
+	p.deadline, _ = ctx.Deadline()
+	return nil
+}
+
+func (p *recordingProvider) ListInstances(ctx context.Context) ([]*models.Instance, error) {
+	return nil, nil
+}
+
+func TestHandleTerminateInstance_UsesInstanceProvider(t *testing.T) {
+	awsProvider := &recordingProvider{}
+	dockerProvider := &recordingProvider{}
+	registry := cloud.NewRegistry("aws")
+	registry.Register("aws", func() (cloud.CloudProvider, error) { return awsProvider, nil })
+	registry.Register("docker", func() (cloud.CloudProvider, error) { return dockerProvider, nil })
+
+	server := newTestServer(t)
+	server.provider = awsProvider
+	server.SetRegistry(registry)
+	if err := server.storage.SaveInstance(&models.Instance{ID: "c-docker", Provider: "docker", State: "running"}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleTerminateInstance(rec, httptest.NewRequest(http.MethodPost, "/api/instances/terminate?instance_id=c-docker", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(dockerProvider.terminated) != 1 || dockerProvider.terminated[0] != "c-docker" {
+		t.Errorf("Expected Docker to terminate c-docker, got %v", dockerProvider.terminated)
+	}
+	if len(awsProvider.terminated) != 0 {
+		t.Errorf("Expected AWS not to be called, got %v", awsProvider.terminated)
+	}
+	archived, err := server.storage.ListArchived()
+	if err != nil || len(archived) != 1 || archived[0].Instance.ID != "c-docker" || archived[0].Reason != models.ArchiveReasonTerminated {
+		t.Errorf("Expected the record of c-docker to be archived, got %v, %v", archived, err)
+	}
+}
+
+func TestHandleTerminateInstance_CallTimeout(t *testing.T) {
+	provider := &recordingProvider{}
+	server := newTestServer(t)
+	server.provider = provider
+	server.providers = cloud.Static(provider)
+	server.SetCallTimeout(5 * time.Second)
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	server.handleTerminateInstance(rec, httptest.NewRequest(http.MethodPost, "/api/instances/terminate?instance_id=i-1", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if provider.deadline.IsZero() {
+		t.Fatal("Expected the provider call to have a deadline")
+	}
+	if remaining := provider.deadline.Sub(start); remaining > 5*time.Second+time.Second || remaining <= 0 {
+		t.Errorf("Expected a deadline about 5s after the request, got %v", remaining)
+	}
+}
+
+func TestHandleInstanceHistory(t *testing.T) {
+	server := newTestServer(t)
+	if err := server.storage.SaveInstance(&models.Instance{ID: "i-1", ExpiresAt: time.Now().Add(time.Hour), LaunchTime: time.Now()}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleExtendInstance(rec, httptest.NewRequest(http.MethodPost, "/api/instances/extend?instance_id=i-1", strings.NewReader(`{"duration": "1h"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the extension to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	server.handleInstanceHistory(rec, httptest.NewRequest(http.MethodGet, "/api/instances/history?instance_id=i-1", nil))
+	var resp struct {
+		Data []models.HistoryEvent `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Data) != 1 || resp.Data[0].Event != models.EventExtended || resp.Data[0].Actor != "web" {
+		t.Errorf("Expected the extension to be recorded, got %+v", resp.Data)
+	}
+
+	rec = httptest.NewRecorder()
+	server.handleInstanceHistory(rec, httptest.NewRequest(http.MethodGet, "/api/instances/history?instance_id=i-missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing instance, got %d", rec.Code)
+	}
+}
+
+func TestUserHeader_ScopesInstances(t *testing.T) {
+	provider := &recordingProvider{}
+	server := newTestServer(t)
+	server.provider = provider
+	server.providers = cloud.Static(provider)
+	server.SetUserHeader("X-Forwarded-User")
+	server.SetAdmins([]string{"ops"})
+	for _, instance := range []*models.Instance{
+		{ID: "i-alice", State: "running", Owner: "alice", ExpiresAt: time.Now().Add(time.Hour)},
+		{ID: "i-bob", State: "running", Owner: "bob", ExpiresAt: time.Now().Add(time.Hour)},
+	} {
+		if err := server.storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+	request := func(handler http.HandlerFunc, method, target, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if user != "" {
+			req.Header.Set("X-Forwarded-User", user)
+		}
+		rec := httptest.NewRecorder()
+		server.authenticate(handler)(rec, req)
+		return rec
+	}
+	listed := func(user string) []string {
+		var resp struct {
+			Data []instanceView `json:"data"`
+		}
+		rec := request(server.handleInstances, http.MethodGet, "/api/instances", user)
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		var ids []string
+		for _, view := range resp.Data {
+			ids = append(ids, view.ID)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	if rec := request(server.handleInstances, http.MethodGet, "/api/instances", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a user, got %d", rec.Code)
+	}
+	if ids := listed("alice"); len(ids) != 1 || ids[0] != "i-alice" {
+		t.Errorf("Expected alice to see only i-alice, got %v", ids)
+	}
+	if ids := listed("ops"); len(ids) != 2 {
+		t.Errorf("Expected the admin to see every instance, got %v", ids)
+	}
+
+	if rec := request(server.handleTerminateInstance, http.MethodPost, "/api/instances/terminate?instance_id=i-bob", "alice"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 when alice terminates i-bob, got %d", rec.Code)
+	}
+	if rec := request(server.handleTerminateInstance, http.MethodPost, "/api/instances/terminate?instance_id=i-unknown", "alice"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 when alice terminates an unknown instance, got %d", rec.Code)
+	}
+	if len(provider.terminated) != 0 {
+		t.Errorf("Expected no termination, got %v", provider.terminated)
+	}
+	if rec := request(server.handleTerminateInstance, http.MethodPost, "/api/instances/terminate?instance_id=i-bob", "ops"); rec.Code != http.StatusOK {
+		t.Errorf("Expected the admin to terminate i-bob, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := request(server.handlePauseScheduler, http.MethodPost, "/api/scheduler/pause", "alice"); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 when alice pauses the scheduler, got %d", rec.Code)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	server := newTestServer(t)
+	server.SetAPIKeys(apikey.NewKeyring(map[string]string{"ci": "ci-secret"}, nil))
+	if err := server.storage.SaveInstance(&models.Instance{ID: "i-1", ExpiresAt: time.Now().Add(time.Hour), LaunchTime: time.Now()}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	request := func(handler http.HandlerFunc, method, target, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(`{"duration": "1h"}`))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		server.authenticate(handler)(rec, req)
+		return rec
+	}
+
+	for _, authorization := range []string{"", "Bearer wrong", "ci-secret", "Basic ci-secret"} {
+		rec := request(server.handleInstanceHistory, http.MethodGet, "/api/instances/history?instance_id=i-1", authorization)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for %q, got %d", authorization, rec.Code)
+		}
+		if rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Expected a WWW-Authenticate header for %q", authorization)
+		}
+	}
+	if rec := request(server.handleInstanceHistory, http.MethodGet, "/api/instances/history?instance_id=i-1", "Bearer ci-secret"); rec.Code != http.StatusOK {
+		t.Errorf("Expected the key to be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Changes made with a key are recorded under its name
+	if rec := request(server.handleExtendInstance, http.MethodPost, "/api/instances/extend?instance_id=i-1", "bearer ci-secret"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the extension to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	history, err := server.storage.GetInstance("i-1")
+	if err != nil || len(history.History) != 1 || history.History[0].Actor != "ci" {
+		t.Errorf("Expected the extension to be recorded for ci, got %+v, %v", history, err)
+	}
+
+	// A key holder cannot act for another user, an admin least of all, by
+	// sending the user header
+	server.SetUserHeader("X-Forwarded-User")
+	server.SetAdmins([]string{"ops"})
+	req := httptest.NewRequest(http.MethodGet, "/api/instances", nil)
+	req.Header.Set("Authorization", "Bearer ci-secret")
+	req.Header.Set("X-Forwarded-User", "ops")
+	var user, role string
+	server.authenticate(func(w http.ResponseWriter, r *http.Request) { user, role = server.user(r), server.role(r) })(httptest.NewRecorder(), req)
+	if user != "ci" || role == users.RoleAdmin {
+		t.Errorf("Expected the request to act for ci without admin rights, got %q as %s", user, role)
+	}
+}
+
+func TestUserHeader_TrustedProxies(t *testing.T) {
+	server := newTestServer(t)
+	server.SetUserHeader("X-Forwarded-User")
+	if err := server.SetTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"}); err != nil {
+		t.Fatalf("SetTrustedProxies failed: %v", err)
+	}
+	for remote, want := range map[string]string{"10.1.2.3:5555": "alice", "192.0.2.1:5555": "alice", "192.0.2.2:5555": ""} {
+		req := httptest.NewRequest(http.MethodGet, "/api/instances", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-User", "alice")
+		if user := server.user(req); user != want {
+			t.Errorf("Expected %q from %s, got %q", want, remote, user)
+		}
+	}
+	if err := server.SetTrustedProxies([]string{"not-an-address"}); err == nil {
+		t.Error("Expected an invalid address to be refused")
+	}
+}
+
+func TestUserAccounts(t *testing.T) {
+	server := newTestServer(t)
+	dir := t.TempDir()
+	accounts := users.NewFile(filepath.Join(dir, "users.json"))
+	server.SetUserAccounts(accounts, users.NewSessions(accounts, []byte("a test secret of at least 32 bytes"), time.Hour))
+
+	login := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.handleLogin(rec, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body)))
+		return rec
+	}
+	// Sign-in stays off until the user file exists
+	if rec := login(`{"username": "alice", "password": "correct horse"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without user accounts, got %d", rec.Code)
+	}
+	if err := accounts.Add("alice", "correct horse"); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	for id, owner := range map[string]string{"i-1": "alice", "i-2": "bob"} {
+		if err := server.storage.SaveInstance(&models.Instance{ID: id, Owner: owner, ExpiresAt: time.Now().Add(time.Hour), LaunchTime: time.Now()}); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+
+	if rec := login(`{"username": "alice", "password": "wrong password"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong password, got %d", rec.Code)
+	}
+	rec := login(`{"username": "alice", "password": "correct horse"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected alice to sign in, got %d: %s", rec.Code, rec.Body.String())
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("Expected an HttpOnly session cookie, got %+v", cookies)
+	}
+	var signedIn struct {
+		Data session `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &signedIn); err != nil || signedIn.Data.Token == "" {
+		t.Fatalf("Expected a session token, got %s", rec.Body.String())
+	}
+
+	request := func(handler http.HandlerFunc, method, target string, authorize func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(`{"duration": "1h"}`))
+		if authorize != nil {
+			authorize(req)
+		}
+		rec := httptest.NewRecorder()
+		server.authenticate(handler)(rec, req)
+		return rec
+	}
+	rec = request(server.handleInstanceHistory, http.MethodGet, "/api/instances/history?instance_id=i-1", nil)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Header().Get("WWW-Authenticate"), `login="/login"`) {
+		t.Errorf("Expected 401 pointing to the login page, got %d, %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+	if rec := request(server.handleInstanceHistory, http.MethodGet, "/api/instances/history?instance_id=i-1", func(r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: "forged"})
+	}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a forged session to be refused, got %d", rec.Code)
+	}
+
+	// Signed-in users only manage their own instances
+	if rec := request(server.handleInstanceHistory, http.MethodGet, "/api/instances/history?instance_id=i-2", func(r *http.Request) {
+		r.AddCookie(cookies[0])
+	}); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the instance of bob to be hidden from alice, got %d", rec.Code)
+	}
+
+	// Changes made in a session are recorded under the user's name, whether
+	// the token comes in the cookie or the Authorization header
+	if rec := request(server.handleExtendInstance, http.MethodPost, "/api/instances/extend?instance_id=i-1", func(r *http.Request) {
+		r.AddCookie(cookies[0])
+	}); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the extension to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	server.SetAdmins([]string{"alice"})
+	if rec := request(server.handlePauseScheduler, http.MethodPost, "/api/scheduler/pause", func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+signedIn.Data.Token)
+	}); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the pause to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	instance, err := server.storage.GetInstance("i-1")
+	if err != nil || len(instance.History) != 1 || instance.History[0].Actor != "alice" {
+		t.Errorf("Expected the extension to be recorded for alice, got %+v, %v", instance, err)
+	}
+	if pause, err := server.storage.SchedulerPause(); err != nil || pause == nil || pause.PausedBy != "alice" {
+		t.Errorf("Expected the pause to be recorded for alice, got %+v, %v", pause, err)
+	}
+
+	// Removing the user ends their sessions
+	if err := accounts.Remove("alice"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if rec := request(server.handleInstanceHistory, http.MethodGet, "/api/instances/history?instance_id=i-1", func(r *http.Request) {
+		r.AddCookie(cookies[0])
+	}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the session of a removed user to be refused, got %d", rec.Code)
+	}
+}
+
+func TestSingleSignOn(t *testing.T) {
+	// A GitHub Enterprise server, where octocat is in the acme/platform team
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "gho_test", "token_type": "bearer"}`))
+	})
+	mux.HandleFunc("/api/v3/user", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{"login": "octocat"}`)) })
+	mux.HandleFunc("/api/v3/user/emails", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`[]`)) })
+	mux.HandleFunc("/api/v3/user/orgs", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`[{"login": "acme"}]`)) })
+	mux.HandleFunc("/api/v3/user/teams", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"slug": "platform", "organization": {"login": "acme"}}]`))
+	})
+	github := httptest.NewServer(mux)
+	defer github.Close()
+
+	server := newTestServer(t)
+	accounts := users.NewFile(filepath.Join(t.TempDir(), "users.json"))
+	server.SetUserAccounts(accounts, users.NewSessions(accounts, []byte("a test secret of at least 32 bytes"), time.Hour))
+	provider, err := sso.New(sso.Config{Kind: sso.KindGitHub, Issuer: github.URL, ClientID: "client", ClientSecret: "secret",
+		Roles: map[string][]string{"admin": {"acme/platform"}}})
+	if err != nil {
+		t.Fatalf("Failed to create the provider: %v", err)
+	}
+	server.SetSSO(provider)
+
+	rec := httptest.NewRecorder()
+	server.handleSSOLogin(rec, httptest.NewRequest(http.MethodGet, "http://im.example.com/api/auth/sso/login?next=/%3Fextend%3Di-1", nil))
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if rec.Code != http.StatusFound || !strings.HasPrefix(location.String(), github.URL+"/login/oauth/authorize") ||
+		location.Query().Get("redirect_uri") != "http://im.example.com/api/auth/sso/callback" {
+		t.Fatalf("Expected a redirect to GitHub, got %d to %s", rec.Code, location)
+	}
+	flowCookies := rec.Result().Cookies()
+	state := location.Query().Get("state")
+
+	// Clients cannot move the callback to another host, only trusted proxies
+	redirectURI := func(remote string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://im.example.com/api/auth/sso/login", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-Host", "evil.example.com")
+		rec := httptest.NewRecorder()
+		server.handleSSOLogin(rec, req)
+		location, _ := url.Parse(rec.Header().Get("Location"))
+		return location.Query().Get("redirect_uri")
+	}
+	if got := redirectURI("203.0.113.9:4444"); got != "http://im.example.com/api/auth/sso/callback" {
+		t.Errorf("Expected X-Forwarded-Host to be ignored without trusted proxies, got %s", got)
+	}
+	if err := server.SetTrustedProxies([]string{"10.0.0.0/8"}); err != nil {
+		t.Fatalf("SetTrustedProxies failed: %v", err)
+	}
+	if got := redirectURI("203.0.113.9:4444"); got != "http://im.example.com/api/auth/sso/callback" {
+		t.Errorf("Expected X-Forwarded-Host from an untrusted client to be ignored, got %s", got)
+	}
+	if got := redirectURI("10.0.0.5:4444"); got != "http://evil.example.com/api/auth/sso/callback" {
+		t.Errorf("Expected X-Forwarded-Host from a trusted proxy to be honoured, got %s", got)
+	}
+
+	callback := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://im.example.com/api/auth/sso/callback?"+query, nil)
+		for _, cookie := range flowCookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		server.handleSSOCallback(rec, req)
+		return rec
+	}
+	if rec := callback("code=abc&state=forged"); !strings.HasPrefix(rec.Header().Get("Location"), "/login?error=") {
+		t.Errorf("Expected a forged state to be refused, got %d to %s", rec.Code, rec.Header().Get("Location"))
+	}
+	rec = callback("code=abc&state=" + state)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/?extend=i-1" {
+		t.Fatalf("Expected a redirect back to the page, got %d to %s", rec.Code, rec.Header().Get("Location"))
+	}
+	var sessionCookies []*http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == sessionCookie {
+			sessionCookies = append(sessionCookies, cookie)
+		}
+	}
+	if len(sessionCookies) != 1 {
+		t.Fatalf("Expected a session cookie, got %+v", rec.Result().Cookies())
+	}
+
+	// The team makes octocat an admin
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/me", nil)
+	req.AddCookie(sessionCookies[0])
+	rec = httptest.NewRecorder()
+	server.authenticate(server.handleMe)(rec, req)
+	var me struct {
+		Data session `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &me); err != nil || me.Data.User != "octocat" || me.Data.Role != users.RoleAdmin || !me.Data.ManagesAll {
+		t.Errorf("Expected octocat to be signed in as an admin, got %s", rec.Body.String())
+	}
+}
+
+func TestRoles(t *testing.T) {
+	provider := &recordingProvider{}
+	server := newTestServer(t)
+	server.provider = provider
+	server.providers = cloud.Static(provider)
+	server.SetUserHeader("X-Forwarded-User")
+	server.SetRoles(map[string][]string{users.RoleAdmin: {"ops"}, users.RoleViewer: {"auditor", "ops"}}, users.RoleOperator)
+	for _, instance := range []*models.Instance{
+		{ID: "i-alice", State: "running", Owner: "alice", ExpiresAt: time.Now().Add(time.Hour), LaunchTime: time.Now()},
+		{ID: "i-bob", State: "running", Owner: "bob", ExpiresAt: time.Now().Add(time.Hour), LaunchTime: time.Now()},
+	} {
+		if err := server.storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+	api := server.apiHandler()
+	request := func(method, target, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(`{"duration": "1h"}`))
+		req.Header.Set("X-Forwarded-User", user)
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, check := range []struct {
+		method, target, user string
+		status               int
+	}{
+		{http.MethodGet, "/api/v1/instances/i-bob/history", "auditor", http.StatusOK},
+		{http.MethodPost, "/api/v1/instances/i-bob/extend", "auditor", http.StatusForbidden},
+		{http.MethodPost, "/api/v1/scheduler/pause", "auditor", http.StatusForbidden},
+		{http.MethodGet, "/api/v1/instances/i-bob/history", "alice", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/instances/i-alice/extend", "alice", http.StatusOK},
+		{http.MethodDelete, "/api/v1/instances/i-alice", "alice", http.StatusForbidden},
+		{http.MethodPost, "/api/instances/terminate?instance_id=i-alice", "alice", http.StatusForbidden},
+		{http.MethodDelete, "/api/v1/instances/i-bob", "ops", http.StatusOK},
+	} {
+		if rec := request(check.method, check.target, check.user); rec.Code != check.status {
+			t.Errorf("Expected %d for %s %s by %s, got %d: %s", check.status, check.method, check.target, check.user, rec.Code, rec.Body.String())
+		}
+	}
+	if len(provider.terminated) != 1 || provider.terminated[0] != "i-bob" {
+		t.Errorf("Expected only i-bob to be terminated, got %v", provider.terminated)
+	}
+
+	// The UI learns the role from the auth API; ops is listed twice and gets
+	// the most privileged role
+	for user, want := range map[string]string{"auditor": users.RoleViewer, "alice": users.RoleOperator, "ops": users.RoleAdmin} {
+		var me struct {
+			Data session `json:"data"`
+		}
+		rec := request(http.MethodGet, "/api/v1/auth/me", user)
+		if err := json.Unmarshal(rec.Body.Bytes(), &me); err != nil || me.Data.Role != want {
+			t.Errorf("Expected %s to be %s, got %s", user, want, rec.Body.String())
+		}
+	}
+}
+
+// blockingStorage holds GetInstance until release is closed
+type blockingStorage struct {
+	storage.Storage
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingStorage) GetInstance(id string) (*models.Instance, error) {
+	close(b.started)
+	<-b.release
+	return b.Storage.GetInstance(id)
+}
+
+func TestShutdown(t *testing.T) {
+	server := newTestServer(t)
+	if err := server.storage.SaveInstance(&models.Instance{ID: "i-1", State: "running"}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	blocking := &blockingStorage{Storage: server.storage, started: make(chan struct{}), release: make(chan struct{})}
+	server.storage = blocking
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+	base := "http://" + listener.Addr().String()
+
+	// A request in flight when the server shuts down still gets its answer
+	answered := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(base + "/api/instances/history?instance_id=i-1")
+		if err != nil {
+			t.Errorf("Request in flight failed: %v", err)
+			answered <- 0
+			return
+		}
+		resp.Body.Close()
+		answered <- resp.StatusCode
+	}()
+	<-blocking.started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned with a request in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(blocking.release)
+	if status := <-answered; status != http.StatusOK {
+		t.Errorf("Expected the request in flight to succeed, got %d", status)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Expected Serve to return nil after Shutdown, got %v", err)
+	}
+	if _, err := http.Get(base + "/api/health"); err == nil {
+		t.Error("Expected the server to refuse connections after Shutdown")
+	}
+}
+
+func TestLiveUpdates(t *testing.T) {
+	server := newTestServer(t)
+	server.provider = &recordingProvider{}
+	server.providers = cloud.Static(server.provider)
+	httpServer := httptest.NewServer(http.HandlerFunc(server.handleLiveUpdates))
+	defer httpServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	if _, err := websocket.Dial(wsURL, "", "https://evil.example.com"); err == nil {
+		t.Error("Expected a handshake from another site to be refused")
+	}
+	conn, err := websocket.Dial(wsURL, "", httpServer.URL)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	receive := func() liveUpdate {
+		t.Helper()
+		var update liveUpdate
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := websocket.JSON.Receive(conn, &update); err != nil {
+			t.Fatalf("Failed to receive an update: %v", err)
+		}
+		return update
+	}
+
+	instance := &models.Instance{ID: "i-1", State: "pending", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := server.storage.SaveInstance(instance); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	update := receive()
+	if update.Type != storage.ChangeCreated || update.InstanceID != "i-1" || update.Instance == nil || update.Instance.StopsAt.IsZero() {
+		t.Errorf("Expected the created instance with its view, got %+v", update)
+	}
+
+	// Instances still starting are synced with their provider, which
+	// reports this one running
+	server.syncSettling(context.Background(), server.storage)
+	if update := receive(); update.Type != storage.ChangeUpdated || update.Instance.State != "running" {
+		t.Errorf("Expected the instance to be updated to running, got %+v", update)
+	}
+
+	if err := server.storage.DeleteInstance("i-1"); err != nil {
+		t.Fatalf("Failed to delete instance: %v", err)
+	}
+	if update := receive(); update.Type != storage.ChangeDeleted || update.Instance != nil {
+		t.Errorf("Expected the instance to be deleted, got %+v", update)
+	}
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	server := newTestServer(t)
+	rec := httptest.NewRecorder()
+	server.handleOpenAPI(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	var spec struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("The spec is not valid JSON: %v", err)
+	}
+
+	// Every route is described, and nothing else
+	described := make(map[string]bool)
+	for path, item := range spec.Paths {
+		for method := range item {
+			if method != "parameters" {
+				described[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+	for _, route := range server.routes() {
+		operation := route.method + " " + route.pattern
+		if !described[operation] {
+			t.Errorf("The spec does not describe %s", operation)
+		}
+		delete(described, operation)
+	}
+	for operation := range described {
+		t.Errorf("The spec describes %s, which is not a route", operation)
+	}
+
+	// Every reference resolves
+	var document map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &document)
+	for _, ref := range regexp.MustCompile(`"\$ref": "#/([^"]+)"`).FindAllStringSubmatch(rec.Body.String(), -1) {
+		var node interface{} = document
+		for _, key := range strings.Split(ref[1], "/") {
+			object, _ := node.(map[string]interface{})
+			node = object[key]
+		}
+		if node == nil {
+			t.Errorf("Unresolved reference #/%s", ref[1])
+		}
+	}
+}
+
+func TestAPIRoutes(t *testing.T) {
+	server := newTestServer(t)
+	if err := server.storage.SaveInstance(&models.Instance{ID: "i-1", State: "running", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Failed to save instance: %v", err)
+	}
+	api := server.apiHandler()
+	request := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	if rec := request(http.MethodGet, "/api/v1/instances/i-1/history"); rec.Code != http.StatusOK || rec.Header().Get("Deprecation") != "" {
+		t.Errorf("Expected the history of i-1, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Legacy routes still work, naming their successor
+	rec := request(http.MethodGet, "/api/instances/history?instance_id=i-1")
+	if rec.Code != http.StatusOK || rec.Header().Get("Deprecation") != "true" {
+		t.Errorf("Expected the deprecated history of i-1, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if link := rec.Header().Get("Link"); link != `</api/v1/instances/i-1/history>; rel="successor-version"` {
+		t.Errorf("Unexpected Link header %q", link)
+	}
+
+	if rec := request(http.MethodPut, "/api/v1/instances/i-1"); rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "DELETE, GET" {
+		t.Errorf("Expected 405 allowing DELETE and GET, got %d with %q", rec.Code, rec.Header().Get("Allow"))
+	}
+	if rec := request(http.MethodGet, "/api/v1/unknown"); rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "No API route") {
+		t.Errorf("Expected 404 for an unknown route, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	server := newTestServer(t)
+	server.SetUserHeader("X-Forwarded-User")
+	server.SetRateLimits(RateLimits{
+		PerIP:          ratelimit.Limit{PerMinute: 60, Burst: 4},
+		PerToken:       ratelimit.Limit{PerMinute: 60, Burst: 3},
+		Changes:        ratelimit.Limit{PerMinute: 1, Burst: 1},
+		ClientIPHeader: "X-Forwarded-For",
+	})
+	api := server.apiHandler()
+	request := func(method, target, user, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(`{}`))
+		req.Header.Set("X-Forwarded-User", user)
+		req.Header.Set("X-Forwarded-For", "198.51.100.1, "+ip)
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Changes, extensions included, run out first
+	if rec := request(http.MethodPost, "/api/v1/instances/i-1/extend", "alice", "10.0.0.1"); rec.Code == http.StatusTooManyRequests {
+		t.Fatalf("Expected the first extension to be allowed, got %s", rec.Body.String())
+	}
+	rec := request(http.MethodPost, "/api/v1/instances/i-1/stop", "alice", "10.0.0.1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected a second change to wait a minute, got %d with Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := request(http.MethodPost, "/api/v1/instances/i-1/stop", "bob", "10.0.0.1"); rec.Code == http.StatusTooManyRequests {
+		t.Errorf("Expected bob to have their own changes, got %s", rec.Body.String())
+	}
+
+	// alice spent 2 of their 3 requests; the address, shared with bob, spent
+	// 3 of 4
+	if rec := request(http.MethodGet, "/api/v1/instances", "alice", "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Errorf("Expected alice's third request to be allowed, got %d", rec.Code)
+	}
+	if rec := request(http.MethodGet, "/api/v1/instances", "alice", "10.0.0.2"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected alice to be over their limit from any address, got %d", rec.Code)
+	}
+	if rec := request(http.MethodGet, "/api/v1/health", "", "10.0.0.1"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the address to be over its limit, got %d", rec.Code)
+	}
+	if rec := request(http.MethodGet, "/api/v1/health", "", "10.0.0.3"); rec.Code != http.StatusOK {
+		t.Errorf("Expected another address to be allowed, got %d", rec.Code)
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	server := newTestServer(t)
+	logs := test.NewLocal(server.logger)
+	handler := server.logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.log(r.Context()).Warn("Failed to reach the provider")
+		logging.FromContext(r.Context()).Warn("Provider call failed, retrying")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/instances", nil))
+	id := rec.Header().Get("X-Request-ID")
+	if len(id) != 16 {
+		t.Fatalf("Expected a generated request ID, got %q", id)
+	}
+	entries := logs.AllEntries()
+	if len(entries) != 3 || entries[0].Data["request_id"] != id || entries[1].Data["request_id"] != id {
+		t.Fatalf("Expected the handler's and provider's entries to carry the request ID, got %+v", entries)
+	}
+	if request := entries[2]; request.Data["request_id"] != id || request.Data["method"] != http.MethodPost || request.Data["path"] != "/api/v1/instances" ||
+		request.Data["status"] != http.StatusBadGateway || request.Level != logrus.ErrorLevel {
+		t.Errorf("Unexpected request entry %+v", request.Data)
+	}
+
+	// An ID a proxy assigned is kept, unless it is not a plain token
+	for sent, kept := range map[string]bool{"edge-4f2a.77": true, "bad id\nforged entry": false} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/health", nil)
+		req.Header.Set("X-Request-ID", sent)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got := rec.Header().Get("X-Request-ID"); (got == sent) != kept || got == "" {
+			t.Errorf("Unexpected request ID %q for %q", got, sent)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	server := newTestServer(t)
+	provider := &recordingProvider{terminateErr: errors.New("access denied")}
+	server.provider = provider
+	server.providers = cloud.Static(provider)
+	for _, instance := range []*models.Instance{
+		{ID: "i-1", State: "running", ExpiresAt: time.Now().Add(time.Hour)},
+		{ID: "i-2", State: "running", Provider: "docker", ExpiresAt: time.Now().Add(time.Hour)},
+		{ID: "i-3", State: "stopped", ExpiresAt: time.Now().Add(time.Hour)},
+	} {
+		if err := server.storage.SaveInstance(instance); err != nil {
+			t.Fatalf("Failed to save instance: %v", err)
+		}
+	}
+	handler := server.logRequests(server.apiHandler())
+	for _, request := range []struct{ method, target string }{
+		{http.MethodGet, "/api/v1/instances"},
+		{http.MethodGet, "/api/v1/instances/i-1/history"},
+		{http.MethodGet, "/api/v1/instances/i-2/history"},
+		{http.MethodDelete, "/api/v1/instances/i-3"},
+		{http.MethodGet, "/api/v1/nothing"},
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(request.method, request.target, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`instance_manager_http_requests_total{method="GET",route="/api/v1/instances",status="200"} 1`,
+		`instance_manager_http_requests_total{method="GET",route="/api/v1/instances/{id}/history",status="200"} 2`,
+		`instance_manager_http_requests_total{method="DELETE",route="/api/v1/instances/{id}",status="500"} 1`,
+		`instance_manager_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`instance_manager_http_request_duration_seconds_count{method="GET",route="/api/v1/instances/{id}/history"} 2`,
+		// Listing synced i-3 with the provider, which reports it running
+		`instance_manager_instances{state="running",provider="aws"} 2`,
+		`instance_manager_instances{state="running",provider="docker"} 1`,
+		`instance_manager_provider_errors_total{provider="aws",operation="terminate"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected the metrics to hold %s, got:\n%s", line, body)
+		}
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+}
+
+func TestHealthProbes(t *testing.T) {
+	server := newTestServer(t)
+	provider := &recordingProvider{}
+	server.provider = provider
+	server.SetSchedulerInterval(30 * time.Second)
+
+	rec := httptest.NewRecorder()
+	server.handleLiveness(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected /healthz to answer 200, got %d", rec.Code)
+	}
+
+	probe := func() (int, readiness) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		server.handleReadiness(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var body struct {
+			Data readiness `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to decode readiness: %v", err)
+		}
+		return rec.Code, body.Data
+	}
+
+	// A scheduler that never ran is reported but leaves the server ready
+	code, ready := probe()
+	if code != http.StatusOK || ready.Status != "ready" {
+		t.Fatalf("Expected ready, got %d %+v", code, ready)
+	}
+	for name, want := range map[string]string{"storage": checkOK, "provider": checkOK, "scheduler": checkUnknown} {
+		if check := ready.Checks[name]; check == nil || check.Status != want {
+			t.Errorf("Expected %s check %s, got %+v", name, want, check)
+		}
+	}
+
+	if err := server.storage.RecordSchedulerRun(&models.SchedulerRun{StartedAt: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatalf("Failed to record scheduler run: %v", err)
+	}
+	_, ready = probe()
+	if check := ready.Checks["scheduler"]; check.Status != checkFailing || check.Critical {
+		t.Errorf("Expected a stale scheduler to fail without being critical, got %+v", check)
+	}
+
+	// Credentials are validated once per providerCheckTTL
+	provider.credentialErr = errors.New("expired token")
+	if code, _ := probe(); code != http.StatusOK {
+		t.Errorf("Expected the cached credential check to hold, got %d", code)
+	}
+	server.providerCheck.CheckedAt = time.Now().Add(-providerCheckTTL)
+	code, ready = probe()
+	if code != http.StatusServiceUnavailable || ready.Status != "not ready" {
+		t.Fatalf("Expected not ready with invalid credentials, got %d %+v", code, ready)
+	}
+	if check := ready.Checks["provider"]; check.Error != "expired token" {
+		t.Errorf("Expected the credential error in the provider check, got %+v", check)
+	}
+}
+
+func TestHandler_ServersDoNotShareRoutes(t *testing.T) {
+	// Each server registers its routes on a mux of its own, so two of them
+	// live side by side
+	first, second := newTestServer(t), newTestServer(t)
+	second.SetAPIKeys(apikey.NewKeyring(map[string]string{"ci": "ci-secret"}, nil))
+	for _, tc := range []struct {
+		server *Server
+		want   int
+	}{{first, http.StatusOK}, {second, http.StatusUnauthorized}} {
+		ts := httptest.NewServer(tc.server.Handler())
+		resp, err := http.Get(ts.URL + "/api/v1/instances")
+		ts.Close()
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("Expected %d, got %d", tc.want, resp.StatusCode)
+		}
+	}
+}
+
+func TestStart_Twice(t *testing.T) {
+	server := newTestServer(t) // Port 0 listens on any free port
+	started := make(chan error, 2)
+	go func() { started <- server.Start() }()
+	go func() { started <- server.Start() }()
+	time.Sleep(50 * time.Millisecond)
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-started; err != nil {
+			t.Errorf("Expected Start to return nil after Shutdown, got %v", err)
+		}
+	}
+}
+
+func TestStaticFiles(t *testing.T) {
+	server := newTestServer(t)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.handleStaticFiles(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	if rec := get("/"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `<script src="/js/app.js">`) {
+		t.Errorf("Expected the embedded index page, got %d", rec.Code)
+	}
+	if rec := get("/js/app.js"); !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/javascript") {
+		t.Errorf("Unexpected content type %q", rec.Header().Get("Content-Type"))
+	}
+	if rec := get("/login"); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "{{") {
+		t.Errorf("Expected the login page filled in, got %d", rec.Code)
+	}
+	for _, path := range []string{"/login.html", "/css", "/missing.png"} {
+		if rec := get(path); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s, got %d", path, rec.Code)
+		}
+	}
+
+	// Files in the static directory replace the embedded ones
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "css"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "css", "style.css"), []byte("body { color: teal; }"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "logo.svg"), []byte("<svg></svg>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.SetStaticDir(dir); err != nil {
+		t.Fatalf("SetStaticDir failed: %v", err)
+	}
+	if rec := get("/css/style.css"); rec.Body.String() != "body { color: teal; }" {
+		t.Errorf("Expected the overriding style sheet, got %q", rec.Body.String())
+	}
+	if rec := get("/logo.svg"); rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Errorf("Expected the added logo, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec := get("/js/app.js"); rec.Code != http.StatusOK {
+		t.Errorf("Expected the embedded script where the directory has none, got %d", rec.Code)
+	}
+	if rec := get("/../../etc/passwd"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected paths to stay inside the static directory, got %d", rec.Code)
+	}
+	if err := server.SetStaticDir(filepath.Join(dir, "logo.svg")); err == nil {
+		t.Error("Expected a file to be refused as the static directory")
+	}
+}
@@ -0,0 +1,528 @@
+var API_BASE = '/api/v1';
+var API_KEY_STORAGE = 'instance-manager-api-key';
+
+// ROLE is the role of the user, which decides the actions the UI offers;
+// the server enforces it either way
+var ROLE = 'admin';
+
+// apiFetch calls the API with the API key kept in the browser, asking for
+// one when the server requires a key and refuses the one it has. Servers
+// with user accounts send the browser to their login page instead.
+// failedRequestID is the ID of the last request the server refused, shown
+// with the next error so users can quote it when reporting a problem
+var failedRequestID = null;
+
+async function apiFetch(url, options) {
+    options = options || {};
+    for (let attempt = 0; attempt < 2; attempt++) {
+        const headers = Object.assign({}, options.headers);
+        const key = localStorage.getItem(API_KEY_STORAGE);
+        if (key) headers['Authorization'] = 'Bearer ' + key;
+        const response = await fetch(url, Object.assign({}, options, { headers: headers }));
+        if (!response.ok) failedRequestID = response.headers.get('X-Request-ID');
+        const challenge = response.headers.get('WWW-Authenticate') || '';
+        if (response.status !== 401 || !challenge.startsWith('Bearer') || attempt > 0) {
+            return response;
+        }
+        if (challenge.includes('login=')) {
+            window.location.href = '/login?next=' + encodeURIComponent(window.location.pathname + window.location.search);
+            return response;
+        }
+        // Another request may have asked for a key meanwhile
+        if (localStorage.getItem(API_KEY_STORAGE) === key) {
+            const entered = prompt('This server requires an API key. Enter your key:');
+            if (!entered) return response;
+            localStorage.setItem(API_KEY_STORAGE, entered.trim());
+        }
+    }
+}
+
+document.querySelectorAll('.tab-btn').forEach(btn => {
+    btn.addEventListener('click', function() {
+        const tab = this.dataset.tab;
+        switchTab(tab, this);
+    });
+});
+
+function switchTab(tab, btn) {
+    document.querySelectorAll('.tab-content').forEach(el => {
+        el.classList.remove('active');
+    });
+    document.querySelectorAll('.tab-btn').forEach(b => {
+        b.classList.remove('active');
+    });
+    document.getElementById(tab + '-tab').classList.add('active');
+    btn.classList.add('active');
+    if (tab === 'instances') {
+        refreshInstances();
+    }
+}
+
+// LIST is the page of the instance list shown, its filters and its order
+var LIST = {page: 1, perPage: 24, pages: 1, total: 0, state: '', owner: '', sort: '-expires_at'};
+
+async function refreshInstances() {
+    const params = new URLSearchParams({page: LIST.page, per_page: LIST.perPage, sort: LIST.sort});
+    if (LIST.state) params.set('state', LIST.state);
+    if (LIST.owner) params.set('owner', LIST.owner);
+    try {
+        const response = await apiFetch(API_BASE + '/instances?' + params);
+        const data = await response.json();
+        if (!data.success) {
+            showMessage('Error loading instances: ' + (data.error || 'unknown error'), 'error');
+            return;
+        }
+        INSTANCES = data.data || [];
+        if (data.pagination) {
+            LIST.pages = data.pagination.pages;
+            LIST.total = data.pagination.total;
+            // Step back when the last page emptied
+            if (INSTANCES.length === 0 && LIST.page > 1 && LIST.page > LIST.pages) {
+                LIST.page = Math.max(LIST.pages, 1);
+                return refreshInstances();
+            }
+        }
+        renderInstances();
+    } catch (error) {
+        showMessage('Failed to load instances: ' + error.message, 'error');
+    }
+}
+
+// changeListView applies the filters and order chosen, from the first page
+function changeListView() {
+    LIST.state = document.getElementById('list-state').value;
+    LIST.owner = document.getElementById('list-owner').value.trim();
+    LIST.sort = document.getElementById('list-sort').value;
+    LIST.page = 1;
+    refreshInstances();
+}
+
+function changeListPage(step) {
+    LIST.page = Math.min(Math.max(LIST.page + step, 1), Math.max(LIST.pages, 1));
+    refreshInstances();
+}
+
+function renderPager() {
+    document.getElementById('list-pager').classList.toggle('hidden', LIST.pages <= 1);
+    document.getElementById('list-page-info').textContent = 'Page ' + LIST.page + ' of ' + LIST.pages + ' (' + LIST.total + ' instances)';
+    document.getElementById('list-prev').disabled = LIST.page <= 1;
+    document.getElementById('list-next').disabled = LIST.page >= LIST.pages;
+}
+
+// INSTANCES are the instances on the page shown, in the order of LIST
+var INSTANCES = [];
+
+function renderInstances() {
+    const list = document.getElementById('instances-list');
+    renderPager();
+    if (INSTANCES.length === 0) {
+        list.innerHTML = LIST.state || LIST.owner
+            ? '<p class="empty">No instances match the filters.</p>'
+            : '<p class="empty">No instances running. Create one to get started!</p>';
+        return;
+    }
+    list.innerHTML = INSTANCES.map(instance => createInstanceCard(instance)).join('');
+    updateCountdowns();
+    // Keep the timelines that were open across refreshes
+    for (const instance of INSTANCES) {
+        if (openHistories.has(instance.id)) loadHistory(instance.id);
+    }
+}
+
+// liveSocket is the open connection to /api/v1/ws, which pushes every change to
+// the instances, or null while there is none and the list is polled
+var liveSocket = null;
+var liveRetryDelay = 1000;
+
+function connectLiveUpdates() {
+    const scheme = window.location.protocol === 'https:' ? 'wss://' : 'ws://';
+    const socket = new WebSocket(scheme + window.location.host + API_BASE + '/ws');
+    socket.onopen = () => {
+        liveSocket = socket;
+        liveRetryDelay = 1000;
+        // Catch up on the changes made while disconnected
+        refreshInstances();
+    };
+    socket.onmessage = (event) => applyLiveUpdate(JSON.parse(event.data));
+    socket.onclose = () => {
+        liveSocket = null;
+        setTimeout(connectLiveUpdates, liveRetryDelay);
+        liveRetryDelay = Math.min(liveRetryDelay * 2, 60000);
+    };
+}
+
+function applyLiveUpdate(update) {
+    const index = INSTANCES.findIndex(instance => instance.id === update.instance_id);
+    if (update.type !== 'deleted' && index >= 0 && staysInPlace(INSTANCES[index], update.instance)) {
+        // Replace the card alone, keeping its open timeline
+        INSTANCES[index] = update.instance;
+        const card = document.getElementById('instance-' + update.instance_id);
+        if (card) {
+            card.outerHTML = createInstanceCard(update.instance);
+            updateCountdowns();
+            if (openHistories.has(update.instance_id)) loadHistory(update.instance_id);
+            return;
+        }
+    }
+    // The change may move instances between pages, so the server lays them out
+    scheduleRefresh();
+}
+
+// staysInPlace reports whether an instance changed without leaving the
+// filters or moving in the order of the list
+function staysInPlace(before, after) {
+    const field = LIST.sort.replace(/^-/, '');
+    return before[field] === after[field]
+        && (!LIST.state || after.state === LIST.state)
+        && (!LIST.owner || after.owner === LIST.owner);
+}
+
+// scheduleRefresh reloads the list once a burst of changes settles
+var refreshTimer = null;
+
+function scheduleRefresh() {
+    clearTimeout(refreshTimer);
+    refreshTimer = setTimeout(refreshInstances, 300);
+}
+
+// updateCountdowns shows how long each instance has left before it expires
+function updateCountdowns() {
+    for (const element of document.querySelectorAll('.countdown')) {
+        const remaining = Math.floor((new Date(element.dataset.expiresAt) - new Date()) / 1000);
+        if (remaining <= 0) {
+            element.textContent = 'expired';
+            continue;
+        }
+        const hours = Math.floor(remaining / 3600);
+        const minutes = Math.floor(remaining % 3600 / 60);
+        const seconds = remaining % 60;
+        element.textContent = 'in ' + (hours > 0 ? hours + 'h ' : '') + (hours > 0 || minutes > 0 ? minutes + 'm ' : '') + seconds + 's';
+    }
+}
+
+function createInstanceCard(instance) {
+    const isExpired = new Date(instance.expires_at) < new Date() && !instance.in_grace_period;
+    const statusClass = isExpired || instance.in_grace_period ? 'expired' : (instance.state === 'running' ? 'running' : 'stopped');
+    const statusText = isExpired ? 'Expired' : (instance.in_grace_period ? 'Grace period' : instance.state);
+    let graceSection = '';
+    if (instance.stops_at && instance.stops_at !== instance.expires_at) {
+        graceSection = '<div class="instance-detail"><span class="instance-detail-label">Stops:</span><span class="instance-detail-value">' + new Date(instance.stops_at).toLocaleString() + (instance.in_grace_period ? ' unless extended' : '') + '</span></div>';
+    }
+    let cronSection = '';
+    if (instance.next_cron_stop) {
+        cronSection += '<div class="instance-detail"><span class="instance-detail-label">Scheduled stop:</span><span class="instance-detail-value" title="' + instance.stop_cron + '">' + new Date(instance.next_cron_stop).toLocaleString() + '</span></div>';
+    }
+    if (instance.next_cron_start) {
+        cronSection += '<div class="instance-detail"><span class="instance-detail-label">Scheduled start:</span><span class="instance-detail-value" title="' + instance.start_cron + '">' + new Date(instance.next_cron_start).toLocaleString() + '</span></div>';
+    }
+    let sshSection = '';
+    if (instance.public_ip) {
+        const sshCommand = instance.connection_command || (instance.username + '@' + instance.public_ip);
+        sshSection = '<div class="instance-detail"><span class="instance-detail-label">SSH:</span><span class="instance-detail-value">' + sshCommand + '</span></div>';
+    }
+    let gpuSection = '';
+    if (instance.gpu_count) {
+        gpuSection = '<div class="instance-detail"><span class="instance-detail-label">GPUs:</span><span class="instance-detail-value">' + instance.gpu_count + ' x ' + instance.gpu_model + '</span></div>';
+    }
+    return '<div class="instance-card" id="instance-' + instance.id + '">' +
+        '<div class="instance-id">' + (instance.name ? instance.name + ' (' + instance.id + ')' : instance.id) + '</div>' +
+        '<div class="instance-detail">' +
+        '<span class="instance-detail-label">Type:</span>' +
+        '<span class="instance-detail-value">' + instance.instance_type + '</span>' +
+        '</div>' +
+        gpuSection +
+        '<div class="instance-detail">' +
+        '<span class="instance-detail-label">Status:</span>' +
+        '<span class="status ' + statusClass + '">' + statusText + '</span>' +
+        '</div>' +
+        '<div class="instance-detail">' +
+        '<span class="instance-detail-label">IP:</span>' +
+        '<span class="instance-detail-value">' + (instance.public_ip || 'N/A') + '</span>' +
+        '</div>' +
+        '<div class="instance-detail">' +
+        '<span class="instance-detail-label">Zone:</span>' +
+        '<span class="instance-detail-value">' + instance.availability_zone + '</span>' +
+        '</div>' +
+        '<div class="instance-detail">' +
+        '<span class="instance-detail-label">Expires:</span>' +
+        '<span class="instance-detail-value">' + new Date(instance.expires_at).toLocaleString() + ' (<span class="countdown" data-expires-at="' + instance.expires_at + '"></span>)</span>' +
+        '</div>' +
+        graceSection +
+        cronSection +
+        sshSection +
+        '<div class="instance-actions">' +
+        (ROLE !== 'viewer' ?
+            '<button class="btn btn-info" onclick="showExtendDialog(\'' + instance.id + '\')">⏰ Extend</button>' +
+            '<button class="btn btn-danger" onclick="stopInstance(\'' + instance.id + '\')"' + (isExpired ? ' disabled title="Cannot stop an expired instance"' : '') + '>⛔ Stop</button>' : '') +
+        (ROLE === 'admin' ? '<button class="btn btn-danger" onclick="terminateInstance(\'' + instance.id + '\')">🗑️ Terminate</button>' : '') +
+        '<button class="btn btn-info" onclick="toggleHistory(\'' + instance.id + '\')">📜 History</button>' +
+        '</div>' +
+        '<ul class="timeline" id="history-' + instance.id + '" style="display: none"></ul>' +
+        '</div>';
+}
+
+document.getElementById('create-form').addEventListener('submit', async function(e) {
+    e.preventDefault();
+    const name = document.getElementById('name').value.trim();
+    const instanceType = document.getElementById('instance-type').value;
+    const duration = document.getElementById('duration').value;
+    const publicKey = document.getElementById('public-key').value;
+    const keyName = document.getElementById('key-name').value;
+    const availabilityZone = document.getElementById('availability-zone').value;
+    const provider = document.getElementById('provider').value;
+    const spot = document.getElementById('spot').checked;
+    const spotMaxPrice = spot ? document.getElementById('spot-max-price').value : '';
+    const tags = {};
+    for (const line of document.getElementById('tags').value.split('\n')) {
+        const trimmed = line.trim();
+        if (!trimmed) continue;
+        const eq = trimmed.indexOf('=');
+        if (eq < 0) {
+            showMessage('Error: invalid tag "' + trimmed + '" (expected key=value)', 'error');
+            return;
+        }
+        tags[trimmed.slice(0, eq).trim()] = trimmed.slice(eq + 1).trim();
+    }
+    try {
+        showMessage('Creating instance... Please wait', 'info');
+        const response = await apiFetch(API_BASE + '/instances', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({
+                name: name,
+                instance_type: instanceType,
+                duration: duration,
+                public_key_path: publicKey,
+                key_name: keyName,
+                availability_zone: availabilityZone,
+                provider: provider,
+                spot: spot,
+                spot_max_price: spotMaxPrice,
+                tags: tags,
+            }),
+        });
+        const data = await response.json();
+        if (!data.success) {
+            showMessage('Error: ' + data.error, 'error');
+            return;
+        }
+        showMessage('Instance created! ID: ' + data.data.id, 'success');
+        document.getElementById('create-form').reset();
+        loadProvider();
+
+        // Switch to instances tab immediately
+        document.querySelector('[data-tab="instances"]').click();
+
+        // Live updates show the new instance and its IP once assigned;
+        // without them, poll every 5 seconds for 2 minutes instead
+        refreshInstances();
+        if (!liveSocket) {
+            var refreshCount = 0;
+            var quickRefreshInterval = setInterval(() => {
+                refreshInstances();
+                refreshCount++;
+                if (refreshCount >= 24 || liveSocket) { // 24 * 5 seconds = 2 minutes
+                    clearInterval(quickRefreshInterval);
+                }
+            }, 5000);
+        }
+    } catch (error) {
+        showMessage('Failed to create instance: ' + error.message, 'error');
+    }
+});
+
+async function showExtendDialog(instanceId) {
+    const duration = prompt('Enter duration to extend (e.g., 1h, 30m):', '1h');
+    if (!duration) return;
+    try {
+        const response = await apiFetch(API_BASE + '/instances/' + encodeURIComponent(instanceId) + '/extend', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({
+                duration: duration,
+            }),
+        });
+        const data = await response.json();
+        if (!data.success) {
+            showMessage('Error: ' + data.error, 'error');
+            return;
+        }
+        showMessage('Instance TTL extended successfully!', 'success');
+        refreshInstances();
+    } catch (error) {
+        showMessage('Failed to extend instance: ' + error.message, 'error');
+    }
+}
+
+async function stopInstance(instanceId) {
+    if (!confirm('Are you sure you want to Stop this instance?')) return;
+    try {
+        const response = await apiFetch(API_BASE + '/instances/' + encodeURIComponent(instanceId) + '/stop', {
+            method: 'POST',
+        });
+        const data = await response.json();
+        if (!data.success) {
+            showMessage('Error: ' + data.error, 'error');
+            return;
+        }
+        showMessage('Instance Stopd successfully!', 'success');
+        refreshInstances();
+    } catch (error) {
+        showMessage('Failed to Stop instance: ' + error.message, 'error');
+    }
+}
+
+async function terminateInstance(instanceId) {
+    if (!confirm('Are you sure you want to TERMINATE this instance? This cannot be undone.')) return;
+    try {
+        const response = await apiFetch(API_BASE + '/instances/' + encodeURIComponent(instanceId), {
+            method: 'DELETE',
+        });
+        const data = await response.json();
+        if (!data.success) {
+            showMessage('Error: ' + data.error, 'error');
+            return;
+        }
+        showMessage('Instance terminated successfully!', 'success');
+        refreshInstances();
+    } catch (error) {
+        showMessage('Failed to terminate instance: ' + error.message, 'error');
+    }
+}
+
+// IDs of the instances whose history timeline is shown
+var openHistories = new Set();
+
+function toggleHistory(instanceId) {
+    if (openHistories.delete(instanceId)) {
+        document.getElementById('history-' + instanceId).style.display = 'none';
+        return;
+    }
+    openHistories.add(instanceId);
+    loadHistory(instanceId);
+}
+
+async function loadHistory(instanceId) {
+    const timeline = document.getElementById('history-' + instanceId);
+    try {
+        const response = await apiFetch(API_BASE + '/instances/' + encodeURIComponent(instanceId) + '/history');
+        const data = await response.json();
+        if (!data.success) {
+            showMessage('Error: ' + data.error, 'error');
+            return;
+        }
+        timeline.innerHTML = '';
+        if (data.data.length === 0) {
+            const item = document.createElement('li');
+            item.textContent = 'No events recorded';
+            timeline.appendChild(item);
+        }
+        for (const event of data.data) {
+            const item = document.createElement('li');
+            const time = document.createElement('span');
+            time.className = 'timeline-time';
+            time.textContent = new Date(event.time).toLocaleString();
+            item.appendChild(time);
+            let text = event.event;
+            if (event.actor) text += ' by ' + event.actor;
+            if (event.detail) text += ' (' + event.detail + ')';
+            item.appendChild(document.createTextNode(text));
+            timeline.appendChild(item);
+        }
+        timeline.style.display = '';
+    } catch (error) {
+        showMessage('Failed to load history: ' + error.message, 'error');
+    }
+}
+
+function showMessage(message, type) {
+    if (!type) type = 'info';
+    if (type === 'error' && failedRequestID) {
+        message += ' (request ' + failedRequestID + ')';
+        failedRequestID = null;
+    }
+    const msgEl = document.getElementById('message');
+    msgEl.textContent = message;
+    msgEl.className = 'message ' + type;
+    setTimeout(() => {
+        msgEl.classList.add('hidden');
+    }, 4000);
+}
+
+async function loadInstanceTypes() {
+    try {
+        const response = await apiFetch(API_BASE + '/instance-types');
+        const data = await response.json();
+        if (!data.success) {
+            return;
+        }
+        const types = data.data || [];
+        const select = document.getElementById('instance-type');
+        select.innerHTML = types.map(t => '<option value="' + t + '">' + t + '</option>').join('');
+    } catch (error) {
+        showMessage('Failed to load instance types: ' + error.message, 'error');
+    }
+}
+
+async function loadProvider() {
+    try {
+        const response = await apiFetch(API_BASE + '/health');
+        const data = await response.json();
+        if (data.success && data.data && data.data.provider) {
+            document.getElementById('provider').value = data.data.provider;
+        }
+    } catch (error) {
+        showMessage('Failed to load provider: ' + error.message, 'error');
+    }
+}
+
+async function loadSession() {
+    try {
+        const response = await apiFetch(API_BASE + '/auth/me');
+        const data = await response.json();
+        if (!data.success || !data.data) {
+            return;
+        }
+        ROLE = data.data.role || ROLE;
+        if (ROLE === 'viewer') {
+            document.querySelector('[data-tab="create"]').classList.add('hidden');
+        }
+        if (data.data.method === 'session') {
+            document.getElementById('session-user').textContent = data.data.user;
+            document.getElementById('session-role').textContent = ROLE;
+            document.getElementById('session').classList.remove('hidden');
+        }
+    } catch (error) {
+        // The session line stays hidden
+    }
+}
+
+async function signOut() {
+    await fetch(API_BASE + '/auth/logout', { method: 'POST' });
+    window.location.href = '/login';
+}
+
+window.addEventListener('load', async () => {
+    await loadSession();
+    loadProvider();
+    loadInstanceTypes();
+    refreshInstances();
+    connectLiveUpdates();
+
+    // Expiry warnings link to /?extend=<instance-id>
+    const extendID = new URLSearchParams(window.location.search).get('extend');
+    if (extendID) {
+        history.replaceState(null, '', window.location.pathname);
+        showExtendDialog(extendID);
+    }
+});
+
+// Live updates make polling unnecessary while connected
+setInterval(() => {
+    if (!liveSocket) refreshInstances();
+}, 30000);
+setInterval(updateCountdowns, 1000);
@@ -4,11 +4,19 @@
 package test
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"instance-manager/pkg/aws"
 	"instance-manager/pkg/config"
+	"instance-manager/pkg/models"
 )
 
 // TestAWSProviderIntegration tests the AWS provider with real AWS services
@@ -32,13 +40,13 @@ func TestAWSProviderIntegration(t *testing.T) {
 	}
 
 	// Test credential validation
-	err = provider.ValidateCredentials()
+	err = provider.ValidateCredentials(context.Background())
 	if err != nil {
 		t.Fatalf("Invalid AWS credentials: %v", err)
 	}
 
 	// Test listing instances (should not fail even if empty)
-	instances, err := provider.ListInstances()
+	instances, err := provider.ListInstances(context.Background())
 	if err != nil {
 		t.Fatalf("Failed to list instances: %v", err)
 	}
@@ -50,6 +58,100 @@ func TestAWSProviderIntegration(t *testing.T) {
 	// are sufficient to verify the integration works.
 }
 
+// TestLocalStackInstanceLifecycle creates, stops and terminates an instance
+// against LocalStack (or moto). It runs when AWS_ENDPOINT_URL points at the
+// emulator, e.g. via "make test-localstack".
+func TestLocalStackInstanceLifecycle(t *testing.T) {
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	if endpoint == "" {
+		t.Skip("Skipping LocalStack test: AWS_ENDPOINT_URL not set")
+	}
+
+	provider, err := aws.NewProviderWithOptions(aws.Options{
+		Region:    "us-east-1",
+		AccessKey: "test",
+		SecretKey: "test",
+		Endpoint:  endpoint,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create AWS provider: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	if err := provider.ValidateCredentials(ctx); err != nil {
+		t.Fatalf("Failed to reach LocalStack at %s: %v", endpoint, err)
+	}
+
+	instance, err := provider.CreateInstance(ctx, models.InstanceConfig{
+		InstanceType:  "t2.nano",
+		Duration:      time.Hour,
+		PublicKeyPath: writeTestPublicKey(t),
+		Region:        "us-east-1",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create instance: %v", err)
+	}
+	t.Logf("Created instance: %s", instance.ID)
+
+	instances, err := provider.ListInstances(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list instances: %v", err)
+	}
+	found := false
+	for _, listed := range instances {
+		found = found || listed.ID == instance.ID
+	}
+	if !found {
+		t.Errorf("Expected %s among the managed instances", instance.ID)
+	}
+
+	if err := provider.StopInstance(ctx, instance.ID); err != nil {
+		t.Fatalf("Failed to stop instance: %v", err)
+	}
+	status, err := provider.GetInstanceStatus(ctx, instance.ID)
+	if err != nil {
+		t.Fatalf("Failed to get instance status: %v", err)
+	}
+	if status.State != "stopping" && status.State != "stopped" {
+		t.Errorf("Expected a stopped instance, got %s", status.State)
+	}
+
+	if err := provider.TerminateInstance(ctx, instance.ID); err != nil {
+		t.Fatalf("Failed to terminate instance: %v", err)
+	}
+	status, err = provider.GetInstanceStatus(ctx, instance.ID)
+	if err != nil {
+		t.Fatalf("Failed to get instance status: %v", err)
+	}
+	if status.State != "shutting-down" && status.State != "terminated" {
+		t.Errorf("Expected a terminated instance, got %s", status.State)
+	}
+}
+
+// writeTestPublicKey writes a freshly generated ed25519 public key in
+// authorized_keys format and returns its path
+func writeTestPublicKey(t *testing.T) string {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	var blob []byte
+	for _, field := range [][]byte{[]byte("ssh-ed25519"), publicKey} {
+		blob = binary.BigEndian.AppendUint32(blob, uint32(len(field)))
+		blob = append(blob, field...)
+	}
+
+	path := filepath.Join(t.TempDir(), "test_key.pub")
+	line := "ssh-ed25519 " + base64.StdEncoding.EncodeToString(blob) + " test@example.com\n"
+	if err := os.WriteFile(path, []byte(line), 0644); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+	return path
+}
+
 // TestInstanceLifecycle would test the full instance lifecycle
 // but is commented out to avoid AWS charges during testing
 /*